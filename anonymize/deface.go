@@ -0,0 +1,126 @@
+// anonymize contains methods to remove identifying information from nifti1
+// images.
+
+package anonymize
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Identity is the 4x4 identity affine.
+var Identity = [4][4]float64{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+
+// ReadAffine reads a 4x4 matrix stored as four lines of four
+// whitespace-separated numbers. Blank lines and lines starting with '#' are
+// ignored.
+func ReadAffine(filename string) ([4][4]float64, error) {
+	var a [4][4]float64
+	f, err := os.Open(filename)
+	if err != nil {
+		return a, err
+	}
+	defer f.Close()
+
+	row := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if row >= 4 || len(fields) != 4 {
+			return a, fmt.Errorf("%s: expected 4 rows of 4 numbers", filename)
+		}
+		for j, s := range fields {
+			if a[row][j], err = strconv.ParseFloat(s, 64); err != nil {
+				return a, fmt.Errorf("%s: %v", filename, err)
+			}
+		}
+		row++
+	}
+	if err := sc.Err(); err != nil {
+		return a, err
+	}
+	if row != 4 {
+		return a, fmt.Errorf("%s: expected 4 rows of 4 numbers", filename)
+	}
+	return a, nil
+}
+
+// MaskToSubject maps a mask defined on a template grid to the grid of a
+// subject image using nearest-neighbour lookup. templateToSubject maps
+// template world coordinates to subject world coordinates; use Identity when
+// the mask is already in subject space.
+func MaskToSubject(mask *nifti1.Image, subject *nifti1.Image, templateToSubject [4][4]float64) ([]bool, error) {
+	subjectToTemplate, err := linalg.InvertAffine(templateToSubject)
+	if err != nil {
+		return nil, err
+	}
+	values, err := mask.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]bool, subject.Nx*subject.Ny*subject.Nz)
+	for k := 0; k < subject.Nz; k++ {
+		for j := 0; j < subject.Ny; j++ {
+			for i := 0; i < subject.Nx; i++ {
+				w := subject.VoxelToWorld(float64(i), float64(j), float64(k))
+				w = linalg.ApplyAffine(subjectToTemplate, w)
+				v := mask.WorldToVoxel(w[0], w[1], w[2])
+				mi, mj, mk := int(math.Round(v[0])), int(math.Round(v[1])), int(math.Round(v[2]))
+				if mi < 0 || mj < 0 || mk < 0 || mi >= mask.Nx || mj >= mask.Ny || mk >= mask.Nz {
+					continue
+				}
+				out[i+subject.Nx*(j+subject.Ny*k)] = values[mi+mask.Nx*(mj+mask.Ny*mk)] != 0
+			}
+		}
+	}
+	return out, nil
+}
+
+// Deface sets the raw value of every voxel inside the face mask to zero in
+// all volumes and returns the number of spatial voxels removed. The data are
+// modified in place; all-zero bytes are zero for every numeric datatype, so
+// the datatype and scaling are kept (removed voxels read as scl_inter). The
+// operation is recorded in the image provenance.
+func Deface(img *nifti1.Image, face []bool) (int, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	if len(face) != nxyz {
+		return 0, errors.New("face mask is not on the image grid")
+	}
+	if img.NByPer == 0 || len(img.Data) < img.NVox*img.NByPer {
+		return 0, errors.New("image has no data")
+	}
+
+	n := 0
+	for i, in := range face {
+		if !in {
+			continue
+		}
+		n++
+		for t := 0; t*nxyz < img.NVox; t++ {
+			off := (t*nxyz + i) * img.NByPer
+			for b := off; b < off+img.NByPer; b++ {
+				img.Data[b] = 0
+			}
+		}
+	}
+	img.DropChecksums()
+
+	err := img.AddProvenance(nifti1.ProvenanceRecord{
+		Operation:  "deface",
+		Parameters: map[string]string{"voxels": strconv.Itoa(n)},
+	})
+	return n, err
+}
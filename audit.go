@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kaczmarj/gonifti/derive"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// auditCommand and auditArgs are the command being run and its arguments,
+// set by main, which every entry of the audit log records.
+var (
+	auditCommand string
+	auditArgs    []string
+)
+
+// auditInputs are the files the command has read so far, which every
+// entry of the audit log records as inputs along with those it is given.
+var auditInputs struct {
+	sync.Mutex
+	names []string
+}
+
+// noteAuditInput adds a file that was read to auditInputs. It is the
+// util.ReadHook while there is an audit log.
+func noteAuditInput(name string) {
+	auditInputs.Lock()
+	auditInputs.names = append(auditInputs.names, name)
+	auditInputs.Unlock()
+}
+
+// auditRead adds files the command read other than through util, such as
+// tables and transforms, to auditInputs, so that the writes that follow
+// record them.
+func auditRead(names ...string) {
+	if cfg.AuditLog == "" {
+		return
+	}
+	for _, name := range names {
+		noteAuditInput(name)
+	}
+}
+
+// auditEntry is a line of the audit log: a write of one or more files by a
+// command, with the digests of the files it read and wrote.
+type auditEntry struct {
+	Time    time.Time   `json:"time"`
+	User    string      `json:"user"`
+	UID     int         `json:"uid"`
+	Host    string      `json:"host"`
+	PID     int         `json:"pid"`
+	Job     string      `json:"job,omitempty"`    // the job of gonifti daemon
+	Client  string      `json:"client,omitempty"` // the address that submitted the job
+	Command string      `json:"command"`
+	Args    []string    `json:"args"`
+	Cached  bool        `json:"cached,omitempty"` // the outputs were restored by -cache
+	Inputs  []auditFile `json:"inputs"`
+	Outputs []auditFile `json:"outputs"`
+}
+
+// auditFile is a file read or written, with its size and SHA-256 digest.
+// The digest of a directory, such as a Zarr store, is that of the lines
+// "<digest>  <path>" of its files, sorted by path, as printed by sha256sum.
+// Files that cannot be read, such as remote inputs, have no digest.
+type auditFile struct {
+	File   string `json:"file"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// audit is a write in progress, recorded to the audit log once it is done.
+type audit struct {
+	entry auditEntry
+}
+
+// beginAudit digests the inputs of a write before it replaces any of them,
+// and returns nil if there is no audit log or nothing is written, as with
+// -dry-run. The images and other files the command has read so far are
+// inputs too, so only files read otherwise, such as tables and transforms,
+// need be given.
+func beginAudit(inputs ...string) *audit {
+	if cfg.AuditLog == "" || util.DryRun {
+		return nil
+	}
+	e := auditEntry{
+		User:    auditUser(),
+		UID:     os.Getuid(),
+		PID:     os.Getpid(),
+		Job:     os.Getenv("GONIFTI_JOB"),
+		Client:  os.Getenv("GONIFTI_JOB_CLIENT"),
+		Command: auditCommand,
+		Args:    auditArgs,
+	}
+	e.Host, _ = os.Hostname()
+	auditInputs.Lock()
+	inputs = append(append([]string{}, auditInputs.names...), inputs...)
+	auditInputs.Unlock()
+	e.Inputs = auditFiles(inputs)
+	return &audit{entry: e}
+}
+
+// commit digests the outputs of a finished write and appends the entry to
+// the audit log. A write that cannot be recorded fails the command, since
+// it cannot be traced.
+func (a *audit) commit(outputs ...string) error {
+	if a == nil {
+		return nil
+	}
+	a.entry.Outputs = auditFiles(outputs)
+	a.entry.Time = time.Now().UTC()
+	b, err := json.Marshal(a.entry)
+	if err != nil {
+		return err
+	}
+	// Appends of one line in one write do not interleave with those of
+	// other processes, such as the jobs of a daemon, on local file systems.
+	f, err := os.OpenFile(cfg.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err == nil {
+		_, err = f.Write(append(b, '\n'))
+		if err == nil && util.SyncWrites {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("wrote %s but could not record it in the audit log: %v", outputs, err)
+	}
+	return nil
+}
+
+// auditUser returns the name of the user running gonifti, or its uid if
+// it has no name, as in containers without /etc/passwd.
+func auditUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return strconv.Itoa(os.Getuid())
+}
+
+// auditFiles digests files, with both files of NIfTI pairs, once each.
+func auditFiles(names []string) []auditFile {
+	out := []auditFile{}
+	seen := map[string]bool{}
+	for _, name := range expandPairs(names) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			out = append(out, auditDigest(name))
+		}
+	}
+	return out
+}
+
+// auditDigest returns the size and digest of a file or directory.
+func auditDigest(name string) auditFile {
+	af := auditFile{File: name}
+	fi, err := os.Stat(name)
+	switch {
+	case err != nil:
+	case fi.Mode().IsRegular():
+		if digest, err := derive.FileDigest(name); err == nil {
+			af.Size, af.SHA256 = fi.Size(), digest
+		}
+	case fi.IsDir():
+		h := sha256.New()
+		err := filepath.Walk(name, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() {
+				return err
+			}
+			digest, err := derive.FileDigest(path)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(name, path)
+			fmt.Fprintf(h, "%s  %s\n", digest, filepath.ToSlash(rel))
+			af.Size += fi.Size()
+			return nil
+		})
+		if err == nil {
+			af.SHA256 = hex.EncodeToString(h.Sum(nil))
+		} else {
+			af.Size = 0
+		}
+	}
+	return af
+}
@@ -0,0 +1,316 @@
+// augment contains random data augmentation for machine learning: flips,
+// small rotations, and elastic deformation, applied alike to an image and
+// its labels, and bias field, gamma, and intensity jitter, applied to the
+// image alone. Every transform draws from a seeded source, so an
+// augmentation can be reproduced.
+
+package augment
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Sample is an image to augment, with optional labels on the same grid.
+type Sample struct {
+	// Values holds the volumes of the image, nxyz voxels each, stored with
+	// i fastest.
+	Values []float64
+	// Labels holds one volume of labels, or is nil.
+	Labels []float64
+	// Dims and VoxelSize (mm) describe the grid.
+	Dims      [3]int
+	VoxelSize [3]float64
+}
+
+// FromImage returns the scaled values of img, and of labels if not nil, as
+// a sample.
+func FromImage(img, labels *nifti1.Image) (*Sample, error) {
+	s := &Sample{Dims: [3]int{img.Nx, img.Ny, img.Nz}, VoxelSize: img.VoxelSizeMM()}
+	var err error
+	if s.Values, err = img.ScaledFloat64s(); err != nil {
+		return nil, err
+	}
+	if labels != nil {
+		if !nifti1.SameGrid(img, labels) {
+			return nil, fmt.Errorf("image and labels: %w", nifti1.ErrGridMismatch)
+		}
+		all, err := labels.ScaledFloat64s()
+		if err != nil {
+			return nil, err
+		}
+		s.Labels = all[:s.nxyz()]
+	}
+	return s, nil
+}
+
+func (s *Sample) nxyz() int {
+	return s.Dims[0] * s.Dims[1] * s.Dims[2]
+}
+
+// Transform is one random augmentation.
+type Transform interface {
+	// Apply changes the sample in place, drawing from rng.
+	Apply(s *Sample, rng *rand.Rand) error
+}
+
+// Pipeline applies transforms in order.
+type Pipeline []Transform
+
+// Apply applies the transforms to s with a source seeded by seed.
+func (p Pipeline) Apply(s *Sample, seed int64) error {
+	if len(s.Values)%s.nxyz() != 0 || (s.Labels != nil && len(s.Labels) != s.nxyz()) {
+		return fmt.Errorf("sample holds %d values and %d labels for %v voxels", len(s.Values), len(s.Labels), s.Dims)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for _, t := range p {
+		if err := t.Apply(s, rng); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flip reverses each axis whose flag is set with probability P.
+type Flip struct {
+	Axes [3]bool
+	P    float64
+}
+
+// Apply flips the sample.
+func (f Flip) Apply(s *Sample, rng *rand.Rand) error {
+	for a := 0; a < 3; a++ {
+		if !f.Axes[a] || rng.Float64() >= f.P {
+			continue
+		}
+		s.Values = flip(s.Values, s.Dims, a)
+		if s.Labels != nil {
+			s.Labels = flip(s.Labels, s.Dims, a)
+		}
+	}
+	return nil
+}
+
+// flip reverses axis a of every volume of values.
+func flip(values []float64, dims [3]int, a int) []float64 {
+	out := make([]float64, len(values))
+	stride := [3]int{1, dims[0], dims[0] * dims[1]}
+	n := dims[a]
+	for m := range values {
+		c := m / stride[a] % n
+		out[m+(n-1-2*c)*stride[a]] = values[m]
+	}
+	return out
+}
+
+// Rotate rotates the sample about the center of the grid by up to
+// MaxDegrees about each axis, interpolating values with Method (labels use
+// nearest neighbour). Voxels rotated in from outside the grid are 0.
+type Rotate struct {
+	MaxDegrees float64
+	Method     string
+}
+
+// Apply rotates the sample.
+func (r Rotate) Apply(s *Sample, rng *rand.Rand) error {
+	var angle [3]float64
+	for a := range angle {
+		angle[a] = r.MaxDegrees * math.Pi / 180 * (2*rng.Float64() - 1)
+	}
+	rot := rotation(angle)
+	c := s.center()
+	return s.warp(r.Method, func(p [3]float64) [3]float64 {
+		// Rotate in mm about the center, mapping output voxels to input.
+		var d, q [3]float64
+		for a := range d {
+			d[a] = (p[a] - c[a]) * s.VoxelSize[a]
+		}
+		for a := range q {
+			x := rot[0][a]*d[0] + rot[1][a]*d[1] + rot[2][a]*d[2]
+			q[a] = c[a] + x/s.VoxelSize[a]
+		}
+		return q
+	})
+}
+
+// rotation returns the matrix of rotations about x, then y, then z.
+func rotation(angle [3]float64) [3][3]float64 {
+	cx, sx := math.Cos(angle[0]), math.Sin(angle[0])
+	cy, sy := math.Cos(angle[1]), math.Sin(angle[1])
+	cz, sz := math.Cos(angle[2]), math.Sin(angle[2])
+	return [3][3]float64{
+		{cz * cy, cz*sy*sx - sz*cx, cz*sy*cx + sz*sx},
+		{sz * cy, sz*sy*sx + cz*cx, sz*sy*cx - cz*sx},
+		{-sy, cy * sx, cy * cx},
+	}
+}
+
+// Elastic displaces voxels by a smooth random field: displacements drawn
+// with a standard deviation of Alpha mm at control points Spacing mm
+// apart, interpolated by cubic B-splines. Values are interpolated with
+// Method and labels by nearest neighbour.
+type Elastic struct {
+	Alpha, Spacing float64
+	Method         string
+}
+
+// Apply deforms the sample.
+func (e Elastic) Apply(s *Sample, rng *rand.Rand) error {
+	if e.Spacing <= 0 {
+		return fmt.Errorf("invalid elastic control point spacing %g mm", e.Spacing)
+	}
+	var ctrl [3]int
+	var scale [3]float64
+	for a := 0; a < 3; a++ {
+		extent := float64(s.Dims[a]-1) * s.VoxelSize[a]
+		ctrl[a] = int(math.Ceil(extent/e.Spacing)) + 1
+		if ctrl[a] < 2 {
+			ctrl[a] = 2
+		}
+		scale[a] = float64(ctrl[a]-1) / math.Max(float64(s.Dims[a]-1), 1)
+	}
+	n := ctrl[0] * ctrl[1] * ctrl[2]
+	var field [3]interp.Interpolator
+	for a := 0; a < 3; a++ {
+		d := make([]float64, n)
+		for m := range d {
+			d[m] = e.Alpha * rng.NormFloat64() / s.VoxelSize[a]
+		}
+		var err error
+		if field[a], err = interp.New(d, ctrl, interp.Options{Method: interp.Cubic, Boundary: interp.Mirror}); err != nil {
+			return err
+		}
+	}
+	return s.warp(e.Method, func(p [3]float64) [3]float64 {
+		var c [3]float64
+		for a := range c {
+			c[a] = p[a] * scale[a]
+		}
+		for a := range p {
+			d, _ := field[a].Sample(c)
+			p[a] += d
+		}
+		return p
+	})
+}
+
+// center returns the voxel index of the center of the grid.
+func (s *Sample) center() [3]float64 {
+	return [3]float64{float64(s.Dims[0]-1) / 2, float64(s.Dims[1]-1) / 2, float64(s.Dims[2]-1) / 2}
+}
+
+// warp resamples the sample so that output voxel p takes the value at
+// input voxel index fn(p).
+func (s *Sample) warp(method string, fn func(p [3]float64) [3]float64) error {
+	nxyz := s.nxyz()
+	src := make([][3]float64, nxyz)
+	for m := range src {
+		src[m] = fn([3]float64{float64(m % s.Dims[0]), float64(m / s.Dims[0] % s.Dims[1]), float64(m / (s.Dims[0] * s.Dims[1]))})
+	}
+	resample := func(values []float64, o interp.Options) ([]float64, error) {
+		out := make([]float64, len(values))
+		for t := 0; t < len(values)/nxyz; t++ {
+			in, err := interp.New(values[t*nxyz:(t+1)*nxyz], s.Dims, o)
+			if err != nil {
+				return nil, err
+			}
+			for m, p := range src {
+				out[t*nxyz+m], _ = in.Sample(p)
+			}
+		}
+		return out, nil
+	}
+	var err error
+	if s.Values, err = resample(s.Values, interp.Options{Method: method}); err != nil {
+		return err
+	}
+	if s.Labels != nil {
+		if s.Labels, err = resample(s.Labels, interp.Options{Method: interp.Nearest}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BiasField multiplies the values by a smooth field, the exponential of a
+// random polynomial of degree 2 in the voxel coordinates scaled to [-1, 1]
+// whose coefficients are at most Strength in magnitude.
+type BiasField struct {
+	Strength float64
+}
+
+// Apply multiplies the values by the field.
+func (b BiasField) Apply(s *Sample, rng *rand.Rand) error {
+	var coef [10]float64
+	for i := range coef {
+		coef[i] = b.Strength * (2*rng.Float64() - 1)
+	}
+	nxyz := s.nxyz()
+	c := s.center()
+	for m := 0; m < nxyz; m++ {
+		var x [3]float64
+		for a, i := range [3]int{m % s.Dims[0], m / s.Dims[0] % s.Dims[1], m / (s.Dims[0] * s.Dims[1])} {
+			if c[a] > 0 {
+				x[a] = (float64(i) - c[a]) / c[a]
+			}
+		}
+		terms := [10]float64{1, x[0], x[1], x[2], x[0] * x[0], x[1] * x[1], x[2] * x[2], x[0] * x[1], x[0] * x[2], x[1] * x[2]}
+		e := 0.0
+		for i, t := range terms {
+			e += coef[i] * t
+		}
+		f := math.Exp(e)
+		for v := m; v < len(s.Values); v += nxyz {
+			s.Values[v] *= f
+		}
+	}
+	return nil
+}
+
+// Gamma raises the values, rescaled to [0, 1], to a power between
+// exp(-MaxLog) and exp(MaxLog), and scales them back.
+type Gamma struct {
+	MaxLog float64
+}
+
+// Apply applies the gamma curve.
+func (g Gamma) Apply(s *Sample, rng *rand.Rand) error {
+	gamma := math.Exp(g.MaxLog * (2*rng.Float64() - 1))
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range s.Values {
+		if !math.IsNaN(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if !(hi > lo) {
+		return nil
+	}
+	for i, v := range s.Values {
+		s.Values[i] = lo + (hi-lo)*math.Pow((v-lo)/(hi-lo), gamma)
+	}
+	return nil
+}
+
+// Intensity scales the values by a factor within Scale of 1, shifts them
+// by up to Shift, and adds Gaussian noise of standard deviation Noise, all
+// in the units of the values.
+type Intensity struct {
+	Scale, Shift, Noise float64
+}
+
+// Apply jitters the values.
+func (j Intensity) Apply(s *Sample, rng *rand.Rand) error {
+	scale := 1 + j.Scale*(2*rng.Float64()-1)
+	shift := j.Shift * (2*rng.Float64() - 1)
+	for i, v := range s.Values {
+		s.Values[i] = scale*v + shift
+		if j.Noise > 0 {
+			s.Values[i] += j.Noise * rng.NormFloat64()
+		}
+	}
+	return nil
+}
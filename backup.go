@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// backupSuffix is appended to the name of an image for the header and
+// extensions saved by -backup.
+const backupSuffix = ".header-backup"
+
+// backup is set by the -backup flag of commands that modify headers.
+// writeImage then saves the header and extensions of an image it
+// overwrites in place, for gonifti undo.
+var backup bool
+
+// addBackupFlag registers -backup on fs.
+func addBackupFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&backup, "backup", false,
+		"when the output is the input, first save its header and extensions to <header>"+backupSuffix+" for gonifti undo")
+}
+
+// sameFile reports whether the names a and b refer to the same existing
+// file.
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// headerName returns the name of the file holding the header of filename.
+func headerName(filename string) string {
+	if nifti1.FileTypeFromName(filename) == nifti1.FileTypeNifti1Pair {
+		hdr, _ := nifti1.PairFilenames(filename)
+		return hdr
+	}
+	return filename
+}
+
+// saveHeaderBackup saves the bytes before the voxel data of the image
+// filename, its header and extensions, to its backup file. An existing
+// backup is kept, so that undo goes back to the header before the first
+// change.
+func saveHeaderBackup(filename string) error {
+	hdrName := headerName(filename)
+	name := hdrName + backupSuffix
+	if _, err := os.Stat(name); err == nil {
+		log.WithFields(log.Fields{
+			"backup": name,
+		}).Info("Keeping the existing backup of the header")
+		return nil
+	}
+	b, err := util.ReadBytes(hdrName)
+	if err != nil {
+		return nifti1.GzipError(hdrName, err)
+	}
+	h, _, err := nifti1.DecodeHeader(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", hdrName, err)
+	}
+	if n := int(h.VoxOffset); hdrName == filename && n < len(b) {
+		b = b[:n]
+	}
+	a := beginAudit(hdrName)
+	if err := util.WriteFileAtomic(name, b, 0644); err != nil {
+		return err
+	}
+	if err := a.commit(name); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"bytes":  len(b),
+		"backup": name,
+	}).Info("Saved header")
+	return nil
+}
+
+// runUndo restores the header and extensions saved by -backup.
+func runUndo(args []string) error {
+	fs := newFlagSet("undo")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti undo <file>")
+		fmt.Fprintln(fs.Output(), "Restores the header and extensions of an image from <header>"+backupSuffix+",")
+		fmt.Fprintln(fs.Output(), "saved by the -backup flag of check, origin, or ext, and removes the backup.")
+		fmt.Fprintln(fs.Output(), "The voxel data are kept as they are.")
+		fs.PrintDefaults()
+	}
+	addDryRunFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("undo requires a file")
+	}
+	filename := fs.Arg(0)
+	hdrName := headerName(filename)
+	name := hdrName + backupSuffix
+	saved, err := util.ReadBytes(name)
+	if err != nil {
+		return err
+	}
+	h, _, err := nifti1.DecodeHeader(saved)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	size, _ := nifti1.DatatypeSize(int(h.DataType))
+	nvox := 1
+	for i := 1; i <= int(h.Dim[0]) && i < len(h.Dim); i++ {
+		if h.Dim[i] > 0 {
+			nvox *= int(h.Dim[i])
+		}
+	}
+
+	b := saved
+	if hdrName == filename {
+		current, err := util.ReadBytes(filename)
+		if err != nil {
+			return nifti1.GzipError(filename, err)
+		}
+		ch, _, err := nifti1.DecodeHeader(current)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		var data []byte
+		if off := int(ch.VoxOffset); off < len(current) {
+			data = current[off:]
+		}
+		if len(data) < nvox*size {
+			return fmt.Errorf("%s: %w: the saved header needs %d bytes of data, but the file has %d",
+				filename, nifti1.ErrTruncated, nvox*size, len(data))
+		}
+		b = append(append(make([]byte, 0, len(saved)+len(data)), saved...), data...)
+	}
+	a := beginAudit(filename, name)
+	if err := util.WriteBytesLevel(hdrName, b, cfg.CompressionLevel); err != nil {
+		return err
+	}
+	if util.DryRun {
+		fmt.Printf("would remove %s\n", name)
+		return nil
+	}
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	if err := a.commit(hdrName); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"file":   hdrName,
+		"backup": name,
+	}).Info("Restored header")
+	return nil
+}
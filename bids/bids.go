@@ -0,0 +1,214 @@
+// bids contains methods to index and query the NIfTI images of a BIDS
+// dataset.
+// https://bids-specification.readthedocs.io
+
+package bids
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Common entity keys, as they appear in filenames.
+const (
+	Subject     = "sub"
+	Session     = "ses"
+	Task        = "task"
+	Acquisition = "acq"
+	Run         = "run"
+	Echo        = "echo"
+)
+
+// File is an image in a BIDS dataset. It is opened only when Open is
+// called.
+type File struct {
+	Path      string            // path of the image
+	Entities  map[string]string // key-value pairs of the filename, e.g. "sub": "01"
+	Suffix    string            // e.g. "T1w" or "bold"
+	Datatype  string            // parent directory, e.g. "anat" or "func"
+	Extension string            // ".nii" or ".nii.gz"
+}
+
+// Open reads the image.
+func (f *File) Open(opts ...nifti1.ReadOption) (*nifti1.Image, error) {
+	return nifti1.ReadFile(f.Path, opts...)
+}
+
+// Sidecar returns the path of the JSON sidecar next to the image, if it
+// exists. Inherited sidecars higher in the tree are not searched.
+func (f *File) Sidecar() (string, bool) {
+	p := strings.TrimSuffix(f.Path, f.Extension) + ".json"
+	if _, err := os.Stat(p); err != nil {
+		return "", false
+	}
+	return p, true
+}
+
+// ParseFilename splits a BIDS filename such as
+// "sub-01_ses-1_task-rest_run-2_bold.nii.gz" into its entities, suffix, and
+// extension. It reports false if the name does not follow the pattern.
+func ParseFilename(name string) (entities map[string]string, suffix, ext string, ok bool) {
+	name = filepath.Base(name)
+	switch {
+	case strings.HasSuffix(name, ".nii.gz"):
+		ext = ".nii.gz"
+	case strings.HasSuffix(name, ".nii"):
+		ext = ".nii"
+	default:
+		return nil, "", "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ext), "_")
+	if len(parts) < 2 {
+		return nil, "", "", false
+	}
+
+	entities = make(map[string]string, len(parts)-1)
+	for _, p := range parts[:len(parts)-1] {
+		kv := strings.SplitN(p, "-", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, "", "", false
+		}
+		entities[kv[0]] = kv[1]
+	}
+	if _, has := entities[Subject]; !has {
+		return nil, "", "", false
+	}
+	suffix = parts[len(parts)-1]
+	if strings.Contains(suffix, "-") {
+		return nil, "", "", false
+	}
+	return entities, suffix, ext, true
+}
+
+// Dataset is an index of the images of a BIDS dataset.
+type Dataset struct {
+	Root  string
+	Files []*File
+}
+
+// Index walks a BIDS dataset and indexes its NIfTI images. The derivatives,
+// sourcedata, and code directories, and hidden directories, are skipped.
+func Index(root string) (*Dataset, error) {
+	if _, err := os.Stat(filepath.Join(root, "dataset_description.json")); err != nil {
+		log.WithFields(log.Fields{
+			"root": root,
+		}).Warn("No dataset_description.json; this may not be a BIDS dataset")
+	}
+
+	d := &Dataset{Root: root}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != root && (strings.HasPrefix(name, ".") || name == "derivatives" || name == "sourcedata" || name == "code") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entities, suffix, ext, ok := ParseFilename(path)
+		if !ok {
+			return nil
+		}
+		d.Files = append(d.Files, &File{
+			Path:      path,
+			Entities:  entities,
+			Suffix:    suffix,
+			Datatype:  filepath.Base(filepath.Dir(path)),
+			Extension: ext,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"root":   root,
+		"images": len(d.Files),
+	}).Debug("Indexed BIDS dataset")
+
+	return d, nil
+}
+
+// Query selects files. Empty fields match anything; Entities must all
+// match.
+type Query struct {
+	Entities map[string]string
+	Suffix   string
+	Datatype string
+}
+
+// Match reports whether a file satisfies the query.
+func (q Query) Match(f *File) bool {
+	if q.Suffix != "" && q.Suffix != f.Suffix {
+		return false
+	}
+	if q.Datatype != "" && q.Datatype != f.Datatype {
+		return false
+	}
+	for k, v := range q.Entities {
+		if f.Entities[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns the files matching a query, sorted by path.
+func (d *Dataset) Find(q Query) []*File {
+	var out []*File
+	for _, f := range d.Files {
+		if q.Match(f) {
+			out = append(out, f)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// Values returns the distinct values of an entity, sorted, e.g. all
+// subjects with Values(Subject).
+func (d *Dataset) Values(entity string) []string {
+	seen := map[string]bool{}
+	for _, f := range d.Files {
+		if v, ok := f.Entities[entity]; ok {
+			seen[v] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for v := range seen {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseQuery parses a query written as space- or comma-separated
+// "key=value" terms, where key is an entity, "suffix", or "datatype", e.g.
+// "sub=01 ses=2 suffix=T1w".
+func ParseQuery(s string) (Query, error) {
+	q := Query{Entities: map[string]string{}}
+	for _, term := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return q, fmt.Errorf("invalid query term %q", term)
+		}
+		switch kv[0] {
+		case "suffix":
+			q.Suffix = kv[1]
+		case "datatype":
+			q.Datatype = kv[1]
+		default:
+			q.Entities[kv[0]] = kv[1]
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,48 @@
+package bids
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// RoundSliceTiming rounds slice times to microseconds, so that they are
+// written as 0.3 rather than 0.30000000000000004.
+func RoundSliceTiming(times []float64) []float64 {
+	out := make([]float64, len(times))
+	for i, t := range times {
+		out[i] = math.Round(t*1e6) / 1e6
+	}
+	return out
+}
+
+// WriteSliceTiming sets SliceTiming, rounded with RoundSliceTiming, and
+// MultibandAccelerationFactor, unless multiband is 1 or less, in the JSON
+// sidecar name, keeping its other fields. The sidecar is created if it
+// does not exist.
+func WriteSliceTiming(name string, times []float64, multiband int) error {
+	fields := map[string]json.RawMessage{}
+	b, err := ioutil.ReadFile(name)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	if fields["SliceTiming"], err = json.Marshal(RoundSliceTiming(times)); err != nil {
+		return err
+	}
+	delete(fields, "MultibandAccelerationFactor")
+	if multiband > 1 {
+		fields["MultibandAccelerationFactor"], _ = json.Marshal(multiband)
+	}
+	if b, err = json.MarshalIndent(fields, "", "  "); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(name, append(b, '\n'), 0644)
+}
@@ -0,0 +1,71 @@
+package bids
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// NA is the value of missing cells in BIDS tables.
+const NA = "n/a"
+
+// Table is a tab-separated table with a header row, such as events.tsv or
+// a confounds file.
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// ReadTSV reads a table. Every row must have as many cells as the header.
+func ReadTSV(name string) (*Table, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no header row", name)
+	}
+	return &Table{Columns: records[0], Rows: records[1:]}, nil
+}
+
+// Column returns the index of a column, or -1.
+func (t *Table) Column(name string) int {
+	for i, c := range t.Columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Float returns the cells of a column as numbers, with missing ("n/a")
+// cells as NaN.
+func (t *Table) Float(name string) ([]float64, error) {
+	j := t.Column(name)
+	if j < 0 {
+		return nil, fmt.Errorf("no column %q", name)
+	}
+	values := make([]float64, len(t.Rows))
+	for i, row := range t.Rows {
+		if row[j] == NA {
+			values[i] = math.NaN()
+			continue
+		}
+		x, err := strconv.ParseFloat(row[j], 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %q, row %d: invalid number %q", name, i+1, row[j])
+		}
+		values[i] = x
+	}
+	return values, nil
+}
@@ -0,0 +1,358 @@
+// brainvoyager contains readers of BrainVoyager anatomical (VMR) and
+// functional (FMR with STC data) volumes. The geometry is best effort:
+// BrainVoyager's own coordinate conventions are mapped to RAS, but
+// transformations recorded in the files are not applied.
+
+package brainvoyager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// IsVMR reports whether name has the extension of a VMR file.
+func IsVMR(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".vmr")
+}
+
+// IsFMR reports whether name has the extension of an FMR file.
+func IsFMR(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".fmr")
+}
+
+// internalAffine returns the affine of BrainVoyager's internal axes, which
+// run anterior to posterior, superior to inferior, and right to left with
+// the first fastest, centered on the middle of the grid.
+func internalAffine(dims [3]int, res [3]float64) [4][4]float64 {
+	var a [4][4]float64
+	a[1][0] = -res[0] // x: A -> P
+	a[2][1] = -res[1] // y: S -> I
+	a[0][2] = -res[2] // z: R -> L
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			a[i][3] -= a[i][j] * float64(dims[j]) / 2
+		}
+	}
+	a[3][3] = 1
+	return a
+}
+
+// vmrReader reads little-endian fields of a VMR file.
+type vmrReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *vmrReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if r.pos+n > len(r.b) {
+		r.err = fmt.Errorf("%w VMR header", nifti1.ErrTruncated)
+		return make([]byte, n)
+	}
+	r.pos += n
+	return r.b[r.pos-n : r.pos]
+}
+
+func (r *vmrReader) u8() int {
+	return int(r.next(1)[0])
+}
+
+func (r *vmrReader) i32() int {
+	return int(int32(binary.LittleEndian.Uint32(r.next(4))))
+}
+
+func (r *vmrReader) f32() float64 {
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(r.next(4))))
+}
+
+func (r *vmrReader) skip(n int) {
+	r.next(n)
+}
+
+// skipString skips a null-terminated string.
+func (r *vmrReader) skipString() {
+	if r.err != nil {
+		return
+	}
+	i := bytes.IndexByte(r.b[r.pos:], 0)
+	if i < 0 {
+		r.err = fmt.Errorf("%w VMR header", nifti1.ErrTruncated)
+		return
+	}
+	r.pos += i + 1
+}
+
+// ReadVMR reads a VMR file, version 1 (no version field) or later, as a
+// uint8 image. The voxel resolution of version 3 and later files is used;
+// earlier files are 1 mm.
+func ReadVMR(name string) (*nifti1.Image, error) {
+	return ReadVMRFS(util.Local, name)
+}
+
+// ReadVMRFS is ReadVMR for a file of fsys.
+func ReadVMRFS(fsys fs.FS, name string) (*nifti1.Image, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%s: %w VMR header", name, nifti1.ErrTruncated)
+	}
+	le := binary.LittleEndian
+	version := 0
+	dims := [3]int{int(le.Uint16(b)), int(le.Uint16(b[2:])), int(le.Uint16(b[4:]))}
+	offset := 6
+	if len(b) != 6+dims[0]*dims[1]*dims[2] {
+		version = int(le.Uint16(b))
+		dims = [3]int{int(le.Uint16(b[2:])), int(le.Uint16(b[4:])), int(le.Uint16(b[6:]))}
+		offset = 8
+	}
+	n := dims[0] * dims[1] * dims[2]
+	if n == 0 {
+		return nil, fmt.Errorf("%s: %w: VMR size %v", name, nifti1.ErrInvalidHeader, dims)
+	}
+	if offset+n > len(b) {
+		return nil, fmt.Errorf("%s: %w VMR data", name, nifti1.ErrTruncated)
+	}
+
+	res := [3]float64{1, 1, 1}
+	if version >= 3 {
+		r := &vmrReader{b: b, pos: offset + n}
+		r.skip(2 * 4)  // offsets and framing cube
+		r.skip(4 * 2)  // position information flag and coordinate system
+		r.skip(4 * 12) // slice centers and row and column directions
+		r.skip(4 * 2)  // rows and columns
+		r.skip(4 * 4)  // field of view, slice and gap thickness
+		for i, nt := 0, r.i32(); i < nt && r.err == nil; i++ {
+			r.skipString() // name
+			r.i32()        // type
+			r.skipString() // source file
+			r.skip(4 * r.i32())
+		}
+		r.u8() // left-right convention
+		r.u8() // reference space
+		res = [3]float64{r.f32(), r.f32(), r.f32()}
+		if r.err != nil || res[0] <= 0 || res[1] <= 0 || res[2] <= 0 {
+			log.WithFields(log.Fields{
+				"file":    name,
+				"version": version,
+			}).Warn("Could not read the VMR voxel resolution, using 1 mm")
+			res = [3]float64{1, 1, 1}
+		}
+	}
+
+	img, err := nifti1.NewImage(nifti1.DTUint8, dims[:], internalAffine(dims, res), nifti1.XformScannerAnat)
+	if err != nil {
+		return nil, err
+	}
+	copy(img.Data, b[offset:offset+n])
+
+	log.WithFields(log.Fields{
+		"file":       name,
+		"version":    version,
+		"dims":       dims,
+		"resolution": res,
+	}).Debug("Read VMR")
+
+	return img, nil
+}
+
+// readFMRHeader reads the "Key: value" lines of an FMR file. Values in
+// quotes are unquoted.
+func readFMRHeader(fsys fs.FS, name string) (map[string]string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := map[string]string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if _, ok := h[key]; !ok {
+			h[key] = strings.Trim(value, `"`)
+		}
+	}
+	return h, s.Err()
+}
+
+// ReadFMR reads an FMR file and its STC data, stored as separate files per
+// slice (DataStorageFormat 1), or in one file ordered by slice (2) or by
+// volume (3), as an image with one volume per time point. If the FMR has
+// position information, it is used as DICOM patient coordinates; otherwise
+// the grid is axis-aligned and centered.
+func ReadFMR(name string) (*nifti1.Image, error) {
+	return ReadFMRFS(util.Local, name)
+}
+
+// ReadFMRFS is ReadFMR for files of fsys.
+func ReadFMRFS(fsys fs.FS, name string) (*nifti1.Image, error) {
+	h, err := readFMRHeader(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	num := func(key string, def float64) float64 {
+		if v, err := strconv.ParseFloat(h[key], 64); err == nil {
+			return v
+		}
+		return def
+	}
+	nx, ny := int(num("ResolutionX", 0)), int(num("ResolutionY", 0))
+	nz, nt := int(num("NrOfSlices", 0)), int(num("NrOfVolumes", 0))
+	if nx <= 0 || ny <= 0 || nz <= 0 || nt <= 0 {
+		return nil, fmt.Errorf("%s: %w: FMR size %dx%dx%dx%d", name, nifti1.ErrInvalidHeader, nx, ny, nz, nt)
+	}
+	datatype, size := nifti1.DTUint16, 2
+	if num("DataType", 1) == 2 {
+		datatype, size = nifti1.DTFloat32, 4
+	}
+	prefix := util.Join(fsys, util.Dir(fsys, name), h["Prefix"])
+	format := int(num("DataStorageFormat", 1))
+
+	slice := nx * ny * size
+	data := make([]byte, slice*nz*nt)
+	switch format {
+	case 1:
+		for z := 0; z < nz; z++ {
+			stc := fmt.Sprintf("%s-%d.stc", prefix, z+1)
+			b, err := fs.ReadFile(fsys, stc)
+			if err != nil {
+				return nil, err
+			}
+			if len(b) == 4+slice*nt {
+				b = b[4:] // dimensions of old STC files
+			}
+			if len(b) < slice*nt {
+				return nil, fmt.Errorf("%s: %w STC data", stc, nifti1.ErrTruncated)
+			}
+			for t := 0; t < nt; t++ {
+				copy(data[(t*nz+z)*slice:], b[t*slice:(t+1)*slice])
+			}
+		}
+	case 2, 3:
+		stc := prefix + ".stc"
+		b, err := fs.ReadFile(fsys, stc)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < len(data) {
+			return nil, fmt.Errorf("%s: %w STC data", stc, nifti1.ErrTruncated)
+		}
+		if format == 3 {
+			copy(data, b)
+			break
+		}
+		for z := 0; z < nz; z++ {
+			for t := 0; t < nt; t++ {
+				copy(data[(t*nz+z)*slice:], b[(z*nt+t)*slice:(z*nt+t+1)*slice])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s: %w FMR data storage format %d", name, nifti1.ErrUnsupported, format)
+	}
+
+	res := [3]float64{num("InplaneResolutionX", 1), num("InplaneResolutionY", 1), num("SliceThickness", 1) + num("GapThickness", 0)}
+	affine, ok := fmrAffine(h, [3]int{nx, ny, nz}, res)
+	if !ok {
+		affine = [4][4]float64{{res[0], 0, 0, 0}, {0, res[1], 0, 0}, {0, 0, res[2], 0}, {0, 0, 0, 1}}
+		for i := 0; i < 3; i++ {
+			affine[i][3] = -affine[i][i] * float64([3]int{nx, ny, nz}[i]-1) / 2
+		}
+	}
+
+	dims := []int{nx, ny, nz}
+	if nt > 1 {
+		dims = append(dims, nt)
+	}
+	img, err := nifti1.NewImage(datatype, dims, affine, nifti1.XformScannerAnat)
+	if err != nil {
+		return nil, err
+	}
+	img.Data = data
+	if tr := num("TR", 0); tr > 0 && nt > 1 {
+		img.Dt = tr / 1000
+		img.PixDim[4] = img.Dt
+	}
+
+	log.WithFields(log.Fields{
+		"file":     name,
+		"dims":     dims,
+		"format":   format,
+		"position": ok,
+	}).Debug("Read FMR")
+
+	return img, nil
+}
+
+// fmrAffine returns the affine from the position information of an FMR:
+// the centers of the first and last slices and the row and column
+// directions, in DICOM patient (LPS) coordinates.
+func fmrAffine(h map[string]string, dims [3]int, res [3]float64) ([4][4]float64, bool) {
+	var a [4][4]float64
+	vec := func(prefix string) ([3]float64, bool) {
+		var v [3]float64
+		for i, c := range "XYZ" {
+			x, err := strconv.ParseFloat(h[prefix+string(c)], 64)
+			if err != nil {
+				return v, false
+			}
+			v[i] = x
+		}
+		return v, true
+	}
+	first, ok1 := vec("Slice1Center")
+	last, ok2 := vec("SliceNCenter")
+	row, ok3 := vec("RowDir")
+	col, ok4 := vec("ColDir")
+	if !ok1 || !ok2 || !ok3 || !ok4 || (row == [3]float64{} && col == [3]float64{}) {
+		return a, false
+	}
+	var slice [3]float64
+	if dims[2] > 1 {
+		for i := range slice {
+			slice[i] = (last[i] - first[i]) / float64(dims[2]-1)
+		}
+	} else {
+		// Normal to the slice, by the right-hand rule.
+		slice = [3]float64{
+			row[1]*col[2] - row[2]*col[1],
+			row[2]*col[0] - row[0]*col[2],
+			row[0]*col[1] - row[1]*col[0],
+		}
+		for i := range slice {
+			slice[i] *= res[2]
+		}
+	}
+	for i := 0; i < 3; i++ {
+		a[i][0] = row[i] * res[0]
+		a[i][1] = col[i] * res[1]
+		a[i][2] = slice[i]
+		// Center of the first slice to voxel (0, 0, 0).
+		a[i][3] = first[i] - a[i][0]*float64(dims[0]-1)/2 - a[i][1]*float64(dims[1]-1)/2
+	}
+	// LPS to RAS, with no negative zeros.
+	for j := 0; j < 4; j++ {
+		a[0][j], a[1][j] = 0-a[0][j], 0-a[1][j]
+	}
+	a[3][3] = 1
+	return a, true
+}
@@ -0,0 +1,407 @@
+// cloud reads images from object storage with the S3 API (AWS S3, and
+// MinIO, Ceph, or Google Cloud Storage in interoperability mode) and signs
+// URLs for it. Credentials come from a chain of providers, tried in the
+// order the AWS tools use: environment variables, a web identity token
+// (IAM roles for service accounts on Kubernetes), the shared credentials
+// file, and the container or instance metadata service. Without
+// credentials, requests are sent unsigned, as public buckets allow.
+
+package cloud
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoCredentials is returned by providers that find no credentials, so
+// that the next provider of a chain is tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Credentials are the keys requests are signed with. Temporary credentials
+// have a session token and expire.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // zero if the credentials do not expire
+	Source          string    // the provider, for messages
+}
+
+// expired reports whether the credentials expire within a minute.
+func (c Credentials) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && now.Add(time.Minute).After(c.Expires)
+}
+
+// Provider retrieves credentials.
+type Provider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// Static provides fixed credentials.
+type Static Credentials
+
+// Retrieve returns the credentials.
+func (s Static) Retrieve(ctx context.Context) (Credentials, error) {
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	c := Credentials(s)
+	if c.Source == "" {
+		c.Source = "static"
+	}
+	return c, nil
+}
+
+// Env provides credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// and AWS_SESSION_TOKEN.
+type Env struct{}
+
+// Retrieve returns the credentials in the environment.
+func (Env) Retrieve(ctx context.Context) (Credentials, error) {
+	c := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Source:          "environment",
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return c, nil
+}
+
+// SharedConfig provides credentials from a profile of the shared
+// credentials file.
+type SharedConfig struct {
+	Filename string // AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials if empty
+	Profile  string // AWS_PROFILE or "default" if empty
+}
+
+// Retrieve returns the credentials of the profile.
+func (s SharedConfig) Retrieve(ctx context.Context) (Credentials, error) {
+	name := s.Filename
+	if name == "" {
+		name = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if name == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, ErrNoCredentials
+		}
+		name = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := s.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return Credentials{}, ErrNoCredentials
+	} else if err != nil {
+		return Credentials{}, err
+	}
+	defer f.Close()
+
+	// The file is INI: [profile] sections of "key = value" lines.
+	c := Credentials{Source: fmt.Sprintf("%s [%s]", name, profile)}
+	section := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.TrimSpace(line[:i]) {
+		case "aws_access_key_id":
+			c.AccessKeyID = value
+		case "aws_secret_access_key":
+			c.SecretAccessKey = value
+		case "aws_session_token":
+			c.SessionToken = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Credentials{}, fmt.Errorf("%s: %v", name, err)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return c, nil
+}
+
+// WebIdentity exchanges a web identity token for temporary credentials of
+// a role with AWS STS. On Kubernetes with IAM roles for service accounts
+// (IRSA), the token file and role are set in the environment of the pod.
+type WebIdentity struct {
+	TokenFile   string // AWS_WEB_IDENTITY_TOKEN_FILE if empty
+	RoleARN     string // AWS_ROLE_ARN if empty
+	SessionName string // AWS_ROLE_SESSION_NAME or "gonifti" if empty
+	Endpoint    string // the STS endpoint; https://sts.amazonaws.com if empty
+	HTTP        *http.Client
+}
+
+// Retrieve assumes the role with the token.
+func (w WebIdentity) Retrieve(ctx context.Context) (Credentials, error) {
+	tokenFile := firstNonEmpty(w.TokenFile, os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	role := firstNonEmpty(w.RoleARN, os.Getenv("AWS_ROLE_ARN"))
+	if tokenFile == "" || role == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	endpoint := firstNonEmpty(w.Endpoint, "https://sts.amazonaws.com")
+	if region := os.Getenv("AWS_REGION"); w.Endpoint == "" && region != "" {
+		endpoint = "https://sts." + region + ".amazonaws.com"
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {firstNonEmpty(w.SessionName, os.Getenv("AWS_ROLE_SESSION_NAME"), "gonifti")},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := fetch(ctx, w.HTTP, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("assuming role %s: %v", role, err)
+	}
+
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &out); err != nil {
+		return Credentials{}, fmt.Errorf("assuming role %s: %v", role, err)
+	}
+	return Credentials{
+		AccessKeyID:     out.Credentials.AccessKeyID,
+		SecretAccessKey: out.Credentials.SecretAccessKey,
+		SessionToken:    out.Credentials.SessionToken,
+		Expires:         out.Credentials.Expiration,
+		Source:          "web identity " + role,
+	}, nil
+}
+
+// Metadata provides the credentials of the role of the container (ECS,
+// EKS Pod Identity) or, outside containers, of the instance (EC2, with
+// IMDSv2 session tokens).
+type Metadata struct {
+	// Endpoint of the instance metadata service;
+	// AWS_EC2_METADATA_SERVICE_ENDPOINT or http://169.254.169.254 if empty.
+	Endpoint string
+	HTTP     *http.Client
+}
+
+// metadataClient fails fast when there is no metadata service, as off
+// cloud instances.
+var metadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// Retrieve asks the metadata service for credentials.
+func (m Metadata) Retrieve(ctx context.Context) (Credentials, error) {
+	client := m.HTTP
+	if client == nil {
+		client = metadataClient
+	}
+	if os.Getenv("AWS_EC2_METADATA_DISABLED") == "true" {
+		return Credentials{}, ErrNoCredentials
+	}
+
+	// Containers are given their own endpoint and, sometimes, a token.
+	containerURL := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		containerURL = "http://169.254.170.2" + rel
+	}
+	if containerURL != "" {
+		req, err := http.NewRequest("GET", containerURL, nil)
+		if err != nil {
+			return Credentials{}, err
+		}
+		token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return Credentials{}, err
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		return metadataCredentials(ctx, client, req, "container metadata")
+	}
+
+	endpoint := strings.TrimSuffix(firstNonEmpty(m.Endpoint, os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "http://169.254.169.254"), "/")
+	req, err := http.NewRequest("PUT", endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := fetch(ctx, client, req)
+	if err != nil {
+		// No metadata service: not on an instance.
+		log.WithFields(log.Fields{"error": err}).Debug("No instance metadata service")
+		return Credentials{}, ErrNoCredentials
+	}
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", endpoint+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return Credentials{}, err
+	}
+	roles, err := fetch(ctx, client, req)
+	if err != nil {
+		return Credentials{}, ErrNoCredentials // an instance without a role
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	if req, err = get(role); err != nil {
+		return Credentials{}, err
+	}
+	return metadataCredentials(ctx, client, req, "instance metadata "+role)
+}
+
+// metadataCredentials reads the JSON credentials that both metadata
+// services send.
+func metadataCredentials(ctx context.Context, client *http.Client, req *http.Request, source string) (Credentials, error) {
+	body, err := fetch(ctx, client, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("%s: %v", source, err)
+	}
+	var out struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return Credentials{}, fmt.Errorf("%s: %v", source, err)
+	}
+	if out.AccessKeyID == "" || out.SecretAccessKey == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return Credentials{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.Token,
+		Expires:         out.Expiration,
+		Source:          source,
+	}, nil
+}
+
+// fetch sends a request and returns the body of a 200 response.
+func fetch(ctx context.Context, client *http.Client, req *http.Request) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, resp.Status)
+	}
+	return body, nil
+}
+
+// Chain tries providers in order and returns the credentials of the first
+// that has them. It caches them until they expire.
+type Chain struct {
+	Providers []Provider
+
+	mu     sync.Mutex
+	cached Credentials
+}
+
+// DefaultChain is the chain of the AWS tools: environment, web identity,
+// shared credentials file, and metadata service.
+func DefaultChain() *Chain {
+	return &Chain{Providers: []Provider{Env{}, WebIdentity{}, SharedConfig{}, Metadata{}}}
+}
+
+// Retrieve returns the cached credentials, or those of the first provider
+// that has them. It returns ErrNoCredentials if none has.
+func (c *Chain) Retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached.AccessKeyID != "" && !c.cached.expired(time.Now()) {
+		return c.cached, nil
+	}
+	for _, p := range c.Providers {
+		creds, err := p.Retrieve(ctx)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			return Credentials{}, err
+		}
+		log.WithFields(log.Fields{
+			"source":  creds.Source,
+			"expires": creds.Expires,
+		}).Debug("Found credentials")
+		c.cached = creds
+		return creds, nil
+	}
+	return Credentials{}, ErrNoCredentials
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
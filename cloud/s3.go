@@ -0,0 +1,185 @@
+package cloud
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// Client reads objects from an S3 endpoint.
+type Client struct {
+	// Region requests are signed for; AWS_REGION, AWS_DEFAULT_REGION, or
+	// us-east-1 by New.
+	Region string
+	// Endpoint is the URL of a server other than AWS, such as MinIO;
+	// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL by New. Buckets of custom
+	// endpoints are addressed by path rather than by host name.
+	Endpoint    string
+	Credentials Provider
+	HTTP        *http.Client
+	Retry       util.Retry
+}
+
+// New returns a client configured by the environment, with the default
+// credential chain.
+func New() *Client {
+	return &Client{
+		Region:      firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		Endpoint:    firstNonEmpty(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")),
+		Credentials: DefaultChain(),
+		HTTP:        http.DefaultClient,
+		Retry:       util.DefaultRetry,
+	}
+}
+
+// ParseURL splits an "s3://bucket/key" URL.
+func ParseURL(s string) (bucket, key string, err error) {
+	if !strings.HasPrefix(s, "s3://") {
+		return "", "", fmt.Errorf("%q is not an s3:// URL", s)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(s, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q is not of the form s3://bucket/key", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// URL returns the HTTP URL of an object.
+func (c *Client) URL(bucket, key string) (*url.URL, error) {
+	var u *url.URL
+	var path string
+	if c.Endpoint != "" {
+		var err error
+		if u, err = url.Parse(strings.TrimSuffix(c.Endpoint, "/")); err != nil {
+			return nil, fmt.Errorf("endpoint: %v", err)
+		}
+		path = u.Path + "/" + bucket + "/" + key
+	} else {
+		// Bucket names with dots do not match the certificate of the
+		// virtual host, so those are addressed by path.
+		u = &url.URL{Scheme: "https", Host: bucket + ".s3." + c.Region + ".amazonaws.com"}
+		path = "/" + key
+		if strings.Contains(bucket, ".") {
+			u.Host = "s3." + c.Region + ".amazonaws.com"
+			path = "/" + bucket + "/" + key
+		}
+	}
+	u.Path = path
+	u.RawPath = escapePath(path) // what is sent is what is signed
+	return u, nil
+}
+
+// credentials returns the credentials of the client, or none for unsigned
+// requests.
+func (c *Client) credentials(ctx context.Context) (Credentials, bool, error) {
+	if c.Credentials == nil {
+		return Credentials{}, false, nil
+	}
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err == ErrNoCredentials {
+		return Credentials{}, false, nil
+	}
+	return creds, err == nil, err
+}
+
+// Open opens an object for reading. Failed requests are retried and
+// interrupted reads resumed as c.Retry says.
+func (c *Client) Open(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	u, err := c.URL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	creds, signed, err := c.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// Headers other than Host and X-Amz-* need not be signed, so the
+	// Range of a resumed read can be added after signing.
+	resp, err := c.Retry.Open(ctx, client, func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if signed {
+			sign(req, creds, c.Region, time.Now())
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3://%s/%s: %v", bucket, key, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("s3://%s/%s: %s", bucket, key, statusMessage(resp))
+	}
+	return resp.Body, nil
+}
+
+// Presign returns an HTTPS URL that allows anyone to GET the object until
+// it expires, for tools and nodes without credentials.
+func (c *Client) Presign(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	u, err := c.URL(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	creds, signed, err := c.credentials(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !signed {
+		return "", fmt.Errorf("signing s3://%s/%s: %w", bucket, key, ErrNoCredentials)
+	}
+	return presign("GET", u, creds, c.Region, time.Now(), expires)
+}
+
+// statusMessage returns the status of an error response with the message
+// in its XML body, if any.
+func statusMessage(resp *http.Response) string {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Sprintf("%s: %s: %s", resp.Status, e.Code, e.Message)
+	}
+	return resp.Status
+}
+
+// OpenURL opens an "s3://bucket/key" URL with a client configured by the
+// environment. It is registered with util.RegisterScheme, so that files
+// can be read from s3:// URLs wherever local files are.
+func OpenURL(ctx context.Context, s string) (io.ReadCloser, error) {
+	bucket, key, err := ParseURL(s)
+	if err != nil {
+		return nil, err
+	}
+	return defaultClient().Open(ctx, bucket, key)
+}
+
+var (
+	defaultOnce sync.Once
+	defaultC    *Client
+)
+
+// defaultClient returns the client of OpenURL, created once so that the
+// credentials are retrieved once.
+func defaultClient() *Client {
+	defaultOnce.Do(func() { defaultC = New() })
+	return defaultC
+}
@@ -0,0 +1,162 @@
+package cloud
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requests are signed with AWS Signature Version 4.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	signAlgorithm    = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateFormat    = "20060102T150405Z"
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// sign adds the signature of a request without a body to its headers.
+func sign(req *http.Request, creds Credentials, region string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for _, h := range []string{"X-Amz-Date", "X-Amz-Content-Sha256", "X-Amz-Security-Token"} {
+		if v := req.Header.Get(h); v != "" {
+			headers[strings.ToLower(h)] = v
+		}
+	}
+	signed, canonical := canonicalHeaders(headers)
+	scope := credentialScope(now, region)
+	sig := signature(creds, now, region, req.Method, req.URL, canonical, signed, unsignedPayload)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, creds.AccessKeyID, scope, signed, sig))
+}
+
+// presign returns u with the signature in its query, valid for expires.
+func presign(method string, u *url.URL, creds Credentials, region string, now time.Time, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > maxPresignExpiry {
+		return "", fmt.Errorf("expiry %v is not between 1s and 7 days", expires)
+	}
+	if !creds.Expires.IsZero() && now.Add(expires).After(creds.Expires) {
+		return "", fmt.Errorf("the credentials from %s expire at %s, before the URL", creds.Source, creds.Expires.Format(time.RFC3339))
+	}
+	now = now.UTC()
+	signed := *u
+	q := signed.Query()
+	q.Set("X-Amz-Algorithm", signAlgorithm)
+	q.Set("X-Amz-Credential", creds.AccessKeyID+"/"+credentialScope(now, region))
+	q.Set("X-Amz-Date", now.Format(amzDateFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		q.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	signed.RawQuery = canonicalQuery(q)
+
+	headers, canonical := canonicalHeaders(map[string]string{"host": u.Host})
+	sig := signature(creds, now, region, method, &signed, canonical, headers, unsignedPayload)
+	signed.RawQuery += "&X-Amz-Signature=" + sig
+	return signed.String(), nil
+}
+
+func credentialScope(now time.Time, region string) string {
+	return now.Format("20060102") + "/" + region + "/s3/aws4_request"
+}
+
+// signature computes the signature of the canonical request.
+func signature(creds Credentials, now time.Time, region, method string, u *url.URL, headers, signedHeaders, payload string) string {
+	canonical := strings.Join([]string{
+		method,
+		escapePath(u.Path),
+		canonicalQuery(u.Query()),
+		headers,
+		signedHeaders,
+		payload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{
+		signAlgorithm,
+		now.Format(amzDateFormat),
+		credentialScope(now, region),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), now.Format("20060102"))
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalHeaders returns the sorted names of lowercase headers, joined
+// by ';', and the headers as "name:value" lines.
+func canonicalHeaders(headers map[string]string) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// canonicalQuery encodes a query sorted by name and value.
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for name, values := range q {
+		for _, v := range values {
+			params = append(params, escape(name)+"="+escape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// escapePath escapes each segment of a path.
+func escapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape percent-encodes every byte but the unreserved characters of RFC
+// 3986, as signatures require.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
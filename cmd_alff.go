@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	log "github.com/sirupsen/logrus"
+)
+
+// runALFF writes the ALFF map, and optionally the fALFF map, of a 4D image.
+func runALFF(args []string) error {
+	fs := newFlagSet("alff")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti alff [flags] <input> <output>")
+		fs.PrintDefaults()
+	}
+	low := fs.Float64("low", 0.01, "lower edge of the band in Hz")
+	high := fs.Float64("high", 0.08, "upper edge of the band in Hz")
+	tr := fs.Float64("tr", 0, "repetition time in seconds (default: pixdim[4] of the input)")
+	falffName := fs.String("falff", "", "also write the fALFF map to this file")
+	maskName := fs.String("mask", "", "compute only within this mask image")
+	workers := fs.Int("workers", cfg.Workers, "number of goroutines processing voxels")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("alff requires an input and an output filename")
+	}
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if img.NVox/(img.Nx*img.Ny*img.Nz) < 2 {
+		return fmt.Errorf("%s: alff requires a 4D image", fs.Arg(0))
+	}
+	if *tr == 0 {
+		*tr = img.RepetitionTime()
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+
+	alff, falff, err := temporal.ALFF(img, *tr, *low, *high, mask, *workers)
+	if err != nil {
+		return err
+	}
+	if err := writeFloat32(fs.Arg(1), img, alff, img.Nx, img.Ny, img.Nz); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"band":   []float64{*low, *high},
+		"tr":     *tr,
+		"output": fs.Arg(1),
+	}).Info("Wrote ALFF map")
+	if *falffName != "" {
+		if err := writeFloat32(*falffName, img, falff, img.Nx, img.Ny, img.Nz); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"output": *falffName,
+		}).Info("Wrote fALFF map")
+	}
+
+	return nil
+}
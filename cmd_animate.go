@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/render"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runAnimate renders a slice across time, or a fly-through across slices,
+// into an animated GIF or MP4.
+func runAnimate(args []string) error {
+	fs := newFlagSet("animate")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti animate [flags] <input> <output.gif|output.mp4>")
+		fs.PrintDefaults()
+	}
+	axis := fs.String("axis", "z", "axis normal to the slice: x, y, or z")
+	slice := fs.Int("slice", -1, "slice index when sweeping time (default: middle slice)")
+	t := fs.Int("t", 0, "volume index when sweeping slices")
+	sweep := fs.String("sweep", "time", "what changes between frames: time or slices")
+	fps := fs.Float64("fps", 10, "frames per second")
+	scale := fs.Int("scale", 4, "integer upsampling factor")
+	window := fs.String("window", "full", "window preset shared by all frames: full, auto, or symmetric")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("animate requires an input and an output filename")
+	}
+	in, out := fs.Arg(0), fs.Arg(1)
+
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	opts := render.AnimationOptions{T: *t, Index: *slice, Scale: *scale}
+	if opts.Axis, err = render.ParseAxis(*axis); err != nil {
+		return usageError(err.Error())
+	}
+	switch *sweep {
+	case "time":
+		opts.Sweep = render.SweepTime
+	case "slices":
+		opts.Sweep = render.SweepSlices
+	default:
+		return usageError(fmt.Sprintf("unknown sweep %q", *sweep))
+	}
+
+	preset, err := render.ParseWindowPreset(*window)
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	img, err := nifti1.ReadFile(in, ropts...)
+	if err != nil {
+		return err
+	}
+	if preset != render.WindowFull {
+		t := opts.T
+		if opts.Sweep == render.SweepTime {
+			t = -1
+		}
+		if opts.Window, err = render.ImageWindow(img, t, preset); err != nil {
+			return err
+		}
+	}
+	if opts.Index < 0 {
+		opts.Index = [3]int{img.Nx, img.Ny, img.Nz}[opts.Axis] / 2
+	}
+
+	frames, err := render.Frames(img, opts)
+	if err != nil {
+		return err
+	}
+
+	a := beginAudit()
+	switch filepath.Ext(out) {
+	case ".gif":
+		err := util.WriteAtomic(out, func(w io.Writer) error {
+			return render.WriteGIF(w, frames, *fps, opts.Scale)
+		})
+		if err != nil {
+			return err
+		}
+	case ".mp4":
+		if err := render.WriteMP4(out, frames, *fps, opts.Scale); err != nil {
+			return err
+		}
+	default:
+		return usageError(fmt.Sprintf("unsupported animation format %q", filepath.Ext(out)))
+	}
+	if err := a.commit(out); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"frames": len(frames),
+		"preset": preset,
+		"window": frames[0].Window(),
+		"output": out,
+	}).Info("Wrote animation")
+
+	return nil
+}
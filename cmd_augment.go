@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/augment"
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runAugment writes a randomly augmented copy of an image and its labels.
+func runAugment(args []string) error {
+	fs := newFlagSet("augment")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti augment [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Applies, in order, flips, rotation, elastic deformation, bias field, gamma,")
+		fmt.Fprintln(fs.Output(), "and intensity jitter; a transform with a zero flag is skipped. Labels are")
+		fmt.Fprintln(fs.Output(), "moved with the image and keep their values.")
+		fs.PrintDefaults()
+	}
+	seed := fs.Int64("seed", 1, "random seed")
+	flipAxes := fs.String("flip", "", "comma-separated axes to flip at random, of x, y, and z")
+	flipP := fs.Float64("flip-p", 0.5, "probability of flipping each axis")
+	rotate := fs.Float64("rotate", 0, "largest rotation about each axis in degrees")
+	elastic := fs.Float64("elastic", 0, "standard deviation of the elastic displacements in mm")
+	spacing := fs.Float64("elastic-spacing", 20, "spacing of the elastic control points in mm")
+	method := fs.String("interp", interp.Linear, "interpolation of the values: "+strings.Join(interp.Methods, ", "))
+	bias := fs.Float64("bias", 0, "largest coefficient of the log bias field")
+	gamma := fs.Float64("gamma", 0, "largest log of the gamma exponent")
+	scale := fs.Float64("scale", 0, "largest relative change of the intensity scale")
+	shift := fs.Float64("shift", 0, "largest intensity shift")
+	noise := fs.Float64("noise", 0, "standard deviation of added Gaussian noise")
+	labelsIn := fs.String("labels", "", "label image to move with the input")
+	labelsOut := fs.String("labels-out", "", "output filename of the moved labels")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("augment requires an input and an output filename")
+	}
+	if (*labelsIn == "") != (*labelsOut == "") {
+		return usageError("-labels and -labels-out must be given together")
+	}
+
+	var p augment.Pipeline
+	if *flipAxes != "" {
+		var f augment.Flip
+		for _, a := range strings.Split(*flipAxes, ",") {
+			i := strings.Index("xyz", a)
+			if len(a) != 1 || i < 0 {
+				return usageError(fmt.Sprintf("invalid -flip axis %q", a))
+			}
+			f.Axes[i] = true
+		}
+		f.P = *flipP
+		p = append(p, f)
+	}
+	if *rotate > 0 {
+		p = append(p, augment.Rotate{MaxDegrees: *rotate, Method: *method})
+	}
+	if *elastic > 0 {
+		p = append(p, augment.Elastic{Alpha: *elastic, Spacing: *spacing, Method: *method})
+	}
+	if *bias > 0 {
+		p = append(p, augment.BiasField{Strength: *bias})
+	}
+	if *gamma > 0 {
+		p = append(p, augment.Gamma{MaxLog: *gamma})
+	}
+	if *scale > 0 || *shift > 0 || *noise > 0 {
+		p = append(p, augment.Intensity{Scale: *scale, Shift: *shift, Noise: *noise})
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	var labels *nifti1.Image
+	if *labelsIn != "" {
+		if labels, err = nifti1.ReadFile(*labelsIn, ropts...); err != nil {
+			return err
+		}
+	}
+	s, err := augment.FromImage(img, labels)
+	if err != nil {
+		return err
+	}
+	if err := p.Apply(s, *seed); err != nil {
+		return err
+	}
+
+	if err := writeFloat32(fs.Arg(1), img, s.Values); err != nil {
+		return err
+	}
+	if labels != nil {
+		values := make([]uint8, len(s.Labels))
+		wide := false
+		for i, v := range s.Labels {
+			values[i] = uint8(v)
+			wide = wide || v != float64(values[i])
+		}
+		if wide {
+			err = writeFloat32(*labelsOut, labels, s.Labels, s.Dims[:]...)
+		} else {
+			err = writeUint8(*labelsOut, labels, values, s.Dims[:]...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"transforms": len(p),
+		"seed":       *seed,
+		"output":     fs.Arg(1),
+	}).Info("Wrote augmented image")
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// runBench times reading, decoding, streaming, and writing a file, for
+// performance reports.
+func runBench(args []string) error {
+	fs := newFlagSet("bench")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti bench [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Times the basic operations on the input and prints one row per operation")
+		fmt.Fprintln(fs.Output(), "with the best and mean times and the throughput in MB of voxel data per")
+		fmt.Fprintln(fs.Output(), "second. Include the output when reporting performance problems; combine")
+		fmt.Fprintln(fs.Output(), "with gonifti -profile for details.")
+		fs.PrintDefaults()
+	}
+	runs := fs.Int("n", 3, "number of runs of each operation")
+	prefetch := fs.Int("prefetch", 2, "volumes read ahead when streaming")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("bench requires an input filename")
+	}
+	if *runs < 1 {
+		return usageError("-n must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	name := fs.Arg(0)
+
+	img, err := nifti1.ReadFile(name, ropts...)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "gonifti-bench")
+	if err != nil {
+		return err
+	}
+	defer util.TrackTemp(dir)()
+	defer os.RemoveAll(dir)
+
+	ops := []struct {
+		name string
+		run  func() error
+	}{
+		{"read", func() error {
+			_, err := nifti1.ReadFile(name, ropts...)
+			return err
+		}},
+		{"scale", func() error {
+			_, err := img.ScaledFloat64s()
+			return err
+		}},
+		{"stream", func() error {
+			r, err := nifti1.OpenVolumes(name, *prefetch, ropts...)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			for {
+				if _, err := r.Next(); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		}},
+		{"write", func() error {
+			return writeImage(img, filepath.Join(dir, "out.nii"))
+		}},
+		{"write-gz", func() error {
+			return writeImage(img, filepath.Join(dir, "out.nii.gz"))
+		}},
+	}
+
+	mb := float64(len(img.Data)) / (1 << 20)
+	fmt.Printf("# %s: %v voxels, %.1f MB of data, %s, GOMAXPROCS %d\n",
+		name, img.Dim[1:img.NDim+1], mb, runtime.Version(), runtime.GOMAXPROCS(0))
+	fmt.Println("op\truns\tbest_ms\tmean_ms\tmb_per_s")
+	for _, op := range ops {
+		var best, total time.Duration
+		for i := 0; i < *runs; i++ {
+			start := time.Now()
+			if err := op.run(); err != nil {
+				return fmt.Errorf("%s: %v", op.name, err)
+			}
+			d := time.Since(start)
+			total += d
+			if i == 0 || d < best {
+				best = d
+			}
+		}
+		fmt.Printf("%s\t%d\t%.2f\t%.2f\t%.1f\n", op.name, *runs,
+			ms(best), ms(total)/float64(*runs), mb/best.Seconds())
+	}
+	return nil
+}
+
+// ms converts a duration to milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/intensity"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/segment"
+	log "github.com/sirupsen/logrus"
+)
+
+// runBiascorrect removes a smooth low-frequency intensity bias from a 3D
+// image.
+func runBiascorrect(args []string) error {
+	fs := newFlagSet("biascorrect")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti biascorrect [flags] <input> <output>")
+		fs.PrintDefaults()
+	}
+	maskName := fs.String("mask", "", "mask of voxels used for the fit (default: Otsu foreground)")
+	degree := fs.Int("degree", 3, "degree of the polynomial bias field")
+	fieldName := fs.String("field", "", "also write the estimated bias field to this file")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("biascorrect requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if img.NVox != img.Nx*img.Ny*img.Nz {
+		return errors.New("biascorrect expects a 3D image")
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+
+	opts := intensity.BiasOptions{Degree: *degree}
+	if *maskName != "" {
+		opts.Mask, err = readMask(*maskName, img, ropts)
+	} else {
+		opts.Mask, err = segment.OtsuMask(values)
+	}
+	if err != nil {
+		return err
+	}
+
+	corrected, field, err := intensity.BiasCorrect(img, values, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeFloat32(fs.Arg(1), img, corrected); err != nil {
+		return err
+	}
+	if *fieldName != "" {
+		if err := writeFloat32(*fieldName, img, field); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"degree": *degree,
+		"output": fs.Arg(1),
+	}).Info("Wrote bias-corrected image")
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/bids"
+)
+
+// runBids lists the images of a BIDS dataset that match a query.
+func runBids(args []string) error {
+	fs := newFlagSet("bids")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti bids [flags] <dataset> [key=value ...]")
+		fmt.Fprintln(fs.Output(), "Keys are entities (sub, ses, task, run, ...), suffix, or datatype.")
+		fs.PrintDefaults()
+	}
+	entities := fs.Bool("entities", false, "print the entities of each image")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return usageError("bids requires a dataset directory")
+	}
+
+	q, err := bids.ParseQuery(strings.Join(fs.Args()[1:], " "))
+	if err != nil {
+		return usageError(err.Error())
+	}
+	d, err := bids.Index(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range d.Find(q) {
+		if !*entities {
+			fmt.Println(f.Path)
+			continue
+		}
+		keys := make([]string, 0, len(f.Entities))
+		for k := range f.Entities {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		terms := make([]string, 0, len(keys)+2)
+		for _, k := range keys {
+			terms = append(terms, k+"="+f.Entities[k])
+		}
+		terms = append(terms, "suffix="+f.Suffix, "datatype="+f.Datatype)
+		fmt.Printf("%s\t%s\n", f.Path, strings.Join(terms, " "))
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/render"
+)
+
+// browser is the state of an interactive browse session.
+type browser struct {
+	img     *nifti1.Image
+	name    string
+	axis    render.Axis
+	index   [3]int
+	t       int
+	width   int
+	preset  render.WindowPreset
+	header  bool
+	message string
+}
+
+// sttyRun runs stty on the controlling terminal.
+func sttyRun(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// runBrowse opens an interactive slice browser in the terminal.
+func runBrowse(args []string) error {
+	fs := newFlagSet("browse")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti browse [flags] <input>")
+		fmt.Fprintln(fs.Output(), "\nkeys: up/down or k/j slice, left/right or h/l volume, a axis, w window,")
+		fmt.Fprintln(fs.Output(), "      i header, g go to world coordinates, q quit")
+		fs.PrintDefaults()
+	}
+	width := fs.Int("width", 80, "width in terminal columns")
+	window := fs.String("window", "auto", "initial window preset: full, auto, or symmetric")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("browse requires an input filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	preset, err := render.ParseWindowPreset(*window)
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+
+	b := &browser{
+		img:    img,
+		name:   fs.Arg(0),
+		axis:   render.AxisK,
+		index:  [3]int{img.Nx / 2, img.Ny / 2, img.Nz / 2},
+		width:  *width,
+		preset: preset,
+	}
+
+	if err := sttyRun("-icanon", "-echo", "min", "1"); err != nil {
+		return fmt.Errorf("browse needs an interactive terminal: %v", err)
+	}
+	defer sttyRun("sane")
+	fmt.Print("\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[0m\n")
+	// The deferred calls do not run when a signal stops the command.
+	atSignal(func() {
+		sttyRun("sane")
+		fmt.Print("\x1b[?25h\x1b[0m\n")
+	})
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		if err := b.draw(); err != nil {
+			return err
+		}
+		key, err := readKey(in)
+		if err != nil {
+			return err
+		}
+		b.message = ""
+		switch key {
+		case "q", "\x1b":
+			return nil
+		case "k", "up":
+			b.step(1)
+		case "j", "down":
+			b.step(-1)
+		case "l", "right":
+			if b.t < b.volumes()-1 {
+				b.t++
+			}
+		case "h", "left":
+			if b.t > 0 {
+				b.t--
+			}
+		case "a":
+			b.axis = (b.axis + 1) % 3
+		case "w":
+			b.preset = (b.preset + 1) % 3
+		case "i":
+			b.header = !b.header
+		case "g":
+			b.gotoWorld(in)
+		}
+	}
+}
+
+// readKey reads one key press, naming the arrow keys.
+func readKey(in *bufio.Reader) (string, error) {
+	c, err := in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if c != 0x1b {
+		return string(c), nil
+	}
+	// An arrow key is sent as ESC [ A..D; a lone ESC has nothing buffered.
+	if in.Buffered() < 2 {
+		return "\x1b", nil
+	}
+	seq := make([]byte, 2)
+	if _, err := in.Read(seq); err != nil {
+		return "", err
+	}
+	switch string(seq) {
+	case "[A":
+		return "up", nil
+	case "[B":
+		return "down", nil
+	case "[C":
+		return "right", nil
+	case "[D":
+		return "left", nil
+	}
+	return "", nil
+}
+
+func (b *browser) volumes() int {
+	return b.img.NVox / (b.img.Nx * b.img.Ny * b.img.Nz)
+}
+
+// step moves the slice along the current axis.
+func (b *browser) step(d int) {
+	n := [3]int{b.img.Nx, b.img.Ny, b.img.Nz}[b.axis]
+	if i := b.index[b.axis] + d; i >= 0 && i < n {
+		b.index[b.axis] = i
+	}
+}
+
+// gotoWorld prompts for world coordinates and moves all slices to the
+// nearest voxel.
+func (b *browser) gotoWorld(in *bufio.Reader) {
+	sttyRun("icanon", "echo")
+	defer sttyRun("-icanon", "-echo", "min", "1")
+	fmt.Print("\x1b[?25hworld x y z (mm): ")
+	line, err := in.ReadString('\n')
+	fmt.Print("\x1b[?25l")
+	if err != nil {
+		b.message = err.Error()
+		return
+	}
+	fields := strings.Fields(strings.Replace(line, ",", " ", -1))
+	if len(fields) != 3 {
+		b.message = "expected three coordinates"
+		return
+	}
+	var xyz [3]float64
+	for n, f := range fields {
+		if xyz[n], err = strconv.ParseFloat(f, 64); err != nil {
+			b.message = fmt.Sprintf("invalid coordinate %q", f)
+			return
+		}
+	}
+	ijk := b.img.WorldToVoxel(xyz[0], xyz[1], xyz[2])
+	dims := [3]int{b.img.Nx, b.img.Ny, b.img.Nz}
+	for n := range ijk {
+		v := int(math.Round(ijk[n]))
+		if v < 0 || v >= dims[n] {
+			b.message = fmt.Sprintf("(%g, %g, %g) is outside the volume", xyz[0], xyz[1], xyz[2])
+			return
+		}
+		b.index[n] = v
+	}
+}
+
+// draw renders the whole screen.
+func (b *browser) draw() error {
+	img := b.img
+	index := b.index[b.axis]
+	s, w, err := windowedSlice(img, b.axis, index, b.t, b.preset)
+	if err != nil {
+		return err
+	}
+	rows := terminalRows(img, s, b.width)
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H\x1b[2J")
+	xyz := img.VoxelToWorld(float64(b.index[0]), float64(b.index[1]), float64(b.index[2]))
+	fmt.Fprintf(&buf, "%s  axis %s  slice %d/%d  volume %d/%d\r\n",
+		b.name, "ijk"[b.axis:b.axis+1], index, [3]int{img.Nx, img.Ny, img.Nz}[b.axis]-1, b.t, b.volumes()-1)
+	fmt.Fprintf(&buf, "voxel (%d, %d, %d)  world (%.1f, %.1f, %.1f) mm  window %s [%g, %g]\r\n",
+		b.index[0], b.index[1], b.index[2], xyz[0], xyz[1], xyz[2], b.preset, w[0], w[1])
+	if err := render.WriteBlocks(&buf, render.Resize(s, b.width, rows)); err != nil {
+		return err
+	}
+	if b.header {
+		fmt.Fprintf(&buf, "dim %v  pixdim %v\r\n", img.Dim, img.PixDim)
+		fmt.Fprintf(&buf, "datatype %d  scl %g/%g  cal [%g, %g]\r\n",
+			img.DataType, img.SclSlope, img.SclInter, img.CalMin, img.CalMax)
+		fmt.Fprintf(&buf, "qform %d %s  sform %d %s\r\n",
+			img.QFormCode, nifti1.OrientationLetters(img.QFormOrientation()),
+			img.SFormCode, nifti1.OrientationLetters(img.SFormOrientation()))
+		fmt.Fprintf(&buf, "descrip %q  intent %d %q\r\n", img.Descrip, img.IntentCode, img.IntentName)
+	}
+	if b.message != "" {
+		fmt.Fprintf(&buf, "%s\r\n", b.message)
+	}
+	_, err = os.Stdout.Write(buf.Bytes())
+	return err
+}
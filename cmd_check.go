@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runCheck reports problems with the orientation of an image and voxels
+// saturated at the limits of its datatype, and optionally writes a repaired
+// copy.
+func runCheck(args []string) error {
+	fs := newFlagSet("check")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti check [flags] <input> [output]")
+		fs.PrintDefaults()
+	}
+	repair := fs.String("repair", "", "transform to rebuild when problems are found: qform (from the sform) or sform (from the qform)")
+	addDryRunFlag(fs)
+	addBackupFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return usageError("check requires an input filename")
+	}
+	if *repair != "" && fs.NArg() != 2 {
+		return usageError("-repair requires an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("qform\t%d\t%s\n", img.QFormCode, nifti1.OrientationLetters(img.QFormOrientation()))
+	fmt.Printf("sform\t%d\t%s\n", img.SFormCode, nifti1.OrientationLetters(img.SFormOrientation()))
+	issues := img.CheckHandedness()
+	for _, issue := range issues {
+		log.Warn(issue)
+	}
+	if len(issues) == 0 {
+		log.Info("No orientation problems found")
+	}
+	for _, issue := range img.CheckVoxOffset() {
+		log.Warn(issue)
+	}
+	if sat, err := img.Saturation(); err == nil {
+		fmt.Printf("range\t%s\t%g\t%g\n", nifti1.DataTypeString(img.DataType), sat.Lo, sat.Hi)
+		fmt.Printf("at_limits\t%d\t%d\n", sat.AtLo, sat.AtHi)
+		// The lower limit of unsigned datatypes is 0, the background.
+		atLo := sat.AtLo
+		if sat.Lo == 0 {
+			atLo = 0
+		}
+		if atLo > 0 || sat.AtHi > 0 {
+			log.WithFields(log.Fields{
+				"datatype": nifti1.DataTypeString(img.DataType),
+				"atMin":    atLo,
+				"atMax":    sat.AtHi,
+			}).Warn("Voxels at the limits of the datatype may have been clipped")
+		}
+	}
+
+	if fs.NArg() == 2 {
+		switch *repair {
+		case "qform":
+			err = img.SetQFormFromSForm()
+		case "sform":
+			err = img.SetSFormFromQForm()
+		case "":
+		default:
+			err = usageError(fmt.Sprintf("unknown repair %q", *repair))
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeImage(img, fs.Arg(1)); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"repair": *repair,
+			"issues": len(img.CheckHandedness()),
+			"output": fs.Arg(1),
+		}).Info("Wrote checked image")
+	}
+
+	return nil
+}
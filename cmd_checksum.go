@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// sidecarSuffix is appended to the name of a file for its chunk checksums.
+const sidecarSuffix = ".chunks.json"
+
+// runChecksum stores per-chunk checksums of an image, embedded as an
+// extension or in a sidecar file, for gonifti verify.
+func runChecksum(args []string) error {
+	fs := newFlagSet("checksum")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti checksum [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "       gonifti checksum -sidecar [flags] <file>")
+		fmt.Fprintln(fs.Output(), "By default, checksums of each volume of the voxel data are embedded in the")
+		fmt.Fprintln(fs.Output(), "output as an extension. With -sidecar, checksums of the bytes of the file are")
+		fmt.Fprintln(fs.Output(), "written to <file>"+sidecarSuffix+" and the file is not modified.")
+		fs.PrintDefaults()
+	}
+	sidecar := fs.Bool("sidecar", false, "write checksums of the file bytes to a sidecar")
+	chunkMB := fs.Float64("chunk-mb", 0, "chunk size in MB (default: one volume, or 64 MB with -sidecar)")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*sidecar && fs.NArg() != 1) || (!*sidecar && fs.NArg() != 2) {
+		fs.Usage()
+		return usageError("checksum requires an input and an output filename, or -sidecar and a file")
+	}
+	if *chunkMB < 0 {
+		return usageError("-chunk-mb must be positive")
+	}
+	chunk := int(*chunkMB * (1 << 20))
+
+	if *sidecar {
+		if chunk == 0 {
+			chunk = 64 << 20
+		}
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		c, err := nifti1.ComputeChecksums(f, chunk)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fs.Arg(0), err)
+		}
+		b, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return err
+		}
+		name := fs.Arg(0) + sidecarSuffix
+		a := beginAudit(fs.Arg(0))
+		if err := util.WriteFileAtomic(name, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+		if err := a.commit(name); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"chunks": len(c.Sums),
+			"output": name,
+		}).Info("Wrote checksums")
+		return nil
+	}
+
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if chunk == 0 {
+		chunk = img.VolumeBytes()
+	}
+	c, err := nifti1.ComputeChecksums(bytes.NewReader(img.Data), chunk)
+	if err != nil {
+		return err
+	}
+	if err := img.SetChecksums(c); err != nil {
+		return err
+	}
+	if err := writeImage(img, fs.Arg(1)); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"chunks": len(c.Sums),
+		"output": fs.Arg(1),
+	}).Info("Wrote image with checksums")
+	return nil
+}
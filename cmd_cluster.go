@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// runCluster thresholds a Z map, finds its clusters, and keeps those that
+// are significant by Gaussian random field theory.
+func runCluster(args []string) error {
+	fs := newFlagSet("cluster")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti cluster (-fwhm <mm> | -residuals <image>) [flags] <z-map> <output>")
+		fmt.Fprintln(fs.Output(), "The output keeps the Z values of significant clusters. A table of all clusters")
+		fmt.Fprintln(fs.Output(), "is printed, with peaks in world coordinates (mm).")
+		fs.PrintDefaults()
+	}
+	z := fs.Float64("z", 3.1, "cluster-forming threshold")
+	p := fs.Float64("p", 0.05, "cluster significance level")
+	fwhm := fs.String("fwhm", "", "smoothness of the map in mm: one value, or x,y,z")
+	residuals := fs.String("residuals", "", "estimate the smoothness from this 4D residual image instead of -fwhm")
+	maskName := fs.String("mask", "", "search only within this mask image")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("cluster requires a Z map and an output filename")
+	}
+	if (*fwhm == "") == (*residuals == "") {
+		return usageError("cluster requires one of -fwhm or -residuals")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	values, err := volumeValues(img, 0)
+	if err != nil {
+		return err
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+	var fwhmVox [3]float64
+	if *residuals != "" {
+		res, err := nifti1.ReadFile(*residuals, ropts...)
+		if err != nil {
+			return err
+		}
+		if !nifti1.SameGrid(img, res) {
+			return fmt.Errorf("%s: %w", *residuals, nifti1.ErrGridMismatch)
+		}
+		s, err := estimateSmoothness(res, *maskName, ropts)
+		if err != nil {
+			return err
+		}
+		fwhmVox = s.FWHM
+		log.WithFields(log.Fields{
+			"fwhm_voxels": fwhmVox,
+		}).Info("Estimated smoothness")
+	} else {
+		smooth, err := parseFWHM(*fwhm)
+		if err != nil {
+			return usageError(err.Error())
+		}
+		vox := img.VoxelSizeMM()
+		for i := range fwhmVox {
+			fwhmVox[i] = smooth[i] / vox[i]
+		}
+	}
+
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	clusters, labels, err := stats.Clusters(values, dims, mask, *z, fwhmVox)
+	if err != nil {
+		return err
+	}
+
+	keep := map[int]bool{}
+	affine := img.Affine()
+	fmt.Println("cluster\tvoxels\tp\tmax\tx\ty\tz")
+	for i, c := range clusters {
+		if c.P <= *p {
+			keep[c.Label] = true
+		}
+		v := [3]float64{float64(c.Peak % dims[0]), float64(c.Peak / dims[0] % dims[1]), float64(c.Peak / (dims[0] * dims[1]))}
+		w := linalg.ApplyAffine(affine, v)
+		fmt.Printf("%d\t%d\t%.4g\t%.4g\t%.1f\t%.1f\t%.1f\n", i+1, c.Size, c.P, c.Max, w[0], w[1], w[2])
+	}
+	out := make([]float64, len(values))
+	for i, l := range labels {
+		if keep[l] {
+			out[i] = values[i]
+		}
+	}
+	if err := writeFloat32(fs.Arg(1), img, out, dims[0], dims[1], dims[2]); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"clusters":    len(clusters),
+		"significant": len(keep),
+		"output":      fs.Arg(1),
+	}).Info("Wrote cluster-thresholded map")
+
+	return nil
+}
+
+// parseFWHM parses one smoothness in mm for all axes, or x,y,z.
+func parseFWHM(s string) ([3]float64, error) {
+	var fwhm [3]float64
+	parts := strings.Split(s, ",")
+	if len(parts) == 1 {
+		parts = []string{s, s, s}
+	}
+	if len(parts) != 3 {
+		return fwhm, fmt.Errorf("FWHM %q must be one value or x,y,z", s)
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v <= 0 {
+			return fwhm, fmt.Errorf("invalid FWHM %q in %q", p, s)
+		}
+		fwhm[i] = v
+	}
+	return fwhm, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/synth"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runCohort writes synthetic subjects generated from a template.
+func runCohort(args []string) error {
+	fs := newFlagSet("cohort")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti cohort [flags] <template> <output directory>")
+		fmt.Fprintln(fs.Output(), "Writes sub-NNN.nii.gz and the lesion labels sub-NNN_lesions.nii.gz for each")
+		fmt.Fprintln(fs.Output(), "subject, and cohort.tsv with the jitter and lesions of each.")
+		fs.PrintDefaults()
+	}
+	d := synth.DefaultCohortOptions
+	n := fs.Int("n", 10, "number of subjects")
+	seed := fs.Int64("seed", d.Seed, "random seed")
+	noise := fs.Float64("noise", d.Noise, "noise standard deviation, as a fraction of the mean nonzero template value")
+	lesions := fs.Int("lesions", d.Lesions, "lesions per subject")
+	radius := fs.String("lesion-radius", fmt.Sprintf("%g,%g", d.LesionRadius[0], d.LesionRadius[1]), "range of lesion radii in mm, min,max")
+	intensity := fs.Float64("lesion-intensity", d.LesionIntensity, "factor applied to the values inside lesions")
+	shape := fs.String("lesion-shape", d.LesionShape, "lesion shape: "+strings.Join(synth.Shapes, ", "))
+	irregularity := fs.Float64("lesion-irregularity", d.LesionIrregularity, "largest change of the radius of irregular lesions, as a fraction")
+	profile := fs.String("lesion-profile", d.LesionProfile, "lesion intensity profile: "+strings.Join(synth.Profiles, ", "))
+	translation := fs.Float64("translation", d.Translation, "largest head shift along each axis in mm")
+	rotation := fs.Float64("rotation", d.Rotation, "largest head rotation about each axis in degrees")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("cohort requires a template and an output directory")
+	}
+	if *n < 1 {
+		return usageError("-n must be positive")
+	}
+	o := synth.CohortOptions{
+		Seed:               *seed,
+		Noise:              *noise,
+		Lesions:            *lesions,
+		LesionIntensity:    *intensity,
+		LesionShape:        *shape,
+		LesionIrregularity: *irregularity,
+		LesionProfile:      *profile,
+		Translation:        *translation,
+		Rotation:           *rotation,
+	}
+	if _, err := fmt.Sscanf(strings.Replace(*radius, ",", " ", 1), "%g %g", &o.LesionRadius[0], &o.LesionRadius[1]); err != nil {
+		return usageError(fmt.Sprintf("invalid -lesion-radius %q", *radius))
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	template, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	dir := fs.Arg(1)
+	if !util.DryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("subject\ttrans_x\ttrans_y\ttrans_z\trot_x\trot_y\trot_z\tlesions\tlesion_voxels\n")
+	for i := 0; i < *n; i++ {
+		s, err := synth.NewSubject(template, o, i)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("sub-%03d", i+1)
+		if err := writeImage(s.Image, filepath.Join(dir, name+".nii.gz")); err != nil {
+			return err
+		}
+		if err := writeImage(s.Lesions, filepath.Join(dir, name+"_lesions.nii.gz")); err != nil {
+			return err
+		}
+		voxels := 0
+		for _, v := range s.Lesions.Data {
+			if v > 0 {
+				voxels++
+			}
+		}
+		fmt.Fprintf(&b, "%s\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%d\t%d\n", name,
+			s.Jitter[0], s.Jitter[1], s.Jitter[2],
+			s.Jitter[3]*180/math.Pi, s.Jitter[4]*180/math.Pi, s.Jitter[5]*180/math.Pi,
+			len(s.LesionList), voxels)
+	}
+	table := filepath.Join(dir, "cohort.tsv")
+	a := beginAudit()
+	if err := util.WriteFileAtomic(table, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := a.commit(table); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"subjects": *n,
+		"output":   dir,
+	}).Info("Wrote cohort")
+
+	return nil
+}
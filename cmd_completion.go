@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// commandDoc describes a subcommand for completions and man pages.
+type commandDoc struct {
+	name     string
+	short    string
+	synopsis string   // arguments after the command name
+	notes    []string // usage lines between the synopsis and the flags
+	flags    []*flag.Flag
+}
+
+// describeCommands runs every command up to parseFlags, which stops it, to
+// collect its flags and usage text.
+func describeCommands() []commandDoc {
+	describing = true
+	// Registering -dry-run on the flag sets of the commands resets it.
+	dryRun := util.DryRun
+	defer func() {
+		describing = false
+		util.DryRun = dryRun
+	}()
+
+	var docs []commandDoc
+	for _, c := range commands {
+		describedFS = nil
+		describedOut.Reset()
+		c.run(nil)
+
+		d := commandDoc{name: c.name, short: c.short, synopsis: "[flags]"}
+		if describedFS != nil {
+			describedFS.VisitAll(func(f *flag.Flag) { d.flags = append(d.flags, f) })
+		}
+		lines := strings.Split(describedOut.String(), "\n")
+		if len(lines) > 0 && strings.HasPrefix(lines[0], "usage: gonifti "+c.name) {
+			d.synopsis = strings.TrimSpace(strings.TrimPrefix(lines[0], "usage: gonifti "+c.name))
+			for _, l := range lines[1:] {
+				// The flag defaults start with "  -".
+				if strings.HasPrefix(l, "  -") {
+					break
+				}
+				if l = strings.TrimSpace(l); l != "" {
+					d.notes = append(d.notes, l)
+				}
+			}
+		}
+		docs = append(docs, d)
+	}
+	return docs
+}
+
+// isBoolFlag reports whether a flag takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// runCompletion prints a shell completion script.
+func runCompletion(args []string) error {
+	fs := newFlagSet("completion")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti completion <bash|zsh|fish>")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("completion requires a shell name")
+	}
+
+	docs := describeCommands()
+	switch fs.Arg(0) {
+	case "bash":
+		writeBashCompletion(os.Stdout, docs)
+	case "zsh":
+		writeZshCompletion(os.Stdout, docs)
+	case "fish":
+		writeFishCompletion(os.Stdout, docs)
+	default:
+		return usageError(fmt.Sprintf("unsupported shell %q", fs.Arg(0)))
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, docs []commandDoc) {
+	var names []string
+	for _, d := range docs {
+		names = append(names, d.name)
+	}
+	fmt.Fprintln(w, "# bash completion for gonifti")
+	fmt.Fprintln(w, "_gonifti() {")
+	fmt.Fprintln(w, "\tlocal cur opts")
+	fmt.Fprintln(w, "\tcur=\"${COMP_WORDS[COMP_CWORD]}\"")
+	fmt.Fprintln(w, "\tif [ \"$COMP_CWORD\" -eq 1 ]; then")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase \"${COMP_WORDS[1]}\" in")
+	for _, d := range docs {
+		var opts []string
+		for _, f := range d.flags {
+			opts = append(opts, "-"+f.Name)
+		}
+		fmt.Fprintf(w, "\t%s) opts=%q ;;\n", d.name, strings.Join(opts, " "))
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tif [[ \"$cur\" == -* ]]; then")
+	fmt.Fprintln(w, "\t\tCOMPREPLY=( $(compgen -W \"$opts\" -- \"$cur\") )")
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, "\t\tCOMPREPLY=( $(compgen -f -- \"$cur\") )")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _gonifti gonifti")
+}
+
+// zshQuote escapes a description for a zsh _arguments or _describe spec
+// inside single quotes.
+func zshQuote(s string) string {
+	r := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+	return r.Replace(s)
+}
+
+func writeZshCompletion(w io.Writer, docs []commandDoc) {
+	fmt.Fprintln(w, "#compdef gonifti")
+	fmt.Fprintln(w, "_gonifti() {")
+	fmt.Fprintln(w, "\tlocal -a commands")
+	fmt.Fprintln(w, "\tcommands=(")
+	for _, d := range docs {
+		fmt.Fprintf(w, "\t\t'%s:%s'\n", d.name, zshQuote(d.short))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\tif (( CURRENT == 2 )); then")
+	fmt.Fprintln(w, "\t\t_describe 'command' commands")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase $words[2] in")
+	for _, d := range docs {
+		fmt.Fprintf(w, "\t%s)\n\t\t_arguments", d.name)
+		for _, f := range d.flags {
+			if isBoolFlag(f) {
+				fmt.Fprintf(w, " \\\n\t\t\t'-%s[%s]'", f.Name, zshQuote(f.Usage))
+			} else {
+				fmt.Fprintf(w, " \\\n\t\t\t'-%s[%s]:%s:'", f.Name, zshQuote(f.Usage), f.Name)
+			}
+		}
+		fmt.Fprintln(w, " \\\n\t\t\t'*:file:_files'")
+		fmt.Fprintln(w, "\t\t;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "_gonifti \"$@\"")
+}
+
+// fishQuote quotes a string for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func writeFishCompletion(w io.Writer, docs []commandDoc) {
+	fmt.Fprintln(w, "# fish completion for gonifti")
+	for _, d := range docs {
+		fmt.Fprintf(w, "complete -c gonifti -f -n __fish_use_subcommand -a %s -d %s\n", d.name, fishQuote(d.short))
+	}
+	for _, d := range docs {
+		for _, f := range d.flags {
+			req := " -r"
+			if isBoolFlag(f) {
+				req = ""
+			}
+			fmt.Fprintf(w, "complete -c gonifti -n '__fish_seen_subcommand_from %s' -o %s -d %s%s\n",
+				d.name, f.Name, fishQuote(f.Usage), req)
+		}
+	}
+}
+
+// roff escapes text for a man page.
+func roff(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// runMan writes man pages for gonifti and each command to a directory.
+func runMan(args []string) error {
+	fs := newFlagSet("man")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti man [flags] <directory>")
+		fs.PrintDefaults()
+	}
+	addDryRunFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("man requires an output directory")
+	}
+	dir := fs.Arg(0)
+	if !util.DryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	docs := describeCommands()
+	date := time.Now().Format("2006-01-02")
+	a := beginAudit()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, ".TH GONIFTI 1 %q\n", date)
+	fmt.Fprintln(&b, ".SH NAME\ngonifti \\- read, check, and process NIfTI\\-1 images")
+	fmt.Fprintln(&b, ".SH SYNOPSIS\n.B gonifti\n.I command\n[arguments]")
+	fmt.Fprintln(&b, ".SH COMMANDS")
+	for _, d := range docs {
+		fmt.Fprintf(&b, ".TP\n.B %s\n%s\n", d.name, roff(d.short))
+	}
+	fmt.Fprintln(&b, ".SH SEE ALSO")
+	var refs []string
+	for _, d := range docs {
+		refs = append(refs, fmt.Sprintf(".BR gonifti\\-%s (1)", d.name))
+	}
+	fmt.Fprintln(&b, strings.Join(refs, ",\n"))
+	if err := util.WriteFileAtomic(filepath.Join(dir, "gonifti.1"), b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	for _, d := range docs {
+		b.Reset()
+		fmt.Fprintf(&b, ".TH GONIFTI\\-%s 1 %q\n", strings.ToUpper(d.name), date)
+		fmt.Fprintf(&b, ".SH NAME\ngonifti\\-%s \\- %s\n", d.name, roff(d.short))
+		fmt.Fprintf(&b, ".SH SYNOPSIS\n.B gonifti %s\n%s\n", d.name, roff(d.synopsis))
+		if len(d.notes) > 0 {
+			fmt.Fprintln(&b, ".SH DESCRIPTION")
+			for _, n := range d.notes {
+				fmt.Fprintln(&b, roff(n))
+			}
+		}
+		if len(d.flags) > 0 {
+			fmt.Fprintln(&b, ".SH OPTIONS")
+			for _, f := range d.flags {
+				if isBoolFlag(f) {
+					fmt.Fprintf(&b, ".TP\n.B \\-%s\n", roff(f.Name))
+				} else {
+					fmt.Fprintf(&b, ".TP\n.BI \\-%s \" value\"\n", roff(f.Name))
+				}
+				usage := f.Usage
+				if f.DefValue != "" && f.DefValue != "false" {
+					usage += fmt.Sprintf(" (default %s)", f.DefValue)
+				}
+				fmt.Fprintln(&b, roff(usage))
+			}
+		}
+		fmt.Fprintln(&b, ".SH SEE ALSO\n.BR gonifti (1)")
+		if err := util.WriteFileAtomic(filepath.Join(dir, "gonifti-"+d.name+".1"), b.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := a.commit(dir); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"pages":  len(docs) + 1,
+		"output": dir,
+	}).Info("Wrote man pages")
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/conformance"
+)
+
+// runConformance checks gonifti against the reference values of the NIfTI-1
+// C library.
+func runConformance(args []string) error {
+	fs := newFlagSet("conformance")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti conformance [flags]")
+		fmt.Fprintln(fs.Output(), "Checks datatype sizes, header decoding, quaternion and affine conversions,")
+		fmt.Fprintln(fs.Output(), "and orientations against reference values of nifti_clib, and prints one")
+		fmt.Fprintln(fs.Output(), "row per check. Fails if any check does.")
+		fs.PrintDefaults()
+	}
+	verbose := fs.Bool("v", false, "print the checks that pass too")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return usageError("conformance takes no arguments")
+	}
+
+	failed := 0
+	results := conformance.Run()
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Printf("FAIL\t%s\t%s\t%v\n", r.Group, r.Name, r.Err)
+		} else if *verbose {
+			fmt.Printf("ok\t%s\t%s\n", r.Group, r.Name)
+		}
+	}
+	fmt.Printf("%d of %d checks passed\n", len(results)-failed, len(results))
+	if failed > 0 {
+		return fmt.Errorf("%d conformance checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/npy"
+	"github.com/kaczmarj/gonifti/temporal"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runConnectivity computes the correlation matrix between the mean time
+// series of the regions of an atlas, and optionally their seed maps.
+func runConnectivity(args []string) error {
+	fs := newFlagSet("connectivity")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti connectivity [flags] <input> <labels>")
+		fmt.Fprintln(fs.Output(), "The matrix is written as TSV, or as NumPy if -out ends in .npy; its rows and")
+		fmt.Fprintln(fs.Output(), "columns are the labels in increasing order.")
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "", "write the matrix to this file instead of stdout")
+	fisher := fs.Bool("fisher", false, "Fisher z-transform the correlations (the diagonal becomes 0)")
+	seedMaps := fs.String("seed-maps", "", "write the seed-to-voxel correlation map of each region to this 4D image")
+	maskName := fs.String("mask", "", "compute seed maps only within this mask image")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("connectivity requires a 4D image and a label image")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	atlas, err := nifti1.ReadFile(fs.Arg(1), ropts...)
+	if err != nil {
+		return err
+	}
+	if !nifti1.SameGrid(img, atlas) {
+		return fmt.Errorf("image and labels: %w", nifti1.ErrGridMismatch)
+	}
+	labels, err := temporal.Labels(atlas)
+	if err != nil {
+		return err
+	}
+	ids, series, err := temporal.ROISeries(img, labels)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("%s: no labels", fs.Arg(1))
+	}
+
+	r := temporal.Correlation(series)
+	if *fisher {
+		for i := range r {
+			for j := range r[i] {
+				if i == j {
+					r[i][j] = 0
+				} else {
+					r[i][j] = temporal.FisherZ(r[i][j])
+				}
+			}
+		}
+	}
+	if err := writeMatrix(*out, ids, r); err != nil {
+		return err
+	}
+
+	if *seedMaps != "" {
+		var mask []bool
+		if *maskName != "" {
+			if mask, err = readMask(*maskName, img, ropts); err != nil {
+				return err
+			}
+		}
+		maps, err := temporal.SeedMaps(img, series, mask)
+		if err != nil {
+			return err
+		}
+		var values []float64
+		for _, m := range maps {
+			if *fisher {
+				for i := range m {
+					m[i] = temporal.FisherZ(m[i])
+				}
+			}
+			values = append(values, m...)
+		}
+		if err := writeFloat32(*seedMaps, img, values, img.Nx, img.Ny, img.Nz, len(maps)); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"maps":   len(maps),
+			"output": *seedMaps,
+		}).Info("Wrote seed maps")
+	}
+
+	log.WithFields(log.Fields{
+		"regions": len(ids),
+		"fisher":  *fisher,
+	}).Info("Computed connectivity")
+
+	return nil
+}
+
+// writeMatrix writes a square matrix with row and column labels as TSV, to
+// stdout if name is empty, or as NumPy for .npy files.
+func writeMatrix(name string, ids []int, m [][]float64) error {
+	if strings.EqualFold(filepath.Ext(name), ".npy") {
+		data := make([]float64, 0, len(m)*len(m))
+		for _, row := range m {
+			data = append(data, row...)
+		}
+		a := beginAudit()
+		if err := npy.WriteFile(name, data, []int{len(m), len(m)}); err != nil {
+			return err
+		}
+		return a.commit(name)
+	}
+
+	var b strings.Builder
+	b.WriteString("label")
+	for _, id := range ids {
+		fmt.Fprintf(&b, "\t%d", id)
+	}
+	b.WriteString("\n")
+	for i, row := range m {
+		fmt.Fprintf(&b, "%d", ids[i])
+		for _, x := range row {
+			fmt.Fprintf(&b, "\t%g", x)
+		}
+		b.WriteString("\n")
+	}
+	if name == "" {
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	}
+	a := beginAudit()
+	if err := util.WriteFileAtomic(name, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return a.commit(name)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/brainvoyager"
+	"github.com/kaczmarj/gonifti/ecat"
+	"github.com/kaczmarj/gonifti/hdf5"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/parrec"
+	"github.com/kaczmarj/gonifti/raw"
+	"github.com/kaczmarj/gonifti/tiff"
+	"github.com/kaczmarj/gonifti/util"
+	"github.com/kaczmarj/gonifti/zarr"
+	log "github.com/sirupsen/logrus"
+)
+
+// runConvert converts an image to another format, chosen from the output
+// filename.
+func runConvert(args []string) error {
+	fs := newFlagSet("convert")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti convert [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "The output may be .h5 or .hdf5, a .zarr directory, a 16-bit .tif or .tiff stack,")
+		fmt.Fprintln(fs.Output(), "or any NIfTI-1 filename. The input may be a .zarr directory, a .tif or .tiff")
+		fmt.Fprintln(fs.Output(), "stack, a directory of TIFF files, an ECAT 7 volume, a Philips .PAR or .REC file,")
+		fmt.Fprintln(fs.Output(), "a BrainVoyager .vmr or .fmr file, a .json description of a raw binary file, a NumPy")
+		fmt.Fprintln(fs.Output(), ".npy or .npz array with its geometry in a .json file of the same name, or any")
+		fmt.Fprintln(fs.Output(), "NIfTI-1 filename.")
+		fs.PrintDefaults()
+	}
+	level := fs.Int("compression", cfg.CompressionLevel, "compression level, from 0 (none) to 9; -1 for the default")
+	zarrVersion := fs.Int("zarr-version", 2, "Zarr format version of .zarr output, 2 or 3")
+	codec := fs.String("codec", zarr.CodecGzip, "codec of .zarr output chunks: gzip, zlib (v2 only), blosc, or none")
+	chunks := fs.String("chunks", "", "chunk shape of .zarr output as z,y,x (default 64,64,64)")
+	spacing := fs.String("spacing", "", "voxel size of TIFF input as x,y,z in mm (default from the files, else 1)")
+	fpScaling := fs.Bool("fp", false, "scale PAR/REC input to Philips floating-point values rather than display values")
+	volume := fs.Int("volume", 0, "volume of 4D input to write to a TIFF stack")
+	npzKey := fs.String("key", "", "array of .npz input to convert, if it holds several")
+	swapTU := fs.Bool("swap-tu", false, "swap dim[4] and dim[5], for input with echoes or coils along dim[4] and time along dim[5]")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("convert requires an input and an output filename")
+	}
+	in, out := fs.Arg(0), fs.Arg(1)
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	var zopts zarr.Options
+	if isZarr(out) {
+		zopts = zarr.Options{Version: *zarrVersion, Codec: *codec, Level: *level}
+		if *chunks != "" {
+			if zopts.Chunks, err = parseChunks(*chunks); err != nil {
+				return usageError(err.Error())
+			}
+		}
+	}
+
+	inOpts := inputOptions{scaling: parrec.ScaleDisplay}
+	if *spacing != "" {
+		if inOpts.spacing, err = parseSpacing(*spacing); err != nil {
+			return usageError(err.Error())
+		}
+	}
+	if *fpScaling {
+		inOpts.scaling = parrec.ScaleFloatingPoint
+	}
+	inOpts.npzKey = *npzKey
+
+	img, err := readInput(in, inOpts, ropts)
+	if err != nil {
+		return err
+	}
+	if *swapTU {
+		if img, err = img.SwapTU(); err != nil {
+			return err
+		}
+	}
+
+	isHDF5 := strings.HasSuffix(out, ".h5") || strings.HasSuffix(out, ".hdf5")
+	if util.DryRun && (isHDF5 || isZarr(out) || tiff.IsTIFF(out)) {
+		fmt.Printf("would write %s: about %d bytes of voxel data before compression\n", out, len(img.Data))
+		return nil
+	}
+	// writeImage records NIfTI outputs in the audit log; the others are
+	// recorded here.
+	a := beginAudit(in)
+	switch {
+	case isHDF5:
+		err = writeHDF5(img, out, *level)
+	case isZarr(out):
+		err = zarr.WriteImage(strings.TrimSuffix(out, "/"), img, zopts)
+	case tiff.IsTIFF(out):
+		err = tiff.WriteStack(out, img, *volume)
+	default:
+		a = nil
+		err = writeImage(img, out, nifti1.CompressionLevel(*level))
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.commit(out); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"input":  in,
+		"output": out,
+	}).Info("Converted image")
+
+	return nil
+}
+
+// inputOptions configure the readers of formats other than NIfTI.
+type inputOptions struct {
+	spacing [3]float64     // voxel size of TIFF stacks
+	scaling parrec.Scaling // scaling of PAR/REC values
+	npzKey  string         // array of .npz files
+}
+
+// readInput reads an image in any of the formats convert accepts.
+func readInput(name string, o inputOptions, ropts []nifti1.ReadOption) (*nifti1.Image, error) {
+	switch {
+	case isZarr(name):
+		return zarr.ReadImage(strings.TrimSuffix(name, "/"))
+	case isTIFFStack(name):
+		return tiff.ReadStack(name, o.spacing)
+	case parrec.IsPAR(name):
+		return parrec.ReadFile(name, o.scaling)
+	case brainvoyager.IsVMR(name):
+		return brainvoyager.ReadVMR(name)
+	case brainvoyager.IsFMR(name):
+		return brainvoyager.ReadFMR(name)
+	case ecat.IsECAT(name):
+		return ecat.ReadFile(name)
+	case strings.HasSuffix(strings.ToLower(name), ".json"):
+		return raw.ReadFile(name)
+	case strings.HasSuffix(strings.ToLower(name), ".npy") || strings.HasSuffix(strings.ToLower(name), ".npz"):
+		return raw.ReadArray(name, o.npzKey)
+	}
+	return nifti1.ReadFile(name, ropts...)
+}
+
+// isZarr reports whether name is a Zarr store.
+func isZarr(name string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(name, "/"), ".zarr")
+}
+
+// parseChunks parses a chunk shape "z,y,x".
+func parseChunks(s string) ([]int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("chunk shape %q must be z,y,x", s)
+	}
+	chunks := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid chunk length %q in %q", p, s)
+		}
+		chunks[i] = n
+	}
+	return chunks, nil
+}
+
+// isTIFFStack reports whether name is a TIFF file or a directory, which is
+// read as a directory of TIFF files.
+func isTIFFStack(name string) bool {
+	if tiff.IsTIFF(name) {
+		return true
+	}
+	fi, err := os.Stat(name)
+	return err == nil && fi.IsDir()
+}
+
+// parseSpacing parses a voxel size "x,y,z".
+func parseSpacing(s string) ([3]float64, error) {
+	var spacing [3]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return spacing, fmt.Errorf("spacing %q must be x,y,z", s)
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v <= 0 {
+			return spacing, fmt.Errorf("invalid spacing %q in %q", p, s)
+		}
+		spacing[i] = v
+	}
+	return spacing, nil
+}
+
+// writeHDF5 writes the scaled values of img as a float32 dataset "data" with
+// dims (t, z, y, x), one chunk per group of volumes, and the geometry as
+// attributes.
+func writeHDF5(img *nifti1.Image, filename string, level int) error {
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+	data := make([]float32, len(values))
+	for i, v := range values {
+		data[i] = float32(v)
+	}
+
+	nxyz := img.Nx * img.Ny * img.Nz
+	nt := img.NVox / nxyz
+	dims := []int{img.Nz, img.Ny, img.Nx}
+	chunks := dims
+	if nt > 1 {
+		per := (nt + hdf5.MaxChunks - 1) / hdf5.MaxChunks
+		dims = append([]int{nt}, dims...)
+		chunks = append([]int{per}, chunks...)
+	}
+
+	if level < 0 {
+		level = 6
+	}
+	affine := img.Affine()
+	var flat []float64
+	for _, row := range affine {
+		flat = append(flat, row[:]...)
+	}
+	dim := make([]int32, 8)
+	for i, d := range img.Dim {
+		dim[i] = int32(d)
+	}
+
+	return hdf5.WriteFile(filename, hdf5.Dataset{
+		Name:   "data",
+		Dims:   dims,
+		Chunks: chunks,
+		Data:   data,
+		Level:  level,
+		Attributes: []hdf5.Attribute{
+			{Name: "affine", Value: flat, Dims: []int{4, 4}},
+			{Name: "dim", Value: dim},
+			{Name: "pixdim", Value: img.PixDim[:]},
+			{Name: "qform_code", Value: int32(img.QFormCode)},
+			{Name: "sform_code", Value: int32(img.SFormCode)},
+			{Name: "xyzt_units", Value: int32(nifti1.SpaceTimeToXYZT(img.XYZUnits, img.TimeUnits))},
+		},
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runCrop crops an image to its foreground.
+func runCrop(args []string) error {
+	fs := newFlagSet("crop")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti crop [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Crops to the smallest box holding the voxels above -threshold in any volume,")
+		fmt.Fprintln(fs.Output(), "or of -mask, plus -margin. World coordinates of the voxels are kept.")
+		fs.PrintDefaults()
+	}
+	threshold := fs.Float64("threshold", 0, "foreground threshold")
+	margin := fs.Int("margin", 0, "voxels to keep around the foreground")
+	maskName := fs.String("mask", "", "take the foreground from this image instead of the input")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("crop requires an input and an output filename")
+	}
+	if *margin < 0 {
+		return usageError("-margin must not be negative")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	fg := img
+	if *maskName != "" {
+		if fg, err = nifti1.ReadFile(*maskName, ropts...); err != nil {
+			return err
+		}
+		if !nifti1.SameGrid(img, fg) {
+			return fmt.Errorf("image and mask: %w", nifti1.ErrGridMismatch)
+		}
+	}
+	b, ok, err := fg.ForegroundBounds(*threshold)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: no voxels above %g", fs.Arg(0), *threshold)
+	}
+	b = b.Expand(*margin, [3]int{img.Nx, img.Ny, img.Nz})
+	out, err := img.Crop(b)
+	if err != nil {
+		return err
+	}
+	if err := writeImage(out, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"box":    b.String(),
+		"output": fs.Arg(1),
+	}).Info("Wrote cropped image")
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kaczmarj/gonifti/daemon"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// daemonCommands are the commands that jobs of gonifti daemon may run.
+var daemonCommands = []string{"convert", "repack", "check", "info", "qc-anat", "qc-epi", "verify", "checksum", "manifest"}
+
+// daemonGrace is how long a canceled job has to stop after SIGTERM before
+// it is killed.
+const daemonGrace = 10 * time.Second
+
+// runDaemon serves a queue of jobs over HTTP until it is stopped by a
+// signal.
+func runDaemon(args []string) error {
+	fs := newFlagSet("daemon")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti daemon [flags] <unix:path | host:port>")
+		fmt.Fprintln(fs.Output(), "Runs gonifti commands submitted over HTTP on a Unix socket or a loopback TCP")
+		fmt.Fprintln(fs.Output(), "address, a few at a time and within a memory budget, and reports their status.")
+		fmt.Fprintln(fs.Output(), "Jobs may run "+strings.Join(daemonCommands, ", ")+",")
+		fmt.Fprintln(fs.Output(), "on files under -root only. TCP requires a bearer token, read from -token-file")
+		fmt.Fprintln(fs.Output(), "or GONIFTI_DAEMON_TOKEN.")
+		fmt.Fprintln(fs.Output(), "  POST /jobs {\"command\": ..., \"args\": [...]}, GET /jobs, GET /jobs/<id>,")
+		fmt.Fprintln(fs.Output(), "  DELETE /jobs/<id> to cancel, GET /status.")
+		fs.PrintDefaults()
+	}
+	workers := fs.Int("workers", 0, "jobs to run at a time (default: the workers setting, at most max_workers)")
+	budgetMB := fs.Int("memory-budget", cfg.MemoryLimitMB,
+		"MB of memory the running jobs may take, estimated from the headers of their inputs (0: no limit)")
+	rootDir := fs.String("root", ".", "directory the jobs run in, outside which their arguments may not name files")
+	tokenFile := fs.String("token-file", "", "file holding the bearer token clients must send (default: $GONIFTI_DAEMON_TOKEN)")
+	remote := fs.Bool("remote", false, "allow listening on TCP addresses other than loopback")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("daemon requires an address to listen on")
+	}
+	addr := fs.Arg(0)
+
+	token := os.Getenv("GONIFTI_DAEMON_TOKEN")
+	if *tokenFile != "" {
+		b, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	root, err := filepath.Abs(*rootDir)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Jobs record their own inputs in the audit log; the headers the daemon
+	// reads for estimates would only pile up.
+	util.ReadHook = nil
+
+	n := *workers
+	if n <= 0 {
+		n = cfg.Workers
+	}
+	n = util.Workers(n)
+	// The jobs share the workers of the machine.
+	jobWorkers := util.MaxWorkers() / n
+	if jobWorkers < 1 {
+		jobWorkers = 1
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+		// A socket left by a daemon that was killed would fail the listen.
+		if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+	} else {
+		// Jobs write files, so anyone who can submit them can change any
+		// file under the root.
+		if token == "" {
+			return usageError("listening on TCP requires a bearer token in -token-file or GONIFTI_DAEMON_TOKEN")
+		}
+		if !*remote && !isLoopback(addr) {
+			return usageError(fmt.Sprintf("%s is not a loopback address; use -remote to listen on it", addr))
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		// Only the user, and the group if the directory allows, may submit.
+		if err := os.Chmod(addr, 0660); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	q := daemon.NewQueue(daemon.Options{
+		Workers:      n,
+		MemoryBudget: int64(*budgetMB) << 20,
+		Commands:     daemonCommands,
+		Check:        confineArgs(root),
+		Estimate:     daemonEstimate(root),
+		Run:          daemonRunner(root, jobWorkers),
+		Token:        token,
+	})
+	srv := &http.Server{Handler: q.Handler()}
+	atSignal(func() {
+		srv.Close()
+		q.Close()
+		if network == "unix" {
+			os.Remove(addr)
+		}
+	})
+	log.WithFields(log.Fields{
+		"address":       ln.Addr().String(),
+		"root":          root,
+		"workers":       n,
+		"memory_budget": *budgetMB,
+	}).Info("Serving jobs")
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		q.Close()
+		return err
+	}
+	// Serve only returns nil after a signal, whose handler exits.
+	select {}
+}
+
+// isLoopback reports whether the host of a "host:port" address is a
+// loopback address.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// confineArgs returns a check that refuses jobs whose arguments name files
+// outside root, which must be absolute with its links resolved. Every
+// argument other than a flag name is taken as a path relative to root, as
+// are the values of -flag=value; others, such as numbers, are harmless
+// paths under root.
+func confineArgs(root string) func(command string, args []string) error {
+	return func(command string, args []string) error {
+		for _, arg := range args {
+			p := arg
+			if strings.HasPrefix(arg, "-") {
+				i := strings.Index(arg, "=")
+				if i < 0 {
+					continue
+				}
+				p = arg[i+1:]
+			}
+			if !insideRoot(root, p) {
+				return fmt.Errorf("%q is outside the root %s of the daemon", p, root)
+			}
+		}
+		return nil
+	}
+}
+
+// insideRoot reports whether path p, relative to root, lies in root. The
+// links of the part of p that exists are resolved, so that a link under
+// root cannot lead out of it; the rest is to be created by the job.
+func insideRoot(root, p string) bool {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p = filepath.Clean(p)
+	rest := ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			p = filepath.Join(resolved, rest)
+			break
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			break
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// daemonEstimate returns the estimate of the memory a job takes: that of
+// reading each of its arguments that is an image file under root as
+// float64 values. Arguments that are not readable image files, such as
+// flags and outputs, take none.
+func daemonEstimate(root string) func(command string, args []string) (int64, error) {
+	return func(command string, args []string) (int64, error) {
+		var total int64
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			if !filepath.IsAbs(arg) {
+				arg = filepath.Join(root, arg)
+			}
+			if fi, err := os.Stat(arg); err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			h, err := nifti1.ReadHeaderFile(arg)
+			if err != nil {
+				continue
+			}
+			m, err := nifti1.EstimateMemory(h)
+			if err != nil {
+				continue
+			}
+			total += m.Values
+		}
+		return total, nil
+	}
+}
+
+// daemonRunner returns a runner of jobs as child processes of this binary,
+// run in root, each limited to workers workers, which record the job in
+// the audit log. A canceled job gets SIGTERM, so that it removes its
+// partial outputs, and is killed if it has not stopped after daemonGrace.
+func daemonRunner(root string, workers int) daemon.Runner {
+	return func(ctx context.Context, j daemon.Job, stdout, stderr io.Writer) (int, error) {
+		exe, err := os.Executable()
+		if err != nil {
+			return -1, err
+		}
+		cmd := exec.Command(exe, append([]string{j.Command}, j.Args...)...)
+		cmd.Dir = root
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		// The job and client are recorded in the audit log.
+		cmd.Env = append(os.Environ(),
+			"GONIFTI_MAX_WORKERS="+strconv.Itoa(workers),
+			"GONIFTI_JOB="+j.ID,
+			"GONIFTI_JOB_CLIENT="+j.Client)
+		if err := cmd.Start(); err != nil {
+			return -1, err
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+			}
+			cmd.Process.Signal(syscall.SIGTERM)
+			select {
+			case <-done:
+			case <-time.After(daemonGrace):
+				cmd.Process.Kill()
+			}
+		}()
+		err = cmd.Wait()
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return exit.ExitCode(), nil
+		}
+		if err != nil {
+			return -1, err
+		}
+		return 0, nil
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/anonymize"
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runDeface zeroes the voxels covered by a face mask.
+func runDeface(args []string) error {
+	fs := newFlagSet("deface")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti deface -mask <face mask> [flags] <input> <output>")
+		fs.PrintDefaults()
+	}
+	maskName := fs.String("mask", "", "face/ear mask, in subject or template space")
+	affineName := fs.String("affine", "", "4x4 text matrix mapping mask world coordinates to subject world coordinates")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || *maskName == "" {
+		fs.Usage()
+		return usageError("deface requires a mask, an input, and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	mask, err := nifti1.ReadFile(*maskName, ropts...)
+	if err != nil {
+		return err
+	}
+
+	affine := anonymize.Identity
+	if *affineName != "" {
+		if affine, err = anonymize.ReadAffine(*affineName); err != nil {
+			return err
+		}
+	}
+	face, err := anonymize.MaskToSubject(mask, img, affine)
+	if err != nil {
+		return err
+	}
+
+	n, err := anonymize.Deface(img, face)
+	if err != nil {
+		return err
+	}
+	if err := writeImage(img, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"voxels": n,
+		"output": fs.Arg(1),
+	}).Info("Wrote defaced image")
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/glm"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/npy"
+	"github.com/kaczmarj/gonifti/temporal"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runDesign writes the GLM design matrix of a BIDS events file.
+func runDesign(args []string) error {
+	fs := newFlagSet("design")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti design [flags] <events.tsv>")
+		fmt.Fprintln(fs.Output(), "The number of volumes and TR are taken from -ref, or from -volumes and -tr.")
+		fmt.Fprintln(fs.Output(), "The design is written as TSV, or as NumPy if -out ends in .npy.")
+		fs.PrintDefaults()
+	}
+	o := glm.DefaultOptions
+	ref := fs.String("ref", "", "4D image the design is for")
+	tr := fs.Float64("tr", 0, "repetition time in seconds")
+	volumes := fs.Int("volumes", 0, "number of volumes")
+	fs.StringVar(&o.HRF, "hrf", o.HRF, "HRF model: spm, spm+derivative, spm+derivative+dispersion, or none")
+	fs.StringVar(&o.Drift, "drift", o.Drift, "drift model: cosine, polynomial, or none")
+	fs.Float64Var(&o.HighPass, "highpass", o.HighPass, "cutoff of the cosine drift in Hz")
+	fs.IntVar(&o.Order, "order", o.Order, "order of the polynomial drift")
+	out := fs.String("out", "", "write the design to this file instead of stdout")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("design requires an events file")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	if *ref != "" {
+		img, err := nifti1.ReadFile(*ref, ropts...)
+		if err != nil {
+			return err
+		}
+		o.TR = img.RepetitionTime()
+		o.Volumes = img.NVox / (img.Nx * img.Ny * img.Nz)
+	}
+	if *tr > 0 {
+		o.TR = *tr
+	}
+	if *volumes > 0 {
+		o.Volumes = *volumes
+	}
+	if o.TR <= 0 || o.Volumes < 1 {
+		return usageError("design requires -ref, or -tr and -volumes")
+	}
+
+	events, err := temporal.ReadEvents(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	d, err := glm.Build(events, o)
+	if err != nil {
+		return err
+	}
+
+	a := beginAudit(fs.Arg(0))
+	switch {
+	case *out == "":
+		err = d.WriteTSV(os.Stdout)
+	case strings.EqualFold(filepath.Ext(*out), ".npy"):
+		err = npy.WriteFile(*out, d.Matrix.Data, []int{d.Matrix.Rows, d.Matrix.Cols})
+	default:
+		err = util.WriteAtomic(*out, func(f io.Writer) error {
+			return d.WriteTSV(f)
+		})
+	}
+	if err == nil && *out != "" {
+		err = a.commit(*out)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"columns": d.Columns,
+		"volumes": o.Volumes,
+		"tr":      o.TR,
+	}).Info("Built design matrix")
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kaczmarj/gonifti/examples"
+)
+
+// runExamples lists the built-in example pipelines, or runs one.
+func runExamples(args []string) error {
+	fs := newFlagSet("examples")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti examples [<name> <arguments>]")
+		fmt.Fprintln(fs.Output(), "Lists the example pipelines, or runs one. Each is a short function of the")
+		fmt.Fprintln(fs.Output(), "examples package built on the public API; \"gonifti examples <name> -h\"")
+		fmt.Fprintln(fs.Output(), "describes it.")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		for _, e := range examples.All {
+			fmt.Printf("%-16s %s\n", e.Name, e.Short)
+		}
+		return nil
+	}
+
+	e, ok := examples.Find(fs.Arg(0))
+	if !ok {
+		fs.Usage()
+		return usageError(fmt.Sprintf("unknown example %q", fs.Arg(0)))
+	}
+	efs := newFlagSet("examples " + e.Name)
+	efs.Usage = func() {
+		fmt.Fprintln(efs.Output(), "usage: "+e.Usage())
+		fmt.Fprintln(efs.Output(), e.Long)
+		efs.PrintDefaults()
+	}
+	if err := parseFlags(efs, fs.Args()[1:]); err != nil {
+		return err
+	}
+	if efs.NArg() != len(e.Args) {
+		efs.Usage()
+		return usageError(fmt.Sprintf("example %s requires %d arguments", e.Name, len(e.Args)))
+	}
+	return e.Run(os.Stdout, efs.Args())
+}
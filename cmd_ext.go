@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runExt lists, removes, and adds the header extensions of an image.
+func runExt(args []string) error {
+	fs := newFlagSet("ext")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti ext -list <input>")
+		fmt.Fprintln(fs.Output(), "       gonifti ext [-remove <codes>] [-keep <codes>] [-add <code>:<file>,...] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Codes are numbers or names, such as 2 or dicom, separated by commas. Added")
+		fmt.Fprintln(fs.Output(), "extensions hold the bytes of the file and come after the existing ones.")
+		fs.PrintDefaults()
+	}
+	list := fs.Bool("list", false, "list the extensions")
+	remove := fs.String("remove", "", "remove the extensions with these codes")
+	keep := fs.String("keep", "", "remove the extensions without these codes")
+	add := fs.String("add", "", "add extensions with these codes and the contents of these files")
+	addDryRunFlag(fs)
+	addBackupFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*list && fs.NArg() != 1) || (!*list && fs.NArg() != 2) {
+		fs.Usage()
+		return usageError("ext requires -list and an input, or an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if *list {
+		fmt.Println("index\tecode\tname\tbytes\tpreview")
+		for i, e := range img.Extensions {
+			fmt.Printf("%d\t%d\t%s\t%d\t%s\n", i, e.ECode, nifti1.ECodeName(e.ECode), len(e.Data), preview(e.Data))
+		}
+		return nil
+	}
+
+	var rules []nifti1.ExtensionRule
+	for _, opt := range []struct {
+		value string
+		rule  func(...int32) nifti1.ExtensionRule
+	}{{*remove, nifti1.Drop}, {*keep, nifti1.Keep}} {
+		if opt.value == "" {
+			continue
+		}
+		var codes []int32
+		for _, s := range strings.Split(opt.value, ",") {
+			code, err := nifti1.ParseECode(s)
+			if err != nil {
+				return usageError(err.Error())
+			}
+			codes = append(codes, code)
+		}
+		rules = append(rules, opt.rule(codes...))
+	}
+	// Rules apply to the existing extensions only, so that added ones are
+	// kept whatever their code.
+	n := len(img.Extensions)
+	img.Extensions = nifti1.FilterExtensions(img.Extensions, rules)
+	removed := n - len(img.Extensions)
+	added := 0
+	if *add != "" {
+		for _, s := range strings.Split(*add, ",") {
+			i := strings.Index(s, ":")
+			if i < 0 {
+				return usageError(fmt.Sprintf("-add %q is not <code>:<file>", s))
+			}
+			code, err := nifti1.ParseECode(s[:i])
+			if err != nil {
+				return usageError(err.Error())
+			}
+			data, err := ioutil.ReadFile(s[i+1:])
+			if err != nil {
+				return err
+			}
+			img.Extensions = append(img.Extensions, nifti1.Extension{ECode: code, Data: data})
+			added++
+		}
+	}
+
+	if err := writeImage(img, fs.Arg(1)); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"removed":   removed,
+		"added":     added,
+		"numExt":    img.NumExt,
+		"voxOffset": img.INameOffset,
+		"output":    fs.Arg(1),
+	}).Info("Wrote image")
+	return nil
+}
+
+// preview returns the start of extension data if it is text, or "(binary)".
+func preview(data []byte) string {
+	data = bytes.TrimRight(data, "\x00")
+	s := string(data)
+	for _, r := range s {
+		if r != '\n' && r != '\t' && r != '\r' && !unicode.IsPrint(r) {
+			return "(binary)"
+		}
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > 60 {
+		s = s[:57] + "..."
+	}
+	return s
+}
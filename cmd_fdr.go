@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// runFDR thresholds a p-value map at a false discovery rate.
+func runFDR(args []string) error {
+	fs := newFlagSet("fdr")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti fdr [flags] <p-map> <output>")
+		fmt.Fprintln(fs.Output(), "The output is 1 - p in significant voxels and 0 elsewhere, or the values of")
+		fmt.Fprintln(fs.Output(), "-stat if given.")
+		fs.PrintDefaults()
+	}
+	q := fs.Float64("q", 0.05, "false discovery rate")
+	statName := fs.String("stat", "", "write the values of this statistic map in significant voxels")
+	adjusted := fs.String("adjusted", "", "also write the map of adjusted p-values (q-values) to this file")
+	maskName := fs.String("mask", "", "correct only over the voxels in this mask image")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("fdr requires a p-value map and an output filename")
+	}
+	if *q <= 0 || *q >= 1 {
+		return usageError("-q must be between 0 and 1")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	p, err := volumeValues(img, 0)
+	if err != nil {
+		return err
+	}
+	if *maskName != "" {
+		mask, err := readMask(*maskName, img, ropts)
+		if err != nil {
+			return err
+		}
+		for i, in := range mask {
+			if !in {
+				p[i] = math.NaN()
+			}
+		}
+	}
+	var stat []float64
+	if *statName != "" {
+		s, err := nifti1.ReadFile(*statName, ropts...)
+		if err != nil {
+			return err
+		}
+		if !nifti1.SameGrid(img, s) {
+			return fmt.Errorf("%s: %w", *statName, nifti1.ErrGridMismatch)
+		}
+		if stat, err = volumeValues(s, 0); err != nil {
+			return err
+		}
+	}
+
+	threshold := stats.FDRThreshold(p, *q)
+	out := make([]float64, len(p))
+	significant := 0
+	for i, v := range p {
+		if math.IsNaN(v) || v > threshold || threshold == 0 {
+			continue
+		}
+		significant++
+		if stat != nil {
+			out[i] = stat[i]
+		} else {
+			out[i] = 1 - v
+		}
+	}
+	if err := writeFloat32(fs.Arg(1), img, out, img.Nx, img.Ny, img.Nz); err != nil {
+		return err
+	}
+	if *adjusted != "" {
+		qv := stats.FDRAdjust(p)
+		for i, v := range qv {
+			if math.IsNaN(v) {
+				qv[i] = 1
+			}
+		}
+		if err := writeFloat32(*adjusted, img, qv, img.Nx, img.Ny, img.Nz); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"q":           *q,
+		"threshold":   threshold,
+		"significant": significant,
+		"output":      fs.Arg(1),
+	}).Info("Wrote FDR-thresholded map")
+
+	return nil
+}
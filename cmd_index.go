@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/parquet"
+	"github.com/kaczmarj/gonifti/sqlite"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// indexColumns are the columns of the table written by gonifti index.
+var indexColumns = []sqlite.Column{
+	{Name: "path", Type: "TEXT"},
+	{Name: "bytes", Type: "INTEGER"},
+	{Name: "sha256", Type: "TEXT"},
+	{Name: "datatype", Type: "INTEGER"},
+	{Name: "ndim", Type: "INTEGER"},
+	{Name: "nx", Type: "INTEGER"},
+	{Name: "ny", Type: "INTEGER"},
+	{Name: "nz", Type: "INTEGER"},
+	{Name: "nt", Type: "INTEGER"},
+	{Name: "dx", Type: "REAL"},
+	{Name: "dy", Type: "REAL"},
+	{Name: "dz", Type: "REAL"},
+	{Name: "dt", Type: "REAL"},
+	{Name: "qform_code", Type: "INTEGER"},
+	{Name: "sform_code", Type: "INTEGER"},
+	{Name: "orientation", Type: "TEXT"},
+	{Name: "descrip", Type: "TEXT"},
+	{Name: "min", Type: "REAL"},
+	{Name: "max", Type: "REAL"},
+	{Name: "mean", Type: "REAL"},
+	{Name: "std", Type: "REAL"},
+	{Name: "error", Type: "TEXT"},
+}
+
+// isImageName reports whether a file name is a NIfTI image or the header of
+// a pair.
+func isImageName(name string) bool {
+	for _, ext := range []string{".nii", ".nii.gz", ".hdr", ".hdr.gz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexRow reads one image and returns its row. Unreadable images get a row
+// with only the path, size, digest, and error, which is also returned.
+func indexRow(path string, ropts []nifti1.ReadOption) ([]interface{}, error) {
+	row := make([]interface{}, len(indexColumns))
+	row[0] = path
+
+	f, err := os.Open(path)
+	if err != nil {
+		row[len(row)-1] = err.Error()
+		return row, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		row[len(row)-1] = err.Error()
+		return row, err
+	}
+	row[1], row[2] = n, hex.EncodeToString(h.Sum(nil))
+
+	img, err := nifti1.ReadFile(path, ropts...)
+	if err != nil {
+		row[len(row)-1] = err.Error()
+		return row, err
+	}
+	orient := img.QFormOrientation()
+	if img.SFormCode > 0 {
+		orient = img.SFormOrientation()
+	}
+	copy(row[3:], []interface{}{
+		img.DataType, img.NDim, img.Nx, img.Ny, img.Nz, img.Nt,
+		img.Dx, img.Dy, img.Dz, img.Dt,
+		img.QFormCode, img.SFormCode, nifti1.OrientationLetters(orient), img.Descrip,
+	})
+
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		row[len(row)-1] = err.Error()
+		return row, err
+	}
+	if len(values) > 0 {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		var sum, sumSq float64
+		for _, v := range values {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+			sum += v
+			sumSq += v * v
+		}
+		mean := sum / float64(len(values))
+		row[17], row[18], row[19] = lo, hi, mean
+		row[20] = math.Sqrt(math.Max(sumSq/float64(len(values))-mean*mean, 0))
+	}
+	return row, nil
+}
+
+// writeIndexTSV writes rows as tab-separated values with a header line.
+func writeIndexTSV(w io.Writer, rows [][]interface{}) error {
+	names := make([]string, len(indexColumns))
+	for i, c := range indexColumns {
+		names[i] = c.Name
+	}
+	if _, err := fmt.Fprintln(w, strings.Join(names, "\t")); err != nil {
+		return err
+	}
+	fields := make([]string, len(indexColumns))
+	for _, row := range rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+				fields[i] = ""
+			case float64:
+				fields[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			case string:
+				fields[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(v)
+			default:
+				fields[i] = fmt.Sprint(v)
+			}
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexParquetColumns converts rows to Parquet columns. Missing values are
+// nulls.
+func indexParquetColumns(rows [][]interface{}) []parquet.Column {
+	columns := make([]parquet.Column, len(indexColumns))
+	for c, def := range indexColumns {
+		null := make([]bool, len(rows))
+		var ints []int64
+		var reals []float64
+		var texts []string
+		for r, row := range rows {
+			null[r] = row[c] == nil
+			switch def.Type {
+			case "INTEGER":
+				var v int64
+				switch x := row[c].(type) {
+				case int:
+					v = int64(x)
+				case int64:
+					v = x
+				}
+				ints = append(ints, v)
+			case "REAL":
+				v, _ := row[c].(float64)
+				reals = append(reals, v)
+			default:
+				v, _ := row[c].(string)
+				texts = append(texts, v)
+			}
+		}
+		columns[c] = parquet.Column{Name: def.Name, Null: null}
+		switch def.Type {
+		case "INTEGER":
+			columns[c].Values = ints
+		case "REAL":
+			columns[c].Values = reals
+		default:
+			columns[c].Values = texts
+		}
+	}
+	return columns
+}
+
+// runIndex walks a directory and stores the header metadata, digest, and
+// intensity statistics of every image in a table.
+func runIndex(args []string) error {
+	fs := newFlagSet("index")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti index [flags] <directory>")
+		fmt.Fprintln(fs.Output(), "The output format is chosen from the -out extension: .sqlite, .db, .parquet, or .tsv.")
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "index.sqlite", "output file")
+	workers := fs.Int("workers", cfg.Workers, "number of images read in parallel")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("index requires a directory")
+	}
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ext := filepath.Ext(*out)
+	if ext != ".sqlite" && ext != ".db" && ext != ".parquet" && ext != ".tsv" {
+		return fmt.Errorf("%w output format %q", nifti1.ErrUnsupported, ext)
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	// Files and directories that cannot be walked are listed with their
+	// error, like unreadable images.
+	var paths []string
+	walkErrs := make(map[string]error)
+	err = filepath.Walk(fs.Arg(0), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == fs.Arg(0) {
+				return err
+			}
+			paths = append(paths, path)
+			walkErrs[path] = err
+			return nil
+		}
+		if info.IsDir() && path != fs.Arg(0) && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && isImageName(info.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, len(paths))
+	var failures batchErrors
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < util.Workers(*workers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var err error
+				if err = walkErrs[paths[i]]; err != nil {
+					rows[i] = make([]interface{}, len(indexColumns))
+					rows[i][0], rows[i][len(indexColumns)-1] = paths[i], err.Error()
+				} else {
+					rows[i], err = indexRow(paths[i], ropts)
+				}
+				if err != nil {
+					failures.add(paths[i], err)
+				}
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	a := beginAudit()
+	switch ext {
+	case ".tsv":
+		err := util.WriteAtomic(*out, func(w io.Writer) error {
+			return writeIndexTSV(w, rows)
+		})
+		if err != nil {
+			return err
+		}
+	case ".parquet":
+		if err := parquet.WriteFile(*out, indexParquetColumns(rows)); err != nil {
+			return err
+		}
+	default:
+		if err := sqlite.WriteFile(*out, "images", indexColumns, rows); err != nil {
+			return err
+		}
+	}
+	if err := a.commit(*out); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"images": len(rows),
+		"failed": len(failures.files),
+		"output": *out,
+	}).Info("Wrote index")
+
+	return failures.err(len(paths))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+func runInfo(args []string) error {
+	fs := newFlagSet("info")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti info [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Prints the header fields, with the symbolic names of codes such as the")
+		fmt.Fprintln(fs.Output(), "datatype. With -json, prints a report of the header in a stable schema.")
+		fmt.Fprintln(fs.Output(), "With -memory, reads only the header and prints, as JSON, the bytes that")
+		fmt.Fprintln(fs.Output(), "holding the image and its float64 values take.")
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print the header as JSON, with codes as {value, name}")
+	memory := fs.Bool("memory", false, "print the memory estimate of the image as JSON, without reading its data")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("nifti filename must be provided")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	filename := fs.Arg(0)
+
+	if *memory {
+		header, err := nifti1.ReadHeaderFile(filename, ropts...)
+		if err != nil {
+			return err
+		}
+		m, err := nifti1.EstimateMemory(header)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	header, err := nifti1.ReadHeaderFile(filename, ropts...)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		b, err := json.MarshalIndent(nifti1.NewHeaderReport(header), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else {
+		fmt.Println(header)
+	}
+
+	image, err := nifti1.ReadFile(filename, ropts...)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dataLen":       len(image.Data),
+		"numExt":        image.NumExt,
+		"trailingBytes": len(image.TrailingData),
+		"voxelMM3":      image.VoxelVolumeMM3(),
+		"fovMM":         image.FieldOfViewMM(),
+	}).Info("Length of byte data in volume")
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/transform"
+	log "github.com/sirupsen/logrus"
+)
+
+// runJacobian writes the Jacobian determinant map of a warp field.
+func runJacobian(args []string) error {
+	fs := newFlagSet("jacobian")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti jacobian [flags] <warp> <output>")
+		fs.PrintDefaults()
+	}
+	convention := fs.String("convention", "", "convention of the warp field: ras, itk, or fsl (default: guessed from the layout)")
+	logJac := fs.Bool("log", false, "write the natural logarithm of the determinant (NaN where it is not positive)")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("jacobian requires a warp field and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	w, err := transform.NewWarp(img, *convention)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	values := w.Jacobian()
+	folded := 0
+	for i, x := range values {
+		if x <= 0 {
+			folded++
+		}
+		if *logJac {
+			if x > 0 {
+				values[i] = math.Log(x)
+			} else {
+				values[i] = math.NaN()
+			}
+		}
+	}
+
+	ref := *img
+	ref.IntentCode = 0
+	if err := writeFloat32(fs.Arg(1), &ref, values, img.Nx, img.Ny, img.Nz); err != nil {
+		return err
+	}
+	if folded > 0 {
+		log.WithFields(log.Fields{
+			"voxels": folded,
+		}).Warn("Warp field folds: Jacobian determinant is not positive")
+	}
+	log.WithFields(log.Fields{
+		"convention": w.Convention,
+		"output":     fs.Arg(1),
+	}).Info("Wrote Jacobian determinant")
+
+	return nil
+}
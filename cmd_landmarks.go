@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/transform"
+	log "github.com/sirupsen/logrus"
+)
+
+// runLandmarks estimates the transform between corresponding landmarks.
+func runLandmarks(args []string) error {
+	fs := newFlagSet("landmarks")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti landmarks [flags] <reference.txt> <moving.txt>")
+		fmt.Fprintln(fs.Output(), "Each file lists world coordinates x y z (mm), one landmark per line, in the")
+		fmt.Fprintln(fs.Output(), "same order. The matrix maps reference to moving coordinates, as gonifti")
+		fmt.Fprintln(fs.Output(), "transform expects to resample the moving image onto the reference grid.")
+		fs.PrintDefaults()
+	}
+	dof := fs.Int("dof", 6, "degrees of freedom: 6 (rigid), 7 (rigid and scale), or 12 (affine)")
+	matrixName := fs.String("matrix", "", "write the matrix to this file instead of stdout (.json for a transform chain)")
+	addDryRunFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("landmarks requires a reference and a moving landmark file")
+	}
+
+	reference, err := transform.ReadLandmarks(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	moving, err := transform.ReadLandmarks(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	a, rms, err := transform.FromLandmarks(reference, moving, *dof)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"landmarks": len(reference),
+		"dof":       *dof,
+		"rms":       rms,
+	}).Info("Fitted landmarks")
+
+	if *matrixName == "" {
+		fmt.Print(transform.FormatMatrix(a))
+		return nil
+	}
+	audit := beginAudit(fs.Arg(0), fs.Arg(1))
+	if err := transform.New(a).WriteFile(*matrixName); err != nil {
+		return err
+	}
+	return audit.commit(*matrixName)
+}
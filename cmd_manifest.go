@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/manifest"
+	log "github.com/sirupsen/logrus"
+)
+
+// runManifest writes a manifest of the images of a directory for archival,
+// or checks a directory against one.
+func runManifest(args []string) error {
+	fs := newFlagSet("manifest")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti manifest [flags] <directory>")
+		fmt.Fprintln(fs.Output(), "Writes the size, digests, geometry, datatype, and extensions of every image as")
+		fmt.Fprintln(fs.Output(), "JSON (schema "+manifest.Schema+"), or with -check, compares the files with a")
+		fmt.Fprintln(fs.Output(), "manifest and prints those missing, changed, or unlisted.")
+		fs.PrintDefaults()
+	}
+	out := fs.String("o", "manifest.json", "output file")
+	check := fs.String("check", "", "check the directory against this manifest instead")
+	workers := fs.Int("workers", cfg.Workers, "number of images read in parallel")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("manifest requires a directory")
+	}
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	if *check != "" {
+		m, err := manifest.ReadFile(*check)
+		if err != nil {
+			return err
+		}
+		problems, err := m.Check(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Printf("%s\t%s\n", p.Path, p.Reason)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d files do not match %s", len(problems), *check)
+		}
+		log.WithFields(log.Fields{
+			"files": len(m.Files),
+		}).Info("All files match the manifest")
+		return nil
+	}
+
+	m, err := manifest.Build(fs.Arg(0), ropts, *workers)
+	if err != nil {
+		return err
+	}
+	var failures batchErrors
+	for _, f := range m.Files {
+		if err := f.Err(); err != nil {
+			failures.add(f.Path, err)
+		}
+	}
+	a := beginAudit()
+	if err := m.WriteFile(*out); err != nil {
+		return err
+	}
+	if err := a.commit(*out); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"images": len(m.Files),
+		"failed": len(failures.files),
+		"output": *out,
+	}).Info("Wrote manifest")
+	return failures.err(len(m.Files))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runMeants writes the mean time series of a mask or of each label of an
+// atlas as text, in the format of FSL's fslmeants.
+func runMeants(args []string) error {
+	fs := newFlagSet("meants")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti meants -i <input> (-m <mask> | --label <labels>) [flags]")
+		fmt.Fprintln(fs.Output(), "Writes one row per volume and one column per region, as fslmeants does. With")
+		fmt.Fprintln(fs.Output(), "--label there is a column for every label from 1 to the largest, 0 for labels")
+		fmt.Fprintln(fs.Output(), "without voxels. With --showall the columns are the voxels of the mask, and the")
+		fmt.Fprintln(fs.Output(), "first three rows their voxel coordinates.")
+		fs.PrintDefaults()
+	}
+	input := fs.String("i", "", "input 4D image")
+	out := fs.String("o", "", "write the time series to this file instead of stdout")
+	maskName := fs.String("m", "", "mask image; with --label, only voxels in the mask are averaged")
+	labelName := fs.String("label", "", "label image, one column per label")
+	showAll := fs.Bool("showall", false, "write the time series of every voxel in the mask")
+	transpose := fs.Bool("transpose", false, "write one row per region instead of one per volume")
+	prefetch := fs.Int("prefetch", 2, "volumes to read ahead while averaging regions")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *input == "" || fs.NArg() != 0 || (*maskName == "" && *labelName == "") {
+		fs.Usage()
+		return usageError("meants requires -i and -m or --label")
+	}
+	if *showAll && *labelName != "" {
+		return usageError("--showall cannot be used with --label")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	// Regions are averaged volume by volume as the file is read; only
+	// --showall needs the whole image.
+	var img *nifti1.Image
+	var volumes *nifti1.VolumeReader
+	if *showAll {
+		if img, err = nifti1.ReadFile(*input, ropts...); err != nil {
+			return err
+		}
+	} else {
+		if volumes, err = nifti1.OpenVolumes(*input, *prefetch, ropts...); err != nil {
+			return err
+		}
+		defer volumes.Close()
+		img = volumes.Image
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+
+	var columns [][]float64
+	switch {
+	case *showAll:
+		if columns, err = voxelSeries(img, mask); err != nil {
+			return err
+		}
+	case *labelName != "":
+		atlas, err := nifti1.ReadFile(*labelName, ropts...)
+		if err != nil {
+			return err
+		}
+		if !nifti1.SameGrid(img, atlas) {
+			return fmt.Errorf("image and labels: %w", nifti1.ErrGridMismatch)
+		}
+		labels, err := temporal.Labels(atlas)
+		if err != nil {
+			return err
+		}
+		largest := 0
+		for m, l := range labels {
+			if l < 0 || (mask != nil && !mask[m]) {
+				labels[m] = 0
+			} else if l > largest {
+				largest = l
+			}
+		}
+		if largest == 0 {
+			return fmt.Errorf("%s: no labels", *labelName)
+		}
+		ids, series, err := temporal.StreamROISeries(volumes, labels)
+		if err != nil {
+			return err
+		}
+		nt := volumes.Len()
+		columns = make([][]float64, largest)
+		for l := range columns {
+			columns[l] = make([]float64, nt)
+		}
+		for i, id := range ids {
+			columns[id-1] = series[i]
+		}
+	default:
+		labels := make([]int, len(mask))
+		for m := range mask {
+			if mask[m] {
+				labels[m] = 1
+			}
+		}
+		_, series, err := temporal.StreamROISeries(volumes, labels)
+		if err != nil {
+			return err
+		}
+		if len(series) == 0 {
+			return fmt.Errorf("mask %s is empty", *maskName)
+		}
+		columns = series
+	}
+
+	if err := writeColumns(*out, columns, *transpose); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"columns": len(columns),
+		"output":  *out,
+	}).Info("Wrote time series")
+	return nil
+}
+
+// voxelSeries returns, for every voxel in mask (all voxels if mask is nil),
+// its i, j, k coordinates followed by its time series.
+func voxelSeries(img *nifti1.Image, mask []bool) ([][]float64, error) {
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	nxyz := img.Nx * img.Ny * img.Nz
+	nt := img.NVox / nxyz
+	var columns [][]float64
+	for m := 0; m < nxyz; m++ {
+		if mask != nil && !mask[m] {
+			continue
+		}
+		c := make([]float64, 3, 3+nt)
+		c[0] = float64(m % img.Nx)
+		c[1] = float64(m / img.Nx % img.Ny)
+		c[2] = float64(m / (img.Nx * img.Ny))
+		for t := 0; t < nt; t++ {
+			c = append(c, values[t*nxyz+m])
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// writeColumns writes columns of numbers as space-separated text, each
+// number followed by a space as in FSL's matrix files, to stdout if name is
+// empty. If transpose is set, each column is written as a row.
+func writeColumns(name string, columns [][]float64, transpose bool) error {
+	var b strings.Builder
+	rows := 0
+	if len(columns) > 0 {
+		rows = len(columns[0])
+	}
+	if transpose {
+		for _, c := range columns {
+			for _, x := range c {
+				b.WriteString(strconv.FormatFloat(x, 'g', 6, 64))
+				b.WriteByte(' ')
+			}
+			b.WriteByte('\n')
+		}
+	} else {
+		for r := 0; r < rows; r++ {
+			for _, c := range columns {
+				b.WriteString(strconv.FormatFloat(c[r], 'g', 6, 64))
+				b.WriteByte(' ')
+			}
+			b.WriteByte('\n')
+		}
+	}
+	if name == "" {
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	}
+	a := beginAudit()
+	if err := util.WriteFileAtomic(name, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return a.commit(name)
+}
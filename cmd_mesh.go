@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/mesh"
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runMesh extracts a surface mesh from a mask or thresholded image.
+func runMesh(args []string) error {
+	fs := newFlagSet("mesh")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti mesh [flags] <input> <output.stl|output.obj|output.gii>")
+		fs.PrintDefaults()
+	}
+	level := fs.Float64("level", 0.5, "isosurface level; voxels at or above it are inside")
+	t := fs.Int("t", 0, "volume index of a 4D image")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("mesh requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	m, err := mesh.FromImage(img, *t, *level)
+	if err != nil {
+		return err
+	}
+	a := beginAudit()
+	if err := m.WriteFile(fs.Arg(1)); err != nil {
+		return err
+	}
+	if err := a.commit(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"vertices":  len(m.Vertices),
+		"triangles": len(m.Triangles),
+		"output":    fs.Arg(1),
+	}).Info("Wrote mesh")
+
+	return nil
+}
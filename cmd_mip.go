@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/render"
+	log "github.com/sirupsen/logrus"
+)
+
+// runMIP renders a maximum intensity projection to a PNG file.
+func runMIP(args []string) error {
+	fs := newFlagSet("mip")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti mip [flags] <input> <output.png>")
+		fs.PrintDefaults()
+	}
+	axis := fs.String("axis", "z", "projection axis: x, y, or z")
+	azimuth := fs.Float64("azimuth", 0, "view rotation about the z axis in degrees")
+	elevation := fs.Float64("elevation", 0, "view tilt towards the z axis in degrees")
+	t := fs.Int("t", 0, "volume index of a 4D image")
+	scale := fs.Int("scale", 1, "integer upsampling factor")
+	window := fs.String("window", "full", "window preset: full, auto, or symmetric")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("mip requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	ax, err := render.ParseAxis(*axis)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	preset, err := render.ParseWindowPreset(*window)
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+
+	var p *render.Plane
+	if *azimuth == 0 && *elevation == 0 {
+		p, err = render.MIP(img, *t, ax)
+	} else {
+		p, err = render.RotatedMIP(img, *t, *azimuth, *elevation)
+	}
+	if err != nil {
+		return err
+	}
+	p.SetWindow(preset)
+	a := beginAudit()
+	if err := render.WritePNG(fs.Arg(1), p.Upsample(*scale)); err != nil {
+		return err
+	}
+	if err := a.commit(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"preset": preset,
+		"window": p.Window,
+		"output": fs.Arg(1),
+	}).Info("Wrote maximum intensity projection")
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/register"
+)
+
+// runMoments prints centroids or centers of mass and principal axes in
+// world coordinates.
+func runMoments(args []string) error {
+	fs := newFlagSet("moments")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti moments [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Prints the centroid of the voxels above -threshold, or with -weighted the")
+		fmt.Fprintln(fs.Output(), "intensity-weighted center of mass, in world coordinates (mm), with the")
+		fmt.Fprintln(fs.Output(), "principal axes and the standard deviation along each. With -labels, each")
+		fmt.Fprintln(fs.Output(), "nonzero label gets a row.")
+		fs.PrintDefaults()
+	}
+	threshold := fs.Float64("threshold", 0, "centroid of the voxels above this value")
+	weighted := fs.Bool("weighted", false, "center of mass weighted by the positive intensities")
+	labels := fs.Bool("labels", false, "treat the input as a label map: one centroid per label")
+	vol := fs.Int("t", 0, "volume index of a 4D image")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("moments requires an input filename")
+	}
+	if *weighted && *labels {
+		return usageError("-weighted and -labels are exclusive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	v, err := register.FromImage(img, *vol)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("region\tmass\tx\ty\tz\tsd1\tsd2\tsd3\taxis1\taxis2\taxis3")
+	row := func(region string, m register.Moments) {
+		fmt.Printf("%s\t%g\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f", region, m.Mass,
+			m.Center[0], m.Center[1], m.Center[2], m.SD[0], m.SD[1], m.SD[2])
+		for a := 0; a < 3; a++ {
+			fmt.Printf("\t%.4f,%.4f,%.4f", m.Axes[0][a], m.Axes[1][a], m.Axes[2][a])
+		}
+		fmt.Println()
+	}
+
+	if !*labels {
+		var m register.Moments
+		var ok bool
+		if *weighted {
+			m, ok = v.CenterOfMass()
+		} else {
+			m, ok = v.Centroid(*threshold)
+		}
+		if !ok {
+			return fmt.Errorf("%s: no voxels with positive weight", fs.Arg(0))
+		}
+		row("all", m)
+		return nil
+	}
+
+	seen := map[int]bool{}
+	for _, x := range v.Values {
+		if l := int(math.Round(x)); l != 0 {
+			seen[l] = true
+		}
+	}
+	keys := make([]int, 0, len(seen))
+	for l := range seen {
+		keys = append(keys, l)
+	}
+	sort.Ints(keys)
+	for _, l := range keys {
+		l := l
+		m, _ := v.Moments(func(x float64) float64 {
+			if int(math.Round(x)) == l {
+				return 1
+			}
+			return 0
+		})
+		row(strconv.Itoa(l), m)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/inference"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	log "github.com/sirupsen/logrus"
+)
+
+// runOnehot converts between label maps and one-hot channels.
+func runOnehot(args []string) error {
+	fs := newFlagSet("onehot")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti onehot [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Writes one volume per label, in the order of -labels, and records the order")
+		fmt.Fprintln(fs.Output(), "in an extension. With -decode, turns such channels, or probabilities in the")
+		fmt.Fprintln(fs.Output(), "same order, back into a label map.")
+		fs.PrintDefaults()
+	}
+	list := fs.String("labels", "", "comma-separated labels of the channels, in order (default: the labels of the input, sorted)")
+	smoothing := fs.Float64("smoothing", 0, "label smoothing: the fraction of probability spread over all channels")
+	decode := fs.Bool("decode", false, "convert channels back into a label map")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("onehot requires an input and an output filename")
+	}
+	var order []int
+	if *list != "" {
+		for _, s := range strings.Split(*list, ",") {
+			l, err := strconv.Atoi(s)
+			if err != nil {
+				return usageError(fmt.Sprintf("invalid label %q", s))
+			}
+			order = append(order, l)
+		}
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	nxyz := img.Nx * img.Ny * img.Nz
+
+	if *decode {
+		channels, err := inference.Channels(img)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fs.Arg(0), err)
+		}
+		if order == nil {
+			var ok bool
+			if order, ok = img.ChannelLabels(); !ok {
+				return fmt.Errorf("%s: no channel labels recorded; give -labels", fs.Arg(0))
+			}
+		}
+		if len(order) != channels {
+			return fmt.Errorf("%s: %d channels for %d labels", fs.Arg(0), channels, len(order))
+		}
+		values, err := img.ScaledFloat64s()
+		if err != nil {
+			return err
+		}
+		labels, err := inference.FromOneHot(values[:channels*nxyz], nxyz, order)
+		if err != nil {
+			return err
+		}
+		f := make([]float64, nxyz)
+		b := make([]uint8, nxyz)
+		small := true
+		for m, l := range labels {
+			f[m], b[m] = float64(l), uint8(l)
+			small = small && l >= 0 && l <= 255
+		}
+		out := *img
+		out.Extensions = nil
+		if small {
+			err = writeUint8(fs.Arg(1), &out, b, img.Nx, img.Ny, img.Nz)
+		} else {
+			err = writeFloat32(fs.Arg(1), &out, f, img.Nx, img.Ny, img.Nz)
+		}
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"channels": channels,
+			"output":   fs.Arg(1),
+		}).Info("Wrote label map")
+		return nil
+	}
+
+	labels, err := temporal.Labels(img)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		order = inference.LabelOrder(labels)
+	}
+	values, err := inference.OneHot(labels, order, *smoothing)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	out := *img
+	if err := out.SetChannelLabels(order); err != nil {
+		return err
+	}
+	if *smoothing == 0 {
+		b := make([]uint8, len(values))
+		for i, v := range values {
+			b[i] = uint8(v)
+		}
+		err = writeUint8(fs.Arg(1), &out, b, img.Nx, img.Ny, img.Nz, len(order))
+	} else {
+		err = writeFloat32(fs.Arg(1), &out, values, img.Nx, img.Ny, img.Nz, len(order))
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"labels": order,
+		"output": fs.Arg(1),
+	}).Info("Wrote one-hot channels")
+
+	return nil
+}
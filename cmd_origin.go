@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/register"
+	log "github.com/sirupsen/logrus"
+)
+
+// runOrigin moves the world origin of an image to a voxel, its center of
+// mass, or the center of its grid.
+func runOrigin(args []string) error {
+	fs := newFlagSet("origin")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti origin [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Translates the qform and sform so that the chosen point maps to world")
+		fmt.Fprintln(fs.Output(), "coordinates (0, 0, 0), e.g. the anterior commissure before SPM processing.")
+		fmt.Fprintln(fs.Output(), "The voxel data are not changed.")
+		fs.PrintDefaults()
+	}
+	voxel := fs.String("voxel", "", "voxel index i,j,k of the new origin, e.g. the anterior commissure")
+	com := fs.Bool("com", false, "use the intensity-weighted center of mass")
+	center := fs.Bool("center", false, "use the center of the grid")
+	vol := fs.Int("t", 0, "volume index of a 4D image for -com")
+	addDryRunFlag(fs)
+	addBackupFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("origin requires an input and an output filename")
+	}
+	chosen := 0
+	for _, set := range []bool{*voxel != "", *com, *center} {
+		if set {
+			chosen++
+		}
+	}
+	if chosen != 1 {
+		return usageError("origin requires exactly one of -voxel, -com, or -center")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	var p [3]float64
+	switch {
+	case *voxel != "":
+		if p, err = parseVector(*voxel); err != nil {
+			return usageError(err.Error())
+		}
+	case *com:
+		v, err := register.FromImage(img, *vol)
+		if err != nil {
+			return err
+		}
+		m, ok := v.CenterOfMass()
+		if !ok {
+			return fmt.Errorf("%s: no positive intensities", fs.Arg(0))
+		}
+		p = img.WorldToVoxel(m.Center[0], m.Center[1], m.Center[2])
+	case *center:
+		p = [3]float64{float64(img.Nx-1) / 2, float64(img.Ny-1) / 2, float64(img.Nz-1) / 2}
+	}
+	before := img.VoxelToWorld(p[0], p[1], p[2])
+	img.SetOrigin(p)
+	if err := writeImage(img, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"voxel":  p,
+		"shift":  before,
+		"output": fs.Arg(1),
+	}).Info("Moved origin")
+
+	return nil
+}
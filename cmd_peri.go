@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runPeri averages the time courses around the events of a BIDS events
+// file, per region of an atlas or per voxel.
+func runPeri(args []string) error {
+	fs := newFlagSet("peri")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti peri [flags] <input> <events.tsv>")
+		fmt.Fprintln(fs.Output(), "With -labels, the average time course of each region and trial type is written")
+		fmt.Fprintln(fs.Output(), "as TSV; with -voxels, the average of each voxel is written as a 4D image with")
+		fmt.Fprintln(fs.Output(), "one volume per time point of the window.")
+		fs.PrintDefaults()
+	}
+	pre := fs.Float64("pre", 4, "window before each onset in seconds")
+	post := fs.Float64("post", 20, "window after each onset in seconds")
+	baseline := fs.Bool("baseline", true, "subtract the mean of the window before each onset")
+	percent := fs.Bool("percent", false, "express values as percent signal change from the baseline")
+	tr := fs.Float64("tr", 0, "repetition time in seconds (default: pixdim[4] of the input)")
+	labelsName := fs.String("labels", "", "average within the regions of this label image")
+	voxels := fs.String("voxels", "", "write the voxelwise average to this 4D image (requires -trial-type if there are several)")
+	trialType := fs.String("trial-type", "", "use only the events of this trial type")
+	out := fs.String("out", "", "write the TSV to this file instead of stdout")
+	workers := fs.Int("workers", cfg.Workers, "number of goroutines processing voxels with -voxels")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("peri requires a 4D image and an events file")
+	}
+	if (*labelsName == "") == (*voxels == "") {
+		return usageError("peri requires one of -labels or -voxels")
+	}
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	events, err := temporal.ReadEvents(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	auditRead(fs.Arg(1))
+	types := temporal.TrialTypes(events)
+	if *trialType != "" {
+		types = []string{*trialType}
+	}
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if *tr == 0 {
+		*tr = img.RepetitionTime()
+	}
+	if *tr <= 0 {
+		return fmt.Errorf("%s: no repetition time; use -tr", fs.Arg(0))
+	}
+	o := temporal.PeriOptions{Pre: *pre, Post: *post, Baseline: *baseline, Percent: *percent}
+
+	if *voxels != "" {
+		if len(types) != 1 {
+			return usageError(fmt.Sprintf("-voxels requires -trial-type; the events have types %s", strings.Join(types, ", ")))
+		}
+		onsets := temporal.Onsets(events, types[0])
+		values, n, err := temporal.PeriEventImage(img, *tr, onsets, o, *workers)
+		if err != nil {
+			return err
+		}
+		nxyz := img.Nx * img.Ny * img.Nz
+		if err := writeFloat32(*voxels, img, values, img.Nx, img.Ny, img.Nz, len(values)/nxyz); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"events": n,
+			"output": *voxels,
+		}).Info("Wrote peri-event average")
+		return nil
+	}
+
+	atlas, err := nifti1.ReadFile(*labelsName, ropts...)
+	if err != nil {
+		return err
+	}
+	if !nifti1.SameGrid(img, atlas) {
+		return fmt.Errorf("image and labels: %w", nifti1.ErrGridMismatch)
+	}
+	labels, err := temporal.Labels(atlas)
+	if err != nil {
+		return err
+	}
+	ids, series, err := temporal.ROISeries(img, labels)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("trial_type\tlabel\ttime\tmean\tsem\tn\n")
+	for _, tt := range types {
+		onsets := temporal.Onsets(events, tt)
+		for i, id := range ids {
+			res := temporal.PeriEvent(series[i], *tr, onsets, o)
+			for k, t := range res.Times {
+				fmt.Fprintf(&b, "%s\t%d\t%g\t%g\t%g\t%d\n", tt, id, t, res.Mean[k], res.SEM[k], res.N)
+			}
+		}
+	}
+	if *out == "" {
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	}
+	a := beginAudit()
+	if err := util.WriteFileAtomic(*out, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := a.commit(*out); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"regions": len(ids),
+		"types":   types,
+		"output":  *out,
+	}).Info("Wrote peri-event averages")
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runPhysio writes slice-specific RETROICOR regressors of a 4D image from
+// cardiac and respiratory recordings.
+func runPhysio(args []string) error {
+	fs := newFlagSet("physio")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti physio (-bids <physio.tsv.gz> | -cardiac <file> -respiratory <file> -freq <Hz>) [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Writes a table with a column <regressor>_slice<s> per regressor and slice, for")
+		fmt.Fprintln(fs.Output(), "gonifti regress -slice-confounds. Slice times are taken from the header")
+		fmt.Fprintln(fs.Output(), "(slice_code, slice_duration, dim_info), or from -slice-timing.")
+		fs.PrintDefaults()
+	}
+	bidsName := fs.String("bids", "", "BIDS physiological recording, with a .json sidecar")
+	cardiac := fs.String("cardiac", "", "cardiac trace, one sample per line")
+	respiratory := fs.String("respiratory", "", "respiratory trace, one sample per line")
+	freq := fs.Float64("freq", 0, "sampling frequency of -cardiac and -respiratory in Hz")
+	start := fs.Float64("start", 0, "time of the first sample of -cardiac and -respiratory relative to the first volume, in seconds")
+	order := fs.Int("order", 2, "number of harmonics of each phase")
+	sliceTiming := fs.String("slice-timing", "", "BIDS sidecar (.json) whose SliceTiming overrides the header")
+	multiband := fs.Int("multiband", 1, "multiband factor of the slice timing in the header")
+	tr := fs.Float64("tr", 0, "repetition time in seconds (default: pixdim[4] of the input)")
+	out := fs.String("out", "", "write the table to this file instead of stdout")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (*bidsName == "") == (*cardiac == "" && *respiratory == "") {
+		fs.Usage()
+		return usageError("physio requires a 4D image and -bids, or -cardiac and/or -respiratory")
+	}
+	if *bidsName == "" && *freq <= 0 {
+		return usageError("-cardiac and -respiratory require -freq")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	var p *temporal.Physio
+	if *bidsName != "" {
+		if p, err = temporal.ReadBIDSPhysio(*bidsName); err != nil {
+			return err
+		}
+	} else {
+		p = &temporal.Physio{Freq: *freq, Start: *start}
+		if *cardiac != "" {
+			if p.Cardiac, err = temporal.ReadTrace(*cardiac); err != nil {
+				return err
+			}
+		}
+		if *respiratory != "" {
+			if p.Respiratory, err = temporal.ReadTrace(*respiratory); err != nil {
+				return err
+			}
+		}
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if *tr == 0 {
+		*tr = img.RepetitionTime()
+	}
+	var times []float64
+	if *sliceTiming != "" {
+		b, err := ioutil.ReadFile(*sliceTiming)
+		if err != nil {
+			return err
+		}
+		var sidecar struct{ SliceTiming []float64 }
+		if err := json.Unmarshal(b, &sidecar); err != nil {
+			return fmt.Errorf("%s: %v", *sliceTiming, err)
+		}
+		if len(sidecar.SliceTiming) == 0 {
+			return fmt.Errorf("%s: no SliceTiming", *sliceTiming)
+		}
+		times = sidecar.SliceTiming
+	} else if times, err = img.SliceTimes(nifti1.Multiband(*multiband)); err != nil {
+		return fmt.Errorf("%s: %w (use -slice-timing)", fs.Arg(0), err)
+	}
+
+	nt := img.NVox / (img.Nx * img.Ny * img.Nz)
+	slices, err := temporal.RETROICOR(p, *tr, nt, times, *order)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		err = temporal.WriteSliceConfounds(os.Stdout, slices)
+	} else {
+		a := beginAudit(*bidsName, *cardiac, *respiratory, *sliceTiming)
+		err = util.WriteAtomic(*out, func(f io.Writer) error {
+			return temporal.WriteSliceConfounds(f, slices)
+		})
+		if err == nil {
+			err = a.commit(*out)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"slices":     len(slices),
+		"regressors": slices[0].Names,
+		"output":     *out,
+	}).Info("Wrote physiological regressors")
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kaczmarj/gonifti/cloud"
+)
+
+// runPresign prints signed HTTPS URLs for objects in S3 storage.
+func runPresign(args []string) error {
+	fs := newFlagSet("presign")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti presign [flags] s3://bucket/key ...")
+		fmt.Fprintln(fs.Output(), "Prints a URL for each object that can be read without credentials until it")
+		fmt.Fprintln(fs.Output(), "expires, e.g. by jobs on nodes without access to the bucket. Credentials and")
+		fmt.Fprintln(fs.Output(), "the endpoint are found as for reading s3:// URLs; see the README.")
+		fs.PrintDefaults()
+	}
+	expires := fs.Duration("expires", time.Hour, "how long the URLs are valid, at most 168h")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return usageError("presign requires at least one s3:// URL")
+	}
+
+	ctx := context.Background()
+	client := cloud.New()
+	var failures batchErrors
+	for _, arg := range fs.Args() {
+		bucket, key, err := cloud.ParseURL(arg)
+		if err != nil {
+			failures.add(arg, usageError(err.Error()))
+			continue
+		}
+		u, err := client.Presign(ctx, bucket, key, *expires)
+		if err != nil {
+			failures.add(arg, err)
+			continue
+		}
+		fmt.Println(u)
+	}
+	return failures.err(fs.NArg())
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/inference"
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runProbmap post-processes the multi-channel output of a model: label
+// maps, probabilities, and uncertainty.
+func runProbmap(args []string) error {
+	fs := newFlagSet("probmap")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti probmap [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "The channels of the input are along dim 4, or dim 5 if dim 4 is 1. -op is")
+		fmt.Fprintln(fs.Output(), "argmax (a label map), softmax (probabilities from logits), or entropy (an")
+		fmt.Fprintln(fs.Output(), "uncertainty map).")
+		fs.PrintDefaults()
+	}
+	op := fs.String("op", "argmax", "operation: argmax, softmax, or entropy")
+	logits := fs.Bool("logits", false, "the input holds logits; apply softmax before argmax or entropy")
+	threshold := fs.Float64("threshold", 0, "with argmax, label 0 the voxels whose highest probability is below this")
+	offset := fs.Int("offset", 0, "with argmax, add this to the channel index; 1 labels channel 0 as 1 instead of background (ignored for channels with labels from gonifti onehot)")
+	normalize := fs.Bool("normalize", true, "with entropy, divide by log(channels) to range from 0 to 1")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("probmap requires an input and an output filename")
+	}
+	if *op != "argmax" && *op != "softmax" && *op != "entropy" {
+		return usageError(fmt.Sprintf("unknown -op %q", *op))
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	channels, err := inference.Channels(img)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+	nxyz := img.Nx * img.Ny * img.Nz
+	values = values[:channels*nxyz]
+	if *logits || *op == "softmax" {
+		inference.Softmax(values, nxyz)
+	}
+
+	switch *op {
+	case "argmax":
+		var labels []int
+		smallest, largest := *offset, channels-1+*offset
+		// Channels written by gonifti onehot carry their labels.
+		if order, ok := img.ChannelLabels(); ok && len(order) == channels {
+			labels = inference.Argmax(values, nxyz, 1, *threshold)
+			for m, l := range labels {
+				if l > 0 {
+					labels[m] = order[l-1]
+				}
+			}
+			smallest, largest = 0, 0
+			for _, l := range order {
+				if l < smallest {
+					smallest = l
+				}
+				if l > largest {
+					largest = l
+				}
+			}
+		} else {
+			labels = inference.Argmax(values, nxyz, *offset, *threshold)
+		}
+		if smallest >= 0 && largest <= 255 {
+			b := make([]uint8, nxyz)
+			for m, l := range labels {
+				b[m] = uint8(l)
+			}
+			err = writeUint8(fs.Arg(1), img, b, img.Nx, img.Ny, img.Nz)
+		} else {
+			f := make([]float64, nxyz)
+			for m, l := range labels {
+				f[m] = float64(l)
+			}
+			err = writeFloat32(fs.Arg(1), img, f, img.Nx, img.Ny, img.Nz)
+		}
+	case "softmax":
+		err = writeFloat32(fs.Arg(1), img, values, img.Nx, img.Ny, img.Nz, channels)
+	case "entropy":
+		err = writeFloat32(fs.Arg(1), img, inference.Entropy(values, nxyz, *normalize), img.Nx, img.Ny, img.Nz)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"op":       *op,
+		"channels": channels,
+		"output":   fs.Arg(1),
+	}).Info("Wrote probability map")
+
+	return nil
+}
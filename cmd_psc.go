@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	log "github.com/sirupsen/logrus"
+)
+
+// runPSC converts a 4D series or a map of effects to percent signal change.
+func runPSC(args []string) error {
+	fs := newFlagSet("psc")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti psc [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Series become 100 (x - b) / b with b the temporal mean of each voxel, or the")
+		fmt.Fprintln(fs.Output(), "-baseline image. With -change, such as for GLM betas, the input is already a")
+		fmt.Fprintln(fs.Output(), "change from the baseline and becomes 100 x / b.")
+		fs.PrintDefaults()
+	}
+	baselineName := fs.String("baseline", "", "baseline image, such as the mean or intercept (required for 3D inputs)")
+	change := fs.Bool("change", false, "the input is a change from the baseline, such as a beta map")
+	maskName := fs.String("mask", "", "convert only within this mask image; other voxels are 0")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("psc requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	var baseline []float64
+	if *baselineName != "" {
+		b, err := nifti1.ReadFile(*baselineName, ropts...)
+		if err != nil {
+			return err
+		}
+		if !nifti1.SameGrid(img, b) {
+			return fmt.Errorf("baseline %s: %w", *baselineName, nifti1.ErrGridMismatch)
+		}
+		if baseline, err = volumeValues(b, 0); err != nil {
+			return err
+		}
+	} else if img.NVox/(img.Nx*img.Ny*img.Nz) < 2 {
+		return fmt.Errorf("%s: a 3D image requires -baseline", fs.Arg(0))
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+
+	out, err := temporal.PercentChange(img, baseline, mask, *change)
+	if err != nil {
+		return err
+	}
+	if err := writeImage(out, fs.Arg(1)); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"output":   fs.Arg(1),
+		"baseline": *baselineName,
+	}).Info("Wrote percent signal change")
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/qc"
+	log "github.com/sirupsen/logrus"
+)
+
+// runQCAnat reports the quality metrics of an anatomical image.
+func runQCAnat(args []string) error {
+	fs := newFlagSet("qc-anat")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti qc-anat [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Reports the SNR, CNR, CJV, and FBER of an anatomical image, with the")
+		fmt.Fprintln(fs.Output(), "statistics of the regions they are computed in. The background is the air")
+		fmt.Fprintln(fs.Output(), "around the head, the brain mask is estimated unless -mask is given, and")
+		fmt.Fprintln(fs.Output(), "gray and white matter are split from it by intensity. With -rois, the")
+		fmt.Fprintln(fs.Output(), "regions are written as labels: 1 background, 2 CSF, 3 GM, 4 WM.")
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "", "write the report to this file instead of stdout")
+	asJSON := fs.Bool("json", false, "write JSON instead of TSV")
+	maskName := fs.String("mask", "", "brain mask image")
+	t2 := fs.Bool("t2", false, "white matter is darker than gray matter, as in T2-weighted images")
+	margin := fs.Int("margin", 3, "voxels between the head and the background")
+	rois := fs.String("rois", "", "write the regions to this label image")
+	vol := fs.Int("t", 0, "volume index of a 4D image")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("qc-anat requires an input filename")
+	}
+	if *margin < 1 {
+		return usageError("-margin must be at least 1")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	values, err := volumeValues(img, *vol)
+	if err != nil {
+		return err
+	}
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	opts := qc.AnatOptions{T2: *t2, Margin: *margin}
+	if *maskName != "" {
+		if opts.Mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+	metrics, err := qc.Anat(values, dims, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	if *rois != "" {
+		r, err := qc.AnatRegions(values, dims, opts)
+		if err != nil {
+			return err
+		}
+		labels := make([]uint8, len(values))
+		for i := range labels {
+			if r.Background[i] {
+				labels[i] = 1
+			} else if r.Tissue[i] != qc.TissueNone {
+				labels[i] = uint8(r.Tissue[i] + 1)
+			}
+		}
+		if err := writeUint8(*rois, img, labels, dims[:]...); err != nil {
+			return err
+		}
+	}
+	if err := writeMetrics(*out, fs.Arg(0), metrics, *asJSON); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"input":  fs.Arg(0),
+		"output": *out,
+	}).Info("Computed anatomical quality metrics")
+	return nil
+}
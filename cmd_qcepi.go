@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/qc"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runQCEPI reports the quality metrics of a functional series.
+func runQCEPI(args []string) error {
+	fs := newFlagSet("qc-epi")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti qc-epi [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Reports the ghost-to-signal ratio, temporal SNR, mean DVARS, and counts of")
+		fmt.Fprintln(fs.Output(), "outlier volumes and slices of a 4D EPI series. The brain mask is estimated")
+		fmt.Fprintln(fs.Output(), "from the mean volume unless -mask is given.")
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "", "write the report to this file instead of stdout")
+	asJSON := fs.Bool("json", false, "write JSON instead of TSV")
+	maskName := fs.String("mask", "", "brain mask image")
+	spikeZ := fs.Float64("spike-z", 3, "z threshold of outlier volumes")
+	sliceZ := fs.Float64("slice-z", 5, "robust z threshold of outlier slices")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("qc-epi requires a 4D image")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	opts := qc.EPIOptions{SpikeZ: *spikeZ, SliceZ: *sliceZ}
+	if *maskName != "" {
+		if opts.Mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+	metrics, err := qc.EPI(img, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	if err := writeMetrics(*out, fs.Arg(0), metrics, *asJSON); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"input":  fs.Arg(0),
+		"output": *out,
+	}).Info("Computed EPI quality metrics")
+	return nil
+}
+
+// writeMetrics writes QC metrics as TSV or JSON to a file, or to stdout if
+// name is empty.
+func writeMetrics(name, input string, metrics []qc.Metric, asJSON bool) error {
+	write := func(w io.Writer) error {
+		if !asJSON {
+			return qc.WriteTSV(w, metrics)
+		}
+		b, err := json.MarshalIndent(struct {
+			Input   string      `json:"input"`
+			Metrics []qc.Metric `json:"metrics"`
+		}{input, metrics}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+	if name == "" {
+		return write(os.Stdout)
+	}
+	a := beginAudit()
+	if err := util.WriteAtomic(name, write); err != nil {
+		return err
+	}
+	return a.commit(name)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/segment"
+	log "github.com/sirupsen/logrus"
+)
+
+// runQuickbet writes an approximate brain mask.
+func runQuickbet(args []string) error {
+	fs := newFlagSet("quickbet")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti quickbet [flags] <input> <mask>")
+		fs.PrintDefaults()
+	}
+	erode := fs.Int("erode", 2, "voxels to erode before selecting the largest component")
+	dilate := fs.Int("dilate", 1, "extra margin in voxels added to the mask")
+	brain := fs.String("brain", "", "also write the masked image to this file")
+	t := fs.Int("t", 0, "volume index of a 4D image")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("quickbet requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	values, err := volumeValues(img, *t)
+	if err != nil {
+		return err
+	}
+
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	mask, err := segment.QuickBet(values, dims, segment.QuickBetOptions{Erode: *erode, Dilate: *dilate})
+	if err != nil {
+		return err
+	}
+
+	out := make([]uint8, len(mask))
+	n := 0
+	for i, in := range mask {
+		if in {
+			out[i] = 1
+			n++
+		}
+	}
+	if err := writeUint8(fs.Arg(1), img, out, dims[:]...); err != nil {
+		return err
+	}
+
+	if *brain != "" {
+		masked := make([]float64, len(values))
+		for i, in := range mask {
+			if in {
+				masked[i] = values[i]
+			}
+		}
+		if err := writeFloat32(*brain, img, masked, dims[:]...); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"voxels":   n,
+		"volumeML": nifti1.MM3ToML(img.VolumeMM3(n)),
+		"output":   fs.Arg(1),
+	}).Info("Wrote brain mask")
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/register"
+	"github.com/kaczmarj/gonifti/transform"
+	log "github.com/sirupsen/logrus"
+)
+
+// runRegister registers a moving image to a fixed image with a rigid or
+// affine transform, and writes the matrix and the resampled moving image.
+func runRegister(args []string) error {
+	fs := newFlagSet("register")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti register [flags] <fixed> <moving>")
+		fmt.Fprintln(fs.Output(), "The matrix maps fixed world coordinates (mm) to moving world coordinates.")
+		fs.PrintDefaults()
+	}
+	dof := fs.Int("dof", register.DefaultOptions.DOF, "degrees of freedom: 6 (rigid) or 12 (affine)")
+	cost := fs.String("cost", register.DefaultOptions.Cost, "cost function: corr (same modality) or nmi (any modality)")
+	levels := fs.String("levels", "4,2,1", "downsampling factors, coarse to fine")
+	bins := fs.Int("bins", register.DefaultOptions.Bins, "histogram bins of nmi")
+	vol := fs.Int("t", 0, "volume index of 4D images to register")
+	matrixName := fs.String("matrix", "", "write the matrix to this file instead of stdout (.json for a transform chain)")
+	outName := fs.String("out", "", "write the moving image resampled onto the fixed grid (all volumes)")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("register requires a fixed and a moving image")
+	}
+	o := register.DefaultOptions
+	o.DOF, o.Cost, o.Bins = *dof, *cost, *bins
+	var err error
+	if o.Levels, err = parseLevels(*levels); err != nil {
+		return usageError(err.Error())
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	fixedImg, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	movingImg, err := nifti1.ReadFile(fs.Arg(1), ropts...)
+	if err != nil {
+		return err
+	}
+	fixed, err := register.FromImage(fixedImg, *vol)
+	if err != nil {
+		return err
+	}
+	moving, err := register.FromImage(movingImg, *vol)
+	if err != nil {
+		return err
+	}
+
+	res, err := register.Affine(fixed, moving, o)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"cost":   res.Cost,
+		"evals":  res.Evals,
+		"params": res.Params,
+	}).Info("Registered images")
+
+	t := transform.New(transform.Affine(res.Matrix))
+	if *matrixName == "" {
+		fmt.Print(transform.FormatMatrix(res.Matrix))
+	} else {
+		a := beginAudit()
+		if err := t.WriteFile(*matrixName); err != nil {
+			return err
+		}
+		if err := a.commit(*matrixName); err != nil {
+			return err
+		}
+	}
+
+	if *outName != "" {
+		out, err := t.Resample(movingImg, fixedImg, interp.Options{Method: interp.Linear})
+		if err != nil {
+			return err
+		}
+		if err := writeImage(out, *outName); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"output": *outName,
+		}).Info("Wrote resampled image")
+	}
+
+	return nil
+}
+
+// parseLevels parses comma-separated downsampling factors.
+func parseLevels(s string) ([]int, error) {
+	var levels []int
+	for _, p := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid level %q in %q", p, s)
+		}
+		levels = append(levels, n)
+	}
+	return levels, nil
+}
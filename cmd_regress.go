@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	log "github.com/sirupsen/logrus"
+)
+
+// runRegress regresses confounds out of the voxel time series of a 4D
+// image.
+func runRegress(args []string) error {
+	fs := newFlagSet("regress")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti regress [flags] <input> [<confounds.tsv>] <output>")
+		fmt.Fprintln(fs.Output(), "The confounds file has a header row and one row per volume. It may be left")
+		fmt.Fprintln(fs.Output(), "out with -slice-confounds.")
+		fs.PrintDefaults()
+	}
+	columns := fs.String("columns", "", "comma-separated confound columns or patterns, e.g. trans_*,rot_* (default: all)")
+	maskName := fs.String("mask", "", "clean only the voxels in this mask image")
+	sliceConfounds := fs.String("slice-confounds", "", "also regress slice-specific confounds from this table, such as the output of gonifti physio")
+	keepMean := fs.Bool("keep-mean", false, "keep the mean of each time series")
+	workers := fs.Int("workers", cfg.Workers, "number of goroutines cleaning voxels")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 && !(fs.NArg() == 2 && *sliceConfounds != "") {
+		fs.Usage()
+		return usageError("regress requires an input, a confounds file, and an output filename")
+	}
+	output := fs.Arg(fs.NArg() - 1)
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	var cols []string
+	if *columns != "" {
+		cols = strings.Split(*columns, ",")
+	}
+	var c temporal.Confounds
+	if fs.NArg() == 3 {
+		if c, err = temporal.ReadConfounds(fs.Arg(1), cols); err != nil {
+			return err
+		}
+	}
+	var slices []temporal.Confounds
+	if *sliceConfounds != "" {
+		if slices, err = temporal.ReadSliceConfounds(*sliceConfounds); err != nil {
+			return err
+		}
+	}
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+
+	// The slices are along dim_info's slice dimension, or k if unset.
+	sliceDim := img.SliceDim
+	if sliceDim == 0 {
+		sliceDim = 3
+	}
+	out, err := temporal.RegressSlices(img, c, slices, sliceDim, mask, *keepMean, *workers)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	if err := writeImage(out, output); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"confounds": c.Names,
+		"slices":    len(slices),
+		"output":    output,
+	}).Info("Wrote cleaned image")
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	log "github.com/sirupsen/logrus"
+)
+
+// runReHo writes the regional homogeneity map of a 4D image.
+func runReHo(args []string) error {
+	fs := newFlagSet("reho")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti reho [flags] <input> <output>")
+		fs.PrintDefaults()
+	}
+	neighbors := fs.Int("neighbors", 27, "voxels in the neighborhood: 7, 19, or 27")
+	maskName := fs.String("mask", "", "compute only within this mask image")
+	workers := fs.Int("workers", cfg.Workers, "number of goroutines processing voxels")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("reho requires an input and an output filename")
+	}
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+
+	reho, err := temporal.ReHo(img, *neighbors, mask, *workers)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	if err := writeFloat32(fs.Arg(1), img, reho, img.Nx, img.Ny, img.Nz); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"neighbors": *neighbors,
+		"output":    fs.Arg(1),
+	}).Info("Wrote ReHo map")
+
+	return nil
+}
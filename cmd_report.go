@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/report"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runReport writes the QC report of an image.
+func runReport(args []string) error {
+	d := report.DefaultOptions
+	fs := newFlagSet("report")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti report [flags] <input> <output.html>")
+		fmt.Fprintln(fs.Output(), "Writes a self-contained HTML page with the quality metrics, slice montages,")
+		fmt.Fprintln(fs.Output(), "and intensity histogram of an image: those of qc-epi for a 4D series and")
+		fmt.Fprintln(fs.Output(), "of qc-anat otherwise. The page is rendered with a Go template: -template")
+		fmt.Fprintln(fs.Output(), "names an HTML template, or a Markdown one if it ends in .md, and")
+		fmt.Fprintln(fs.Output(), "-print-template prints a built-in one to start from.")
+		fs.PrintDefaults()
+	}
+	subject := fs.String("subject", "", "title of the report (default: the BIDS subject and session, or the file name)")
+	maskName := fs.String("mask", "", "brain mask image")
+	t2 := fs.Bool("t2", false, "white matter is darker than gray matter, as in T2-weighted images")
+	slices := fs.Int("slices", d.Slices, "slices in each montage")
+	columns := fs.Int("columns", d.Columns, "tiles across each montage")
+	scale := fs.Int("scale", d.Scale, "enlarge the montages by this factor")
+	templateName := fs.String("template", cfg.ReportTemplate, "render the report with this template file")
+	markdown := fs.Bool("markdown", false, "render the report with the built-in Markdown template instead of -template")
+	printTemplate := fs.String("print-template", "", "print the built-in html or markdown template and exit")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	switch *printTemplate {
+	case "":
+	case "html":
+		fmt.Print(report.DefaultHTML)
+		return nil
+	case "markdown":
+		fmt.Print(report.DefaultMarkdown)
+		return nil
+	default:
+		return usageError(fmt.Sprintf("unknown template %q", *printTemplate))
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("report requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	tmpl := report.HTMLTemplate
+	if *markdown {
+		tmpl = report.MarkdownTemplate
+	} else if *templateName != "" {
+		// Parse errors are reported before the metrics are computed.
+		if tmpl, err = report.ReadTemplate(*templateName); err != nil {
+			return err
+		}
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	opts := d
+	opts.Subject, opts.T2 = *subject, *t2
+	opts.Slices, opts.Columns, opts.Scale = *slices, *columns, *scale
+	if *maskName != "" {
+		if opts.Mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+	r, err := report.New(img, fs.Arg(0), opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+
+	// The report is rendered first, so that a template that fails to
+	// execute leaves no partial output.
+	var b bytes.Buffer
+	if err := r.Execute(&b, tmpl); err != nil {
+		return err
+	}
+	a := beginAudit(*templateName)
+	if err := util.WriteFileAtomic(fs.Arg(1), b.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := a.commit(fs.Arg(1)); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"input":  fs.Arg(0),
+		"output": fs.Arg(1),
+	}).Info("Wrote report")
+	return nil
+}
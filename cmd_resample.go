@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/transform"
+	log "github.com/sirupsen/logrus"
+)
+
+// runResample resamples an image to isotropic voxels.
+func runResample(args []string) error {
+	fs := newFlagSet("resample")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti resample -voxel <mm> [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Resamples to cubic voxels over the same field of view, smoothing first")
+		fmt.Fprintln(fs.Output(), "along axes that are downsampled so that the result does not alias.")
+		fs.PrintDefaults()
+	}
+	size := fs.Float64("voxel", 0, "output voxel size in mm")
+	method := fs.String("interp", interp.Linear, "interpolation: "+strings.Join(interp.Methods, ", ")+"; nearest for labels, which are not smoothed")
+	boundary := fs.String("boundary", "clamp", "values beyond the input grid: clamp or mirror")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || *size <= 0 {
+		fs.Usage()
+		return usageError("resample requires -voxel, an input, and an output filename")
+	}
+	o := interp.Options{Method: *method}
+	var err error
+	if o.Boundary, err = interp.ParseBoundary(*boundary); err != nil {
+		return usageError(err.Error())
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	out, err := transform.ResampleIsotropic(img, *size, o)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	if err := writeImage(out, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dims":   out.Dim[1 : out.NDim+1],
+		"output": fs.Arg(1),
+	}).Info("Wrote resampled image")
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/render"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseVector parses a world coordinate or direction "x,y,z".
+func parseVector(s string) ([3]float64, error) {
+	var v [3]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("vector %q must be x,y,z", s)
+	}
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return v, fmt.Errorf("invalid component %q in %q", p, s)
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+// runReslice renders an oblique plane or slab to a PNG file.
+func runReslice(args []string) error {
+	fs := newFlagSet("reslice")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti reslice [flags] <input> <output.png>")
+		fmt.Fprintln(fs.Output(), "Renders the plane through -point perpendicular to -normal, both in world")
+		fmt.Fprintln(fs.Output(), "coordinates, as seen from the side the normal points to. With -thickness,")
+		fmt.Fprintln(fs.Output(), "the samples across a slab are combined by -slab.")
+		fs.PrintDefaults()
+	}
+	point := fs.String("point", "", "world coordinates x,y,z in mm of the center of the view (default: center of the image)")
+	normal := fs.String("normal", "0,0,1", "world direction x,y,z perpendicular to the plane")
+	up := fs.String("up", "", "world direction x,y,z towards the top of the view (default: superior, or anterior for axial planes)")
+	width := fs.Float64("width", 0, "width of the view in mm (default: the whole image)")
+	height := fs.Float64("height", 0, "height of the view in mm (default: the whole image)")
+	spacing := fs.Float64("spacing", 0, "pixel size in mm (default: the smallest voxel size)")
+	thickness := fs.Float64("thickness", 0, "slab thickness in mm")
+	slab := fs.String("slab", render.SlabMean, "combination across a slab: mean, max, or min")
+	method := fs.String("interp", interp.Linear, "interpolation: "+strings.Join(interp.Methods, ", "))
+	t := fs.Int("t", 0, "volume index of a 4D image")
+	scale := fs.Int("scale", 1, "integer upsampling factor")
+	window := fs.String("window", "auto", "window preset: full, auto, or symmetric")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("reslice requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	preset, err := render.ParseWindowPreset(*window)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	o := render.ObliqueOptions{
+		Width:     *width,
+		Height:    *height,
+		Spacing:   *spacing,
+		Thickness: *thickness,
+		Slab:      *slab,
+		Method:    *method,
+	}
+	if o.Normal, err = parseVector(*normal); err != nil {
+		return usageError(err.Error())
+	}
+	if *up != "" {
+		if o.Up, err = parseVector(*up); err != nil {
+			return usageError(err.Error())
+		}
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if *point != "" {
+		if o.Point, err = parseVector(*point); err != nil {
+			return usageError(err.Error())
+		}
+	} else {
+		o.Point = img.VoxelToWorld(float64(img.Nx-1)/2, float64(img.Ny-1)/2, float64(img.Nz-1)/2)
+	}
+
+	p, err := render.Oblique(img, *t, o)
+	if err != nil {
+		return err
+	}
+	p.SetWindow(preset)
+	a := beginAudit()
+	if err := render.WritePNG(fs.Arg(1), p.Upsample(*scale)); err != nil {
+		return err
+	}
+	if err := a.commit(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"point":  o.Point,
+		"normal": o.Normal,
+		"size":   fmt.Sprintf("%dx%d", p.W, p.H),
+		"window": p.Window,
+		"output": fs.Arg(1),
+	}).Info("Wrote oblique slice")
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runROI extracts a range of volumes given in seconds.
+func runROI(args []string) error {
+	fs := newFlagSet("roi")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti roi [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Keeps the volumes acquired from -tmin-sec for -duration-sec seconds, counting")
+		fmt.Fprintln(fs.Output(), "from toffset. Volume indices are computed from the repetition time in the")
+		fmt.Fprintln(fs.Output(), "time units of the header; toffset of the output is moved to its first volume.")
+		fs.PrintDefaults()
+	}
+	tmin := fs.Float64("tmin-sec", 0, "time in seconds of the first volume to keep")
+	duration := fs.Float64("duration-sec", 0, "seconds of volumes to keep (default: to the end)")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("roi requires an input and an output filename")
+	}
+	if *duration < 0 {
+		return usageError("-duration-sec must not be negative")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if img.TimeUnits == 0 {
+		log.WithFields(log.Fields{
+			"file": fs.Arg(0),
+		}).Warn("Time units are not set; assuming seconds")
+	}
+	start, n, err := img.VolumeRange(*tmin, *duration)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	out, err := img.CropVolumes(start, n)
+	if err != nil {
+		return err
+	}
+	if err := writeImage(out, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"first":   start,
+		"volumes": n,
+		"tr":      img.RepetitionTime(),
+		"output":  fs.Arg(1),
+	}).Info("Wrote volumes")
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// roiStats accumulates the statistics of one label.
+type roiStats struct {
+	n          int
+	sum, sumSq numeric.Accumulator
+	min, max   float64
+	// atLo and atHi count the stored values at the limits of the
+	// datatype.
+	atLo, atHi int
+}
+
+// runRoistats prints per-label voxel counts, volumes, and intensity
+// statistics of an image within a label (or binary mask) image.
+func runRoistats(args []string) error {
+	fs := newFlagSet("roistats")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti roistats [flags] <image> <labels>")
+		fs.PrintDefaults()
+	}
+	vol := fs.Int("t", 0, "volume index of a 4D image")
+	saturation := fs.Bool("saturation", false, "add the numbers of voxels whose stored values are at the minimum and maximum of the datatype")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("roistats requires an image and a label image")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	labels, err := nifti1.ReadFile(fs.Arg(1), ropts...)
+	if err != nil {
+		return err
+	}
+	if !nifti1.SameGrid(img, labels) {
+		return fmt.Errorf("image and labels: %w", nifti1.ErrGridMismatch)
+	}
+
+	nxyz := img.Nx * img.Ny * img.Nz
+	if *vol < 0 || (*vol+1)*nxyz > img.NVox {
+		return fmt.Errorf("volume index %d out of range", *vol)
+	}
+
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+	values = values[*vol*nxyz : (*vol+1)*nxyz]
+
+	var raw func(i int) float64
+	var lo, hi float64
+	if *saturation {
+		var ok bool
+		if lo, hi, ok = nifti1.DataTypeRange(img.DataType); !ok {
+			return fmt.Errorf("-saturation: %w datatype %s", nifti1.ErrUnsupported, nifti1.DataTypeString(img.DataType))
+		}
+		if raw, err = img.Float64Func(); err != nil {
+			return err
+		}
+	}
+
+	lv, err := labels.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+
+	stats := map[int]*roiStats{}
+	for i, v := range values {
+		l := int(math.Round(lv[i]))
+		if l == 0 {
+			continue
+		}
+		s, ok := stats[l]
+		if !ok {
+			s = &roiStats{min: math.Inf(1), max: math.Inf(-1)}
+			stats[l] = s
+		}
+		s.n++
+		s.sum.Add(v)
+		s.sumSq.AddProduct(v, v)
+		s.min = math.Min(s.min, v)
+		s.max = math.Max(s.max, v)
+		if raw != nil {
+			r := raw(*vol*nxyz + i)
+			if r <= lo {
+				s.atLo++
+			}
+			if r >= hi {
+				s.atHi++
+			}
+		}
+	}
+
+	keys := make([]int, 0, len(stats))
+	for l := range stats {
+		keys = append(keys, l)
+	}
+	sort.Ints(keys)
+
+	header := "label\tvoxels\tvolume_mm3\tvolume_ml\tmean\tstd\tmin\tmax"
+	if *saturation {
+		header += "\tat_min\tat_max"
+	}
+	fmt.Println(header)
+	for _, l := range keys {
+		s := stats[l]
+		volMM3 := img.VolumeMM3(s.n)
+		mean := s.sum.Sum() / float64(s.n)
+		std := math.Sqrt(math.Max(s.sumSq.Sum()/float64(s.n)-mean*mean, 0))
+		fmt.Printf("%d\t%d\t%.3f\t%.3f\t%g\t%g\t%g\t%g",
+			l, s.n, volMM3, nifti1.MM3ToML(volMM3), mean, std, s.min, s.max)
+		if *saturation {
+			fmt.Printf("\t%d\t%d", s.atLo, s.atHi)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/similarity"
+)
+
+// runSimilarity prints similarity measures between two volumes.
+func runSimilarity(args []string) error {
+	fs := newFlagSet("similarity")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti similarity [flags] <image A> <image B>")
+		fs.PrintDefaults()
+	}
+	maskName := fs.String("mask", "", "compare only voxels in this mask")
+	bins := fs.Int("bins", similarity.DefaultBins, "histogram bins for mutual information")
+	vol := fs.Int("t", 0, "volume index of 4D images")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("similarity requires two images")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	a, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	b, err := nifti1.ReadFile(fs.Arg(1), ropts...)
+	if err != nil {
+		return err
+	}
+	if !nifti1.SameGrid(a, b) {
+		return fmt.Errorf("%s and %s: %w", fs.Arg(0), fs.Arg(1), nifti1.ErrGridMismatch)
+	}
+	va, err := volumeValues(a, *vol)
+	if err != nil {
+		return err
+	}
+	vb, err := volumeValues(b, *vol)
+	if err != nil {
+		return err
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, a, ropts); err != nil {
+			return err
+		}
+	}
+
+	r, err := similarity.Correlation(va, vb, mask)
+	if err != nil {
+		return err
+	}
+	nmi, err := similarity.NormalizedMutualInformation(va, vb, mask, *bins)
+	if err != nil {
+		return err
+	}
+	ssim, err := similarity.SSIM(va, vb, [3]int{a.Nx, a.Ny, a.Nz}, mask)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("metric\tvalue")
+	fmt.Printf("correlation\t%.6f\n", r)
+	fmt.Printf("nmi\t%.6f\n", nmi)
+	fmt.Printf("ssim\t%.6f\n", ssim)
+
+	return nil
+}
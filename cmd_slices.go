@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/render"
+	"github.com/kaczmarj/gonifti/temporal"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runSlices flags slices of a 4D image whose mean or variance stands out
+// from the same slice in other volumes.
+func runSlices(args []string) error {
+	fs := newFlagSet("slices")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti slices [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Writes a TSV of the mean and variance of every slice of every volume, with")
+		fmt.Fprintln(fs.Output(), "their robust z-scores across volumes, to find dropped or spiking slices.")
+		fmt.Fprintln(fs.Output(), "Slices are taken along the slice dimension of dim_info, or z. With -png,")
+		fmt.Fprintln(fs.Output(), "also draws a heatmap of the larger |z| with volumes across and slices up.")
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "", "write the table to this file instead of stdout")
+	pngName := fs.String("png", "", "write the heatmap to this PNG file")
+	threshold := fs.Float64("z", 5, "flag slices whose |z| of the mean or variance exceeds this")
+	scale := fs.Int("scale", 4, "pixels per volume and slice in the heatmap")
+	maskName := fs.String("mask", "", "compute only within this mask image (recommended: a brain mask)")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("slices requires a 4D image")
+	}
+	if *threshold <= 0 {
+		return usageError("-z must be positive")
+	}
+	if *scale < 1 {
+		return usageError("-scale must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+	m, err := temporal.SliceSignal(img, mask, *threshold)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	outliers := 0
+	for _, o := range m.Outliers {
+		if o {
+			outliers++
+		}
+	}
+
+	if *out == "" {
+		err = m.WriteTSV(os.Stdout)
+	} else {
+		a := beginAudit()
+		err = util.WriteAtomic(*out, func(f io.Writer) error {
+			return m.WriteTSV(f)
+		})
+		if err == nil {
+			err = a.commit(*out)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if *pngName != "" {
+		// White at twice the threshold, so that flagged slices stand out.
+		p := render.NewPlane(m.Volumes, m.Slices)
+		p.Mapping = render.Gray8
+		p.Window = [2]float64{0, 2 * *threshold}
+		for t := 0; t < m.Volumes; t++ {
+			for k := 0; k < m.Slices; k++ {
+				i := t*m.Slices + k
+				z := math.Max(math.Abs(m.MeanZ[i]), math.Abs(m.VarZ[i]))
+				if math.IsNaN(z) {
+					z = 0
+				}
+				p.Values[t+k*m.Volumes] = z
+			}
+		}
+		a := beginAudit()
+		if err := render.WritePNG(*pngName, p.Upsample(*scale)); err != nil {
+			return err
+		}
+		if err := a.commit(*pngName); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"volumes":  m.Volumes,
+		"slices":   m.Slices,
+		"outliers": outliers,
+		"output":   *out,
+		"png":      *pngName,
+	}).Info("Computed slice metrics")
+	return nil
+}
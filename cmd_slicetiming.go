@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// runSliceTiming prints or stores the BIDS SliceTiming of an image.
+func runSliceTiming(args []string) error {
+	fs := newFlagSet("slicetiming")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti slicetiming [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Prints the slice times of the image as a BIDS SliceTiming array, from")
+		fmt.Fprintln(fs.Output(), "slice_code and slice_duration or the repetition time, or sets them in a sidecar.")
+		fmt.Fprintln(fs.Output(), "With -multiband, the slices acquired together share their times.")
+		fs.PrintDefaults()
+	}
+	multiband := fs.Int("multiband", 1, "multiband (simultaneous multi-slice) factor")
+	sliceDim := fs.Int("slice-dim", 0, "slice dimension, 1, 2, or 3, overriding dim_info")
+	code := fs.String("slice-code", "", "slice order overriding the header, such as seq_inc or alt_inc2")
+	tr := fs.Float64("tr", 0, "repetition time in seconds overriding the header, when slice_duration is unset")
+	sidecar := fs.String("sidecar", "", "JSON sidecar in which to set SliceTiming and MultibandAccelerationFactor")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("slicetiming requires an input filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if *sliceDim != 0 {
+		if *sliceDim < 1 || *sliceDim > 3 {
+			return usageError(fmt.Sprintf("slice dimension %d must be 1, 2, or 3", *sliceDim))
+		}
+		img.SliceDim = *sliceDim
+		img.SliceStart, img.SliceEnd = 0, 0
+	}
+	if *code != "" {
+		if img.SliceCode, err = nifti1.ParseSliceCode(*code); err != nil {
+			return usageError(err.Error())
+		}
+	}
+	if *tr > 0 {
+		img.Dt = *tr / nifti1.TimeUnitsToSeconds(img.TimeUnits)
+	}
+	times, err := img.SliceTimes(nifti1.Multiband(*multiband))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+
+	if *sidecar == "" {
+		b, err := json.Marshal(map[string][]float64{"SliceTiming": bids.RoundSliceTiming(times)})
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	a := beginAudit(*sidecar)
+	if err := bids.WriteSliceTiming(*sidecar, times, *multiband); err != nil {
+		return err
+	}
+	if err := a.commit(*sidecar); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"input":     fs.Arg(0),
+		"sidecar":   *sidecar,
+		"multiband": *multiband,
+	}).Info("Wrote slice timing")
+	return nil
+}
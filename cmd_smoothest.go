@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/stats"
+)
+
+// runSmoothest prints the smoothness of a 4D residual image.
+func runSmoothest(args []string) error {
+	fs := newFlagSet("smoothest")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti smoothest [flags] <residuals>")
+		fs.PrintDefaults()
+	}
+	maskName := fs.String("mask", "", "estimate only within this mask image")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("smoothest requires a residual image")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	s, err := estimateSmoothness(img, *maskName, ropts)
+	if err != nil {
+		return err
+	}
+	vox := img.VoxelSizeMM()
+	fmt.Printf("DLH %g\n", s.DLH)
+	fmt.Printf("VOLUME %d\n", s.Voxels)
+	fmt.Printf("RESELS %g\n", s.FWHM[0]*s.FWHM[1]*s.FWHM[2])
+	fmt.Printf("FWHMvoxel %g %g %g\n", s.FWHM[0], s.FWHM[1], s.FWHM[2])
+	fmt.Printf("FWHMmm %g %g %g\n", s.FWHM[0]*vox[0], s.FWHM[1]*vox[1], s.FWHM[2]*vox[2])
+	return nil
+}
+
+// estimateSmoothness estimates the smoothness of a residual image within
+// an optional mask.
+func estimateSmoothness(img *nifti1.Image, maskName string, ropts []nifti1.ReadOption) (stats.Smoothness, error) {
+	var mask []bool
+	if maskName != "" {
+		var err error
+		if mask, err = readMask(maskName, img, ropts); err != nil {
+			return stats.Smoothness{}, err
+		}
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return stats.Smoothness{}, err
+	}
+	return stats.EstimateSmoothness(values, [3]int{img.Nx, img.Ny, img.Nz}, mask)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runSpikes flags outlier volumes of a 4D image by the z-scores of the
+// global signal and DVARS, and optionally despikes the image.
+func runSpikes(args []string) error {
+	fs := newFlagSet("spikes")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti spikes [flags] <input>")
+		fmt.Fprintln(fs.Output(), "Writes a TSV of the global signal, DVARS, their z-scores, the outlier flag,")
+		fmt.Fprintln(fs.Output(), "and one spike regressor (outlier00, outlier01, ...) per outlier volume.")
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "", "write the table to this file instead of stdout")
+	globalZ := fs.Float64("global-z", 3, "flag volumes whose global signal |z| exceeds this (0 to disable)")
+	dvarsZ := fs.Float64("dvars-z", 3, "flag volumes whose DVARS z exceeds this, and the volumes before them (0 to disable)")
+	despike := fs.String("despike", "", "write the image with outlier volumes interpolated to this file")
+	maskName := fs.String("mask", "", "compute only within this mask image (recommended: a brain mask)")
+	workers := fs.Int("workers", cfg.Workers, "number of goroutines processing voxels")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("spikes requires a 4D image")
+	}
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+	s, err := temporal.DetectSpikes(img, mask, *globalZ, *dvarsZ)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	outliers := 0
+	for _, o := range s.Outliers {
+		if o {
+			outliers++
+		}
+	}
+
+	if *out == "" {
+		err = s.WriteTSV(os.Stdout)
+	} else {
+		a := beginAudit()
+		err = util.WriteAtomic(*out, func(f io.Writer) error {
+			return s.WriteTSV(f)
+		})
+		if err == nil {
+			err = a.commit(*out)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"volumes":  len(s.Outliers),
+		"outliers": outliers,
+		"output":   *out,
+	}).Info("Detected spikes")
+
+	if *despike != "" {
+		clean, err := temporal.Despike(img, s.Outliers, mask, *workers)
+		if err != nil {
+			return err
+		}
+		if err := writeImage(clean, *despike); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"output":   *despike,
+			"outliers": outliers,
+		}).Info("Wrote despiked image")
+	}
+	return nil
+}
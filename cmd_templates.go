@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/templates"
+	log "github.com/sirupsen/logrus"
+)
+
+// runTemplates lists the standard-space templates and downloads them into
+// the cache.
+func runTemplates(args []string) error {
+	fs := newFlagSet("templates")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti templates [list]")
+		fmt.Fprintln(fs.Output(), "       gonifti templates fetch <name> ...")
+		fmt.Fprintln(fs.Output(), "       gonifti templates path <name>")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch fs.Arg(0) {
+	case "", "list":
+		fmt.Println("name\tcached")
+		for _, a := range templates.Assets() {
+			fmt.Printf("%s\t%v\n", a.Name, templates.Cached(a.Name))
+		}
+	case "fetch":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return usageError("templates fetch requires at least one name")
+		}
+		var failures batchErrors
+		for _, name := range fs.Args()[1:] {
+			path, err := templates.Path(name)
+			if err != nil {
+				failures.add(name, err)
+				continue
+			}
+			log.WithFields(log.Fields{
+				"name": name,
+				"path": path,
+			}).Info("Template is cached")
+		}
+		return failures.err(fs.NArg() - 1)
+	case "path":
+		if fs.NArg() != 2 {
+			fs.Usage()
+			return usageError("templates path requires a name")
+		}
+		path, err := templates.Path(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	default:
+		fs.Usage()
+		return usageError(fmt.Sprintf("unknown templates subcommand %q", fs.Arg(0)))
+	}
+	return nil
+}
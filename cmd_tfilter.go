@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/temporal"
+	log "github.com/sirupsen/logrus"
+)
+
+// runTFilter filters the voxel time series of a 4D image.
+func runTFilter(args []string) error {
+	fs := newFlagSet("tfilter")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti tfilter [flags] <input> <output>")
+		fmt.Fprintln(fs.Output(), "Give -highpass, -lowpass, or both for a band-pass filter.")
+		fs.PrintDefaults()
+	}
+	highpass := fs.Float64("highpass", 0, "remove frequencies below this cutoff in Hz")
+	lowpass := fs.Float64("lowpass", 0, "remove frequencies above this cutoff in Hz")
+	order := fs.Int("order", 2, "Butterworth filter order (applied forwards and backwards)")
+	tr := fs.Float64("tr", 0, "repetition time in seconds (default: pixdim[4] of the input)")
+	maskName := fs.String("mask", "", "filter only the voxels in this mask image")
+	keepMean := fs.Bool("keep-mean", false, "restore the mean of each time series after filtering")
+	workers := fs.Int("workers", cfg.Workers, "number of goroutines filtering voxels")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("tfilter requires an input and an output filename")
+	}
+	if *highpass == 0 && *lowpass == 0 {
+		return usageError("tfilter requires -highpass, -lowpass, or both")
+	}
+	if *workers < 1 {
+		return usageError("-workers must be positive")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if img.NVox/(img.Nx*img.Ny*img.Nz) < 2 {
+		return fmt.Errorf("%s: tfilter requires a 4D image", fs.Arg(0))
+	}
+	if *tr == 0 {
+		*tr = img.RepetitionTime()
+	}
+	f, err := temporal.Butterworth(*order, *tr, *highpass, *lowpass)
+	if err != nil {
+		return err
+	}
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+
+	out, err := temporal.FilterImage(img, f, mask, *keepMean, *workers)
+	if err != nil {
+		return err
+	}
+	if err := writeImage(out, fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"highpass": *highpass,
+		"lowpass":  *lowpass,
+		"tr":       *tr,
+		"output":   fs.Arg(1),
+	}).Info("Wrote filtered image")
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/segment"
+	log "github.com/sirupsen/logrus"
+)
+
+// runThreshold writes a mask (or, for multi-level Otsu, a class label image)
+// using an automatically selected threshold.
+func runThreshold(args []string) error {
+	fs := newFlagSet("threshold")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti threshold [flags] <input> <output>")
+		fs.PrintDefaults()
+	}
+	method := fs.String("method", "otsu", "otsu, multiotsu, percentile, or value")
+	classes := fs.Int("classes", 3, "number of classes for multiotsu")
+	p := fs.Float64("p", 50, "percentile for the percentile method")
+	value := fs.Float64("value", 0, "threshold for the value method")
+	t := fs.Int("t", 0, "volume index of a 4D image")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("threshold requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	values, err := volumeValues(img, *t)
+	if err != nil {
+		return err
+	}
+
+	var thresholds []float64
+	switch *method {
+	case "otsu":
+		thresholds, err = segment.MultiOtsu(values, 2)
+	case "multiotsu":
+		thresholds, err = segment.MultiOtsu(values, *classes)
+	case "percentile":
+		var v float64
+		v, err = segment.Percentile(values, *p)
+		thresholds = []float64{v}
+	case "value":
+		thresholds = []float64{*value}
+	default:
+		return usageError(fmt.Sprintf("unknown method %q", *method))
+	}
+	if err != nil {
+		return err
+	}
+	if len(thresholds) > 255 {
+		return errors.New("too many classes for a uint8 label image")
+	}
+
+	labels := segment.Classify(values, thresholds)
+	out := make([]uint8, len(labels))
+	for i, l := range labels {
+		out[i] = uint8(l)
+	}
+	if err := writeUint8(fs.Arg(1), img, out, img.Nx, img.Ny, img.Nz); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"method":     *method,
+		"thresholds": thresholds,
+		"output":     fs.Arg(1),
+	}).Info("Wrote thresholded image")
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/npy"
+	log "github.com/sirupsen/logrus"
+)
+
+// runToNpy writes the voxels of an image as a NumPy array.
+func runToNpy(args []string) error {
+	fs := newFlagSet("tonpy")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti tonpy [flags] <input> <output.npy>")
+		fmt.Fprintln(fs.Output(), "Writes the voxels as an array of shape (nx, ny, nz, ...), indexed as")
+		fmt.Fprintln(fs.Output(), "array[x, y, z, t] in either order. By default the stored values are")
+		fmt.Fprintln(fs.Output(), "written as they are, so nothing is lost.")
+		fs.PrintDefaults()
+	}
+	scaled := fs.Bool("scaled", false, "apply scl_slope and scl_inter, giving float64 unless -dtype is set")
+	dtype := fs.String("dtype", "", "element type, such as int16 or float32 (default: the stored datatype); integers are rounded and clipped")
+	order := fs.String("order", "F", "memory order: F, as in the file, or C")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("tonpy requires an input and an output filename")
+	}
+	opts := npy.ImageOptions{Scaled: *scaled}
+	switch strings.ToUpper(*order) {
+	case "F":
+		opts.Fortran = true
+	case "C":
+	default:
+		return usageError(fmt.Sprintf("order %q must be F or C", *order))
+	}
+	if *dtype != "" {
+		var err error
+		if opts.DataType, err = nifti1.ParseDataType(*dtype); err != nil {
+			return usageError(err.Error())
+		}
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	a, clipped, err := npy.ImageArray(img, opts)
+	if err != nil {
+		return err
+	}
+	if clipped > 0 {
+		log.WithFields(log.Fields{
+			"dtype":   a.Descr,
+			"clipped": clipped,
+		}).Warn("Values outside the range of the dtype were clipped")
+	}
+	audit := beginAudit()
+	if err := npy.WriteArray(fs.Arg(1), a); err != nil {
+		return err
+	}
+	if err := audit.commit(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"input":  fs.Arg(0),
+		"output": fs.Arg(1),
+		"dtype":  a.Descr,
+		"shape":  a.Shape,
+	}).Info("Wrote NumPy array")
+	return nil
+}
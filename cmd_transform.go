@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/transform"
+	log "github.com/sirupsen/logrus"
+)
+
+// runTransform resamples an image onto a reference grid through a chain of
+// transforms, or composes and inverts transforms.
+func runTransform(args []string) error {
+	fs := newFlagSet("transform")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti transform -t <transform>[,<transform>...] [flags] [<input> <reference> <output>]")
+		fmt.Fprintln(fs.Output(), "Transforms (4x4 matrix text, .json chains, or .nii/.nii.gz warp fields) map")
+		fmt.Fprintln(fs.Output(), "reference world coordinates (mm) towards the input and are applied in order.")
+		fs.PrintDefaults()
+	}
+	list := fs.String("t", "", "comma-separated transforms, applied in order")
+	method := fs.String("interp", interp.Linear, "interpolation: "+strings.Join(interp.Methods, ", "))
+	boundary := fs.String("boundary", "zero", "values beyond the input grid: zero, clamp, or mirror")
+	inverse := fs.Bool("inverse", false, "invert the composed transform")
+	convention := fs.String("convention", "", "convention of warp fields: ras, itk, or fsl (default: guessed from the layout)")
+	save := fs.String("save", "", "write the composed transform to this file (.json, or matrix text if affine)")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *list == "" || (fs.NArg() != 3 && !(fs.NArg() == 0 && *save != "")) {
+		fs.Usage()
+		return usageError("transform requires -t and an input, a reference, and an output, or -save")
+	}
+
+	o := interp.Options{Method: *method}
+	var err error
+	if o.Boundary, err = interp.ParseBoundary(*boundary); err != nil {
+		return usageError(err.Error())
+	}
+
+	t := transform.New()
+	for _, name := range strings.Split(*list, ",") {
+		var u *transform.Transform
+		if transform.IsWarp(name) {
+			w, err := transform.ReadWarp(name, *convention)
+			if err != nil {
+				return err
+			}
+			u = transform.New(w)
+		} else if u, err = transform.ReadFile(name); err != nil {
+			return err
+		}
+		t = t.Then(u)
+	}
+	auditRead(strings.Split(*list, ",")...)
+	if *inverse {
+		if t, err = t.Inverse(); err != nil {
+			return err
+		}
+	}
+	t = t.Simplify()
+
+	if *save != "" {
+		a := beginAudit()
+		if err := t.WriteFile(*save); err != nil {
+			return err
+		}
+		if err := a.commit(*save); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"output": *save,
+			"steps":  len(t.Steps),
+		}).Info("Wrote transform")
+	}
+	if fs.NArg() == 0 {
+		return nil
+	}
+
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	input, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	reference, err := nifti1.ReadFile(fs.Arg(1), ropts...)
+	if err != nil {
+		return err
+	}
+	out, err := t.Resample(input, reference, o)
+	if err != nil {
+		return err
+	}
+	if err := writeImage(out, fs.Arg(2)); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"output": fs.Arg(2),
+		"steps":  len(t.Steps),
+	}).Info("Wrote transformed image")
+	return nil
+}
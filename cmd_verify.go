@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// runVerify checks files against the checksums written by gonifti
+// checksum and reports the corrupted chunks.
+func runVerify(args []string) error {
+	fs := newFlagSet("verify")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti verify [flags] <file>...")
+		fmt.Fprintln(fs.Output(), "Checks each file against its "+sidecarSuffix+" sidecar and the checksums")
+		fmt.Fprintln(fs.Output(), "embedded in its extensions, and prints the byte ranges that differ.")
+		fs.PrintDefaults()
+	}
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return usageError("verify requires at least one file")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	var failures batchErrors
+	for _, name := range fs.Args() {
+		if err := verifyFile(name, ropts); err != nil {
+			failures.add(name, err)
+		}
+	}
+	return failures.err(fs.NArg())
+}
+
+// verifyFile prints the result of checking one file and returns the first
+// reason it failed.
+func verifyFile(name string, ropts []nifti1.ReadOption) error {
+	var failure error
+	checked := false
+	fail := func(err error) {
+		if failure == nil {
+			failure = err
+		}
+		fmt.Printf("%s: %v\n", name, err)
+	}
+	report := func(what string, c nifti1.Checksums, bad []int) {
+		checked = true
+		if len(bad) == 0 {
+			fmt.Printf("%s: %s: OK (%d chunks)\n", name, what, len(c.Sums))
+			return
+		}
+		for _, i := range bad {
+			start, end := c.Chunk(i)
+			fmt.Printf("%s: %s: chunk %d (bytes %d-%d) CORRUPTED\n", name, what, i, start, end)
+		}
+		if failure == nil {
+			failure = fmt.Errorf("%s: %d of %d chunks corrupted", what, len(bad), len(c.Sums))
+		}
+	}
+
+	if b, err := ioutil.ReadFile(name + sidecarSuffix); err == nil {
+		var c nifti1.Checksums
+		if err := json.Unmarshal(b, &c); err != nil {
+			fail(fmt.Errorf("%s%s: %v", name, sidecarSuffix, err))
+		} else if f, err := os.Open(name); err != nil {
+			fail(err)
+		} else {
+			bad, err := c.Verify(f)
+			f.Close()
+			if err != nil {
+				fail(err)
+			} else {
+				report("file", c, bad)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		fail(err)
+	}
+
+	if isImageName(name) {
+		img, err := nifti1.ReadFile(name, ropts...)
+		if err != nil {
+			fail(err)
+		} else if c, found := img.Checksums(); found {
+			bad, err := c.Verify(bytes.NewReader(img.Data))
+			if err != nil {
+				fail(err)
+			} else {
+				report("data", c, bad)
+			}
+		}
+	}
+
+	if failure == nil && !checked {
+		fail(errors.New("no checksums"))
+	}
+	return failure
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/features"
+)
+
+// runVersion prints the version and, optionally, the capabilities of the
+// build.
+func runVersion(args []string) error {
+	fs := newFlagSet("version")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti version [flags]")
+		fmt.Fprintln(fs.Output(), "Prints the version of gonifti. With -features, also prints the formats,")
+		fmt.Fprintln(fs.Output(), "datatypes, codecs, URL schemes, and optional features of this build.")
+		fs.PrintDefaults()
+	}
+	list := fs.Bool("features", false, "print the capabilities of this build")
+	asJSON := fs.Bool("json", false, "print the capabilities as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return usageError("version takes no arguments")
+	}
+
+	c := features.Capabilities()
+	if *asJSON {
+		b, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	fmt.Printf("gonifti %s %s %s\n", c.Version, c.GoVersion, c.Platform)
+	if !*list {
+		return nil
+	}
+
+	fmt.Println("\nFormats:")
+	for _, f := range c.Formats {
+		var modes []string
+		if f.Read {
+			modes = append(modes, "read")
+		}
+		if f.Write {
+			modes = append(modes, "write")
+		}
+		fmt.Printf("  %-18s %-11s %s\n", f.Name, strings.Join(modes, ","), strings.Join(f.Extensions, " "))
+	}
+	fmt.Println("\nDatatypes:")
+	for _, d := range c.DataTypes {
+		note := ""
+		if !d.Numeric {
+			note = "stored only"
+		}
+		fmt.Println(strings.TrimRight(fmt.Sprintf("  %-22s %4d  %s", d.Name, d.Code, note), " "))
+	}
+	fmt.Println("\nCodecs:")
+	for _, codec := range c.Codecs {
+		fmt.Println(strings.TrimRight(fmt.Sprintf("  %-6s %s", codec.Name, codec.Note), " "))
+	}
+	fmt.Printf("\nURL schemes: %s\n", strings.Join(c.Schemes, " "))
+	fmt.Println("\nFeatures:")
+	for _, name := range c.FeatureNames() {
+		state := "no"
+		if c.Has(name) {
+			state = "yes"
+		}
+		fmt.Printf("  %-6s %s\n", name, state)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/render"
+)
+
+// parseSliceSpec parses a slice given as "<axis>=<index>", e.g. "z=30". The
+// index is -1 if only the axis is given.
+func parseSliceSpec(s string) (render.Axis, int, error) {
+	parts := strings.SplitN(s, "=", 2)
+	axis, err := render.ParseAxis(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return axis, -1, nil
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid slice index %q", parts[1])
+	}
+	return axis, n, nil
+}
+
+// windowedSlice returns a Gray8 slice windowed with a preset computed from
+// the slice itself, and the window.
+func windowedSlice(img *nifti1.Image, axis render.Axis, index, t int, preset render.WindowPreset) (*render.Slice, [2]float64, error) {
+	s, err := render.NewSlice(img, axis, index, t, render.SliceOptions{Mapping: render.Gray8})
+	if err != nil {
+		return nil, [2]float64{}, err
+	}
+	b := s.Bounds()
+	values := make([]float64, 0, b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			values = append(values, s.Value(x, y))
+		}
+	}
+	w := render.ComputeWindow(values, preset)
+	s, err = render.NewSlice(img, axis, index, t, render.SliceOptions{Mapping: render.Gray8, Window: w})
+	return s, w, err
+}
+
+// terminalRows returns the number of pixel rows that keeps the physical
+// aspect ratio of a slice drawn cols pixels wide.
+func terminalRows(img *nifti1.Image, s *render.Slice, cols int) int {
+	d := img.VoxelSizeMM()
+	inPlane := map[render.Axis][2]int{render.AxisI: {1, 2}, render.AxisJ: {0, 2}, render.AxisK: {0, 1}}[s.Axis()]
+	du, dv := d[inPlane[0]], d[inPlane[1]]
+	if du == 0 || dv == 0 {
+		du, dv = 1, 1
+	}
+	b := s.Bounds()
+	return int(math.Max(1, math.Round(float64(cols)*float64(b.Dy())*dv/(float64(b.Dx())*du))))
+}
+
+// runView prints a slice to the terminal.
+func runView(args []string) error {
+	fs := newFlagSet("view")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti view [flags] <input>")
+		fs.PrintDefaults()
+	}
+	sliceSpec := fs.String("slice", "z", "slice as axis=index, e.g. z=30 (default: middle axial slice)")
+	t := fs.Int("t", 0, "volume index of a 4D image")
+	width := fs.Int("width", 80, "width in terminal columns")
+	mode := fs.String("mode", "blocks", "output: blocks (24-bit color), ascii, or sixel")
+	window := fs.String("window", "auto", "window preset: full, auto, or symmetric")
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("view requires an input filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+	axis, index, err := parseSliceSpec(*sliceSpec)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	preset, err := render.ParseWindowPreset(*window)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	if *width < 1 {
+		return usageError("width must be positive")
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	if index < 0 {
+		index = [3]int{img.Nx, img.Ny, img.Nz}[axis] / 2
+	}
+
+	s, _, err := windowedSlice(img, axis, index, *t, preset)
+	if err != nil {
+		return err
+	}
+	cols := *width
+	rows := func(c int) int { return terminalRows(img, s, c) }
+
+	switch *mode {
+	case "blocks":
+		return render.WriteBlocks(os.Stdout, render.Resize(s, cols, rows(cols)))
+	case "ascii":
+		// Character cells are about twice as tall as they are wide.
+		return render.WriteASCII(os.Stdout, render.Resize(s, cols, (rows(cols)+1)/2))
+	case "sixel":
+		px := cols * 8
+		return render.WriteSixel(os.Stdout, render.Resize(s, px, rows(px)))
+	}
+	return usageError(fmt.Sprintf("unknown mode %q", *mode))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/parquet"
+	log "github.com/sirupsen/logrus"
+)
+
+// runVoxels writes the voxels×time matrix of an image as a Parquet table with
+// one row per voxel: the voxel indices i, j, k, the world coordinates x, y, z
+// in mm, and one column t0, t1, ... per volume.
+func runVoxels(args []string) error {
+	fs := newFlagSet("voxels")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti voxels [flags] <input> <output.parquet>")
+		fs.PrintDefaults()
+	}
+	maskName := fs.String("mask", "", "only write voxels in this mask (default all voxels)")
+	compress := fs.Bool("gzip", true, "compress the pages with gzip")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return usageError("voxels requires an input and an output filename")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	img, err := nifti1.ReadFile(fs.Arg(0), ropts...)
+	if err != nil {
+		return err
+	}
+	nxyz := img.Nx * img.Ny * img.Nz
+	nt := img.NVox / nxyz
+
+	var mask []bool
+	if *maskName != "" {
+		if mask, err = readMask(*maskName, img, ropts); err != nil {
+			return err
+		}
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+
+	var index []int
+	for v := 0; v < nxyz; v++ {
+		if mask == nil || mask[v] {
+			index = append(index, v)
+		}
+	}
+
+	n := len(index)
+	ii, jj, kk := make([]int32, n), make([]int32, n), make([]int32, n)
+	xx, yy, zz := make([]float64, n), make([]float64, n), make([]float64, n)
+	for r, v := range index {
+		i, j, k := v%img.Nx, (v/img.Nx)%img.Ny, v/(img.Nx*img.Ny)
+		ii[r], jj[r], kk[r] = int32(i), int32(j), int32(k)
+		w := img.VoxelToWorld(float64(i), float64(j), float64(k))
+		xx[r], yy[r], zz[r] = w[0], w[1], w[2]
+	}
+	columns := []parquet.Column{
+		{Name: "i", Values: ii}, {Name: "j", Values: jj}, {Name: "k", Values: kk},
+		{Name: "x", Values: xx}, {Name: "y", Values: yy}, {Name: "z", Values: zz},
+	}
+	for t := 0; t < nt; t++ {
+		col := make([]float32, n)
+		for r, v := range index {
+			col[r] = float32(values[t*nxyz+v])
+		}
+		columns = append(columns, parquet.Column{Name: fmt.Sprintf("t%d", t), Values: col})
+	}
+
+	affine, err := json.Marshal(img.Affine())
+	if err != nil {
+		return err
+	}
+	opts := []parquet.Option{
+		parquet.Metadata("nifti.source", fs.Arg(0)),
+		parquet.Metadata("nifti.dim", fmt.Sprint(img.Dim[:img.NDim+1])),
+		parquet.Metadata("nifti.affine", string(affine)),
+	}
+	if *compress {
+		opts = append(opts, parquet.Gzip())
+	}
+	a := beginAudit()
+	if err := parquet.WriteFile(fs.Arg(1), columns, opts...); err != nil {
+		return err
+	}
+	if err := a.commit(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"voxels":  n,
+		"volumes": nt,
+		"output":  fs.Arg(1),
+	}).Info("Wrote voxel table")
+
+	return nil
+}
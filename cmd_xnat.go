@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/kaczmarj/gonifti/xnat"
+	log "github.com/sirupsen/logrus"
+)
+
+// runXnat lists sessions and scans on an XNAT server and fetches NIfTI
+// resources. The password is read from GONIFTI_XNAT_PASSWORD so that it does
+// not appear in the process list.
+func runXnat(args []string) error {
+	fs := newFlagSet("xnat")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gonifti xnat [flags] sessions <project>")
+		fmt.Fprintln(fs.Output(), "       gonifti xnat [flags] scans <session>")
+		fmt.Fprintln(fs.Output(), "       gonifti xnat [flags] get <session> <scan> <output>")
+		fmt.Fprintln(fs.Output(), "The password is read from GONIFTI_XNAT_PASSWORD.")
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", os.Getenv("GONIFTI_XNAT_SERVER"), "XNAT server URL")
+	user := fs.String("user", os.Getenv("GONIFTI_XNAT_USER"), "XNAT user name")
+	resource := fs.String("resource", "NIFTI", "scan resource holding the images")
+	addDryRunFlag(fs)
+	readOpts := addProfileFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	want := map[string]int{"sessions": 2, "scans": 2, "get": 4}
+	if n, ok := want[fs.Arg(0)]; !ok || fs.NArg() != n {
+		fs.Usage()
+		return usageError("xnat requires a subcommand and its arguments")
+	}
+	if *server == "" {
+		return usageError("xnat requires -server or GONIFTI_XNAT_SERVER")
+	}
+	ropts, err := readOpts()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	c := xnat.New(*server, *user, os.Getenv("GONIFTI_XNAT_PASSWORD"))
+	if *user != "" {
+		if err := c.Login(ctx); err != nil {
+			return err
+		}
+		defer c.Logout(ctx)
+	}
+
+	switch fs.Arg(0) {
+	case "sessions":
+		sessions, err := c.Sessions(ctx, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Println("id\tlabel\tsubject\tdate")
+		for _, s := range sessions {
+			fmt.Printf("%s\t%s\t%s\t%s\n", s.ID, s.Label, s.Subject, s.Date)
+		}
+	case "scans":
+		scans, err := c.Scans(ctx, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Println("id\ttype\tdescription\tquality")
+		for _, s := range scans {
+			fmt.Printf("%s\t%s\t%s\t%s\n", s.ID, s.Type, s.Description, s.Quality)
+		}
+	case "get":
+		files, err := c.Files(ctx, fs.Arg(1), fs.Arg(2), *resource)
+		if err != nil {
+			return err
+		}
+		var found *xnat.File
+		for i := range files {
+			if xnat.IsNifti(files[i].Name) {
+				found = &files[i]
+				break
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("scan %s of session %s has no NIfTI file in resource %s", fs.Arg(2), fs.Arg(1), *resource)
+		}
+		img, err := c.Open(ctx, *found, ropts...)
+		if err != nil {
+			return err
+		}
+		if err := writeImage(img, fs.Arg(3)); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"file":   found.Name,
+			"output": fs.Arg(3),
+		}).Info("Wrote XNAT scan")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// readMask reads a mask image on the grid of ref. Voxels of the first volume
+// with a non-zero value are in the mask.
+func readMask(filename string, ref *nifti1.Image, ropts []nifti1.ReadOption) ([]bool, error) {
+	m, err := nifti1.ReadFile(filename, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	if !nifti1.SameGrid(m, ref) {
+		return nil, fmt.Errorf("mask %s: %w", filename, nifti1.ErrGridMismatch)
+	}
+	values, err := m.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]bool, m.Nx*m.Ny*m.Nz)
+	for i := range mask {
+		mask[i] = values[i] != 0
+	}
+	return mask, nil
+}
+
+// writeFloat32 writes values on the grid of ref as a DT_FLOAT32 image. The
+// dims of ref are replaced with dims, if given.
+func writeFloat32(filename string, ref *nifti1.Image, values []float64, dims ...int) error {
+	out := *ref
+	if len(dims) > 0 {
+		if err := out.SetDims(dims...); err != nil {
+			return err
+		}
+	}
+	if err := out.SetFloat32Data(values); err != nil {
+		return err
+	}
+	return writeImage(&out, filename)
+}
+
+// writeUint8 writes values on the grid of ref as a DT_UINT8 image. The dims
+// of ref are replaced with dims, if given.
+func writeUint8(filename string, ref *nifti1.Image, values []uint8, dims ...int) error {
+	out := *ref
+	if len(dims) > 0 {
+		if err := out.SetDims(dims...); err != nil {
+			return err
+		}
+	}
+	if err := out.SetUint8Data(values); err != nil {
+		return err
+	}
+	return writeImage(&out, filename)
+}
+
+// volumeValues returns the scaled values of volume t of img.
+func volumeValues(img *nifti1.Image, t int) ([]float64, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	if t < 0 || (t+1)*nxyz > img.NVox {
+		return nil, fmt.Errorf("volume index %d out of range", t)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	return values[t*nxyz : (t+1)*nxyz], nil
+}
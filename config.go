@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/templates"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// settings are the defaults of the command-line flags. They are merged from,
+// in increasing order of precedence, built-in defaults, the config file, and
+// GONIFTI_* environment variables; flags given on the command line override
+// them all.
+type settings struct {
+	CompressionLevel int    // compression_level, GONIFTI_COMPRESSION_LEVEL
+	Workers          int    // workers, GONIFTI_WORKERS
+	PixDim           string // pixdim, GONIFTI_PIXDIM
+	CacheDir         string // cache_dir, GONIFTI_CACHE_DIR
+	AnnexGet         string // annex_get, GONIFTI_ANNEX_GET
+	TemplateFlowURL  string // templateflow_url, GONIFTI_TEMPLATEFLOW_URL
+	Deterministic    bool   // deterministic, GONIFTI_DETERMINISTIC
+	InflateToDiskMB  int    // inflate_to_disk_mb, GONIFTI_INFLATE_TO_DISK_MB
+	Retries          int    // retries, GONIFTI_RETRIES
+	CacheDerived     bool   // cache_derived, GONIFTI_CACHE_DERIVED
+	ReportTemplate   string // report_template, GONIFTI_REPORT_TEMPLATE
+	DataAlignment    int    // data_alignment, GONIFTI_DATA_ALIGNMENT
+	DirectIO         bool   // direct_io, GONIFTI_DIRECT_IO
+	MemoryLimitMB    int    // memory_limit_mb, GONIFTI_MEMORY_LIMIT_MB
+	SyncWrites       bool   // sync_writes, GONIFTI_SYNC_WRITES
+	MaxWorkers       int    // max_workers, GONIFTI_MAX_WORKERS
+	AuditLog         string // audit_log, GONIFTI_AUDIT_LOG
+}
+
+// cfg holds the settings loaded by main.
+var cfg = defaultSettings()
+
+func defaultSettings() settings {
+	cache := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cache = filepath.Join(dir, "gonifti")
+	}
+	return settings{
+		CompressionLevel: gzip.DefaultCompression,
+		Workers:          util.AvailableCPUs(),
+		PixDim:           nifti1.DefaultProfile.PixDim.String(),
+		CacheDir:         cache,
+		TemplateFlowURL:  templates.TemplateFlowURL,
+		Retries:          util.DefaultRetry.Retries,
+	}
+}
+
+// configPath returns the path of the config file: $GONIFTI_CONFIG if set,
+// otherwise gonifti/config.yaml in the user config directory (usually
+// ~/.config).
+func configPath() string {
+	if p := os.Getenv("GONIFTI_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gonifti", "config.yaml")
+}
+
+// loadSettings merges the defaults, the config file, and the environment.
+// A missing config file is not an error.
+func loadSettings() (settings, error) {
+	s := defaultSettings()
+
+	values := map[string]string{}
+	if p := configPath(); p != "" {
+		f, err := os.Open(p)
+		switch {
+		case err == nil:
+			values, err = parseConfig(f)
+			f.Close()
+			if err != nil {
+				return s, fmt.Errorf("%s: %v", p, err)
+			}
+			log.WithFields(log.Fields{
+				"file": p,
+			}).Debug("Read config file")
+		case !os.IsNotExist(err):
+			return s, err
+		}
+	}
+
+	for _, key := range []string{"compression_level", "workers", "pixdim", "cache_dir", "annex_get", "templateflow_url", "deterministic", "inflate_to_disk_mb", "retries", "cache_derived", "report_template", "data_alignment", "direct_io", "memory_limit_mb", "sync_writes", "max_workers", "audit_log"} {
+		if v, ok := os.LookupEnv("GONIFTI_" + strings.ToUpper(key)); ok {
+			values[key] = v
+		}
+	}
+
+	for key, v := range values {
+		var err error
+		switch key {
+		case "compression_level":
+			s.CompressionLevel, err = strconv.Atoi(v)
+			if err == nil && (s.CompressionLevel < gzip.HuffmanOnly || s.CompressionLevel > gzip.BestCompression) {
+				err = fmt.Errorf("must be in [%d, %d]", gzip.HuffmanOnly, gzip.BestCompression)
+			}
+		case "workers":
+			s.Workers, err = strconv.Atoi(v)
+			if err == nil && s.Workers < 1 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "pixdim":
+			_, err = nifti1.ParsePixDimRepair(v)
+			s.PixDim = v
+		case "cache_dir":
+			s.CacheDir = v
+		case "annex_get":
+			s.AnnexGet = v
+		case "templateflow_url":
+			s.TemplateFlowURL = v
+		case "deterministic":
+			s.Deterministic, err = strconv.ParseBool(v)
+		case "retries":
+			s.Retries, err = strconv.Atoi(v)
+			if err == nil && s.Retries < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "cache_derived":
+			s.CacheDerived, err = strconv.ParseBool(v)
+		case "report_template":
+			s.ReportTemplate = v
+		case "audit_log":
+			s.AuditLog = v
+		case "max_workers":
+			s.MaxWorkers, err = strconv.Atoi(v)
+			if err == nil && s.MaxWorkers < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "sync_writes":
+			s.SyncWrites, err = strconv.ParseBool(v)
+		case "memory_limit_mb":
+			s.MemoryLimitMB, err = strconv.Atoi(v)
+		case "direct_io":
+			s.DirectIO, err = strconv.ParseBool(v)
+		case "data_alignment":
+			s.DataAlignment, err = strconv.Atoi(v)
+			if err == nil && (s.DataAlignment < 0 || s.DataAlignment%16 != 0) {
+				err = fmt.Errorf("must be a multiple of 16")
+			}
+		case "inflate_to_disk_mb":
+			s.InflateToDiskMB, err = strconv.Atoi(v)
+			if err == nil && s.InflateToDiskMB < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		default:
+			log.WithFields(log.Fields{
+				"key": key,
+			}).Warn("Ignoring unknown config key")
+		}
+		if err != nil {
+			return s, fmt.Errorf("invalid %s %q: %v", key, v, err)
+		}
+	}
+	return s, nil
+}
+
+// parseConfig reads the subset of YAML used by the config file: one
+// "key: value" pair per line, with optional quotes around the value and
+// comments starting with '#'.
+func parseConfig(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n)
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values, sc.Err()
+}
+
+// writeImage writes an image with the configured compression level, data
+// alignment, and direct I/O, and records it in the audit log. Options given
+// by the caller take precedence.
+// With -dry-run, it prints what it would write instead, and with -backup, it
+// saves the header of the image it overwrites in place first.
+func writeImage(img *nifti1.Image, filename string, opts ...nifti1.WriteOption) error {
+	defaults := []nifti1.WriteOption{nifti1.CompressionLevel(cfg.CompressionLevel), nifti1.AlignData(cfg.DataAlignment)}
+	if cfg.DirectIO {
+		defaults = append(defaults, nifti1.DirectWrite())
+	}
+	opts = append(defaults, opts...)
+	if util.DryRun {
+		return dryRunWrite(img, filename, opts)
+	}
+	if backup && sameFile(img.FName, headerName(filename)) {
+		if err := saveHeaderBackup(filename); err != nil {
+			return err
+		}
+	}
+	a := beginAudit(img.FName)
+	if err := nifti1.WriteFile(img, filename, opts...); err != nil {
+		return err
+	}
+	return a.commit(filename)
+}
+
+// annexGetHook returns a hook that retrieves annexed content by running
+// command (e.g. "datalad get") with the path appended.
+func annexGetHook(command string) func(string) error {
+	return func(path string) error {
+		args := append(strings.Fields(command), path)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		return cmd.Run()
+	}
+}
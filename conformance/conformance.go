@@ -0,0 +1,300 @@
+// conformance checks gonifti against reference values of the NIfTI-1 C
+// library (nifti_clib, nifti1_io.c): datatype sizes, the packing of
+// dim_info and xyzt_units, header decoding in both byte orders, the
+// magic and data offset of single files with and without the extender, the
+// quaternion and affine conversions, and orientation codes and strings.
+// The vectors are regenerated from the algorithms and tables of nifti1_io.c
+// (nifti_datatype_sizes, nifti_quatern_to_mat44, nifti_mat44_to_quatern,
+// nifti_mat44_to_orientation, nifti_orientation_string) for inputs whose
+// results can be worked out by hand, so that they do not depend on gonifti
+// itself.
+
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing/fstest"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// tolerance is the largest difference allowed between real values, which
+// pass through the float32 fields of the header.
+const tolerance = 1e-5
+
+// Result is the outcome of one vector. Err is nil if gonifti agrees with
+// the reference.
+type Result struct {
+	Group string
+	Name  string
+	Err   error
+}
+
+// Run checks every vector.
+func Run() []Result {
+	var results []Result
+	add := func(group, name string, err error) {
+		results = append(results, Result{group, name, err})
+	}
+
+	for _, v := range datatypeVectors {
+		nbyper, swapsize := nifti1.DatatypeSize(v.code)
+		var err error
+		if nbyper != v.nbyper || swapsize != v.swapsize {
+			err = fmt.Errorf("got sizes (%d, %d), want (%d, %d)", nbyper, swapsize, v.nbyper, v.swapsize)
+		} else if name := nifti1.DataTypeString(v.code); name != v.name {
+			err = fmt.Errorf("got name %q, want %q", name, v.name)
+		}
+		add("datatype", v.name, err)
+	}
+
+	for _, v := range dimInfoVectors {
+		var err error
+		if got := nifti1.FPSIntoDimInfo(v.freq, v.phase, v.slice); got != v.packed {
+			err = fmt.Errorf("packed to %d, want %d", got, v.packed)
+		} else if f, p, s := nifti1.DimInfoToFPS(v.packed); f != v.freq || p != v.phase || s != v.slice {
+			err = fmt.Errorf("unpacked to (%d, %d, %d)", f, p, s)
+		}
+		add("dim_info", fmt.Sprintf("freq %d phase %d slice %d", v.freq, v.phase, v.slice), err)
+	}
+
+	for _, v := range xyztVectors {
+		var err error
+		if got := nifti1.SpaceTimeToXYZT(v.space, v.time); got != v.packed {
+			err = fmt.Errorf("packed to %d, want %d", got, v.packed)
+		} else if s, t := nifti1.XYZTToSpace(v.packed), nifti1.XYZTToTime(v.packed); s != v.space || t != v.time {
+			err = fmt.Errorf("unpacked to (%d, %d)", s, t)
+		}
+		add("xyzt_units", fmt.Sprintf("%s %s", nifti1.UnitsString(v.space), nifti1.UnitsString(v.time)), err)
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		add("header", order.String(), checkDecode(order))
+	}
+
+	for _, v := range layoutVectors {
+		add("layout", v.name, checkLayout(v))
+	}
+	for _, v := range magicVectors {
+		add("magic", v.name, checkMagic(v))
+	}
+
+	for _, v := range qformVectors {
+		add("quatern_to_mat44", v.name, checkQForm(v))
+	}
+	for _, v := range sformVectors {
+		add("mat44_to_quatern", v.name, checkSForm(v))
+	}
+
+	for code, want := range orientationStrings {
+		var err error
+		if got := nifti1.OrientationString(code); got != want {
+			err = fmt.Errorf("got %q, want %q", got, want)
+		}
+		add("orientation_string", fmt.Sprint(code), err)
+	}
+	return results
+}
+
+// referenceHeader returns a header with every field set.
+func referenceHeader() nifti1.Header {
+	h := nifti1.Header{
+		SizeOfHdr:     348,
+		DimInfo:       nifti1.FPSIntoDimInfo(1, 2, 3),
+		Dim:           [8]int16{4, 64, 64, 30, 120, 1, 1, 1},
+		IntentCode:    5,
+		DataType:      4,
+		BitPix:        16,
+		SliceEnd:      29,
+		SliceCode:     3,
+		PixDim:        [8]float32{-1, 3, 3, 4, 2, 1, 1, 1},
+		VoxOffset:     352,
+		SclSlope:      0.5,
+		SclInter:      -10,
+		XYZTUnits:     nifti1.SpaceTimeToXYZT(2, 8),
+		CalMax:        1000,
+		SliceDuration: 0.0625,
+		QFormCode:     1,
+		SFormCode:     4,
+		QuaternC:      1,
+		QOffsetX:      90,
+		QOffsetY:      -126,
+		QOffsetZ:      -72,
+		SRowX:         [4]float32{-3, 0, 0, 90},
+		SRowY:         [4]float32{0, 3, 0, -126},
+		SRowZ:         [4]float32{0, 0, 4, -72},
+	}
+	copy(h.Magic[:], []int8{'n', '+', '1', 0})
+	return h
+}
+
+// checkDecode encodes the reference header in a byte order and checks that
+// it is decoded to the same fields, with the byte order detected from
+// dim[0] as nifti_read_header does.
+func checkDecode(order binary.ByteOrder) error {
+	want := referenceHeader()
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, order, want); err != nil {
+		return err
+	}
+	buf.Write(make([]byte, 4)) // the extender
+	got, gotOrder, err := nifti1.DecodeHeader(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if gotOrder != order {
+		return fmt.Errorf("detected %v byte order", gotOrder)
+	}
+	if got != want {
+		return fmt.Errorf("decoded header differs:\n%v", got)
+	}
+	return nil
+}
+
+// layoutFile returns a single file with the reference header, a 2x2 int16
+// image, and its values. The data start at vox_offset, right after the
+// header if it is 348, which leaves out the extender, or after a zero
+// extender if it is 352.
+func layoutFile(voxOffset int) ([]byte, []float64, error) {
+	h := referenceHeader()
+	h.Dim = [8]int16{2, 2, 2, 1, 1, 1, 1, 1}
+	h.DimInfo, h.SliceEnd, h.SliceCode, h.SliceDuration = 0, 0, 0, 0
+	h.SclSlope, h.SclInter = 1, 0
+	h.VoxOffset = float32(voxOffset)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, h); err != nil {
+		return nil, nil, err
+	}
+	buf.Write(make([]byte, voxOffset-buf.Len()))
+	values := []float64{1, -2, 300, -4000}
+	for _, v := range values {
+		binary.Write(&buf, binary.LittleEndian, int16(v))
+	}
+	return buf.Bytes(), values, nil
+}
+
+// checkLayout checks that the data of a single file are read at vox_offset
+// both whole and volume by volume.
+func checkLayout(v layoutVector) error {
+	b, want, err := layoutFile(v.voxOffset)
+	if err != nil {
+		return err
+	}
+	img, err := nifti1.Read(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if img.INameOffset != v.voxOffset {
+		return fmt.Errorf("data offset %d, want %d", img.INameOffset, v.voxOffset)
+	}
+	got, err := img.Float64s()
+	if err != nil {
+		return err
+	}
+	if err := compareValues("read", got, want); err != nil {
+		return err
+	}
+
+	fsys := fstest.MapFS{"layout.nii": &fstest.MapFile{Data: b}}
+	r, err := nifti1.OpenVolumes("layout.nii", 0, nifti1.FromFS(fsys))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	got, err = r.Next()
+	if err != nil {
+		return err
+	}
+	return compareValues("streamed", got, want)
+}
+
+func compareValues(how string, got, want []float64) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("%s %d values, want %d", how, len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return fmt.Errorf("%s %v, want %v", how, got, want)
+		}
+	}
+	return nil
+}
+
+// checkMagic checks that a header with a bad magic or size is rejected
+// with the expected error.
+func checkMagic(v magicVector) error {
+	b, _, err := layoutFile(352)
+	if err != nil {
+		return err
+	}
+	v.edit(b)
+	_, _, err = nifti1.DecodeHeader(b)
+	if !errors.Is(err, v.err) {
+		return fmt.Errorf("got error %v, want %v", err, v.err)
+	}
+	return nil
+}
+
+// checkQForm builds the qform of a header and compares it and its
+// orientation to the reference.
+func checkQForm(v qformVector) error {
+	h := referenceHeader()
+	h.SFormCode = 0
+	h.QuaternB, h.QuaternC, h.QuaternD = float32(v.quatern[0]), float32(v.quatern[1]), float32(v.quatern[2])
+	h.QOffsetX, h.QOffsetY, h.QOffsetZ = float32(v.offset[0]), float32(v.offset[1]), float32(v.offset[2])
+	h.PixDim[0] = float32(v.qfac)
+	for i := 0; i < 3; i++ {
+		h.PixDim[i+1] = float32(v.pixdim[i])
+	}
+	img := nifti1.ConvertHeaderToImage(h, binary.LittleEndian)
+	if err := compareAffine(img.Affine(), v.affine); err != nil {
+		return err
+	}
+	if got := nifti1.OrientationLetters(img.QFormOrientation()); got != v.orientation {
+		return fmt.Errorf("orientation %s, want %s", got, v.orientation)
+	}
+	return nil
+}
+
+// checkSForm sets an affine as the sform and compares the quaternion
+// parameters derived from it to the reference.
+func checkSForm(v sformVector) error {
+	img := nifti1.ConvertHeaderToImage(referenceHeader(), binary.LittleEndian)
+	if err := img.SetAffine(v.affine, 2); err != nil {
+		return err
+	}
+	got := [3]float64{img.QuaternB, img.QuaternC, img.QuaternD}
+	for i := range got {
+		if math.Abs(got[i]-v.quatern[i]) > tolerance {
+			return fmt.Errorf("quatern_bcd %v, want %v", got, v.quatern)
+		}
+	}
+	if img.QFac != v.qfac {
+		return fmt.Errorf("qfac %g, want %g", img.QFac, v.qfac)
+	}
+	spacing := [3]float64{img.Dx, img.Dy, img.Dz}
+	for i := range spacing {
+		if math.Abs(spacing[i]-v.pixdim[i]) > tolerance {
+			return fmt.Errorf("pixdim %v, want %v", spacing, v.pixdim)
+		}
+	}
+	if got := nifti1.OrientationLetters(img.SFormOrientation()); got != v.orientation {
+		return fmt.Errorf("orientation %s, want %s", got, v.orientation)
+	}
+	return nil
+}
+
+func compareAffine(got, want [4][4]float64) error {
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			if math.Abs(got[i][j]-want[i][j]) > tolerance {
+				return fmt.Errorf("affine %v, want %v", got, want)
+			}
+		}
+	}
+	return nil
+}
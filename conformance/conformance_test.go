@@ -0,0 +1,26 @@
+package conformance
+
+import "testing"
+
+// TestConformance runs the vectors that gonifti conformance checks, one
+// subtest per vector, so that go test fails on any disagreement with
+// nifti_clib.
+func TestConformance(t *testing.T) {
+	results := Run()
+	groups := map[string]int{}
+	for _, r := range results {
+		r := r
+		groups[r.Group]++
+		t.Run(r.Group+"/"+r.Name, func(t *testing.T) {
+			if r.Err != nil {
+				t.Error(r.Err)
+			}
+		})
+	}
+	for _, group := range []string{"datatype", "dim_info", "xyzt_units", "header", "layout", "magic",
+		"quatern_to_mat44", "mat44_to_quatern", "orientation_string"} {
+		if groups[group] == 0 {
+			t.Errorf("no %s vectors", group)
+		}
+	}
+}
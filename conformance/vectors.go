@@ -0,0 +1,214 @@
+package conformance
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Sizes of the datatypes, from nifti_datatype_sizes.
+var datatypeVectors = []struct {
+	code             int
+	name             string
+	nbyper, swapsize int
+}{
+	{2, "NIFTI_TYPE_UINT8", 1, 0},
+	{4, "NIFTI_TYPE_INT16", 2, 2},
+	{8, "NIFTI_TYPE_INT32", 4, 4},
+	{16, "NIFTI_TYPE_FLOAT32", 4, 4},
+	{32, "NIFTI_TYPE_COMPLEX64", 8, 4},
+	{64, "NIFTI_TYPE_FLOAT64", 8, 8},
+	{128, "NIFTI_TYPE_RGB24", 3, 0},
+	{256, "NIFTI_TYPE_INT8", 1, 0},
+	{512, "NIFTI_TYPE_UINT16", 2, 2},
+	{768, "NIFTI_TYPE_UINT32", 4, 4},
+	{1024, "NIFTI_TYPE_INT64", 8, 8},
+	{1280, "NIFTI_TYPE_UINT64", 8, 8},
+	{1536, "NIFTI_TYPE_FLOAT128", 16, 16},
+	{1792, "NIFTI_TYPE_COMPLEX128", 16, 8},
+	{2048, "NIFTI_TYPE_COMPLEX256", 32, 16},
+	{2304, "NIFTI_TYPE_RGBA32", 4, 0},
+}
+
+// dim_info packs the frequency, phase, and slice dimensions in 2 bits each
+// (FPS_INTO_DIM_INFO).
+var dimInfoVectors = []struct {
+	freq, phase, slice int
+	packed             int8
+}{
+	{0, 0, 0, 0},
+	{1, 2, 3, 57},
+	{2, 1, 3, 54},
+	{3, 3, 3, 63},
+}
+
+// xyzt_units holds the spatial units in bits 0-2 and the temporal units in
+// bits 3-5 (SPACE_TIME_TO_XYZT).
+var xyztVectors = []struct {
+	space, time int
+	packed      int8
+}{
+	{0, 0, 0},
+	{2, 8, 10},  // mm, s
+	{3, 16, 19}, // um, ms
+	{1, 24, 25}, // m, us
+}
+
+var sqrtHalf = math.Sqrt(0.5)
+
+// qformVector is a qform and the affine that nifti_quatern_to_mat44 makes
+// of it, with its orientation by nifti_mat44_to_orientation.
+type qformVector struct {
+	name        string
+	quatern     [3]float64 // b, c, d
+	offset      [3]float64
+	pixdim      [3]float64
+	qfac        float64
+	affine      [4][4]float64
+	orientation string
+}
+
+var qformVectors = []qformVector{
+	{
+		name:        "identity",
+		offset:      [3]float64{-90, -126, -72},
+		pixdim:      [3]float64{2, 3, 4},
+		qfac:        1,
+		affine:      [4][4]float64{{2, 0, 0, -90}, {0, 3, 0, -126}, {0, 0, 4, -72}, {0, 0, 0, 1}},
+		orientation: "RAS",
+	},
+	{
+		name:        "qfac -1",
+		offset:      [3]float64{-90, -126, -72},
+		pixdim:      [3]float64{2, 3, 4},
+		qfac:        -1,
+		affine:      [4][4]float64{{2, 0, 0, -90}, {0, 3, 0, -126}, {0, 0, -4, -72}, {0, 0, 0, 1}},
+		orientation: "RAI",
+	},
+	{
+		name:        "180 degrees about x",
+		quatern:     [3]float64{1, 0, 0},
+		pixdim:      [3]float64{1, 1, 1},
+		qfac:        1,
+		affine:      [4][4]float64{{1, 0, 0, 0}, {0, -1, 0, 0}, {0, 0, -1, 0}, {0, 0, 0, 1}},
+		orientation: "RPI",
+	},
+	{
+		name:        "180 degrees about z",
+		quatern:     [3]float64{0, 0, 1},
+		offset:      [3]float64{10, 20, 30},
+		pixdim:      [3]float64{1, 1, 1},
+		qfac:        1,
+		affine:      [4][4]float64{{-1, 0, 0, 10}, {0, -1, 0, 20}, {0, 0, 1, 30}, {0, 0, 0, 1}},
+		orientation: "LPS",
+	},
+	{
+		name:        "90 degrees about z",
+		quatern:     [3]float64{0, 0, sqrtHalf},
+		pixdim:      [3]float64{1, 2, 3},
+		qfac:        1,
+		affine:      [4][4]float64{{0, -2, 0, 0}, {1, 0, 0, 0}, {0, 0, 3, 0}, {0, 0, 0, 1}},
+		orientation: "ALS",
+	},
+	{
+		// A zero pixdim is taken as 1.
+		name:        "zero pixdim",
+		pixdim:      [3]float64{0, 2, 2},
+		qfac:        1,
+		affine:      [4][4]float64{{1, 0, 0, 0}, {0, 2, 0, 0}, {0, 0, 2, 0}, {0, 0, 0, 1}},
+		orientation: "RAS",
+	},
+}
+
+// sformVector is an affine and the qform that nifti_mat44_to_quatern
+// makes of it.
+type sformVector struct {
+	name        string
+	affine      [4][4]float64
+	quatern     [3]float64
+	pixdim      [3]float64
+	qfac        float64
+	orientation string
+}
+
+var sformVectors = []sformVector{
+	{
+		name:        "RAS",
+		affine:      [4][4]float64{{2, 0, 0, -90}, {0, 2, 0, -126}, {0, 0, 2, -72}, {0, 0, 0, 1}},
+		pixdim:      [3]float64{2, 2, 2},
+		qfac:        1,
+		orientation: "RAS",
+	},
+	{
+		// The left-handed matrix has its third column flipped, leaving a
+		// rotation of 180 degrees about y.
+		name:        "LAS",
+		affine:      [4][4]float64{{-2, 0, 0, 90}, {0, 2, 0, -126}, {0, 0, 2, -72}, {0, 0, 0, 1}},
+		quatern:     [3]float64{0, 1, 0},
+		pixdim:      [3]float64{2, 2, 2},
+		qfac:        -1,
+		orientation: "LAS",
+	},
+	{
+		name:        "90 degrees about z",
+		affine:      [4][4]float64{{0, -2, 0, 0}, {1, 0, 0, 0}, {0, 0, 3, 0}, {0, 0, 0, 1}},
+		quatern:     [3]float64{0, 0, sqrtHalf},
+		pixdim:      [3]float64{1, 2, 3},
+		qfac:        1,
+		orientation: "ALS",
+	},
+	{
+		// Oblique by 30 degrees about x: the axes are closest to RAS.
+		name: "30 degrees about x",
+		affine: [4][4]float64{
+			{1, 0, 0, 0},
+			{0, math.Cos(math.Pi / 6), -math.Sin(math.Pi / 6), 0},
+			{0, math.Sin(math.Pi / 6), math.Cos(math.Pi / 6), 0},
+			{0, 0, 0, 1},
+		},
+		quatern:     [3]float64{math.Sin(math.Pi / 12), 0, 0},
+		pixdim:      [3]float64{1, 1, 1},
+		qfac:        1,
+		orientation: "RAS",
+	},
+}
+
+// Names of the orientation codes 0 to 6, from nifti_orientation_string.
+var orientationStrings = []string{
+	"Unknown",
+	"Left-to-Right",
+	"Right-to-Left",
+	"Posterior-to-Anterior",
+	"Anterior-to-Posterior",
+	"Inferior-to-Superior",
+	"Superior-to-Inferior",
+}
+
+// layoutVectors are the data offsets of single files: nifti_image_read
+// reads the data at vox_offset, which some writers set to 348 without the
+// 4-byte extender that nifti_image_write puts before them.
+type layoutVector struct {
+	name      string
+	voxOffset int
+}
+
+var layoutVectors = []layoutVector{
+	{"no extender, vox_offset 348", 348},
+	{"extender, vox_offset 352", 352},
+	{"extender and padding, vox_offset 368", 368},
+}
+
+// magicVectors edit the bytes of a valid little-endian header.
+type magicVector struct {
+	name string
+	edit func(b []byte)
+	err  error
+}
+
+var magicVectors = []magicVector{
+	{"Analyze 7.5, no magic", func(b []byte) { copy(b[344:], []byte{0, 0, 0, 0}) }, nifti1.ErrInvalidHeader},
+	{"unknown magic", func(b []byte) { copy(b[344:], "n+2\x00") }, nifti1.ErrInvalidHeader},
+	{"pair magic ni1", func(b []byte) { copy(b[344:], "ni1\x00") }, nil},
+	{"NIfTI-2 size", func(b []byte) { binary.LittleEndian.PutUint32(b, 540) }, nifti1.ErrUnsupported},
+}
@@ -0,0 +1,485 @@
+// daemon runs jobs, such as conversions and QC, from a queue served over
+// HTTP, so that gonifti can run as a service behind an upload portal. Jobs
+// run in order of submission, a limited number at a time and within a
+// memory budget.
+
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Job states.
+const (
+	Queued   = "queued"
+	Running  = "running"
+	Done     = "done"
+	Failed   = "failed"
+	Canceled = "canceled"
+)
+
+// maxOutput is the number of bytes of the output and log of a job kept.
+const maxOutput = 1 << 20
+
+// maxFinished is the number of finished jobs kept for status queries.
+const maxFinished = 1000
+
+// Job is a command run by the queue, with its state.
+type Job struct {
+	ID       string     `json:"id"`
+	Command  string     `json:"command"`
+	Args     []string   `json:"args"`
+	Client   string     `json:"client,omitempty"` // the address that submitted the job
+	Status   string     `json:"status"`
+	Memory   int64      `json:"memory"` // bytes reserved from the budget
+	ExitCode int        `json:"exit_code"`
+	Output   string     `json:"output,omitempty"` // standard output
+	Log      string     `json:"log,omitempty"`    // standard error
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Runner runs the command of a job, writing its output to stdout and
+// stderr, and returns its exit code. It must stop when ctx is canceled.
+type Runner func(ctx context.Context, j Job, stdout, stderr io.Writer) (int, error)
+
+// Options configure a Queue.
+type Options struct {
+	// Workers is the number of jobs run at a time, at least 1.
+	Workers int
+	// MemoryBudget is the number of bytes the jobs running at a time may
+	// reserve, or 0 for no limit.
+	MemoryBudget int64
+	// Commands are the commands jobs may run.
+	Commands []string
+	// Check, if set, refuses the arguments of a job, such as paths outside
+	// the files the daemon serves.
+	Check func(command string, args []string) error
+	// Estimate returns the bytes of memory a job takes, reserved from
+	// MemoryBudget while it runs. Jobs that need more than the whole
+	// budget are refused.
+	Estimate func(command string, args []string) (int64, error)
+	// Run runs the command of a job.
+	Run Runner
+	// Token, if set, is the bearer token every request to Handler must
+	// carry in its Authorization header.
+	Token string
+}
+
+// Queue runs jobs in order of submission.
+type Queue struct {
+	o Options
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     map[string]*Job
+	pending  []*Job
+	finished []string // IDs, oldest first
+	running  int
+	reserved int64
+	nextID   int
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewQueue starts the workers of a queue.
+func NewQueue(o Options) *Queue {
+	if o.Workers < 1 {
+		o.Workers = 1
+	}
+	q := &Queue{o: o, jobs: map[string]*Job{}}
+	q.cond = sync.NewCond(&q.mu)
+	for w := 0; w < o.Workers; w++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues a job from a client, such as the address of an HTTP
+// request, and returns it.
+func (q *Queue) Submit(client, command string, args []string) (Job, error) {
+	allowed := false
+	for _, c := range q.o.Commands {
+		allowed = allowed || c == command
+	}
+	if !allowed {
+		return Job{}, fmt.Errorf("command %q is not allowed; allowed are %s", command, strings.Join(q.o.Commands, ", "))
+	}
+	if q.o.Check != nil {
+		if err := q.o.Check(command, args); err != nil {
+			return Job{}, err
+		}
+	}
+	var mem int64
+	if q.o.Estimate != nil {
+		var err error
+		if mem, err = q.o.Estimate(command, args); err != nil {
+			return Job{}, err
+		}
+	}
+	if q.o.MemoryBudget > 0 && mem > q.o.MemoryBudget {
+		return Job{}, fmt.Errorf("job needs %.1f MB of memory, more than the budget of %.1f MB", float64(mem)/(1<<20), float64(q.o.MemoryBudget)/(1<<20))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, errors.New("the queue is closed")
+	}
+	q.nextID++
+	j := &Job{
+		ID:      strconv.Itoa(q.nextID),
+		Command: command,
+		Args:    append([]string{}, args...),
+		Client:  client,
+		Status:  Queued,
+		Memory:  mem,
+		Created: time.Now().UTC(),
+	}
+	q.jobs[j.ID] = j
+	q.pending = append(q.pending, j)
+	q.cond.Broadcast()
+	log.WithFields(log.Fields{
+		"job":     j.ID,
+		"command": command,
+		"memory":  mem,
+	}).Info("Queued job")
+	return *j, nil
+}
+
+// Job returns the job with an ID.
+func (q *Queue) Job(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Jobs returns the jobs kept, in order of submission.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		out = append(out, *j)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		x, _ := strconv.Atoi(out[a].ID)
+		y, _ := strconv.Atoi(out[b].ID)
+		return x < y
+	})
+	return out
+}
+
+// Cancel removes a queued job, or stops a running one, which is canceled
+// once its Run returns.
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return fmt.Errorf("no job %s", id)
+	}
+	switch j.Status {
+	case Queued:
+		for i, p := range q.pending {
+			if p == j {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				break
+			}
+		}
+		j.Status = Canceled
+		j.Finished = now()
+		q.finish(j)
+		q.cond.Broadcast()
+	case Running:
+		j.cancel()
+	default:
+		return fmt.Errorf("job %s is %s", id, j.Status)
+	}
+	return nil
+}
+
+// Status is a summary of the state of a queue.
+type Status struct {
+	Workers      int            `json:"workers"`
+	MemoryBudget int64          `json:"memory_budget"`
+	Reserved     int64          `json:"reserved"`
+	Jobs         map[string]int `json:"jobs"` // number of jobs by status
+}
+
+// Status returns a summary of the state of the queue.
+func (q *Queue) Status() Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := Status{Workers: q.o.Workers, MemoryBudget: q.o.MemoryBudget, Reserved: q.reserved, Jobs: map[string]int{}}
+	for _, j := range q.jobs {
+		s.Jobs[j.Status]++
+	}
+	return s
+}
+
+// Close stops taking jobs, cancels those queued and running, and waits for
+// the running ones to stop.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	for _, j := range q.pending {
+		j.Status = Canceled
+		j.Finished = now()
+		q.finish(j)
+		log.WithFields(log.Fields{
+			"job":    j.ID,
+			"status": j.Status,
+		}).Info("Finished job")
+	}
+	q.pending = nil
+	for _, j := range q.jobs {
+		if j.Status == Running {
+			j.cancel()
+		}
+	}
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// work runs jobs until the queue is closed.
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		j := q.next()
+		if j == nil {
+			return
+		}
+		q.run(j)
+	}
+}
+
+// next waits for the first queued job to fit in the memory budget, marks it
+// running, and returns it, or nil once the queue is closed. Jobs start in
+// order of submission, so that a large job is not starved by smaller ones
+// submitted after it.
+func (q *Queue) next() *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return nil
+		}
+		if len(q.pending) > 0 {
+			j := q.pending[0]
+			if q.o.MemoryBudget <= 0 || q.reserved+j.Memory <= q.o.MemoryBudget {
+				q.pending = q.pending[1:]
+				q.reserved += j.Memory
+				q.running++
+				j.Status = Running
+				j.Started = now()
+				j.ctx, j.cancel = context.WithCancel(context.Background())
+				return j
+			}
+		}
+		q.cond.Wait()
+	}
+}
+
+// run runs a job and records its result.
+func (q *Queue) run(j *Job) {
+	q.mu.Lock()
+	ctx, job := j.ctx, *j
+	q.mu.Unlock()
+	defer j.cancel()
+
+	log.WithFields(log.Fields{
+		"job":     j.ID,
+		"command": job.Command,
+	}).Info("Running job")
+	var stdout, stderr limitedBuffer
+	code, err := q.o.Run(ctx, job, &stdout, &stderr)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.ExitCode = code
+	j.Output, j.Log = stdout.String(), stderr.String()
+	j.Finished = now()
+	switch {
+	case ctx.Err() != nil:
+		j.Status = Canceled
+	case err != nil:
+		j.Status = Failed
+		j.Error = err.Error()
+	case code != 0:
+		j.Status = Failed
+	default:
+		j.Status = Done
+	}
+	q.reserved -= j.Memory
+	q.running--
+	q.finish(j)
+	q.cond.Broadcast()
+	log.WithFields(log.Fields{
+		"job":       j.ID,
+		"status":    j.Status,
+		"exit_code": code,
+		"seconds":   j.Finished.Sub(*j.Started).Seconds(),
+	}).Info("Finished job")
+}
+
+// now returns the time for the Started and Finished of a job.
+func now() *time.Time {
+	t := time.Now().UTC()
+	return &t
+}
+
+// finish records a finished job, forgetting the oldest once more than
+// maxFinished are kept.
+func (q *Queue) finish(j *Job) {
+	q.finished = append(q.finished, j.ID)
+	for len(q.finished) > maxFinished {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	b         []byte
+	truncated bool
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(p)
+	if room := maxOutput - len(l.b); n > room {
+		p = p[:room]
+		l.truncated = true
+	}
+	l.b = append(l.b, p...)
+	return n, nil
+}
+
+func (l *limitedBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.truncated {
+		return string(l.b) + "\n[truncated]\n"
+	}
+	return string(l.b)
+}
+
+// Request is the body of a job submission.
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// Handler serves the queue over HTTP:
+//
+//	POST   /jobs       submit a job, {"command": "convert", "args": [...]}
+//	GET    /jobs       list the jobs
+//	GET    /jobs/<id>  a job, with its output once finished
+//	DELETE /jobs/<id>  cancel a job
+//	GET    /status     the workers, memory budget, and number of jobs by status
+//
+// With a Token, requests without "Authorization: Bearer <token>" are
+// refused with 401 Unauthorized.
+func (q *Queue) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, q.Jobs())
+		case http.MethodPost:
+			var req Request
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			client := r.RemoteAddr
+			if client == "@" {
+				// Clients of Unix sockets have no address.
+				client = ""
+			}
+			j, err := q.Submit(client, req.Command, req.Args)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, j)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		switch r.Method {
+		case http.MethodGet:
+			j, ok := q.Job(id)
+			if !ok {
+				writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", id))
+				return
+			}
+			writeJSON(w, http.StatusOK, j)
+		case http.MethodDelete:
+			if err := q.Cancel(id); err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			j, _ := q.Job(id)
+			writeJSON(w, http.StatusOK, j)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, q.Status())
+	})
+	if q.o.Token == "" {
+		return mux
+	}
+	want := []byte("Bearer " + q.o.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
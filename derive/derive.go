@@ -0,0 +1,191 @@
+// derive caches derived files, such as bias-corrected images or brain
+// masks, under a key made of the digests of their inputs, the operation
+// that made them, and its parameters. A pipeline that is run again with
+// unchanged inputs then copies the files from the cache instead of making
+// them again.
+
+package derive
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Key identifies a derivation. Build it with NewKey and the Add methods.
+type Key struct {
+	parts []string
+}
+
+// NewKey starts the key of an operation.
+func NewKey(op string) *Key {
+	return &Key{parts: []string{"op", op}}
+}
+
+// Add adds a parameter of the operation.
+func (k *Key) Add(param string) {
+	k.parts = append(k.parts, "param", param)
+}
+
+// AddFile adds an input file by the digest of its content, so that the
+// key changes when the file does and not when it is moved.
+func (k *Key) AddFile(name string) error {
+	digest, err := FileDigest(name)
+	if err != nil {
+		return err
+	}
+	k.parts = append(k.parts, "file", digest)
+	return nil
+}
+
+// String returns the key as a hex SHA-256 digest of its parts.
+func (k *Key) String() string {
+	h := sha256.New()
+	var n [8]byte
+	for _, p := range k.parts {
+		// Length prefixes keep ("ab", "c") apart from ("a", "bc").
+		binary.LittleEndian.PutUint64(n[:], uint64(len(p)))
+		h.Write(n[:])
+		io.WriteString(h, p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FileDigest returns the hex SHA-256 digest of a file.
+func FileDigest(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Cache stores derived files in a directory, one subdirectory per key.
+type Cache struct {
+	Dir string
+}
+
+// entry is the record of a derivation, kept next to its files.
+type entry struct {
+	Key     string    `json:"key"`
+	Op      string    `json:"op"`
+	Outputs []string  `json:"outputs"`
+	Created time.Time `json:"created"`
+}
+
+func (c Cache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key)
+}
+
+// Restore copies the files cached under key to outputs, in the order they
+// were stored. It returns false if nothing is cached under key.
+func (c Cache) Restore(key *Key, outputs []string) (bool, error) {
+	k := key.String()
+	dir := c.path(k)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "entry.json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil || len(e.Outputs) != len(outputs) {
+		// A damaged entry is made again.
+		log.WithFields(log.Fields{"key": k}).Warn("Ignoring damaged cache entry")
+		return false, nil
+	}
+	for i, out := range outputs {
+		if err := copyFile(filepath.Join(dir, strconv.Itoa(i)), out); err != nil {
+			return false, err
+		}
+	}
+	log.WithFields(log.Fields{
+		"key":     k,
+		"op":      e.Op,
+		"created": e.Created.Format(time.RFC3339),
+		"output":  outputs,
+	}).Info("Restored cached result")
+	return true, nil
+}
+
+// Store caches outputs under key. Entries are published whole: readers see
+// either no entry or all of its files.
+func (c Cache) Store(key *Key, op string, outputs []string) error {
+	k := key.String()
+	dir := c.path(k)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer util.TrackTemp(tmp)()
+	defer os.RemoveAll(tmp)
+	for i, out := range outputs {
+		if err := copyFile(out, filepath.Join(tmp, strconv.Itoa(i))); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(entry{Key: k, Op: op, Outputs: outputs, Created: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "entry.json"), b, 0644); err != nil {
+		return err
+	}
+	// Replace an older entry, e.g. one made again with -force.
+	os.RemoveAll(dir)
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"key":    k,
+		"op":     op,
+		"output": outputs,
+	}).Debug("Cached result")
+	return nil
+}
+
+// copyFile copies src to dst through a temporary file in the directory of
+// dst, so that dst is never left half written.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".gonifti-*")
+	if err != nil {
+		return err
+	}
+	defer util.TrackTemp(out.Name())()
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
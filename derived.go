@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/derive"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// cachedCommands are the commands whose results -cache reuses: those that
+// deterministically write the file named by their last argument, and the
+// files named by the listed flags.
+var cachedCommands = map[string][]string{
+	"alff":        {"falff"},
+	"biascorrect": {"field"},
+	"crop":        nil,
+	"fdr":         {"adjusted"},
+	"jacobian":    nil,
+	"mesh":        nil,
+	"mip":         nil,
+	"onehot":      nil,
+	"psc":         nil,
+	"quickbet":    {"brain"},
+	"reho":        nil,
+	"resample":    nil,
+	"reslice":     nil,
+	"roi":         nil,
+	"tfilter":     nil,
+	"threshold":   nil,
+}
+
+// runCached runs a command, or restores its outputs from the derivation
+// cache if it was run before with the same arguments and settings on inputs
+// with the same content. force runs it anyway and replaces the cached
+// outputs. Commands that are not cacheable, or whose arguments cannot be
+// keyed, are just run.
+func runCached(c *command, args []string, force bool) error {
+	key, outputs, ok := derivationKey(c.name, args)
+	if !ok {
+		return c.run(args)
+	}
+	cache := derive.Cache{Dir: filepath.Join(cfg.CacheDir, "derived")}
+	if !force {
+		a := beginAudit()
+		restored, err := cache.Restore(key, outputs)
+		if err != nil {
+			return err
+		}
+		if restored {
+			if a != nil {
+				a.entry.Cached = true
+			}
+			return a.commit(outputs...)
+		}
+	}
+	if err := c.run(args); err != nil {
+		return err
+	}
+	if err := cache.Store(key, c.name, outputs); err != nil {
+		// The outputs are written; only the next run is slower.
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Cannot cache result")
+	}
+	return nil
+}
+
+// derivationKey returns the key and the output files of a run of a
+// cacheable command. Arguments that name existing files are keyed by their
+// content, so that a changed input is made again; the others, including
+// output names, are keyed as they are. Runs on remote inputs are not
+// cached, since their content is only known by reading it.
+func derivationKey(name string, args []string) (*derive.Key, []string, bool) {
+	outputFlags, cacheable := cachedCommands[name]
+	if !cacheable || cfg.CacheDir == "" || len(args) == 0 || strings.HasPrefix(args[len(args)-1], "-") {
+		return nil, nil, false
+	}
+	// Dry runs write nothing to restore over or to store.
+	for _, arg := range args {
+		if trimmed := strings.TrimLeft(arg, "-"); trimmed != arg && (trimmed == "dry-run" || strings.HasPrefix(trimmed, "dry-run=")) {
+			return nil, nil, false
+		}
+	}
+	outputs := []string{args[len(args)-1]}
+	for _, f := range outputFlags {
+		if v := flagValue(args, f); v != "" {
+			outputs = append(outputs, v)
+		}
+	}
+	outputs = expandPairs(outputs)
+	isOutput := map[string]bool{}
+	for _, o := range outputs {
+		isOutput[o] = true
+	}
+
+	key := derive.NewKey(name)
+	key.Add(executableDigest())
+	key.Add(settingsKey())
+	for _, arg := range args {
+		value := arg
+		if i := strings.IndexByte(arg, '='); strings.HasPrefix(arg, "-") && i > 0 {
+			value = arg[i+1:]
+			key.Add(arg[:i+1])
+		}
+		if util.IsRemote(value) {
+			return nil, nil, false
+		}
+		inputs := expandPairs([]string{value})
+		if isOutput[value] || !isFile(inputs[0]) {
+			key.Add(value)
+			continue
+		}
+		for _, in := range inputs {
+			if err := key.AddFile(in); err != nil {
+				return nil, nil, false
+			}
+		}
+	}
+	return key, outputs, true
+}
+
+// flagValue returns the value of a string flag in args, given as -name
+// value or -name=value, with one or two dashes.
+func flagValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if trimmed == arg {
+			continue
+		}
+		if trimmed == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(trimmed, name+"=") {
+			return trimmed[len(name)+1:]
+		}
+	}
+	return ""
+}
+
+// expandPairs replaces the names of NIfTI pairs by both of their files.
+func expandPairs(names []string) []string {
+	var out []string
+	for _, name := range names {
+		if nifti1.FileTypeFromName(name) == nifti1.FileTypeNifti1Pair {
+			hdr, img := nifti1.PairFilenames(name)
+			out = append(out, hdr, img)
+			continue
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+func isFile(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// settingsKey returns the settings that can change results, which are
+// keyed along with the arguments.
+func settingsKey() string {
+	return fmt.Sprintf("compression_level=%d;pixdim=%s;deterministic=%t",
+		cfg.CompressionLevel, cfg.PixDim, cfg.Deterministic)
+}
+
+// executableDigest returns the digest of the running gonifti, so that a
+// new build, which may compute differently, does not reuse old results.
+func executableDigest() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "unknown"
+	}
+	digest, err := derive.FileDigest(exe)
+	if err != nil {
+		return "unknown"
+	}
+	return digest
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// addDryRunFlag registers -dry-run on fs, which every command that writes
+// files accepts. It sets util.DryRun, so that writeImage and the writers of
+// util print what they would write instead of writing.
+func addDryRunFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&util.DryRun, "dry-run", false,
+		"print the files that would be written, with their sizes and the header fields that change, without writing")
+}
+
+// dryRunWrite prints what writing img to filename would do: each file with
+// whether it is created or overwritten and its size, and the header fields
+// that differ from the file it overwrites, or else from the file img was
+// read from.
+func dryRunWrite(img *nifti1.Image, filename string, opts []nifti1.WriteOption) error {
+	hdrName := filename
+	if nifti1.FileTypeFromName(filename) == nifti1.FileTypeNifti1Pair {
+		hdrName, _ = nifti1.PairFilenames(filename)
+	}
+	var old *nifti1.HeaderReport
+	for _, name := range []string{hdrName, img.FName} {
+		if name == "" || (name == hdrName && !fileExists(name)) {
+			continue
+		}
+		h, err := nifti1.ReadHeaderFile(name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file":  name,
+				"error": err,
+			}).Warn("Cannot read the header to compare with")
+			continue
+		}
+		r := nifti1.NewHeaderReport(h)
+		old = &r
+		break
+	}
+
+	opts = append(opts, nifti1.WriteFunc(func(name string, b []byte) error {
+		util.PrintDryRun(name, int64(len(b)))
+		if name != hdrName || old == nil {
+			return nil
+		}
+		content, err := util.DecompressBytes(b)
+		if err != nil {
+			return err
+		}
+		h, _, err := nifti1.DecodeHeader(content)
+		if err != nil {
+			return err
+		}
+		for _, d := range headerChanges(*old, nifti1.NewHeaderReport(h)) {
+			fmt.Println("  " + d)
+		}
+		return nil
+	}))
+	return nifti1.WriteFile(img, filename, opts...)
+}
+
+// headerChanges lists the fields that differ between two header reports as
+// "name: old -> new".
+func headerChanges(a, b nifti1.HeaderReport) []string {
+	var changes []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		x, y := fmt.Sprintf("%q", va.Field(i).Interface()), fmt.Sprintf("%q", vb.Field(i).Interface())
+		if va.Field(i).Kind() != reflect.String {
+			x, y = fmt.Sprint(va.Field(i).Interface()), fmt.Sprint(vb.Field(i).Interface())
+		}
+		if x != y {
+			name := strings.Split(va.Type().Field(i).Tag.Get("json"), ",")[0]
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, x, y))
+		}
+	}
+	return changes
+}
+
+// fileExists reports whether a file exists.
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
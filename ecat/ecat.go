@@ -0,0 +1,389 @@
+// ecat contains a reader of ECAT 7 PET volumes (file types 6 and 7, one
+// matrix per frame), converting them to 4D images of calibrated activity.
+// Frame timing and decay correction are kept in a comment extension.
+// Refer to the ECAT 7 file format specification and to matrix.h in the ECAT
+// library.
+
+package ecat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const blockSize = 512
+
+// magic begins the main header of ECAT 7 files.
+const magic = "MATRIX7"
+
+// File types of volumes.
+const (
+	fileVolume8  = 6
+	fileVolume16 = 7
+)
+
+// Data types of matrices.
+const (
+	dataByte   = 1
+	dataIEEER4 = 5
+	dataSunI2  = 6
+	dataSunI4  = 7
+)
+
+// timingPrefix marks comment extensions holding frame timing.
+const timingPrefix = "gonifti-ecat "
+
+// Timing describes the frames of a PET image, using the names of the BIDS
+// PET sidecar. Times are in seconds.
+type Timing struct {
+	FrameTimesStart       []float64 `json:"FrameTimesStart"`
+	FrameDuration         []float64 `json:"FrameDuration"`
+	DecayCorrectionFactor []float64 `json:"DecayCorrectionFactor"`
+	TracerRadionuclide    string    `json:"TracerRadionuclide,omitempty"`
+	RadionuclideHalfLife  float64   `json:"RadionuclideHalfLife,omitempty"`
+	TracerName            string    `json:"TracerName,omitempty"`
+	Units                 string    `json:"Units,omitempty"`
+	ScanStart             int64     `json:"ScanStart,omitempty"` // Unix time
+}
+
+// mainHeader holds the fields of the main header that are used.
+type mainHeader struct {
+	fileType            int16
+	scanStart           int32
+	isotope             string
+	halfLife            float32
+	radiopharmaceutical string
+	calibration         float32
+	numFrames           int16
+	dataUnits           string
+}
+
+// subheader holds the fields of an image subheader that are used.
+type subheader struct {
+	dataType         int16
+	nx, ny, nz       int16
+	xOff, yOff, zOff float32 // cm
+	scale            float32
+	dx, dy, dz       float32 // cm
+	duration, start  int32   // ms
+	decayCorrection  float32
+}
+
+// matrix is a directory entry.
+type matrix struct {
+	frame int
+	start int // block of the subheader, starting at 1
+}
+
+// IsECAT reports whether the file starts with the ECAT 7 magic number.
+func IsECAT(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b := make([]byte, len(magic))
+	_, err = f.Read(b)
+	return err == nil && string(b) == magic
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+func f32(b []byte) float32 {
+	return math.Float32frombits(binary.BigEndian.Uint32(b))
+}
+
+// f64 converts a float32 to the float64 with the same shortest decimal
+// representation, so that 1.1 is stored as 1.1 in JSON.
+func f64(x float32) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(x), 'g', -1, 32), 64)
+	return v
+}
+
+func i16(b []byte) int16 {
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func i32(b []byte) int32 {
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func readMainHeader(b []byte) mainHeader {
+	return mainHeader{
+		fileType:            i16(b[50:]),
+		scanStart:           i32(b[62:]),
+		isotope:             cString(b[66:74]),
+		halfLife:            f32(b[74:]),
+		radiopharmaceutical: cString(b[78:110]),
+		calibration:         f32(b[144:]),
+		numFrames:           i16(b[354:]),
+		dataUnits:           cString(b[466:498]),
+	}
+}
+
+func readSubheader(b []byte) subheader {
+	return subheader{
+		dataType:        i16(b[0:]),
+		nx:              i16(b[4:]),
+		ny:              i16(b[6:]),
+		nz:              i16(b[8:]),
+		xOff:            f32(b[10:]),
+		yOff:            f32(b[14:]),
+		zOff:            f32(b[18:]),
+		scale:           f32(b[26:]),
+		dx:              f32(b[34:]),
+		dy:              f32(b[38:]),
+		dz:              f32(b[42:]),
+		duration:        i32(b[46:]),
+		start:           i32(b[50:]),
+		decayCorrection: f32(b[80:]),
+	}
+}
+
+// readDirectory reads the matrix directory, a circular list of blocks
+// starting at block 2.
+func readDirectory(b []byte) ([]matrix, error) {
+	var matrices []matrix
+	seen := map[int]bool{}
+	for blk := 2; !seen[blk]; {
+		seen[blk] = true
+		off := (blk - 1) * blockSize
+		if off+blockSize > len(b) {
+			return nil, fmt.Errorf("%w ECAT directory block %d", nifti1.ErrTruncated, blk)
+		}
+		d := b[off : off+blockSize]
+		for i := 1; i < 32; i++ {
+			e := d[16*i:]
+			num, start := int(i32(e)), int(i32(e[4:]))
+			if num == 0 {
+				continue
+			}
+			matrices = append(matrices, matrix{frame: num & 0x1FF, start: start})
+		}
+		blk = int(i32(d[4:]))
+		if blk <= 0 {
+			break
+		}
+	}
+	sort.Slice(matrices, func(i, j int) bool { return matrices[i].frame < matrices[j].frame })
+	return matrices, nil
+}
+
+// ReadFile reads an ECAT 7 volume file as a float32 image of activity,
+// with one volume per frame. The values are the stored values times the
+// frame's scale factor and the calibration factor. The voxel grid is
+// centered on the scanner origin, shifted by the image offsets, and the
+// frame timing is stored in a comment extension (see ReadTiming).
+func ReadFile(name string) (*nifti1.Image, error) {
+	return ReadFS(util.Local, name)
+}
+
+// ReadFS is ReadFile for a file of fsys.
+func ReadFS(fsys fs.FS, name string) (*nifti1.Image, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2*blockSize {
+		return nil, fmt.Errorf("%s: %w ECAT header", name, nifti1.ErrTruncated)
+	}
+	if string(b[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%s: %w: not an ECAT 7 file", name, nifti1.ErrInvalidHeader)
+	}
+	mh := readMainHeader(b)
+	if mh.fileType != fileVolume8 && mh.fileType != fileVolume16 {
+		return nil, fmt.Errorf("%s: %w ECAT file type %d", name, nifti1.ErrUnsupported, mh.fileType)
+	}
+	matrices, err := readDirectory(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(matrices) == 0 {
+		return nil, fmt.Errorf("%s: no matrices in the ECAT directory", name)
+	}
+
+	if int(mh.numFrames) != len(matrices) {
+		log.WithFields(log.Fields{
+			"file":     name,
+			"frames":   mh.numFrames,
+			"matrices": len(matrices),
+		}).Warn("Number of frames does not match the ECAT directory")
+	}
+
+	calibration := float64(mh.calibration)
+	if calibration == 0 {
+		calibration = 1
+	}
+	var first subheader
+	var values []float64
+	timing := Timing{
+		TracerRadionuclide:   mh.isotope,
+		RadionuclideHalfLife: f64(mh.halfLife),
+		TracerName:           mh.radiopharmaceutical,
+		Units:                mh.dataUnits,
+		ScanStart:            int64(mh.scanStart),
+	}
+	for n, m := range matrices {
+		off := (m.start - 1) * blockSize
+		if m.start < 1 || off+blockSize > len(b) {
+			return nil, fmt.Errorf("%s: %w ECAT subheader of frame %d", name, nifti1.ErrTruncated, m.frame)
+		}
+		sh := readSubheader(b[off:])
+		if n == 0 {
+			first = sh
+		} else if sh.nx != first.nx || sh.ny != first.ny || sh.nz != first.nz {
+			return nil, fmt.Errorf("%s: frame %d is %dx%dx%d, expected %dx%dx%d",
+				name, m.frame, sh.nx, sh.ny, sh.nz, first.nx, first.ny, first.nz)
+		}
+		nvox := int(sh.nx) * int(sh.ny) * int(sh.nz)
+		if nvox <= 0 {
+			return nil, fmt.Errorf("%s: invalid size %dx%dx%d", name, sh.nx, sh.ny, sh.nz)
+		}
+
+		var size int
+		switch sh.dataType {
+		case dataByte:
+			size = 1
+		case dataSunI2:
+			size = 2
+		case dataSunI4, dataIEEER4:
+			size = 4
+		default:
+			return nil, fmt.Errorf("%s: %w ECAT data type %d", name, nifti1.ErrUnsupported, sh.dataType)
+		}
+		data := b[off+blockSize:]
+		if len(data) < nvox*size {
+			return nil, fmt.Errorf("%s: %w ECAT data of frame %d", name, nifti1.ErrTruncated, m.frame)
+		}
+		scale := float64(sh.scale)
+		if scale == 0 {
+			scale = 1
+		}
+		scale *= calibration
+		for i := 0; i < nvox; i++ {
+			var v float64
+			switch sh.dataType {
+			case dataByte:
+				v = float64(data[i])
+			case dataSunI2:
+				v = float64(i16(data[2*i:]))
+			case dataSunI4:
+				v = float64(i32(data[4*i:]))
+			case dataIEEER4:
+				v = float64(f32(data[4*i:]))
+			}
+			values = append(values, v*scale)
+		}
+
+		timing.FrameTimesStart = append(timing.FrameTimesStart, float64(sh.start)/1000)
+		timing.FrameDuration = append(timing.FrameDuration, float64(sh.duration)/1000)
+		timing.DecayCorrectionFactor = append(timing.DecayCorrectionFactor, f64(sh.decayCorrection))
+	}
+
+	// Grid spacing in mm, centered on the scanner origin.
+	dims := []int{int(first.nx), int(first.ny), int(first.nz)}
+	spacing := [3]float64{10 * float64(first.dx), 10 * float64(first.dy), 10 * float64(first.dz)}
+	offset := [3]float64{10 * float64(first.xOff), 10 * float64(first.yOff), 10 * float64(first.zOff)}
+	var affine [4][4]float64
+	for i := 0; i < 3; i++ {
+		if spacing[i] <= 0 {
+			spacing[i] = 1
+		}
+		affine[i][i] = spacing[i]
+		affine[i][3] = -float64(dims[i]-1)/2*spacing[i] + offset[i]
+	}
+	affine[3][3] = 1
+	if len(matrices) > 1 {
+		dims = append(dims, len(matrices))
+	}
+
+	img, err := nifti1.NewImage(nifti1.DTFloat32, dims, affine, nifti1.XformScannerAnat)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.SetFloat32Data(values); err != nil {
+		return nil, err
+	}
+	img.Descrip = "ECAT7 " + mh.radiopharmaceutical
+	if len(img.Descrip) > 79 {
+		img.Descrip = img.Descrip[:79]
+	}
+	if len(matrices) > 1 {
+		img.TOffset = timing.FrameTimesStart[0]
+		img.Dt = uniformStep(timing.FrameTimesStart)
+		img.PixDim[4] = img.Dt
+	}
+	if err := setTiming(img, timing); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"file":    name,
+		"frames":  len(matrices),
+		"dims":    dims,
+		"isotope": mh.isotope,
+	}).Debug("Read ECAT file")
+
+	return img, nil
+}
+
+// uniformStep returns the step between start times if it is constant, and 0
+// otherwise, as NIfTI has no way to describe frames of different lengths.
+func uniformStep(starts []float64) float64 {
+	if len(starts) < 2 {
+		return 0
+	}
+	step := starts[1] - starts[0]
+	for i := 2; i < len(starts); i++ {
+		if math.Abs(starts[i]-starts[i-1]-step) > 1e-3 {
+			return 0
+		}
+	}
+	return step
+}
+
+// setTiming stores the timing in a comment extension.
+func setTiming(img *nifti1.Image, t Timing) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	img.Extensions = append(img.Extensions, nifti1.Extension{
+		ECode: nifti1.ECodeComment,
+		Data:  append([]byte(timingPrefix), b...),
+	})
+	img.NumExt = len(img.Extensions)
+	return nil
+}
+
+// ReadTiming returns the frame timing stored by ReadFile, or nil if there is
+// none.
+func ReadTiming(img *nifti1.Image) *Timing {
+	for _, e := range img.Extensions {
+		if e.ECode != nifti1.ECodeComment || !bytes.HasPrefix(e.Data, []byte(timingPrefix)) {
+			continue
+		}
+		var t Timing
+		b := bytes.TrimRight(e.Data[len(timingPrefix):], "\x00")
+		if err := json.Unmarshal(b, &t); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes. These are documented in the README and must not change.
+const (
+	exitOK            = 0
+	exitError         = 1  // any other failure
+	exitInvalidHeader = 2  // the header is malformed
+	exitTruncated     = 3  // a file is shorter than its header requires
+	exitGridMismatch  = 4  // images that must share a grid do not
+	exitUnsupported   = 5  // a datatype or feature is not supported
+	exitTooLarge      = 6  // an image exceeds the memory limit
+	exitCorrupt       = 7  // compressed data fail their checks
+	exitUsage         = 64 // invalid command line (EX_USAGE)
+	// Commands stopped by a signal exit with 128 plus its number, as
+	// shells report them: 130 for SIGINT and 143 for SIGTERM.
+	exitSignal = 128
+)
+
+// usageErr is an error in the command line.
+type usageErr struct {
+	msg string
+}
+
+func (e *usageErr) Error() string { return e.msg }
+
+// usageError returns an error reporting an invalid command line.
+func usageError(msg string) error {
+	return &usageErr{msg: msg}
+}
+
+// fileError is the failure of one file of a batch command.
+type fileError struct {
+	File  string `json:"file"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+}
+
+// batchErrors collects the failures of a batch command, which goes on with
+// the other files instead of stopping at the first. It is safe for
+// concurrent use.
+type batchErrors struct {
+	mu    sync.Mutex
+	files []fileError
+}
+
+// add records the failure of a file.
+func (b *batchErrors) add(file string, err error) {
+	kind, _ := errorKind(err)
+	b.mu.Lock()
+	b.files = append(b.files, fileError{file, kind, err.Error()})
+	b.mu.Unlock()
+}
+
+// err returns nil if no file failed, or else an error summarizing the
+// failures of total files, which exitWithError lists by file name.
+func (b *batchErrors) err(total int) error {
+	if len(b.files) == 0 {
+		return nil
+	}
+	sort.SliceStable(b.files, func(i, j int) bool { return b.files[i].File < b.files[j].File })
+	return &batchErr{files: b.files, total: total}
+}
+
+// batchErr is the summary of the failed files of a batch command.
+type batchErr struct {
+	files []fileError
+	total int
+}
+
+func (e *batchErr) Error() string {
+	return fmt.Sprintf("%d of %d files failed", len(e.files), e.total)
+}
+
+// signalErr reports that the command was stopped by a signal.
+type signalErr struct {
+	sig syscall.Signal
+}
+
+func (e *signalErr) Error() string { return "stopped by " + e.sig.String() }
+
+// errorKind classifies an error into a name and an exit code.
+func errorKind(err error) (string, int) {
+	var u *usageErr
+	var sig *signalErr
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return "help", exitOK
+	case errors.As(err, &u):
+		return "usage", exitUsage
+	case errors.Is(err, nifti1.ErrInvalidHeader):
+		return "invalid_header", exitInvalidHeader
+	case errors.Is(err, nifti1.ErrTruncated):
+		return "truncated", exitTruncated
+	case errors.Is(err, nifti1.ErrGridMismatch):
+		return "grid_mismatch", exitGridMismatch
+	case errors.Is(err, nifti1.ErrUnsupported):
+		return "unsupported", exitUnsupported
+	case errors.Is(err, nifti1.ErrTooLarge):
+		return "too_large", exitTooLarge
+	case errors.Is(err, nifti1.ErrCorrupt):
+		return "corrupt", exitCorrupt
+	case errors.As(err, new(*batchErr)):
+		return "batch", exitError
+	case errors.As(err, &sig):
+		return "interrupted", exitSignal + int(sig.sig)
+	}
+	return "error", exitError
+}
+
+// exitWithError reports err and exits with its code. With the environment
+// variable GONIFTI_ERROR_FORMAT=json, the error is written to stderr as one
+// JSON object with the fields command, kind, code, and error, and for batch
+// commands, files: the file, kind, and error of each failure.
+func exitWithError(command string, err error) {
+	kind, code := errorKind(err)
+	if code == exitOK {
+		os.Exit(code)
+	}
+	var files []fileError
+	var b *batchErr
+	if errors.As(err, &b) {
+		files = b.files
+	}
+
+	if os.Getenv("GONIFTI_ERROR_FORMAT") == "json" {
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Command string      `json:"command"`
+			Kind    string      `json:"kind"`
+			Code    int         `json:"code"`
+			Error   string      `json:"error"`
+			Files   []fileError `json:"files,omitempty"`
+		}{command, kind, code, err.Error(), files})
+	} else {
+		for _, f := range files {
+			log.WithFields(log.Fields{
+				"file": f.File,
+				"kind": f.Kind,
+			}).Error(f.Error)
+		}
+		log.WithFields(log.Fields{
+			"kind": kind,
+			"code": code,
+		}).Error(err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,91 @@
+package examples
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/brainvoyager"
+	"github.com/kaczmarj/gonifti/ecat"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/parrec"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConvertFolder converts the PAR/REC, ECAT 7, and BrainVoyager files under
+// dir to .nii.gz files at the same relative paths under outDir, and writes
+// the name of each output to w. Files that cannot be converted are logged
+// and skipped; the error then counts them.
+func ConvertFolder(w io.Writer, dir, outDir string) error {
+	var inputs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		// The .REC file of a pair is read with its .PAR file.
+		if !info.IsDir() && readerOf(path) != nil && !strings.EqualFold(filepath.Ext(path), ".rec") {
+			inputs = append(inputs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("no PAR/REC, ECAT, or BrainVoyager files under %s", dir)
+	}
+
+	failed := 0
+	for _, in := range inputs {
+		rel, _ := filepath.Rel(dir, in)
+		out := filepath.Join(outDir, strings.TrimSuffix(rel, filepath.Ext(rel))+".nii.gz")
+		if err := convertFile(in, out); err != nil {
+			log.WithFields(log.Fields{
+				"file":  in,
+				"error": err,
+			}).Warn("Skipping file")
+			failed++
+			continue
+		}
+		fmt.Fprintln(w, out)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(inputs))
+	}
+	return nil
+}
+
+// readerOf returns the function reading a file of a scanner format, or nil
+// if name has none.
+func readerOf(name string) func(string) (*nifti1.Image, error) {
+	switch {
+	case parrec.IsPAR(name):
+		return func(name string) (*nifti1.Image, error) {
+			return parrec.ReadFile(name, parrec.ScaleDisplay)
+		}
+	case ecat.IsECAT(name):
+		return ecat.ReadFile
+	case brainvoyager.IsVMR(name):
+		return brainvoyager.ReadVMR
+	case brainvoyager.IsFMR(name):
+		return brainvoyager.ReadFMR
+	}
+	return nil
+}
+
+// convertFile reads in and writes it to out.
+func convertFile(in, out string) error {
+	img, err := readerOf(in)(in)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	return nifti1.WriteFile(img, out)
+}
@@ -0,0 +1,82 @@
+// examples holds end-to-end flows built only on the public API of the
+// gonifti packages, run by "gonifti examples". Each is a short function
+// meant to be read as much as run: it shows which packages a task takes and
+// how they fit together, and running them on real data exercises the
+// library the way users do.
+
+package examples
+
+import (
+	"fmt"
+	"io"
+)
+
+// Example is a flow that can be run from the command line.
+type Example struct {
+	Name  string
+	Args  []string // names of the arguments, all required
+	Short string   // one line
+	Long  string   // what the example does and which packages it uses
+	Run   func(w io.Writer, args []string) error
+}
+
+// All lists the examples.
+var All = []Example{
+	{
+		Name:  "qc-subject",
+		Args:  []string{"bids-root", "subject"},
+		Short: "QC metrics of every anatomical and BOLD image of a BIDS subject",
+		Long: `Indexes a BIDS dataset with bids.Index, finds the T1w, T2w, and bold
+images of one subject with Dataset.Find, and computes the metrics of
+qc.Anat or qc.EPI for each, printed as a table of file, metric, and value.
+Images that fail are reported and skipped.`,
+		Run: func(w io.Writer, args []string) error {
+			return QCSubject(w, args[0], args[1])
+		},
+	},
+	{
+		Name:  "convert-folder",
+		Args:  []string{"input-dir", "output-dir"},
+		Short: "convert the scanner files of a folder to .nii.gz",
+		Long: `Walks a folder for Philips PAR/REC, ECAT 7, and BrainVoyager VMR and
+FMR files, reads each with the reader of its package, and writes it with
+nifti1.WriteFile to the same relative path under the output folder, as
+.nii.gz. gonifti has no DICOM reader, so DICOM folders must be converted
+with a tool such as dcm2niix first.`,
+		Run: func(w io.Writer, args []string) error {
+			return ConvertFolder(w, args[0], args[1])
+		},
+	},
+	{
+		Name:  "roi-stats",
+		Args:  []string{"image", "atlas"},
+		Short: "voxel counts, volumes, and mean intensities of the labels of an atlas",
+		Long: `Reads an image and a label atlas with nifti1.ReadFile, looks up the label
+of every voxel of the image through world coordinates, with
+Image.VoxelToWorld and Image.WorldToVoxel, so that the atlas may be on
+another grid, and prints the number of voxels, volume, mean, and standard
+deviation of each label, summed with numeric.Accumulator.`,
+		Run: func(w io.Writer, args []string) error {
+			return ROIStats(w, args[0], args[1])
+		},
+	},
+}
+
+// Find returns the example named name.
+func Find(name string) (Example, bool) {
+	for _, e := range All {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return Example{}, false
+}
+
+// Usage returns the usage line of the example.
+func (e Example) Usage() string {
+	s := "gonifti examples " + e.Name
+	for _, a := range e.Args {
+		s += fmt.Sprintf(" <%s>", a)
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+package examples
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/qc"
+	log "github.com/sirupsen/logrus"
+)
+
+// QCSubject writes the QC metrics of the anatomical (T1w and T2w) and
+// functional (bold) images of subject in the BIDS dataset at root to w, as
+// a table with the columns file, metric, and value. Images that cannot be
+// read or measured are logged and skipped; the error then counts them.
+func QCSubject(w io.Writer, root, subject string) error {
+	d, err := bids.Index(root)
+	if err != nil {
+		return err
+	}
+	files := d.Find(bids.Query{Entities: map[string]string{bids.Subject: subject}})
+	fmt.Fprintln(w, "file\tmetric\tvalue")
+	n, failed := 0, 0
+	for _, f := range files {
+		if f.Suffix != "T1w" && f.Suffix != "T2w" && f.Suffix != "bold" {
+			continue
+		}
+		n++
+		metrics, err := qcFile(f)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file":  f.Path,
+				"error": err,
+			}).Warn("Skipping image")
+			failed++
+			continue
+		}
+		rel, _ := filepath.Rel(root, f.Path)
+		for _, m := range metrics {
+			value := bids.NA
+			if !math.IsNaN(m.Value) {
+				value = strconv.FormatFloat(m.Value, 'g', 6, 64)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", filepath.ToSlash(rel), m.Name, value)
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("no T1w, T2w, or bold images of subject %q under %s", subject, root)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, n)
+	}
+	return nil
+}
+
+// qcFile computes the metrics of qc.Anat for the first volume of an
+// anatomical image, or of qc.EPI for a BOLD series.
+func qcFile(f *bids.File) ([]qc.Metric, error) {
+	img, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	if f.Suffix == "bold" {
+		return qc.EPI(img, qc.EPIOptions{SpikeZ: 3, SliceZ: 5})
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	values = values[:img.Nx*img.Ny*img.Nz]
+	if img.NDim > 3 {
+		log.WithFields(log.Fields{
+			"file":    f.Path,
+			"volumes": img.NVox / len(values),
+		}).Info("Measuring the first volume")
+	}
+	return qc.Anat(values, dims, qc.AnatOptions{T2: f.Suffix == "T2w"})
+}
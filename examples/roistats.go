@@ -0,0 +1,88 @@
+package examples
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// ROIStats writes, for each nonzero label of the atlas, the number of
+// voxels of the first volume of image that fall in it, their volume in
+// mm³, and the mean and standard deviation of their scaled values, as a
+// table. The label of a voxel is that of the atlas voxel nearest to its
+// world coordinates, so that the atlas need not be on the grid of the
+// image.
+func ROIStats(w io.Writer, image, atlas string) error {
+	img, err := nifti1.ReadFile(image)
+	if err != nil {
+		return err
+	}
+	labels, err := nifti1.ReadFile(atlas)
+	if err != nil {
+		return err
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+	lv, err := labels.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+
+	type roi struct {
+		n          int
+		sum, sumSq numeric.Accumulator
+	}
+	rois := map[int]*roi{}
+	for k := 0; k < img.Nz; k++ {
+		for j := 0; j < img.Ny; j++ {
+			for i := 0; i < img.Nx; i++ {
+				p := img.VoxelToWorld(float64(i), float64(j), float64(k))
+				a := labels.WorldToVoxel(p[0], p[1], p[2])
+				ai, aj, ak := int(math.Round(a[0])), int(math.Round(a[1])), int(math.Round(a[2]))
+				if ai < 0 || aj < 0 || ak < 0 || ai >= labels.Nx || aj >= labels.Ny || ak >= labels.Nz {
+					continue
+				}
+				label := int(math.Round(lv[ai+labels.Nx*(aj+labels.Ny*ak)]))
+				if label == 0 {
+					continue
+				}
+				r := rois[label]
+				if r == nil {
+					r = &roi{}
+					rois[label] = r
+				}
+				x := values[i+img.Nx*(j+img.Ny*k)]
+				r.n++
+				r.sum.Add(x)
+				r.sumSq.AddProduct(x, x)
+			}
+		}
+	}
+	if len(rois) == 0 {
+		return fmt.Errorf("no voxel of %s falls in a label of %s", image, atlas)
+	}
+
+	ids := make([]int, 0, len(rois))
+	for id := range rois {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fmt.Fprintln(w, "label\tvoxels\tvolume_mm3\tmean\tsd")
+	for _, id := range ids {
+		r := rois[id]
+		n := float64(r.n)
+		mean := r.sum.Sum() / n
+		sd := 0.0
+		if r.n > 1 {
+			sd = math.Sqrt(math.Max(r.sumSq.Sum()-n*mean*mean, 0) / (n - 1))
+		}
+		fmt.Fprintf(w, "%d\t%d\t%.6g\t%.6g\t%.6g\n", id, r.n, img.VolumeMM3(r.n), mean, sd)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// features describes what this build of gonifti supports: file formats,
+// datatypes, codecs, and optional features that depend on the platform or
+// on what the program registers, so that tools built on it can adapt at
+// run time. The gonifti command prints it with "version -features".
+
+package features
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// Schema identifies the version of the JSON form of Set. Fields may be
+// added within a version, but not removed or changed.
+const Schema = "gonifti-features/1"
+
+// Format is a file format and whether it can be read and written.
+type Format struct {
+	Name       string   `json:"name"`
+	Extensions []string `json:"extensions"`
+	Read       bool     `json:"read"`
+	Write      bool     `json:"write"`
+}
+
+// DataType is a NIfTI datatype that can be stored. Numeric datatypes can
+// also be converted to and from float64 values, which most commands need.
+type DataType struct {
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Numeric bool   `json:"numeric"`
+}
+
+// Codec is a compression codec and whether it can be decoded and encoded.
+type Codec struct {
+	Name   string `json:"name"`
+	Decode bool   `json:"decode"`
+	Encode bool   `json:"encode"`
+	Note   string `json:"note,omitempty"`
+}
+
+// Set is what a build supports.
+type Set struct {
+	Schema    string     `json:"schema"`
+	Version   string     `json:"version"`
+	GoVersion string     `json:"go_version"`
+	Platform  string     `json:"platform"`
+	Formats   []Format   `json:"formats"`
+	DataTypes []DataType `json:"datatypes"`
+	Codecs    []Codec    `json:"codecs"`
+	// Schemes are the URL schemes of remote files that can be read, which
+	// include those registered with util.RegisterScheme, such as s3.
+	Schemes []string `json:"schemes"`
+	// Features are the optional features by name, and whether they are
+	// available.
+	Features map[string]bool `json:"features"`
+}
+
+// Optional features.
+const (
+	// FeatureMmap is set where files, such as gzipped images inflated to
+	// disk, are mapped into memory rather than read.
+	FeatureMmap = "mmap"
+	// FeatureZstd is set if Zstandard-compressed data can be read.
+	FeatureZstd = "zstd"
+	// FeatureCloud is set if a scheme of object storage, such as s3, is
+	// registered.
+	FeatureCloud = "cloud"
+	// FeatureRemote is set if files can be read from http and https URLs.
+	FeatureRemote = "remote"
+	// FeatureDirectIO is set where files can be read and written around
+	// the page cache.
+	FeatureDirectIO = "direct_io"
+)
+
+var formats = []Format{
+	{"NIfTI-1", []string{".nii", ".nii.gz"}, true, true},
+	{"NIfTI-1 pair", []string{".hdr", ".img", ".hdr.gz", ".img.gz"}, true, true},
+	{"ECAT 7", []string{".v"}, true, false},
+	{"PAR/REC", []string{".par", ".rec"}, true, false},
+	{"BrainVoyager VMR", []string{".vmr"}, true, false},
+	{"BrainVoyager FMR", []string{".fmr", ".stc"}, true, false},
+	{"TIFF", []string{".tif", ".tiff"}, true, true},
+	{"Zarr", []string{".zarr"}, true, true},
+	{"Raw binary", []string{".json"}, true, false},
+	{"HDF5", []string{".h5", ".hdf5"}, false, true},
+	{"NumPy", []string{".npy", ".npz"}, true, true},
+	{"Parquet", []string{".parquet"}, false, true},
+	{"SQLite", []string{".sqlite"}, false, true},
+	{"GIfTI", []string{".gii"}, false, true},
+	{"STL", []string{".stl"}, false, true},
+	{"OBJ", []string{".obj"}, false, true},
+}
+
+var codecs = []Codec{
+	{Name: "gzip", Decode: true, Encode: true},
+	{Name: "zlib", Decode: true, Encode: true, Note: "Zarr v2 chunks"},
+	{Name: "blosc", Decode: true, Encode: true, Note: "uncompressed (memcpyed) frames only"},
+}
+
+// Capabilities returns what this build supports. Schemes, codecs, and the
+// features that depend on them reflect the registrations made so far.
+func Capabilities() Set {
+	c := Set{
+		Schema:    Schema,
+		Version:   Version(),
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Formats:   append([]Format(nil), formats...),
+		Codecs:    append([]Codec(nil), codecs...),
+		Schemes:   util.Schemes(),
+		Features: map[string]bool{
+			FeatureMmap:     util.CanMap,
+			FeatureZstd:     false,
+			FeatureCloud:    false,
+			FeatureRemote:   false,
+			FeatureDirectIO: util.CanDirect,
+		},
+	}
+	for _, code := range nifti1.DataTypes() {
+		c.DataTypes = append(c.DataTypes, DataType{code, nifti1.DataTypeString(code), nifti1.IsNumeric(code)})
+	}
+	// Codecs registered with util.RegisterCodec compress NIfTI files with
+	// their suffix.
+	for _, rc := range util.Codecs() {
+		if rc.Name == "gzip" {
+			continue
+		}
+		c.Codecs = append(c.Codecs, Codec{Name: rc.Name, Decode: true, Encode: true, Note: "registered, for files ending in " + rc.Suffix})
+		for i := range c.Formats[:2] {
+			var exts []string
+			for _, ext := range c.Formats[i].Extensions {
+				if _, suffix := util.SplitCodecSuffix(ext); suffix == "" {
+					exts = append(exts, ext+rc.Suffix)
+				}
+			}
+			c.Formats[i].Extensions = append(append([]string(nil), c.Formats[i].Extensions...), exts...)
+		}
+		if rc.Name == "zstd" {
+			c.Features[FeatureZstd] = true
+		}
+	}
+	for _, s := range c.Schemes {
+		switch s {
+		case "http", "https":
+			c.Features[FeatureRemote] = true
+		default:
+			c.Features[FeatureCloud] = true
+		}
+	}
+	return c
+}
+
+// Has reports whether an optional feature is available.
+func (c Set) Has(feature string) bool {
+	return c.Features[feature]
+}
+
+// FeatureNames returns the names of the optional features in lexical
+// order.
+func (c Set) FeatureNames() []string {
+	names := make([]string, 0, len(c.Features))
+	for name := range c.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Version returns the version of the gonifti module in the running
+// program, or "(devel)" if it was built from a work tree.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	for _, m := range info.Deps {
+		if m.Path == modulePath {
+			if m.Replace != nil {
+				return m.Replace.Version
+			}
+			return m.Version
+		}
+	}
+	return "(devel)"
+}
+
+const modulePath = "github.com/kaczmarj/gonifti"
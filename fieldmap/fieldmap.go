@@ -0,0 +1,251 @@
+// fieldmap contains methods for magnitude and phase images used in fieldmap
+// workflows.
+
+package fieldmap
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// PhaseToRadians returns the phase values of an image in radians. The values
+// are scaled with scl_slope and scl_inter first. Floating-point phase must
+// already be in radians, within [-pi, pi]. Integer phase, as scanners store
+// it, is taken to span one full cycle over the values its datatype can hold
+// once scaled (see Image.ValueRange), whether or not the data reach either
+// end, and is mapped linearly onto [-pi, pi) with PhaseRangeToRadians. Phase
+// stored in fewer bits than its datatype holds, such as 12-bit phase in
+// DT_UINT16, needs PhaseRangeToRadians with the range of the scanner.
+func PhaseToRadians(img *nifti1.Image) ([]float64, error) {
+	const eps = 1e-3
+	if !nifti1.IsInteger(img.DataType) {
+		values, err := img.ScaledFloat64s()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if !math.IsInf(v, 0) && math.Abs(v) > math.Pi+eps {
+				return nil, fmt.Errorf("phase value %g is outside [-pi, pi]", v)
+			}
+		}
+		return values, nil
+	}
+
+	lo, hi, ok := img.ValueRange()
+	if !ok {
+		return nil, fmt.Errorf("datatype %d has no range", img.DataType)
+	}
+	if lo >= -math.Pi-eps && hi <= math.Pi+eps {
+		// The scaling already gives radians.
+		return img.ScaledFloat64s()
+	}
+	// The cycle ends one stored step past the largest value.
+	slope, _, _ := img.Scaling()
+	return PhaseRangeToRadians(img, lo, hi+math.Abs(slope))
+}
+
+// PhaseRangeToRadians returns the phase values of an image in radians, with
+// the values, once scaled with scl_slope and scl_inter, mapped linearly from
+// one cycle [lo, hi) onto [-pi, pi). Values outside the cycle are wrapped
+// into it. Phase stored as 0 to 4095, for example, has lo 0 and hi 4096.
+func PhaseRangeToRadians(img *nifti1.Image, lo, hi float64) ([]float64, error) {
+	if !(hi > lo) || math.IsInf(hi-lo, 0) {
+		return nil, fmt.Errorf("invalid phase range [%g, %g)", lo, hi)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		values[i] = wrap((v-lo)/(hi-lo)*2*math.Pi - math.Pi)
+	}
+
+	log.WithFields(log.Fields{
+		"lo": lo,
+		"hi": hi,
+	}).Debug("Rescaled phase to radians")
+
+	return values, nil
+}
+
+// Combine returns a DT_COMPLEX64 image from a magnitude image and a phase
+// image, which must be on the same grid. The phase is converted with
+// PhaseToRadians.
+func Combine(mag, phase *nifti1.Image) (*nifti1.Image, error) {
+	if !nifti1.SameGrid(mag, phase) || mag.NVox != phase.NVox {
+		return nil, errors.New("magnitude and phase are not on the same grid")
+	}
+	m, err := mag.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	p, err := PhaseToRadians(phase)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]complex128, len(m))
+	for i := range values {
+		values[i] = cmplx.Rect(m[i], p[i])
+	}
+	out := *mag
+	if err := out.SetComplex64Data(values); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Split returns the magnitude and phase (in radians) of a complex image.
+func Split(img *nifti1.Image) (mag, phase []float64, err error) {
+	values, err := img.Complex128s()
+	if err != nil {
+		return nil, nil, err
+	}
+	mag = make([]float64, len(values))
+	phase = make([]float64, len(values))
+	for i, v := range values {
+		mag[i], phase[i] = cmplx.Polar(v)
+	}
+	return mag, phase, nil
+}
+
+// wrap returns the angle a wrapped to [-pi, pi).
+func wrap(a float64) float64 {
+	return a - 2*math.Pi*math.Floor((a+math.Pi)/(2*math.Pi))
+}
+
+// Unwrap removes 2*pi jumps from a 3D phase volume in radians by
+// quality-guided region growing: starting from the voxel of highest quality,
+// neighbours are unwrapped relative to an already unwrapped voxel in order of
+// decreasing quality. Quality is the magnitude if given, otherwise the
+// smoothness of the wrapped phase. Voxels outside mask (if not nil) are left
+// at zero, and each connected region of the mask is unwrapped separately.
+func Unwrap(phase []float64, dims [3]int, mag []float64, mask []bool) ([]float64, error) {
+	n := dims[0] * dims[1] * dims[2]
+	if len(phase) != n {
+		return nil, fmt.Errorf("got %d phase values for a %dx%dx%d volume", len(phase), dims[0], dims[1], dims[2])
+	}
+	if mag != nil && len(mag) != n {
+		return nil, errors.New("magnitude is not on the phase grid")
+	}
+	if mask != nil && len(mask) != n {
+		return nil, errors.New("mask is not on the phase grid")
+	}
+
+	quality := mag
+	if quality == nil {
+		quality = smoothness(phase, dims)
+	}
+	in := func(i int) bool { return mask == nil || mask[i] }
+
+	out := make([]float64, n)
+	done := make([]bool, n)
+	regions := 0
+	for {
+		// Seed each region at its best voxel.
+		seed := -1
+		for i := range phase {
+			if in(i) && !done[i] && (seed < 0 || quality[i] > quality[seed]) {
+				seed = i
+			}
+		}
+		if seed < 0 {
+			break
+		}
+		regions++
+
+		out[seed] = phase[seed]
+		done[seed] = true
+		q := &voxelQueue{}
+		push := func(from int) {
+			forNeighbors(from, dims, func(j int) {
+				if in(j) && !done[j] {
+					heap.Push(q, edge{to: j, from: from, quality: quality[j]})
+				}
+			})
+		}
+		push(seed)
+		for q.Len() > 0 {
+			e := heap.Pop(q).(edge)
+			if done[e.to] {
+				continue
+			}
+			out[e.to] = out[e.from] + wrap(phase[e.to]-phase[e.from])
+			done[e.to] = true
+			push(e.to)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"regions": regions,
+	}).Debug("Unwrapped phase")
+
+	return out, nil
+}
+
+// smoothness returns a quality map that is high where the wrapped phase
+// differences to the face neighbours are small.
+func smoothness(phase []float64, dims [3]int) []float64 {
+	q := make([]float64, len(phase))
+	for i := range phase {
+		sum := 0.0
+		forNeighbors(i, dims, func(j int) {
+			d := wrap(phase[j] - phase[i])
+			sum += d * d
+		})
+		q[i] = -sum
+	}
+	return q
+}
+
+// forNeighbors calls f with the index of each face-connected neighbour of
+// voxel i that lies inside a grid of the given dims.
+func forNeighbors(i int, dims [3]int, f func(j int)) {
+	x := i % dims[0]
+	y := (i / dims[0]) % dims[1]
+	z := i / (dims[0] * dims[1])
+	if x > 0 {
+		f(i - 1)
+	}
+	if x < dims[0]-1 {
+		f(i + 1)
+	}
+	if y > 0 {
+		f(i - dims[0])
+	}
+	if y < dims[1]-1 {
+		f(i + dims[0])
+	}
+	if z > 0 {
+		f(i - dims[0]*dims[1])
+	}
+	if z < dims[2]-1 {
+		f(i + dims[0]*dims[1])
+	}
+}
+
+// edge is a candidate voxel to unwrap relative to an unwrapped neighbour.
+type edge struct {
+	to, from int
+	quality  float64
+}
+
+// voxelQueue is a max-heap of edges by quality.
+type voxelQueue []edge
+
+func (q voxelQueue) Len() int            { return len(q) }
+func (q voxelQueue) Less(i, j int) bool  { return q[i].quality > q[j].quality }
+func (q voxelQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *voxelQueue) Push(x interface{}) { *q = append(*q, x.(edge)) }
+func (q *voxelQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
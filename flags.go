@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// describing is set while the command tree is walked to generate shell
+// completions and man pages. Commands are then run without arguments, and the
+// flag set and usage text of each are captured.
+var (
+	describing   bool
+	describedFS  *flag.FlagSet
+	describedOut bytes.Buffer
+)
+
+// errDescribed stops a command at parseFlags while describing, before it does
+// any work.
+var errDescribed = errors.New("command described")
+
+// newFlagSet returns the flag set of a subcommand.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if !describing {
+		return fs
+	}
+	fs.SetOutput(&describedOut)
+	describedFS = fs
+	return fs
+}
+
+// parseFlags parses the flags of a subcommand. Errors other than
+// flag.ErrHelp are usage errors; the flag package has already printed them
+// with the usage. While describing, it prints the usage and returns
+// errDescribed instead.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if describing {
+		if fs.Usage != nil {
+			fs.Usage()
+		} else {
+			fs.PrintDefaults()
+		}
+		return errDescribed
+	}
+	err := fs.Parse(args)
+	if err == nil || err == flag.ErrHelp {
+		return err
+	}
+	return usageError(err.Error())
+}
+
+// addProfileFlags registers the validation profile flags on fs, along with
+// -deterministic, -inflate-to-disk, -memory-limit, and -direct-io, which
+// every command that reads images accepts. The returned function builds the
+// read options once the flags have been parsed.
+func addProfileFlags(fs *flag.FlagSet) func() ([]nifti1.ReadOption, error) {
+	pixdim := fs.String("pixdim", cfg.PixDim,
+		"repair for non-positive pixdims: one, abs, or error")
+	deterministic := fs.Bool("deterministic", cfg.Deterministic,
+		"use compensated summation without fused multiply-adds, so that statistics are identical on every machine")
+	inflateMB := fs.Int("inflate-to-disk", cfg.InflateToDiskMB,
+		"inflate .gz inputs of at least this many MB to a temporary file and map it instead of holding them in memory (0: never)")
+	memoryMB := fs.Int("memory-limit", cfg.MemoryLimitMB,
+		"refuse, before reading them, images that take more than this many MB to hold (0: no limit)")
+	directIO := fs.Bool("direct-io", cfg.DirectIO,
+		"read and write uncompressed files around the page cache, preallocating outputs, where the platform supports it")
+
+	return func() ([]nifti1.ReadOption, error) {
+		numeric.Deterministic = *deterministic
+		p := nifti1.DefaultProfile
+		var err error
+		if p.PixDim, err = nifti1.ParsePixDimRepair(*pixdim); err != nil {
+			return nil, usageError(err.Error())
+		}
+		// Outputs are written by writeImage, which reads the setting.
+		cfg.DirectIO = *directIO
+		opts := []nifti1.ReadOption{
+			nifti1.WithProfile(p),
+			nifti1.InflateToDisk(int64(*inflateMB)<<20, ""),
+			nifti1.MemoryLimit(int64(*memoryMB) << 20),
+		}
+		if *directIO {
+			opts = append(opts, nifti1.DirectRead())
+		}
+		return opts, nil
+	}
+}
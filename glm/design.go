@@ -0,0 +1,285 @@
+// glm contains the design matrices of general linear models of fMRI time
+// series: event regressors convolved with a hemodynamic response function
+// (HRF), and drift regressors.
+
+package glm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/temporal"
+)
+
+// HRF models.
+const (
+	HRFSPM           = "spm"                       // canonical double gamma
+	HRFSPMDerivative = "spm+derivative"            // and its temporal derivative
+	HRFSPMDispersion = "spm+derivative+dispersion" // and its dispersion derivative
+	HRFNone          = "none"                      // boxcars, without convolution
+)
+
+// Drift models.
+const (
+	DriftCosine     = "cosine"     // discrete cosine basis below a cutoff
+	DriftPolynomial = "polynomial" // orthogonal polynomials of time
+	DriftNone       = "none"
+)
+
+// Options configure Build.
+type Options struct {
+	TR           float64 // repetition time in seconds
+	Volumes      int     // number of volumes
+	HRF          string
+	Drift        string
+	HighPass     float64 // cutoff of DriftCosine in Hz
+	Order        int     // order of DriftPolynomial
+	Oversampling int     // samples per TR of the convolution
+}
+
+// DefaultOptions use the SPM HRF and a 128 s cosine drift cutoff. TR and
+// Volumes must be set.
+var DefaultOptions = Options{
+	HRF:          HRFSPM,
+	Drift:        DriftCosine,
+	HighPass:     1.0 / 128,
+	Order:        1,
+	Oversampling: 50,
+}
+
+// Design is a design matrix with one row per volume and named columns.
+type Design struct {
+	Columns []string
+	Matrix  *linalg.Dense
+}
+
+// gammaPDF returns the density of the gamma distribution with the given
+// shape and scale at x.
+func gammaPDF(x, shape, scale float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	lg, _ := math.Lgamma(shape)
+	return math.Exp((shape-1)*math.Log(x) - x/scale - lg - shape*math.Log(scale))
+}
+
+// spmHRF returns SPM's canonical HRF at t seconds, with a response peaking
+// at 6 s, an undershoot at 16 s one sixth its size, and the given
+// dispersion (1 by default).
+func spmHRF(t, dispersion float64) float64 {
+	return gammaPDF(t, 6/dispersion, dispersion) - gammaPDF(t, 16/dispersion, dispersion)/6
+}
+
+// kernels returns the HRF and its derivatives sampled every dt seconds for
+// 32 s, each with the name suffix of its column, normalized so that the
+// HRF sums to 1.
+func kernels(model string, dt float64) ([][]float64, []string, error) {
+	n := int(32/dt) + 1
+	sample := func(f func(t float64) float64) []float64 {
+		k := make([]float64, n)
+		for i := range k {
+			k[i] = f(float64(i) * dt)
+		}
+		return k
+	}
+	hrf := sample(func(t float64) float64 { return spmHRF(t, 1) })
+	sum := 0.0
+	for _, v := range hrf {
+		sum += v
+	}
+	normalize := func(k []float64) []float64 {
+		for i := range k {
+			k[i] /= sum
+		}
+		return k
+	}
+
+	const delta = 0.1 // seconds, and relative dispersion
+	derivative := sample(func(t float64) float64 {
+		return (spmHRF(t+delta/2, 1) - spmHRF(t-delta/2, 1)) / delta
+	})
+	dispersion := sample(func(t float64) float64 {
+		return (spmHRF(t, 1+delta/2) - spmHRF(t, 1-delta/2)) / delta
+	})
+	switch model {
+	case HRFNone:
+		return [][]float64{{1}}, []string{""}, nil
+	case HRFSPM:
+		return [][]float64{normalize(hrf)}, []string{""}, nil
+	case HRFSPMDerivative:
+		return [][]float64{normalize(hrf), normalize(derivative)}, []string{"", "_derivative"}, nil
+	case HRFSPMDispersion:
+		return [][]float64{normalize(hrf), normalize(derivative), normalize(dispersion)}, []string{"", "_derivative", "_dispersion"}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown HRF model %q", model)
+}
+
+// Build returns the design matrix of events: one column per trial type
+// (named "event" for events without one) and HRF basis function, then the
+// drift columns, then a constant. Events last their duration, or one
+// oversampled step if it is 0.
+func Build(events []temporal.Event, o Options) (*Design, error) {
+	if o.TR <= 0 || o.Volumes < 1 {
+		return nil, fmt.Errorf("invalid TR %g s or number of volumes %d", o.TR, o.Volumes)
+	}
+	if o.Oversampling < 1 {
+		o.Oversampling = 1
+	}
+	if o.HRF == HRFNone {
+		o.Oversampling = 1
+	}
+	dt := o.TR / float64(o.Oversampling)
+	basis, suffixes, err := kernels(o.HRF, dt)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	var regressors [][]float64
+	nfine := o.Volumes * o.Oversampling
+	for _, tt := range temporal.TrialTypes(events) {
+		// Boxcar of the events on the fine grid, as the fraction of each
+		// step covered by an event.
+		box := make([]float64, nfine)
+		for _, e := range events {
+			if e.TrialType != tt {
+				continue
+			}
+			start, end := e.Onset/dt, (e.Onset+math.Max(e.Duration, dt))/dt
+			for i := int(math.Max(0, math.Floor(start))); i < nfine && float64(i) < end; i++ {
+				box[i] += math.Min(end, float64(i+1)) - math.Max(start, float64(i))
+			}
+		}
+		name := tt
+		if name == "" {
+			name = "event"
+		}
+		for b, k := range basis {
+			col := make([]float64, o.Volumes)
+			for v := range col {
+				i := v * o.Oversampling
+				s := 0.0
+				for j := 0; j < len(k) && j <= i; j++ {
+					s += k[j] * box[i-j]
+				}
+				col[v] = s
+			}
+			columns = append(columns, name+suffixes[b])
+			regressors = append(regressors, col)
+		}
+	}
+
+	drifts, err := driftColumns(o)
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range drifts {
+		columns = append(columns, fmt.Sprintf("drift_%02d", i+1))
+		regressors = append(regressors, d)
+	}
+	constant := make([]float64, o.Volumes)
+	for i := range constant {
+		constant[i] = 1
+	}
+	columns = append(columns, "constant")
+	regressors = append(regressors, constant)
+
+	m := linalg.NewDense(o.Volumes, len(columns))
+	for j, col := range regressors {
+		for i, v := range col {
+			m.Set(i, j, v)
+		}
+	}
+	return &Design{Columns: columns, Matrix: m}, nil
+}
+
+// driftColumns returns the drift regressors.
+func driftColumns(o Options) ([][]float64, error) {
+	n := o.Volumes
+	var drifts [][]float64
+	switch o.Drift {
+	case DriftNone, "":
+	case DriftCosine:
+		if o.HighPass <= 0 {
+			return nil, fmt.Errorf("invalid high-pass cutoff %g Hz", o.HighPass)
+		}
+		// Cosines with periods longer than 1/HighPass.
+		order := int(math.Floor(2 * float64(n) * o.TR * o.HighPass))
+		for k := 1; k <= order && k < n; k++ {
+			d := make([]float64, n)
+			for t := range d {
+				d[t] = math.Sqrt(2.0/float64(n)) * math.Cos(math.Pi*float64(k)*(float64(t)+0.5)/float64(n))
+			}
+			drifts = append(drifts, d)
+		}
+	case DriftPolynomial:
+		if o.Order < 1 {
+			return nil, fmt.Errorf("invalid polynomial order %d", o.Order)
+		}
+		// Powers of time, orthonormalized by Gram-Schmidt against the
+		// constant and each other.
+		constant := make([]float64, n)
+		for t := range constant {
+			constant[t] = 1 / math.Sqrt(float64(n))
+		}
+		basis := [][]float64{constant}
+		for p := 1; p <= o.Order && p < n; p++ {
+			d := make([]float64, n)
+			for t := range d {
+				d[t] = math.Pow(float64(t)/float64(n-1)*2-1, float64(p))
+			}
+			for _, b := range basis {
+				c := 0.0
+				for t := range d {
+					c += d[t] * b[t]
+				}
+				for t := range d {
+					d[t] -= c * b[t]
+				}
+			}
+			basis = append(basis, unitNorm(d))
+			drifts = append(drifts, basis[len(basis)-1])
+		}
+	default:
+		return nil, fmt.Errorf("unknown drift model %q", o.Drift)
+	}
+	return drifts, nil
+}
+
+// unitNorm scales x to unit norm in place and returns it.
+func unitNorm(x []float64) []float64 {
+	norm := 0.0
+	for _, v := range x {
+		norm += v * v
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range x {
+			x[i] /= norm
+		}
+	}
+	return x
+}
+
+// WriteTSV writes the design as a table with a header row of the column
+// names and one row per volume.
+func (d *Design) WriteTSV(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(strings.Join(d.Columns, "\t"))
+	b.WriteString("\n")
+	for i := 0; i < d.Matrix.Rows; i++ {
+		for j, v := range d.Matrix.Row(i) {
+			if j > 0 {
+				b.WriteString("\t")
+			}
+			b.WriteString(strconv.FormatFloat(v, 'g', 8, 64))
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
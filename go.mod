@@ -0,0 +1,7 @@
+module github.com/kaczmarj/gonifti
+
+go 1.23
+
+require github.com/sirupsen/logrus v1.10.2
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,427 @@
+// hdf5 contains a writer for HDF5 files holding one chunked, deflate
+// compressed float32 dataset with attributes. It writes the original file
+// format (superblock version 0, version 1 object headers and B-trees), which
+// every HDF5 release reads, and does not need the HDF5 library.
+// https://docs.hdfgroup.org/hdf5/develop/_f_m_t3.html
+
+package hdf5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+const (
+	undefined = math.MaxUint64 // undefined address
+
+	groupLeafK     = 4  // symbol table node holds 2K entries
+	groupInternalK = 16 // group B-tree node holds 2K children
+	chunkK         = 32 // chunk B-tree node holds 2K children
+
+	// freeListEnd marks an empty local heap free list.
+	freeListEnd = 1
+
+	superblockSize = 96
+	entrySize      = 40 // symbol table entry
+
+	// MaxChunks is the number of chunks a dataset may have: this writer
+	// indexes chunks with a single B-tree leaf.
+	MaxChunks = 2 * chunkK
+)
+
+// Message types.
+const (
+	msgDataspace   = 0x0001
+	msgDatatype    = 0x0003
+	msgFillValue   = 0x0005
+	msgLayout      = 0x0008
+	msgFilter      = 0x000b
+	msgAttribute   = 0x000c
+	msgSymbolTable = 0x0011
+)
+
+// Attribute is an attribute of the dataset. Value is an int32, a float64, an
+// []int32, or a []float64; Dims gives the shape of slices and defaults to
+// one dimension.
+type Attribute struct {
+	Name  string
+	Value interface{}
+	Dims  []int
+}
+
+// Dataset is a float32 array stored in C order (the last dimension varies
+// fastest).
+type Dataset struct {
+	Name       string
+	Dims       []int
+	Chunks     []int // chunk shape; defaults to the whole array
+	Data       []float32
+	Level      int // deflate level, 0 for no compression
+	Attributes []Attribute
+}
+
+// writer lays out the file in memory.
+type writer struct {
+	b []byte
+}
+
+func (w *writer) alloc(n int) int {
+	off := len(w.b)
+	w.b = append(w.b, make([]byte, n)...)
+	return off
+}
+
+func (w *writer) put8(off int, v uint64) { binary.LittleEndian.PutUint64(w.b[off:], v) }
+
+func append16(b []byte, v uint16) []byte { return append(b, byte(v), byte(v>>8)) }
+
+func append32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func append64(b []byte, v uint64) []byte {
+	return append32(append32(b, uint32(v)), uint32(v>>32))
+}
+
+// pad8 rounds n up to a multiple of 8.
+func pad8(n int) int { return (n + 7) &^ 7 }
+
+// message is a header message; its data is padded to 8 bytes when encoded.
+type message struct {
+	typ  uint16
+	data []byte
+}
+
+// objectHeader encodes a version 1 object header.
+func objectHeader(msgs []message) []byte {
+	size := 0
+	for _, m := range msgs {
+		size += 8 + pad8(len(m.data))
+	}
+	b := make([]byte, 16, 16+size)
+	b[0] = 1
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(msgs)))
+	binary.LittleEndian.PutUint32(b[4:], 1)
+	binary.LittleEndian.PutUint32(b[8:], uint32(size))
+	for _, m := range msgs {
+		var h [8]byte
+		binary.LittleEndian.PutUint16(h[0:], m.typ)
+		binary.LittleEndian.PutUint16(h[2:], uint16(pad8(len(m.data))))
+		b = append(b, h[:]...)
+		b = append(b, m.data...)
+		b = append(b, make([]byte, pad8(len(m.data))-len(m.data))...)
+	}
+	return b
+}
+
+// dataspace encodes a version 1 dataspace; no dims is a scalar.
+func dataspace(dims []int) []byte {
+	b := make([]byte, 8, 8+8*len(dims))
+	b[0], b[1] = 1, byte(len(dims))
+	for _, d := range dims {
+		b = append64(b, uint64(d))
+	}
+	return b
+}
+
+// floatType encodes an IEEE little-endian float of 4 or 8 bytes.
+func floatType(size int) []byte {
+	b := []byte{0x11, 0x20, 0, 0}
+	b = append32(b, uint32(size))
+	if size == 4 {
+		b[2] = 31
+		b = append(b, 0, 0, 32, 0, 23, 8, 0, 23)
+		return append32(b, 127)
+	}
+	b[2] = 63
+	b = append(b, 0, 0, 64, 0, 52, 11, 0, 52)
+	return append32(b, 1023)
+}
+
+// int32Type encodes a signed little-endian 32-bit integer.
+func int32Type() []byte {
+	b := []byte{0x10, 0x08, 0, 0}
+	b = append32(b, 4)
+	return append(b, 0, 0, 32, 0)
+}
+
+// attribute encodes a version 1 attribute message.
+func attribute(a Attribute) ([]byte, error) {
+	var typ, data []byte
+	dims := a.Dims
+	switch v := a.Value.(type) {
+	case int32:
+		typ, dims = int32Type(), nil
+		data = append32(nil, uint32(v))
+	case float64:
+		typ, dims = floatType(8), nil
+		data = append64(nil, math.Float64bits(v))
+	case []int32:
+		typ = int32Type()
+		for _, x := range v {
+			data = append32(data, uint32(x))
+		}
+		if dims == nil {
+			dims = []int{len(v)}
+		}
+	case []float64:
+		typ = floatType(8)
+		for _, x := range v {
+			data = append64(data, math.Float64bits(x))
+		}
+		if dims == nil {
+			dims = []int{len(v)}
+		}
+	default:
+		return nil, fmt.Errorf("attribute %s: unsupported value %T", a.Name, a.Value)
+	}
+	space := dataspace(dims)
+
+	b := []byte{1, 0}
+	b = append16(b, uint16(len(a.Name)+1))
+	b = append16(b, uint16(len(typ)))
+	b = append16(b, uint16(len(space)))
+	for _, f := range [][]byte{append([]byte(a.Name), 0), typ, space} {
+		b = append(b, f...)
+		b = append(b, make([]byte, pad8(len(f))-len(f))...)
+	}
+	return append(b, data...), nil
+}
+
+// Write writes a file holding the dataset in the root group.
+func Write(out io.Writer, ds Dataset) error {
+	rank := len(ds.Dims)
+	n := 1
+	for _, d := range ds.Dims {
+		n *= d
+	}
+	if rank == 0 || len(ds.Data) != n {
+		return fmt.Errorf("dataset %s: %d values for dims %v", ds.Name, len(ds.Data), ds.Dims)
+	}
+	chunks := ds.Chunks
+	if chunks == nil {
+		chunks = ds.Dims
+	}
+	if len(chunks) != rank {
+		return fmt.Errorf("dataset %s: chunk rank %d, expected %d", ds.Name, len(chunks), rank)
+	}
+	// The chunk B-tree orders keys from the last dimension, so chunks are
+	// only split along the first dimension to keep C order and key order
+	// the same.
+	for i := 1; i < rank; i++ {
+		if chunks[i] != ds.Dims[i] {
+			return fmt.Errorf("dataset %s: chunks may only split the first dimension", ds.Name)
+		}
+	}
+
+	// Chunk grid, in C order.
+	grid := make([]int, rank)
+	nchunks := 1
+	for i := range grid {
+		if chunks[i] < 1 {
+			return fmt.Errorf("dataset %s: invalid chunk shape %v", ds.Name, chunks)
+		}
+		grid[i] = (ds.Dims[i] + chunks[i] - 1) / chunks[i]
+		nchunks *= grid[i]
+	}
+	if nchunks > MaxChunks {
+		return fmt.Errorf("dataset %s: %d chunks, at most %d are supported", ds.Name, nchunks, MaxChunks)
+	}
+
+	w := &writer{}
+	w.alloc(superblockSize)
+
+	// Root group: object header, B-tree, local heap, and symbol table node.
+	root := objectHeader([]message{{msgSymbolTable, make([]byte, 16)}})
+	rootAddr := w.alloc(len(root))
+	copy(w.b[rootAddr:], root)
+
+	keySize := 8 // heap offset
+	btreeAddr := w.alloc(24 + (2*groupInternalK+1)*keySize + 2*groupInternalK*8)
+	heapData := make([]byte, 8+pad8(len(ds.Name)+1))
+	copy(heapData[8:], ds.Name)
+	heapAddr := w.alloc(32)
+	heapDataAddr := w.alloc(len(heapData))
+	copy(w.b[heapDataAddr:], heapData)
+	snodAddr := w.alloc(8 + 2*groupLeafK*entrySize)
+
+	binary.LittleEndian.PutUint64(w.b[rootAddr+16+8:], uint64(btreeAddr))
+	binary.LittleEndian.PutUint64(w.b[rootAddr+16+16:], uint64(heapAddr))
+
+	copy(w.b[btreeAddr:], "TREE")
+	w.b[btreeAddr+4], w.b[btreeAddr+5] = 0, 0 // group node, leaf
+	binary.LittleEndian.PutUint16(w.b[btreeAddr+6:], 1)
+	w.put8(btreeAddr+8, undefined)
+	w.put8(btreeAddr+16, undefined)
+	w.put8(btreeAddr+24, 0)                // key: ""
+	w.put8(btreeAddr+32, uint64(snodAddr)) // child
+	w.put8(btreeAddr+40, 8)                // key: the dataset name
+
+	copy(w.b[heapAddr:], "HEAP")
+	w.put8(heapAddr+8, uint64(len(heapData)))
+	w.put8(heapAddr+16, freeListEnd)
+	w.put8(heapAddr+24, uint64(heapDataAddr))
+
+	copy(w.b[snodAddr:], "SNOD")
+	w.b[snodAddr+4] = 1
+	binary.LittleEndian.PutUint16(w.b[snodAddr+6:], 1)
+	w.put8(snodAddr+8, 8) // name offset in the heap; the header address follows
+
+	// Dataset object header.
+	var msgs []message
+	msgs = append(msgs, message{msgDataspace, dataspace(ds.Dims)})
+	msgs = append(msgs, message{msgDatatype, floatType(4)})
+	msgs = append(msgs, message{msgFillValue, []byte{2, 3, 0, 0}})
+
+	layout := []byte{3, 2, byte(rank + 1), 0, 0, 0, 0, 0, 0, 0, 0}
+	for _, c := range chunks {
+		layout = append32(layout, uint32(c))
+	}
+	layout = append32(layout, 4)
+	msgs = append(msgs, message{msgLayout, layout})
+
+	if ds.Level > 0 {
+		filter := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+		filter = append(filter, 1, 0, 0, 0, 0, 0, 1, 0) // deflate, no name, 1 value
+		filter = append32(filter, uint32(ds.Level))
+		filter = append(filter, 0, 0, 0, 0)
+		msgs = append(msgs, message{msgFilter, filter})
+	}
+	for _, a := range ds.Attributes {
+		b, err := attribute(a)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, message{msgAttribute, b})
+	}
+	header := objectHeader(msgs)
+	dsAddr := w.alloc(len(header))
+	copy(w.b[dsAddr:], header)
+	w.put8(snodAddr+8+8, uint64(dsAddr))
+
+	// The layout message is the fourth; its B-tree address follows the
+	// version, class, and dimensionality bytes.
+	layoutAddr := dsAddr + 16
+	for _, m := range msgs[:3] {
+		layoutAddr += 8 + pad8(len(m.data))
+	}
+	layoutAddr += 8 + 3
+
+	// Chunk B-tree leaf.
+	ckeySize := 8 + 8*(rank+1)
+	cbtreeAddr := w.alloc(24 + (2*chunkK+1)*ckeySize + 2*chunkK*8)
+	w.put8(layoutAddr, uint64(cbtreeAddr))
+	copy(w.b[cbtreeAddr:], "TREE")
+	w.b[cbtreeAddr+4], w.b[cbtreeAddr+5] = 1, 0 // chunk node, leaf
+	binary.LittleEndian.PutUint16(w.b[cbtreeAddr+6:], uint16(nchunks))
+	w.put8(cbtreeAddr+8, undefined)
+	w.put8(cbtreeAddr+16, undefined)
+
+	// Write the chunks in C order of the grid.
+	strides := make([]int, rank)
+	strides[rank-1] = 1
+	for i := rank - 2; i >= 0; i-- {
+		strides[i] = strides[i+1] * ds.Dims[i+1]
+	}
+	chunkLen := 1
+	for _, c := range chunks {
+		chunkLen *= c
+	}
+	pos := cbtreeAddr + 24
+	idx := make([]int, rank) // chunk index in the grid
+	for c := 0; c < nchunks; c++ {
+		raw := make([]byte, 4*chunkLen)
+		origin := make([]int, rank)
+		for i := range origin {
+			origin[i] = idx[i] * chunks[i]
+		}
+		// Copy the chunk element by element; edge chunks are zero padded.
+		el := make([]int, rank)
+		for e := 0; e < chunkLen; e++ {
+			src, inside := 0, true
+			for i := 0; i < rank; i++ {
+				x := origin[i] + el[i]
+				if x >= ds.Dims[i] {
+					inside = false
+					break
+				}
+				src += x * strides[i]
+			}
+			if inside {
+				binary.LittleEndian.PutUint32(raw[4*e:], math.Float32bits(ds.Data[src]))
+			}
+			for i := rank - 1; i >= 0; i-- {
+				if el[i]++; el[i] < chunks[i] {
+					break
+				}
+				el[i] = 0
+			}
+		}
+
+		stored := raw
+		if ds.Level > 0 {
+			var buf bytes.Buffer
+			z, err := zlib.NewWriterLevel(&buf, ds.Level)
+			if err != nil {
+				return err
+			}
+			z.Write(raw)
+			if err := z.Close(); err != nil {
+				return err
+			}
+			stored = buf.Bytes()
+		}
+		addr := w.alloc(len(stored))
+		copy(w.b[addr:], stored)
+
+		binary.LittleEndian.PutUint32(w.b[pos:], uint32(len(stored)))
+		for i := 0; i < rank; i++ {
+			w.put8(pos+8+8*i, uint64(origin[i]))
+		}
+		w.put8(pos+ckeySize, uint64(addr))
+		pos += ckeySize + 8
+
+		for i := rank - 1; i >= 0; i-- {
+			if idx[i]++; idx[i] < grid[i] {
+				break
+			}
+			idx[i] = 0
+		}
+	}
+	// The last key bounds the final chunk.
+	w.put8(pos+8, uint64(grid[0]*chunks[0]))
+
+	// Superblock.
+	sb := w.b[:superblockSize]
+	copy(sb, "\x89HDF\r\n\x1a\n")
+	sb[13], sb[14] = 8, 8 // sizes of offsets and lengths
+	binary.LittleEndian.PutUint16(sb[16:], groupLeafK)
+	binary.LittleEndian.PutUint16(sb[18:], groupInternalK)
+	w.put8(24, 0) // base address
+	w.put8(32, undefined)
+	w.put8(40, uint64(len(w.b)))
+	w.put8(48, undefined)
+	w.put8(56, 0) // root entry: name offset
+	w.put8(64, uint64(rootAddr))
+	binary.LittleEndian.PutUint32(sb[72:], 1) // cached symbol table
+	w.put8(80, uint64(btreeAddr))
+	w.put8(88, uint64(heapAddr))
+
+	_, err := out.Write(w.b)
+	return err
+}
+
+// WriteFile writes a file holding the dataset to filename.
+func WriteFile(filename string, ds Dataset) error {
+	var b bytes.Buffer
+	if err := Write(&b, ds); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filename, b.Bytes(), 0644)
+}
@@ -0,0 +1,73 @@
+package inference
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LabelOrder returns the distinct labels of a label map in increasing
+// order, the default channel order of OneHot.
+func LabelOrder(labels []int) []int {
+	seen := map[int]bool{}
+	var order []int
+	for _, l := range labels {
+		if !seen[l] {
+			seen[l] = true
+			order = append(order, l)
+		}
+	}
+	sort.Ints(order)
+	return order
+}
+
+// OneHot encodes a label map as one volume per label of order, 1 where the
+// voxel has the label and 0 elsewhere. With label smoothing, the values are
+// 1-smoothing+smoothing/C and smoothing/C instead, for C channels. Labels
+// missing from order are an error.
+func OneHot(labels []int, order []int, smoothing float64) ([]float64, error) {
+	if smoothing < 0 || smoothing >= 1 {
+		return nil, fmt.Errorf("label smoothing must be in [0, 1), got %g", smoothing)
+	}
+	channel := make(map[int]int, len(order))
+	for c, l := range order {
+		if _, ok := channel[l]; ok {
+			return nil, fmt.Errorf("label %d is listed twice", l)
+		}
+		channel[l] = c
+	}
+	nxyz := len(labels)
+	off := smoothing / float64(len(order))
+	on := 1 - smoothing + off
+	out := make([]float64, len(order)*nxyz)
+	if off != 0 {
+		for i := range out {
+			out[i] = off
+		}
+	}
+	var missing []int
+	for m, l := range labels {
+		c, ok := channel[l]
+		if !ok {
+			missing = append(missing, l)
+			continue
+		}
+		out[c*nxyz+m] = on
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("labels %v are not among the channels %v", LabelOrder(missing), order)
+	}
+	return out, nil
+}
+
+// FromOneHot decodes channels of a one-hot encoding, or of probabilities,
+// into a label map: each voxel gets the label of its highest channel.
+func FromOneHot(values []float64, nxyz int, order []int) ([]int, error) {
+	if len(values) != len(order)*nxyz {
+		return nil, fmt.Errorf("got %d values for %d channels of %d voxels", len(values), len(order), nxyz)
+	}
+	labels := Argmax(values, nxyz, 0, 0)
+	for m, c := range labels {
+		labels[m] = order[c]
+	}
+	return labels, nil
+}
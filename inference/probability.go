@@ -0,0 +1,92 @@
+package inference
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Channels returns the number of channels of a multi-channel model output:
+// its 4th dimension, or its 5th, the NIfTI dimension of vector values, if
+// the 4th is 1.
+func Channels(img *nifti1.Image) (int, error) {
+	switch {
+	case img.Nt > 1 && img.Nu > 1:
+		return 0, fmt.Errorf("channels must be along dim 4 or dim 5, not both (%d×%d)", img.Nt, img.Nu)
+	case img.Nu > 1:
+		return img.Nu, nil
+	}
+	return img.Nt, nil
+}
+
+// Softmax converts logits, channels volumes of nxyz voxels, into
+// probabilities in place.
+func Softmax(values []float64, nxyz int) {
+	channels := len(values) / nxyz
+	for m := 0; m < nxyz; m++ {
+		max := math.Inf(-1)
+		for c := 0; c < channels; c++ {
+			max = math.Max(max, values[c*nxyz+m])
+		}
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			e := math.Exp(values[c*nxyz+m] - max)
+			values[c*nxyz+m] = e
+			sum += e
+		}
+		for c := 0; c < channels; c++ {
+			values[c*nxyz+m] /= sum
+		}
+	}
+}
+
+// Argmax returns the channel of highest probability at each voxel of
+// channels volumes of nxyz voxels, plus offset; offset 0 makes channel 0
+// the background label. Voxels whose highest probability is below
+// threshold get label 0, as do voxels whose values are all NaN.
+func Argmax(values []float64, nxyz, offset int, threshold float64) []int {
+	channels := len(values) / nxyz
+	labels := make([]int, nxyz)
+	for m := range labels {
+		best, max := -1, math.Inf(-1)
+		for c := 0; c < channels; c++ {
+			if v := values[c*nxyz+m]; v > max {
+				best, max = c, v
+			}
+		}
+		if best >= 0 && max >= threshold {
+			labels[m] = best + offset
+		}
+	}
+	return labels
+}
+
+// Entropy returns the Shannon entropy in nats of the probabilities at each
+// voxel, channels volumes of nxyz voxels, divided by log(channels) if
+// normalize is set so that it ranges from 0 (certain) to 1 (uniform).
+// Probabilities are renormalized to sum to 1, and negative ones taken as 0.
+func Entropy(values []float64, nxyz int, normalize bool) []float64 {
+	channels := len(values) / nxyz
+	out := make([]float64, nxyz)
+	for m := range out {
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			sum += math.Max(values[c*nxyz+m], 0)
+		}
+		if !(sum > 0) {
+			continue
+		}
+		h := 0.0
+		for c := 0; c < channels; c++ {
+			if p := math.Max(values[c*nxyz+m], 0) / sum; p > 0 {
+				h -= p * math.Log(p)
+			}
+		}
+		if normalize && channels > 1 {
+			h /= math.Log(float64(channels))
+		}
+		out[m] = h
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+// inference runs patch-based models over whole images: sliding windows of
+// overlapping patches whose predictions are blended with Gaussian weights,
+// and the post-processing of their probability maps.
+
+package inference
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Predictor runs a model on one patch. in holds the input channels of a
+// patch of size voxels, one after the other with i fastest in each, and
+// out must hold the output channels in the same layout. The number of
+// output channels is up to the model, but must not change between patches.
+// in is reused between calls.
+type Predictor func(in []float32, size [3]int) (out []float32, err error)
+
+// WindowOptions configure a sliding window.
+type WindowOptions struct {
+	// Size is the size of the patches in voxels.
+	Size [3]int
+	// Overlap is the fraction of a patch shared with the next along each
+	// axis, in [0, 1).
+	Overlap float64
+	// Sigma is the standard deviation of the Gaussian weights as a fraction
+	// of the patch size. Weights fall off toward the edges of a patch,
+	// where predictions are least reliable; 0 weighs all voxels alike.
+	Sigma float64
+}
+
+// DefaultWindowOptions use patches of 96 voxels that overlap by half, with
+// the weights of nnU-Net.
+var DefaultWindowOptions = WindowOptions{Size: [3]int{96, 96, 96}, Overlap: 0.5, Sigma: 0.125}
+
+// starts returns the first voxels of the patches along an axis of n voxels,
+// evenly spaced from 0 to n-size so that they cover the axis.
+func starts(n, size int, overlap float64) []int {
+	if n <= size {
+		return []int{0}
+	}
+	step := math.Max(1, float64(size)*(1-overlap))
+	count := int(math.Ceil(float64(n-size)/step)) + 1
+	s := make([]int, count)
+	for i := range s {
+		s[i] = int(math.Round(float64(i) * float64(n-size) / float64(count-1)))
+	}
+	return s
+}
+
+// gaussian returns the weights of a patch, at least a small positive value
+// so that every voxel of the image is covered.
+func gaussian(size [3]int, sigma float64) []float64 {
+	var axes [3][]float64
+	for a := 0; a < 3; a++ {
+		axes[a] = make([]float64, size[a])
+		c := float64(size[a]-1) / 2
+		sd := sigma * float64(size[a])
+		for i := range axes[a] {
+			axes[a][i] = 1
+			if sd > 0 {
+				d := (float64(i) - c) / sd
+				axes[a][i] = math.Exp(-d * d / 2)
+			}
+		}
+	}
+	w := make([]float64, size[0]*size[1]*size[2])
+	for k := 0; k < size[2]; k++ {
+		for j := 0; j < size[1]; j++ {
+			for i := 0; i < size[0]; i++ {
+				w[i+size[0]*(j+size[1]*k)] = math.Max(axes[0][i]*axes[1][j]*axes[2][k], 1e-6)
+			}
+		}
+	}
+	return w
+}
+
+// SlidingWindow runs predict on overlapping patches of values, which holds
+// channels volumes of dims voxels, and blends the outputs into volumes of
+// the same grid, one per output channel. Patches that extend past the grid
+// are padded with zeros and their padding is discarded.
+func SlidingWindow(values []float64, dims [3]int, predict Predictor, o WindowOptions) ([]float64, int, error) {
+	nxyz := dims[0] * dims[1] * dims[2]
+	if nxyz == 0 || len(values)%nxyz != 0 {
+		return nil, 0, fmt.Errorf("got %d values for %v voxels", len(values), dims)
+	}
+	if o.Overlap < 0 || o.Overlap >= 1 {
+		return nil, 0, fmt.Errorf("overlap must be in [0, 1), got %g", o.Overlap)
+	}
+	size := o.Size
+	for a := 0; a < 3; a++ {
+		if size[a] < 1 {
+			return nil, 0, fmt.Errorf("invalid patch size %v", o.Size)
+		}
+	}
+	channels := len(values) / nxyz
+	np := size[0] * size[1] * size[2]
+	weight := gaussian(size, o.Sigma)
+
+	var sum []float64
+	total := make([]float64, nxyz)
+	outChannels := 0
+	in := make([]float32, channels*np)
+	for _, z := range starts(dims[2], size[2], o.Overlap) {
+		for _, y := range starts(dims[1], size[1], o.Overlap) {
+			for _, x := range starts(dims[0], size[0], o.Overlap) {
+				origin := [3]int{x, y, z}
+				for i := range in {
+					in[i] = 0
+				}
+				eachVoxel(origin, size, dims, func(p, m int) {
+					for c := 0; c < channels; c++ {
+						in[c*np+p] = float32(values[c*nxyz+m])
+					}
+				})
+				out, err := predict(in, size)
+				if err != nil {
+					return nil, 0, err
+				}
+				if len(out) == 0 || len(out)%np != 0 {
+					return nil, 0, fmt.Errorf("predictor returned %d values for a patch of %v voxels", len(out), size)
+				}
+				if sum == nil {
+					outChannels = len(out) / np
+					sum = make([]float64, outChannels*nxyz)
+				} else if len(out) != outChannels*np {
+					return nil, 0, fmt.Errorf("predictor returned %d channels, then %d", outChannels, len(out)/np)
+				}
+				eachVoxel(origin, size, dims, func(p, m int) {
+					w := weight[p]
+					total[m] += w
+					for c := 0; c < outChannels; c++ {
+						sum[c*nxyz+m] += w * float64(out[c*np+p])
+					}
+				})
+			}
+		}
+	}
+	for m, w := range total {
+		for c := 0; c < outChannels; c++ {
+			sum[c*nxyz+m] /= w
+		}
+	}
+	return sum, outChannels, nil
+}
+
+// eachVoxel calls fn with the index within the patch and within the grid of
+// every voxel of a patch at origin that lies inside the grid.
+func eachVoxel(origin, size, dims [3]int, fn func(p, m int)) {
+	for k := 0; k < size[2] && origin[2]+k < dims[2]; k++ {
+		for j := 0; j < size[1] && origin[1]+j < dims[1]; j++ {
+			row := dims[0] * (origin[1] + j + dims[1]*(origin[2]+k))
+			for i := 0; i < size[0] && origin[0]+i < dims[0]; i++ {
+				fn(i+size[0]*(j+size[1]*k), row+origin[0]+i)
+			}
+		}
+	}
+}
+
+// Predict runs predict over img with a sliding window, with the volumes of
+// img as input channels, and returns a DT_FLOAT32 image on its grid with
+// one volume per output channel.
+func Predict(img *nifti1.Image, predict Predictor, o WindowOptions) (*nifti1.Image, error) {
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	out, channels, err := SlidingWindow(values, dims, predict, o)
+	if err != nil {
+		return nil, err
+	}
+	res := *img
+	if channels > 1 {
+		err = res.SetDims(dims[0], dims[1], dims[2], channels)
+	} else {
+		err = res.SetDims(dims[0], dims[1], dims[2])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := res.SetFloat32Data(out); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,292 @@
+// interp contains interpolators of 3D volumes at fractional voxel
+// indices: nearest neighbour, trilinear, cubic B-spline, and Lanczos
+// windowed sinc, each with a choice of how the volume is extended beyond
+// its grid.
+
+package interp
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Interpolation methods, from fastest to most accurate.
+const (
+	Nearest = "nearest"
+	Linear  = "linear"
+	Cubic   = "cubic" // cubic B-spline
+	Sinc    = "sinc"  // Lanczos windowed sinc with 3 lobes
+)
+
+// Methods lists the interpolation methods.
+var Methods = []string{Nearest, Linear, Cubic, Sinc}
+
+// Boundary is how a volume is extended beyond its grid.
+type Boundary int
+
+// Boundary modes.
+const (
+	// Zero is 0 outside the grid, and points there are not sampled.
+	// Kernels reaching past the edge from inside use Mirror, so that
+	// interpolation within the grid is the same as with Mirror.
+	Zero Boundary = iota
+	// Clamp repeats the edge voxels.
+	Clamp
+	// Mirror reflects the volume about the centers of the edge voxels.
+	Mirror
+)
+
+// ParseBoundary returns the boundary mode with the name "zero", "clamp",
+// or "mirror".
+func ParseBoundary(s string) (Boundary, error) {
+	switch s {
+	case "zero":
+		return Zero, nil
+	case "clamp":
+		return Clamp, nil
+	case "mirror":
+		return Mirror, nil
+	}
+	return 0, fmt.Errorf("unknown boundary mode %q", s)
+}
+
+// String returns the name of the boundary mode.
+func (b Boundary) String() string {
+	switch b {
+	case Zero:
+		return "zero"
+	case Clamp:
+		return "clamp"
+	case Mirror:
+		return "mirror"
+	}
+	return fmt.Sprintf("Boundary(%d)", int(b))
+}
+
+// index returns the voxel index of grid position i on an axis of n voxels.
+func (b Boundary) index(i, n int) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	if b == Clamp {
+		if i < 0 {
+			return 0
+		}
+		return n - 1
+	}
+	if n == 1 {
+		return 0
+	}
+	period := 2*n - 2
+	i %= period
+	if i < 0 {
+		i += period
+	}
+	if i >= n {
+		i = period - i
+	}
+	return i
+}
+
+// Options selects an interpolator.
+type Options struct {
+	Method   string
+	Boundary Boundary
+}
+
+// Interpolator samples a volume at fractional voxel indices (i, j, k).
+type Interpolator interface {
+	// Sample returns the value at p. It reports false for points outside
+	// the grid with the Zero boundary, whose value is 0.
+	Sample(p [3]float64) (float64, bool)
+}
+
+// maxTaps is the largest number of voxels used along an axis.
+const maxTaps = 6
+
+// kernel weighs the voxels around a point along one axis.
+type kernel struct {
+	// radius is the number of voxels used on each side of the point.
+	radius int
+	// weight returns the weight of a voxel at distance d from the point.
+	weight func(d float64) float64
+	// normalize divides the weights by their sum.
+	normalize bool
+}
+
+// separable interpolates with the product of a kernel along each axis, or
+// by nearest neighbour if the kernel is nil.
+type separable struct {
+	values   []float64
+	dims     [3]int
+	boundary Boundary
+	k        *kernel
+}
+
+// New returns an interpolator of values, a volume of dims voxels stored
+// with i fastest. Values are used as given, except by Cubic, which
+// computes spline coefficients of its own.
+func New(values []float64, dims [3]int, o Options) (Interpolator, error) {
+	if len(values) != dims[0]*dims[1]*dims[2] {
+		return nil, fmt.Errorf("got %d values for %v voxels", len(values), dims)
+	}
+	if o.Boundary < Zero || o.Boundary > Mirror {
+		return nil, fmt.Errorf("invalid boundary mode %d", int(o.Boundary))
+	}
+	s := &separable{values: values, dims: dims, boundary: o.Boundary}
+	switch o.Method {
+	case Nearest:
+	case Linear, "":
+		s.k = &kernel{radius: 1, weight: func(d float64) float64 {
+			return math.Max(0, 1-math.Abs(d))
+		}}
+	case Cubic:
+		s.values = splineCoefficients(values, dims)
+		s.k = &kernel{radius: 2, weight: bspline3}
+	case Sinc:
+		s.k = &kernel{radius: 3, weight: lanczos3, normalize: true}
+	default:
+		return nil, fmt.Errorf("%w interpolation %q", nifti1.ErrUnsupported, o.Method)
+	}
+	return s, nil
+}
+
+// Sample returns the value at p.
+func (s *separable) Sample(p [3]float64) (float64, bool) {
+	var idx [3][maxTaps]int
+	var w [3][maxTaps]float64
+	var taps [3]int
+	for a := 0; a < 3; a++ {
+		n := s.dims[a]
+		if s.boundary == Zero && (p[a] < 0 || p[a] > float64(n-1)) {
+			return 0, false
+		}
+		if s.k == nil {
+			idx[a][0], w[a][0], taps[a] = s.boundary.index(int(math.Round(p[a])), n), 1, 1
+			continue
+		}
+		base := int(math.Floor(p[a]))
+		sum := 0.0
+		for t := 0; t < 2*s.k.radius; t++ {
+			i := base - s.k.radius + 1 + t
+			idx[a][t] = s.boundary.index(i, n)
+			w[a][t] = s.k.weight(p[a] - float64(i))
+			sum += w[a][t]
+		}
+		if s.k.normalize && sum != 0 {
+			for t := 0; t < 2*s.k.radius; t++ {
+				w[a][t] /= sum
+			}
+		}
+		taps[a] = 2 * s.k.radius
+	}
+
+	nx, nxy := s.dims[0], s.dims[0]*s.dims[1]
+	v := 0.0
+	for c := 0; c < taps[2]; c++ {
+		if w[2][c] == 0 {
+			continue
+		}
+		for b := 0; b < taps[1]; b++ {
+			if w[1][b] == 0 {
+				continue
+			}
+			row := idx[2][c]*nxy + idx[1][b]*nx
+			wcb := w[2][c] * w[1][b]
+			for a := 0; a < taps[0]; a++ {
+				if w[0][a] == 0 {
+					continue
+				}
+				v += wcb * w[0][a] * s.values[row+idx[0][a]]
+			}
+		}
+	}
+	return v, true
+}
+
+// bspline3 is the cubic B-spline.
+func bspline3(d float64) float64 {
+	d = math.Abs(d)
+	switch {
+	case d < 1:
+		return 2.0/3 - d*d + d*d*d/2
+	case d < 2:
+		e := 2 - d
+		return e * e * e / 6
+	}
+	return 0
+}
+
+// lanczos3 is the sinc function windowed by a sinc three times as wide.
+func lanczos3(d float64) float64 {
+	const a = 3
+	switch {
+	case d == 0:
+		return 1
+	case math.Abs(d) >= a:
+		return 0
+	}
+	x := math.Pi * d
+	return a * math.Sin(x) * math.Sin(x/a) / (x * x)
+}
+
+// splineCoefficients returns the cubic B-spline coefficients that
+// interpolate values, by the recursive filter of Unser et al. (1991) along
+// each axis with mirror-symmetric boundaries.
+func splineCoefficients(values []float64, dims [3]int) []float64 {
+	c := append([]float64(nil), values...)
+	stride := [3]int{1, dims[0], dims[0] * dims[1]}
+	for a := 0; a < 3; a++ {
+		n := dims[a]
+		if n < 2 {
+			continue
+		}
+		line := make([]float64, n)
+		for start := 0; start < len(c); start++ {
+			// Visit each line along the axis once, from its first voxel.
+			if start/stride[a]%n != 0 {
+				continue
+			}
+			for i := range line {
+				line[i] = c[start+i*stride[a]]
+			}
+			prefilter(line)
+			for i, x := range line {
+				c[start+i*stride[a]] = x
+			}
+		}
+	}
+	return c
+}
+
+// prefilter replaces samples with cubic B-spline coefficients in place.
+func prefilter(x []float64) {
+	z := math.Sqrt(3) - 2
+	n := len(x)
+	for i := range x {
+		x[i] *= (1 - z) * (1 - 1/z)
+	}
+
+	// Causal initialization, truncated where z^k is negligible.
+	horizon := n
+	if h := int(math.Ceil(math.Log(1e-12) / math.Log(math.Abs(z)))); h < n {
+		horizon = h
+	}
+	sum, zk := x[0], z
+	for k := 1; k < horizon; k++ {
+		sum += zk * x[k]
+		zk *= z
+	}
+	x[0] = sum
+	for k := 1; k < n; k++ {
+		x[k] += z * x[k-1]
+	}
+
+	// Anticausal initialization and recursion.
+	x[n-1] = z / (z*z - 1) * (z*x[n-2] + x[n-1])
+	for k := n - 2; k >= 0; k-- {
+		x[k] = z * (x[k+1] - x[k])
+	}
+}
@@ -0,0 +1,45 @@
+package interp
+
+import "math"
+
+// GaussianSmooth returns values, a volume of dims voxels stored with i
+// fastest, convolved with a Gaussian of standard deviation sigma voxels
+// along each axis. Axes with sigma 0 are left alone. The kernel is
+// truncated at 3 sigma and renormalized where it reaches past the grid.
+func GaussianSmooth(values []float64, dims [3]int, sigma [3]float64) []float64 {
+	out := append([]float64(nil), values...)
+	stride := [3]int{1, dims[0], dims[0] * dims[1]}
+	for a := 0; a < 3; a++ {
+		if sigma[a] <= 0 || dims[a] < 2 {
+			continue
+		}
+		radius := int(math.Ceil(3 * sigma[a]))
+		kernel := make([]float64, 2*radius+1)
+		for d := -radius; d <= radius; d++ {
+			x := float64(d) / sigma[a]
+			kernel[d+radius] = math.Exp(-x * x / 2)
+		}
+		n := dims[a]
+		line := make([]float64, n)
+		for start := 0; start < len(out); start++ {
+			// Visit each line along the axis once, from its first voxel.
+			if start/stride[a]%n != 0 {
+				continue
+			}
+			for i := range line {
+				line[i] = out[start+i*stride[a]]
+			}
+			for i := 0; i < n; i++ {
+				sum, weight := 0.0, 0.0
+				for d := -radius; d <= radius; d++ {
+					if j := i + d; j >= 0 && j < n {
+						sum += kernel[d+radius] * line[j]
+						weight += kernel[d+radius]
+					}
+				}
+				out[start+i*stride[a]] = sum / weight
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package linalg
+
+import "math"
+
+// SymEigen3 returns the eigenvalues of a symmetric 3x3 matrix in decreasing
+// order, and the corresponding unit eigenvectors as the columns of a
+// matrix, by cyclic Jacobi rotations.
+func SymEigen3(a [3][3]float64) ([3]float64, [3][3]float64) {
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	norm := 0.0
+	for i := range a {
+		for j := range a[i] {
+			norm += a[i][j] * a[i][j]
+		}
+	}
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off <= 1e-30*norm {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				// The rotation in the (p, q) plane that zeroes a[p][q].
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 3; k++ {
+					a[k][p], a[k][q] = c*a[k][p]-s*a[k][q], s*a[k][p]+c*a[k][q]
+				}
+				for k := 0; k < 3; k++ {
+					a[p][k], a[q][k] = c*a[p][k]-s*a[q][k], s*a[p][k]+c*a[q][k]
+				}
+				for k := 0; k < 3; k++ {
+					v[k][p], v[k][q] = c*v[k][p]-s*v[k][q], s*v[k][p]+c*v[k][q]
+				}
+			}
+		}
+	}
+
+	values := [3]float64{a[0][0], a[1][1], a[2][2]}
+	for i := 0; i < 2; i++ {
+		for j := i + 1; j < 3; j++ {
+			if values[j] > values[i] {
+				values[i], values[j] = values[j], values[i]
+				for k := 0; k < 3; k++ {
+					v[k][i], v[k][j] = v[k][j], v[k][i]
+				}
+			}
+		}
+	}
+	return values, v
+}
+
+// SVD3 returns the singular value decomposition m = u diag(s) vᵀ of a 3x3
+// matrix, with the singular values in decreasing order. The columns of u
+// for zero singular values are completed to an orthonormal basis.
+func SVD3(m [3][3]float64) (u [3][3]float64, s [3]float64, v [3][3]float64) {
+	var mtm [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				mtm[i][j] += m[k][i] * m[k][j]
+			}
+		}
+	}
+	values, v := SymEigen3(mtm)
+
+	// u = m v / s for the nonzero singular values.
+	var cols [3][3]float64
+	rank := 0
+	for c := 0; c < 3; c++ {
+		s[c] = math.Sqrt(math.Max(values[c], 0))
+		if s[c] <= 1e-12*s[0] || s[c] == 0 {
+			s[c] = 0
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			for k := 0; k < 3; k++ {
+				cols[c][i] += m[i][k] * v[k][c]
+			}
+			cols[c][i] /= s[c]
+		}
+		rank++
+	}
+	switch rank {
+	case 0:
+		cols[0] = [3]float64{1, 0, 0}
+		fallthrough
+	case 1:
+		// Any unit vector perpendicular to the first.
+		a := cols[0]
+		e := [3]float64{1, 0, 0}
+		if math.Abs(a[0]) > 0.9 {
+			e = [3]float64{0, 1, 0}
+		}
+		cols[1] = normalize3(cross3(a, e))
+		fallthrough
+	case 2:
+		cols[2] = cross3(cols[0], cols[1])
+	}
+	for i := 0; i < 3; i++ {
+		for c := 0; c < 3; c++ {
+			u[i][c] = cols[c][i]
+		}
+	}
+	return u, s, v
+}
+
+func cross3(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func normalize3(a [3]float64) [3]float64 {
+	n := math.Sqrt(a[0]*a[0] + a[1]*a[1] + a[2]*a[2])
+	return [3]float64{a[0] / n, a[1] / n, a[2] / n}
+}
@@ -0,0 +1,204 @@
+// linalg contains small dense linear algebra routines used by the image
+// processing packages.
+
+package linalg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrSingular is returned when a matrix is singular or rank deficient.
+var ErrSingular = errors.New("matrix is singular")
+
+// Dense is a row-major dense matrix.
+type Dense struct {
+	Rows, Cols int
+	Data       []float64
+}
+
+// NewDense returns a zero matrix with r rows and c columns.
+func NewDense(r, c int) *Dense {
+	return &Dense{Rows: r, Cols: c, Data: make([]float64, r*c)}
+}
+
+// At returns element (i, j).
+func (m *Dense) At(i, j int) float64 {
+	return m.Data[i*m.Cols+j]
+}
+
+// Set sets element (i, j).
+func (m *Dense) Set(i, j int, v float64) {
+	m.Data[i*m.Cols+j] = v
+}
+
+// Row returns row i, sharing memory with m.
+func (m *Dense) Row(i int) []float64 {
+	return m.Data[i*m.Cols : (i+1)*m.Cols]
+}
+
+// Clone returns a copy of m.
+func (m *Dense) Clone() *Dense {
+	c := NewDense(m.Rows, m.Cols)
+	copy(c.Data, m.Data)
+	return c
+}
+
+// QR is the Householder QR factorization of a matrix with at least as many
+// rows as columns.
+type QR struct {
+	qr    *Dense    // R above the diagonal, Householder vectors below
+	rdiag []float64 // diagonal of R
+}
+
+// NewQR factorizes a. The matrix a is not modified.
+func NewQR(a *Dense) (*QR, error) {
+	if a.Rows < a.Cols {
+		return nil, fmt.Errorf("QR needs rows >= cols, got %dx%d", a.Rows, a.Cols)
+	}
+	m, n := a.Rows, a.Cols
+	qr := a.Clone()
+	rdiag := make([]float64, n)
+
+	for k := 0; k < n; k++ {
+		norm := 0.0
+		for i := k; i < m; i++ {
+			norm = math.Hypot(norm, qr.At(i, k))
+		}
+		if norm != 0 {
+			if qr.At(k, k) < 0 {
+				norm = -norm
+			}
+			for i := k; i < m; i++ {
+				qr.Set(i, k, qr.At(i, k)/norm)
+			}
+			qr.Set(k, k, qr.At(k, k)+1)
+
+			for j := k + 1; j < n; j++ {
+				s := 0.0
+				for i := k; i < m; i++ {
+					s += qr.At(i, k) * qr.At(i, j)
+				}
+				s = -s / qr.At(k, k)
+				for i := k; i < m; i++ {
+					qr.Set(i, j, qr.At(i, j)+s*qr.At(i, k))
+				}
+			}
+		}
+		rdiag[k] = -norm
+	}
+
+	return &QR{qr: qr, rdiag: rdiag}, nil
+}
+
+// FullRank reports whether R has no (numerically) zero diagonal element.
+func (f *QR) FullRank() bool {
+	maxAbs := 0.0
+	for _, d := range f.rdiag {
+		maxAbs = math.Max(maxAbs, math.Abs(d))
+	}
+	tol := maxAbs * 1e-12 * float64(f.qr.Rows)
+	for _, d := range f.rdiag {
+		if math.Abs(d) <= tol {
+			return false
+		}
+	}
+	return true
+}
+
+// Solve returns the least-squares solution x minimizing ||a x - b||.
+func (f *QR) Solve(b []float64) ([]float64, error) {
+	m, n := f.qr.Rows, f.qr.Cols
+	if len(b) != m {
+		return nil, fmt.Errorf("right-hand side has length %d, expected %d", len(b), m)
+	}
+	if !f.FullRank() {
+		return nil, ErrSingular
+	}
+
+	y := make([]float64, m)
+	copy(y, b)
+
+	// Compute Q^T b.
+	for k := 0; k < n; k++ {
+		s := 0.0
+		for i := k; i < m; i++ {
+			s += f.qr.At(i, k) * y[i]
+		}
+		s = -s / f.qr.At(k, k)
+		for i := k; i < m; i++ {
+			y[i] += s * f.qr.At(i, k)
+		}
+	}
+
+	// Solve R x = Q^T b.
+	x := make([]float64, n)
+	for k := n - 1; k >= 0; k-- {
+		s := y[k]
+		for j := k + 1; j < n; j++ {
+			s -= f.qr.At(k, j) * x[j]
+		}
+		x[k] = s / f.rdiag[k]
+	}
+	return x, nil
+}
+
+// LeastSquares returns x minimizing ||a x - b||.
+func LeastSquares(a *Dense, b []float64) ([]float64, error) {
+	f, err := NewQR(a)
+	if err != nil {
+		return nil, err
+	}
+	return f.Solve(b)
+}
+
+// InvertAffine returns the inverse of a 4x4 affine matrix whose last row is
+// [0 0 0 1].
+func InvertAffine(a [4][4]float64) ([4][4]float64, error) {
+	var r [4][4]float64
+	det := a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+	if det == 0 {
+		return r, ErrSingular
+	}
+
+	r[0][0] = (a[1][1]*a[2][2] - a[1][2]*a[2][1]) / det
+	r[0][1] = (a[0][2]*a[2][1] - a[0][1]*a[2][2]) / det
+	r[0][2] = (a[0][1]*a[1][2] - a[0][2]*a[1][1]) / det
+	r[1][0] = (a[1][2]*a[2][0] - a[1][0]*a[2][2]) / det
+	r[1][1] = (a[0][0]*a[2][2] - a[0][2]*a[2][0]) / det
+	r[1][2] = (a[0][2]*a[1][0] - a[0][0]*a[1][2]) / det
+	r[2][0] = (a[1][0]*a[2][1] - a[1][1]*a[2][0]) / det
+	r[2][1] = (a[0][1]*a[2][0] - a[0][0]*a[2][1]) / det
+	r[2][2] = (a[0][0]*a[1][1] - a[0][1]*a[1][0]) / det
+
+	for i := 0; i < 3; i++ {
+		r[i][3] = -(r[i][0]*a[0][3] + r[i][1]*a[1][3] + r[i][2]*a[2][3])
+	}
+	r[3][3] = 1
+	return r, nil
+}
+
+// MulAffine returns the product a b of two 4x4 matrices.
+func MulAffine(a, b [4][4]float64) [4][4]float64 {
+	var r [4][4]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				r[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// ApplyAffine applies a 4x4 affine matrix to the point p.
+func ApplyAffine(a [4][4]float64, p [3]float64) [3]float64 {
+	return [3]float64{
+		a[0][0]*p[0] + a[0][1]*p[1] + a[0][2]*p[2] + a[0][3],
+		a[1][0]*p[0] + a[1][1]*p[1] + a[1][2]*p[2] + a[1][3],
+		a[2][0]*p[0] + a[2][1]*p[1] + a[2][2]*p[2] + a[2][3],
+	}
+}
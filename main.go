@@ -1,35 +1,175 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
 
-	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/cloud"
+	"github.com/kaczmarj/gonifti/templates"
 	"github.com/kaczmarj/gonifti/util"
 	log "github.com/sirupsen/logrus"
 )
 
+// command is a gonifti subcommand. run receives the arguments that follow the
+// command name.
+type command struct {
+	name  string
+	short string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{"info", "print the header of a nifti file", runInfo},
+	{"repack", "convert between .nii and .hdr/.img layouts", runRepack},
+	{"roistats", "print per-label volumes and intensity statistics", runRoistats},
+	{"animate", "render a slice across time or slices as a GIF or MP4", runAnimate},
+	{"mesh", "extract a surface mesh as STL, OBJ, or GIfTI", runMesh},
+	{"mip", "render a maximum intensity projection to PNG", runMIP},
+	{"biascorrect", "remove a smooth intensity bias field", runBiascorrect},
+	{"threshold", "make a mask with an automatic threshold", runThreshold},
+	{"quickbet", "make an approximate brain mask", runQuickbet},
+	{"deface", "remove the face using a face mask", runDeface},
+	{"check", "check the orientation and repair qform/sform conflicts", runCheck},
+	{"overlap", "print Dice, Jaccard, and Hausdorff metrics per label", runOverlap},
+	{"similarity", "print correlation, mutual information, and SSIM", runSimilarity},
+	{"view", "print a slice in the terminal", runView},
+	{"browse", "browse slices and volumes interactively in the terminal", runBrowse},
+	{"bids", "list the images of a BIDS dataset matching entities", runBids},
+	{"xnat", "list and fetch scans from an XNAT server", runXnat},
+	{"index", "index the images of a directory into a SQLite, Parquet, or TSV table", runIndex},
+	{"voxels", "export the voxels×time matrix as a Parquet table", runVoxels},
+	{"convert", "convert an image to HDF5, Zarr, TIFF, or another NIfTI-1 layout", runConvert},
+	{"templates", "list and download standard-space templates", runTemplates},
+	{"register", "register two images with a rigid or affine transform", runRegister},
+	{"transform", "resample an image through a chain of transforms, or compose and invert transforms", runTransform},
+	{"jacobian", "compute the Jacobian determinant map of a warp field", runJacobian},
+	{"tfilter", "apply a high-pass, low-pass, or band-pass filter to voxel time series", runTFilter},
+	{"regress", "regress confounds, such as motion parameters, out of voxel time series", runRegress},
+	{"connectivity", "compute the correlation matrix and seed maps of atlas regions", runConnectivity},
+	{"alff", "compute ALFF and fALFF maps of voxel time series", runALFF},
+	{"reho", "compute the regional homogeneity (ReHo) map of voxel time series", runReHo},
+	{"peri", "average time courses around events, per region or voxel", runPeri},
+	{"design", "build a GLM design matrix from a BIDS events file", runDesign},
+	{"permute", "run a permutation test on subject maps with FWE correction", runPermute},
+	{"fdr", "threshold a p-value map at a false discovery rate", runFDR},
+	{"cluster", "find significant clusters of a Z map by random field theory", runCluster},
+	{"smoothest", "estimate the smoothness (FWHM) of a 4D residual image", runSmoothest},
+	{"meants", "write mean time series of a mask or atlas labels, like fslmeants", runMeants},
+	{"psc", "convert a series or effect map to percent signal change", runPSC},
+	{"spikes", "detect outlier volumes by global signal and DVARS, and despike", runSpikes},
+	{"physio", "generate slice-specific RETROICOR regressors from physiological recordings", runPhysio},
+	{"checksum", "store per-volume or per-chunk checksums in an extension or sidecar", runChecksum},
+	{"verify", "find the corrupted chunks of files with stored checksums", runVerify},
+	{"manifest", "write or check a JSON manifest of the images of a directory for archival", runManifest},
+	{"ext", "list, remove, or add header extensions", runExt},
+	{"cohort", "generate synthetic subjects with noise, lesions, and head jitter from a template", runCohort},
+	{"lesion", "insert synthetic lesions and write their ground-truth labels", runLesion},
+	{"augment", "write a randomly flipped, rotated, deformed, and intensity-jittered copy of an image", runAugment},
+	{"probmap", "turn multi-channel probabilities into label, softmax, or entropy maps", runProbmap},
+	{"onehot", "convert between label maps and one-hot channels with label smoothing", runOnehot},
+	{"crop", "crop an image to the bounding box of its foreground", runCrop},
+	{"resample", "resample an image to isotropic voxels with anti-aliasing", runResample},
+	{"reslice", "render an oblique plane or slab through a world point to PNG", runReslice},
+	{"landmarks", "estimate a rigid or affine transform from corresponding landmarks", runLandmarks},
+	{"moments", "print centroids, centers of mass, and principal axes in world coordinates", runMoments},
+	{"origin", "move the world origin to a voxel, the center of mass, or the grid center", runOrigin},
+	{"bench", "time reading, decoding, streaming, and writing a file for performance reports", runBench},
+	{"presign", "print signed URLs for s3:// objects", runPresign},
+	{"conformance", "check gonifti against nifti_clib reference values", runConformance},
+	{"roi", "keep the volumes of a time range in seconds", runROI},
+	{"slices", "flag dropped or spiking slices of a 4D image", runSlices},
+	{"qc-epi", "report ghosting, tSNR, and outliers of an EPI series", runQCEPI},
+	{"qc-anat", "report SNR, CNR, and background noise of an anatomical image", runQCAnat},
+	{"report", "write an HTML QC report with metrics, montages, and a histogram", runReport},
+	{"version", "print the version and capabilities of this build", runVersion},
+	{"tonpy", "write the voxels as a NumPy .npy array", runToNpy},
+	{"slicetiming", "print or store the BIDS SliceTiming, with multiband support", runSliceTiming},
+	{"undo", "restore the header saved by -backup", runUndo},
+	{"examples", "run built-in example pipelines, such as QC of a BIDS subject", runExamples},
+	{"daemon", "run conversion and QC jobs submitted over HTTP within worker and memory limits", runDaemon},
+}
+
+// The completion and man commands walk commands, so they are registered in
+// init to avoid an initialization cycle.
+func init() {
+	commands = append(commands,
+		&command{"completion", "print a bash, zsh, or fish completion script", runCompletion},
+		&command{"man", "write man pages to a directory", runMan},
+	)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: gonifti [-profile cpu|mem|trace [-profile-out file]] [-cache [-force]] <command> [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.short)
+	}
+}
+
 func main() {
 
 	log.SetLevel(log.DebugLevel)
 
-	if len(os.Args) < 2 {
-		log.Fatal("nifti filename must be provided")
+	var err error
+	if cfg, err = loadSettings(); err != nil {
+		exitWithError("", err)
 	}
-	filename := os.Args[1]
-
-	// TODO(kaczmarj): move reading to nifti1.go
-	allBytes, err := util.ReadBytes(filename)
-	if err != nil {
-		log.Fatal(err)
+	if cfg.AnnexGet != "" {
+		util.AnnexGetHook = annexGetHook(cfg.AnnexGet)
 	}
+	templates.CacheDir = cfg.CacheDir
+	templates.TemplateFlowURL = cfg.TemplateFlowURL
+	util.DefaultRetry.Retries = cfg.Retries
+	util.SyncWrites = cfg.SyncWrites
+	util.FitProcsToQuota()
+	util.SetMaxWorkers(cfg.MaxWorkers)
+	util.RegisterScheme("s3", cloud.OpenURL)
 
-	header, byteOrder := nifti1.ReadHeader(allBytes)
-
-	image := nifti1.ConvertHeaderToImage(header, byteOrder)
-	image.SetData(allBytes, header)
+	// Flags before the command apply to any command.
+	global := flag.NewFlagSet("gonifti", flag.ContinueOnError)
+	global.Usage = usage
+	profile := global.String("profile", "", "profile the command: cpu, mem, or trace")
+	profileOut := global.String("profile-out", "", "profile filename (default: gonifti-<command>.<kind>.pprof)")
+	useCache := global.Bool("cache", cfg.CacheDerived, "reuse the outputs of earlier runs with the same inputs and arguments")
+	force := global.Bool("force", false, "with -cache, run the command even if its outputs are cached")
+	if err := parseFlags(global, os.Args[1:]); err != nil {
+		exitWithError("", err)
+	}
+	args := global.Args()
+	if len(args) < 1 {
+		usage()
+		exitWithError("", usageError("a command must be provided"))
+	}
 
-	log.WithFields(log.Fields{
-		"dataLen": len(image.Data),
-	}).Info("Length of byte data in volume")
+	name := args[0]
+	for _, c := range commands {
+		if c.name == name {
+			stop := func() error { return nil }
+			if *profile != "" {
+				if stop, err = startProfile(*profile, *profileOut, name); err != nil {
+					exitWithError(name, err)
+				}
+			}
+			handleSignals(name, stop)
+			auditCommand, auditArgs = name, args[1:]
+			if cfg.AuditLog != "" {
+				util.ReadHook = noteAuditInput
+			}
+			run := c.run
+			if *useCache {
+				run = func(args []string) error { return runCached(c, args, *force) }
+			}
+			err := run(args[1:])
+			if perr := stop(); perr != nil && err == nil {
+				err = perr
+			}
+			if err != nil {
+				exitWithError(name, err)
+			}
+			return
+		}
+	}
 
+	usage()
+	exitWithError("", usageError(fmt.Sprintf("unknown command %q", name)))
 }
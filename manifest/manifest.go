@@ -0,0 +1,311 @@
+// manifest describes the images of a directory for archival: the size and
+// digest of every file, with the geometry, datatype, and extensions of the
+// images, in a stable JSON schema that can be checked against the files
+// later.
+
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// Schema identifies the version of the manifest format. Fields may be added
+// within a version, but not removed or changed.
+const Schema = "gonifti-manifest/1"
+
+// Manifest lists the images under a directory.
+type Manifest struct {
+	Schema  string    `json:"schema"`
+	Created time.Time `json:"created"`
+	Files   []File    `json:"files"`
+}
+
+// Digest is the size and SHA-256 digest of a file.
+type Digest struct {
+	Path   string `json:"path"` // relative to the root, with slashes
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// File describes one image, or the header of a .hdr/.img pair.
+type File struct {
+	Digest
+	// ImageFile is the data file of a pair.
+	ImageFile *Digest `json:"image_file,omitempty"`
+	// Error is set, and the fields below are empty, if the image could not
+	// be read.
+	Error string `json:"error,omitempty"`
+	// err is the error of a manifest being built.
+	err error
+
+	Format string `json:"format,omitempty"` // "nifti1" or "nifti1-pair"
+	// DataSHA256 is the digest of the voxel data alone, which does not
+	// change with the header, extensions, or compression.
+	DataSHA256  string        `json:"data_sha256,omitempty"`
+	DataType    int           `json:"datatype,omitempty"`
+	Dim         []int         `json:"dim,omitempty"`
+	PixDim      []float64     `json:"pixdim,omitempty"`
+	XYZUnits    int           `json:"xyz_units"`
+	TimeUnits   int           `json:"time_units"`
+	QFormCode   int           `json:"qform_code"`
+	SFormCode   int           `json:"sform_code"`
+	Affine      [4][4]float64 `json:"affine"`
+	Orientation string        `json:"orientation,omitempty"`
+	SclSlope    float64       `json:"scl_slope"`
+	SclInter    float64       `json:"scl_inter"`
+	IntentCode  int           `json:"intent_code"`
+	Descrip     string        `json:"descrip"`
+	Extensions  []Extension   `json:"extensions"`
+}
+
+// Extension describes a header extension.
+type Extension struct {
+	ECode  int32  `json:"ecode"`
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// isImageName reports whether a file name is a NIfTI image or the header of
+// a pair.
+func isImageName(name string) bool {
+	for _, ext := range []string{".nii", ".nii.gz", ".hdr", ".hdr.gz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the error of a file that could not be read, with its
+// original type if the manifest was just built.
+func (f File) Err() error {
+	if f.err != nil || f.Error == "" {
+		return f.err
+	}
+	return errors.New(f.Error)
+}
+
+// Build describes the images under root, reading workers files at a time.
+// Hidden directories are skipped. Files that cannot be read as images, and
+// files and directories that cannot be walked, are listed with their digest,
+// if any, and an error.
+func Build(root string, ropts []nifti1.ReadOption, workers int) (*Manifest, error) {
+	var paths []string
+	walkErrs := make(map[string]error)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			paths = append(paths, path)
+			walkErrs[path] = err
+			return nil
+		}
+		if info.IsDir() && path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && isImageName(info.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	workers = util.Workers(workers)
+	m := &Manifest{Schema: Schema, Created: time.Now().UTC(), Files: make([]File, len(paths))}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := walkErrs[paths[i]]; err != nil {
+					m.Files[i] = failed(File{Digest: Digest{Path: relPath(root, paths[i])}}, err)
+					continue
+				}
+				m.Files[i] = describe(root, paths[i], ropts)
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return m, nil
+}
+
+// relPath returns path relative to root, with slashes.
+func relPath(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// digest returns the digest of a file, with its path relative to root.
+func digest(root, path string) (Digest, error) {
+	d := Digest{Path: relPath(root, path)}
+	f, err := os.Open(path)
+	if err != nil {
+		return d, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if d.Bytes, err = io.Copy(h, f); err != nil {
+		return d, err
+	}
+	d.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return d, nil
+}
+
+// failed returns f with the error err.
+func failed(f File, err error) File {
+	f.Error, f.err = err.Error(), err
+	return f
+}
+
+// describe returns the entry of one image.
+func describe(root, path string, ropts []nifti1.ReadOption) File {
+	var f File
+	var err error
+	if f.Digest, err = digest(root, path); err != nil {
+		return failed(f, err)
+	}
+	img, err := nifti1.ReadFile(path, ropts...)
+	if err != nil {
+		return failed(f, err)
+	}
+
+	f.Format = "nifti1"
+	if img.NiftiType == nifti1.FileTypeNifti1Pair {
+		f.Format = "nifti1-pair"
+		d, err := digest(root, img.IName)
+		if err != nil {
+			return failed(f, err)
+		}
+		f.ImageFile = &d
+	}
+	sum := sha256.Sum256(img.Data)
+	f.DataSHA256 = hex.EncodeToString(sum[:])
+	f.DataType = img.DataType
+	f.Dim = append([]int(nil), img.Dim[1:img.NDim+1]...)
+	f.PixDim = append([]float64(nil), img.PixDim[1:img.NDim+1]...)
+	f.XYZUnits, f.TimeUnits = img.XYZUnits, img.TimeUnits
+	f.QFormCode, f.SFormCode = img.QFormCode, img.SFormCode
+	f.Affine = img.Affine()
+	orient := img.QFormOrientation()
+	if img.SFormCode > 0 {
+		orient = img.SFormOrientation()
+	}
+	f.Orientation = nifti1.OrientationLetters(orient)
+	f.SclSlope, f.SclInter = img.SclSlope, img.SclInter
+	f.IntentCode = img.IntentCode
+	f.Descrip = img.Descrip
+	f.Extensions = []Extension{}
+	for _, e := range img.Extensions {
+		sum := sha256.Sum256(e.Data)
+		f.Extensions = append(f.Extensions, Extension{ECode: e.ECode, Bytes: len(e.Data), SHA256: hex.EncodeToString(sum[:])})
+	}
+	return f
+}
+
+// ReadFile reads a manifest.
+func ReadFile(name string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if m.Schema != Schema {
+		return nil, fmt.Errorf("%s: %w manifest schema %q", name, nifti1.ErrUnsupported, m.Schema)
+	}
+	return &m, nil
+}
+
+// WriteFile writes the manifest as indented JSON.
+func (m *Manifest) WriteFile(name string) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(name, buf.Bytes(), 0644)
+}
+
+// Problem is a difference between a manifest and the files.
+type Problem struct {
+	Path   string
+	Reason string // "missing", "changed", or "unlisted"
+}
+
+// Check compares the files under root with the manifest by size and
+// digest. Images under root that are not in the manifest are reported as
+// unlisted, and directories that cannot be walked with their error.
+func (m *Manifest) Check(root string) ([]Problem, error) {
+	var problems []Problem
+	listed := map[string]bool{}
+	check := func(d Digest) {
+		listed[d.Path] = true
+		got, err := digest(root, filepath.Join(root, filepath.FromSlash(d.Path)))
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, Problem{d.Path, "missing"})
+		case err != nil:
+			problems = append(problems, Problem{d.Path, err.Error()})
+		case got.Bytes != d.Bytes || got.SHA256 != d.SHA256:
+			problems = append(problems, Problem{d.Path, "changed"})
+		}
+	}
+	for _, f := range m.Files {
+		check(f.Digest)
+		if f.ImageFile != nil {
+			check(*f.ImageFile)
+		}
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			problems = append(problems, Problem{relPath(root, path), err.Error()})
+			return nil
+		}
+		if info.IsDir() && path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !isImageName(info.Name()) {
+			return nil
+		}
+		if rel := relPath(root, path); !listed[rel] {
+			problems = append(problems, Problem{rel, "unlisted"})
+		}
+		return nil
+	})
+	return problems, err
+}
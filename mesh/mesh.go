@@ -0,0 +1,275 @@
+// mesh contains methods to extract triangle meshes from nifti1 images.
+
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Mesh is an indexed triangle mesh. Triangles are wound counter-clockwise
+// when seen from outside the surface.
+type Mesh struct {
+	Vertices  [][3]float64
+	Triangles [][3]int
+}
+
+// cubeCorners are the (di, dj, dk) offsets of the corners of a cube.
+var cubeCorners = [8][3]int{
+	{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0},
+	{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1},
+}
+
+// cubeTetrahedra split a cube into six tetrahedra around the diagonal from
+// corner 0 to corner 6. Every cube is split the same way, so the faces of
+// neighbouring cubes match and the surface has no cracks.
+var cubeTetrahedra = [6][4]int{
+	{0, 1, 2, 6}, {0, 2, 3, 6}, {0, 3, 7, 6},
+	{0, 7, 4, 6}, {0, 4, 5, 6}, {0, 5, 1, 6},
+}
+
+// extractor holds the state of one isosurface extraction.
+type extractor struct {
+	values     []float64
+	nx, ny, nz int
+	level      float64
+	verts      map[[2]int]int
+	mesh       *Mesh
+}
+
+// Extract returns the isosurface of volume t at the given level, with vertices
+// in voxel coordinates. Voxels with scaled values >= level are inside. Use a
+// level of 0.5 for binary masks.
+//
+// This is a marching cubes variant: each cube of eight neighbouring voxels is
+// split into six tetrahedra, which avoids the ambiguous cases of the
+// classic lookup table and always yields a closed surface. Voxels outside
+// the grid are treated as outside the surface.
+func Extract(img *nifti1.Image, t int, level float64) (*Mesh, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	if t < 0 || (t+1)*nxyz > img.NVox {
+		return nil, fmt.Errorf("volume index %d out of range", t)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &extractor{
+		values: values[t*nxyz : (t+1)*nxyz],
+		nx:     img.Nx,
+		ny:     img.Ny,
+		nz:     img.Nz,
+		level:  level,
+		verts:  map[[2]int]int{},
+		mesh:   &Mesh{},
+	}
+
+	// Visit cubes that straddle the border of the grid too, so that surfaces
+	// touching the border are closed.
+	for k := -1; k < e.nz; k++ {
+		for j := -1; j < e.ny; j++ {
+			for i := -1; i < e.nx; i++ {
+				e.cube(i, j, k)
+			}
+		}
+	}
+
+	return e.mesh, nil
+}
+
+// key returns the index of voxel (i, j, k) in a grid padded by one voxel on
+// every side.
+func (e *extractor) key(i, j, k int) int {
+	return (i + 1) + (e.nx+2)*((j+1)+(e.ny+2)*(k+1))
+}
+
+// value returns the value of voxel (i, j, k), or a value below the level for
+// voxels outside the grid.
+func (e *extractor) value(i, j, k int) float64 {
+	if i < 0 || j < 0 || k < 0 || i >= e.nx || j >= e.ny || k >= e.nz {
+		return e.level - 1
+	}
+	v := e.values[i+e.nx*(j+e.ny*k)]
+	if math.IsNaN(v) {
+		return e.level - 1
+	}
+	return v
+}
+
+type corner struct {
+	key int
+	pos [3]float64
+	val float64
+}
+
+func (e *extractor) cube(i, j, k int) {
+	var c [8]corner
+	inside := 0
+	for n, o := range cubeCorners {
+		ci, cj, ck := i+o[0], j+o[1], k+o[2]
+		c[n] = corner{
+			key: e.key(ci, cj, ck),
+			pos: [3]float64{float64(ci), float64(cj), float64(ck)},
+			val: e.value(ci, cj, ck),
+		}
+		if c[n].val >= e.level {
+			inside++
+		}
+	}
+	if inside == 0 || inside == 8 {
+		return
+	}
+
+	for _, tet := range cubeTetrahedra {
+		var in, out []corner
+		for _, n := range tet {
+			if c[n].val >= e.level {
+				in = append(in, c[n])
+			} else {
+				out = append(out, c[n])
+			}
+		}
+
+		switch len(in) {
+		case 1, 3:
+			// One corner is separated from the other three.
+			var lone corner
+			var rest []corner
+			if len(in) == 1 {
+				lone, rest = in[0], out
+			} else {
+				lone, rest = out[0], in
+			}
+			e.triangle(in, out,
+				e.vertex(lone, rest[0]), e.vertex(lone, rest[1]), e.vertex(lone, rest[2]))
+		case 2:
+			// The surface crosses the tetrahedron as a quadrilateral.
+			a, b := in[0], in[1]
+			ac, ad := e.vertex(a, out[0]), e.vertex(a, out[1])
+			bc, bd := e.vertex(b, out[0]), e.vertex(b, out[1])
+			e.triangle(in, out, ac, ad, bd)
+			e.triangle(in, out, ac, bd, bc)
+		}
+	}
+}
+
+// vertex returns the index of the vertex on the edge between corners p and q,
+// creating it if needed. Vertices are shared by all triangles using the edge.
+func (e *extractor) vertex(p, q corner) int {
+	key := [2]int{p.key, q.key}
+	if key[0] > key[1] {
+		key[0], key[1] = key[1], key[0]
+	}
+	if n, ok := e.verts[key]; ok {
+		return n
+	}
+
+	f := 0.5
+	if d := q.val - p.val; d != 0 {
+		f = (e.level - p.val) / d
+	}
+	var pos [3]float64
+	for n := range pos {
+		pos[n] = p.pos[n] + f*(q.pos[n]-p.pos[n])
+	}
+
+	n := len(e.mesh.Vertices)
+	e.mesh.Vertices = append(e.mesh.Vertices, pos)
+	e.verts[key] = n
+	return n
+}
+
+// triangle appends a triangle, oriented so that its normal points from the
+// inside corners towards the outside corners.
+func (e *extractor) triangle(in, out []corner, a, b, c int) {
+	if a == b || b == c || a == c {
+		return
+	}
+	va, vb, vc := e.mesh.Vertices[a], e.mesh.Vertices[b], e.mesh.Vertices[c]
+	normal := cross(sub(vb, va), sub(vc, va))
+	dir := sub(centroid(out), centroid(in))
+	if dot(normal, dir) < 0 {
+		b, c = c, b
+	}
+	e.mesh.Triangles = append(e.mesh.Triangles, [3]int{a, b, c})
+}
+
+// toWorld transforms the vertices of m from voxel indices to world
+// coordinates.
+func (m *Mesh) toWorld(img *nifti1.Image) {
+	affine := img.Affine()
+	for n, v := range m.Vertices {
+		m.Vertices[n] = img.VoxelToWorld(v[0], v[1], v[2])
+	}
+
+	// A left-handed affine mirrors the surface, so flip the winding to keep
+	// normals pointing outwards.
+	if det3(affine) < 0 {
+		for n, tri := range m.Triangles {
+			m.Triangles[n] = [3]int{tri[0], tri[2], tri[1]}
+		}
+	}
+}
+
+// FromImage extracts the isosurface of volume t at level in world
+// coordinates. See Extract for details.
+func FromImage(img *nifti1.Image, t int, level float64) (*Mesh, error) {
+	m, err := Extract(img, t, level)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Triangles) == 0 {
+		return nil, errors.New("no voxels at or above the level")
+	}
+	m.toWorld(img)
+	return m, nil
+}
+
+// Normal returns the unit normal of triangle n.
+func (m *Mesh) Normal(n int) [3]float64 {
+	t := m.Triangles[n]
+	a, b, c := m.Vertices[t[0]], m.Vertices[t[1]], m.Vertices[t[2]]
+	v := cross(sub(b, a), sub(c, a))
+	l := math.Sqrt(dot(v, v))
+	if l == 0 {
+		return v
+	}
+	return [3]float64{v[0] / l, v[1] / l, v[2] / l}
+}
+
+func centroid(cs []corner) [3]float64 {
+	var r [3]float64
+	for _, c := range cs {
+		for n := range r {
+			r[n] += c.pos[n] / float64(len(cs))
+		}
+	}
+	return r
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+// det3 returns the determinant of the upper-left 3x3 block of a.
+func det3(a [4][4]float64) float64 {
+	return a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+}
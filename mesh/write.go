@@ -0,0 +1,111 @@
+package mesh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// WriteSTL writes the mesh as binary STL.
+func (m *Mesh) WriteSTL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	var header [80]byte
+	copy(header[:], "gonifti mesh")
+	bw.Write(header[:])
+	binary.Write(bw, binary.LittleEndian, uint32(len(m.Triangles)))
+
+	var rec [50]byte
+	for n, t := range m.Triangles {
+		vals := make([]float64, 0, 12)
+		normal := m.Normal(n)
+		vals = append(vals, normal[:]...)
+		for _, v := range t {
+			vals = append(vals, m.Vertices[v][:]...)
+		}
+		for i, v := range vals {
+			binary.LittleEndian.PutUint32(rec[4*i:], math.Float32bits(float32(v)))
+		}
+		// The last two bytes are the unused attribute byte count.
+		if _, err := bw.Write(rec[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteOBJ writes the mesh as Wavefront OBJ.
+func (m *Mesh) WriteOBJ(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# gonifti mesh")
+	for _, v := range m.Vertices {
+		fmt.Fprintf(bw, "v %g %g %g\n", v[0], v[1], v[2])
+	}
+	for _, t := range m.Triangles {
+		// OBJ indices start at 1.
+		fmt.Fprintf(bw, "f %d %d %d\n", t[0]+1, t[1]+1, t[2]+1)
+	}
+	return bw.Flush()
+}
+
+// WriteGIfTI writes the mesh as a GIfTI surface with ASCII-encoded pointset
+// and triangle arrays.
+// https://www.nitrc.org/projects/gifti/
+func (m *Mesh) WriteGIfTI(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(bw, `<!DOCTYPE GIFTI SYSTEM "http://www.nitrc.org/frs/download.php/115/gifti.dtd">`)
+	fmt.Fprintln(bw, `<GIFTI Version="1.0" NumberOfDataArrays="2">`)
+
+	fmt.Fprintf(bw, `<DataArray Intent="NIFTI_INTENT_POINTSET" DataType="NIFTI_TYPE_FLOAT32" `+
+		`ArrayIndexingOrder="RowMajorOrder" Dimensionality="2" Dim0="%d" Dim1="3" `+
+		`Encoding="ASCII" Endian="LittleEndian" ExternalFileName="" ExternalFileOffset="">`+"\n", len(m.Vertices))
+	fmt.Fprintln(bw, `<CoordinateSystemTransformMatrix>`)
+	fmt.Fprintln(bw, `<DataSpace><![CDATA[NIFTI_XFORM_UNKNOWN]]></DataSpace>`)
+	fmt.Fprintln(bw, `<TransformedSpace><![CDATA[NIFTI_XFORM_UNKNOWN]]></TransformedSpace>`)
+	fmt.Fprintln(bw, `<MatrixData>1 0 0 0 0 1 0 0 0 0 1 0 0 0 0 1</MatrixData>`)
+	fmt.Fprintln(bw, `</CoordinateSystemTransformMatrix>`)
+	fmt.Fprint(bw, "<Data>")
+	for _, v := range m.Vertices {
+		fmt.Fprintf(bw, "%g %g %g\n", float32(v[0]), float32(v[1]), float32(v[2]))
+	}
+	fmt.Fprintln(bw, "</Data>")
+	fmt.Fprintln(bw, "</DataArray>")
+
+	fmt.Fprintf(bw, `<DataArray Intent="NIFTI_INTENT_TRIANGLE" DataType="NIFTI_TYPE_INT32" `+
+		`ArrayIndexingOrder="RowMajorOrder" Dimensionality="2" Dim0="%d" Dim1="3" `+
+		`Encoding="ASCII" Endian="LittleEndian" ExternalFileName="" ExternalFileOffset="">`+"\n", len(m.Triangles))
+	fmt.Fprint(bw, "<Data>")
+	for _, t := range m.Triangles {
+		fmt.Fprintf(bw, "%d %d %d\n", t[0], t[1], t[2])
+	}
+	fmt.Fprintln(bw, "</Data>")
+	fmt.Fprintln(bw, "</DataArray>")
+
+	fmt.Fprintln(bw, "</GIFTI>")
+	return bw.Flush()
+}
+
+// WriteFile writes the mesh in the format implied by the extension of
+// filename: .stl, .obj, or .gii.
+func (m *Mesh) WriteFile(filename string) error {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".stl":
+		write = m.WriteSTL
+	case ".obj":
+		write = m.WriteOBJ
+	case ".gii":
+		write = m.WriteGIfTI
+	default:
+		return fmt.Errorf("unsupported mesh format %q", filepath.Ext(filename))
+	}
+
+	return util.WriteAtomic(filename, write)
+}
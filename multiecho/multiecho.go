@@ -0,0 +1,237 @@
+// multiecho contains helpers for multi-echo datasets, stored either as one 5D
+// image (x,y,z,t,echo) or as one image per echo.
+
+package multiecho
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+	log "github.com/sirupsen/logrus"
+)
+
+// NumEchoes returns the number of echoes in a 5D image (dim[5]).
+func NumEchoes(img *nifti1.Image) int {
+	if img.NDim < 5 {
+		return 1
+	}
+	return img.Nu
+}
+
+// checkEchoes reports an error unless all echoes share the grid and the
+// number of volumes of the first.
+func checkEchoes(echoes []*nifti1.Image) error {
+	if len(echoes) == 0 {
+		return errors.New("no echoes given")
+	}
+	first := echoes[0]
+	for n, e := range echoes[1:] {
+		if !nifti1.SameGrid(first, e) {
+			return fmt.Errorf("echo %d is not on the grid of echo 0", n+1)
+		}
+		if e.Nt != first.Nt {
+			return fmt.Errorf("echo %d has %d volumes, echo 0 has %d", n+1, e.Nt, first.Nt)
+		}
+	}
+	return nil
+}
+
+// Stack combines per-echo images into one 5D image with echoes along dim[5].
+// The raw data are concatenated when all echoes share the datatype and
+// scaling; otherwise the scaled values are stored as DT_FLOAT32.
+func Stack(echoes []*nifti1.Image) (*nifti1.Image, error) {
+	if err := checkEchoes(echoes); err != nil {
+		return nil, err
+	}
+	first := echoes[0]
+
+	raw := true
+	for _, e := range echoes[1:] {
+		if e.DataType != first.DataType || e.SclSlope != first.SclSlope || e.SclInter != first.SclInter {
+			raw = false
+		}
+	}
+
+	out := *first
+	if err := out.SetDims(first.Nx, first.Ny, first.Nz, first.Nt, len(echoes)); err != nil {
+		return nil, err
+	}
+	out.PixDim[5], out.Du = 1, 1
+
+	if raw {
+		n := first.Nx * first.Ny * first.Nz * first.Nt * first.NByPer
+		data := make([]byte, 0, n*len(echoes))
+		for _, e := range echoes {
+			if len(e.Data) < n {
+				return nil, fmt.Errorf("echo holds %d bytes of data, expected %d", len(e.Data), n)
+			}
+			data = append(data, e.Data[:n]...)
+		}
+		out.Data = data
+		out.TrailingData = nil
+		out.DropChecksums()
+	} else {
+		var values []float64
+		for _, e := range echoes {
+			v, err := e.ScaledFloat64s()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v...)
+		}
+		if err := out.SetFloat32Data(values); err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"echoes": len(echoes),
+		"raw":    raw,
+	}).Debug("Stacked echoes")
+
+	return &out, nil
+}
+
+// Echo returns echo e of a 5D image as a 4D image, keeping the datatype. An
+// image with fewer than five dimensions holds a single echo.
+func Echo(img *nifti1.Image, e int) (*nifti1.Image, error) {
+	if e < 0 || e >= NumEchoes(img) {
+		return nil, fmt.Errorf("echo index %d out of range [0, %d)", e, NumEchoes(img))
+	}
+	n := img.Nx * img.Ny * img.Nz * img.Nt * img.NByPer
+	if len(img.Data) < (e+1)*n {
+		return nil, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), (e+1)*n)
+	}
+
+	out := *img
+	if err := out.SetDims(img.Nx, img.Ny, img.Nz, img.Nt); err != nil {
+		return nil, err
+	}
+	out.PixDim[5], out.Du = 0, 0
+	out.Data = append([]byte(nil), img.Data[e*n:(e+1)*n]...)
+	out.TrailingData = nil
+	out.DropChecksums()
+	return &out, nil
+}
+
+// Split returns each echo of a 5D image as a 4D image.
+func Split(img *nifti1.Image) ([]*nifti1.Image, error) {
+	echoes := make([]*nifti1.Image, NumEchoes(img))
+	for e := range echoes {
+		var err error
+		if echoes[e], err = Echo(img, e); err != nil {
+			return nil, err
+		}
+	}
+	return echoes, nil
+}
+
+// OptimalCombination combines echoes with T2*-weighted averaging (Posse et
+// al., 1999). For each voxel, T2* is estimated by a log-linear fit of the
+// time-averaged signal against the echo times, and echo e is weighted by
+// TE_e * exp(-TE_e / T2*). Voxels where the signal does not decay are
+// weighted by TE alone. The combined image is DT_FLOAT32; the T2* map is in
+// the units of tes and holds +Inf where no decay was fit.
+func OptimalCombination(echoes []*nifti1.Image, tes []float64) (combined *nifti1.Image, t2star []float64, err error) {
+	if err := checkEchoes(echoes); err != nil {
+		return nil, nil, err
+	}
+	if len(tes) != len(echoes) {
+		return nil, nil, fmt.Errorf("got %d echo times for %d echoes", len(tes), len(echoes))
+	}
+	if len(echoes) < 2 {
+		return nil, nil, errors.New("at least two echoes are needed")
+	}
+	for _, te := range tes {
+		if te <= 0 {
+			return nil, nil, fmt.Errorf("echo times must be positive, got %g", te)
+		}
+	}
+
+	first := echoes[0]
+	nxyz := first.Nx * first.Ny * first.Nz
+	nt := first.Nt
+	values := make([][]float64, len(echoes))
+	for e, img := range echoes {
+		if values[e], err = img.ScaledFloat64s(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Least-squares slope of log(signal) against TE.
+	meanTE := 0.0
+	for _, te := range tes {
+		meanTE += te
+	}
+	meanTE /= float64(len(tes))
+	sxx := 0.0
+	for _, te := range tes {
+		sxx += (te - meanTE) * (te - meanTE)
+	}
+
+	out := make([]float64, nxyz*nt)
+	t2star = make([]float64, nxyz)
+	logs := make([]float64, len(echoes))
+	weights := make([]float64, len(echoes))
+	for i := 0; i < nxyz; i++ {
+		t2star[i] = math.Inf(1)
+		ok := true
+		for e := range echoes {
+			var sum numeric.Accumulator
+			for t := 0; t < nt; t++ {
+				sum.Add(values[e][i+t*nxyz])
+			}
+			mean := sum.Sum() / float64(nt)
+			if mean <= 0 {
+				ok = false
+				break
+			}
+			logs[e] = math.Log(mean)
+		}
+		if ok && sxx > 0 {
+			meanLog := 0.0
+			for _, l := range logs {
+				meanLog += l
+			}
+			meanLog /= float64(len(logs))
+			sxy := 0.0
+			for e, te := range tes {
+				sxy += (te - meanTE) * (logs[e] - meanLog)
+			}
+			if slope := sxy / sxx; slope < 0 {
+				t2star[i] = -1 / slope
+			}
+		}
+
+		sum := 0.0
+		for e, te := range tes {
+			weights[e] = te * math.Exp(-te/t2star[i])
+			sum += weights[e]
+		}
+		for t := 0; t < nt; t++ {
+			v := 0.0
+			for e := range echoes {
+				v += weights[e] * values[e][i+t*nxyz]
+			}
+			out[i+t*nxyz] = v / sum
+		}
+	}
+
+	c := *first
+	if err := c.SetDims(first.Nx, first.Ny, first.Nz, nt); err != nil {
+		return nil, nil, err
+	}
+	if err := c.SetFloat32Data(out); err != nil {
+		return nil, nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"echoes": len(echoes),
+		"tes":    tes,
+	}).Debug("Combined echoes")
+
+	return &c, t2star, nil
+}
@@ -0,0 +1,118 @@
+package nifti1
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// analyzeExtents is the value of the extents field written by Analyze 7.5.
+const analyzeExtents = 16384
+
+// AnalyzeCompatible writes an Analyze 7.5 header instead of a NIfTI-1 one,
+// for tools that predate NIfTI. The output must be a .hdr/.img pair. Fields
+// that NIfTI-1 added to the unused parts of the Analyze header are cleared:
+// the magic, the qform and sform, the intent, the slice timing, and the
+// units. Extensions are not written. scl_slope and scl_inter are kept, as
+// SPM reads them from the same bytes.
+//
+// Analyze has no orientation: readers assume their own convention (often
+// radiological), so the output may be displayed flipped. A warning is
+// logged for each piece of information that is lost. gonifti cannot read
+// the result back, because the header has no NIfTI magic.
+func AnalyzeCompatible() WriteOption {
+	return func(c *writeConfig) {
+		c.analyze = true
+	}
+}
+
+// analyzeDatatypes are the datatypes defined by Analyze 7.5.
+var analyzeDatatypes = map[int]bool{
+	DTUint8:     true,
+	DTInt16:     true,
+	DTInt32:     true,
+	DTFloat32:   true,
+	DTFloat64:   true,
+	DTComplex64: true,
+	DTRGB24:     true,
+}
+
+// toAnalyzeHeader converts an image to an Analyze 7.5 header and reports
+// what could not be kept.
+func (img *Image) toAnalyzeHeader() (Header, []string, error) {
+	if !analyzeDatatypes[img.DataType] {
+		return Header{}, nil, fmt.Errorf("%w datatype %d in Analyze 7.5", ErrUnsupported, img.DataType)
+	}
+
+	var lost []string
+	if img.QFormCode > 0 {
+		lost = append(lost, fmt.Sprintf("qform (code %d, %s)", img.QFormCode, OrientationLetters(img.QFormOrientation())))
+	}
+	if img.SFormCode > 0 {
+		lost = append(lost, fmt.Sprintf("sform (code %d, %s)", img.SFormCode, OrientationLetters(img.SFormOrientation())))
+	}
+	if img.IntentCode != 0 || img.IntentName != "" {
+		lost = append(lost, fmt.Sprintf("intent (code %d)", img.IntentCode))
+	}
+	if img.SliceCode != 0 || img.SliceDuration != 0 {
+		lost = append(lost, "slice timing")
+	}
+	if img.XYZUnits != 0 || img.TimeUnits != 0 {
+		lost = append(lost, "units")
+	}
+	if len(img.Extensions) > 0 {
+		lost = append(lost, fmt.Sprintf("%d extensions", len(img.Extensions)))
+	}
+
+	h := img.ToHeader()
+	h.Magic = [4]int8{}
+	h.UnusedExtents = analyzeExtents
+	h.UnusedRegular = 'r'
+
+	h.PixDim[0] = 0
+	h.QFormCode, h.SFormCode = 0, 0
+	h.QuaternB, h.QuaternC, h.QuaternD = 0, 0, 0
+	h.QOffsetX, h.QOffsetY, h.QOffsetZ = 0, 0, 0
+	h.SRowX, h.SRowY, h.SRowZ = [4]float32{}, [4]float32{}, [4]float32{}
+
+	h.IntentCode = 0
+	h.IntentP1, h.IntentP2, h.IntentP3 = 0, 0, 0
+	h.IntentName = [16]int8{}
+
+	h.DimInfo = 0
+	h.SliceCode, h.SliceStart, h.SliceEnd = 0, 0, 0
+	h.SliceDuration, h.TOffset = 0, 0
+	h.XYZTUnits = 0
+	h.VoxOffset = 0
+
+	// Analyze viewers scale the display with glmax and glmin.
+	if img.DataType != DTRGB24 && img.DataType != DTComplex64 {
+		if values, err := img.Float64s(); err == nil && len(values) > 0 {
+			lo, hi := values[0], values[0]
+			for _, v := range values {
+				if v < lo {
+					lo = v
+				}
+				if v > hi {
+					hi = v
+				}
+			}
+			h.UnusedGlmin, h.UnusedGlmax = int32(lo), int32(hi)
+		}
+	}
+
+	return h, lost, nil
+}
+
+// warnAnalyzeLoss logs what was dropped when writing an Analyze header.
+func warnAnalyzeLoss(filename string, lost []string) {
+	for _, l := range lost {
+		log.WithFields(log.Fields{
+			"file": filename,
+			"lost": l,
+		}).Warn("Analyze 7.5 output cannot store this; it was dropped")
+	}
+	log.WithFields(log.Fields{
+		"file": filename,
+	}).Warn("Analyze 7.5 has no orientation; readers may display the image flipped")
+}
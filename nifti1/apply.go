@@ -0,0 +1,248 @@
+package nifti1
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// Volume is one 3D volume of an image, as passed to the functions of
+// ApplyVolumes and ApplyVolumeReader.
+type Volume struct {
+	Nx, Ny, Nz int
+	// Values are the voxel values with scl_slope and scl_inter applied, x
+	// fastest: voxel (i, j, k) is Values[Index(i, j, k)].
+	Values []float64
+}
+
+// Index returns the index in Values of voxel (i, j, k).
+func (v *Volume) Index(i, j, k int) int {
+	return i + v.Nx*(j+v.Ny*k)
+}
+
+// At returns the value of voxel (i, j, k).
+func (v *Volume) At(i, j, k int) float64 {
+	return v.Values[v.Index(i, j, k)]
+}
+
+// Set sets the value of voxel (i, j, k).
+func (v *Volume) Set(i, j, k int, x float64) {
+	v.Values[v.Index(i, j, k)] = x
+}
+
+// ApplyOption configures ApplyVolumes, ApplyVoxels, and ApplyVolumeReader.
+type ApplyOption func(*applyConfig)
+
+type applyConfig struct {
+	workers int
+}
+
+// ApplyWorkers processes n volumes at a time, each in its own goroutine, so
+// the function applied must be safe for concurrent use. The default is 1.
+func ApplyWorkers(n int) ApplyOption {
+	return func(c *applyConfig) {
+		c.workers = n
+	}
+}
+
+func newApplyConfig(opts []ApplyOption) applyConfig {
+	c := applyConfig{workers: 1}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.workers = util.Workers(c.workers)
+	return c
+}
+
+// ApplyVolumes calls fn with each volume t of img, and returns a copy of img
+// holding the values fn leaves in the volumes, stored as DT_FLOAT32 as by
+// WithFloat32Data. Volumes are decoded one at a time, when fn is called for
+// them, so that only the result and a volume per worker are held as
+// float64. fn may change vol.Values in place, but must not keep them after
+// it returns: their buffer is reused. The first error fn returns stops the
+// volumes not yet started and is returned.
+func ApplyVolumes(img *Image, fn func(t int, vol *Volume) error, opts ...ApplyOption) (*Image, error) {
+	c := newApplyConfig(opts)
+	vb := img.VolumeBytes()
+	n := 0
+	if vb > 0 {
+		n = img.NVox * img.NByPer / vb
+	}
+	if len(img.Data) < img.NVox*img.NByPer {
+		return nil, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), img.NVox*img.NByPer)
+	}
+	if !IsNumeric(img.DataType) {
+		return nil, fmt.Errorf("%w datatype %d", ErrUnsupported, img.DataType)
+	}
+	nxyz := img.Nx * img.Ny * img.Nz
+	out := make([]float32, img.NVox)
+
+	err := applyVolumes(n, c.workers, func() func(t int) error {
+		vol := &Volume{Nx: img.Nx, Ny: img.Ny, Nz: img.Nz, Values: make([]float64, nxyz)}
+		return func(t int) error {
+			if err := img.volumeValues(t, vol.Values); err != nil {
+				return err
+			}
+			if err := fn(t, vol); err != nil {
+				return err
+			}
+			if len(vol.Values) != nxyz {
+				return fmt.Errorf("the function left %d values, expected %d", len(vol.Values), nxyz)
+			}
+			dst := out[t*nxyz : (t+1)*nxyz]
+			for i, x := range vol.Values {
+				dst[i] = float32(x)
+			}
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img.WithFloat32Data(out)
+}
+
+// ApplyVoxels returns a copy of img with each voxel (i, j, k) of volume t
+// replaced by fn of its scaled value, stored as DT_FLOAT32. It runs like
+// ApplyVolumes, so fn must be safe for concurrent use with ApplyWorkers.
+func ApplyVoxels(img *Image, fn func(i, j, k, t int, x float64) float64, opts ...ApplyOption) (*Image, error) {
+	return ApplyVolumes(img, func(t int, vol *Volume) error {
+		m := 0
+		for k := 0; k < vol.Nz; k++ {
+			for j := 0; j < vol.Ny; j++ {
+				for i := 0; i < vol.Nx; i++ {
+					vol.Values[m] = fn(i, j, k, t, vol.Values[m])
+					m++
+				}
+			}
+		}
+		return nil
+	}, opts...)
+}
+
+// ApplyVolumeReader calls fn with each volume of r that Next has not
+// returned yet, reading them from its file one at a time, for operations
+// that only read the volumes, such as statistics, of series too long to
+// hold in memory. With ApplyWorkers, fn runs on several volumes while the
+// next are read. Each volume has its own buffer, which fn may keep.
+func ApplyVolumeReader(r *VolumeReader, fn func(t int, vol *Volume) error, opts ...ApplyOption) error {
+	c := newApplyConfig(opts)
+	img := r.Image
+	type job struct {
+		t      int
+		values []float64
+	}
+	jobs := make(chan job)
+	errs := make(chan error, c.workers+1)
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func(err error) {
+		errs <- err
+		once.Do(func() { close(done) })
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < c.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				vol := &Volume{Nx: img.Nx, Ny: img.Ny, Nz: img.Nz, Values: j.values}
+				if err := fn(j.t, vol); err != nil {
+					stop(fmt.Errorf("volume %d: %w", j.t, err))
+					return
+				}
+			}
+		}()
+	}
+
+	t := r.returned
+loop:
+	for ; ; t++ {
+		values, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stop(err)
+			break
+		}
+		select {
+		case jobs <- job{t, values}:
+		case <-done:
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// applyVolumes runs the functions of newWorker, one per worker, on volumes
+// 0 to n-1, and returns the first error, after which no volume is started.
+func applyVolumes(n, workers int, newWorker func() func(t int) error) error {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var first error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return first != nil
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn := newWorker()
+			for t := range jobs {
+				if failed() {
+					continue
+				}
+				if err := fn(t); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = fmt.Errorf("volume %d: %w", t, err)
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for t := 0; t < n && !failed(); t++ {
+		jobs <- t
+	}
+	close(jobs)
+	wg.Wait()
+	return first
+}
+
+// volumeValues decodes the scaled values of volume t into values.
+func (img *Image) volumeValues(t int, values []float64) error {
+	vol := *img
+	if err := vol.SetDims(img.Nx, img.Ny, img.Nz); err != nil {
+		return err
+	}
+	vb := vol.VolumeBytes()
+	vol.Data = img.Data[t*vb : (t+1)*vb]
+	at, err := vol.Float64Func()
+	if err != nil {
+		return err
+	}
+	slope, inter, scaled := img.Scaling()
+	for i := range values {
+		v := at(i)
+		if scaled {
+			v = slope*v + inter
+		}
+		values[i] = v
+	}
+	return nil
+}
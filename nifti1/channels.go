@@ -0,0 +1,48 @@
+package nifti1
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// channelsPrefix marks comment extensions holding the labels of channels.
+const channelsPrefix = "gonifti-channels "
+
+// SetChannelLabels records in the image's extensions that its volumes are
+// the channels of the given labels in order, such as a one-hot encoding of
+// a label map, replacing any labels recorded before.
+func (img *Image) SetChannelLabels(labels []int) error {
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	exts := make([]Extension, 0, len(img.Extensions)+1)
+	for _, e := range img.Extensions {
+		if !isChannelsExtension(e) {
+			exts = append(exts, e)
+		}
+	}
+	img.Extensions = append(exts, Extension{ECode: ECodeComment, Data: append([]byte(channelsPrefix), b...)})
+	img.NumExt = len(img.Extensions)
+	return nil
+}
+
+// ChannelLabels returns the labels of the image's channels recorded by
+// SetChannelLabels, if any.
+func (img *Image) ChannelLabels() ([]int, bool) {
+	for _, e := range img.Extensions {
+		if !isChannelsExtension(e) {
+			continue
+		}
+		var labels []int
+		b := bytes.TrimRight(e.Data[len(channelsPrefix):], "\x00")
+		if err := json.Unmarshal(b, &labels); err == nil {
+			return labels, true
+		}
+	}
+	return nil, false
+}
+
+func isChannelsExtension(e Extension) bool {
+	return e.ECode == ECodeComment && bytes.HasPrefix(e.Data, []byte(channelsPrefix))
+}
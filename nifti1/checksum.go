@@ -0,0 +1,151 @@
+package nifti1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// checksumPrefix marks comment extensions holding data checksums.
+const checksumPrefix = "gonifti-checksums "
+
+// Checksums are SHA-256 digests of consecutive chunks of a byte stream, so
+// that a corrupted copy can be narrowed down to the chunks that differ
+// instead of failing as a whole.
+type Checksums struct {
+	Algorithm string `json:"algorithm"`
+	// ChunkSize is the size of every chunk but the last, in bytes.
+	ChunkSize int `json:"chunk_size"`
+	// Size is the total size of the stream in bytes.
+	Size int64    `json:"size"`
+	Sums []string `json:"sums"`
+}
+
+// ComputeChecksums returns the checksums of r in chunks of chunkSize
+// bytes.
+func ComputeChecksums(r io.Reader, chunkSize int) (Checksums, error) {
+	c := Checksums{Algorithm: "sha256", ChunkSize: chunkSize, Sums: []string{}}
+	if chunkSize < 1 {
+		return c, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	err := eachChunk(r, chunkSize, func(sum string, n int) {
+		c.Sums = append(c.Sums, sum)
+		c.Size += int64(n)
+	})
+	return c, err
+}
+
+// eachChunk calls fn with the digest and size of each chunk of r.
+func eachChunk(r io.Reader, chunkSize int, fn func(sum string, n int)) error {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			fn(hex.EncodeToString(sum[:]), n)
+		}
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// Verify compares r with the checksums and returns the indices of the
+// chunks that differ, including chunks that are missing from r or that r
+// has in excess.
+func (c Checksums) Verify(r io.Reader) ([]int, error) {
+	if c.Algorithm != "sha256" {
+		return nil, fmt.Errorf("%w checksum algorithm %q", ErrUnsupported, c.Algorithm)
+	}
+	if c.ChunkSize < 1 {
+		return nil, fmt.Errorf("invalid chunk size %d", c.ChunkSize)
+	}
+	var bad []int
+	i := 0
+	err := eachChunk(r, c.ChunkSize, func(sum string, n int) {
+		if i >= len(c.Sums) || c.Sums[i] != sum || int64(i)*int64(c.ChunkSize)+int64(n) > c.Size {
+			bad = append(bad, i)
+		}
+		i++
+	})
+	if err != nil {
+		return nil, err
+	}
+	for ; i < len(c.Sums); i++ {
+		bad = append(bad, i)
+	}
+	return bad, nil
+}
+
+// Chunk returns the byte range [start, end) of chunk i.
+func (c Checksums) Chunk(i int) (start, end int64) {
+	start = int64(i) * int64(c.ChunkSize)
+	end = start + int64(c.ChunkSize)
+	if end > c.Size {
+		end = c.Size
+	}
+	return start, end
+}
+
+// VolumeBytes returns the number of bytes of one volume (nx*ny*nz voxels)
+// of the data.
+func (img *Image) VolumeBytes() int {
+	return img.Nx * img.Ny * img.Nz * img.NByPer
+}
+
+// SetChecksums replaces the checksums stored in the image's extensions
+// with c, the checksums of its voxel data. Unlike a digest of the file,
+// they are not affected by the header, the extensions, or compression.
+func (img *Image) SetChecksums(c Checksums) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	img.DropChecksums()
+	img.Extensions = append(img.Extensions, Extension{ECode: ECodeComment, Data: append([]byte(checksumPrefix), b...)})
+	img.NumExt = len(img.Extensions)
+	return nil
+}
+
+// DropChecksums removes the checksums of the voxel data from the
+// extensions, for code that changes Data directly; the methods that replace
+// the data call it. The slice is copied, so images sharing extensions, such
+// as the one an image was copied from, are not modified.
+func (img *Image) DropChecksums() {
+	exts := make([]Extension, 0, len(img.Extensions)+1)
+	for _, e := range img.Extensions {
+		if !isChecksumExtension(e) {
+			exts = append(exts, e)
+		}
+	}
+	img.Extensions = exts
+	img.NumExt = len(exts)
+}
+
+// Checksums returns the checksums of the voxel data stored in the image's
+// extensions, if any.
+func (img *Image) Checksums() (Checksums, bool) {
+	var c Checksums
+	for _, e := range img.Extensions {
+		if !isChecksumExtension(e) {
+			continue
+		}
+		// Extension data is padded with zeros to a multiple of 16 bytes.
+		b := bytes.TrimRight(e.Data[len(checksumPrefix):], "\x00")
+		if err := json.Unmarshal(b, &c); err == nil {
+			return c, true
+		}
+	}
+	return c, false
+}
+
+func isChecksumExtension(e Extension) bool {
+	return e.ECode == ECodeComment && bytes.HasPrefix(e.Data, []byte(checksumPrefix))
+}
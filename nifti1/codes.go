@@ -0,0 +1,122 @@
+package nifti1
+
+// Datatype codes, stored in Image.DataType, as DT_* in nifti1.h.
+const (
+	DTUint8      = 2
+	DTInt16      = 4
+	DTInt32      = 8
+	DTFloat32    = 16
+	DTComplex64  = 32
+	DTFloat64    = 64
+	DTRGB24      = 128
+	DTInt8       = 256
+	DTUint16     = 512
+	DTUint32     = 768
+	DTInt64      = 1024
+	DTUint64     = 1280
+	DTFloat128   = 1536
+	DTComplex128 = 1792
+	DTComplex256 = 2048
+	DTRGBA32     = 2304
+)
+
+// Transform codes, stored in Image.QFormCode and Image.SFormCode, as
+// NIFTI_XFORM_* in nifti1.h.
+const (
+	XformUnknown     = 0
+	XformScannerAnat = 1
+	XformAlignedAnat = 2
+	XformTalairach   = 3
+	XformMNI152      = 4
+)
+
+// Slice order codes, stored in Image.SliceCode, as NIFTI_SLICE_* in
+// nifti1.h.
+const (
+	SliceUnknown = 0
+	SliceSeqInc  = 1
+	SliceSeqDec  = 2
+	SliceAltInc  = 3
+	SliceAltDec  = 4
+	SliceAltInc2 = 5
+	SliceAltDec2 = 6
+)
+
+// Unit codes, stored in Image.XYZUnits and Image.TimeUnits, as
+// NIFTI_UNITS_* in nifti1.h.
+const (
+	UnitsUnknown = 0
+	UnitsMeter   = 1
+	UnitsMM      = 2
+	UnitsMicron  = 3
+	UnitsSec     = 8
+	UnitsMsec    = 16
+	UnitsUsec    = 24
+)
+
+// Intent codes, stored in Image.IntentCode, as NIFTI_INTENT_* in nifti1.h.
+// ParseIntent and IntentString name the others.
+const (
+	IntentNone     = 0
+	IntentEstimate = 1001
+	IntentLabel    = 1002
+)
+
+// Datatype codes of nifti1.h that hold no voxel data.
+const (
+	dtUnknown = 0
+	dtBinary  = 1
+)
+
+// Unit codes of nifti1.h for spectral dimensions.
+const (
+	unitsHz   = 32
+	unitsPPM  = 40
+	unitsRads = 48
+)
+
+// The other intent codes, as NIFTI_INTENT_* in nifti1.h. The statistics run
+// from firstStatCode to lastStatCode, as NIFTI_FIRST_STATCODE and
+// NIFTI_LAST_STATCODE.
+const (
+	firstStatCode = 2
+	lastStatCode  = 24
+
+	intentCorrel     = 2
+	intentTTest      = 3
+	intentFTest      = 4
+	intentZScore     = 5
+	intentChiSq      = 6
+	intentBeta       = 7
+	intentBinom      = 8
+	intentGamma      = 9
+	intentPoisson    = 10
+	intentNormal     = 11
+	intentFTestNonc  = 12
+	intentChiSqNonc  = 13
+	intentLogistic   = 14
+	intentLaplace    = 15
+	intentUniform    = 16
+	intentTTestNonc  = 17
+	intentWeibull    = 18
+	intentChi        = 19
+	intentInvGauss   = 20
+	intentExtVal     = 21
+	intentPVal       = 22
+	intentLogPVal    = 23
+	intentLog10PVal  = 24
+	intentNeuroName  = 1003
+	intentGenMatrix  = 1004
+	intentSymMatrix  = 1005
+	intentDispVect   = 1006
+	intentVector     = 1007
+	intentPointSet   = 1008
+	intentTriangle   = 1009
+	intentQuaternion = 1010
+	intentDimless    = 1011
+	intentTimeSeries = 2001
+	intentNodeIndex  = 2002
+	intentRGBVector  = 2003
+	intentRGBAVector = 2004
+	intentShape      = 2005
+)
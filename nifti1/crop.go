@@ -0,0 +1,197 @@
+package nifti1
+
+import (
+	"fmt"
+	"math"
+)
+
+// Box is a box of voxels, from Min to Max exclusive along each axis.
+type Box struct {
+	Min, Max [3]int
+}
+
+// Size returns the number of voxels of the box along each axis.
+func (b Box) Size() [3]int {
+	return [3]int{b.Max[0] - b.Min[0], b.Max[1] - b.Min[1], b.Max[2] - b.Min[2]}
+}
+
+// Expand returns the box grown by margin voxels on every side, within a
+// grid of dims voxels.
+func (b Box) Expand(margin int, dims [3]int) Box {
+	for a := 0; a < 3; a++ {
+		b.Min[a] = int(math.Max(0, float64(b.Min[a]-margin)))
+		b.Max[a] = int(math.Min(float64(dims[a]), float64(b.Max[a]+margin)))
+	}
+	return b
+}
+
+// String returns the box as i0:i1,j0:j1,k0:k1.
+func (b Box) String() string {
+	return fmt.Sprintf("%d:%d,%d:%d,%d:%d", b.Min[0], b.Max[0], b.Min[1], b.Max[1], b.Min[2], b.Max[2])
+}
+
+// ForegroundBounds returns the smallest box containing the voxels whose
+// value, with scaling applied, is above threshold in any volume. It reports
+// false if there are none.
+func (img *Image) ForegroundBounds(threshold float64) (Box, bool, error) {
+	b := Box{Min: [3]int{img.Nx, img.Ny, img.Nz}}
+	at, err := img.Float64Func()
+	if err != nil {
+		return b, false, err
+	}
+	slope, inter, _ := img.Scaling()
+	nxyz := img.Nx * img.Ny * img.Nz
+	found := false
+	for v := 0; v < img.NVox; v++ {
+		if !(slope*at(v)+inter > threshold) {
+			continue
+		}
+		m := v % nxyz
+		p := [3]int{m % img.Nx, m / img.Nx % img.Ny, m / (img.Nx * img.Ny)}
+		for a := 0; a < 3; a++ {
+			if p[a] < b.Min[a] {
+				b.Min[a] = p[a]
+			}
+			if p[a]+1 > b.Max[a] {
+				b.Max[a] = p[a] + 1
+			}
+		}
+		found = true
+	}
+	if !found {
+		return Box{}, false, nil
+	}
+	return b, true, nil
+}
+
+// Crop returns a copy of the image holding the voxels of the box in every
+// volume, with the qform and sform moved so that the voxels keep their
+// world coordinates.
+func (img *Image) Crop(b Box) (*Image, error) {
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	for a := 0; a < 3; a++ {
+		if b.Min[a] < 0 || b.Max[a] > dims[a] || b.Min[a] >= b.Max[a] {
+			return nil, fmt.Errorf("box %v is empty or outside the grid of %v voxels", b, dims)
+		}
+	}
+	if len(img.Data) < img.NVox*img.NByPer {
+		return nil, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), img.NVox*img.NByPer)
+	}
+
+	out := *img
+	size := b.Size()
+	d := []int{size[0], size[1], size[2]}
+	if img.NDim > 3 {
+		d = append(d, img.Dim[4:img.NDim+1]...)
+	}
+	if err := out.SetDims(d...); err != nil {
+		return nil, err
+	}
+
+	nxyz := img.Nx * img.Ny * img.Nz
+	row := size[0] * img.NByPer
+	data := make([]byte, 0, out.NVox*img.NByPer)
+	for t := 0; t < img.NVox/nxyz; t++ {
+		for k := b.Min[2]; k < b.Max[2]; k++ {
+			for j := b.Min[1]; j < b.Max[1]; j++ {
+				start := (t*nxyz + b.Min[0] + img.Nx*(j+img.Ny*k)) * img.NByPer
+				data = append(data, img.Data[start:start+row]...)
+			}
+		}
+	}
+	out.Data = data
+	out.TrailingData = nil
+	out.DropChecksums()
+	out.shiftOrigin(b.Min)
+	return &out, nil
+}
+
+// CropVolumes returns a copy of the image holding n volumes from volume
+// start, with toffset moved so that they keep their times. Images with
+// dimensions beyond the fourth are not supported.
+func (img *Image) CropVolumes(start, n int) (*Image, error) {
+	if img.Nu > 1 || img.Nv > 1 || img.Nw > 1 {
+		return nil, fmt.Errorf("%w: cropping volumes of a %d-dimensional image", ErrUnsupported, img.NDim)
+	}
+	if start < 0 || n < 1 || start+n > img.Nt {
+		return nil, fmt.Errorf("volumes %d to %d are empty or outside the %d volumes", start, start+n-1, img.Nt)
+	}
+	vol := img.VolumeBytes()
+	if len(img.Data) < img.Nt*vol {
+		return nil, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), img.Nt*vol)
+	}
+
+	out := *img
+	if err := out.SetDims(img.Nx, img.Ny, img.Nz, n); err != nil {
+		return nil, err
+	}
+	out.Data = append([]byte(nil), img.Data[start*vol:(start+n)*vol]...)
+	out.TrailingData = nil
+	out.DropChecksums()
+	out.TOffset += float64(start) * img.Dt
+	return &out, nil
+}
+
+// shiftOrigin moves the qform and sform so that voxel o becomes voxel 0.
+func (img *Image) shiftOrigin(o [3]int) {
+	shift := func(m *mat44) [3]float64 {
+		var d [3]float64
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				d[r] += float64(m.m[r][c]) * float64(o[c])
+			}
+			m.m[r][3] = float32(float64(m.m[r][3]) + d[r])
+		}
+		return d
+	}
+	d := shift(&img.QtoXYZ)
+	img.QOffsetX += d[0]
+	img.QOffsetY += d[1]
+	img.QOffsetZ += d[2]
+	img.QtoIJK = img.QtoXYZ.inverse()
+	shift(&img.StoXYZ)
+	img.StoIJK = img.StoXYZ.inverse()
+}
+
+// AutoCrop crops the image to the voxels above threshold with margin
+// voxels around them, and returns the box it kept.
+func (img *Image) AutoCrop(threshold float64, margin int) (*Image, Box, error) {
+	b, ok, err := img.ForegroundBounds(threshold)
+	if err != nil {
+		return nil, b, err
+	}
+	if !ok {
+		return nil, b, fmt.Errorf("no voxels above %g", threshold)
+	}
+	b = b.Expand(margin, [3]int{img.Nx, img.Ny, img.Nz})
+	out, err := img.Crop(b)
+	return out, b, err
+}
+
+// SetOrigin translates the qform and sform so that voxel (i, j, k), which
+// may be fractional, maps to world coordinates (0, 0, 0), keeping their
+// rotations and voxel sizes, as "set origin" does in SPM. If neither
+// transform is set, the qform code becomes XformScannerAnat so that the
+// new origin is used by readers.
+func (img *Image) SetOrigin(voxel [3]float64) {
+	if img.QFormCode <= 0 && img.SFormCode <= 0 {
+		img.QFormCode = XformScannerAnat
+	}
+	move := func(m *mat44) [3]float64 {
+		var t [3]float64
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				t[r] -= float64(m.m[r][c]) * voxel[c]
+			}
+			m.m[r][3] = float32(t[r])
+		}
+		return t
+	}
+	t := move(&img.QtoXYZ)
+	img.QOffsetX, img.QOffsetY, img.QOffsetZ = t[0], t[1], t[2]
+	img.QtoIJK = img.QtoXYZ.inverse()
+	if img.SFormCode > 0 {
+		move(&img.StoXYZ)
+		img.StoIJK = img.StoXYZ.inverse()
+	}
+}
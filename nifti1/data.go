@@ -0,0 +1,260 @@
+package nifti1
+
+import (
+	"fmt"
+	"math"
+)
+
+// DataTypes returns the codes of the datatypes whose voxels can be stored,
+// read, and written as they are, in the order of nifti1.h.
+func DataTypes() []int {
+	var codes []int
+	for _, c := range dataTypeNames {
+		if nbyper, _ := DatatypeSize(c.code); nbyper > 0 {
+			codes = append(codes, c.code)
+		}
+	}
+	return codes
+}
+
+// IsNumeric reports whether the values of a datatype can be converted to
+// float64, as by Float64Func. Complex and RGB datatypes cannot.
+func IsNumeric(datatype int) bool {
+	switch datatype {
+	case DTUint8, DTInt8, DTInt16, DTUint16, DTInt32, DTUint32,
+		DTInt64, DTUint64, DTFloat32, DTFloat64:
+		return true
+	}
+	return false
+}
+
+// Float64Func returns a function that reads the raw value of voxel i (in
+// file order) directly from Data, without applying scl_slope and scl_inter.
+// Complex and RGB datatypes are not supported.
+func (img *Image) Float64Func() (func(i int) float64, error) {
+	if img.NByPer == 0 || len(img.Data) < img.NVox*img.NByPer {
+		return nil, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), img.NVox*img.NByPer)
+	}
+
+	order := img.byteOrder()
+	b := img.Data
+
+	switch img.DataType {
+	case DTUint8:
+		return func(i int) float64 { return float64(b[i]) }, nil
+	case DTInt8:
+		return func(i int) float64 { return float64(int8(b[i])) }, nil
+	case DTInt16:
+		return func(i int) float64 { return float64(int16(order.Uint16(b[2*i:]))) }, nil
+	case DTUint16:
+		return func(i int) float64 { return float64(order.Uint16(b[2*i:])) }, nil
+	case DTInt32:
+		return func(i int) float64 { return float64(int32(order.Uint32(b[4*i:]))) }, nil
+	case DTUint32:
+		return func(i int) float64 { return float64(order.Uint32(b[4*i:])) }, nil
+	case DTInt64:
+		return func(i int) float64 { return float64(int64(order.Uint64(b[8*i:]))) }, nil
+	case DTUint64:
+		return func(i int) float64 { return float64(order.Uint64(b[8*i:])) }, nil
+	case DTFloat32:
+		return func(i int) float64 { return float64(math.Float32frombits(order.Uint32(b[4*i:]))) }, nil
+	case DTFloat64:
+		return func(i int) float64 { return math.Float64frombits(order.Uint64(b[8*i:])) }, nil
+	}
+	return nil, fmt.Errorf("%w datatype %d", ErrUnsupported, img.DataType)
+}
+
+// Float64s returns the voxel values converted to float64, without applying
+// scl_slope and scl_inter. Complex and RGB datatypes are not supported.
+func (img *Image) Float64s() ([]float64, error) {
+	at, err := img.Float64Func()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]float64, img.NVox)
+	for i := range out {
+		out[i] = at(i)
+	}
+	return out, nil
+}
+
+// ScaledFloat64s returns the voxel values converted to float64 with
+// scl_slope and scl_inter applied, following the rules of Scaling.
+func (img *Image) ScaledFloat64s() ([]float64, error) {
+	out, err := img.Float64s()
+	if err != nil {
+		return nil, err
+	}
+	slope, inter, ok := img.Scaling()
+	if !ok {
+		return out, nil
+	}
+	for i, v := range out {
+		out[i] = slope*v + inter
+	}
+	return out, nil
+}
+
+// SetDims sets the dimensions of the grid, updating NDim, Nx..Nw, Dim, and
+// NVox. Unused dimensions are set to 1. The data are not changed.
+func (img *Image) SetDims(dims ...int) error {
+	if len(dims) < 1 || len(dims) > 7 {
+		return fmt.Errorf("number of dimensions must be in [1, 7], got %d", len(dims))
+	}
+
+	img.Dim[0] = len(dims)
+	img.NVox = 1
+	for i := 1; i <= 7; i++ {
+		img.Dim[i] = 1
+		if i <= len(dims) {
+			if dims[i-1] < 1 {
+				return fmt.Errorf("dimension %d must be positive, got %d", i, dims[i-1])
+			}
+			img.Dim[i] = dims[i-1]
+		}
+		img.NVox *= img.Dim[i]
+	}
+
+	img.NDim = img.Dim[0]
+	img.Nx, img.Ny, img.Nz = img.Dim[1], img.Dim[2], img.Dim[3]
+	img.Nt, img.Nu, img.Nv, img.Nw = img.Dim[4], img.Dim[5], img.Dim[6], img.Dim[7]
+	return nil
+}
+
+// SetFloat32Data replaces the data with values stored as DT_FLOAT32. The
+// number of values must match NVox. Scaling is reset to identity, the
+// display range is cleared, and checksums are dropped, since all described
+// the old data.
+func (img *Image) SetFloat32Data(values []float64) error {
+	if len(values) != img.NVox {
+		return fmt.Errorf("got %d values for %d voxels", len(values), img.NVox)
+	}
+
+	order := img.byteOrder()
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		order.PutUint32(b[4*i:], math.Float32bits(float32(v)))
+	}
+
+	img.DataType = DTFloat32
+	img.NByPer, img.SwapSize = DatatypeSize(img.DataType)
+	img.Data = b
+	img.TrailingData = nil
+	img.SclSlope, img.SclInter = 1, 0
+	img.CalMin, img.CalMax = 0, 0
+	img.DropChecksums()
+	return nil
+}
+
+// SetUint8Data replaces the data with values stored as DT_UINT8, such as a
+// mask or a small label image. The number of values must match NVox.
+// Scaling, the display range, and checksums are reset as by SetFloat32Data.
+func (img *Image) SetUint8Data(values []uint8) error {
+	if len(values) != img.NVox {
+		return fmt.Errorf("got %d values for %d voxels", len(values), img.NVox)
+	}
+
+	img.DataType = DTUint8
+	img.NByPer, img.SwapSize = DatatypeSize(img.DataType)
+	img.Data = append([]byte(nil), values...)
+	img.TrailingData = nil
+	img.SclSlope, img.SclInter = 1, 0
+	img.CalMin, img.CalMax = 0, 0
+	img.DropChecksums()
+	return nil
+}
+
+// Complex128s returns the voxel values of a DT_COMPLEX64 or DT_COMPLEX128
+// image. Scaling is not applied.
+func (img *Image) Complex128s() ([]complex128, error) {
+	if img.NByPer == 0 || len(img.Data) < img.NVox*img.NByPer {
+		return nil, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), img.NVox*img.NByPer)
+	}
+
+	order := img.byteOrder()
+	b := img.Data
+	out := make([]complex128, img.NVox)
+
+	switch img.DataType {
+	case DTComplex64:
+		for i := range out {
+			re := math.Float32frombits(order.Uint32(b[8*i:]))
+			im := math.Float32frombits(order.Uint32(b[8*i+4:]))
+			out[i] = complex(float64(re), float64(im))
+		}
+	case DTComplex128:
+		for i := range out {
+			re := math.Float64frombits(order.Uint64(b[16*i:]))
+			im := math.Float64frombits(order.Uint64(b[16*i+8:]))
+			out[i] = complex(re, im)
+		}
+	default:
+		return nil, fmt.Errorf("%w: datatype %d is not complex", ErrUnsupported, img.DataType)
+	}
+	return out, nil
+}
+
+// SetComplex64Data replaces the data with values stored as DT_COMPLEX64. The
+// number of values must match NVox. Scaling, the display range, and
+// checksums are reset as by SetFloat32Data.
+func (img *Image) SetComplex64Data(values []complex128) error {
+	if len(values) != img.NVox {
+		return fmt.Errorf("got %d values for %d voxels", len(values), img.NVox)
+	}
+
+	order := img.byteOrder()
+	b := make([]byte, 8*len(values))
+	for i, v := range values {
+		order.PutUint32(b[8*i:], math.Float32bits(float32(real(v))))
+		order.PutUint32(b[8*i+4:], math.Float32bits(float32(imag(v))))
+	}
+
+	img.DataType = DTComplex64
+	img.NByPer, img.SwapSize = DatatypeSize(img.DataType)
+	img.Data = b
+	img.TrailingData = nil
+	img.SclSlope, img.SclInter = 1, 0
+	img.CalMin, img.CalMax = 0, 0
+	img.DropChecksums()
+	return nil
+}
+
+// WithData returns a copy of the image with the voxel data b, stored as
+// datatype in the byte order of the image. The copy keeps the dims,
+// spacing, qform and sform, units, intent, description, and extensions of
+// img, but its scaling is reset to identity, its display range is cleared,
+// and checksums of the old data are dropped. b must hold NVox values and
+// is used as it is, not copied.
+func (img *Image) WithData(b []byte, datatype int) (*Image, error) {
+	size, swap := DatatypeSize(datatype)
+	if size == 0 {
+		return nil, fmt.Errorf("%w datatype %d", ErrUnsupported, datatype)
+	}
+	if len(b) != img.NVox*size {
+		return nil, fmt.Errorf("got %d bytes of %s data for %d voxels, expected %d",
+			len(b), DataTypeString(datatype), img.NVox, img.NVox*size)
+	}
+
+	out := *img
+	out.DataType = datatype
+	out.NByPer, out.SwapSize = size, swap
+	out.Data = b
+	out.TrailingData = nil
+	out.SclSlope, out.SclInter = 1, 0
+	out.CalMin, out.CalMax = 0, 0
+	out.DropChecksums()
+	return &out, nil
+}
+
+// WithFloat32Data is like WithData for values stored as DT_FLOAT32.
+func (img *Image) WithFloat32Data(values []float32) (*Image, error) {
+	if len(values) != img.NVox {
+		return nil, fmt.Errorf("got %d values for %d voxels", len(values), img.NVox)
+	}
+	order := img.byteOrder()
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		order.PutUint32(b[4*i:], math.Float32bits(v))
+	}
+	return img.WithData(b, DTFloat32)
+}
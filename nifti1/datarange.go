@@ -0,0 +1,118 @@
+package nifti1
+
+import (
+	"fmt"
+	"math"
+)
+
+// DataTypeRange returns the smallest and largest values that a datatype
+// can store, and reports whether it has such a range. Complex, RGB, and
+// unknown datatypes do not. The limits of DT_INT64 and DT_UINT64 are
+// rounded to float64.
+func DataTypeRange(datatype int) (lo, hi float64, ok bool) {
+	switch datatype {
+	case DTUint8:
+		return 0, math.MaxUint8, true
+	case DTInt8:
+		return math.MinInt8, math.MaxInt8, true
+	case DTInt16:
+		return math.MinInt16, math.MaxInt16, true
+	case DTUint16:
+		return 0, math.MaxUint16, true
+	case DTInt32:
+		return math.MinInt32, math.MaxInt32, true
+	case DTUint32:
+		return 0, math.MaxUint32, true
+	case DTInt64:
+		return math.MinInt64, math.MaxInt64, true
+	case DTUint64:
+		return 0, math.MaxUint64, true
+	case DTFloat32:
+		return -math.MaxFloat32, math.MaxFloat32, true
+	case DTFloat64:
+		return -math.MaxFloat64, math.MaxFloat64, true
+	}
+	return 0, 0, false
+}
+
+// IsInteger reports whether a datatype stores integers.
+func IsInteger(datatype int) bool {
+	return IsNumeric(datatype) && datatype != DTFloat32 && datatype != DTFloat64
+}
+
+// ValueRange returns the range of the real values that the image can hold:
+// the range of its datatype with scl_slope and scl_inter applied.
+func (img *Image) ValueRange() (lo, hi float64, ok bool) {
+	lo, hi, ok = DataTypeRange(img.DataType)
+	if !ok {
+		return 0, 0, false
+	}
+	slope, inter, _ := img.Scaling()
+	lo, hi = slope*lo+inter, slope*hi+inter
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return lo, hi, true
+}
+
+// Saturation counts the voxels whose stored values sit at the limits of
+// their datatype, where values beyond the range were likely clipped when
+// the image was converted or scaled.
+type Saturation struct {
+	// Lo and Hi are the limits of the datatype, as by DataTypeRange.
+	Lo, Hi float64
+	// Min and Max are the smallest and largest stored values; NaN if no
+	// value is a number.
+	Min, Max float64
+	// AtLo and AtHi are the numbers of voxels at or beyond Lo and Hi,
+	// which includes infinities for floating-point datatypes. N is the
+	// number of voxels counted, which leaves out NaNs.
+	AtLo, AtHi, N int
+}
+
+// Saturated reports whether any voxel is at a limit.
+func (s Saturation) Saturated() bool {
+	return s.AtLo > 0 || s.AtHi > 0
+}
+
+// Fraction returns the fraction of the voxels at either limit.
+func (s Saturation) Fraction() float64 {
+	if s.N == 0 {
+		return 0
+	}
+	return float64(s.AtLo+s.AtHi) / float64(s.N)
+}
+
+// Saturation counts the stored values of the image at the limits of its
+// datatype. For unsigned datatypes, whose lower limit is 0, AtLo counts
+// the zeros, which are usually background rather than clipped values.
+func (img *Image) Saturation() (Saturation, error) {
+	lo, hi, ok := DataTypeRange(img.DataType)
+	if !ok {
+		return Saturation{}, fmt.Errorf("%w datatype %s", ErrUnsupported, DataTypeString(img.DataType))
+	}
+	at, err := img.Float64Func()
+	if err != nil {
+		return Saturation{}, err
+	}
+	s := Saturation{Lo: lo, Hi: hi, Min: math.Inf(1), Max: math.Inf(-1)}
+	for i := 0; i < img.NVox; i++ {
+		v := at(i)
+		if math.IsNaN(v) {
+			continue
+		}
+		s.N++
+		if v <= lo {
+			s.AtLo++
+		}
+		if v >= hi {
+			s.AtHi++
+		}
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+	if s.N == 0 {
+		s.Min, s.Max = math.NaN(), math.NaN()
+	}
+	return s, nil
+}
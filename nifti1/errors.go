@@ -0,0 +1,35 @@
+package nifti1
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Kinds of errors returned by this package. Use errors.Is to test for them.
+var (
+	ErrInvalidHeader = errors.New("invalid header")
+	ErrTruncated     = errors.New("truncated data")
+	ErrCorrupt       = errors.New("corrupt compressed data")
+	ErrGridMismatch  = errors.New("images are not on the same grid")
+	ErrUnsupported   = errors.New("unsupported")
+	ErrTooLarge      = errors.New("image too large for the memory limit")
+)
+
+// GzipError diagnoses an error from inflating the gzip file name: a stream
+// that ends early is ErrTruncated, and one whose deflate data, CRC-32, or
+// size (ISIZE) is wrong is ErrCorrupt. Other errors are returned as is.
+func GzipError(name string, err error) error {
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%s: %w: the gzip stream ends early, so the file was cut short", name, ErrTruncated)
+	case errors.Is(err, gzip.ErrChecksum):
+		return fmt.Errorf("%s: %w: the CRC-32 or size recorded by gzip does not match the inflated data", name, ErrCorrupt)
+	case errors.Is(err, gzip.ErrHeader), errors.As(err, &corrupt):
+		return fmt.Errorf("%s: %w: %v", name, ErrCorrupt, err)
+	}
+	return err
+}
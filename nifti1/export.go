@@ -0,0 +1,125 @@
+package nifti1
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Alignment is the alignment in bytes of the buffers of Float32Tensor, a
+// multiple of the cache line and vector register sizes that CUDA and
+// OpenCL accept for zero-copy host buffers.
+const Alignment = 64
+
+// Allocator returns a buffer of n bytes for a Float32Tensor, such as
+// page-locked host memory from cudaHostAlloc. The buffer must be aligned to
+// Alignment bytes, and outlives the image: freeing it is up to the caller.
+type Allocator func(n int) ([]byte, error)
+
+// Float32Tensor is the voxel data as native float32 values in one
+// buffer.
+type Float32Tensor struct {
+	// Data starts at an address that is a multiple of Alignment.
+	Data []float32
+	// Shape lists the dimensions: from slowest to fastest, the reverse of
+	// the NIfTI dim order, (nt, nz, ny, nx) for a 4D image, as returned by
+	// Float32Tensor, or in the NIfTI dim order, (nx, ny, nz, nt), as
+	// returned by Float32TensorOrder.
+	Shape []int
+	// Strides are the distances in Data, in elements, between values
+	// whose index differs by one along each axis of Shape.
+	Strides []int
+}
+
+// Pointer returns the address of the first value, to hand to C.
+func (t Float32Tensor) Pointer() unsafe.Pointer {
+	if len(t.Data) == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&t.Data[0])
+}
+
+// Float32Tensor returns the voxel values with scl_slope and scl_inter
+// applied as float32 in a C-contiguous (row-major) buffer from alloc, or
+// from the Go heap if alloc is nil. NIfTI stores i fastest, so the values
+// keep their file order. Complex and RGB datatypes are not supported.
+func (img *Image) Float32Tensor(alloc Allocator) (Float32Tensor, error) {
+	var t Float32Tensor
+	at, err := img.Float64Func()
+	if err != nil {
+		return t, err
+	}
+	for d := img.NDim; d >= 1; d-- {
+		t.Shape = append(t.Shape, img.Dim[d])
+	}
+	t.Strides = Strides(t.Shape, COrder)
+	if t.Data, err = allocFloat32(alloc, img.NVox); err != nil || len(t.Data) == 0 {
+		return t, err
+	}
+	slope, inter, scaled := img.Scaling()
+	for i := range t.Data {
+		v := at(i)
+		if scaled {
+			v = slope*v + inter
+		}
+		t.Data[i] = float32(v)
+	}
+	return t, nil
+}
+
+// Float32TensorOrder is like Float32Tensor, but the shape is in the NIfTI
+// dim order, (nx, ny, nz, nt) for a 4D image, as the arrays of nibabel,
+// and the values are laid out in order. FortranOrder keeps the file order,
+// x fastest, as Float32Tensor does. COrder permutes the values so that the
+// last dim varies fastest, as libraries that only take C-contiguous arrays
+// of that shape need.
+func (img *Image) Float32TensorOrder(alloc Allocator, order Order) (Float32Tensor, error) {
+	if order == FortranOrder {
+		t, err := img.Float32Tensor(alloc)
+		if err != nil {
+			return t, err
+		}
+		t.Shape = append([]int(nil), img.Dim[1:img.NDim+1]...)
+		t.Strides = img.Strides()
+		return t, nil
+	}
+	var t Float32Tensor
+	values, err := img.float32Values()
+	if err != nil {
+		return t, err
+	}
+	t.Shape = append([]int(nil), img.Dim[1:img.NDim+1]...)
+	t.Strides = Strides(t.Shape, COrder)
+	if t.Data, err = allocFloat32(alloc, img.NVox); err != nil {
+		return t, err
+	}
+	permute(t.Data, values, t.Shape, img.Strides())
+	return t, nil
+}
+
+// allocFloat32 returns n float32 values in a buffer from alloc, or from the
+// Go heap if alloc is nil, checking its length and alignment.
+func allocFloat32(alloc Allocator, n int) ([]float32, error) {
+	size := 4 * n
+	var b []byte
+	if alloc == nil {
+		// Over-allocate to find an aligned start within the buffer.
+		b = make([]byte, size+Alignment)
+		off := int(-uintptr(unsafe.Pointer(&b[0])) & (Alignment - 1))
+		b = b[off : off+size]
+	} else {
+		var err error
+		if b, err = alloc(size); err != nil {
+			return nil, err
+		}
+		if len(b) < size {
+			return nil, fmt.Errorf("allocator returned %d bytes, expected %d", len(b), size)
+		}
+		if size > 0 && uintptr(unsafe.Pointer(&b[0]))%Alignment != 0 {
+			return nil, fmt.Errorf("allocator returned a buffer that is not %d-byte aligned", Alignment)
+		}
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	return (*[1 << 30]float32)(unsafe.Pointer(&b[0]))[:n:n], nil
+}
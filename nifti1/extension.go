@@ -0,0 +1,246 @@
+package nifti1
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Extension codes. See nifti1_io.h for the full list of registered codes.
+const (
+	ECodeIgnore       = 0
+	ECodeDICOM        = 2  // intended for raw DICOM attributes
+	ECodeAFNI         = 4  // AFNI header attributes (XML)
+	ECodeComment      = 6  // plain ASCII text
+	ECodeXCEDE        = 8  // XCEDE metadata
+	ECodeJIMDiMap     = 10 // dimensional information for the JIM software
+	ECodeWorkflowFWDS = 12 // Fiswidgets workflow
+	ECodeFreeSurfer   = 14 // FreeSurfer
+	ECodePyPickle     = 16 // Python pickle
+	ECodeVoxBo        = 28 // VoxBo
+	ECodeCaret        = 30 // Caret
+	ECodeCIFTI        = 32 // CIFTI-2
+	ECodeMATLAB       = 40 // MATLAB
+	ECodeQuantiphyse  = 42 // Quantiphyse
+	ECodeMRS          = 44 // MRS-NIfTI
+)
+
+// ecodeNames are the names of the registered extension codes.
+var ecodeNames = map[int32]string{
+	ECodeIgnore:       "ignore",
+	ECodeDICOM:        "dicom",
+	ECodeAFNI:         "afni",
+	ECodeComment:      "comment",
+	ECodeXCEDE:        "xcede",
+	ECodeJIMDiMap:     "jimdimap",
+	ECodeWorkflowFWDS: "workflow_fwds",
+	ECodeFreeSurfer:   "freesurfer",
+	ECodePyPickle:     "pypickle",
+	ECodeVoxBo:        "voxbo",
+	ECodeCaret:        "caret",
+	ECodeCIFTI:        "cifti",
+	ECodeMATLAB:       "matlab",
+	ECodeQuantiphyse:  "quantiphyse",
+	ECodeMRS:          "mrs",
+}
+
+// ECodeName returns the name of an extension code, such as "afni", or
+// "unknown".
+func ECodeName(code int32) string {
+	if name, ok := ecodeNames[code]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseECode parses an extension code given as a number or a name.
+func ParseECode(s string) (int32, error) {
+	for code, name := range ecodeNames {
+		if strings.EqualFold(s, name) {
+			return code, nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown extension code %q", s)
+	}
+	return int32(n), nil
+}
+
+// ExtensionRule selects extensions by code; see WithExtensions.
+type ExtensionRule struct {
+	keep  bool
+	codes []int32
+}
+
+// Keep is a rule keeping the extensions with the given codes. If an image
+// is written with any Keep rule, extensions no Keep rule names are dropped.
+func Keep(codes ...int32) ExtensionRule {
+	return ExtensionRule{keep: true, codes: codes}
+}
+
+// Drop is a rule dropping the extensions with the given codes.
+func Drop(codes ...int32) ExtensionRule {
+	return ExtensionRule{codes: codes}
+}
+
+// FilterExtensions returns the extensions selected by rules: those with a
+// code no Drop rule names and, if there are Keep rules, that one of them
+// names.
+func FilterExtensions(exts []Extension, rules []ExtensionRule) []Extension {
+	if len(rules) == 0 {
+		return exts
+	}
+	hasKeep := false
+	keep, drop := map[int32]bool{}, map[int32]bool{}
+	for _, r := range rules {
+		for _, c := range r.codes {
+			if r.keep {
+				keep[c] = true
+			} else {
+				drop[c] = true
+			}
+		}
+		hasKeep = hasKeep || r.keep
+	}
+	var out []Extension
+	for _, e := range exts {
+		if drop[e.ECode] || (hasKeep && !keep[e.ECode]) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Extension is a header extension. Extensions follow the 4-byte extender
+// that comes after the 348-byte header.
+// https://nifti.nimh.nih.gov/nifti-1/documentation/nifti1fields/nifti1fields_pages/extension.html
+type Extension struct {
+	ECode int32  // extension code, one of the NIFTI_ECODE_* values
+	Data  []byte // raw data, with no byte swapping (length is esize-8)
+}
+
+// Size returns the number of bytes the extension occupies on disk, including
+// the esize and ecode fields. The size is always a multiple of 16.
+func (e Extension) Size() int {
+	size := len(e.Data) + 8
+	if rem := size % 16; rem != 0 {
+		size += 16 - rem
+	}
+	return size
+}
+
+// ExtensionsSize returns the number of bytes a list of extensions occupies on
+// disk, not including the 4-byte extender.
+func ExtensionsSize(exts []Extension) int {
+	size := 0
+	for _, e := range exts {
+		size += e.Size()
+	}
+	return size
+}
+
+// MinVoxOffset returns the smallest valid vox_offset of the image: the end
+// of the header, extender, and extensions for single files, and 0 for pairs,
+// whose data is in a separate file.
+func (img *Image) MinVoxOffset() int {
+	if img.NiftiType == FileTypeNifti1Pair {
+		return 0
+	}
+	return headerSize + ExtensionsSize(img.Extensions)
+}
+
+// CheckVoxOffset returns the problems with the image's vox_offset
+// (INameOffset): for single files, it must be past the extensions, as it
+// may not be after extensions are added in memory, and it should be a
+// multiple of 16. WriteFile recalculates it.
+func (img *Image) CheckVoxOffset() []string {
+	if img.NiftiType == FileTypeNifti1Pair {
+		return nil
+	}
+	var issues []string
+	if min := img.MinVoxOffset(); img.INameOffset < min {
+		issues = append(issues, fmt.Sprintf("vox_offset %d is inside the header and extensions, which end at byte %d", img.INameOffset, min))
+	}
+	if img.INameOffset%16 != 0 {
+		issues = append(issues, fmt.Sprintf("vox_offset %d is not a multiple of 16", img.INameOffset))
+	}
+	return issues
+}
+
+// ReadExtensions reads the extensions stored in b between the end of the
+// extender (byte 352) and end. For single files, end is vox_offset; for
+// header/image pairs, it is the length of the .hdr file.
+// Refer to nifti_read_extensions in nifti1_io.c.
+func ReadExtensions(b []byte, order binary.ByteOrder, end int) ([]Extension, error) {
+	if end > len(b) {
+		end = len(b)
+	}
+
+	// No extender or extender[0] == 0 means there are no extensions.
+	if end < headerSize || b[minHeaderSize] == 0 {
+		return nil, nil
+	}
+
+	var exts []Extension
+	pos := headerSize
+	for pos+8 <= end {
+		size := int(int32(order.Uint32(b[pos : pos+4])))
+		code := int32(order.Uint32(b[pos+4 : pos+8]))
+		// Zeros after the extensions pad the data to an aligned
+		// vox_offset.
+		if size == 0 && code == 0 {
+			break
+		}
+
+		if size < 16 || pos+size > end {
+			return exts, fmt.Errorf("invalid extension size %d at offset %d", size, pos)
+		}
+		if size%16 != 0 {
+			log.WithFields(log.Fields{
+				"esize":  size,
+				"ecode":  code,
+				"offset": pos,
+			}).Warn("Extension size is not a multiple of 16")
+		}
+
+		data := make([]byte, size-8)
+		copy(data, b[pos+8:pos+size])
+		exts = append(exts, Extension{ECode: code, Data: data})
+		pos += size
+	}
+
+	log.WithFields(log.Fields{
+		"numExt": len(exts),
+	}).Debug("Read extensions")
+
+	return exts, nil
+}
+
+// appendExtensions appends the 4-byte extender and the extensions to b. The
+// extender is written as all zeros if there are no extensions.
+func appendExtensions(b []byte, exts []Extension, order binary.ByteOrder) []byte {
+	if len(exts) == 0 {
+		return append(b, 0, 0, 0, 0)
+	}
+	b = append(b, 1, 0, 0, 0)
+
+	var field [4]byte
+	for _, e := range exts {
+		size := e.Size()
+		order.PutUint32(field[:], uint32(size))
+		b = append(b, field[:]...)
+		order.PutUint32(field[:], uint32(e.ECode))
+		b = append(b, field[:]...)
+		b = append(b, e.Data...)
+		// Pad with zeros to a multiple of 16.
+		for i := len(e.Data) + 8; i < size; i++ {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
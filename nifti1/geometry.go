@@ -0,0 +1,208 @@
+package nifti1
+
+import (
+	"fmt"
+	"math"
+)
+
+// SpatialUnitsToMM returns the factor that converts lengths in the given
+// NIFTI_UNITS_* spatial units to millimeters. Unknown units are assumed to be
+// millimeters.
+func SpatialUnitsToMM(units int) float64 {
+	switch units {
+	case UnitsMeter:
+		return 1000
+	case UnitsMicron:
+		return 0.001
+	}
+	return 1
+}
+
+// TimeUnitsToSeconds returns the factor that converts durations in the given
+// NIFTI_UNITS_* time units to seconds. Unknown units are assumed to be
+// seconds.
+func TimeUnitsToSeconds(units int) float64 {
+	switch units {
+	case UnitsMsec:
+		return 0.001
+	case UnitsUsec:
+		return 1e-6
+	}
+	return 1
+}
+
+// RepetitionTime returns the spacing of volumes (dt) in seconds.
+func (img *Image) RepetitionTime() float64 {
+	return math.Abs(img.Dt) * TimeUnitsToSeconds(img.TimeUnits)
+}
+
+// VolumeRange returns the first volume and the number of volumes acquired
+// from tmin for duration seconds, counting from toffset, or to the last
+// volume if duration is not positive. Volume t is acquired at toffset + t
+// dt; a volume is included if it starts within the interval.
+func (img *Image) VolumeRange(tmin, duration float64) (start, n int, err error) {
+	tr := img.RepetitionTime()
+	if !(tr > 0) {
+		return 0, 0, fmt.Errorf("the repetition time (pixdim[4] = %g) is not positive", img.Dt)
+	}
+	nt := img.NVox / (img.Nx * img.Ny * img.Nz)
+	t0 := img.TOffset * TimeUnitsToSeconds(img.TimeUnits)
+	// The tolerance keeps times on a volume from being pushed past it by
+	// rounding, as 0.3/0.1 is.
+	const eps = 1e-9
+	index := func(t float64) int {
+		return int(math.Ceil((t-t0)/tr - eps))
+	}
+	start = index(tmin)
+	end := nt
+	if duration > 0 {
+		end = index(tmin + duration)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > nt {
+		end = nt
+	}
+	if start >= end {
+		span := fmt.Sprintf("from %g s", tmin)
+		if duration > 0 {
+			span += fmt.Sprintf(" to %g s", tmin+duration)
+		}
+		return 0, 0, fmt.Errorf("no volumes %s: the %d volumes span %g s to %g s",
+			span, nt, t0, t0+float64(nt-1)*tr)
+	}
+	return start, end - start, nil
+}
+
+// SliceTimes returns the acquisition time in seconds of each slice along
+// the slice dimension, relative to the start of the volume, from
+// slice_code, slice_start, slice_end, and slice_duration, which is the time
+// between shots. A slice_duration of 0 is taken as the repetition time
+// divided by the number of shots. The header cannot record a multiband
+// factor, so it is given with Multiband. Slices outside
+// slice_start..slice_end, which are padding, get time 0.
+func (img *Image) SliceTimes(opts ...SliceOption) ([]float64, error) {
+	if img.SliceDim < 1 || img.SliceDim > 3 {
+		return nil, fmt.Errorf("%w: no slice dimension in dim_info", ErrUnsupported)
+	}
+	ns := img.Dim[img.SliceDim]
+	start, end := img.SliceStart, img.SliceEnd
+	if end == 0 {
+		end = ns - 1
+	}
+	if start < 0 || end >= ns || start > end {
+		return nil, fmt.Errorf("%w: slices %d..%d of %d", ErrInvalidHeader, start, end, ns)
+	}
+	n := end - start + 1
+	shots, err := SliceShots(n, img.SliceCode, opts...)
+	if err != nil {
+		return nil, err
+	}
+	nshots := 0
+	for _, k := range shots {
+		if k+1 > nshots {
+			nshots = k + 1
+		}
+	}
+	dur := img.SliceDuration * TimeUnitsToSeconds(img.TimeUnits)
+	if dur <= 0 {
+		dur = img.RepetitionTime() / float64(nshots)
+	}
+
+	times := make([]float64, ns)
+	for i, k := range shots {
+		times[start+i] = float64(k) * dur
+	}
+	return times, nil
+}
+
+// VoxelSizeMM returns the grid spacings (dx, dy, dz) in millimeters.
+func (img *Image) VoxelSizeMM() [3]float64 {
+	s := SpatialUnitsToMM(img.XYZUnits)
+	return [3]float64{
+		math.Abs(img.Dx) * s,
+		math.Abs(img.Dy) * s,
+		math.Abs(img.Dz) * s,
+	}
+}
+
+// VoxelVolumeMM3 returns the volume of a single voxel in cubic millimeters.
+func (img *Image) VoxelVolumeMM3() float64 {
+	d := img.VoxelSizeMM()
+	return d[0] * d[1] * d[2]
+}
+
+// FieldOfViewMM returns the world-space length of the grid along each of the
+// (i, j, k) axes in millimeters.
+func (img *Image) FieldOfViewMM() [3]float64 {
+	d := img.VoxelSizeMM()
+	return [3]float64{
+		float64(img.Nx) * d[0],
+		float64(img.Ny) * d[1],
+		float64(img.Nz) * d[2],
+	}
+}
+
+// VolumeMM3 returns the volume covered by n voxels in cubic millimeters.
+func (img *Image) VolumeMM3(n int) float64 {
+	return float64(n) * img.VoxelVolumeMM3()
+}
+
+// MM3ToML converts cubic millimeters to milliliters.
+func MM3ToML(v float64) float64 {
+	return v / 1000
+}
+
+// SameGrid reports whether two images have the same spatial dimensions.
+func SameGrid(a, b *Image) bool {
+	return a.Nx == b.Nx && a.Ny == b.Ny && a.Nz == b.Nz
+}
+
+// Affine returns the transform from voxel indices (i, j, k) to world
+// coordinates (x, y, z). The sform is used when its code is set; otherwise
+// the qform (or, for Analyze-style headers, the pixdim scaling) is used.
+func (img *Image) Affine() [4][4]float64 {
+	m := img.QtoXYZ
+	if img.SFormCode > 0 {
+		m = img.StoXYZ
+	}
+	var r [4][4]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			r[i][j] = float64(m.m[i][j])
+		}
+	}
+	return r
+}
+
+// VoxelToWorld transforms (possibly fractional) voxel indices to world
+// coordinates using Affine.
+func (img *Image) VoxelToWorld(i, j, k float64) [3]float64 {
+	return applyAffine(img.Affine(), i, j, k)
+}
+
+// applyAffine applies the 4x4 affine a to the point (x, y, z).
+func applyAffine(a [4][4]float64, x, y, z float64) [3]float64 {
+	return [3]float64{
+		a[0][0]*x + a[0][1]*y + a[0][2]*z + a[0][3],
+		a[1][0]*x + a[1][1]*y + a[1][2]*z + a[1][3],
+		a[2][0]*x + a[2][1]*y + a[2][2]*z + a[2][3],
+	}
+}
+
+// WorldToVoxel transforms world coordinates to (fractional) voxel indices
+// using the inverse of Affine.
+func (img *Image) WorldToVoxel(x, y, z float64) [3]float64 {
+	m := img.QtoIJK
+	if img.SFormCode > 0 {
+		m = img.StoIJK
+	}
+	var a [4][4]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			a[i][j] = float64(m.m[i][j])
+		}
+	}
+	return applyAffine(a, x, y, z)
+}
@@ -0,0 +1,374 @@
+package nifti1
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// splitGzipSuffix removes a trailing ".gz" from filename and reports whether
+// it was present.
+func splitGzipSuffix(filename string) (string, bool) {
+	if strings.HasSuffix(filename, ".gz") {
+		return strings.TrimSuffix(filename, ".gz"), true
+	}
+	return filename, false
+}
+
+// FileTypeFromName returns the file type implied by the extension of
+// filename, ignoring the suffix of a registered codec such as ".gz":
+// FileTypeNifti1Pair for .hdr and .img files, and FileTypeNifti1 otherwise.
+func FileTypeFromName(filename string) int {
+	base, _ := util.SplitCodecSuffix(filename)
+	if strings.HasSuffix(base, ".hdr") || strings.HasSuffix(base, ".img") {
+		return FileTypeNifti1Pair
+	}
+	return FileTypeNifti1
+}
+
+// PairFilenames returns the header and image filenames of a .hdr/.img pair
+// given either one of them. The suffix of a registered codec, such as
+// ".gz", is kept on both names.
+func PairFilenames(filename string) (hdr, img string) {
+	base, suffix := util.SplitCodecSuffix(filename)
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".hdr"), ".img")
+	return base + ".hdr" + suffix, base + ".img" + suffix
+}
+
+// ReadFile reads a NIfTI-1 image, including its extensions and data. It
+// accepts single files (.nii, .nii.gz) and header/image pairs (.hdr/.img,
+// optionally gzipped), given the name of either file of the pair.
+func ReadFile(filename string, opts ...ReadOption) (*Image, error) {
+	cfg := readConfig{profile: DefaultProfile}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	hdrName, imgName := filename, filename
+	if FileTypeFromName(filename) == FileTypeNifti1Pair {
+		hdrName, imgName = PairFilenames(filename)
+	}
+
+	if err := cfg.checkMemory(filename); err != nil {
+		return nil, err
+	}
+	hb, err := cfg.readBytes(hdrName)
+	if err != nil {
+		return nil, GzipError(hdrName, err)
+	}
+	db := hb
+	if hdrName != imgName {
+		db, err = cfg.readBytes(imgName)
+		if err != nil {
+			return nil, GzipError(imgName, err)
+		}
+	}
+	_, cfg.gzipped = splitGzipSuffix(imgName)
+	return decode(hb, db, hdrName, imgName, cfg)
+}
+
+// Read reads a single-file NIfTI-1 image (the content of a .nii or .nii.gz
+// file) from r, e.g. an HTTP response body. Header/image pairs cannot be
+// read from a single stream.
+func Read(r io.Reader, opts ...ReadOption) (*Image, error) {
+	cfg := readConfig{profile: DefaultProfile}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg.gzipped = len(b) > 1 && b[0] == 0x1f && b[1] == 0x8b
+	b, err = util.DecompressBytes(b)
+	if err != nil {
+		return nil, GzipError("<stream>", err)
+	}
+	return decode(b, b, "<stream>", "<stream>", cfg)
+}
+
+// decode parses an image from the header bytes hb and the image bytes db,
+// which are the same slice for single files. The names are used for errors
+// and recorded in the image.
+func decode(hb, db []byte, hdrName, imgName string, cfg readConfig) (*Image, error) {
+	if len(hb) < minHeaderSize {
+		return nil, fmt.Errorf("%s: %w: file is too short to hold a header (%d bytes)", hdrName, ErrTruncated, len(hb))
+	}
+
+	h, order, err := DecodeHeader(hb)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", hdrName, err)
+	}
+	if err := RepairPixDims(&h, cfg.profile.PixDim); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", hdrName, ErrInvalidHeader, err)
+	}
+	img := ConvertHeaderToImage(h, order)
+	img.FName, img.IName = hdrName, imgName
+
+	extEnd := len(hb)
+	if img.NiftiType == FileTypeNifti1 {
+		extEnd = img.INameOffset
+		switch {
+		case int(h.VoxOffset) < minHeaderSize:
+			log.WithFields(log.Fields{
+				"file":      hdrName,
+				"voxOffset": h.VoxOffset,
+			}).Warn("vox_offset is inside the header; reading data at byte 348")
+		case int(h.VoxOffset) < headerSize:
+			log.WithFields(log.Fields{
+				"file":      hdrName,
+				"voxOffset": h.VoxOffset,
+			}).Debug("File has no extender; reading data at vox_offset")
+		}
+	}
+	img.Extensions, err = ReadExtensions(hb, order, extEnd)
+	if err != nil {
+		if img.NiftiType != FileTypeNifti1 {
+			return nil, fmt.Errorf("%s: %w: %v", hdrName, ErrInvalidHeader, err)
+		}
+		// The data starts at vox_offset regardless, so an extension
+		// running past it is dropped rather than the whole file.
+		log.WithFields(log.Fields{
+			"file":      hdrName,
+			"voxOffset": img.INameOffset,
+			"kept":      len(img.Extensions),
+			"error":     err,
+		}).Warn("Extensions conflict with vox_offset; dropping the rest")
+	}
+	img.NumExt = len(img.Extensions)
+
+	want := img.INameOffset + img.NVox*img.NByPer
+	if len(db) < want {
+		if cfg.gzipped {
+			// The gzip CRC and size matched, so the data were already
+			// short when they were compressed.
+			return nil, fmt.Errorf("%s: %w: expected at least %d bytes but the intact gzip stream inflates to %d; the image was truncated before compression",
+				imgName, ErrTruncated, want, len(db))
+		}
+		return nil, fmt.Errorf("%s: %w: expected at least %d bytes but found %d", imgName, ErrTruncated, want, len(db))
+	}
+	img.SetData(db, h)
+
+	if extra := len(db) - want; extra > 0 {
+		img.TrailingData = db[want:]
+		log.WithFields(log.Fields{
+			"file":          imgName,
+			"trailingBytes": extra,
+		}).Warn("Found extra bytes after voxel data")
+	}
+
+	return img, nil
+}
+
+// WriteOption configures WriteFile.
+type WriteOption func(*writeConfig)
+
+type writeConfig struct {
+	keepTrailing   bool
+	level          int
+	analyze        bool
+	preserveUnused bool
+	extRules       []ExtensionRule
+	align          int
+	direct         bool
+	sink           func(filename string, b []byte) error
+}
+
+// KeepTrailingData writes the image's TrailingData after the voxel data, so
+// that content appended by other writers survives a rewrite.
+func KeepTrailingData() WriteOption {
+	return func(c *writeConfig) {
+		c.keepTrailing = true
+	}
+}
+
+// PreserveUnusedFields writes the image's Unused fields, which hold the
+// legacy Analyze fields as read, instead of the defaults, so that the header
+// bytes round-trip exactly.
+func PreserveUnusedFields() WriteOption {
+	return func(c *writeConfig) {
+		c.preserveUnused = true
+	}
+}
+
+// WithExtensions selects the extensions written by rules such as
+// Keep(ECodeDICOM) and Drop(ECodeAFNI). The image's Extensions are updated
+// to those written.
+func WithExtensions(rules ...ExtensionRule) WriteOption {
+	return func(c *writeConfig) {
+		c.extRules = append(c.extRules, rules...)
+	}
+}
+
+// AlignData pads single files with zeros after the extensions so that the
+// data start at a multiple of boundary bytes, e.g. 4096 for a page, which
+// lets readers map or read the data directly from disk. The boundary must
+// be a multiple of 16, as vox_offset should be; 0 means no padding. Pairs,
+// whose data start the .img file, are not padded.
+func AlignData(boundary int) WriteOption {
+	return func(c *writeConfig) {
+		c.align = boundary
+	}
+}
+
+// DirectWrite makes WriteFile write uncompressed files with
+// util.WriteDirect, which preallocates them and bypasses the page cache.
+// Combined with AlignData(4096), readers can then also read the data of
+// .nii files directly. Gzipped files are written as usual.
+func DirectWrite() WriteOption {
+	return func(c *writeConfig) {
+		c.direct = true
+	}
+}
+
+// WriteFunc makes WriteFile pass the bytes of each file it would write,
+// compressed if the name has the suffix of a codec such as ".gz", to fn
+// instead of writing them, as for a dry run.
+func WriteFunc(fn func(filename string, b []byte) error) WriteOption {
+	return func(c *writeConfig) {
+		c.sink = fn
+	}
+}
+
+// CompressionLevel sets the gzip level used for .gz outputs, from
+// gzip.HuffmanOnly to gzip.BestCompression. The default is
+// gzip.DefaultCompression.
+func CompressionLevel(level int) WriteOption {
+	return func(c *writeConfig) {
+		c.level = level
+	}
+}
+
+// WriteFile writes an image to filename. The layout is chosen from the
+// filename: .nii and .nii.gz produce a single file with magic 'n+1', while
+// .hdr and .img (optionally gzipped) produce a header/image pair with magic
+// 'ni1'. The image's NiftiType, FName, IName, and INameOffset are updated to
+// match what was written.
+// Refer to nifti_image_write in nifti1_io.c.
+func WriteFile(img *Image, filename string, opts ...WriteOption) error {
+	cfg := writeConfig{level: gzip.DefaultCompression}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.level < gzip.HuffmanOnly || cfg.level > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level %d", cfg.level)
+	}
+	if cfg.align < 0 || cfg.align%16 != 0 {
+		return fmt.Errorf("invalid data alignment %d: must be a multiple of 16", cfg.align)
+	}
+
+	if want := img.NVox * img.NByPer; len(img.Data) != want {
+		return fmt.Errorf("image data has %d bytes, expected %d", len(img.Data), want)
+	}
+
+	order := img.byteOrder()
+
+	img.Extensions = FilterExtensions(img.Extensions, cfg.extRules)
+	img.NiftiType = FileTypeFromName(filename)
+	img.NumExt = len(img.Extensions)
+	img.FName, img.IName = filename, filename
+	if img.NiftiType == FileTypeNifti1Pair {
+		img.FName, img.IName = PairFilenames(filename)
+		img.INameOffset = 0
+	} else {
+		// Extensions sit between the header and the data, so the offset
+		// follows them as they are added or removed.
+		img.INameOffset = img.MinVoxOffset()
+		if cfg.align > 0 {
+			img.INameOffset = (img.INameOffset + cfg.align - 1) / cfg.align * cfg.align
+		}
+	}
+
+	h := img.ToHeader()
+	exts := img.Extensions
+	if cfg.analyze {
+		if img.NiftiType != FileTypeNifti1Pair {
+			return fmt.Errorf("%s: Analyze 7.5 output must be a .hdr/.img pair", filename)
+		}
+		var lost []string
+		var err error
+		h, lost, err = img.toAnalyzeHeader()
+		if err != nil {
+			return err
+		}
+		warnAnalyzeLoss(img.FName, lost)
+		exts = nil
+	}
+	if cfg.preserveUnused {
+		img.Unused.apply(&h)
+	}
+	b, err := encodeHeader(h, exts, !cfg.analyze && img.NiftiType == FileTypeNifti1, order)
+	if err != nil {
+		return err
+	}
+	if img.NiftiType == FileTypeNifti1 && len(b) < img.INameOffset {
+		b = append(b, make([]byte, img.INameOffset-len(b))...)
+	}
+	if img.NiftiType == FileTypeNifti1 && len(b) != img.INameOffset {
+		return fmt.Errorf("%s: header and extensions take %d bytes, but vox_offset is %d", filename, len(b), img.INameOffset)
+	}
+
+	log.WithFields(log.Fields{
+		"header":    img.FName,
+		"image":     img.IName,
+		"voxOffset": img.INameOffset,
+		"numExt":    img.NumExt,
+	}).Debug("Writing image")
+
+	data := img.Data
+	if cfg.keepTrailing && len(img.TrailingData) > 0 {
+		data = make([]byte, 0, len(img.Data)+len(img.TrailingData))
+		data = append(append(data, img.Data...), img.TrailingData...)
+	}
+
+	// The data of a pair are written first, so that a write that is
+	// interrupted never leaves a new header with old or missing data.
+	if img.NiftiType == FileTypeNifti1Pair {
+		if err := cfg.write(img.IName, data); err != nil {
+			return err
+		}
+		return cfg.write(img.FName, b)
+	}
+
+	b = append(b, data...)
+	return cfg.write(img.FName, b)
+}
+
+// write writes a file, directly if DirectWrite is set and it is not
+// compressed, or passes it to the function set by WriteFunc.
+func (c *writeConfig) write(filename string, b []byte) error {
+	if c.sink != nil {
+		b, err := util.CompressBytes(filename, b, c.level)
+		if err != nil {
+			return err
+		}
+		return c.sink(filename, b)
+	}
+	if _, suffix := util.SplitCodecSuffix(filename); c.direct && suffix == "" {
+		return util.WriteDirect(filename, b)
+	}
+	return util.WriteBytesLevel(filename, b, c.level)
+}
+
+// encodeHeader returns the on-disk bytes of the header, extender, and
+// extensions. The extender is written if extender is set or there are
+// extensions, so pairs without extensions do not get one.
+func encodeHeader(h Header, exts []Extension, extender bool, order binary.ByteOrder) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, headerSize+ExtensionsSize(exts)))
+	if err := binary.Write(buf, order, &h); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+
+	if extender || len(exts) > 0 {
+		b = appendExtensions(b, exts, order)
+	}
+	return b, nil
+}
@@ -0,0 +1,155 @@
+package nifti1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// layoutFile returns a single-file image of two 2x2 int16 volumes whose data
+// start at voxOffset, with no extender if voxOffset is 348, and its values.
+func layoutFile(t *testing.T, voxOffset int) ([]byte, []float64) {
+	t.Helper()
+	h := Header{
+		SizeOfHdr: minHeaderSize,
+		Dim:       [8]int16{4, 2, 2, 1, 2, 1, 1, 1},
+		DataType:  DTInt16,
+		BitPix:    16,
+		PixDim:    [8]float32{1, 1, 1, 1, 1, 1, 1, 1},
+		VoxOffset: float32(voxOffset),
+		SclSlope:  1,
+	}
+	h.Magic = magicOneFile
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, h); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(make([]byte, voxOffset-buf.Len()))
+	values := []float64{1, -2, 300, -4000, 5, 6, -7, 8}
+	for _, v := range values {
+		binary.Write(&buf, binary.LittleEndian, int16(v))
+	}
+	return buf.Bytes(), values
+}
+
+func equalValues(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadLayouts(t *testing.T) {
+	dir := t.TempDir()
+	for _, voxOffset := range []int{minHeaderSize, headerSize} {
+		b, want := layoutFile(t, voxOffset)
+		name := filepath.Join(dir, "layout.nii")
+		if err := ioutil.WriteFile(name, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := ReadFile(name)
+		if err != nil {
+			t.Fatalf("vox_offset %d: ReadFile: %v", voxOffset, err)
+		}
+		if img.INameOffset != voxOffset {
+			t.Errorf("vox_offset %d: data offset %d", voxOffset, img.INameOffset)
+		}
+		if len(img.Extensions) != 0 {
+			t.Errorf("vox_offset %d: %d extensions, want none", voxOffset, len(img.Extensions))
+		}
+		if got, err := img.Float64s(); err != nil || !equalValues(got, want) {
+			t.Errorf("vox_offset %d: ReadFile values %v (%v), want %v", voxOffset, got, err, want)
+		}
+
+		img, err = Read(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("vox_offset %d: Read: %v", voxOffset, err)
+		}
+		if got, err := img.Float64s(); err != nil || !equalValues(got, want) {
+			t.Errorf("vox_offset %d: Read values %v (%v), want %v", voxOffset, got, err, want)
+		}
+	}
+}
+
+func TestOpenVolumesLayouts(t *testing.T) {
+	for _, voxOffset := range []int{minHeaderSize, headerSize} {
+		b, want := layoutFile(t, voxOffset)
+		fsys := fstest.MapFS{"layout.nii": &fstest.MapFile{Data: b}}
+		r, err := OpenVolumes("layout.nii", 0, FromFS(fsys))
+		if err != nil {
+			t.Fatalf("vox_offset %d: %v", voxOffset, err)
+		}
+		for vol := 0; vol < 2; vol++ {
+			got, err := r.Next()
+			if err != nil {
+				t.Fatalf("vox_offset %d, volume %d: %v", voxOffset, vol, err)
+			}
+			if !equalValues(got, want[4*vol:4*vol+4]) {
+				t.Errorf("vox_offset %d, volume %d: %v, want %v", voxOffset, vol, got, want[4*vol:4*vol+4])
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestReadVoxOffsetInsideHeader(t *testing.T) {
+	// A vox_offset inside the header is taken as 348.
+	b, want := layoutFile(t, minHeaderSize)
+	binary.LittleEndian.PutUint32(b[108:], 0)
+	img, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.INameOffset != minHeaderSize {
+		t.Errorf("data offset %d, want %d", img.INameOffset, minHeaderSize)
+	}
+	if got, err := img.Float64s(); err != nil || !equalValues(got, want) {
+		t.Errorf("values %v (%v), want %v", got, err, want)
+	}
+}
+
+func TestExtensionsPastVoxOffset(t *testing.T) {
+	// The second extension runs 16 bytes past vox_offset; it is dropped,
+	// and the data are read at vox_offset.
+	const voxOffset = headerSize + 32
+	b, want := layoutFile(t, voxOffset)
+	b[minHeaderSize] = 1
+	binary.LittleEndian.PutUint32(b[headerSize:], 16)
+	binary.LittleEndian.PutUint32(b[headerSize+4:], ECodeComment)
+	binary.LittleEndian.PutUint32(b[headerSize+16:], 32)
+	binary.LittleEndian.PutUint32(b[headerSize+20:], ECodeComment)
+
+	img, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(img.Extensions) != 1 {
+		t.Errorf("Read: %d extensions, want 1", len(img.Extensions))
+	}
+	if got, err := img.Float64s(); err != nil || !equalValues(got, want) {
+		t.Errorf("Read values %v (%v), want %v", got, err, want)
+	}
+
+	fsys := fstest.MapFS{"ext.nii": &fstest.MapFile{Data: b}}
+	r, err := OpenVolumes("ext.nii", 0, FromFS(fsys))
+	if err != nil {
+		t.Fatalf("OpenVolumes: %v", err)
+	}
+	defer r.Close()
+	if len(r.Image.Extensions) != 1 || r.Image.NumExt != 1 {
+		t.Errorf("OpenVolumes: %d extensions, want 1", len(r.Image.Extensions))
+	}
+	got, err := r.Next()
+	if err != nil || !equalValues(got, want[:4]) {
+		t.Errorf("OpenVolumes volume 0: %v (%v), want %v", got, err, want[:4])
+	}
+}
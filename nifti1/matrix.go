@@ -0,0 +1,113 @@
+package nifti1
+
+import "math"
+
+// Matrix helpers ported from nifti1_io.c.
+// https://github.com/afni/afni/blob/master/src/nifti/niftilib/nifti1_io.c
+
+// identityMat44 returns the 4x4 identity matrix.
+func identityMat44() mat44 {
+	var r mat44
+	for i := 0; i < 4; i++ {
+		r.m[i][i] = 1
+	}
+	return r
+}
+
+// diagMat44 returns a matrix that scales the (i,j,k) axes by dx, dy, and dz.
+func diagMat44(dx, dy, dz float64) mat44 {
+	r := identityMat44()
+	r.m[0][0] = float32(dx)
+	r.m[1][1] = float32(dy)
+	r.m[2][2] = float32(dz)
+	return r
+}
+
+// quaternToMat44 computes the qform matrix from the quaternion parameters.
+// Refer to nifti_quatern_to_mat44 in nifti1_io.c.
+func quaternToMat44(qb, qc, qd, qx, qy, qz, dx, dy, dz, qfac float64) mat44 {
+	var r mat44
+
+	b, c, d := qb, qc, qd
+	a := 1.0 - (b*b + c*c + d*d)
+	if a < 1.e-7 {
+		// Special case: a is numerically zero, so normalize (b,c,d).
+		a = 1.0 / math.Sqrt(b*b+c*c+d*d)
+		b *= a
+		c *= a
+		d *= a
+		a = 0
+	} else {
+		a = math.Sqrt(a)
+	}
+
+	xd, yd, zd := 1.0, 1.0, 1.0
+	if dx > 0 {
+		xd = dx
+	}
+	if dy > 0 {
+		yd = dy
+	}
+	if dz > 0 {
+		zd = dz
+	}
+	if qfac < 0 {
+		zd = -zd
+	}
+
+	r.m[0][0] = float32((a*a + b*b - c*c - d*d) * xd)
+	r.m[0][1] = float32(2.0 * (b*c - a*d) * yd)
+	r.m[0][2] = float32(2.0 * (b*d + a*c) * zd)
+	r.m[1][0] = float32(2.0 * (b*c + a*d) * xd)
+	r.m[1][1] = float32((a*a + c*c - b*b - d*d) * yd)
+	r.m[1][2] = float32(2.0 * (c*d - a*b) * zd)
+	r.m[2][0] = float32(2.0 * (b*d - a*c) * xd)
+	r.m[2][1] = float32(2.0 * (c*d + a*b) * yd)
+	r.m[2][2] = float32((a*a + d*d - c*c - b*b) * zd)
+
+	r.m[0][3] = float32(qx)
+	r.m[1][3] = float32(qy)
+	r.m[2][3] = float32(qz)
+
+	r.m[3][3] = 1
+	return r
+}
+
+// inverse returns the inverse of an affine matrix. The last row of the matrix
+// is assumed to be [0 0 0 1]. A singular matrix yields the zero matrix.
+// Refer to nifti_mat44_inverse in nifti1_io.c.
+func (m mat44) inverse() mat44 {
+	var r mat44
+
+	r11, r12, r13, v1 := float64(m.m[0][0]), float64(m.m[0][1]), float64(m.m[0][2]), float64(m.m[0][3])
+	r21, r22, r23, v2 := float64(m.m[1][0]), float64(m.m[1][1]), float64(m.m[1][2]), float64(m.m[1][3])
+	r31, r32, r33, v3 := float64(m.m[2][0]), float64(m.m[2][1]), float64(m.m[2][2]), float64(m.m[2][3])
+
+	deti := r11*r22*r33 - r11*r32*r23 - r21*r12*r33 +
+		r21*r32*r13 + r31*r12*r23 - r31*r22*r13
+	if deti == 0 {
+		return r
+	}
+	deti = 1.0 / deti
+
+	r.m[0][0] = float32(deti * (r22*r33 - r32*r23))
+	r.m[0][1] = float32(deti * (-r12*r33 + r32*r13))
+	r.m[0][2] = float32(deti * (r12*r23 - r22*r13))
+	r.m[0][3] = float32(deti * (-r12*r23*v3 + r12*v2*r33 + r22*r13*v3 -
+		r22*v1*r33 - r32*r13*v2 + r32*v1*r23))
+
+	r.m[1][0] = float32(deti * (-r21*r33 + r31*r23))
+	r.m[1][1] = float32(deti * (r11*r33 - r31*r13))
+	r.m[1][2] = float32(deti * (-r11*r23 + r21*r13))
+	r.m[1][3] = float32(deti * (r11*r23*v3 - r11*v2*r33 - r21*r13*v3 +
+		r21*v1*r33 + r31*r13*v2 - r31*v1*r23))
+
+	r.m[2][0] = float32(deti * (r21*r32 - r31*r22))
+	r.m[2][1] = float32(deti * (-r11*r32 + r31*r12))
+	r.m[2][2] = float32(deti * (r11*r22 - r21*r12))
+	r.m[2][3] = float32(deti * (-r11*r22*v3 + r11*r32*v2 + r21*r12*v3 -
+		r21*r32*v1 - r31*r12*v2 + r31*r22*v1))
+
+	r.m[3][3] = 1
+	return r
+}
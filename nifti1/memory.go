@@ -0,0 +1,123 @@
+package nifti1
+
+import (
+	"fmt"
+	"io"
+)
+
+// Memory is an estimate of the bytes of memory that reading an image and
+// common work on it take, from its header alone, so that schedulers and
+// commands can refuse or split the work before running out of memory.
+type Memory struct {
+	// Raw is the voxel data as stored.
+	Raw int64 `json:"raw"`
+	// Read is what ReadFile holds: the voxel data with the header and
+	// extensions of a single file. Gzipped files also hold the compressed
+	// bytes while they are inflated, unless InflateToDisk applies.
+	Read int64 `json:"read"`
+	// Float64 and Float32 are one copy of the values, as returned by
+	// ScaledFloat64s, or converted to float32.
+	Float64 int64 `json:"float64"`
+	Float32 int64 `json:"float32"`
+	// Volume is one volume of float64 values, as returned by
+	// VolumeReader.Next, and Mask a []bool over a volume.
+	Volume int64 `json:"volume"`
+	Mask   int64 `json:"mask"`
+	// Values is the peak of reading an image and converting it to
+	// float64 values, Read plus Float64, which most commands need.
+	Values int64 `json:"values"`
+}
+
+// EstimateMemory returns the memory that an image with a header takes.
+func EstimateMemory(h Header) (Memory, error) {
+	nbyper, _ := DatatypeSize(int(h.DataType))
+	if nbyper == 0 {
+		return Memory{}, fmt.Errorf("%w datatype %d", ErrUnsupported, h.DataType)
+	}
+	ndim := int(h.Dim[0])
+	if ndim < 1 || ndim > 7 {
+		return Memory{}, fmt.Errorf("%w: dim[0] is %d", ErrInvalidHeader, ndim)
+	}
+	nvox, nxyz := int64(1), int64(1)
+	for i := 1; i <= ndim; i++ {
+		d := int64(h.Dim[i])
+		if d < 1 {
+			d = 1
+		}
+		nvox *= d
+		if i <= 3 {
+			nxyz *= d
+		}
+	}
+	m := Memory{
+		Raw:     nvox * int64(nbyper),
+		Float64: 8 * nvox,
+		Float32: 4 * nvox,
+		Volume:  8 * nxyz,
+		Mask:    nxyz,
+	}
+	m.Read = m.Raw
+	if h.Magic == magicOneFile {
+		m.Read += int64(dataOffset(h, FileTypeNifti1))
+	}
+	m.Values = m.Read + m.Float64
+	return m, nil
+}
+
+// MemoryLimit makes ReadFile read the header first and refuse, with
+// ErrTooLarge, images whose Memory.Read exceeds limit bytes. A limit of 0
+// or less turns this off.
+func MemoryLimit(limit int64) ReadOption {
+	return func(c *readConfig) {
+		c.memoryLimit = limit
+	}
+}
+
+// ReadHeaderFile reads the header of an image without its data, inflating
+// no more of a gzipped file than the header. For pairs, given the name of
+// either file, it reads the .hdr file.
+func ReadHeaderFile(filename string, opts ...ReadOption) (Header, error) {
+	cfg := readConfig{profile: DefaultProfile}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	hdrName := filename
+	if FileTypeFromName(filename) == FileTypeNifti1Pair {
+		hdrName, _ = PairFilenames(filename)
+	}
+	var v VolumeReader
+	defer v.closeFiles()
+	r, err := v.open(hdrName, cfg.fsys)
+	if err != nil {
+		return Header{}, err
+	}
+	hb := make([]byte, minHeaderSize)
+	if n, err := io.ReadFull(r, hb); err != nil {
+		return Header{}, fmt.Errorf("%s: %w: file is too short to hold a header (%d bytes)", hdrName, ErrTruncated, n)
+	}
+	h, _, err := DecodeHeader(hb)
+	if err != nil {
+		return Header{}, fmt.Errorf("%s: %w", hdrName, err)
+	}
+	return h, nil
+}
+
+// checkMemory returns ErrTooLarge if reading the image named filename
+// would exceed the memory limit.
+func (c *readConfig) checkMemory(filename string) error {
+	if c.memoryLimit <= 0 {
+		return nil
+	}
+	h, err := ReadHeaderFile(filename, FromFS(c.fsys))
+	if err != nil {
+		return err
+	}
+	m, err := EstimateMemory(h)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if m.Read > c.memoryLimit {
+		return fmt.Errorf("%s: %w: reading it takes %d MB, over the limit of %d MB", filename, ErrTooLarge, m.Read>>20, c.memoryLimit>>20)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package nifti1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The header codes have symbolic names in nifti1.h, such as
+// NIFTI_TYPE_FLOAT32 for datatype 16. The *String functions return the
+// name of a code, or "" if it has none, and the Parse* functions look a
+// code up by its name, with or without the prefix and in any case, or by
+// its number: "NIFTI_TYPE_FLOAT32", "float32", and "16" all parse to 16.
+
+type codeName struct {
+	code int
+	name string
+}
+
+var dataTypeNames = []codeName{
+	{dtUnknown, "DT_UNKNOWN"},
+	{dtBinary, "DT_BINARY"},
+	{DTUint8, "NIFTI_TYPE_UINT8"},
+	{DTInt16, "NIFTI_TYPE_INT16"},
+	{DTInt32, "NIFTI_TYPE_INT32"},
+	{DTFloat32, "NIFTI_TYPE_FLOAT32"},
+	{DTComplex64, "NIFTI_TYPE_COMPLEX64"},
+	{DTFloat64, "NIFTI_TYPE_FLOAT64"},
+	{DTRGB24, "NIFTI_TYPE_RGB24"},
+	{DTInt8, "NIFTI_TYPE_INT8"},
+	{DTUint16, "NIFTI_TYPE_UINT16"},
+	{DTUint32, "NIFTI_TYPE_UINT32"},
+	{DTInt64, "NIFTI_TYPE_INT64"},
+	{DTUint64, "NIFTI_TYPE_UINT64"},
+	{DTFloat128, "NIFTI_TYPE_FLOAT128"},
+	{DTComplex128, "NIFTI_TYPE_COMPLEX128"},
+	{DTComplex256, "NIFTI_TYPE_COMPLEX256"},
+	{DTRGBA32, "NIFTI_TYPE_RGBA32"},
+}
+
+var intentNames = []codeName{
+	{IntentNone, "NIFTI_INTENT_NONE"},
+	{intentCorrel, "NIFTI_INTENT_CORREL"},
+	{intentTTest, "NIFTI_INTENT_TTEST"},
+	{intentFTest, "NIFTI_INTENT_FTEST"},
+	{intentZScore, "NIFTI_INTENT_ZSCORE"},
+	{intentChiSq, "NIFTI_INTENT_CHISQ"},
+	{intentBeta, "NIFTI_INTENT_BETA"},
+	{intentBinom, "NIFTI_INTENT_BINOM"},
+	{intentGamma, "NIFTI_INTENT_GAMMA"},
+	{intentPoisson, "NIFTI_INTENT_POISSON"},
+	{intentNormal, "NIFTI_INTENT_NORMAL"},
+	{intentFTestNonc, "NIFTI_INTENT_FTEST_NONC"},
+	{intentChiSqNonc, "NIFTI_INTENT_CHISQ_NONC"},
+	{intentLogistic, "NIFTI_INTENT_LOGISTIC"},
+	{intentLaplace, "NIFTI_INTENT_LAPLACE"},
+	{intentUniform, "NIFTI_INTENT_UNIFORM"},
+	{intentTTestNonc, "NIFTI_INTENT_TTEST_NONC"},
+	{intentWeibull, "NIFTI_INTENT_WEIBULL"},
+	{intentChi, "NIFTI_INTENT_CHI"},
+	{intentInvGauss, "NIFTI_INTENT_INVGAUSS"},
+	{intentExtVal, "NIFTI_INTENT_EXTVAL"},
+	{intentPVal, "NIFTI_INTENT_PVAL"},
+	{intentLogPVal, "NIFTI_INTENT_LOGPVAL"},
+	{intentLog10PVal, "NIFTI_INTENT_LOG10PVAL"},
+	{IntentEstimate, "NIFTI_INTENT_ESTIMATE"},
+	{IntentLabel, "NIFTI_INTENT_LABEL"},
+	{intentNeuroName, "NIFTI_INTENT_NEURONAME"},
+	{intentGenMatrix, "NIFTI_INTENT_GENMATRIX"},
+	{intentSymMatrix, "NIFTI_INTENT_SYMMATRIX"},
+	{intentDispVect, "NIFTI_INTENT_DISPVECT"},
+	{intentVector, "NIFTI_INTENT_VECTOR"},
+	{intentPointSet, "NIFTI_INTENT_POINTSET"},
+	{intentTriangle, "NIFTI_INTENT_TRIANGLE"},
+	{intentQuaternion, "NIFTI_INTENT_QUATERNION"},
+	{intentDimless, "NIFTI_INTENT_DIMLESS"},
+	{intentTimeSeries, "NIFTI_INTENT_TIME_SERIES"},
+	{intentNodeIndex, "NIFTI_INTENT_NODE_INDEX"},
+	{intentRGBVector, "NIFTI_INTENT_RGB_VECTOR"},
+	{intentRGBAVector, "NIFTI_INTENT_RGBA_VECTOR"},
+	{intentShape, "NIFTI_INTENT_SHAPE"},
+}
+
+var unitsNames = []codeName{
+	{UnitsUnknown, "NIFTI_UNITS_UNKNOWN"},
+	{UnitsMeter, "NIFTI_UNITS_METER"},
+	{UnitsMM, "NIFTI_UNITS_MM"},
+	{UnitsMicron, "NIFTI_UNITS_MICRON"},
+	{UnitsSec, "NIFTI_UNITS_SEC"},
+	{UnitsMsec, "NIFTI_UNITS_MSEC"},
+	{UnitsUsec, "NIFTI_UNITS_USEC"},
+	{unitsHz, "NIFTI_UNITS_HZ"},
+	{unitsPPM, "NIFTI_UNITS_PPM"},
+	{unitsRads, "NIFTI_UNITS_RADS"},
+}
+
+var sliceNames = []codeName{
+	{SliceUnknown, "NIFTI_SLICE_UNKNOWN"},
+	{SliceSeqInc, "NIFTI_SLICE_SEQ_INC"},
+	{SliceSeqDec, "NIFTI_SLICE_SEQ_DEC"},
+	{SliceAltInc, "NIFTI_SLICE_ALT_INC"},
+	{SliceAltDec, "NIFTI_SLICE_ALT_DEC"},
+	{SliceAltInc2, "NIFTI_SLICE_ALT_INC2"},
+	{SliceAltDec2, "NIFTI_SLICE_ALT_DEC2"},
+}
+
+var xformNames = []codeName{
+	{XformUnknown, "NIFTI_XFORM_UNKNOWN"},
+	{XformScannerAnat, "NIFTI_XFORM_SCANNER_ANAT"},
+	{XformAlignedAnat, "NIFTI_XFORM_ALIGNED_ANAT"},
+	{XformTalairach, "NIFTI_XFORM_TALAIRACH"},
+	{XformMNI152, "NIFTI_XFORM_MNI_152"},
+}
+
+func nameOf(names []codeName, code int) string {
+	for _, n := range names {
+		if n.code == code {
+			return n.name
+		}
+	}
+	return ""
+}
+
+// parseCode looks up s in names, comparing the names without the prefixes
+// of the kind.
+func parseCode(names []codeName, kind string, prefixes []string, s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	short := func(name string) string {
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p) {
+				return name[len(p):]
+			}
+		}
+		return name
+	}
+	want := short(strings.ToUpper(s))
+	for _, n := range names {
+		if short(n.name) == want {
+			return n.code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown %s %q", kind, s)
+}
+
+// DataTypeString returns the name of a datatype code, e.g.
+// "NIFTI_TYPE_FLOAT32".
+func DataTypeString(code int) string {
+	return nameOf(dataTypeNames, code)
+}
+
+// ParseDataType returns the datatype code named s, e.g. "FLOAT32". The
+// DT_* aliases of nifti1.h, such as "DT_FLOAT32", are accepted too.
+func ParseDataType(s string) (int, error) {
+	return parseCode(dataTypeNames, "datatype", []string{"NIFTI_TYPE_", "DT_"}, s)
+}
+
+// IntentString returns the name of an intent code, e.g.
+// "NIFTI_INTENT_ZSCORE".
+func IntentString(code int) string {
+	return nameOf(intentNames, code)
+}
+
+// ParseIntent returns the intent code named s, e.g. "ZSCORE".
+func ParseIntent(s string) (int, error) {
+	return parseCode(intentNames, "intent", []string{"NIFTI_INTENT_"}, s)
+}
+
+// UnitsString returns the name of a units code, e.g. "NIFTI_UNITS_MM".
+func UnitsString(code int) string {
+	return nameOf(unitsNames, code)
+}
+
+// ParseUnits returns the units code named s, e.g. "MM".
+func ParseUnits(s string) (int, error) {
+	return parseCode(unitsNames, "units", []string{"NIFTI_UNITS_"}, s)
+}
+
+// SliceCodeString returns the name of a slice order code, e.g.
+// "NIFTI_SLICE_SEQ_INC".
+func SliceCodeString(code int) string {
+	return nameOf(sliceNames, code)
+}
+
+// ParseSliceCode returns the slice order code named s, e.g. "ALT_INC".
+func ParseSliceCode(s string) (int, error) {
+	return parseCode(sliceNames, "slice code", []string{"NIFTI_SLICE_"}, s)
+}
+
+// XformString returns the name of a transform code, e.g.
+// "NIFTI_XFORM_SCANNER_ANAT".
+func XformString(code int) string {
+	return nameOf(xformNames, code)
+}
+
+// ParseXform returns the transform code named s, e.g. "MNI_152".
+func ParseXform(s string) (int, error) {
+	return parseCode(xformNames, "xform code", []string{"NIFTI_XFORM_"}, s)
+}
@@ -0,0 +1,254 @@
+package nifti1
+
+import "fmt"
+
+// Order is the order of an array of voxels.
+//
+// NIfTI stores voxels in Fortran (column-major) order: the first index, x,
+// varies fastest, so voxel (i, j, k) of an nx×ny×nz volume is value
+// i + nx*(j + ny*k) of the flat data, and volume t of a 4D image starts at
+// t*nx*ny*nz. Indexing the flat data as if z varied fastest, as in C, is the
+// most common bug of new users, so accessors take the order explicitly.
+//
+// For flat arrays, as of Strides and Float32TensorOrder, Order is the
+// memory order. For the nested slices of Float32Nested3 and Float32Nested4,
+// whose innermost slices are always contiguous, it is the order of the
+// indices.
+type Order int
+
+const (
+	// FortranOrder indexes nested slices in the order of the NIfTI dims,
+	// v[i][j][k] for voxel (x, y, z), like the arrays of nibabel's
+	// get_fdata. The innermost slices run along z.
+	FortranOrder Order = iota
+	// COrder indexes nested slices in reverse, v[k][j][i] for voxel
+	// (x, y, z), like a C-order NumPy array of shape (nz, ny, nx). The
+	// innermost slices run along x and keep the order of the file.
+	COrder
+)
+
+func (o Order) String() string {
+	switch o {
+	case FortranOrder:
+		return "F"
+	case COrder:
+		return "C"
+	}
+	return fmt.Sprintf("Order(%d)", int(o))
+}
+
+// nestedDims returns the first n dims of the image, checking that the
+// others are 1.
+func (img *Image) nestedDims(n int) ([]int, error) {
+	dims := make([]int, n)
+	size := 1
+	for d := 1; d <= n; d++ {
+		dims[d-1] = img.Dim[d]
+		size *= img.Dim[d]
+	}
+	if size != img.NVox {
+		return nil, fmt.Errorf("image of %d dims (%v) does not fit %d nested slices", img.NDim, img.Dim[1:img.NDim+1], n)
+	}
+	return dims, nil
+}
+
+// float32Values returns the voxel values with scl_slope and scl_inter
+// applied, as float32 in file order.
+func (img *Image) float32Values() ([]float32, error) {
+	at, err := img.Float64Func()
+	if err != nil {
+		return nil, err
+	}
+	slope, inter, scaled := img.Scaling()
+	out := make([]float32, img.NVox)
+	for i := range out {
+		v := at(i)
+		if scaled {
+			v = slope*v + inter
+		}
+		out[i] = float32(v)
+	}
+	return out, nil
+}
+
+// Float32Nested3 returns the values of a 3D image, with scl_slope and
+// scl_inter applied, as nested slices indexed in order: v[i][j][k] in
+// FortranOrder or v[k][j][i] in COrder for voxel (i, j, k). The slices
+// share one backing array.
+func (img *Image) Float32Nested3(order Order) ([][][]float32, error) {
+	dims, err := img.nestedDims(3)
+	if err != nil {
+		return nil, err
+	}
+	flat, err := img.float32Values()
+	if err != nil {
+		return nil, err
+	}
+	nx, ny, nz := dims[0], dims[1], dims[2]
+	if order == COrder {
+		return nest3(flat, nz, ny, nx), nil
+	}
+	back := make([]float32, len(flat))
+	permute(back, flat, dims, Strides(dims, FortranOrder))
+	return nest3(back, nx, ny, nz), nil
+}
+
+// Float32Nested4 is like Float32Nested3 for 4D images: v[i][j][k][t] in
+// FortranOrder or v[t][k][j][i] in COrder, where v[t] is volume t.
+func (img *Image) Float32Nested4(order Order) ([][][][]float32, error) {
+	dims, err := img.nestedDims(4)
+	if err != nil {
+		return nil, err
+	}
+	flat, err := img.float32Values()
+	if err != nil {
+		return nil, err
+	}
+	nx, ny, nz, nt := dims[0], dims[1], dims[2], dims[3]
+	if order == COrder {
+		out := make([][][][]float32, nt)
+		nxyz := nx * ny * nz
+		for t := range out {
+			out[t] = nest3(flat[t*nxyz:(t+1)*nxyz], nz, ny, nx)
+		}
+		return out, nil
+	}
+	back := make([]float32, len(flat))
+	permute(back, flat, dims, Strides(dims, FortranOrder))
+	out := make([][][][]float32, nx)
+	for i := range out {
+		out[i] = nest3(back[i*ny*nz*nt:(i+1)*ny*nz*nt], ny, nz, nt)
+	}
+	return out, nil
+}
+
+// nest3 slices flat into a×b×c nested slices.
+func nest3(flat []float32, a, b, c int) [][][]float32 {
+	out := make([][][]float32, a)
+	rows := make([][]float32, a*b)
+	for x := range out {
+		out[x] = rows[x*b : (x+1)*b : (x+1)*b]
+		for y := range out[x] {
+			off := (x*b + y) * c
+			out[x][y] = flat[off : off+c : off+c]
+		}
+	}
+	return out
+}
+
+// shape3 returns the lengths of nested slices, checking that they are
+// rectangular.
+func shape3(v [][][]float32) ([3]int, error) {
+	var s [3]int
+	s[0] = len(v)
+	if s[0] == 0 {
+		return s, fmt.Errorf("nested slices are empty")
+	}
+	s[1] = len(v[0])
+	if s[1] > 0 {
+		s[2] = len(v[0][0])
+	}
+	for a := range v {
+		if len(v[a]) != s[1] {
+			return s, fmt.Errorf("v[%d] has length %d, but v[0] has %d", a, len(v[a]), s[1])
+		}
+		for b := range v[a] {
+			if len(v[a][b]) != s[2] {
+				return s, fmt.Errorf("v[%d][%d] has length %d, but v[0][0] has %d", a, b, len(v[a][b]), s[2])
+			}
+		}
+	}
+	if s[1] == 0 || s[2] == 0 {
+		return s, fmt.Errorf("nested slices are empty")
+	}
+	return s, nil
+}
+
+// checkNested checks that the shape of nested slices, with indices in
+// order, matches the first dims of the image.
+func (img *Image) checkNested(shape []int, order Order) error {
+	dims, err := img.nestedDims(len(shape))
+	if err != nil {
+		return err
+	}
+	want := append([]int(nil), dims...)
+	if order == COrder {
+		for d := range dims {
+			want[d] = dims[len(dims)-1-d]
+		}
+	}
+	for d := range want {
+		if shape[d] != want[d] {
+			return fmt.Errorf("%w: nested slices in %v order have shape %v, but the image needs %v", ErrGridMismatch, order, shape, want)
+		}
+	}
+	return nil
+}
+
+// SetFloat32Nested3 replaces the data of a 3D image with the values of
+// nested slices indexed in order, as returned by Float32Nested3, stored as
+// DT_FLOAT32 like SetFloat32Data. The slices must be rectangular and match
+// the dims of the image.
+func (img *Image) SetFloat32Nested3(v [][][]float32, order Order) error {
+	s, err := shape3(v)
+	if err != nil {
+		return err
+	}
+	if err := img.checkNested(s[:], order); err != nil {
+		return err
+	}
+	nx, ny := img.Nx, img.Ny
+	values := make([]float64, img.NVox)
+	for a := range v {
+		for b := range v[a] {
+			for c, x := range v[a][b] {
+				i, j, k := a, b, c
+				if order == COrder {
+					i, k = c, a
+				}
+				values[i+nx*(j+ny*k)] = float64(x)
+			}
+		}
+	}
+	return img.SetFloat32Data(values)
+}
+
+// SetFloat32Nested4 is like SetFloat32Nested3 for 4D images, with the
+// slices of Float32Nested4.
+func (img *Image) SetFloat32Nested4(v [][][][]float32, order Order) error {
+	if len(v) == 0 {
+		return fmt.Errorf("nested slices are empty")
+	}
+	s, err := shape3(v[0])
+	if err != nil {
+		return fmt.Errorf("v[0]: %v", err)
+	}
+	for a := range v {
+		sa, err := shape3(v[a])
+		if err != nil {
+			return fmt.Errorf("v[%d]: %v", a, err)
+		}
+		if sa != s {
+			return fmt.Errorf("v[%d] has shape %v, but v[0] has %v", a, sa, s)
+		}
+	}
+	if err := img.checkNested([]int{len(v), s[0], s[1], s[2]}, order); err != nil {
+		return err
+	}
+	nx, ny, nz := img.Nx, img.Ny, img.Nz
+	values := make([]float64, img.NVox)
+	for a := range v {
+		for b := range v[a] {
+			for c := range v[a][b] {
+				for d, x := range v[a][b][c] {
+					i, j, k, t := a, b, c, d
+					if order == COrder {
+						i, j, k, t = d, c, b, a
+					}
+					values[i+nx*(j+ny*(k+nz*t))] = float64(x)
+				}
+			}
+		}
+	}
+	return img.SetFloat32Data(values)
+}
@@ -0,0 +1,60 @@
+package nifti1
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// NewImage returns a single-file image of zeros with the given datatype,
+// dims (x, y, z, t, ...), and voxel-to-world affine in mm. The sform is set
+// to the affine and the qform to its closest rigid transform, both with code
+// xform (a NIFTI_XFORM_* code); the spatial pixdims are taken from the
+// affine and the others are 1. Importers of other formats use it to build
+// images.
+func NewImage(datatype int, dims []int, affine [4][4]float64, xform int) (*Image, error) {
+	nbyper, _ := DatatypeSize(datatype)
+	if nbyper == 0 {
+		return nil, fmt.Errorf("%w datatype %d", ErrUnsupported, datatype)
+	}
+	if len(dims) < 1 || len(dims) > 7 {
+		return nil, fmt.Errorf("number of dimensions must be in [1, 7], got %d", len(dims))
+	}
+
+	h := Header{
+		SizeOfHdr: minHeaderSize,
+		DataType:  int16(datatype),
+		BitPix:    int16(8 * nbyper),
+		VoxOffset: headerSize,
+		XYZTUnits: SpaceTimeToXYZT(UnitsMM, UnitsSec),
+		QFormCode: int16(xform),
+		SFormCode: int16(xform),
+		Magic:     magicOneFile,
+	}
+	h.Dim[0] = int16(len(dims))
+	for i := 1; i <= 7; i++ {
+		h.Dim[i] = 1
+		h.PixDim[i] = 1
+	}
+	for i, d := range dims {
+		if d < 1 || d > 32767 {
+			return nil, fmt.Errorf("dimension %d must be in [1, 32767], got %d", i+1, d)
+		}
+		h.Dim[i+1] = int16(d)
+	}
+	h.PixDim[0] = 1
+	for j := 0; j < 4; j++ {
+		h.SRowX[j] = float32(affine[0][j])
+		h.SRowY[j] = float32(affine[1][j])
+		h.SRowZ[j] = float32(affine[2][j])
+	}
+
+	img := ConvertHeaderToImage(h, binary.LittleEndian)
+	if xform > 0 {
+		if err := img.SetQFormFromSForm(); err != nil {
+			return nil, err
+		}
+	}
+	img.Data = make([]byte, img.NVox*img.NByPer)
+	img.INameOffset = headerSize
+	return img, nil
+}
@@ -5,10 +5,6 @@
 
 package nifti1
 
-// We use the official nifti1 header to access certain variables (e.g., datatype codes).
-
-// #include "nifti1.h"
-import "C"
 import (
 	"bytes"
 	"encoding/binary"
@@ -92,12 +88,44 @@ func (h Header) String() string {
 		f := s.Field(i)
 		strs[i] = fmt.Sprintf("%d: %s %s = %v", i,
 			typeOfT.Field(i).Name, f.Type(), f.Interface())
+		if name := fieldCodeName(typeOfT.Field(i).Name, h); name != "" {
+			strs[i] += " (" + name + ")"
+		}
 	}
 	return strings.Join(strs[:], "\n")
 }
 
+// fieldCodeName returns the symbolic names of the value of a code field of h.
+func fieldCodeName(field string, h Header) string {
+	switch field {
+	case "IntentCode":
+		return IntentString(int(h.IntentCode))
+	case "DataType":
+		return DataTypeString(int(h.DataType))
+	case "SliceCode":
+		return SliceCodeString(int(h.SliceCode))
+	case "XYZTUnits":
+		space, time := UnitsString(XYZTToSpace(h.XYZTUnits)), UnitsString(XYZTToTime(h.XYZTUnits))
+		if space == "" || time == "" {
+			return ""
+		}
+		return space + "|" + time
+	case "QFormCode":
+		return XformString(int(h.QFormCode))
+	case "SFormCode":
+		return XformString(int(h.SFormCode))
+	}
+	return ""
+}
+
 const headerSize = 352
 const minHeaderSize = 348
+const nifti2HeaderSize = 540
+
+var (
+	magicOneFile = [4]int8{'n', '+', '1', 0}
+	magicTwoFile = [4]int8{'n', 'i', '1', 0}
+)
 
 type mat44 struct {
 	m [4][4]float32
@@ -172,94 +200,226 @@ type Image struct {
 	IntentP1   float64 // intent parameters
 	IntentP2   float64 // intent parameters
 	IntentP3   float64 // intent parameters
-	IntentName [16]int // optional description of intent data
+	IntentName string  // optional description of intent data
 
-	Descrip [80]int // optional text to describe dataset
-	AuxFile [24]int // auxiliary filename
+	Descrip string // optional text to describe dataset
+	AuxFile string // auxiliary filename
 
-	FName       *int             // header filename
-	IName       *int             // image filename
+	FName       string           // header filename
+	IName       string           // image filename
 	INameOffset int              // offset into IName where data start
 	SwapSize    int              // swap unit in image data (might be 0)
 	ByteOrder   binary.ByteOrder // byte order on disk (MSB_ or LSB_FIRST)
 
 	Data []byte // slice of data: nbyper*nvox bytes
 
-	NumExt int // number of extensions in extList
+	// Bytes found after the voxel data on read. These are only written back
+	// with the KeepTrailingData write option.
+	TrailingData []byte
+
+	NumExt     int         // number of extensions in Extensions
+	Extensions []Extension // array of extension structs (with data)
+
+	// Legacy Analyze fields as read. These are only written back with the
+	// PreserveUnusedFields write option.
+	Unused UnusedFields
 
-	// TODO(kaczmarj): Add extensions list struct
 	// ommitting analyze75_orient
 }
 
+// UnusedFields are the Analyze 7.5 fields that NIfTI-1 leaves unused.
+type UnusedFields struct {
+	DataType     [10]int8
+	DbName       [18]int8
+	Extents      int32
+	SessionError int16
+	Regular      int8
+	Glmax        int32
+	Glmin        int32
+}
+
+// unusedFields returns the unused fields of a header.
+func unusedFields(h Header) UnusedFields {
+	return UnusedFields{
+		DataType:     h.UnusedDataType,
+		DbName:       h.UnusedDbName,
+		Extents:      h.UnusedExtents,
+		SessionError: h.UnusedSessionError,
+		Regular:      h.UnusedRegular,
+		Glmax:        h.UnusedGlmax,
+		Glmin:        h.UnusedGlmin,
+	}
+}
+
+// apply copies the fields into a header.
+func (u UnusedFields) apply(h *Header) {
+	h.UnusedDataType = u.DataType
+	h.UnusedDbName = u.DbName
+	h.UnusedExtents = u.Extents
+	h.UnusedSessionError = u.SessionError
+	h.UnusedRegular = u.Regular
+	h.UnusedGlmax = u.Glmax
+	h.UnusedGlmin = u.Glmin
+}
+
+// File types, stored in Image.NiftiType.
+const (
+	FileTypeAnalyze    = 0 // Analyze 7.5 header/image pair
+	FileTypeNifti1     = 1 // NIFTI-1 single file (.nii)
+	FileTypeNifti1Pair = 2 // NIFTI-1 header/image pair (.hdr/.img)
+	FileTypeASCII      = 3 // NIFTI-ASCII (not supported)
+)
+
+// byteOrder returns the byte order of the image, defaulting to little endian.
+func (img *Image) byteOrder() binary.ByteOrder {
+	if img.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return img.ByteOrder
+}
+
 func check(e error) {
 	if e != nil {
 		panic(e)
 	}
 }
 
-// ReadHeader reads a header and returns the byteorder of the file.
+// ReadHeader reads a header and returns the byteorder of the file. It exits
+// the program if the header is invalid; use DecodeHeader to get an error
+// instead.
 // Refer to this link for C implementation
 // https://github.com/afni/afni/blob/master/src/nifti/niftilib/nifti1_io.c#L3948-L4042
 func ReadHeader(b []byte) (Header, binary.ByteOrder) {
+	h, order, err := DecodeHeader(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return h, order
+}
+
+// DecodeHeader reads a header and returns the byteorder of the file. Errors
+// wrap ErrTruncated if b is too short and ErrInvalidHeader if the header
+// fails validation.
+func DecodeHeader(b []byte) (Header, binary.ByteOrder, error) {
 
 	log.Debug("Reading header ...")
 	h := Header{}
 	var order binary.ByteOrder = binary.LittleEndian
 
+	// A NIfTI-2 header starts with its size, 540, in either byte order.
+	if len(b) >= 4 && (binary.LittleEndian.Uint32(b) == nifti2HeaderSize || binary.BigEndian.Uint32(b) == nifti2HeaderSize) {
+		return h, order, fmt.Errorf("%w NIfTI-2 header", ErrUnsupported)
+	}
+
 	buf := bytes.NewReader(b)
-	err := binary.Read(buf, order, &h)
-	check(err)
+	if err := binary.Read(buf, order, &h); err != nil {
+		return h, order, fmt.Errorf("%w: %v", ErrTruncated, err)
+	}
 
-	if (h.Dim[0] <= 0) && (h.Dim[0] > 7) {
+	// dim[0] is in [1, 7] when read in the byte order of the file.
+	if h.Dim[0] <= 0 || h.Dim[0] > 7 {
 		h = Header{}
 		order = binary.BigEndian
-		err = binary.Read(buf, order, &h)
-		check(err)
+		if err := binary.Read(bytes.NewReader(b), order, &h); err != nil {
+			return h, order, fmt.Errorf("%w: %v", ErrTruncated, err)
+		}
 	}
 
-	if (h.Dim[0] <= 0) && (h.Dim[0] > 7) {
-		panic("Cannot infer byte order of file based on Dim[0]: not in range [1, 7]")
+	if h.Dim[0] <= 0 || h.Dim[0] > 7 {
+		return h, order, fmt.Errorf("%w: cannot infer byte order from dim[0] = %d, not in range [1, 7]",
+			ErrInvalidHeader, h.Dim[0])
 	}
 
-	validateHeader(h)
+	if err := validateHeader(h); err != nil {
+		return h, order, err
+	}
 
 	log.WithFields(log.Fields{
 		"byteOrder": order,
 	}).Debug("Found byte order")
 
-	return h, order
+	return h, order, nil
 }
 
 // Check https://github.com/afni/afni/blob/master/src/nifti/niftilib/nifti1_io.c#L4045-L4104
-func validateHeader(h Header) {
+func validateHeader(h Header) error {
 	switch {
 
 	case h.SizeOfHdr != minHeaderSize:
-		log.WithFields(log.Fields{
-			"cause":       "invalid header size",
-			"headerSize":  h.SizeOfHdr,
-			"headerValid": false,
-		}).Fatal("Invalid header size for nifti1")
-
-	// Assert that file magic is 'n+1', meaning that the header and data are in
-	// the same file.
-	case h.Magic != [4]int8{110, 43, 49, 0}:
-		log.WithFields(log.Fields{
-			"cause":       "invalid file magic",
-			"headerValid": false,
-		}).Fatal("Invalid file magic. Data must be stored in same file as header")
-
-	case h.DataType == C.DT_BINARY || h.DataType == C.DT_UNKNOWN:
-		log.WithFields(log.Fields{
-			"cause":       "bad datatype",
-			"headerValid": false,
-			"dataType":    h.DataType,
-		}).Fatal("Data type is invalid")
+		return fmt.Errorf("%w: header size is %d, must be %d", ErrInvalidHeader, h.SizeOfHdr, minHeaderSize)
+
+	// Assert that file magic, at byte 344, is 'n+1' (header and data in the
+	// same file) or 'ni1' (header and data in a .hdr/.img pair).
+	case h.Magic == [4]int8{}:
+		return fmt.Errorf("%w: no NIfTI magic at byte 344; Analyze 7.5 headers are not supported", ErrInvalidHeader)
+	case h.Magic != magicOneFile && h.Magic != magicTwoFile:
+		return fmt.Errorf("%w: file magic at byte 344 is %q, must be 'n+1' or 'ni1'", ErrInvalidHeader, magicString(h.Magic))
+
+	case h.DataType == dtBinary || h.DataType == dtUnknown:
+		return fmt.Errorf("%w: datatype %d is invalid", ErrInvalidHeader, h.DataType)
 	}
 
 	log.WithFields(log.Fields{
 		"headerValid": true,
 	}).Debug("Header is valid")
+	return nil
+}
+
+// DimInfoToFreqDim returns the frequency encoding direction (1, 2, 3, or 0
+// if unknown) packed into the dim_info byte.
+func DimInfoToFreqDim(di int8) int {
+	return int(di) & 0x03
+}
+
+// DimInfoToPhaseDim returns the phase encoding direction (1, 2, 3, or 0 if
+// unknown) packed into the dim_info byte.
+func DimInfoToPhaseDim(di int8) int {
+	return (int(di) >> 2) & 0x03
+}
+
+// DimInfoToSliceDim returns the slice direction (1, 2, 3, or 0 if unknown)
+// packed into the dim_info byte.
+func DimInfoToSliceDim(di int8) int {
+	return (int(di) >> 4) & 0x03
+}
+
+// DimInfoToFPS unpacks the frequency, phase, and slice directions from the
+// dim_info byte.
+func DimInfoToFPS(di int8) (freq, phase, slice int) {
+	return DimInfoToFreqDim(di), DimInfoToPhaseDim(di), DimInfoToSliceDim(di)
+}
+
+// FPSIntoDimInfo packs the frequency, phase, and slice directions into a
+// dim_info byte. Each direction must be in the range [0, 3]; higher bits are
+// discarded. This is the equivalent of the FPS_INTO_DIM_INFO macro.
+func FPSIntoDimInfo(freq, phase, slice int) int8 {
+	return int8((freq & 0x03) | ((phase & 0x03) << 2) | ((slice & 0x03) << 4))
+}
+
+// dataOffset returns the offset of the voxel data from vox_offset. Data in
+// a .img file may start at 0. Data in a single file start at vox_offset,
+// which is usually 352 or more, past the 4-byte extender; some writers
+// leave out the extender and set it to 348, so the data are read there, as
+// nifti_image_read does, rather than at 352. Offsets inside the header are
+// taken as 348.
+func dataOffset(h Header, niftiType int) int {
+	// vox_offset is a float, but always holds an integer.
+	offset := int(h.VoxOffset)
+	if niftiType == FileTypeNifti1 && offset < minHeaderSize {
+		offset = minHeaderSize
+	}
+	return offset
+}
+
+// magicString returns the printable bytes of a magic field.
+func magicString(m [4]int8) string {
+	var b []byte
+	for _, c := range m {
+		if c != 0 {
+			b = append(b, byte(c))
+		}
+	}
+	return string(b)
 }
 
 // ConvertHeaderToImage converts a header to an image.
@@ -269,6 +429,22 @@ func ConvertHeaderToImage(h Header, order binary.ByteOrder) *Image {
 
 	img := new(Image)
 
+	switch h.Magic {
+	case magicOneFile:
+		img.NiftiType = FileTypeNifti1
+	case magicTwoFile:
+		img.NiftiType = FileTypeNifti1Pair
+	default:
+		img.NiftiType = FileTypeAnalyze
+	}
+
+	// Unused dimensions are set to 1.
+	for i := int(h.Dim[0]) + 1; i <= 7; i++ {
+		h.Dim[i] = 1
+	}
+
+	img.Unused = unusedFields(h)
+
 	img.NDim = int(h.Dim[0])
 	img.Nx = int(h.Dim[1])
 	img.Ny = int(h.Dim[2])
@@ -279,13 +455,253 @@ func ConvertHeaderToImage(h Header, order binary.ByteOrder) *Image {
 	img.Nw = int(h.Dim[7])
 	img.ByteOrder = order
 
+	img.NVox = 1
 	for i := range img.Dim {
 		img.Dim[i] = int(h.Dim[i])
+		if i > 0 {
+			img.NVox *= img.Dim[i]
+		}
+	}
+
+	img.DataType = int(h.DataType)
+	img.NByPer, img.SwapSize = DatatypeSize(img.DataType)
+
+	for i := range img.PixDim {
+		img.PixDim[i] = float64(h.PixDim[i])
+	}
+	img.Dx = img.PixDim[1]
+	img.Dy = img.PixDim[2]
+	img.Dz = img.PixDim[3]
+	img.Dt = img.PixDim[4]
+	img.Du = img.PixDim[5]
+	img.Dv = img.PixDim[6]
+	img.Dw = img.PixDim[7]
+
+	// Compute qto_xyz transformation from pixel indexes (i,j,k) to (x,y,z).
+	if h.QFormCode <= 0 && h.SFormCode <= 0 {
+		// Analyze-style: scale the axes only.
+		img.QtoXYZ = diagMat44(img.Dx, img.Dy, img.Dz)
+		img.QFormCode = 0
+	} else {
+		img.QuaternB = float64(h.QuaternB)
+		img.QuaternC = float64(h.QuaternC)
+		img.QuaternD = float64(h.QuaternD)
+		img.QOffsetX = float64(h.QOffsetX)
+		img.QOffsetY = float64(h.QOffsetY)
+		img.QOffsetZ = float64(h.QOffsetZ)
+		img.QFac = 1
+		if h.PixDim[0] < 0 {
+			img.QFac = -1
+		}
+		img.QtoXYZ = quaternToMat44(img.QuaternB, img.QuaternC, img.QuaternD,
+			img.QOffsetX, img.QOffsetY, img.QOffsetZ,
+			img.Dx, img.Dy, img.Dz, img.QFac)
+		img.QFormCode = int(h.QFormCode)
+	}
+	img.QtoIJK = img.QtoXYZ.inverse()
+
+	// Load sto_xyz affine transformation, if present.
+	if h.SFormCode > 0 {
+		for j := 0; j < 4; j++ {
+			img.StoXYZ.m[0][j] = h.SRowX[j]
+			img.StoXYZ.m[1][j] = h.SRowY[j]
+			img.StoXYZ.m[2][j] = h.SRowZ[j]
+		}
+		img.StoXYZ.m[3][3] = 1
+		img.StoIJK = img.StoXYZ.inverse()
+		img.SFormCode = int(h.SFormCode)
 	}
 
+	img.SclSlope = float64(h.SclSlope)
+	img.SclInter = float64(h.SclInter)
+
+	img.IntentCode = int(h.IntentCode)
+	img.IntentP1 = float64(h.IntentP1)
+	img.IntentP2 = float64(h.IntentP2)
+	img.IntentP3 = float64(h.IntentP3)
+	img.IntentName = int8sToString(h.IntentName[:])
+
+	img.CalMin = float64(h.CalMin)
+	img.CalMax = float64(h.CalMax)
+
+	img.TOffset = float64(h.TOffset)
+	img.XYZUnits = XYZTToSpace(h.XYZTUnits)
+	img.TimeUnits = XYZTToTime(h.XYZTUnits)
+
+	img.FreqDim, img.PhaseDim, img.SliceDim = DimInfoToFPS(h.DimInfo)
+
+	img.SliceCode = int(h.SliceCode)
+	img.SliceStart = int(h.SliceStart)
+	img.SliceEnd = int(h.SliceEnd)
+	img.SliceDuration = float64(h.SliceDuration)
+
+	img.Descrip = int8sToString(h.Descrip[:])
+	img.AuxFile = int8sToString(h.AuxFile[:])
+
+	img.INameOffset = dataOffset(h, img.NiftiType)
+
 	return img
 }
 
+// ToHeader converts an image back to a header. The magic and vox_offset are
+// set from NiftiType and INameOffset.
+// Refer to nifti_convert_nim2nhdr in nifti1_io.c.
+func (img *Image) ToHeader() Header {
+	h := Header{}
+
+	h.SizeOfHdr = minHeaderSize
+	h.UnusedRegular = 'r'
+
+	h.Dim[0] = int16(img.NDim)
+	h.Dim[1] = int16(img.Nx)
+	h.Dim[2] = int16(img.Ny)
+	h.Dim[3] = int16(img.Nz)
+	h.Dim[4] = int16(img.Nt)
+	h.Dim[5] = int16(img.Nu)
+	h.Dim[6] = int16(img.Nv)
+	h.Dim[7] = int16(img.Nw)
+
+	h.PixDim[0] = 0
+	h.PixDim[1] = float32(img.Dx)
+	h.PixDim[2] = float32(img.Dy)
+	h.PixDim[3] = float32(img.Dz)
+	h.PixDim[4] = float32(img.Dt)
+	h.PixDim[5] = float32(img.Du)
+	h.PixDim[6] = float32(img.Dv)
+	h.PixDim[7] = float32(img.Dw)
+
+	h.DataType = int16(img.DataType)
+	h.BitPix = int16(8 * img.NByPer)
+
+	if lo, hi, ok := img.DisplayRange(); ok {
+		h.CalMin, h.CalMax = float32(lo), float32(hi)
+	}
+
+	// Keep an identity slope as written, but not an invalid one.
+	if slope, inter, _ := img.Scaling(); img.SclSlope != 0 && isFinite(img.SclSlope) {
+		h.SclSlope, h.SclInter = float32(slope), float32(inter)
+	}
+
+	h.IntentCode = int16(img.IntentCode)
+	h.IntentP1 = float32(img.IntentP1)
+	h.IntentP2 = float32(img.IntentP2)
+	h.IntentP3 = float32(img.IntentP3)
+	stringToInt8s(h.IntentName[:], img.IntentName)
+
+	stringToInt8s(h.Descrip[:], img.Descrip)
+	stringToInt8s(h.AuxFile[:], img.AuxFile)
+
+	switch img.NiftiType {
+	case FileTypeNifti1:
+		h.Magic = magicOneFile
+	case FileTypeNifti1Pair:
+		h.Magic = magicTwoFile
+	}
+
+	h.XYZTUnits = SpaceTimeToXYZT(img.XYZUnits, img.TimeUnits)
+	h.TOffset = float32(img.TOffset)
+
+	if img.QFormCode > 0 {
+		h.QFormCode = int16(img.QFormCode)
+		h.QuaternB = float32(img.QuaternB)
+		h.QuaternC = float32(img.QuaternC)
+		h.QuaternD = float32(img.QuaternD)
+		h.QOffsetX = float32(img.QOffsetX)
+		h.QOffsetY = float32(img.QOffsetY)
+		h.QOffsetZ = float32(img.QOffsetZ)
+		h.PixDim[0] = 1
+		if img.QFac < 0 {
+			h.PixDim[0] = -1
+		}
+	}
+
+	if img.SFormCode > 0 {
+		h.SFormCode = int16(img.SFormCode)
+		for j := 0; j < 4; j++ {
+			h.SRowX[j] = img.StoXYZ.m[0][j]
+			h.SRowY[j] = img.StoXYZ.m[1][j]
+			h.SRowZ[j] = img.StoXYZ.m[2][j]
+		}
+	}
+
+	h.DimInfo = FPSIntoDimInfo(img.FreqDim, img.PhaseDim, img.SliceDim)
+	h.SliceCode = int8(img.SliceCode)
+	h.SliceStart = int16(img.SliceStart)
+	h.SliceEnd = int16(img.SliceEnd)
+	h.SliceDuration = float32(img.SliceDuration)
+
+	h.VoxOffset = float32(img.INameOffset)
+
+	return h
+}
+
+// XYZTToSpace returns the spatial units packed into xyzt_units.
+func XYZTToSpace(xyzt int8) int {
+	return int(xyzt) & 0x07
+}
+
+// XYZTToTime returns the temporal units packed into xyzt_units.
+func XYZTToTime(xyzt int8) int {
+	return int(xyzt) & 0x38
+}
+
+// SpaceTimeToXYZT packs spatial and temporal units into xyzt_units.
+func SpaceTimeToXYZT(space, time int) int8 {
+	return int8((space & 0x07) | (time & 0x38))
+}
+
+// DatatypeSize returns the number of bytes per voxel and the swap size for a
+// DT_* datatype code. Unknown datatypes return zero for both values.
+// Refer to nifti_datatype_sizes in nifti1_io.c.
+func DatatypeSize(datatype int) (nbyper, swapsize int) {
+	switch datatype {
+	case DTInt8, DTUint8:
+		return 1, 0
+	case DTInt16, DTUint16:
+		return 2, 2
+	case DTRGB24:
+		return 3, 0
+	case DTRGBA32:
+		return 4, 0
+	case DTInt32, DTUint32, DTFloat32:
+		return 4, 4
+	case DTComplex64:
+		return 8, 4
+	case DTFloat64, DTInt64, DTUint64:
+		return 8, 8
+	case DTFloat128:
+		return 16, 16
+	case DTComplex128:
+		return 16, 8
+	case DTComplex256:
+		return 32, 16
+	}
+	return 0, 0
+}
+
+// int8sToString converts a NUL-terminated char array to a string.
+func int8sToString(a []int8) string {
+	b := make([]byte, 0, len(a))
+	for _, c := range a {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+// stringToInt8s copies s into the char array dst, truncating s so that the
+// array stays NUL-terminated.
+func stringToInt8s(dst []int8, s string) {
+	for i := range dst {
+		dst[i] = 0
+	}
+	for i := 0; i < len(s) && i < len(dst)-1; i++ {
+		dst[i] = int8(s[i])
+	}
+}
+
 // SetData sets data into the Image struct. Operates in-place.
 // TODO(kaczmarj): refer to this link for implementation details.
 // https://github.com/afni/afni/blob/master/src/nifti/niftilib/nifti1_io.c#L3712-L3899
@@ -303,14 +719,7 @@ func (img *Image) SetData(b []byte, h Header) {
 		statDim = img.Dim[5]
 	}
 
-	var offset int
-	if h.VoxOffset < headerSize {
-		offset = headerSize
-	} else {
-		// It should be fine to cast from float32 to int16 here because the index
-		// should always be integer-like.
-		offset = int(h.VoxOffset)
-	}
+	offset := dataOffset(h, img.NiftiType)
 
 	dataSize := img.Dim[1] * img.Dim[2] * img.Dim[3] * timeDim * statDim * (int(h.BitPix) / 8)
 
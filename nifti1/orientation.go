@@ -0,0 +1,465 @@
+package nifti1
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Orientation codes of the voxel axes, as in nifti1_io.h.
+const (
+	OrientUnknown = 0
+	OrientL2R     = 1 // Left-to-Right
+	OrientR2L     = 2 // Right-to-Left
+	OrientP2A     = 3 // Posterior-to-Anterior
+	OrientA2P     = 4 // Anterior-to-Posterior
+	OrientI2S     = 5 // Inferior-to-Superior
+	OrientS2I     = 6 // Superior-to-Inferior
+)
+
+// OrientationString returns the name of an orientation code.
+// Refer to nifti_orientation_string in nifti1_io.c.
+func OrientationString(code int) string {
+	switch code {
+	case OrientL2R:
+		return "Left-to-Right"
+	case OrientR2L:
+		return "Right-to-Left"
+	case OrientP2A:
+		return "Posterior-to-Anterior"
+	case OrientA2P:
+		return "Anterior-to-Posterior"
+	case OrientI2S:
+		return "Inferior-to-Superior"
+	case OrientS2I:
+		return "Superior-to-Inferior"
+	}
+	return "Unknown"
+}
+
+// OrientationLetters returns the axis codes of an orientation, such as "RAS",
+// naming the direction each voxel axis points towards. Unknown axes are '?'.
+func OrientationLetters(codes [3]int) string {
+	letters := map[int]byte{
+		OrientL2R: 'R', OrientR2L: 'L',
+		OrientP2A: 'A', OrientA2P: 'P',
+		OrientI2S: 'S', OrientS2I: 'I',
+	}
+	b := []byte("???")
+	for n, c := range codes {
+		if l, ok := letters[c]; ok {
+			b[n] = l
+		}
+	}
+	return string(b)
+}
+
+// mat44Orientation returns the orientation codes of the i, j, and k axes of
+// an affine matrix.
+// Refer to nifti_mat44_to_orientation in nifti1_io.c.
+func mat44Orientation(r mat44) [3]int {
+	var unknown [3]int
+
+	// Column vectors for each (i,j,k) direction.
+	col := func(j int) [3]float64 {
+		return [3]float64{float64(r.m[0][j]), float64(r.m[1][j]), float64(r.m[2][j])}
+	}
+	normalize := func(v [3]float64) ([3]float64, bool) {
+		l := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+		if l == 0 {
+			return v, false
+		}
+		return [3]float64{v[0] / l, v[1] / l, v[2] / l}, true
+	}
+	dot := func(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+	orth := func(v, u [3]float64) ([3]float64, bool) {
+		if d := dot(v, u); math.Abs(d) > 1.e-4 {
+			v = [3]float64{v[0] - d*u[0], v[1] - d*u[1], v[2] - d*u[2]}
+			return normalize(v)
+		}
+		return v, true
+	}
+
+	vi, ok1 := normalize(col(0))
+	vj, ok2 := normalize(col(1))
+	vk, ok3 := normalize(col(2))
+	if !ok1 || !ok2 || !ok3 {
+		return unknown
+	}
+	// Orthogonalize j to i, and k to i and j.
+	var ok bool
+	if vj, ok = orth(vj, vi); !ok {
+		return unknown
+	}
+	if vk, ok = orth(vk, vi); !ok {
+		return unknown
+	}
+	if vk, ok = orth(vk, vj); !ok {
+		return unknown
+	}
+
+	q := [3][3]float64{
+		{vi[0], vj[0], vk[0]},
+		{vi[1], vj[1], vk[1]},
+		{vi[2], vj[2], vk[2]},
+	}
+	detQ := det33(q)
+	if detQ == 0 {
+		return unknown
+	}
+
+	// Build and test all possible +1/-1 coordinate permutation matrices P,
+	// then find the P such that M = PQ is closest to the identity, in the
+	// sense of M having the largest trace.
+	vbest := -666.0
+	var ibest, jbest, kbest, pbest, qbest, rbest int
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if j == i {
+				continue
+			}
+			for k := 0; k < 3; k++ {
+				if k == i || k == j {
+					continue
+				}
+				for _, p := range []int{-1, 1} {
+					for _, qq := range []int{-1, 1} {
+						for _, rr := range []int{-1, 1} {
+							var pm [3][3]float64
+							pm[0][i] = float64(p)
+							pm[1][j] = float64(qq)
+							pm[2][k] = float64(rr)
+							if det33(pm)*detQ <= 0 {
+								continue
+							}
+							m := mul33(pm, q)
+							if val := m[0][0] + m[1][1] + m[2][2]; val > vbest {
+								vbest = val
+								ibest, jbest, kbest = i+1, j+1, k+1
+								pbest, qbest, rbest = p, qq, rr
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	code := func(axis int) int {
+		switch axis {
+		case 1:
+			return OrientL2R
+		case -1:
+			return OrientR2L
+		case 2:
+			return OrientP2A
+		case -2:
+			return OrientA2P
+		case 3:
+			return OrientI2S
+		case -3:
+			return OrientS2I
+		}
+		return OrientUnknown
+	}
+	return [3]int{code(ibest * pbest), code(jbest * qbest), code(kbest * rbest)}
+}
+
+// QFormOrientation returns the orientation codes of the qform.
+func (img *Image) QFormOrientation() [3]int {
+	return mat44Orientation(img.QtoXYZ)
+}
+
+// SFormOrientation returns the orientation codes of the sform.
+func (img *Image) SFormOrientation() [3]int {
+	return mat44Orientation(img.StoXYZ)
+}
+
+// mat44ToQuatern computes the quaternion parameters, offsets, grid spacings,
+// and qfac of an affine matrix. The 3x3 part is orthogonalized first, so any
+// shear is lost.
+// Refer to nifti_mat44_to_quatern in nifti1_io.c.
+func mat44ToQuatern(r mat44) (qb, qc, qd, qx, qy, qz, dx, dy, dz, qfac float64) {
+	qx, qy, qz = float64(r.m[0][3]), float64(r.m[1][3]), float64(r.m[2][3])
+
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] = float64(r.m[i][j])
+		}
+	}
+
+	// Compute lengths of each column; these determine grid spacings.
+	var d [3]float64
+	for j := 0; j < 3; j++ {
+		d[j] = math.Sqrt(m[0][j]*m[0][j] + m[1][j]*m[1][j] + m[2][j]*m[2][j])
+		// If a column length is zero, patch the trouble.
+		if d[j] == 0 {
+			m[0][j], m[1][j], m[2][j] = 0, 0, 0
+			m[j][j] = 1
+			d[j] = 1
+		}
+		for i := 0; i < 3; i++ {
+			m[i][j] /= d[j]
+		}
+	}
+	dx, dy, dz = d[0], d[1], d[2]
+
+	// Orthogonalize the normalized columns by polar decomposition.
+	m = polar33(m)
+
+	// Compute the determinant to determine if it is proper.
+	qfac = 1
+	if det33(m) <= 0 {
+		qfac = -1
+		m[0][2], m[1][2], m[2][2] = -m[0][2], -m[1][2], -m[2][2]
+	}
+
+	r11, r12, r13 := m[0][0], m[0][1], m[0][2]
+	r21, r22, r23 := m[1][0], m[1][1], m[1][2]
+	r31, r32, r33 := m[2][0], m[2][1], m[2][2]
+
+	var a, b, c, dd float64
+	a = r11 + r22 + r33 + 1
+	if a > 0.5 {
+		a = 0.5 * math.Sqrt(a)
+		b = 0.25 * (r32 - r23) / a
+		c = 0.25 * (r13 - r31) / a
+		dd = 0.25 * (r21 - r12) / a
+	} else {
+		xd := 1.0 + r11 - (r22 + r33)
+		yd := 1.0 + r22 - (r11 + r33)
+		zd := 1.0 + r33 - (r11 + r22)
+		switch {
+		case xd > 1:
+			b = 0.5 * math.Sqrt(xd)
+			c = 0.25 * (r12 + r21) / b
+			dd = 0.25 * (r13 + r31) / b
+			a = 0.25 * (r32 - r23) / b
+		case yd > 1:
+			c = 0.5 * math.Sqrt(yd)
+			b = 0.25 * (r12 + r21) / c
+			dd = 0.25 * (r23 + r32) / c
+			a = 0.25 * (r13 - r31) / c
+		default:
+			dd = 0.5 * math.Sqrt(zd)
+			b = 0.25 * (r13 + r31) / dd
+			c = 0.25 * (r23 + r32) / dd
+			a = 0.25 * (r21 - r12) / dd
+		}
+		if a < 0 {
+			b, c, dd = -b, -c, -dd
+		}
+	}
+
+	return b, c, dd, qx, qy, qz, dx, dy, dz, qfac
+}
+
+// polar33 returns the orthogonal matrix closest to a, by the iterative polar
+// decomposition of nifti_mat33_polar.
+func polar33(a [3][3]float64) [3][3]float64 {
+	x := a
+	gam := det33(x)
+	for gam == 0 {
+		// Perturb the matrix until it is invertible.
+		gam = 0.00001 * (0.001 + rownorm33(x))
+		x[0][0] += gam
+		x[1][1] += gam
+		x[2][2] += gam
+		gam = det33(x)
+	}
+
+	dif := 1.0
+	for k := 0; ; k++ {
+		y := inverse33(x)
+		if dif > 0.3 {
+			// Far from convergence: use a scaled step.
+			alp := math.Sqrt(rownorm33(x) * colnorm33(x))
+			bet := math.Sqrt(rownorm33(y) * colnorm33(y))
+			gam = math.Sqrt(bet / alp)
+		} else {
+			gam = 1
+		}
+		gmi := 1 / gam
+
+		var z [3][3]float64
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				z[i][j] = 0.5 * (gam*x[i][j] + gmi*y[j][i])
+			}
+		}
+
+		dif = 0
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				dif += math.Abs(z[i][j] - x[i][j])
+			}
+		}
+
+		x = z
+		if k > 100 || dif < 3.e-6 {
+			break
+		}
+	}
+	return x
+}
+
+func det33(r [3][3]float64) float64 {
+	return r[0][0]*r[1][1]*r[2][2] - r[0][0]*r[2][1]*r[1][2] -
+		r[1][0]*r[0][1]*r[2][2] + r[1][0]*r[2][1]*r[0][2] +
+		r[2][0]*r[0][1]*r[1][2] - r[2][0]*r[1][1]*r[0][2]
+}
+
+func inverse33(r [3][3]float64) [3][3]float64 {
+	var q [3][3]float64
+	deti := det33(r)
+	if deti == 0 {
+		return q
+	}
+	deti = 1 / deti
+	q[0][0] = deti * (r[1][1]*r[2][2] - r[2][1]*r[1][2])
+	q[0][1] = deti * (-r[0][1]*r[2][2] + r[2][1]*r[0][2])
+	q[0][2] = deti * (r[0][1]*r[1][2] - r[1][1]*r[0][2])
+	q[1][0] = deti * (-r[1][0]*r[2][2] + r[2][0]*r[1][2])
+	q[1][1] = deti * (r[0][0]*r[2][2] - r[2][0]*r[0][2])
+	q[1][2] = deti * (-r[0][0]*r[1][2] + r[1][0]*r[0][2])
+	q[2][0] = deti * (r[1][0]*r[2][1] - r[2][0]*r[1][1])
+	q[2][1] = deti * (-r[0][0]*r[2][1] + r[2][0]*r[0][1])
+	q[2][2] = deti * (r[0][0]*r[1][1] - r[1][0]*r[0][1])
+	return q
+}
+
+func mul33(a, b [3][3]float64) [3][3]float64 {
+	var c [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			c[i][j] = a[i][0]*b[0][j] + a[i][1]*b[1][j] + a[i][2]*b[2][j]
+		}
+	}
+	return c
+}
+
+// rownorm33 returns the maximum absolute row sum.
+func rownorm33(a [3][3]float64) float64 {
+	r := 0.0
+	for i := 0; i < 3; i++ {
+		r = math.Max(r, math.Abs(a[i][0])+math.Abs(a[i][1])+math.Abs(a[i][2]))
+	}
+	return r
+}
+
+// colnorm33 returns the maximum absolute column sum.
+func colnorm33(a [3][3]float64) float64 {
+	r := 0.0
+	for j := 0; j < 3; j++ {
+		r = math.Max(r, math.Abs(a[0][j])+math.Abs(a[1][j])+math.Abs(a[2][j]))
+	}
+	return r
+}
+
+// mat44Det returns the determinant of the 3x3 part of an affine matrix.
+func mat44Det(r mat44) float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] = float64(r.m[i][j])
+		}
+	}
+	return det33(m)
+}
+
+// CheckHandedness looks for signs that the qform and sform disagree about
+// the handedness of the voxel axes, which indicates a silent left/right flip
+// in converted data. It returns a description of each problem found.
+func (img *Image) CheckHandedness() []string {
+	var issues []string
+
+	if img.SFormCode > 0 {
+		det := mat44Det(img.StoXYZ)
+		switch {
+		case det == 0:
+			issues = append(issues, "sform is singular")
+		case img.QFormCode > 0 && math.Signbit(det) != math.Signbit(img.QFac):
+			issues = append(issues, fmt.Sprintf(
+				"sform determinant is %g but qfac is %g: the transforms have opposite handedness (left/right flip)",
+				det, img.QFac))
+		case img.QFormCode <= 0 && det > 0 && img.QFac < 0:
+			// pixdim[0] of 0 also reads as qfac 1, so only an explicit -1
+			// is informative here.
+			issues = append(issues, fmt.Sprintf(
+				"sform determinant is %g but pixdim[0] is -1", det))
+		}
+	}
+
+	if img.QFormCode > 0 && img.SFormCode > 0 {
+		qo, so := img.QFormOrientation(), img.SFormOrientation()
+		if qo != so {
+			msg := fmt.Sprintf("qform orientation %s differs from sform orientation %s",
+				OrientationLetters(qo), OrientationLetters(so))
+			if qo[1] == so[1] && qo[2] == so[2] {
+				msg += " (left/right flip)"
+			}
+			issues = append(issues, msg)
+		}
+	}
+
+	return issues
+}
+
+// SetQFormFromSForm replaces the qform with the closest rigid transform to
+// the sform, updating the quaternion, offsets, qfac, and grid spacings. The
+// qform code is copied from the sform code if it is unset.
+func (img *Image) SetQFormFromSForm() error {
+	if img.SFormCode <= 0 {
+		return errors.New("sform is not set")
+	}
+	qb, qc, qd, qx, qy, qz, dx, dy, dz, qfac := mat44ToQuatern(img.StoXYZ)
+	img.QuaternB, img.QuaternC, img.QuaternD = qb, qc, qd
+	img.QOffsetX, img.QOffsetY, img.QOffsetZ = qx, qy, qz
+	img.Dx, img.Dy, img.Dz = dx, dy, dz
+	img.PixDim[1], img.PixDim[2], img.PixDim[3] = dx, dy, dz
+	img.QFac = qfac
+	img.QtoXYZ = quaternToMat44(qb, qc, qd, qx, qy, qz, dx, dy, dz, qfac)
+	img.QtoIJK = img.QtoXYZ.inverse()
+	if img.QFormCode <= 0 {
+		img.QFormCode = img.SFormCode
+	}
+	return nil
+}
+
+// SetSFormFromQForm replaces the sform with the qform. The sform code is
+// copied from the qform code if it is unset.
+func (img *Image) SetSFormFromQForm() error {
+	if img.QFormCode <= 0 {
+		return errors.New("qform is not set")
+	}
+	img.StoXYZ = img.QtoXYZ
+	img.StoIJK = img.QtoIJK
+	if img.SFormCode <= 0 {
+		img.SFormCode = img.QFormCode
+	}
+	return nil
+}
+
+// SetAffine sets the sform to affine with code xform (a NIFTI_XFORM_*
+// code other than unknown), and the qform to its closest rigid transform,
+// updating the spatial pixdims.
+func (img *Image) SetAffine(affine [4][4]float64, xform int) error {
+	if xform <= 0 {
+		return errors.New("transform code must be set")
+	}
+	var m mat44
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			m.m[i][j] = float32(affine[i][j])
+		}
+	}
+	img.StoXYZ, img.StoIJK = m, m.inverse()
+	img.SFormCode = xform
+	img.QFormCode = 0
+	if err := img.SetQFormFromSForm(); err != nil {
+		return err
+	}
+	img.QFormCode = xform
+	return nil
+}
@@ -0,0 +1,140 @@
+package nifti1
+
+import "fmt"
+
+// Preset holds the header fields of a common kind of image, so that
+// programs generating outputs need not fill them field by field.
+type Preset struct {
+	DataType   int     // DT_* code
+	Intent     int     // NIFTI_INTENT_* code
+	IntentName string  // at most 15 characters
+	XYZUnits   int     // NIFTI_UNITS_* code of the voxel size
+	TimeUnits  int     // NIFTI_UNITS_* code of RepetitionTime
+	CalMin     float64 // display range, or 0 and 0 for the data range
+	CalMax     float64
+	// Spacing is the voxel size, in XYZUnits, of images made by New.
+	Spacing [3]float64
+	// RepetitionTime is the time between volumes, in TimeUnits, of 4D
+	// images, or 0.
+	RepetitionTime float64
+	Descrip        string
+}
+
+// T1wPreset is for anatomical images: 1 mm isotropic voxels stored as
+// DT_INT16, as scanners write them.
+func T1wPreset() Preset {
+	return Preset{
+		DataType:  DTInt16,
+		XYZUnits:  UnitsMM,
+		TimeUnits: UnitsSec,
+		Spacing:   [3]float64{1, 1, 1},
+		Descrip:   "T1w",
+	}
+}
+
+// BOLDPreset is for functional series with a repetition time of tr
+// seconds: 2 mm isotropic voxels stored as DT_FLOAT32, as preprocessed
+// series usually are.
+func BOLDPreset(tr float64) Preset {
+	return Preset{
+		DataType:       DTFloat32,
+		XYZUnits:       UnitsMM,
+		TimeUnits:      UnitsSec,
+		Spacing:        [3]float64{2, 2, 2},
+		RepetitionTime: tr,
+		Descrip:        "bold",
+	}
+}
+
+// LabelMapPreset is for segmentations and atlases: DT_UINT16 labels, with
+// NIFTI_INTENT_LABEL, on 1 mm voxels.
+func LabelMapPreset() Preset {
+	return Preset{
+		DataType:  DTUint16,
+		Intent:    IntentLabel,
+		XYZUnits:  UnitsMM,
+		TimeUnits: UnitsSec,
+		Spacing:   [3]float64{1, 1, 1},
+		Descrip:   "labels",
+	}
+}
+
+// ProbabilityMapPreset is for tissue and atlas probabilities: DT_FLOAT32
+// values displayed from 0 to 1, named "probability", on 1 mm voxels.
+func ProbabilityMapPreset() Preset {
+	return Preset{
+		DataType:   DTFloat32,
+		IntentName: "probability",
+		XYZUnits:   UnitsMM,
+		TimeUnits:  UnitsSec,
+		CalMin:     0,
+		CalMax:     1,
+		Spacing:    [3]float64{1, 1, 1},
+		Descrip:    "probability",
+	}
+}
+
+// New returns an image of zeros of dims (x, y, z, t, ...) with the preset,
+// with voxels of Spacing centered on the world origin in scanner
+// coordinates.
+func (p Preset) New(dims ...int) (*Image, error) {
+	var affine [4][4]float64
+	for a := 0; a < 3; a++ {
+		n := 1
+		if a < len(dims) {
+			n = dims[a]
+		}
+		affine[a][a] = p.Spacing[a]
+		affine[a][3] = -p.Spacing[a] * float64(n-1) / 2
+	}
+	affine[3][3] = 1
+	img, err := NewImage(p.DataType, dims, affine, XformScannerAnat)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Apply(img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Like returns an image of zeros with the preset on the grid of ref: its
+// dims, or dims if given, and its voxel size, qform, and sform. The
+// extensions of ref are not copied.
+func (p Preset) Like(ref *Image, dims ...int) (*Image, error) {
+	grid := *ref
+	if len(dims) > 0 {
+		if err := grid.SetDims(dims...); err != nil {
+			return nil, err
+		}
+	}
+	size, _ := DatatypeSize(p.DataType)
+	img, err := grid.WithData(make([]byte, grid.NVox*size), p.DataType)
+	if err != nil {
+		return nil, err
+	}
+	img.Extensions, img.NumExt = nil, 0
+	img.FName, img.IName = "", ""
+	if err := p.Apply(img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Apply sets the intent, units, display range, repetition time, and
+// description of img to those of the preset. Its datatype and grid are
+// kept.
+func (p Preset) Apply(img *Image) error {
+	if len(p.IntentName) > 15 {
+		return fmt.Errorf("intent name %q is longer than 15 characters", p.IntentName)
+	}
+	img.IntentCode, img.IntentName = p.Intent, p.IntentName
+	img.IntentP1, img.IntentP2, img.IntentP3 = 0, 0, 0
+	img.XYZUnits, img.TimeUnits = p.XYZUnits, p.TimeUnits
+	img.CalMin, img.CalMax = p.CalMin, p.CalMax
+	if p.RepetitionTime > 0 && img.NDim >= 4 {
+		img.Dt, img.PixDim[4] = p.RepetitionTime, p.RepetitionTime
+	}
+	img.Descrip = p.Descrip
+	return nil
+}
@@ -0,0 +1,56 @@
+package nifti1
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// provenancePrefix marks comment extensions holding provenance records.
+const provenancePrefix = "gonifti-provenance "
+
+// ProvenanceRecord describes an operation applied to an image. Records are
+// stored as JSON in comment extensions, so they travel with the file.
+type ProvenanceRecord struct {
+	Time       time.Time         `json:"time"`
+	Operation  string            `json:"operation"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Inputs     []string          `json:"inputs,omitempty"`
+}
+
+// AddProvenance appends a provenance record to the image's extensions. The
+// record's time is set to now if it is zero.
+func (img *Image) AddProvenance(r ProvenanceRecord) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data := append([]byte(provenancePrefix), b...)
+	// Copy the slice so images sharing extensions are not modified.
+	exts := make([]Extension, len(img.Extensions), len(img.Extensions)+1)
+	copy(exts, img.Extensions)
+	img.Extensions = append(exts, Extension{ECode: ECodeComment, Data: data})
+	img.NumExt = len(img.Extensions)
+	return nil
+}
+
+// Provenance returns the provenance records stored in the image's
+// extensions, oldest first.
+func (img *Image) Provenance() []ProvenanceRecord {
+	var records []ProvenanceRecord
+	for _, e := range img.Extensions {
+		if e.ECode != ECodeComment || !bytes.HasPrefix(e.Data, []byte(provenancePrefix)) {
+			continue
+		}
+		// Extension data is padded with zeros to a multiple of 16 bytes.
+		b := bytes.TrimRight(e.Data[len(provenancePrefix):], "\x00")
+		var r ProvenanceRecord
+		if err := json.Unmarshal(b, &r); err == nil {
+			records = append(records, r)
+		}
+	}
+	return records
+}
@@ -0,0 +1,100 @@
+package nifti1
+
+import "fmt"
+
+// HeaderReportSchema identifies the version of the JSON header report.
+// Fields may be added within a version, but not removed or changed.
+const HeaderReportSchema = "gonifti-header/1"
+
+// Code is a header code with its symbolic name, "" for values nifti1.h
+// does not name.
+type Code struct {
+	Value int    `json:"value"`
+	Name  string `json:"name"`
+}
+
+func (c Code) String() string {
+	if c.Name == "" {
+		return fmt.Sprint(c.Value)
+	}
+	return fmt.Sprintf("%d (%s)", c.Value, c.Name)
+}
+
+// HeaderReport is the content of a header in a stable form for reports,
+// with codes given both by value and by name and strings decoded.
+type HeaderReport struct {
+	Schema        string        `json:"schema"`
+	SizeOfHdr     int           `json:"sizeof_hdr"`
+	DimInfo       [3]int        `json:"dim_info"` // freq, phase, slice
+	Dim           [8]int        `json:"dim"`
+	IntentP       [3]float64    `json:"intent_p"`
+	IntentCode    Code          `json:"intent_code"`
+	DataType      Code          `json:"datatype"`
+	BitPix        int           `json:"bitpix"`
+	SliceStart    int           `json:"slice_start"`
+	PixDim        [8]float64    `json:"pixdim"`
+	VoxOffset     float64       `json:"vox_offset"`
+	SclSlope      float64       `json:"scl_slope"`
+	SclInter      float64       `json:"scl_inter"`
+	SliceEnd      int           `json:"slice_end"`
+	SliceCode     Code          `json:"slice_code"`
+	XYZUnits      Code          `json:"xyz_units"`
+	TimeUnits     Code          `json:"time_units"`
+	CalMax        float64       `json:"cal_max"`
+	CalMin        float64       `json:"cal_min"`
+	SliceDuration float64       `json:"slice_duration"`
+	TOffset       float64       `json:"toffset"`
+	Descrip       string        `json:"descrip"`
+	AuxFile       string        `json:"aux_file"`
+	QFormCode     Code          `json:"qform_code"`
+	SFormCode     Code          `json:"sform_code"`
+	Quatern       [3]float64    `json:"quatern_bcd"`
+	QOffset       [3]float64    `json:"qoffset_xyz"`
+	SRow          [3][4]float64 `json:"srow"`
+	IntentName    string        `json:"intent_name"`
+	Magic         string        `json:"magic"`
+}
+
+// NewHeaderReport returns the report of a header.
+func NewHeaderReport(h Header) HeaderReport {
+	r := HeaderReport{
+		Schema:        HeaderReportSchema,
+		SizeOfHdr:     int(h.SizeOfHdr),
+		IntentP:       [3]float64{float64(h.IntentP1), float64(h.IntentP2), float64(h.IntentP3)},
+		IntentCode:    Code{int(h.IntentCode), IntentString(int(h.IntentCode))},
+		DataType:      Code{int(h.DataType), DataTypeString(int(h.DataType))},
+		BitPix:        int(h.BitPix),
+		SliceStart:    int(h.SliceStart),
+		VoxOffset:     float64(h.VoxOffset),
+		SclSlope:      float64(h.SclSlope),
+		SclInter:      float64(h.SclInter),
+		SliceEnd:      int(h.SliceEnd),
+		SliceCode:     Code{int(h.SliceCode), SliceCodeString(int(h.SliceCode))},
+		XYZUnits:      Code{XYZTToSpace(h.XYZTUnits), UnitsString(XYZTToSpace(h.XYZTUnits))},
+		TimeUnits:     Code{XYZTToTime(h.XYZTUnits), UnitsString(XYZTToTime(h.XYZTUnits))},
+		CalMax:        float64(h.CalMax),
+		CalMin:        float64(h.CalMin),
+		SliceDuration: float64(h.SliceDuration),
+		TOffset:       float64(h.TOffset),
+		Descrip:       int8sToString(h.Descrip[:]),
+		AuxFile:       int8sToString(h.AuxFile[:]),
+		QFormCode:     Code{int(h.QFormCode), XformString(int(h.QFormCode))},
+		SFormCode:     Code{int(h.SFormCode), XformString(int(h.SFormCode))},
+		Quatern:       [3]float64{float64(h.QuaternB), float64(h.QuaternC), float64(h.QuaternD)},
+		QOffset:       [3]float64{float64(h.QOffsetX), float64(h.QOffsetY), float64(h.QOffsetZ)},
+		IntentName:    int8sToString(h.IntentName[:]),
+		Magic:         int8sToString(h.Magic[:]),
+	}
+	freq, phase, slice := DimInfoToFPS(h.DimInfo)
+	r.DimInfo = [3]int{freq, phase, slice}
+	for i := 0; i < 8; i++ {
+		r.Dim[i] = int(h.Dim[i])
+		r.PixDim[i] = float64(h.PixDim[i])
+	}
+	for j := 0; j < 4; j++ {
+		r.SRow[0][j] = float64(h.SRowX[j])
+		r.SRow[1][j] = float64(h.SRowY[j])
+		r.SRow[2][j] = float64(h.SRowZ[j])
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+package nifti1
+
+import "math"
+
+// The NIfTI-1 rules for the header fields that modify or describe voxel
+// values, in one place so that every consumer interprets them alike:
+//
+//   - scl_slope of 0 means no scaling, and so does a slope that is NaN or
+//     infinite; a non-finite scl_inter is taken as 0.
+//   - Scaling does not apply to DT_RGB24 and DT_RGBA32 data.
+//   - cal_min and cal_max give a display range only if both are finite and
+//     cal_max > cal_min; 0 and 0 means unset.
+
+// Scaling returns the slope and intercept that convert stored values to
+// real values, and reports whether they change the values at all. If not,
+// slope and inter are 1 and 0.
+func (img *Image) Scaling() (slope, inter float64, ok bool) {
+	slope, inter = img.SclSlope, img.SclInter
+	if slope == 0 || !isFinite(slope) || img.DataType == DTRGB24 || img.DataType == DTRGBA32 {
+		return 1, 0, false
+	}
+	if !isFinite(inter) {
+		inter = 0
+	}
+	return slope, inter, slope != 1 || inter != 0
+}
+
+// DisplayRange returns cal_min and cal_max, and reports whether they give
+// a usable display range.
+func (img *Image) DisplayRange() (lo, hi float64, ok bool) {
+	lo, hi = img.CalMin, img.CalMax
+	if !isFinite(lo) || !isFinite(hi) || hi <= lo {
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
+
+func isFinite(x float64) bool {
+	return !math.IsNaN(x) && !math.IsInf(x, 0)
+}
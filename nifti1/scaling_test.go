@@ -0,0 +1,138 @@
+package nifti1
+
+import (
+	"math"
+	"testing"
+)
+
+// scalingImage returns a 4-voxel image of datatype with the given scaling.
+func scalingImage(t *testing.T, datatype int, slope, inter float64) *Image {
+	t.Helper()
+	affine := [4][4]float64{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+	img, err := NewImage(datatype, []int{4}, affine, XformScannerAnat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.SclSlope, img.SclInter = slope, inter
+	return img
+}
+
+func TestScaling(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	tests := []struct {
+		name         string
+		datatype     int
+		slope, inter float64
+		wantSlope    float64
+		wantInter    float64
+		wantOK       bool
+	}{
+		{"zero slope", DTInt16, 0, 5, 1, 0, false},
+		{"NaN slope", DTInt16, nan, 5, 1, 0, false},
+		{"infinite slope", DTInt16, inf, 5, 1, 0, false},
+		{"negative infinite slope", DTInt16, -inf, 5, 1, 0, false},
+		{"identity", DTInt16, 1, 0, 1, 0, false},
+		{"slope", DTInt16, 2, 0, 2, 0, true},
+		{"intercept", DTInt16, 1, -3, 1, -3, true},
+		{"NaN intercept", DTInt16, 2, nan, 2, 0, true},
+		{"infinite intercept", DTInt16, 2, inf, 2, 0, true},
+		{"RGB24", DTRGB24, 2, 1, 1, 0, false},
+		{"RGBA32", DTRGBA32, 2, 1, 1, 0, false},
+		{"float32", DTFloat32, 2, 1, 2, 1, true},
+		{"float64", DTFloat64, 0.5, 0, 0.5, 0, true},
+		{"float32 with zero slope", DTFloat32, 0, 1, 1, 0, false},
+		{"float32 with NaN slope", DTFloat32, nan, 1, 1, 0, false},
+	}
+	for _, tt := range tests {
+		img := scalingImage(t, tt.datatype, tt.slope, tt.inter)
+		slope, inter, ok := img.Scaling()
+		if slope != tt.wantSlope || inter != tt.wantInter || ok != tt.wantOK {
+			t.Errorf("%s: Scaling() = %g, %g, %v, want %g, %g, %v",
+				tt.name, slope, inter, ok, tt.wantSlope, tt.wantInter, tt.wantOK)
+		}
+	}
+}
+
+func TestScaledFloat64s(t *testing.T) {
+	tests := []struct {
+		name         string
+		slope, inter float64
+		want         []float64
+	}{
+		{"zero slope", 0, 10, []float64{1, 2, 3, 4}},
+		{"NaN slope", math.NaN(), 10, []float64{1, 2, 3, 4}},
+		{"infinite slope", math.Inf(-1), 10, []float64{1, 2, 3, 4}},
+		{"slope and intercept", 2, 10, []float64{12, 14, 16, 18}},
+	}
+	for _, datatype := range []int{DTInt16, DTFloat32} {
+		for _, tt := range tests {
+			img := scalingImage(t, datatype, 1, 0)
+			order := img.byteOrder()
+			for i, v := range []float64{1, 2, 3, 4} {
+				if datatype == DTInt16 {
+					order.PutUint16(img.Data[2*i:], uint16(v))
+				} else {
+					order.PutUint32(img.Data[4*i:], math.Float32bits(float32(v)))
+				}
+			}
+			img.SclSlope, img.SclInter = tt.slope, tt.inter
+			got, err := img.ScaledFloat64s()
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("datatype %d, %s: ScaledFloat64s() = %v, want %v", datatype, tt.name, got, tt.want)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestToHeaderScaling(t *testing.T) {
+	tests := []struct {
+		name         string
+		slope, inter float64
+		wantSlope    float32
+		wantInter    float32
+	}{
+		{"zero slope", 0, 5, 0, 0},
+		{"NaN slope", math.NaN(), 5, 0, 0},
+		{"infinite slope", math.Inf(1), 5, 0, 0},
+		{"identity", 1, 0, 1, 0},
+		{"NaN intercept", 2, math.NaN(), 2, 0},
+	}
+	for _, tt := range tests {
+		h := scalingImage(t, DTInt16, tt.slope, tt.inter).ToHeader()
+		if h.SclSlope != tt.wantSlope || h.SclInter != tt.wantInter {
+			t.Errorf("%s: scl_slope, scl_inter = %g, %g, want %g, %g",
+				tt.name, h.SclSlope, h.SclInter, tt.wantSlope, tt.wantInter)
+		}
+	}
+}
+
+func TestDisplayRange(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	tests := []struct {
+		name   string
+		lo, hi float64
+		wantOK bool
+	}{
+		{"unset", 0, 0, false},
+		{"range", -1, 5, true},
+		{"inverted", 5, -1, false},
+		{"empty", 3, 3, false},
+		{"NaN minimum", nan, 5, false},
+		{"NaN maximum", 0, nan, false},
+		{"infinite maximum", 0, inf, false},
+	}
+	for _, tt := range tests {
+		img := scalingImage(t, DTInt16, 1, 0)
+		img.CalMin, img.CalMax = tt.lo, tt.hi
+		lo, hi, ok := img.DisplayRange()
+		if ok != tt.wantOK || (ok && (lo != tt.lo || hi != tt.hi)) {
+			t.Errorf("%s: DisplayRange() = %g, %g, %v, want ok %v", tt.name, lo, hi, ok, tt.wantOK)
+		}
+	}
+}
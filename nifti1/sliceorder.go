@@ -0,0 +1,113 @@
+package nifti1
+
+import "fmt"
+
+// SliceOption configures SliceOrder, SliceShots, SliceTiming, and
+// Image.SliceTimes.
+type SliceOption func(*sliceConfig)
+
+type sliceConfig struct {
+	multiband int
+}
+
+// Multiband sets the multiband (simultaneous multi-slice) factor: the
+// slices are acquired factor at a time, n/factor apart, and the slice code
+// orders the shots over the first n/factor slices, as on CMRR and vendor
+// sequences. A factor of 0 or 1 means one slice per shot.
+func Multiband(factor int) SliceOption {
+	return func(c *sliceConfig) {
+		c.multiband = factor
+	}
+}
+
+// SliceShots returns, for each of n slices, the index of the shot that
+// acquires it under a slice code (a NIFTI_SLICE_* code other than
+// unknown), from 0 for the first.
+func SliceShots(n, code int, opts ...SliceOption) ([]int, error) {
+	cfg := sliceConfig{multiband: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("number of slices must be positive, got %d", n)
+	}
+	if cfg.multiband < 1 {
+		cfg.multiband = 1
+	}
+	if n%cfg.multiband != 0 {
+		return nil, fmt.Errorf("%d slices are not a multiple of the multiband factor %d", n, cfg.multiband)
+	}
+	m := n / cfg.multiband
+
+	// alt returns the position in time of slice i of interleaved slices
+	// starting with the even slices, or the odd ones if odd is set.
+	alt := func(i int, odd bool) int {
+		first := (m + 1) / 2
+		if odd {
+			first = m / 2
+		}
+		if (i%2 == 1) == odd {
+			return i / 2
+		}
+		return first + i/2
+	}
+	shots := make([]int, n)
+	for i := 0; i < n; i++ {
+		j := i % m
+		switch code {
+		case SliceSeqInc:
+			shots[i] = j
+		case SliceSeqDec:
+			shots[i] = m - 1 - j
+		case SliceAltInc:
+			shots[i] = alt(j, false)
+		case SliceAltDec:
+			shots[i] = alt(m-1-j, false)
+		case SliceAltInc2:
+			shots[i] = alt(j, true)
+		case SliceAltDec2:
+			shots[i] = alt(m-1-j, true)
+		default:
+			return nil, fmt.Errorf("%w: slice_code %d", ErrUnsupported, code)
+		}
+	}
+	return shots, nil
+}
+
+// SliceOrder returns the slices of each shot, in the order of acquisition,
+// for n slices under a slice code: one slice per shot, or the factor
+// slices acquired together with Multiband.
+func SliceOrder(n, code int, opts ...SliceOption) ([][]int, error) {
+	shots, err := SliceShots(n, code, opts...)
+	if err != nil {
+		return nil, err
+	}
+	nshots := 0
+	for _, k := range shots {
+		if k+1 > nshots {
+			nshots = k + 1
+		}
+	}
+	order := make([][]int, nshots)
+	for i, k := range shots {
+		order[k] = append(order[k], i)
+	}
+	return order, nil
+}
+
+// SliceTiming returns the acquisition time in seconds of each of n slices
+// under a slice code, relative to the start of the volume, with shots
+// shotDuration seconds apart. Slices acquired together with Multiband share
+// a time. Without gaps, shotDuration is the repetition time divided by the
+// number of shots, n/factor.
+func SliceTiming(n, code int, shotDuration float64, opts ...SliceOption) ([]float64, error) {
+	shots, err := SliceShots(n, code, opts...)
+	if err != nil {
+		return nil, err
+	}
+	times := make([]float64, n)
+	for i, k := range shots {
+		times[i] = float64(k) * shotDuration
+	}
+	return times, nil
+}
@@ -0,0 +1,259 @@
+package nifti1
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// VolumeReader reads the volumes of an image one at a time from its file,
+// so that a long 4D series need not be held in memory. With prefetching, a
+// goroutine reads and decodes the next volumes while the caller works on
+// the current one, overlapping I/O and inflation with computation.
+type VolumeReader struct {
+	// Image holds the header and extensions of the file. Its Data is empty.
+	Image *Image
+
+	name     string
+	r        io.Reader
+	closers  []io.Closer
+	n, next  int
+	returned int // volumes returned by Next
+
+	results chan volumeResult
+	done    chan struct{}
+}
+
+type volumeResult struct {
+	values []float64
+	err    error
+}
+
+// OpenVolumes opens an image for reading volume by volume with Next. Up to
+// prefetch volumes are read ahead in the background; 0 reads each volume
+// when it is asked for. The read options are those of ReadFile, except
+// InflateToDisk, which does not apply to streams.
+func OpenVolumes(filename string, prefetch int, opts ...ReadOption) (*VolumeReader, error) {
+	cfg := readConfig{profile: DefaultProfile}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	hdrName, imgName := filename, filename
+	pair := FileTypeFromName(filename) == FileTypeNifti1Pair
+	if pair {
+		hdrName, imgName = PairFilenames(filename)
+	}
+
+	v := &VolumeReader{name: imgName}
+	ok := false
+	defer func() {
+		if !ok {
+			v.closeFiles()
+		}
+	}()
+
+	data, err := v.open(imgName, cfg.fsys)
+	if err != nil {
+		return nil, err
+	}
+	var hb []byte
+	if pair {
+		if hb, err = cfg.readBytes(hdrName); err != nil {
+			return nil, GzipError(hdrName, err)
+		}
+	} else {
+		// The extender is read with the extensions, since the data may
+		// start right after the header.
+		hb = make([]byte, minHeaderSize)
+		if n, err := io.ReadFull(data, hb); err != nil {
+			return nil, fmt.Errorf("%s: %w: file is too short to hold a header (%d bytes)", hdrName, ErrTruncated, n)
+		}
+	}
+
+	h, order, err := DecodeHeader(hb)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", hdrName, err)
+	}
+	if err := RepairPixDims(&h, cfg.profile.PixDim); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", hdrName, ErrInvalidHeader, err)
+	}
+	img := ConvertHeaderToImage(h, order)
+	img.FName, img.IName = hdrName, imgName
+
+	// Read the extensions of a single file, and skip to the voxel data.
+	offset := img.INameOffset
+	if !pair {
+		rest := make([]byte, offset-len(hb))
+		if _, err := io.ReadFull(data, rest); err != nil {
+			return nil, v.readError(err, "the extensions")
+		}
+		hb = append(hb, rest...)
+		if img.Extensions, err = ReadExtensions(hb, order, offset); err != nil {
+			// As in decode, the data start at vox_offset regardless.
+			log.WithFields(log.Fields{
+				"file":      hdrName,
+				"voxOffset": offset,
+				"kept":      len(img.Extensions),
+				"error":     err,
+			}).Warn("Extensions conflict with vox_offset; dropping the rest")
+		}
+	} else {
+		if img.Extensions, err = ReadExtensions(hb, order, len(hb)); err != nil {
+			return nil, fmt.Errorf("%s: %w: %v", hdrName, ErrInvalidHeader, err)
+		}
+		if _, err := io.CopyN(ioutil.Discard, data, int64(offset)); err != nil {
+			return nil, v.readError(err, "the data offset")
+		}
+	}
+	img.NumExt = len(img.Extensions)
+	if img.NByPer == 0 {
+		return nil, fmt.Errorf("%s: %w datatype %d", hdrName, ErrUnsupported, img.DataType)
+	}
+
+	v.Image = img
+	v.r = data
+	v.n = img.NVox / (img.Nx * img.Ny * img.Nz)
+	if prefetch > 0 {
+		v.results = make(chan volumeResult, prefetch)
+		v.done = make(chan struct{})
+		go v.prefetch()
+	}
+	ok = true
+	return v, nil
+}
+
+// open opens a file or URL, or a file of fsys if it is not nil, for
+// streaming, decompressing it if it starts with the magic of a codec.
+func (v *VolumeReader) open(name string, fsys fs.FS) (io.Reader, error) {
+	var f io.ReadCloser
+	if fsys != nil {
+		var err error
+		if f, err = fsys.Open(name); err != nil {
+			return nil, err
+		}
+	} else if util.IsRemote(name) {
+		var err error
+		if f, err = util.OpenRemote(context.Background(), name); err != nil {
+			return nil, err
+		}
+	} else {
+		resolved, err := util.ResolveAnnex(name)
+		if err != nil {
+			return nil, err
+		}
+		if f, err = os.Open(resolved); err != nil {
+			return nil, err
+		}
+	}
+	v.closers = append(v.closers, f)
+	br := bufio.NewReaderSize(f, 1<<20)
+	magic, _ := br.Peek(util.MagicLen())
+	c, ok := util.DetectCodec(magic)
+	if !ok {
+		return br, nil
+	}
+	r, err := c.NewReader(br)
+	if err != nil {
+		return nil, GzipError(name, err)
+	}
+	v.closers = append(v.closers, r)
+	return r, nil
+}
+
+// Len returns the number of volumes.
+func (v *VolumeReader) Len() int {
+	return v.n
+}
+
+// Next returns the scaled values of the next volume, or io.EOF after the
+// last one.
+func (v *VolumeReader) Next() ([]float64, error) {
+	var res volumeResult
+	if v.results == nil {
+		res.values, res.err = v.read()
+	} else if r, ok := <-v.results; ok {
+		res = r
+	} else {
+		return nil, io.EOF
+	}
+	if res.err == nil {
+		v.returned++
+	}
+	return res.values, res.err
+}
+
+// prefetch reads volumes into the results channel until the last one, an
+// error, or Close.
+func (v *VolumeReader) prefetch() {
+	defer close(v.results)
+	for {
+		values, err := v.read()
+		if err == io.EOF {
+			return
+		}
+		select {
+		case v.results <- volumeResult{values, err}:
+		case <-v.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// read reads and decodes the next volume.
+func (v *VolumeReader) read() ([]float64, error) {
+	if v.next >= v.n {
+		return nil, io.EOF
+	}
+	vol := *v.Image
+	if err := vol.SetDims(vol.Nx, vol.Ny, vol.Nz); err != nil {
+		return nil, err
+	}
+	vol.Data = make([]byte, vol.VolumeBytes())
+	if _, err := io.ReadFull(v.r, vol.Data); err != nil {
+		return nil, v.readError(err, fmt.Sprintf("volume %d of %d", v.next, v.n))
+	}
+	v.next++
+	return vol.ScaledFloat64s()
+}
+
+// readError describes an error reading part of the file.
+func (v *VolumeReader) readError(err error, part string) error {
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%s: %w: the file ends before %s", v.name, ErrTruncated, part)
+	}
+	return GzipError(v.name, err)
+}
+
+// Close stops prefetching and closes the file.
+func (v *VolumeReader) Close() error {
+	if v.done != nil {
+		close(v.done)
+		// Wait for the prefetching goroutine to stop reading.
+		for range v.results {
+		}
+		v.done = nil
+	}
+	return v.closeFiles()
+}
+
+func (v *VolumeReader) closeFiles() error {
+	var first error
+	for i := len(v.closers) - 1; i >= 0; i-- {
+		if err := v.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	v.closers = nil
+	return first
+}
@@ -0,0 +1,72 @@
+package nifti1
+
+// Strides returns the strides, in elements, of an array of shape laid out
+// in order: the distance in the flat data between values whose index
+// differs by one along each axis. In FortranOrder the first index varies
+// fastest, in COrder the last. Multiply by the size of an element for
+// strides in bytes, as DLPack and NumPy take them.
+func Strides(shape []int, order Order) []int {
+	strides := make([]int, len(shape))
+	n := 1
+	if order == COrder {
+		for d := len(shape) - 1; d >= 0; d-- {
+			strides[d] = n
+			n *= shape[d]
+		}
+		return strides
+	}
+	for d := range shape {
+		strides[d] = n
+		n *= shape[d]
+	}
+	return strides
+}
+
+// Strides returns the strides, in elements, of the voxel data for indices
+// in the NIfTI dim order (i, j, k, t, ...): 1, nx, nx*ny, and so on, since
+// NIfTI stores voxels in FortranOrder.
+func (img *Image) Strides() []int {
+	return Strides(img.Dim[1:img.NDim+1], FortranOrder)
+}
+
+// Offset returns the index in the voxel data of the voxel at idx, given in
+// the NIfTI dim order. Missing trailing indices are 0.
+func (img *Image) Offset(idx ...int) int {
+	off := 0
+	for d, s := range img.Strides() {
+		if d < len(idx) {
+			off += idx[d] * s
+		}
+	}
+	return off
+}
+
+// permute copies src, whose values are laid out with strides over shape,
+// to dst in COrder over shape. It runs through dst in order, with the
+// innermost loop along the last axis, so that writes are sequential and
+// only reads stride.
+func permute(dst, src []float32, shape, strides []int) {
+	n := len(shape)
+	if n == 0 || len(dst) == 0 {
+		copy(dst, src)
+		return
+	}
+	last, step := shape[n-1], strides[n-1]
+	idx := make([]int, n-1)
+	s := 0
+	for d := 0; d < len(dst); d += last {
+		row := dst[d : d+last]
+		for i, o := 0, s; i < len(row); i, o = i+1, o+step {
+			row[i] = src[o]
+		}
+		for a := n - 2; a >= 0; a-- {
+			idx[a]++
+			s += strides[a]
+			if idx[a] < shape[a] {
+				break
+			}
+			s -= idx[a] * strides[a]
+			idx[a] = 0
+		}
+	}
+}
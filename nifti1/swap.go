@@ -0,0 +1,86 @@
+package nifti1
+
+import "fmt"
+
+// intentUsesDim5 reports whether an intent gives dim[5] a meaning: the
+// components of vectors, matrices, and the like, or, for statistics, the
+// parameters of each voxel.
+func intentUsesDim5(code int) bool {
+	switch {
+	case code >= firstStatCode && code <= lastStatCode:
+		return true
+	case code >= intentGenMatrix && code <= intentQuaternion:
+		return true
+	case code == intentRGBVector || code == intentRGBAVector:
+		return true
+	}
+	return false
+}
+
+// SwapTU returns a copy of the image with its 4th and 5th dimensions (t
+// and u) swapped, for data from converters that put echoes or coils along
+// dim[4] and time along dim[5]. The voxels are reordered, and dim, pixdim,
+// and the spacings Dt and Du are swapped; xyzt_units, toffset, and the
+// slice timing then describe the new time axis. NDim grows to 5 or shrinks
+// to 4 as the 5th dimension appears or disappears.
+//
+// Images whose intent gives dim[5] a meaning, such as vectors, matrices,
+// and statistics, are refused when either dimension is longer than 1, as
+// the swap would move that meaning to dim[4], which cannot hold it or would
+// give it to the other dimension; set IntentCode to NIFTI_INTENT_NONE first
+// if the intent no longer applies. Images with dimensions beyond the 5th
+// are swapped within each of their blocks.
+func (img *Image) SwapTU() (*Image, error) {
+	nt, nu := img.Dim[4], img.Dim[5]
+	if nt < 1 {
+		nt = 1
+	}
+	if nu < 1 {
+		nu = 1
+	}
+	if intentUsesDim5(img.IntentCode) && (nt > 1 || nu > 1) {
+		return nil, fmt.Errorf("%w: swapping dim[4] and dim[5] of an image whose intent %s uses dim[5]",
+			ErrUnsupported, IntentString(img.IntentCode))
+	}
+	vol := img.VolumeBytes()
+	if len(img.Data) < img.NVox*img.NByPer {
+		return nil, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), img.NVox*img.NByPer)
+	}
+
+	dims := []int{img.Dim[1], img.Dim[2], img.Dim[3], nu, nt, img.Dim[6], img.Dim[7]}
+	for i := range dims {
+		if dims[i] < 1 {
+			dims[i] = 1
+		}
+	}
+	// Trailing dimensions of length 1 are dropped, down to 3.
+	n := len(dims)
+	for n > 3 && dims[n-1] == 1 {
+		n--
+	}
+
+	out := *img
+	if err := out.SetDims(dims[:n]...); err != nil {
+		return nil, err
+	}
+	out.PixDim[4], out.PixDim[5] = img.PixDim[5], img.PixDim[4]
+	out.Dt, out.Du = img.Du, img.Dt
+
+	// Volume (t, u) of each block moves to (u, t).
+	block := nt * nu * vol
+	data := make([]byte, img.NVox*img.NByPer)
+	for b := 0; b < len(data); b += block {
+		for u := 0; u < nu; u++ {
+			for t := 0; t < nt; t++ {
+				src := b + (t+u*nt)*vol
+				dst := b + (u+t*nu)*vol
+				copy(data[dst:dst+vol], img.Data[src:src+vol])
+			}
+		}
+	}
+	out.Data = data
+	out.TrailingData = nil
+	// The checksums are of volumes in the old order.
+	out.DropChecksums()
+	return &out, nil
+}
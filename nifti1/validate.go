@@ -0,0 +1,193 @@
+package nifti1
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// PixDimRepair selects how zero, negative, or non-finite grid spacings in
+// pixdim[1..ndim] are handled on read.
+type PixDimRepair int
+
+const (
+	// PixDimSetOne replaces every bad spacing, negative ones included,
+	// with 1.0.
+	PixDimSetOne PixDimRepair = iota
+	// PixDimAbs takes the absolute value of negative spacings and replaces
+	// zero and non-finite spacings with 1.0, as nifti_convert_nhdr2nim of
+	// niftilib does.
+	PixDimAbs
+	// PixDimError rejects the header.
+	PixDimError
+)
+
+// String returns the name used for the repair strategy on the command line.
+func (r PixDimRepair) String() string {
+	switch r {
+	case PixDimSetOne:
+		return "one"
+	case PixDimAbs:
+		return "abs"
+	case PixDimError:
+		return "error"
+	}
+	return fmt.Sprintf("PixDimRepair(%d)", int(r))
+}
+
+// ParsePixDimRepair returns the repair strategy with the given name: "one",
+// "abs", or "error".
+func ParsePixDimRepair(s string) (PixDimRepair, error) {
+	for _, r := range []PixDimRepair{PixDimSetOne, PixDimAbs, PixDimError} {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pixdim repair strategy %q", s)
+}
+
+// ValidationProfile controls how strictly headers are checked and which
+// fixups are applied when reading.
+type ValidationProfile struct {
+	PixDim PixDimRepair // handling of non-positive pixdims
+}
+
+// DefaultProfile mirrors the fixups applied by niftilib.
+var DefaultProfile = ValidationProfile{
+	PixDim: PixDimAbs,
+}
+
+// ReadOption configures ReadFile.
+type ReadOption func(*readConfig)
+
+type readConfig struct {
+	profile ValidationProfile
+	// gzipped is set when the data were inflated, whose checks passed.
+	gzipped bool
+	// inflateThreshold and inflateDir are set by InflateToDisk.
+	inflateThreshold int64
+	inflateDir       string
+	// fsys is set by FromFS.
+	fsys fs.FS
+	// direct is set by DirectRead.
+	direct bool
+	// memoryLimit is set by MemoryLimit.
+	memoryLimit int64
+}
+
+// WithProfile sets the validation profile used by ReadFile.
+func WithProfile(p ValidationProfile) ReadOption {
+	return func(c *readConfig) {
+		c.profile = p
+	}
+}
+
+// InflateToDisk makes ReadFile inflate gzipped files whose inflated size is
+// estimated to be at least threshold bytes into a temporary file in dir
+// (the default directory for temporary files if empty) and map it into
+// memory, instead of holding the inflated bytes on the heap. See
+// util.InflateToDisk. A threshold of 0 or less turns this off.
+func InflateToDisk(threshold int64, dir string) ReadOption {
+	return func(c *readConfig) {
+		c.inflateThreshold = threshold
+		c.inflateDir = dir
+	}
+}
+
+// DirectRead makes ReadFile read local files with util.ReadDirect, around
+// the page cache, which spares the cache of a shared node when multi-GB
+// images are read once from fast scratch storage. It does not apply to
+// InflateToDisk, to remote files, or to files read from an fs.FS.
+func DirectRead() ReadOption {
+	return func(c *readConfig) {
+		c.direct = true
+	}
+}
+
+// FromFS makes ReadFile and OpenVolumes read files from fsys, such as an
+// embed.FS of test volumes, instead of the operating system. Names are
+// then slash-separated paths in fsys, and InflateToDisk does not apply.
+func FromFS(fsys fs.FS) ReadOption {
+	return func(c *readConfig) {
+		c.fsys = fsys
+	}
+}
+
+// ReadFS reads an image from fsys. It is ReadFile with FromFS(fsys).
+func ReadFS(fsys fs.FS, filename string, opts ...ReadOption) (*Image, error) {
+	return ReadFile(filename, append(opts, FromFS(fsys))...)
+}
+
+// readBytes returns the content of a file, inflated in memory or, above
+// the InflateToDisk threshold, on disk.
+func (c *readConfig) readBytes(filename string) ([]byte, error) {
+	if c.fsys != nil {
+		b, err := fs.ReadFile(c.fsys, filename)
+		if err != nil {
+			return nil, err
+		}
+		return util.DecompressBytes(b)
+	}
+	if _, gz := splitGzipSuffix(filename); gz && c.inflateThreshold > 0 && !util.IsRemote(filename) {
+		n, err := util.InflatedSize(filename)
+		if err == nil && n >= c.inflateThreshold {
+			log.WithFields(log.Fields{
+				"file":     filename,
+				"estimate": n,
+			}).Debug("Inflating to a temporary file")
+			return util.InflateToDisk(filename, c.inflateDir)
+		}
+	}
+	if c.direct && !util.IsRemote(filename) {
+		name, err := util.ResolveAnnex(filename)
+		if err != nil {
+			return nil, err
+		}
+		b, err := util.ReadDirect(name)
+		if err != nil {
+			return nil, err
+		}
+		return util.DecompressBytes(b)
+	}
+	return util.ReadBytes(filename)
+}
+
+// RepairPixDims checks pixdim[1..ndim] and applies the repair strategy to
+// spacings that are zero, negative, or not finite. It returns an error only
+// for the PixDimError strategy. The header is modified in place.
+func RepairPixDims(h *Header, repair PixDimRepair) error {
+	ndim := int(h.Dim[0])
+	if ndim > 7 {
+		ndim = 7
+	}
+
+	for i := 1; i <= ndim; i++ {
+		v := float64(h.PixDim[i])
+		bad := math.IsNaN(v) || math.IsInf(v, 0)
+		if !bad && v > 0 {
+			continue
+		}
+
+		if repair == PixDimError {
+			return fmt.Errorf("invalid pixdim[%d] = %v", i, v)
+		}
+
+		fixed := float32(1)
+		if repair == PixDimAbs && !bad && v < 0 {
+			fixed = float32(-v)
+		}
+
+		log.WithFields(log.Fields{
+			"index":  i,
+			"pixdim": v,
+			"fixed":  fixed,
+			"repair": repair,
+		}).Warn("Repairing invalid pixdim")
+		h.PixDim[i] = fixed
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package nifti1
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRepairPixDims(t *testing.T) {
+	nan := float32(math.NaN())
+	tests := []struct {
+		repair PixDimRepair
+		want   [4]float32
+	}{
+		{PixDimSetOne, [4]float32{1, 1, 1, 3}},
+		{PixDimAbs, [4]float32{2, 1, 1, 3}},
+	}
+	for _, tt := range tests {
+		h := Header{Dim: [8]int16{4, 2, 2, 2, 2}, PixDim: [8]float32{1, -2, 0, nan, 3}}
+		if err := RepairPixDims(&h, tt.repair); err != nil {
+			t.Fatalf("%v: %v", tt.repair, err)
+		}
+		for i, want := range tt.want {
+			if h.PixDim[i+1] != want {
+				t.Errorf("%v: pixdim[1:5] = %v, want %v", tt.repair, h.PixDim[1:5], tt.want)
+				break
+			}
+		}
+	}
+	h := Header{Dim: [8]int16{3, 2, 2, 2}, PixDim: [8]float32{1, -2, 1, 1}}
+	if err := RepairPixDims(&h, PixDimError); err == nil {
+		t.Error("error: negative pixdim accepted")
+	}
+	if DefaultProfile.PixDim != PixDimAbs {
+		t.Errorf("default repair %v, want abs as in niftilib", DefaultProfile.PixDim)
+	}
+}
@@ -0,0 +1,163 @@
+package npy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// dtypes are the NumPy types of the NIfTI datatypes, without the byte
+// order.
+var dtypes = map[int]string{
+	nifti1.DTUint8:      "u1",
+	nifti1.DTInt8:       "i1",
+	nifti1.DTInt16:      "i2",
+	nifti1.DTUint16:     "u2",
+	nifti1.DTInt32:      "i4",
+	nifti1.DTUint32:     "u4",
+	nifti1.DTInt64:      "i8",
+	nifti1.DTUint64:     "u8",
+	nifti1.DTFloat32:    "f4",
+	nifti1.DTFloat64:    "f8",
+	nifti1.DTComplex64:  "c8",
+	nifti1.DTComplex128: "c16",
+}
+
+// ImageOptions configures ImageArray.
+type ImageOptions struct {
+	// Scaled applies scl_slope and scl_inter.
+	Scaled bool
+	// DataType is the NIfTI datatype of the elements, such as
+	// nifti1.DTFloat32, or 0 for the stored datatype, or float64 if Scaled
+	// changes the values.
+	DataType int
+	// Fortran keeps the elements in the order of the file, x fastest.
+	// Otherwise they are reordered to C order. Both index as
+	// array[x, y, z, t], as nibabel does.
+	Fortran bool
+}
+
+// ImageArray returns the voxels of an image as an array of shape (nx, ny,
+// nz, ...). Stored values are kept as they are, in the byte order of the
+// image, unless they are scaled or converted. Conversion to an integer
+// datatype rounds, and clips to its range; the number of values clipped
+// is returned.
+func ImageArray(img *nifti1.Image, opts ImageOptions) (Array, int, error) {
+	shape := make([]int, img.NDim)
+	copy(shape, img.Dim[1:img.NDim+1])
+	_, _, scales := img.Scaling()
+	scales = scales && opts.Scaled
+
+	datatype := opts.DataType
+	if datatype == 0 {
+		datatype = img.DataType
+		if scales {
+			datatype = nifti1.DTFloat64
+		}
+	}
+	kind, ok := dtypes[datatype]
+	if !ok {
+		return Array{}, 0, fmt.Errorf("%w datatype %s in .npy", nifti1.ErrUnsupported, nifti1.DataTypeString(datatype))
+	}
+	size, _ := nifti1.DatatypeSize(datatype)
+
+	a := Array{Fortran: opts.Fortran, Shape: shape}
+	clipped := 0
+	if datatype == img.DataType && !scales {
+		if len(img.Data) < img.NVox*img.NByPer {
+			return Array{}, 0, fmt.Errorf("image holds %d bytes of data, expected %d", len(img.Data), img.NVox*img.NByPer)
+		}
+		a.Descr = descr(img.ByteOrder, size) + kind
+		a.Data = img.Data[:img.NVox*img.NByPer]
+	} else {
+		if !nifti1.IsNumeric(datatype) {
+			return Array{}, 0, fmt.Errorf("%w conversion to %s", nifti1.ErrUnsupported, nifti1.DataTypeString(datatype))
+		}
+		values, err := img.Float64s()
+		if scales {
+			values, err = img.ScaledFloat64s()
+		}
+		if err != nil {
+			return Array{}, 0, err
+		}
+		a.Descr = descr(binary.LittleEndian, size) + kind
+		a.Data, clipped = encode(values, datatype, size)
+	}
+	if !opts.Fortran {
+		a.Data = Reorder(a.Data, shape, size, true)
+	}
+	return a, clipped, nil
+}
+
+// descr returns the byte order character of a type string. Single bytes
+// have none.
+func descr(order binary.ByteOrder, size int) string {
+	switch {
+	case size == 1:
+		return "|"
+	case order == binary.BigEndian:
+		return ">"
+	}
+	return "<"
+}
+
+// encode stores values as a numeric datatype in little-endian order,
+// rounding and clipping them for integer datatypes.
+func encode(values []float64, datatype, size int) ([]byte, int) {
+	b := make([]byte, size*len(values))
+	lo, hi, _ := nifti1.DataTypeRange(datatype)
+	integer := nifti1.IsInteger(datatype)
+	clipped := 0
+	le := binary.LittleEndian
+	for i, v := range values {
+		if integer {
+			v = math.Round(v)
+			switch {
+			case math.IsNaN(v):
+				v = 0
+				clipped++
+			case v < lo:
+				v = lo
+				clipped++
+			case v > hi:
+				v = hi
+				clipped++
+			}
+		}
+		p := b[size*i:]
+		switch datatype {
+		case nifti1.DTUint8:
+			p[0] = uint8(v)
+		case nifti1.DTInt8:
+			p[0] = uint8(int8(v))
+		case nifti1.DTInt16:
+			le.PutUint16(p, uint16(int16(v)))
+		case nifti1.DTUint16:
+			le.PutUint16(p, uint16(v))
+		case nifti1.DTInt32:
+			le.PutUint32(p, uint32(int32(v)))
+		case nifti1.DTUint32:
+			le.PutUint32(p, uint32(v))
+		case nifti1.DTInt64:
+			// The limits of 64-bit integers round up in float64.
+			if v >= hi {
+				le.PutUint64(p, math.MaxInt64)
+			} else {
+				le.PutUint64(p, uint64(int64(v)))
+			}
+		case nifti1.DTUint64:
+			if v >= hi {
+				le.PutUint64(p, math.MaxUint64)
+			} else {
+				le.PutUint64(p, uint64(v))
+			}
+		case nifti1.DTFloat32:
+			le.PutUint32(p, math.Float32bits(float32(v)))
+		case nifti1.DTFloat64:
+			le.PutUint64(p, math.Float64bits(v))
+		}
+	}
+	return b, clipped
+}
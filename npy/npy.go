@@ -0,0 +1,146 @@
+// npy writes arrays in the NumPy .npy format, version 1.0, so that results
+// can be loaded with numpy.load, and converts images to arrays.
+// https://numpy.org/doc/stable/reference/generated/numpy.lib.format.html
+
+package npy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// magic starts every .npy file, followed by the format version.
+const magic = "\x93NUMPY\x01\x00"
+
+// Array is an array of any dtype, whose elements are stored in Data as
+// Descr, a NumPy array-protocol type string such as "<i2" or ">f4",
+// describes them.
+type Array struct {
+	Descr   string
+	Fortran bool // column-major, first index fastest
+	Shape   []int
+	Data    []byte
+}
+
+// Encode writes data as a little-endian float64 array of the given shape in
+// C (row-major) order.
+func Encode(w io.Writer, data []float64, shape []int) error {
+	buf := make([]byte, 8*len(data))
+	for i, x := range data {
+		binary.LittleEndian.PutUint64(buf[8*i:], math.Float64bits(x))
+	}
+	return EncodeArray(w, Array{Descr: "<f8", Shape: shape, Data: buf})
+}
+
+// EncodeArray writes an array.
+func EncodeArray(w io.Writer, a Array) error {
+	size, err := itemSize(a.Descr)
+	if err != nil {
+		return err
+	}
+	n := 1
+	dims := make([]string, len(a.Shape))
+	for i, s := range a.Shape {
+		n *= s
+		dims[i] = fmt.Sprint(s)
+	}
+	if n*size != len(a.Data) {
+		return fmt.Errorf("shape %v of %s has %d bytes, data has %d", a.Shape, a.Descr, n*size, len(a.Data))
+	}
+	tuple := strings.Join(dims, ", ")
+	if len(a.Shape) == 1 {
+		tuple += ","
+	}
+	fortran := "False"
+	if a.Fortran {
+		fortran = "True"
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': %s, 'shape': (%s), }", a.Descr, fortran, tuple)
+	// Pad with spaces and a newline so that the data are 64-byte aligned.
+	total := len(magic) + 2 + len(header) + 1
+	header += strings.Repeat(" ", (64-total%64)%64) + "\n"
+
+	var b bytes.Buffer
+	b.WriteString(magic)
+	binary.Write(&b, binary.LittleEndian, uint16(len(header)))
+	b.WriteString(header)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+	_, err = w.Write(a.Data)
+	return err
+}
+
+// WriteFile writes data to a .npy file.
+func WriteFile(name string, data []float64, shape []int) error {
+	var b bytes.Buffer
+	if err := Encode(&b, data, shape); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(name, b.Bytes(), 0644)
+}
+
+// WriteArray writes an array to a .npy file.
+func WriteArray(name string, a Array) error {
+	return util.WriteAtomic(name, func(w io.Writer) error {
+		return EncodeArray(w, a)
+	})
+}
+
+// itemSize returns the size of the elements of a type string, such as 2
+// for "<i2".
+func itemSize(descr string) (int, error) {
+	if len(descr) < 3 || !strings.ContainsRune("<>|=", rune(descr[0])) || !strings.ContainsRune("biufc", rune(descr[1])) {
+		return 0, fmt.Errorf("unsupported dtype %q", descr)
+	}
+	size, err := strconv.Atoi(descr[2:])
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("unsupported dtype %q", descr)
+	}
+	return size, nil
+}
+
+// Reorder returns the elements of an array of a shape, each size bytes,
+// in the other order: in C order if fortran is set, and in Fortran order
+// otherwise.
+func Reorder(data []byte, shape []int, size int, fortran bool) []byte {
+	if fortran {
+		// An array in Fortran order is the transpose, in C order.
+		reversed := make([]int, len(shape))
+		for i, s := range shape {
+			reversed[len(shape)-1-i] = s
+		}
+		shape = reversed
+	}
+	out := make([]byte, len(data))
+	// strides[i] is the C stride of index i, in elements.
+	strides := make([]int, len(shape))
+	s := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		strides[i] = s
+		s *= shape[i]
+	}
+	index := make([]int, len(shape))
+	src := 0
+	for dst := 0; dst < len(out); dst += size {
+		copy(out[dst:dst+size], data[src*size:(src+1)*size])
+		// Advance the Fortran index, first index fastest.
+		for i := range index {
+			index[i]++
+			src += strides[i]
+			if index[i] < shape[i] {
+				break
+			}
+			src -= index[i] * strides[i]
+			index[i] = 0
+		}
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package npy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+var (
+	descrRe   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	fortranRe = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	shapeRe   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// Decode reads an array in the .npy format, versions 1.0 to 3.0. Object
+// and structured arrays are not supported.
+func Decode(r io.Reader) (Array, error) {
+	var a Array
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return a, err
+	}
+	if len(b) < 10 || string(b[:6]) != magic[:6] {
+		return a, errors.New("not a .npy file")
+	}
+	var header string
+	switch b[6] {
+	case 1:
+		n := int(binary.LittleEndian.Uint16(b[8:]))
+		if len(b) < 10+n {
+			return a, fmt.Errorf("%w .npy header", nifti1.ErrTruncated)
+		}
+		header, b = string(b[10:10+n]), b[10+n:]
+	case 2, 3:
+		if len(b) < 12 {
+			return a, fmt.Errorf("%w .npy header", nifti1.ErrTruncated)
+		}
+		n := int(binary.LittleEndian.Uint32(b[8:]))
+		if n < 0 || len(b) < 12+n {
+			return a, fmt.Errorf("%w .npy header", nifti1.ErrTruncated)
+		}
+		header, b = string(b[12:12+n]), b[12+n:]
+	default:
+		return a, fmt.Errorf("%w .npy version %d.%d", nifti1.ErrUnsupported, b[6], b[7])
+	}
+
+	m := descrRe.FindStringSubmatch(header)
+	if m == nil {
+		return a, fmt.Errorf("%w dtype in .npy header %q", nifti1.ErrUnsupported, header)
+	}
+	a.Descr = m[1]
+	size, err := itemSize(a.Descr)
+	if err != nil {
+		return a, fmt.Errorf("%w: %v", nifti1.ErrUnsupported, err)
+	}
+	if m := fortranRe.FindStringSubmatch(header); m != nil {
+		a.Fortran = m[1] == "True"
+	}
+	m = shapeRe.FindStringSubmatch(header)
+	if m == nil {
+		return a, fmt.Errorf("no shape in .npy header %q", header)
+	}
+	n := 1
+	for _, s := range strings.Split(m[1], ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		d, err := strconv.Atoi(strings.TrimSuffix(s, "L"))
+		if err != nil || d < 0 {
+			return a, fmt.Errorf("invalid shape (%s)", m[1])
+		}
+		a.Shape = append(a.Shape, d)
+		n *= d
+	}
+	if len(b) < n*size {
+		return a, fmt.Errorf("%w: %d bytes of data, expected %d", nifti1.ErrTruncated, len(b), n*size)
+	}
+	a.Data = b[:n*size]
+	return a, nil
+}
+
+// ReadFile reads an array from a .npy file.
+func ReadFile(name string) (Array, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return Array{}, err
+	}
+	a, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		return a, fmt.Errorf("%s: %w", name, err)
+	}
+	return a, nil
+}
+
+// ReadNPZ reads the array named key from a .npz file, as saved by
+// numpy.savez or numpy.savez_compressed. If key is empty, the file must
+// hold a single array.
+func ReadNPZ(name, key string) (Array, error) {
+	z, err := zip.OpenReader(name)
+	if err != nil {
+		return Array{}, err
+	}
+	defer z.Close()
+	var keys []string
+	for _, f := range z.File {
+		k := strings.TrimSuffix(f.Name, ".npy")
+		keys = append(keys, k)
+		if key != "" && k != key {
+			continue
+		}
+		if key == "" && len(z.File) > 1 {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return Array{}, err
+		}
+		defer r.Close()
+		a, err := Decode(r)
+		if err != nil {
+			return a, fmt.Errorf("%s: %s: %w", name, k, err)
+		}
+		return a, nil
+	}
+	sort.Strings(keys)
+	if key == "" {
+		return Array{}, fmt.Errorf("%s holds %d arrays, choose one of %s", name, len(keys), strings.Join(keys, ", "))
+	}
+	return Array{}, fmt.Errorf("%s has no array %q, only %s", name, key, strings.Join(keys, ", "))
+}
+
+// DataType returns the NIfTI datatype and byte order of the elements of
+// the array. Booleans are stored as DT_UINT8.
+func (a Array) DataType() (int, binary.ByteOrder, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if strings.HasPrefix(a.Descr, ">") {
+		order = binary.BigEndian
+	}
+	kind := strings.TrimLeft(a.Descr, "<>|=")
+	if kind == "b1" {
+		return nifti1.DTUint8, order, nil
+	}
+	for datatype, k := range dtypes {
+		if k == kind {
+			return datatype, order, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("%w dtype %s", nifti1.ErrUnsupported, a.Descr)
+}
@@ -0,0 +1,99 @@
+// numeric contains the floating-point reductions shared by the statistics
+// and QC metrics: compensated sums whose error does not grow with the
+// number of voxels, with a deterministic mode whose results are the same to
+// the bit on every machine.
+
+package numeric
+
+// Deterministic selects explicit rounding of every product and sum, and
+// pairwise summation in Sum. The Go compiler may otherwise fuse x*y + z
+// into one fused multiply-add on arm64, ppc64, and s390x but not on amd64,
+// so that results differ across machines in their last bits. The work of
+// every goroutine in gonifti is split by voxel, not by the number of
+// workers, so results do not depend on -workers in either mode.
+var Deterministic bool
+
+// Accumulator sums values in the order they are added, with Neumaier's
+// variant of Kahan summation. The error of the sum of n values is at most
+// about 2u·Σ|x| + O(n u²)·Σ|x|, with u = 2⁻⁵³, against (n-1)u·Σ|x| for a
+// naive float64 sum, which at a billion voxels is 1e-7 of Σ|x|. The zero
+// value is an empty sum.
+type Accumulator struct {
+	sum, c float64
+}
+
+// Add adds x to the sum.
+func (a *Accumulator) Add(x float64) {
+	t := float64(a.sum + x)
+	if abs(a.sum) >= abs(x) {
+		a.c += float64(float64(a.sum-t) + x)
+	} else {
+		a.c += float64(float64(x-t) + a.sum)
+	}
+	a.sum = t
+}
+
+// AddProduct adds x*y to the sum. In Deterministic mode, the product is
+// rounded before it is added, so that it is never fused with the addition.
+func (a *Accumulator) AddProduct(x, y float64) {
+	if Deterministic {
+		a.Add(float64(x * y))
+		return
+	}
+	a.Add(x * y)
+}
+
+// Sum returns the sum of the values added so far.
+func (a *Accumulator) Sum() float64 {
+	return a.sum + a.c
+}
+
+// pairwiseBlock is the length below which Sum adds values in sequence in
+// Deterministic mode.
+const pairwiseBlock = 128
+
+// Sum returns the compensated sum of x. In Deterministic mode, x is split
+// in halves down to blocks of a fixed length, so that the order of
+// additions depends on len(x) alone, and the sums of the halves are merged
+// along with their compensations, so that the error bound of Accumulator
+// holds and grows with log(len(x)) instead of len(x) in its second-order
+// term.
+func Sum(x []float64) float64 {
+	a := sum(x)
+	return a.Sum()
+}
+
+// sum returns the accumulator of the values of x, added as Sum says.
+func sum(x []float64) Accumulator {
+	if !Deterministic || len(x) <= pairwiseBlock {
+		var a Accumulator
+		for _, v := range x {
+			a.Add(v)
+		}
+		return a
+	}
+	h := len(x) / 2
+	a := sum(x[:h])
+	a.merge(sum(x[h:]))
+	return a
+}
+
+// merge adds the values added to b to a. Rounding the sums of the halves
+// before adding them would lose the compensation of both, which is as
+// large as the rounding error of their partial sums.
+func (a *Accumulator) merge(b Accumulator) {
+	a.Add(b.sum)
+	a.c = float64(a.c + b.c)
+}
+
+// Mean returns the mean of x, or NaN if x is empty.
+func Mean(x []float64) float64 {
+	return Sum(x) / float64(len(x))
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
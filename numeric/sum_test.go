@@ -0,0 +1,106 @@
+package numeric
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// exactSum returns the sum of x rounded once to float64, computed with
+// enough bits that no addition rounds.
+func exactSum(x []float64) float64 {
+	s := new(big.Float).SetPrec(4096)
+	for _, v := range x {
+		s.Add(s, new(big.Float).SetFloat64(v))
+	}
+	f, _ := s.Float64()
+	return f
+}
+
+// cancellation returns n triples 1e16, v, -1e16, whose naive float64 sum
+// loses every v, along with values of mixed sign and magnitude.
+func cancellation(n int) []float64 {
+	r := rand.New(rand.NewSource(1))
+	x := make([]float64, 0, 4*n)
+	for i := 0; i < n; i++ {
+		v := r.Float64()
+		x = append(x, 1e16, v, -1e16, (r.Float64()-0.5)*math.Pow(10, float64(r.Intn(20)-10)))
+	}
+	return x
+}
+
+func TestSumAgainstBig(t *testing.T) {
+	defer func(d bool) { Deterministic = d }(Deterministic)
+	const u = 0x1p-53
+	for _, n := range []int{1, 10, 1000, 100000} {
+		x := cancellation(n)
+		want := exactSum(x)
+		var abssum float64
+		for _, v := range x {
+			abssum += math.Abs(v)
+		}
+		// The bound of Accumulator, with room for the second-order term.
+		bound := 2*u*math.Abs(want) + float64(len(x))*u*u*abssum
+		for _, Deterministic = range []bool{false, true} {
+			got := Sum(x)
+			if err := math.Abs(got - want); err > bound {
+				t.Errorf("n=%d deterministic=%v: Sum = %.17g, want %.17g (error %g > %g)", n, Deterministic, got, want, err, bound)
+			}
+		}
+	}
+}
+
+func TestAccumulatorAddProductAgainstBig(t *testing.T) {
+	defer func(d bool) { Deterministic = d }(Deterministic)
+	r := rand.New(rand.NewSource(2))
+	x := make([]float64, 10000)
+	y := make([]float64, len(x))
+	exact := new(big.Float).SetPrec(4096)
+	for i := range x {
+		x[i] = (r.Float64() - 0.5) * 1e8
+		y[i] = (r.Float64() - 0.5) * 1e-8
+		if i%2 == 1 {
+			// Cancel the previous product up to its rounding.
+			x[i], y[i] = -x[i-1], y[i-1]
+		}
+		p := new(big.Float).SetPrec(4096).Mul(new(big.Float).SetFloat64(x[i]), new(big.Float).SetFloat64(y[i]))
+		exact.Add(exact, p)
+	}
+	want, _ := exact.Float64()
+	for _, Deterministic = range []bool{false, true} {
+		var a Accumulator
+		for i := range x {
+			a.AddProduct(x[i], y[i])
+		}
+		// Each product is rounded once before it is added.
+		var bound float64
+		for i := range x {
+			bound += math.Abs(x[i]*y[i]) * 0x1p-52
+		}
+		if got := a.Sum(); math.Abs(got-want) > bound {
+			t.Errorf("deterministic=%v: sum of products = %g, want %g", Deterministic, got, want)
+		}
+	}
+}
+
+func TestSumCancellationExact(t *testing.T) {
+	defer func(d bool) { Deterministic = d }(Deterministic)
+	// A naive sum of 1e16, 1, -1e16, ... is 0; the exact sum is n.
+	const n = 1000
+	x := make([]float64, 0, 3*n)
+	for i := 0; i < n; i++ {
+		x = append(x, 1e16, 1, -1e16)
+	}
+	for _, Deterministic = range []bool{false, true} {
+		if got := Sum(x); got != n {
+			t.Errorf("deterministic=%v: Sum = %g, want %d", Deterministic, got, n)
+		}
+	}
+}
+
+func TestMeanEmpty(t *testing.T) {
+	if m := Mean(nil); !math.IsNaN(m) {
+		t.Errorf("Mean(nil) = %g, want NaN", m)
+	}
+}
@@ -0,0 +1,350 @@
+// parquet contains a writer for Apache Parquet files of flat tables, which
+// DuckDB, pandas, and Arrow read directly. Pages are PLAIN encoded and
+// optionally compressed with gzip.
+// https://parquet.apache.org/docs/file-format/
+
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+const magic = "PAR1"
+
+// Parquet enums, from parquet.thrift.
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeFloat     = 4
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8 = 0
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	codecGzip         = 2
+
+	pageData = 0
+)
+
+// Column is a column of a table. Values is one of []int32, []int64,
+// []float32, []float64, or []string. If Null is set, the column is optional
+// and Null[i] marks row i as missing; Values[i] is then ignored.
+type Column struct {
+	Name   string
+	Values interface{}
+	Null   []bool
+}
+
+// Option configures Write.
+type Option func(*config)
+
+type config struct {
+	codec        int32
+	rowGroupSize int
+	metadata     [][2]string
+}
+
+// Gzip compresses the pages with gzip.
+func Gzip() Option {
+	return func(c *config) {
+		c.codec = codecGzip
+	}
+}
+
+// RowGroupSize sets the number of rows per row group. By default all rows
+// are in one group.
+func RowGroupSize(n int) Option {
+	return func(c *config) {
+		c.rowGroupSize = n
+	}
+}
+
+// Metadata adds a key-value pair to the file metadata.
+func Metadata(key, value string) Option {
+	return func(c *config) {
+		c.metadata = append(c.metadata, [2]string{key, value})
+	}
+}
+
+// length returns the number of values of a column and its physical type.
+func (c Column) length() (int, int32, error) {
+	var n int
+	var typ int32
+	switch v := c.Values.(type) {
+	case []int32:
+		n, typ = len(v), typeInt32
+	case []int64:
+		n, typ = len(v), typeInt64
+	case []float32:
+		n, typ = len(v), typeFloat
+	case []float64:
+		n, typ = len(v), typeDouble
+	case []string:
+		n, typ = len(v), typeByteArray
+	default:
+		return 0, 0, fmt.Errorf("column %s: unsupported values %T", c.Name, c.Values)
+	}
+	if c.Null != nil && len(c.Null) != n {
+		return 0, 0, fmt.Errorf("column %s: %d null flags for %d values", c.Name, len(c.Null), n)
+	}
+	return n, typ, nil
+}
+
+// plain appends the PLAIN encoding of the non-null values of rows [lo, hi).
+func (c Column) plain(b []byte, lo, hi int) []byte {
+	var tmp [8]byte
+	for i := lo; i < hi; i++ {
+		if c.Null != nil && c.Null[i] {
+			continue
+		}
+		switch v := c.Values.(type) {
+		case []int32:
+			binary.LittleEndian.PutUint32(tmp[:], uint32(v[i]))
+			b = append(b, tmp[:4]...)
+		case []int64:
+			binary.LittleEndian.PutUint64(tmp[:], uint64(v[i]))
+			b = append(b, tmp[:8]...)
+		case []float32:
+			binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(v[i]))
+			b = append(b, tmp[:4]...)
+		case []float64:
+			binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v[i]))
+			b = append(b, tmp[:8]...)
+		case []string:
+			binary.LittleEndian.PutUint32(tmp[:], uint32(len(v[i])))
+			b = append(append(b, tmp[:4]...), v[i]...)
+		}
+	}
+	return b
+}
+
+// definitionLevels appends the definition levels of rows [lo, hi) in the
+// RLE/bit-packing hybrid encoding with a bit width of 1, preceded by their
+// length as data page v1 requires.
+func (c Column) definitionLevels(b []byte, lo, hi int) []byte {
+	var runs []byte
+	var tmp [binary.MaxVarintLen64]byte
+	for i := lo; i < hi; {
+		j := i
+		for j < hi && c.Null[j] == c.Null[i] {
+			j++
+		}
+		n := binary.PutUvarint(tmp[:], uint64(j-i)<<1)
+		runs = append(runs, tmp[:n]...)
+		if c.Null[i] {
+			runs = append(runs, 0)
+		} else {
+			runs = append(runs, 1)
+		}
+		i = j
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(runs)))
+	return append(append(b, size[:]...), runs...)
+}
+
+// chunk is a written column chunk.
+type chunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+	numValues        int64
+}
+
+// Write writes the columns as a table to w. All columns must have the same
+// length.
+func Write(w io.Writer, columns []Column, opts ...Option) error {
+	cfg := config{codec: codecUncompressed}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	nrows := -1
+	types := make([]int32, len(columns))
+	for i, c := range columns {
+		n, typ, err := c.length()
+		if err != nil {
+			return err
+		}
+		if nrows >= 0 && n != nrows {
+			return fmt.Errorf("column %s has %d rows, expected %d", c.Name, n, nrows)
+		}
+		nrows, types[i] = n, typ
+	}
+	if nrows < 0 {
+		return fmt.Errorf("no columns")
+	}
+	groupSize := cfg.rowGroupSize
+	if groupSize <= 0 || groupSize > nrows {
+		groupSize = nrows
+	}
+
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	offset := int64(len(magic))
+
+	var groups [][]chunk
+	for lo := 0; lo < nrows || (nrows == 0 && groups == nil); lo += groupSize {
+		hi := lo + groupSize
+		if hi > nrows {
+			hi = nrows
+		}
+		var chunks []chunk
+		for _, c := range columns {
+			var data []byte
+			if c.Null != nil {
+				data = c.definitionLevels(data, lo, hi)
+			}
+			data = c.plain(data, lo, hi)
+			body := data
+			if cfg.codec == codecGzip {
+				var buf bytes.Buffer
+				g := gzip.NewWriter(&buf)
+				g.Write(data)
+				if err := g.Close(); err != nil {
+					return err
+				}
+				body = buf.Bytes()
+			}
+
+			var e encoder
+			e.beginStruct()
+			e.i32(1, pageData)
+			e.i32(2, int32(len(data)))
+			e.i32(3, int32(len(body)))
+			e.structField(5)
+			e.i32(1, int32(hi-lo))
+			e.i32(2, encodingPlain)
+			e.i32(3, encodingRLE)
+			e.i32(4, encodingRLE)
+			e.endStruct()
+			e.endStruct()
+
+			if _, err := w.Write(e.b); err != nil {
+				return err
+			}
+			if _, err := w.Write(body); err != nil {
+				return err
+			}
+			chunks = append(chunks, chunk{
+				offset:           offset,
+				uncompressedSize: int64(len(e.b) + len(data)),
+				compressedSize:   int64(len(e.b) + len(body)),
+				numValues:        int64(hi - lo),
+			})
+			offset += int64(len(e.b) + len(body))
+		}
+		groups = append(groups, chunks)
+	}
+
+	footer := fileMetaData(columns, types, groups, nrows, cfg)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, size[:], []byte(magic)} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileMetaData encodes the footer.
+func fileMetaData(columns []Column, types []int32, groups [][]chunk, nrows int, cfg config) []byte {
+	var e encoder
+	e.beginStruct()
+	e.i32(1, 1)
+
+	e.list(2, tStruct, len(columns)+1)
+	e.beginStruct()
+	e.str(4, "schema")
+	e.i32(5, int32(len(columns)))
+	e.endStruct()
+	for i, c := range columns {
+		e.beginStruct()
+		e.i32(1, types[i])
+		if c.Null != nil {
+			e.i32(3, repetitionOptional)
+		} else {
+			e.i32(3, repetitionRequired)
+		}
+		e.str(4, c.Name)
+		if types[i] == typeByteArray {
+			e.i32(6, convertedUTF8)
+		}
+		e.endStruct()
+	}
+
+	e.i64(3, int64(nrows))
+
+	e.list(4, tStruct, len(groups))
+	for _, chunks := range groups {
+		e.beginStruct()
+		e.list(1, tStruct, len(chunks))
+		var total, rows int64
+		for i, ch := range chunks {
+			encodings := []int32{encodingPlain}
+			if columns[i].Null != nil {
+				encodings = append(encodings, encodingRLE)
+			}
+			e.beginStruct()
+			e.i64(2, ch.offset)
+			e.structField(3)
+			e.i32(1, types[i])
+			e.list(2, tI32, len(encodings))
+			for _, enc := range encodings {
+				e.listI32(enc)
+			}
+			e.list(3, tBinary, 1)
+			e.listStr(columns[i].Name)
+			e.i32(4, cfg.codec)
+			e.i64(5, ch.numValues)
+			e.i64(6, ch.uncompressedSize)
+			e.i64(7, ch.compressedSize)
+			e.i64(9, ch.offset)
+			e.endStruct()
+			e.endStruct()
+			total += ch.uncompressedSize
+			rows = ch.numValues
+		}
+		e.i64(2, total)
+		e.i64(3, rows)
+		e.endStruct()
+	}
+
+	if len(cfg.metadata) > 0 {
+		e.list(5, tStruct, len(cfg.metadata))
+		for _, kv := range cfg.metadata {
+			e.beginStruct()
+			e.str(1, kv[0])
+			e.str(2, kv[1])
+			e.endStruct()
+		}
+	}
+	e.str(6, "gonifti")
+	e.endStruct()
+	return e.b
+}
+
+// WriteFile writes the columns as a table to filename.
+func WriteFile(filename string, columns []Column, opts ...Option) error {
+	var b bytes.Buffer
+	if err := Write(&b, columns, opts...); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filename, b.Bytes(), 0644)
+}
@@ -0,0 +1,90 @@
+package parquet
+
+import "encoding/binary"
+
+// Thrift compact protocol types.
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// encoder writes the subset of the Thrift compact protocol used by the
+// Parquet footer and page headers.
+type encoder struct {
+	b    []byte
+	last []int16 // last field id of each open struct
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.b = append(e.b, tmp[:n]...)
+}
+
+func (e *encoder) zigzag(v int64) {
+	e.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (e *encoder) fieldHeader(id int16, typ byte) {
+	last := &e.last[len(e.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		e.b = append(e.b, byte(d)<<4|typ)
+	} else {
+		e.b = append(e.b, typ)
+		e.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (e *encoder) beginStruct() { e.last = append(e.last, 0) }
+
+func (e *encoder) endStruct() {
+	e.b = append(e.b, 0)
+	e.last = e.last[:len(e.last)-1]
+}
+
+func (e *encoder) i32(id int16, v int32) {
+	e.fieldHeader(id, tI32)
+	e.zigzag(int64(v))
+}
+
+func (e *encoder) i64(id int16, v int64) {
+	e.fieldHeader(id, tI64)
+	e.zigzag(v)
+}
+
+func (e *encoder) str(id int16, s string) {
+	e.fieldHeader(id, tBinary)
+	e.uvarint(uint64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// structField starts a nested struct field; close it with endStruct.
+func (e *encoder) structField(id int16) {
+	e.fieldHeader(id, tStruct)
+	e.beginStruct()
+}
+
+// list starts a list field of n elements of type typ. Struct elements are
+// written with beginStruct and endStruct.
+func (e *encoder) list(id int16, typ byte, n int) {
+	e.fieldHeader(id, tList)
+	if n < 15 {
+		e.b = append(e.b, byte(n)<<4|typ)
+	} else {
+		e.b = append(e.b, 0xf0|typ)
+		e.uvarint(uint64(n))
+	}
+}
+
+// listI32 and listStr write the elements of lists of i32 and strings.
+func (e *encoder) listI32(v int32) { e.zigzag(int64(v)) }
+
+func (e *encoder) listStr(s string) {
+	e.uvarint(uint64(len(s)))
+	e.b = append(e.b, s...)
+}
@@ -0,0 +1,407 @@
+// parrec contains a reader of Philips PAR/REC exports (PAR versions 4, 4.1,
+// and 4.2): a text header (.PAR) describing every slice and the raw slices
+// (.REC).
+
+package parrec
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scaling selects how stored pixel values (PV) are converted, using the
+// rescale slope (RS), rescale intercept (RI), and scale slope (SS) of each
+// slice.
+type Scaling int
+
+const (
+	// ScaleDisplay gives the values shown on the scanner, PV*RS + RI.
+	ScaleDisplay Scaling = iota
+	// ScaleFloatingPoint gives the Philips floating-point values,
+	// (PV*RS + RI) / (RS*SS), which are comparable between scans.
+	ScaleFloatingPoint
+)
+
+// Columns of the image information table.
+const (
+	colSlice       = 0
+	colEcho        = 1
+	colDynamic     = 2
+	colPhase       = 3
+	colType        = 4
+	colSequence    = 5
+	colIndex       = 6
+	colBits        = 7
+	colReconX      = 9
+	colReconY      = 10
+	colIntercept   = 11
+	colSlope       = 12
+	colScaleSlope  = 13
+	colThickness   = 22
+	colGap         = 23
+	colOrientation = 25
+	colSpacingX    = 28
+	colSpacingY    = 29
+	colBValue      = 35 // version 4.1
+	colGradient    = 36 // version 4.1
+	colLabel       = 41 // version 4.2
+	minColumns     = 35 // version 4
+)
+
+// Slice orientations.
+const (
+	transverse = 1
+	sagittal   = 2
+	coronal    = 3
+)
+
+// Header is a parsed PAR file.
+type Header struct {
+	General map[string]string // general information, by lowercase name
+	Slices  [][]float64       // image information, one row per slice
+}
+
+// general returns a general information value by the start of its name.
+func (h *Header) general(prefix string) string {
+	for k, v := range h.General {
+		if strings.HasPrefix(k, prefix) {
+			return v
+		}
+	}
+	return ""
+}
+
+// generalFloats returns the numbers of a general information value.
+func (h *Header) generalFloats(prefix string) []float64 {
+	var v []float64
+	for _, f := range strings.Fields(h.general(prefix)) {
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil
+		}
+		v = append(v, x)
+	}
+	return v
+}
+
+// ReadHeader parses a PAR file.
+func ReadHeader(name string) (*Header, error) {
+	return ReadHeaderFS(util.Local, name)
+}
+
+// ReadHeaderFS is ReadHeader for a file of fsys.
+func ReadHeaderFS(fsys fs.FS, name string) (*Header, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &Header{General: map[string]string{}}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "."):
+			i := strings.Index(line, ":")
+			if i < 0 {
+				continue
+			}
+			key := strings.ToLower(strings.Join(strings.Fields(line[1:i]), " "))
+			h.General[key] = strings.TrimSpace(line[i+1:])
+		default:
+			fields := strings.Fields(line)
+			row := make([]float64, len(fields))
+			for i, f := range fields {
+				if row[i], err = strconv.ParseFloat(f, 64); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w: invalid number %q", name, n, nifti1.ErrInvalidHeader, f)
+				}
+			}
+			if len(row) < minColumns {
+				return nil, fmt.Errorf("%s:%d: %w: %d columns, expected at least %d (PAR version 4 or later)",
+					name, n, nifti1.ErrUnsupported, len(row), minColumns)
+			}
+			if len(h.Slices) > 0 && len(row) != len(h.Slices[0]) {
+				return nil, fmt.Errorf("%s:%d: %w: %d columns, expected %d", name, n, nifti1.ErrInvalidHeader, len(row), len(h.Slices[0]))
+			}
+			h.Slices = append(h.Slices, row)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(h.Slices) == 0 {
+		return nil, fmt.Errorf("%s: %w: no image information", name, nifti1.ErrInvalidHeader)
+	}
+	return h, nil
+}
+
+// IsPAR reports whether name is a PAR or REC file.
+func IsPAR(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".par" || ext == ".rec"
+}
+
+// pairNames returns the names of the PAR and REC files of either, matching
+// the case of the extension.
+func pairNames(name string) (string, string) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	par, rec := ".par", ".rec"
+	if ext == strings.ToUpper(ext) {
+		par, rec = ".PAR", ".REC"
+	}
+	return base + par, base + rec
+}
+
+// volumeKey identifies the volume of a slice.
+type volumeKey [8]float64
+
+func keyOf(row []float64) volumeKey {
+	k := volumeKey{row[colDynamic], row[colPhase], row[colEcho], row[colType], row[colSequence]}
+	if len(row) > colGradient {
+		k[5], k[6] = row[colBValue], row[colGradient]
+	}
+	if len(row) > colLabel {
+		k[7] = row[colLabel]
+	}
+	return k
+}
+
+// ReadFile reads a PAR/REC pair, given the name of either file, as an image
+// with the slices of each dynamic, cardiac phase, echo, image type, and
+// diffusion direction as a volume, sorted in that order. If every slice has
+// the same scaling, the stored values are kept with scl_slope and
+// scl_inter; otherwise they are scaled to float32.
+func ReadFile(name string, scaling Scaling) (*nifti1.Image, error) {
+	return ReadFS(util.Local, name, scaling)
+}
+
+// ReadFS is ReadFile for files of fsys.
+func ReadFS(fsys fs.FS, name string, scaling Scaling) (*nifti1.Image, error) {
+	parName, recName := pairNames(name)
+	h, err := ReadHeaderFS(fsys, parName)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := fs.ReadFile(fsys, recName)
+	if err != nil {
+		return nil, err
+	}
+
+	first := h.Slices[0]
+	nx, ny, bits := int(first[colReconX]), int(first[colReconY]), int(first[colBits])
+	datatype := nifti1.DTInt16
+	switch bits {
+	case 8:
+		datatype = nifti1.DTUint8
+	case 16:
+	case 32:
+		datatype = nifti1.DTFloat32
+	default:
+		return nil, fmt.Errorf("%s: %w pixel size of %d bits", parName, nifti1.ErrUnsupported, bits)
+	}
+	size := bits / 8
+	sliceLen := nx * ny * size
+
+	// Group the slices into volumes.
+	volumes := map[volumeKey][]int{}
+	var keys []volumeKey
+	nz := 0
+	for i, row := range h.Slices {
+		if int(row[colReconX]) != nx || int(row[colReconY]) != ny || int(row[colBits]) != bits {
+			return nil, fmt.Errorf("%s: slice %d is %dx%d with %d bits, expected %dx%d with %d bits",
+				parName, i+1, int(row[colReconX]), int(row[colReconY]), int(row[colBits]), nx, ny, bits)
+		}
+		k := keyOf(row)
+		if _, ok := volumes[k]; !ok {
+			keys = append(keys, k)
+		}
+		volumes[k] = append(volumes[k], i)
+		if s := int(row[colSlice]); s > nz {
+			nz = s
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		for n := range keys[i] {
+			if keys[i][n] != keys[j][n] {
+				return keys[i][n] < keys[j][n]
+			}
+		}
+		return false
+	})
+	for _, k := range keys {
+		if len(volumes[k]) != nz {
+			return nil, fmt.Errorf("%s: %w: volume %v has %d slices, expected %d",
+				parName, nifti1.ErrInvalidHeader, k, len(volumes[k]), nz)
+		}
+	}
+
+	// Per-slice scaling.
+	uniform := true
+	slopeInter := func(row []float64) (float64, float64) {
+		rs, ri, ss := row[colSlope], row[colIntercept], row[colScaleSlope]
+		if scaling == ScaleFloatingPoint && rs != 0 && ss != 0 {
+			return 1 / ss, ri / (rs * ss)
+		}
+		return rs, ri
+	}
+	slope0, inter0 := slopeInter(first)
+	for _, row := range h.Slices {
+		if s, i := slopeInter(row); s != slope0 || i != inter0 {
+			uniform = false
+		}
+	}
+
+	nvol := len(keys)
+	data := make([]byte, nvol*nz*sliceLen)
+	for v, k := range keys {
+		for _, i := range volumes[k] {
+			row := h.Slices[i]
+			z, index := int(row[colSlice])-1, int(row[colIndex])
+			if z < 0 || (index+1)*sliceLen > len(rec) {
+				return nil, fmt.Errorf("%s: %w slice %d of %s", recName, nifti1.ErrTruncated, index, parName)
+			}
+			copy(data[(v*nz+z)*sliceLen:], rec[index*sliceLen:(index+1)*sliceLen])
+		}
+	}
+
+	dims := []int{nx, ny, nz}
+	if nvol > 1 {
+		dims = append(dims, nvol)
+	}
+	affine, err := h.affine(nx, ny, nz)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", parName, err)
+	}
+	img, err := nifti1.NewImage(datatype, dims, affine, nifti1.XformScannerAnat)
+	if err != nil {
+		return nil, err
+	}
+	img.Data = data
+
+	if uniform {
+		img.SclSlope, img.SclInter = slope0, inter0
+	} else {
+		values, err := img.Float64s()
+		if err != nil {
+			return nil, err
+		}
+		n := nx * ny
+		for v, k := range keys {
+			for _, i := range volumes[k] {
+				z := int(h.Slices[i][colSlice]) - 1
+				s, c := slopeInter(h.Slices[i])
+				for j := (v*nz + z) * n; j < (v*nz+z+1)*n; j++ {
+					values[j] = values[j]*s + c
+				}
+			}
+		}
+		if err := img.SetFloat32Data(values); err != nil {
+			return nil, err
+		}
+	}
+
+	if tr := h.generalFloats("repetition time"); len(tr) > 0 && nvol > 1 {
+		img.Dt = tr[0] / 1000
+		img.PixDim[4] = img.Dt
+	}
+	if desc := h.general("protocol name"); desc != "" {
+		if len(desc) > 79 {
+			desc = desc[:79]
+		}
+		img.Descrip = desc
+	}
+
+	log.WithFields(log.Fields{
+		"file":    parName,
+		"dims":    dims,
+		"uniform": uniform,
+	}).Debug("Read PAR/REC")
+
+	return img, nil
+}
+
+// affine returns the voxel-to-RAS affine of the slices. The scanner's
+// patient coordinates are posterior, superior, left (PSL): the voxel axes
+// are permuted by slice orientation, rotated by the angulation, and shifted
+// by the off-centre of the middle slice.
+func (h *Header) affine(nx, ny, nz int) ([4][4]float64, error) {
+	first := h.Slices[0]
+	zooms := [3]float64{first[colSpacingX], first[colSpacingY], first[colThickness] + first[colGap]}
+	ang := h.generalFloats("angulation midslice")
+	off := h.generalFloats("off centre midslice")
+	if len(ang) != 3 || len(off) != 3 {
+		return [4][4]float64{}, fmt.Errorf("%w: missing angulation or off centre", nifti1.ErrInvalidHeader)
+	}
+
+	var perm [3][3]float64 // voxel axes to PSL
+	switch int(first[colOrientation]) {
+	case transverse:
+		perm = [3][3]float64{{0, 1, 0}, {0, 0, 1}, {1, 0, 0}}
+	case sagittal:
+		perm = [3][3]float64{{1, 0, 0}, {0, -1, 0}, {0, 0, -1}}
+	case coronal:
+		perm = [3][3]float64{{0, 0, 1}, {0, -1, 0}, {1, 0, 0}}
+	default:
+		return [4][4]float64{}, fmt.Errorf("%w slice orientation %v", nifti1.ErrUnsupported, first[colOrientation])
+	}
+
+	// Rotations about P (ap), S (fh), and L (rl), applied in that order.
+	rad := math.Pi / 180
+	ca, sa := math.Cos(ang[0]*rad), math.Sin(ang[0]*rad)
+	cf, sf := math.Cos(ang[1]*rad), math.Sin(ang[1]*rad)
+	cr, sr := math.Cos(ang[2]*rad), math.Sin(ang[2]*rad)
+	rx := [3][3]float64{{1, 0, 0}, {0, ca, -sa}, {0, sa, ca}}
+	ry := [3][3]float64{{cf, 0, sf}, {0, 1, 0}, {-sf, 0, cf}}
+	rz := [3][3]float64{{cr, -sr, 0}, {sr, cr, 0}, {0, 0, 1}}
+	rot := mul3(rx, mul3(ry, rz))
+	m := mul3(rot, perm)
+
+	// Voxel to PSL, with the center of the grid at the off-centre.
+	center := [3]float64{float64(nx-1) / 2, float64(ny-1) / 2, float64(nz-1) / 2}
+	var psl [3][4]float64
+	for i := 0; i < 3; i++ {
+		psl[i][3] = off[i]
+		for j := 0; j < 3; j++ {
+			psl[i][j] = m[i][j] * zooms[j]
+			psl[i][3] -= m[i][j] * zooms[j] * center[j]
+		}
+	}
+
+	// PSL to RAS: R = -L, A = -P, S = S.
+	var affine [4][4]float64
+	affine[0] = [4]float64{-psl[2][0], -psl[2][1], -psl[2][2], -psl[2][3]}
+	affine[1] = [4]float64{-psl[0][0], -psl[0][1], -psl[0][2], -psl[0][3]}
+	affine[2] = [4]float64{psl[1][0], psl[1][1], psl[1][2], psl[1][3]}
+	affine[3][3] = 1
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 4; j++ {
+			affine[i][j] += 0 // no negative zeros
+		}
+	}
+	return affine, nil
+}
+
+func mul3(a, b [3][3]float64) [3][3]float64 {
+	var c [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				c[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return c
+}
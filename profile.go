@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startProfile starts profiling a command, of kind cpu, mem, or trace, to
+// the file name (gonifti-<command>.<kind>.pprof, or .trace, if empty). The
+// returned function stops profiling and writes the file.
+func startProfile(kind, name, command string) (func() error, error) {
+	if name == "" {
+		name = fmt.Sprintf("gonifti-%s.%s.pprof", command, kind)
+		if kind == "trace" {
+			name = fmt.Sprintf("gonifti-%s.trace", command)
+		}
+	}
+	if kind != "cpu" && kind != "mem" && kind != "trace" {
+		return nil, usageError(fmt.Sprintf("unknown -profile %q (cpu, mem, or trace)", kind))
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "cpu":
+		err = pprof.StartCPUProfile(f)
+	case "trace":
+		err = trace.Start(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() error {
+		switch kind {
+		case "cpu":
+			pprof.StopCPUProfile()
+		case "trace":
+			trace.Stop()
+		case "mem":
+			// Every allocation of the command, by the call stack that made
+			// it; "go tool pprof -sample_index=inuse_space" shows the live
+			// heap at the end instead.
+			runtime.GC()
+			if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"kind":   kind,
+			"output": name,
+		}).Info("Wrote profile")
+		return nil
+	}, nil
+}
@@ -0,0 +1,186 @@
+package qc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/numeric"
+	"github.com/kaczmarj/gonifti/segment"
+)
+
+// AnatOptions configures Anat.
+type AnatOptions struct {
+	// Mask is the brain mask. If nil, it is estimated with
+	// segment.QuickBet.
+	Mask []bool
+	// T2 marks images in which white matter is the darkest tissue, as in
+	// T2-weighted images, rather than the brightest.
+	T2 bool
+	// Margin is the number of voxels between the head and the background,
+	// to keep the blurred edge of the head and its ringing out of the
+	// noise estimate. 0 means 3.
+	Margin int
+}
+
+// Tissue classes of Regions.Tissue.
+const (
+	TissueNone = iota
+	TissueCSF
+	TissueGM
+	TissueWM
+)
+
+// Regions are the regions of interest of an anatomical image.
+type Regions struct {
+	// Background is the air around the head, without voxels that are
+	// exactly zero, which are filled in, as by defacing or resampling,
+	// rather than measured noise.
+	Background []bool
+	// Brain is the brain mask.
+	Brain []bool
+	// Tissue holds the TissueCSF, TissueGM, or TissueWM class of the voxels
+	// of the brain, from three-class Otsu thresholds of their intensities,
+	// and TissueNone elsewhere.
+	Tissue []int
+}
+
+// AnatRegions selects the regions of a 3D anatomical image. The head is
+// the largest component above the Otsu threshold of the whole image, with
+// holes filled, and the background is what is farther than the margin from
+// it.
+func AnatRegions(values []float64, dims [3]int, opts AnatOptions) (*Regions, error) {
+	if len(values) != dims[0]*dims[1]*dims[2] {
+		return nil, errors.New("values do not match the grid dimensions")
+	}
+	head, err := segment.OtsuMask(values)
+	if err != nil {
+		return nil, fmt.Errorf("estimating the head: %v", err)
+	}
+	head = segment.FillHoles(segment.LargestComponent(head, dims), dims)
+
+	brain := opts.Mask
+	if brain == nil {
+		if brain, err = segment.QuickBet(values, dims, segment.QuickBetOptions{Erode: 2}); err != nil {
+			return nil, fmt.Errorf("estimating a brain mask: %v", err)
+		}
+	} else if len(brain) != len(values) {
+		return nil, errors.New("the brain mask does not match the grid dimensions")
+	}
+	if count(brain) == 0 {
+		return nil, errors.New("the brain mask is empty")
+	}
+
+	margin := opts.Margin
+	if margin == 0 {
+		margin = 3
+	}
+	near := segment.Dilate(head, dims, margin)
+	// The brain is also kept out in case a given mask reaches past the
+	// head.
+	nearBrain := segment.Dilate(brain, dims, margin)
+	background := make([]bool, len(values))
+	for i, v := range values {
+		background[i] = !near[i] && !nearBrain[i] && v != 0 && !math.IsNaN(v)
+	}
+
+	var inBrain []float64
+	for i, in := range brain {
+		if in && !math.IsNaN(values[i]) {
+			inBrain = append(inBrain, values[i])
+		}
+	}
+	thresholds, err := segment.MultiOtsu(inBrain, 3)
+	if err != nil {
+		return nil, fmt.Errorf("classifying tissues: %v", err)
+	}
+	classes := segment.Classify(values, thresholds)
+	tissue := make([]int, len(values))
+	for i, in := range brain {
+		if !in || math.IsNaN(values[i]) {
+			continue
+		}
+		// Classes go from dark to bright: CSF, GM, WM in T1-weighted
+		// images and WM, GM, CSF in T2-weighted ones.
+		c := classes[i]
+		if opts.T2 {
+			c = 2 - c
+		}
+		tissue[i] = TissueCSF + c
+	}
+	return &Regions{Background: background, Brain: brain, Tissue: tissue}, nil
+}
+
+// rayleighFactor corrects the standard deviation of the background of a
+// magnitude image, which is Rayleigh distributed, to that of the Gaussian
+// noise of the underlying signal: sqrt(2 / (4 - pi)).
+var rayleighFactor = math.Sqrt(2 / (4 - math.Pi))
+
+// Anat computes the quality metrics of a 3D anatomical image, after MRIQC,
+// from the regions of AnatRegions:
+//
+//   - snr: the mean over the standard deviation in the brain;
+//   - snr_wm: the same in white matter;
+//   - snrd: the mean in the brain over the noise estimated from the
+//     background (Dietrich et al., 2007);
+//   - cnr: the difference of the white and gray matter means over the root
+//     of the sum of their variances and that of the noise;
+//   - cjv: the coefficient of joint variation of white and gray matter, the
+//     sum of their standard deviations over the difference of their means;
+//   - fber: the mean energy in the brain over the mean energy of the
+//     background.
+//
+// The means and standard deviations of the regions, and their sizes, are
+// reported alongside.
+func Anat(values []float64, dims [3]int, opts AnatOptions) ([]Metric, error) {
+	r, err := AnatRegions(values, dims, opts)
+	if err != nil {
+		return nil, err
+	}
+	gm := make([]bool, len(values))
+	wm := make([]bool, len(values))
+	for i, c := range r.Tissue {
+		gm[i] = c == TissueGM
+		wm[i] = c == TissueWM
+	}
+	brainMean, brainSD, nBrain := maskStats(values, r.Brain)
+	bgMean, bgSD, nBackground := maskStats(values, r.Background)
+	gmMean, gmSD, _ := maskStats(values, gm)
+	wmMean, wmSD, _ := maskStats(values, wm)
+	noise := bgSD * rayleighFactor
+	contrast := math.Abs(wmMean - gmMean)
+
+	return []Metric{
+		{"snr", brainMean / brainSD, "mean over standard deviation in the brain"},
+		{"snr_wm", wmMean / wmSD, "mean over standard deviation in white matter"},
+		{"snrd", brainMean / noise, "mean in the brain over the background noise (Dietrich)"},
+		{"cnr", contrast / math.Sqrt(gmSD*gmSD+wmSD*wmSD+noise*noise), "white-gray matter contrast-to-noise ratio"},
+		{"cjv", (gmSD + wmSD) / contrast, "coefficient of joint variation of white and gray matter"},
+		{"fber", meanEnergy(values, r.Brain) / meanEnergy(values, r.Background), "brain-to-background energy ratio"},
+		{"brain_mean", brainMean, "mean in the brain"},
+		{"brain_sd", brainSD, "standard deviation in the brain"},
+		{"gm_mean", gmMean, "mean in gray matter"},
+		{"wm_mean", wmMean, "mean in white matter"},
+		{"background_mean", bgMean, "mean in the background"},
+		{"background_sd", bgSD, "standard deviation in the background"},
+		{"brain_voxels", float64(nBrain), "voxels in the brain mask"},
+		{"background_voxels", float64(nBackground), "voxels in the background"},
+	}, nil
+}
+
+// meanEnergy returns the mean of the squared values in mask, or NaN if it
+// is empty.
+func meanEnergy(values []float64, mask []bool) float64 {
+	var sum numeric.Accumulator
+	n := 0
+	for i, in := range mask {
+		if in && !math.IsNaN(values[i]) {
+			sum.AddProduct(values[i], values[i])
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return sum.Sum() / float64(n)
+}
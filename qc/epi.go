@@ -0,0 +1,160 @@
+package qc
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+	"github.com/kaczmarj/gonifti/segment"
+	"github.com/kaczmarj/gonifti/temporal"
+)
+
+// EPIOptions configures EPI.
+type EPIOptions struct {
+	// Mask is the brain mask. If nil, it is estimated from the mean volume
+	// with segment.QuickBet.
+	Mask []bool
+	// SpikeZ and SliceZ are the thresholds of temporal.DetectSpikes (for
+	// both the global signal and DVARS) and temporal.SliceSignal.
+	SpikeZ, SliceZ float64
+}
+
+// GhostMask returns the N/2 ghost of mask: the mask shifted by half the
+// field of view along the phase-encoding axis (0, 1, or 2), where
+// Nyquist ghosts of EPI images fall, without the voxels of the mask itself.
+func GhostMask(mask []bool, dims [3]int, axis int) []bool {
+	ghost := make([]bool, len(mask))
+	n := dims[axis]
+	for i, in := range mask {
+		if !in {
+			continue
+		}
+		p := [3]int{i % dims[0], i / dims[0] % dims[1], i / (dims[0] * dims[1])}
+		p[axis] = (p[axis] + n/2) % n
+		j := p[0] + dims[0]*(p[1]+dims[1]*p[2])
+		if !mask[j] {
+			ghost[j] = true
+		}
+	}
+	return ghost
+}
+
+// TemporalStats returns the temporal mean and standard deviation of each
+// voxel of a series of volumes of nxyz values, one after the other.
+func TemporalStats(values []float64, nxyz int) (mean, sd []float64) {
+	nt := len(values) / nxyz
+	mean = make([]float64, nxyz)
+	sd = make([]float64, nxyz)
+	for v := 0; v < nxyz; v++ {
+		var sum, ss numeric.Accumulator
+		for t := 0; t < nt; t++ {
+			sum.Add(values[t*nxyz+v])
+		}
+		mean[v] = sum.Sum() / float64(nt)
+		for t := 0; t < nt; t++ {
+			d := values[t*nxyz+v] - mean[v]
+			ss.AddProduct(d, d)
+		}
+		if nt > 1 {
+			sd[v] = math.Sqrt(ss.Sum() / float64(nt-1))
+		}
+	}
+	return mean, sd
+}
+
+// EPI computes the quality metrics of a 4D EPI series:
+//
+//   - gsr: the ghost-to-signal ratio, the mean in the N/2 ghost of the
+//     brain less the mean of the rest of the background, over the mean in
+//     the brain, with the phase-encoding axis from dim_info (y if unset);
+//   - tsnr: the median over the brain of the temporal mean over the
+//     temporal standard deviation of each voxel;
+//   - dvars_mean, outlier_volumes, and outlier_slices: the mean DVARS and
+//     the counts of volumes and slices flagged by temporal.DetectSpikes and
+//     temporal.SliceSignal.
+func EPI(img *nifti1.Image, opts EPIOptions) ([]Metric, error) {
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	nxyz := dims[0] * dims[1] * dims[2]
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	nt := len(values) / nxyz
+	if nt < 3 {
+		return nil, fmt.Errorf("EPI metrics require at least 3 volumes, got %d", nt)
+	}
+
+	mean, sd := TemporalStats(values, nxyz)
+
+	mask := opts.Mask
+	if mask == nil {
+		if mask, err = segment.QuickBet(mean, dims, segment.QuickBetOptions{Erode: 1}); err != nil {
+			return nil, fmt.Errorf("estimating a brain mask: %v", err)
+		}
+	} else if len(mask) != nxyz {
+		return nil, fmt.Errorf("mask: %w", nifti1.ErrGridMismatch)
+	}
+	if count(mask) == 0 {
+		return nil, fmt.Errorf("the brain mask is empty")
+	}
+
+	axis := 1
+	if img.PhaseDim >= 1 && img.PhaseDim <= 3 {
+		axis = img.PhaseDim - 1
+	}
+	ghost := GhostMask(mask, dims, axis)
+	// The background leaves a margin around the brain and its ghost, whose
+	// edges blur into them.
+	near := segment.Dilate(mask, dims, 2)
+	nearGhost := segment.Dilate(ghost, dims, 2)
+	background := make([]bool, nxyz)
+	for i := range background {
+		background[i] = !near[i] && !nearGhost[i]
+	}
+	signal, _, _ := maskStats(mean, mask)
+	ghostMean, _, _ := maskStats(mean, ghost)
+	bgMean, _, _ := maskStats(mean, background)
+
+	var tsnr []float64
+	for v, in := range mask {
+		if in && sd[v] > 0 {
+			tsnr = append(tsnr, mean[v]/sd[v])
+		}
+	}
+
+	spikes, err := temporal.DetectSpikes(img, mask, opts.SpikeZ, opts.SpikeZ)
+	if err != nil {
+		return nil, err
+	}
+	var dvars numeric.Accumulator
+	outlierVolumes := 0
+	for t := 0; t < nt; t++ {
+		if t > 0 {
+			dvars.Add(spikes.DVARS[t])
+		}
+		if spikes.Outliers[t] {
+			outlierVolumes++
+		}
+	}
+	slices, err := temporal.SliceSignal(img, mask, opts.SliceZ)
+	if err != nil {
+		return nil, err
+	}
+	outlierSlices := 0
+	for _, o := range slices.Outliers {
+		if o {
+			outlierSlices++
+		}
+	}
+
+	axes := "xyz"
+	return []Metric{
+		{"gsr", (ghostMean - bgMean) / signal, fmt.Sprintf("ghost-to-signal ratio along %c, the phase-encoding axis", axes[axis])},
+		{"tsnr", median(tsnr), "median temporal SNR in the brain"},
+		{"dvars_mean", dvars.Sum() / float64(nt-1), "mean DVARS in the brain"},
+		{"outlier_volumes", float64(outlierVolumes), fmt.Sprintf("volumes with a global signal or DVARS |z| above %g", opts.SpikeZ)},
+		{"outlier_slices", float64(outlierSlices), fmt.Sprintf("slices of volumes with a robust |z| above %g", opts.SliceZ)},
+		{"brain_voxels", float64(count(mask)), "voxels in the brain mask"},
+	}, nil
+}
@@ -0,0 +1,105 @@
+// qc computes image quality metrics for first-pass review of anatomical
+// and functional (EPI) images, after those of MRIQC: signal-to-noise and
+// contrast-to-noise ratios, ghosting, and temporal stability, each a
+// single number per image.
+// https://mriqc.readthedocs.io/en/latest/measures.html
+
+package qc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// Metric is a named quality measure.
+type Metric struct {
+	Name        string
+	Value       float64 // NaN if it cannot be computed
+	Description string
+}
+
+// MarshalJSON writes a metric as {"name", "value", "description"}, with a
+// null value for NaN, which JSON cannot represent.
+func (m Metric) MarshalJSON() ([]byte, error) {
+	var v interface{} = m.Value
+	if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+		v = nil
+	}
+	return json.Marshal(struct {
+		Name        string      `json:"name"`
+		Value       interface{} `json:"value"`
+		Description string      `json:"description"`
+	}{m.Name, v, m.Description})
+}
+
+// WriteTSV writes metrics as a table with the columns metric, value, and
+// description.
+func WriteTSV(w io.Writer, metrics []Metric) error {
+	var b strings.Builder
+	b.WriteString("metric\tvalue\tdescription\n")
+	for _, m := range metrics {
+		value := bids.NA
+		if !math.IsNaN(m.Value) {
+			value = strconv.FormatFloat(m.Value, 'g', 6, 64)
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\n", m.Name, value, m.Description)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// maskStats returns the mean and standard deviation of the values in mask,
+// and the number of them. Both are NaN for an empty mask.
+func maskStats(values []float64, mask []bool) (mean, sd float64, n int) {
+	var sum numeric.Accumulator
+	for i, in := range mask {
+		if in && !math.IsNaN(values[i]) {
+			sum.Add(values[i])
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN(), math.NaN(), 0
+	}
+	mean = sum.Sum() / float64(n)
+	var ss numeric.Accumulator
+	for i, in := range mask {
+		if in && !math.IsNaN(values[i]) {
+			ss.AddProduct(values[i]-mean, values[i]-mean)
+		}
+	}
+	if n > 1 {
+		sd = math.Sqrt(ss.Sum() / float64(n-1))
+	}
+	return mean, sd, n
+}
+
+// median returns the median of x, which it sorts, or NaN if x is empty.
+func median(x []float64) float64 {
+	if len(x) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(x)
+	if len(x)%2 == 1 {
+		return x[len(x)/2]
+	}
+	return (x[len(x)/2-1] + x[len(x)/2]) / 2
+}
+
+func count(mask []bool) int {
+	n := 0
+	for _, in := range mask {
+		if in {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,260 @@
+// raw wraps raw binary voxel dumps, such as the output of custom
+// reconstruction code, and NumPy arrays into NIfTI-1 images, with their
+// layout and geometry described by a JSON sidecar:
+//
+//	{
+//	  "data": "recon.bin",
+//	  "dims": [128, 128, 64, 40],
+//	  "datatype": "float32",
+//	  "affine": [[-2, 0, 0, 127], [0, 2, 0, -127], [0, 0, 2.5, -80], [0, 0, 0, 1]],
+//	  "repetition_time": 2
+//	}
+
+package raw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/npy"
+	log "github.com/sirupsen/logrus"
+)
+
+// Description is the layout and geometry of a raw file.
+type Description struct {
+	// Data is the name of the raw file, relative to the description. If
+	// empty, it is the name of the description with the extension .raw.
+	Data string `json:"data,omitempty"`
+	// Offset is the number of bytes before the voxels, such as a header
+	// of the reconstruction code.
+	Offset int64 `json:"offset,omitempty"`
+	// Dims are the dimensions, x first, as in dim[1..7].
+	Dims []int `json:"dims"`
+	// DataType is a NIfTI datatype, by name, such as "int16" or
+	// "float32", or by code.
+	DataType string `json:"datatype"`
+	// ByteOrder is "little", the default, or "big". Big-endian data are
+	// kept as they are, in a big-endian image.
+	ByteOrder string `json:"byte_order,omitempty"`
+	// Order is "F", the default, if x varies fastest, as in NIfTI files
+	// and Fortran arrays, or "C" if the last of the dims does, as in a
+	// NumPy array of shape dims.
+	Order string `json:"order,omitempty"`
+	// Affine is the voxel-to-world transform in mm, as 3 or 4 rows. If
+	// unset, it is a scaling by Spacing.
+	Affine [][]float64 `json:"affine,omitempty"`
+	// Spacing is the voxel size in mm, 1 by default, used without Affine.
+	Spacing []float64 `json:"spacing,omitempty"`
+	// XForm is the NIFTI_XFORM_* code of the affine, such as "scanner_anat",
+	// the default, or "mni_152".
+	XForm string `json:"xform,omitempty"`
+	// RepetitionTime is the time between volumes in seconds.
+	RepetitionTime float64 `json:"repetition_time,omitempty"`
+	// SclSlope and SclInter scale the stored values, as in the header.
+	SclSlope float64 `json:"scl_slope,omitempty"`
+	SclInter float64 `json:"scl_inter,omitempty"`
+	// Descrip is stored in the descrip field of the header.
+	Descrip string `json:"descrip,omitempty"`
+}
+
+// ReadDescription reads a description from a JSON file.
+func ReadDescription(name string) (Description, error) {
+	var d Description
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return d, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d); err != nil {
+		return d, fmt.Errorf("%s: %v", name, err)
+	}
+	return d, nil
+}
+
+// ReadFile reads the raw file of the description in the named JSON file
+// into an image.
+func ReadFile(name string) (*nifti1.Image, error) {
+	d, err := ReadDescription(name)
+	if err != nil {
+		return nil, err
+	}
+	dataName := d.Data
+	switch {
+	case dataName == "":
+		dataName = strings.TrimSuffix(name, filepath.Ext(name)) + ".raw"
+	case !filepath.IsAbs(dataName):
+		dataName = filepath.Join(filepath.Dir(name), dataName)
+	}
+	b, err := ioutil.ReadFile(dataName)
+	if err != nil {
+		return nil, err
+	}
+	img, err := d.Image(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dataName, err)
+	}
+	return img, nil
+}
+
+// FromArray builds the image of a NumPy array of shape (nx, ny, nz, ...),
+// as written by "gonifti tonpy" or nibabel's get_fdata, with the geometry
+// and scaling of a description, whose layout fields (Data, Offset, Dims,
+// DataType, ByteOrder, and Order) must be unset as the array gives them.
+func FromArray(a npy.Array, d Description) (*nifti1.Image, error) {
+	if d.Data != "" || d.Offset != 0 || d.Dims != nil || d.DataType != "" || d.ByteOrder != "" || d.Order != "" {
+		return nil, errors.New("the layout of an array comes from the array, not its description")
+	}
+	datatype, order, err := a.DataType()
+	if err != nil {
+		return nil, err
+	}
+	d.Dims = a.Shape
+	d.DataType = strconv.Itoa(datatype)
+	d.ByteOrder = "little"
+	if order == binary.BigEndian {
+		d.ByteOrder = "big"
+	}
+	d.Order = "C"
+	if a.Fortran {
+		d.Order = "F"
+	}
+	return d.Image(a.Data)
+}
+
+// ReadArray reads the array in a .npy file, or the array named key in a
+// .npz file, into an image. Its geometry is read from the description in
+// the file of the same name with the extension .json, if there is one;
+// otherwise the voxels are 1 mm with the identity affine.
+func ReadArray(name, key string) (*nifti1.Image, error) {
+	var a npy.Array
+	var err error
+	if strings.HasSuffix(strings.ToLower(name), ".npz") {
+		a, err = npy.ReadNPZ(name, key)
+	} else {
+		a, err = npy.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d Description
+	sidecar := strings.TrimSuffix(name, filepath.Ext(name)) + ".json"
+	if _, err := os.Stat(sidecar); err == nil {
+		if d, err = ReadDescription(sidecar); err != nil {
+			return nil, err
+		}
+	}
+	img, err := FromArray(a, d)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return img, nil
+}
+
+// Image builds the image of the voxels in b, which start at Offset.
+func (d Description) Image(b []byte) (*nifti1.Image, error) {
+	datatype, err := nifti1.ParseDataType(d.DataType)
+	if err != nil {
+		return nil, err
+	}
+	nbyper, _ := nifti1.DatatypeSize(datatype)
+	if nbyper == 0 {
+		return nil, fmt.Errorf("%w datatype %s", nifti1.ErrUnsupported, d.DataType)
+	}
+	if len(d.Dims) < 1 || len(d.Dims) > 7 {
+		return nil, fmt.Errorf("number of dimensions must be in [1, 7], got %d", len(d.Dims))
+	}
+	var order binary.ByteOrder
+	switch strings.ToLower(d.ByteOrder) {
+	case "", "little":
+		order = binary.LittleEndian
+	case "big":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("byte order %q must be little or big", d.ByteOrder)
+	}
+	fortran := true
+	switch strings.ToUpper(d.Order) {
+	case "", "F":
+	case "C":
+		fortran = false
+	default:
+		return nil, fmt.Errorf("order %q must be F or C", d.Order)
+	}
+	xform := nifti1.XformScannerAnat
+	if d.XForm != "" {
+		if xform, err = nifti1.ParseXform(d.XForm); err != nil {
+			return nil, err
+		}
+		if xform == nifti1.XformUnknown {
+			return nil, fmt.Errorf("xform must not be unknown")
+		}
+	}
+	affine, err := d.affine()
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := nifti1.NewImage(datatype, d.Dims, affine, xform)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(img.NVox * img.NByPer)
+	if d.Offset < 0 || int64(len(b)) < d.Offset+size {
+		return nil, fmt.Errorf("%w: %d bytes of voxels after offset %d, expected %d", nifti1.ErrTruncated, int64(len(b))-d.Offset, d.Offset, size)
+	}
+	if extra := int64(len(b)) - d.Offset - size; extra > 0 {
+		log.WithFields(log.Fields{
+			"bytes": extra,
+		}).Warn("Ignoring bytes after the voxels")
+	}
+	data := b[d.Offset : d.Offset+size]
+	if fortran {
+		img.Data = append([]byte(nil), data...)
+	} else {
+		img.Data = npy.Reorder(data, d.Dims, nbyper, false)
+	}
+	img.ByteOrder = order
+	if d.RepetitionTime > 0 {
+		img.Dt, img.PixDim[4] = d.RepetitionTime, d.RepetitionTime
+	}
+	img.SclSlope, img.SclInter = d.SclSlope, d.SclInter
+	img.Descrip = d.Descrip
+	return img, nil
+}
+
+// affine returns the affine of the description.
+func (d Description) affine() ([4][4]float64, error) {
+	affine := [4][4]float64{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+	if len(d.Affine) > 0 {
+		if len(d.Affine) != 3 && len(d.Affine) != 4 {
+			return affine, fmt.Errorf("affine has %d rows, expected 3 or 4", len(d.Affine))
+		}
+		for i, row := range d.Affine {
+			if len(row) != 4 {
+				return affine, fmt.Errorf("row %d of the affine has %d values, expected 4", i+1, len(row))
+			}
+			copy(affine[i][:], row)
+		}
+		return affine, nil
+	}
+	if len(d.Spacing) > 3 {
+		return affine, fmt.Errorf("spacing has %d values, expected at most 3", len(d.Spacing))
+	}
+	for i, s := range d.Spacing {
+		if !(s > 0) {
+			return affine, fmt.Errorf("spacing %g must be positive", s)
+		}
+		affine[i][i] = s
+	}
+	return affine, nil
+}
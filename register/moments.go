@@ -0,0 +1,106 @@
+package register
+
+import (
+	"math"
+
+	"github.com/kaczmarj/gonifti/linalg"
+)
+
+// Moments summarize where a volume's weight lies in world coordinates.
+type Moments struct {
+	// Mass is the sum of the weights: the number of voxels of a mask, or
+	// the total intensity.
+	Mass float64
+	// Center is the weighted mean position in mm.
+	Center [3]float64
+	// Axes holds the principal axes as unit column vectors, the direction
+	// of largest spread first.
+	Axes [3][3]float64
+	// SD is the weighted standard deviation in mm along each axis.
+	SD [3]float64
+}
+
+// Moments returns the moments of the volume with each voxel weighted by
+// weight of its value. It reports false if the total weight is not
+// positive. Weights that are NaN or not positive are skipped.
+func (v Volume) Moments(weight func(value float64) float64) (Moments, bool) {
+	var m Moments
+	nxyz := len(v.Values)
+	w := make([]float64, nxyz)
+	var mean [3]float64
+	for n, x := range v.Values {
+		if w[n] = weight(x); !(w[n] > 0) {
+			w[n] = 0
+			continue
+		}
+		p := v.index(n)
+		for a := range mean {
+			mean[a] += w[n] * p[a]
+		}
+		m.Mass += w[n]
+	}
+	if !(m.Mass > 0) {
+		return m, false
+	}
+	for a := range mean {
+		mean[a] /= m.Mass
+	}
+
+	// The covariance in voxels, taken to world coordinates by the linear
+	// part A of the affine as A C Aᵀ.
+	var c [3][3]float64
+	for n := range w {
+		if w[n] == 0 {
+			continue
+		}
+		p := v.index(n)
+		for r := 0; r < 3; r++ {
+			for s := 0; s < 3; s++ {
+				c[r][s] += w[n] * (p[r] - mean[r]) * (p[s] - mean[s])
+			}
+		}
+	}
+	var world [3][3]float64
+	for r := 0; r < 3; r++ {
+		for s := 0; s < 3; s++ {
+			for i := 0; i < 3; i++ {
+				for j := 0; j < 3; j++ {
+					world[r][s] += v.Affine[r][i] * c[i][j] / m.Mass * v.Affine[s][j]
+				}
+			}
+		}
+	}
+	variances, axes := linalg.SymEigen3(world)
+	for a := range variances {
+		m.SD[a] = math.Sqrt(math.Max(variances[a], 0))
+	}
+	m.Axes = axes
+	m.Center = linalg.ApplyAffine(v.Affine, mean)
+	return m, true
+}
+
+// Centroid returns the moments of the voxels above threshold, each
+// weighted equally.
+func (v Volume) Centroid(threshold float64) (Moments, bool) {
+	return v.Moments(func(x float64) float64 {
+		if x > threshold {
+			return 1
+		}
+		return 0
+	})
+}
+
+// CenterOfMass returns the moments of the volume weighted by its positive
+// intensities.
+func (v Volume) CenterOfMass() (Moments, bool) {
+	return v.Moments(func(x float64) float64 { return x })
+}
+
+// index returns the voxel indices of the n-th voxel.
+func (v Volume) index(n int) [3]float64 {
+	return [3]float64{
+		float64(n % v.Dims[0]),
+		float64(n / v.Dims[0] % v.Dims[1]),
+		float64(n / (v.Dims[0] * v.Dims[1])),
+	}
+}
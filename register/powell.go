@@ -0,0 +1,116 @@
+package register
+
+import "math"
+
+// golden is the golden ratio, used to bracket and shrink line searches.
+const golden = 1.618033988749895
+
+// powell minimizes f from x0 with Powell's direction set method. Parameters
+// should be scaled so that 1 is a sensible step for each. It returns the
+// minimum found, its value, and the number of evaluations.
+// Refer to Numerical Recipes, section 10.5.
+func powell(f func([]float64) float64, x0 []float64, tol float64, maxIter int) ([]float64, float64, int) {
+	n := len(x0)
+	evals := 0
+	cost := func(x []float64) float64 {
+		evals++
+		return f(x)
+	}
+
+	x := append([]float64(nil), x0...)
+	dirs := make([][]float64, n)
+	for i := range dirs {
+		dirs[i] = make([]float64, n)
+		dirs[i][i] = 1
+	}
+	fx := cost(x)
+	for iter := 0; iter < maxIter; iter++ {
+		fstart := fx
+		xstart := append([]float64(nil), x...)
+		biggest, ibig := 0.0, 0
+		for i, d := range dirs {
+			prev := fx
+			x, fx = lineMin(cost, x, d, tol)
+			if prev-fx > biggest {
+				biggest, ibig = prev-fx, i
+			}
+		}
+		if 2*(fstart-fx) <= tol*(math.Abs(fstart)+math.Abs(fx))+1e-12 {
+			break
+		}
+
+		// Try the average direction of this iteration.
+		dnew := make([]float64, n)
+		xe := make([]float64, n)
+		for i := range x {
+			dnew[i] = x[i] - xstart[i]
+			xe[i] = x[i] + dnew[i]
+		}
+		fe := cost(xe)
+		if fe < fstart {
+			t := 2*(fstart-2*fx+fe)*sq(fstart-fx-biggest) - biggest*sq(fstart-fe)
+			if t < 0 {
+				x, fx = lineMin(cost, x, dnew, tol)
+				dirs[ibig] = dirs[n-1]
+				dirs[n-1] = dnew
+			}
+		}
+	}
+	return x, fx, evals
+}
+
+func sq(x float64) float64 { return x * x }
+
+// lineMin minimizes f along d from x by bracketing and golden section
+// search, returning the new point and its value.
+func lineMin(f func([]float64) float64, x, d []float64, tol float64) ([]float64, float64) {
+	at := func(s float64) []float64 {
+		p := make([]float64, len(x))
+		for i := range x {
+			p[i] = x[i] + s*d[i]
+		}
+		return p
+	}
+	g := func(s float64) float64 { return f(at(s)) }
+
+	// Bracket a minimum: g(b) <= g(a) and g(b) <= g(c).
+	a, b := 0.0, 1.0
+	fa, fb := g(a), g(b)
+	if fb > fa {
+		a, b, fb = b, a, fa
+	}
+	c := b + golden*(b-a)
+	fc := g(c)
+	for n := 0; fc < fb && n < 20; n++ {
+		a, b, fb = b, c, fc
+		c = b + golden*(b-a)
+		fc = g(c)
+	}
+	if fc < fb {
+		return at(c), fc
+	}
+
+	// Golden section search in [a, c] around b.
+	if a > c {
+		a, c = c, a
+	}
+	const r = 1 / golden
+	for n := 0; c-a > tol && n < 40; n++ {
+		if b-a > c-b {
+			t := b - (1-r)*(b-a)
+			if ft := g(t); ft < fb {
+				c, b, fb = b, t, ft
+			} else {
+				a = t
+			}
+		} else {
+			t := b + (1-r)*(c-b)
+			if ft := g(t); ft < fb {
+				a, b, fb = b, t, ft
+			} else {
+				c = t
+			}
+		}
+	}
+	return at(b), fb
+}
@@ -0,0 +1,436 @@
+// register contains intensity-based linear registration: a rigid (6
+// parameter) or affine (12 parameter) transform is found by minimizing the
+// negative correlation or normalized mutual information between a fixed
+// and a moving volume with Powell's method, from coarse to fine
+// resolution.
+
+package register
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/nifti1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Volume is a 3D volume with its voxel-to-world affine in mm.
+type Volume struct {
+	Values []float64
+	Dims   [3]int
+	Affine [4][4]float64
+}
+
+// FromImage returns volume t of an image with scaled values.
+func FromImage(img *nifti1.Image, t int) (Volume, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	if t < 0 || (t+1)*nxyz > img.NVox {
+		return Volume{}, fmt.Errorf("volume index %d out of range", t)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return Volume{}, err
+	}
+	return Volume{
+		Values: values[t*nxyz : (t+1)*nxyz],
+		Dims:   [3]int{img.Nx, img.Ny, img.Nz},
+		Affine: img.Affine(),
+	}, nil
+}
+
+// Cost functions.
+const (
+	CostCorrelation = "corr" // same modality
+	CostNMI         = "nmi"  // any modality
+)
+
+// Options configure Affine.
+type Options struct {
+	DOF        int    // 6 (rigid) or 12 (affine)
+	Cost       string // CostCorrelation or CostNMI
+	Levels     []int  // downsampling factors, coarse to fine
+	Bins       int    // histogram bins of CostNMI
+	MaxSamples int    // maximum number of fixed voxels sampled per evaluation
+}
+
+// DefaultOptions are the options of a rigid registration by correlation.
+var DefaultOptions = Options{
+	DOF:        6,
+	Cost:       CostCorrelation,
+	Levels:     []int{4, 2, 1},
+	Bins:       32,
+	MaxSamples: 40000,
+}
+
+// Result is the outcome of a registration.
+type Result struct {
+	// Matrix maps world coordinates of the fixed volume to world
+	// coordinates of the moving volume, which is what resampling the moving
+	// volume onto the fixed grid needs.
+	Matrix [4][4]float64
+	Params []float64 // translations (mm), rotations (rad), scales, shears
+	Cost   float64
+	Evals  int
+}
+
+// steps are the parameter changes that are treated as unit steps: 1 mm,
+// 1 degree, and 1% scaling and shear.
+var steps = []float64{1, 1, 1, math.Pi / 180, math.Pi / 180, math.Pi / 180, 0.01, 0.01, 0.01, 0.01, 0.01, 0.01}
+
+// ParamsMatrix returns the world-to-world matrix of the parameters, with
+// rotation, scaling, and shear about center:
+// x' = R S H (x - center) + center + t.
+func ParamsMatrix(p []float64, center [3]float64) [4][4]float64 {
+	var q [12]float64
+	copy(q[:], p)
+	cx, sx := math.Cos(q[3]), math.Sin(q[3])
+	cy, sy := math.Cos(q[4]), math.Sin(q[4])
+	cz, sz := math.Cos(q[5]), math.Sin(q[5])
+	rx := [4][4]float64{{1, 0, 0, 0}, {0, cx, -sx, 0}, {0, sx, cx, 0}, {0, 0, 0, 1}}
+	ry := [4][4]float64{{cy, 0, sy, 0}, {0, 1, 0, 0}, {-sy, 0, cy, 0}, {0, 0, 0, 1}}
+	rz := [4][4]float64{{cz, -sz, 0, 0}, {sz, cz, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+	s := [4][4]float64{{1 + q[6], 0, 0, 0}, {0, 1 + q[7], 0, 0}, {0, 0, 1 + q[8], 0}, {0, 0, 0, 1}}
+	h := [4][4]float64{{1, q[9], q[10], 0}, {0, 1, q[11], 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+	a := linalg.MulAffine(rz, linalg.MulAffine(ry, linalg.MulAffine(rx, linalg.MulAffine(s, h))))
+	for i := 0; i < 3; i++ {
+		a[i][3] = center[i] + q[i]
+		for j := 0; j < 3; j++ {
+			a[i][3] -= a[i][j] * center[j]
+		}
+	}
+	return a
+}
+
+// center returns the world coordinates of the center of the grid.
+func (v Volume) center() [3]float64 {
+	return linalg.ApplyAffine(v.Affine, [3]float64{
+		float64(v.Dims[0]-1) / 2, float64(v.Dims[1]-1) / 2, float64(v.Dims[2]-1) / 2,
+	})
+}
+
+// centerOfMass returns the intensity-weighted center in world coordinates,
+// or the center of the grid if there is no positive intensity.
+func (v Volume) centerOfMass() [3]float64 {
+	if m, ok := v.CenterOfMass(); ok {
+		return m.Center
+	}
+	return v.center()
+}
+
+// Downsample averages blocks of f voxels along each axis.
+func (v Volume) Downsample(f int) Volume {
+	if f <= 1 {
+		return v
+	}
+	var d [3]int
+	for i := range d {
+		d[i] = (v.Dims[i] + f - 1) / f
+	}
+	sum := make([]float64, d[0]*d[1]*d[2])
+	count := make([]float64, len(sum))
+	for k := 0; k < v.Dims[2]; k++ {
+		for j := 0; j < v.Dims[1]; j++ {
+			for i := 0; i < v.Dims[0]; i++ {
+				x := v.Values[i+v.Dims[0]*(j+v.Dims[1]*k)]
+				if math.IsNaN(x) {
+					continue
+				}
+				n := i/f + d[0]*(j/f+d[1]*(k/f))
+				sum[n] += x
+				count[n]++
+			}
+		}
+	}
+	for n := range sum {
+		if count[n] > 0 {
+			sum[n] /= count[n]
+		}
+	}
+	// Voxel (0, 0, 0) of the new grid is the center of the first block.
+	scale := [4][4]float64{
+		{float64(f), 0, 0, float64(f-1) / 2},
+		{0, float64(f), 0, float64(f-1) / 2},
+		{0, 0, float64(f), float64(f-1) / 2},
+		{0, 0, 0, 1},
+	}
+	return Volume{Values: sum, Dims: d, Affine: linalg.MulAffine(v.Affine, scale)}
+}
+
+// Sample interpolates the volume trilinearly at fractional voxel indices.
+// It reports false outside the grid.
+func (v Volume) Sample(p [3]float64) (float64, bool) {
+	var i0 [3]int
+	var f [3]float64
+	for n := range p {
+		if p[n] < 0 || p[n] > float64(v.Dims[n]-1) {
+			return 0, false
+		}
+		i0[n] = int(p[n])
+		if i0[n] == v.Dims[n]-1 && v.Dims[n] > 1 {
+			i0[n]--
+		}
+		f[n] = p[n] - float64(i0[n])
+	}
+	sum := 0.0
+	for c := 0; c < 8; c++ {
+		w := 1.0
+		var idx [3]int
+		for n := 0; n < 3; n++ {
+			idx[n] = i0[n]
+			if c&(1<<uint(n)) != 0 {
+				if v.Dims[n] == 1 {
+					w = 0
+					break
+				}
+				idx[n]++
+				w *= f[n]
+			} else {
+				w *= 1 - f[n]
+			}
+		}
+		if w != 0 {
+			sum += w * v.Values[idx[0]+v.Dims[0]*(idx[1]+v.Dims[1]*idx[2])]
+		}
+	}
+	return sum, true
+}
+
+// Resample returns the values of moving on the grid of fixed (dims and
+// affine), where matrix maps fixed world coordinates to moving world
+// coordinates. Voxels outside moving are 0.
+func Resample(moving Volume, dims [3]int, affine [4][4]float64, matrix [4][4]float64) ([]float64, error) {
+	inv, err := linalg.InvertAffine(moving.Affine)
+	if err != nil {
+		return nil, fmt.Errorf("moving affine: %w", err)
+	}
+	vox := linalg.MulAffine(inv, linalg.MulAffine(matrix, affine))
+	out := make([]float64, dims[0]*dims[1]*dims[2])
+	for k, n := 0, 0; k < dims[2]; k++ {
+		for j := 0; j < dims[1]; j++ {
+			for i := 0; i < dims[0]; i++ {
+				p := linalg.ApplyAffine(vox, [3]float64{float64(i), float64(j), float64(k)})
+				if x, ok := moving.Sample(p); ok {
+					out[n] = x
+				}
+				n++
+			}
+		}
+	}
+	return out, nil
+}
+
+// costFunc returns the cost of a world-to-world matrix between a fixed and
+// a moving volume at one resolution.
+type costFunc func(matrix [4][4]float64) float64
+
+// newCost prepares the samples of the fixed volume and returns the cost
+// function.
+func newCost(fixed, moving Volume, o Options) (costFunc, error) {
+	inv, err := linalg.InvertAffine(moving.Affine)
+	if err != nil {
+		return nil, fmt.Errorf("moving affine: %w", err)
+	}
+
+	// Sample fixed voxels with a stride that keeps at most MaxSamples.
+	n := len(fixed.Values)
+	stride := 1
+	if o.MaxSamples > 0 && n > o.MaxSamples {
+		stride = (n + o.MaxSamples - 1) / o.MaxSamples
+	}
+	var idx [][3]float64
+	var fv []float64
+	for k, m := 0, 0; k < fixed.Dims[2]; k++ {
+		for j := 0; j < fixed.Dims[1]; j++ {
+			for i := 0; i < fixed.Dims[0]; i, m = i+1, m+1 {
+				if m%stride != 0 || math.IsNaN(fixed.Values[m]) {
+					continue
+				}
+				idx = append(idx, [3]float64{float64(i), float64(j), float64(k)})
+				fv = append(fv, fixed.Values[m])
+			}
+		}
+	}
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("fixed volume has no voxels")
+	}
+	minOverlap := len(idx) / 10
+
+	fLo, fHi := valueRange(fixed.Values)
+	mLo, mHi := valueRange(moving.Values)
+	bins := o.Bins
+	if bins < 2 {
+		bins = DefaultOptions.Bins
+	}
+	binOf := func(x, lo, hi float64) int {
+		if hi <= lo {
+			return 0
+		}
+		b := int((x - lo) / (hi - lo) * float64(bins))
+		if b >= bins {
+			b = bins - 1
+		} else if b < 0 {
+			b = 0
+		}
+		return b
+	}
+
+	mv := make([]float64, len(idx))
+	ok := make([]bool, len(idx))
+	return func(matrix [4][4]float64) float64 {
+		vox := linalg.MulAffine(inv, linalg.MulAffine(matrix, fixed.Affine))
+		overlap := 0
+		for s, p := range idx {
+			mv[s], ok[s] = moving.Sample(linalg.ApplyAffine(vox, p))
+			if ok[s] {
+				overlap++
+			}
+		}
+		if overlap < minOverlap || overlap < 2 {
+			return 1 // worse than any overlapping alignment
+		}
+
+		if o.Cost == CostNMI {
+			joint := make([]float64, bins*bins)
+			for s := range idx {
+				if ok[s] {
+					joint[binOf(fv[s], fLo, fHi)*bins+binOf(mv[s], mLo, mHi)]++
+				}
+			}
+			ha, hb, hab := entropies(joint, bins, float64(overlap))
+			if hab == 0 {
+				return 1
+			}
+			return -(ha + hb) / hab
+		}
+
+		var sa, sb, saa, sbb, sab float64
+		for s := range idx {
+			if !ok[s] {
+				continue
+			}
+			a, b := fv[s], mv[s]
+			sa += a
+			sb += b
+			saa += a * a
+			sbb += b * b
+			sab += a * b
+		}
+		m := float64(overlap)
+		cov := sab - sa*sb/m
+		va, vb := saa-sa*sa/m, sbb-sb*sb/m
+		if va <= 0 || vb <= 0 {
+			return 1
+		}
+		return -cov / math.Sqrt(va*vb)
+	}, nil
+}
+
+// entropies returns the marginal and joint entropies of a joint histogram.
+func entropies(joint []float64, bins int, total float64) (float64, float64, float64) {
+	pa := make([]float64, bins)
+	pb := make([]float64, bins)
+	hab := 0.0
+	for i := 0; i < bins; i++ {
+		for j := 0; j < bins; j++ {
+			c := joint[i*bins+j]
+			if c == 0 {
+				continue
+			}
+			p := c / total
+			hab -= p * math.Log(p)
+			pa[i] += p
+			pb[j] += p
+		}
+	}
+	ha, hb := 0.0, 0.0
+	for i := 0; i < bins; i++ {
+		if pa[i] > 0 {
+			ha -= pa[i] * math.Log(pa[i])
+		}
+		if pb[i] > 0 {
+			hb -= pb[i] * math.Log(pb[i])
+		}
+	}
+	return ha, hb, hab
+}
+
+func valueRange(v []float64) (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, x := range v {
+		if x < lo {
+			lo = x
+		}
+		if x > hi {
+			hi = x
+		}
+	}
+	return lo, hi
+}
+
+// Affine registers moving to fixed. The translation is initialized by
+// aligning the centers of mass; each level then refines the parameters of
+// the previous one on volumes downsampled by its factor.
+func Affine(fixed, moving Volume, o Options) (Result, error) {
+	if o.DOF != 6 && o.DOF != 12 {
+		return Result{}, fmt.Errorf("%w degrees of freedom %d (6 or 12)", nifti1.ErrUnsupported, o.DOF)
+	}
+	if o.Cost != CostCorrelation && o.Cost != CostNMI {
+		return Result{}, fmt.Errorf("%w cost %q", nifti1.ErrUnsupported, o.Cost)
+	}
+	levels := o.Levels
+	if len(levels) == 0 {
+		levels = []int{1}
+	}
+
+	center := fixed.center()
+	fc, mc := fixed.centerOfMass(), moving.centerOfMass()
+	params := make([]float64, o.DOF)
+	for i := 0; i < 3; i++ {
+		params[i] = mc[i] - fc[i]
+	}
+
+	var res Result
+	for _, f := range levels {
+		if f < 1 {
+			return Result{}, fmt.Errorf("invalid level %d", f)
+		}
+		cost, err := newCost(fixed.Downsample(f), moving.Downsample(f), o)
+		if err != nil {
+			return Result{}, err
+		}
+		// Optimize in units of steps, with coarser translation steps at
+		// coarser levels.
+		scale := make([]float64, o.DOF)
+		x0 := make([]float64, o.DOF)
+		for i := range scale {
+			scale[i] = steps[i]
+			if i < 3 {
+				scale[i] *= float64(f)
+			}
+			x0[i] = params[i] / scale[i]
+		}
+		p := make([]float64, o.DOF)
+		x, fx, evals := powell(func(x []float64) float64 {
+			for i := range x {
+				p[i] = x[i] * scale[i]
+			}
+			return cost(ParamsMatrix(p, center))
+		}, x0, 1e-4, 20)
+		for i := range x {
+			params[i] = x[i] * scale[i]
+		}
+		res.Cost = fx
+		res.Evals += evals
+
+		log.WithFields(log.Fields{
+			"level": f,
+			"cost":  fx,
+			"evals": evals,
+		}).Debug("Registered level")
+	}
+
+	res.Params = params
+	res.Matrix = ParamsMatrix(params, center)
+	return res, nil
+}
@@ -0,0 +1,163 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// Sweep selects what changes between the frames of an animation.
+type Sweep int
+
+// Animation sweeps.
+const (
+	SweepTime   Sweep = iota // a fixed slice across volumes
+	SweepSlices              // a fly-through across slices of one volume
+)
+
+// AnimationOptions configures the frames of an animation.
+type AnimationOptions struct {
+	Axis  Axis
+	Index int // slice index for SweepTime
+	T     int // volume index for SweepSlices
+	Sweep Sweep
+	Scale int // integer upsampling factor; values below 1 mean 1
+	// Window is shared by all frames so brightness does not flicker. If
+	// Window[0] >= Window[1], the range over all frames is used.
+	Window [2]float64
+}
+
+// Frames returns the slices making up an animation.
+func Frames(img *nifti1.Image, opts AnimationOptions) ([]*Slice, error) {
+	var n int
+	switch opts.Sweep {
+	case SweepTime:
+		n = img.NVox / (img.Nx * img.Ny * img.Nz)
+	case SweepSlices:
+		n = [3]int{img.Nx, img.Ny, img.Nz}[opts.Axis]
+	default:
+		return nil, errors.New("invalid sweep")
+	}
+
+	frames := make([]*Slice, n)
+	for i := range frames {
+		index, t := opts.Index, i
+		if opts.Sweep == SweepSlices {
+			index, t = i, opts.T
+		}
+		s, err := NewSlice(img, opts.Axis, index, t, SliceOptions{Mapping: Gray8, Window: opts.Window})
+		if err != nil {
+			return nil, err
+		}
+		frames[i] = s
+	}
+
+	if opts.Window[0] >= opts.Window[1] {
+		w := [2]float64{math.Inf(1), math.Inf(-1)}
+		for _, s := range frames {
+			r := s.Range()
+			w[0] = math.Min(w[0], r[0])
+			w[1] = math.Max(w[1], r[1])
+		}
+		for _, s := range frames {
+			s.opts.Window = w
+		}
+	}
+
+	return frames, nil
+}
+
+// grayPalette is a 256-level grayscale palette.
+var grayPalette = func() color.Palette {
+	p := make(color.Palette, 256)
+	for i := range p {
+		p[i] = color.Gray{Y: uint8(i)}
+	}
+	return p
+}()
+
+// paletted converts a slice to a paletted image, upsampled by scale.
+func paletted(s *Slice, scale int) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	b := s.Bounds()
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale), grayPalette)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			g := uint8(math.Round(s.Intensity(x, y) * 0xff))
+			for dy := 0; dy < scale; dy++ {
+				row := p.Pix[(y*scale+dy)*p.Stride:]
+				for dx := 0; dx < scale; dx++ {
+					row[x*scale+dx] = g
+				}
+			}
+		}
+	}
+	return p
+}
+
+// WriteGIF encodes the frames as an animated GIF that loops forever, showing
+// fps frames per second.
+func WriteGIF(w io.Writer, frames []*Slice, fps float64, scale int) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to animate")
+	}
+	if fps <= 0 {
+		return errors.New("frames per second must be positive")
+	}
+	// GIF delays are in hundredths of a second.
+	delay := int(math.Round(100 / fps))
+
+	anim := &gif.GIF{}
+	for _, s := range frames {
+		anim.Image = append(anim.Image, paletted(s, scale))
+		anim.Delay = append(anim.Delay, delay)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// WriteMP4 encodes the frames as an H.264 MP4 file. There is no pure-Go
+// encoder, so frames are piped as PNGs to ffmpeg, which must be on the PATH.
+func WriteMP4(filename string, frames []*Slice, fps float64, scale int) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to animate")
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return errors.New("MP4 export requires ffmpeg on the PATH; use a .gif output instead")
+	}
+
+	var buf bytes.Buffer
+	for _, s := range frames {
+		if err := png.Encode(&buf, paletted(s, scale)); err != nil {
+			return err
+		}
+	}
+
+	// yuv420p needs even dimensions.
+	args := []string{"-y", "-loglevel", "error",
+		"-f", "image2pipe", "-framerate", strconv.FormatFloat(fps, 'g', -1, 64), "-i", "-",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-pix_fmt", "yuv420p", "-f", "mp4"}
+	// ffmpeg writes to a temporary file, so that an interrupted encoding
+	// leaves no partial output.
+	return util.WriteAtomicName(filename, func(tmp string) error {
+		cmd := exec.Command(ffmpeg, append(args, tmp)...)
+		cmd.Stdin = &buf
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("ffmpeg: %v: %s", err, out)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,171 @@
+package render
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// volume returns the scaled values of volume t.
+func volume(img *nifti1.Image, t int) ([]float64, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	if t < 0 || (t+1)*nxyz > img.NVox {
+		return nil, fmt.Errorf("volume index %d out of range", t)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	return values[t*nxyz : (t+1)*nxyz], nil
+}
+
+// MIP returns the maximum intensity projection of volume t along a voxel
+// axis. The in-plane axes are laid out as in Slice.
+func MIP(img *nifti1.Image, t int, axis Axis) (*Plane, error) {
+	values, err := volume(img, t)
+	if err != nil {
+		return nil, err
+	}
+
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	var u, v int
+	switch axis {
+	case AxisI:
+		u, v = 1, 2
+	case AxisJ:
+		u, v = 0, 2
+	case AxisK:
+		u, v = 0, 1
+	default:
+		return nil, fmt.Errorf("invalid axis %d", axis)
+	}
+
+	p := NewPlane(dims[u], dims[v])
+	for n := range p.Values {
+		p.Values[n] = math.Inf(-1)
+	}
+	var idx [3]int
+	for idx[2] = 0; idx[2] < dims[2]; idx[2]++ {
+		for idx[1] = 0; idx[1] < dims[1]; idx[1]++ {
+			for idx[0] = 0; idx[0] < dims[0]; idx[0]++ {
+				val := values[idx[0]+dims[0]*(idx[1]+dims[1]*idx[2])]
+				n := idx[u] + idx[v]*p.W
+				if val > p.Values[n] {
+					p.Values[n] = val
+				}
+			}
+		}
+	}
+	p.SetRange()
+	return p, nil
+}
+
+// RotatedMIP returns the maximum intensity projection of volume t viewed from
+// a direction given in degrees. With both angles zero, the view is along the
+// k axis as in MIP(img, t, AxisK); azimuth rotates the view about the k axis
+// and elevation tilts it towards the k axis. Rays are cast through the
+// volume in millimeter space and sampled every half voxel with trilinear
+// interpolation.
+func RotatedMIP(img *nifti1.Image, t int, azimuth, elevation float64) (*Plane, error) {
+	values, err := volume(img, t)
+	if err != nil {
+		return nil, err
+	}
+
+	d := img.VoxelSizeMM()
+	for n := range d {
+		if d[n] == 0 {
+			d[n] = 1
+		}
+	}
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	step := math.Min(d[0], math.Min(d[1], d[2]))
+
+	// Half the diagonal of the field of view bounds every ray.
+	var center [3]float64
+	radius := 0.0
+	for n := range center {
+		center[n] = float64(dims[n]-1) * d[n] / 2
+		radius += center[n] * center[n]
+	}
+	radius = math.Sqrt(radius) + step
+	size := int(math.Ceil(2*radius/step)) + 1
+
+	// The view direction and the in-plane axes of the projection.
+	az, el := azimuth*math.Pi/180, elevation*math.Pi/180
+	right := [3]float64{math.Cos(az), math.Sin(az), 0}
+	up := [3]float64{
+		-math.Sin(az) * math.Cos(el),
+		math.Cos(az) * math.Cos(el),
+		math.Sin(el),
+	}
+	// The maximum does not depend on the direction of travel along the ray.
+	ray := [3]float64{
+		up[1]*right[2] - up[2]*right[1],
+		up[2]*right[0] - up[0]*right[2],
+		up[0]*right[1] - up[1]*right[0],
+	}
+
+	p := NewPlane(size, size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			su := (float64(x) - float64(size-1)/2) * step
+			sv := (float64(y) - float64(size-1)/2) * step
+			best := math.Inf(-1)
+			for s := -radius; s <= radius; s += step / 2 {
+				var pos [3]float64
+				for n := range pos {
+					pos[n] = (center[n] + su*right[n] + sv*up[n] + s*ray[n]) / d[n]
+				}
+				if val, ok := trilinear(values, dims, pos); ok && val > best {
+					best = val
+				}
+			}
+			p.Values[x+y*size] = best
+		}
+	}
+	p.SetRange()
+	return p, nil
+}
+
+// trilinear interpolates a volume at fractional voxel indices. It reports
+// false for positions outside the grid.
+func trilinear(values []float64, dims [3]int, pos [3]float64) (float64, bool) {
+	var i0 [3]int
+	var f [3]float64
+	for n := range pos {
+		if pos[n] < 0 || pos[n] > float64(dims[n]-1) {
+			return 0, false
+		}
+		i0[n] = int(pos[n])
+		if i0[n] == dims[n]-1 && dims[n] > 1 {
+			i0[n]--
+		}
+		f[n] = pos[n] - float64(i0[n])
+	}
+
+	sum := 0.0
+	for c := 0; c < 8; c++ {
+		w := 1.0
+		var idx [3]int
+		for n := 0; n < 3; n++ {
+			idx[n] = i0[n]
+			if c&(1<<uint(n)) != 0 {
+				if dims[n] == 1 {
+					w = 0
+					break
+				}
+				idx[n]++
+				w *= f[n]
+			} else {
+				w *= 1 - f[n]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		sum += w * values[idx[0]+dims[0]*(idx[1]+dims[1]*idx[2])]
+	}
+	return sum, true
+}
@@ -0,0 +1,63 @@
+package render
+
+import (
+	"errors"
+	"math"
+)
+
+// Montage tiles n evenly spaced slices of a volume along axis into a plane,
+// columns tiles wide, in reading order from the lowest slice. Each tile is
+// oriented as a Slice, and the gaps of an incomplete last row are NaN,
+// which is drawn black. The window is left for the caller to set.
+func Montage(values []float64, dims [3]int, axis Axis, n, columns int) (*Plane, error) {
+	if len(values) != dims[0]*dims[1]*dims[2] {
+		return nil, errors.New("values do not match the grid dimensions")
+	}
+	if axis < AxisI || axis > AxisK {
+		return nil, errors.New("invalid axis")
+	}
+	if n < 1 || columns < 1 {
+		return nil, errors.New("a montage needs at least one slice and one column")
+	}
+	if n > dims[axis] {
+		n = dims[axis]
+	}
+	if columns > n {
+		columns = n
+	}
+	var u, v int
+	switch axis {
+	case AxisI:
+		u, v = 1, 2
+	case AxisJ:
+		u, v = 0, 2
+	case AxisK:
+		u, v = 0, 1
+	}
+	strides := [3]int{1, dims[0], dims[0] * dims[1]}
+	w, h := dims[u], dims[v]
+	rows := (n + columns - 1) / columns
+
+	p := NewPlane(w*columns, h*rows)
+	for i := range p.Values {
+		p.Values[i] = math.NaN()
+	}
+	for k := 0; k < n; k++ {
+		// Slices are spread over the volume without its first and last,
+		// which are mostly empty.
+		index := (k + 1) * dims[axis] / (n + 1)
+		if n == dims[axis] {
+			index = k
+		}
+		x0 := (k % columns) * w
+		// Rows of the plane count from the bottom, tiles from the top.
+		y0 := (rows - 1 - k/columns) * h
+		base := index * strides[axis]
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				p.Values[x0+x+(y0+y)*p.W] = values[base+x*strides[u]+y*strides[v]]
+			}
+		}
+	}
+	return p, nil
+}
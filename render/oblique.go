@@ -0,0 +1,223 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Slab modes combine the samples across the thickness of a slab.
+const (
+	SlabMean = "mean"
+	SlabMax  = "max" // maximum intensity projection
+	SlabMin  = "min" // minimum intensity projection
+)
+
+// ObliqueOptions defines a plane in world coordinates and how it is
+// sampled. The plane passes through Point with the given Normal; it is
+// viewed from the side the normal points to, with Up towards the top of the
+// view and Up × Normal towards the right.
+type ObliqueOptions struct {
+	Point  [3]float64 // world coordinates in mm of the center of the view
+	Normal [3]float64
+	// Up is a world direction projected into the plane to give the top of
+	// the view. If zero, it is superior (+z), or anterior (+y) for planes
+	// close to axial.
+	Up [3]float64
+	// Width and Height are the extent of the view in mm. If zero, the view
+	// covers the whole image from any direction.
+	Width, Height float64
+	// Spacing is the pixel size in mm, the smallest voxel size by default.
+	Spacing float64
+	// Thickness is the thickness of a slab in mm, whose samples are
+	// combined by Slab; 0 samples the plane alone.
+	Thickness float64
+	Slab      string
+	// Method is the interpolation method, linear by default.
+	Method string
+}
+
+// Oblique returns volume t of the image resampled on an arbitrary plane or
+// slab in world coordinates, using the image's affine. Pixels outside the
+// image are NaN, which is drawn black.
+func Oblique(img *nifti1.Image, t int, o ObliqueOptions) (*Plane, error) {
+	values, err := volume(img, t)
+	if err != nil {
+		return nil, err
+	}
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	normal, ok := unit(o.Normal)
+	if !ok {
+		return nil, errors.New("the normal of the plane must not be zero")
+	}
+	up := o.Up
+	if up == ([3]float64{}) {
+		up = [3]float64{0, 0, 1}
+		if math.Abs(normal[2]) > 0.9 {
+			up = [3]float64{0, 1, 0}
+		}
+	}
+	// Keep the part of up within the plane.
+	d := dot(up, normal)
+	for n := range up {
+		up[n] -= d * normal[n]
+	}
+	if up, ok = unit(up); !ok {
+		return nil, errors.New("the up direction must not be parallel to the normal")
+	}
+	right := cross(up, normal)
+
+	spacing := o.Spacing
+	if spacing == 0 {
+		spacing = math.Inf(1)
+		for _, v := range img.VoxelSizeMM() {
+			if v > 0 {
+				spacing = math.Min(spacing, v)
+			}
+		}
+		if math.IsInf(spacing, 1) {
+			spacing = 1
+		}
+	}
+	if spacing < 0 || o.Width < 0 || o.Height < 0 || o.Thickness < 0 {
+		return nil, fmt.Errorf("spacing, size, and thickness must not be negative")
+	}
+	width, height := o.Width, o.Height
+	if width == 0 || height == 0 {
+		// Twice the distance to the farthest corner covers the image.
+		r := 0.0
+		for c := 0; c < 8; c++ {
+			corner := img.VoxelToWorld(
+				float64((c&1)*(dims[0]-1)), float64((c>>1&1)*(dims[1]-1)), float64((c>>2&1)*(dims[2]-1)))
+			r = math.Max(r, math.Sqrt(dist2(corner, o.Point)))
+		}
+		if width == 0 {
+			width = 2*r + spacing
+		}
+		if height == 0 {
+			height = 2*r + spacing
+		}
+	}
+	w, h := int(math.Round(width/spacing)), int(math.Round(height/spacing))
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	slab := o.Slab
+	if slab == "" {
+		slab = SlabMean
+	}
+	if slab != SlabMean && slab != SlabMax && slab != SlabMin {
+		return nil, fmt.Errorf("%w slab mode %q", nifti1.ErrUnsupported, slab)
+	}
+	// Samples across the slab, at most spacing apart.
+	layers := 1
+	if o.Thickness > 0 {
+		layers = int(math.Ceil(o.Thickness/spacing)) + 1
+	}
+
+	method := o.Method
+	if method == "" {
+		method = interp.Linear
+	}
+	// The grid is clamped so that points up to half a voxel beyond the edge
+	// voxels are sampled; farther points are outside the image.
+	ip, err := interp.New(values, dims, interp.Options{Method: method, Boundary: interp.Clamp})
+	if err != nil {
+		return nil, err
+	}
+
+	// The affine is linear, so the voxel indices of a point are those of the
+	// center plus a combination of the steps along each direction.
+	origin := img.WorldToVoxel(o.Point[0], o.Point[1], o.Point[2])
+	step := func(v [3]float64) [3]float64 {
+		p := img.WorldToVoxel(o.Point[0]+v[0], o.Point[1]+v[1], o.Point[2]+v[2])
+		for n := range p {
+			p[n] -= origin[n]
+		}
+		return p
+	}
+	di, dj, dk := step(right), step(up), step(normal)
+
+	p := NewPlane(w, h)
+	for y := 0; y < h; y++ {
+		sv := (float64(y) - float64(h-1)/2) * spacing
+		for x := 0; x < w; x++ {
+			su := (float64(x) - float64(w-1)/2) * spacing
+			acc, count := 0.0, 0
+			switch slab {
+			case SlabMax:
+				acc = math.Inf(-1)
+			case SlabMin:
+				acc = math.Inf(1)
+			}
+			for l := 0; l < layers; l++ {
+				s := 0.0
+				if layers > 1 {
+					s = (float64(l)/float64(layers-1) - 0.5) * o.Thickness
+				}
+				var pos [3]float64
+				inside := true
+				for n := range pos {
+					pos[n] = origin[n] + su*di[n] + sv*dj[n] + s*dk[n]
+					if pos[n] < -0.5 || pos[n] > float64(dims[n])-0.5 {
+						inside = false
+					}
+				}
+				if !inside {
+					continue
+				}
+				v, _ := ip.Sample(pos)
+				switch slab {
+				case SlabMean:
+					acc += v
+				case SlabMax:
+					acc = math.Max(acc, v)
+				case SlabMin:
+					acc = math.Min(acc, v)
+				}
+				count++
+			}
+			switch {
+			case count == 0:
+				acc = math.NaN()
+			case slab == SlabMean:
+				acc /= float64(count)
+			}
+			p.Values[x+y*w] = acc
+		}
+	}
+	p.SetRange()
+	return p, nil
+}
+
+func unit(v [3]float64) ([3]float64, bool) {
+	n := math.Sqrt(dot(v, v))
+	if n == 0 || math.IsNaN(n) {
+		return v, false
+	}
+	return [3]float64{v[0] / n, v[1] / n, v[2] / n}, true
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func dist2(a, b [3]float64) float64 {
+	d := [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+	return dot(d, d)
+}
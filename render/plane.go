@@ -0,0 +1,112 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// Plane is a computed two-dimensional image, such as a projection or a
+// resampled slice, that satisfies image.Image. Row 0 of Values is the bottom
+// of the image, matching Slice.
+type Plane struct {
+	W, H    int
+	Values  []float64 // W*H values, row by row from the bottom
+	Mapping Mapping
+	// Window is the [low, high] range of values that maps to black and white.
+	Window [2]float64
+}
+
+// NewPlane returns a zero-filled plane. Its window is set by SetRange or by
+// the caller.
+func NewPlane(w, h int) *Plane {
+	return &Plane{W: w, H: h, Values: make([]float64, w*h)}
+}
+
+// Value returns the value at pixel (x, y), where y = 0 is the top row.
+func (p *Plane) Value(x, y int) float64 {
+	return p.Values[x+(p.H-1-y)*p.W]
+}
+
+// Range returns the minimum and maximum finite values in the plane.
+func (p *Plane) Range() [2]float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range p.Values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	if lo > hi {
+		return [2]float64{0, 0}
+	}
+	return [2]float64{lo, hi}
+}
+
+// SetRange sets the window to the range of the plane.
+func (p *Plane) SetRange() {
+	p.Window = p.Range()
+}
+
+// Intensity returns the windowed value at pixel (x, y) in the range [0, 1].
+func (p *Plane) Intensity(x, y int) float64 {
+	v := p.Value(x, y)
+	lo, hi := p.Window[0], p.Window[1]
+	if math.IsNaN(v) || hi <= lo {
+		return 0
+	}
+	return math.Max(0, math.Min(1, (v-lo)/(hi-lo)))
+}
+
+// ColorModel implements image.Image.
+func (p *Plane) ColorModel() color.Model {
+	if p.Mapping == Gray8 {
+		return color.GrayModel
+	}
+	return color.Gray16Model
+}
+
+// Bounds implements image.Image.
+func (p *Plane) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.W, p.H)
+}
+
+// At implements image.Image.
+func (p *Plane) At(x, y int) color.Color {
+	var f float64
+	if x >= 0 && y >= 0 && x < p.W && y < p.H {
+		f = p.Intensity(x, y)
+	}
+	if p.Mapping == Gray8 {
+		return color.Gray{Y: uint8(math.Round(f * 0xff))}
+	}
+	return color.Gray16{Y: uint16(math.Round(f * 0xffff))}
+}
+
+// Upsample returns a copy of the plane enlarged by an integer factor using
+// nearest-neighbour interpolation.
+func (p *Plane) Upsample(scale int) *Plane {
+	if scale <= 1 {
+		return p
+	}
+	q := NewPlane(p.W*scale, p.H*scale)
+	q.Mapping, q.Window = p.Mapping, p.Window
+	for y := 0; y < q.H; y++ {
+		for x := 0; x < q.W; x++ {
+			q.Values[x+y*q.W] = p.Values[x/scale+(y/scale)*p.W]
+		}
+	}
+	return q
+}
+
+// WritePNG encodes an image as a PNG file.
+func WritePNG(filename string, m image.Image) error {
+	return util.WriteAtomic(filename, func(w io.Writer) error {
+		return png.Encode(w, m)
+	})
+}
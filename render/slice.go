@@ -0,0 +1,197 @@
+// render contains methods to display nifti1 images.
+
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Axis is the voxel axis normal to a slice.
+type Axis int
+
+// Slice axes.
+const (
+	AxisI Axis = iota // sagittal for RAS-like data
+	AxisJ             // coronal for RAS-like data
+	AxisK             // axial for RAS-like data
+)
+
+// ParseAxis returns the axis with the name "x", "y", or "z" (or "i", "j",
+// "k").
+func ParseAxis(s string) (Axis, error) {
+	switch s {
+	case "x", "i":
+		return AxisI, nil
+	case "y", "j":
+		return AxisJ, nil
+	case "z", "k":
+		return AxisK, nil
+	}
+	return 0, fmt.Errorf("unknown axis %q", s)
+}
+
+// Mapping selects the color model of a slice.
+type Mapping int
+
+// Gray mappings.
+const (
+	Gray16 Mapping = iota // windowed values mapped to color.Gray16
+	Gray8                 // windowed values mapped to color.Gray
+)
+
+// SliceOptions configures a Slice.
+type SliceOptions struct {
+	Mapping Mapping
+	// Window is the [low, high] range of scaled values that maps to black and
+	// white. If Window[0] >= Window[1], the range of the slice is used.
+	Window [2]float64
+}
+
+// Slice is a two-dimensional view of a volume that satisfies image.Image.
+// Voxels are read directly from the image's data buffer on every call to
+// At, so no copy is made.
+//
+// The first in-plane voxel axis runs left to right and the second runs
+// bottom to top, so that an axial slice of RAS data is shown with anterior
+// at the top.
+type Slice struct {
+	img    *nifti1.Image
+	at     func(i int) float64
+	opts   SliceOptions
+	axis   Axis
+	index  int
+	t      int
+	w, h   int
+	stride [2]int
+	base   int
+}
+
+// NewSlice returns a view of slice index along axis in volume t.
+func NewSlice(img *nifti1.Image, axis Axis, index, t int, opts SliceOptions) (*Slice, error) {
+	at, err := img.Float64Func()
+	if err != nil {
+		return nil, err
+	}
+
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	strides := [3]int{1, img.Nx, img.Nx * img.Ny}
+	nxyz := img.Nx * img.Ny * img.Nz
+	if axis < AxisI || axis > AxisK {
+		return nil, errors.New("invalid axis")
+	}
+	if index < 0 || index >= dims[axis] {
+		return nil, fmt.Errorf("slice index %d out of range [0, %d)", index, dims[axis])
+	}
+	if t < 0 || (t+1)*nxyz > img.NVox {
+		return nil, fmt.Errorf("volume index %d out of range", t)
+	}
+
+	s := &Slice{img: img, at: at, opts: opts, axis: axis, index: index, t: t}
+	var u, v int
+	switch axis {
+	case AxisI:
+		u, v = 1, 2
+	case AxisJ:
+		u, v = 0, 2
+	case AxisK:
+		u, v = 0, 1
+	}
+	s.w, s.h = dims[u], dims[v]
+	s.stride = [2]int{strides[u], strides[v]}
+	s.base = t*nxyz + index*strides[axis]
+
+	if s.opts.Window[0] >= s.opts.Window[1] {
+		s.opts.Window = s.Range()
+	}
+
+	return s, nil
+}
+
+// Window returns the [low, high] range that maps to black and white.
+func (s *Slice) Window() [2]float64 {
+	return s.opts.Window
+}
+
+// Range returns the minimum and maximum scaled values in the slice,
+// ignoring non-finite values.
+func (s *Slice) Range() [2]float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for y := 0; y < s.h; y++ {
+		for x := 0; x < s.w; x++ {
+			v := s.Value(x, y)
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+	}
+	if lo > hi {
+		return [2]float64{0, 0}
+	}
+	return [2]float64{lo, hi}
+}
+
+// offset returns the index into the image data of pixel (x, y).
+func (s *Slice) offset(x, y int) int {
+	return s.base + x*s.stride[0] + (s.h-1-y)*s.stride[1]
+}
+
+// Value returns the scaled voxel value at pixel (x, y).
+func (s *Slice) Value(x, y int) float64 {
+	v := s.at(s.offset(x, y))
+	if slope, inter, ok := s.img.Scaling(); ok {
+		v = slope*v + inter
+	}
+	return v
+}
+
+// Intensity returns the windowed value at pixel (x, y) in the range [0, 1].
+func (s *Slice) Intensity(x, y int) float64 {
+	v := s.Value(x, y)
+	lo, hi := s.opts.Window[0], s.opts.Window[1]
+	if math.IsNaN(v) || hi <= lo {
+		return 0
+	}
+	f := (v - lo) / (hi - lo)
+	return math.Max(0, math.Min(1, f))
+}
+
+// ColorModel implements image.Image.
+func (s *Slice) ColorModel() color.Model {
+	if s.opts.Mapping == Gray8 {
+		return color.GrayModel
+	}
+	return color.Gray16Model
+}
+
+// Bounds implements image.Image.
+func (s *Slice) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.w, s.h)
+}
+
+// At implements image.Image.
+func (s *Slice) At(x, y int) color.Color {
+	if x < 0 || y < 0 || x >= s.w || y >= s.h {
+		if s.opts.Mapping == Gray8 {
+			return color.Gray{}
+		}
+		return color.Gray16{}
+	}
+	f := s.Intensity(x, y)
+	if s.opts.Mapping == Gray8 {
+		return color.Gray{Y: uint8(math.Round(f * 0xff))}
+	}
+	return color.Gray16{Y: uint16(math.Round(f * 0xffff))}
+}
+
+// Axis returns the voxel axis normal to the slice.
+func (s *Slice) Axis() Axis {
+	return s.axis
+}
@@ -0,0 +1,126 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// asciiRamp orders characters from dark to bright.
+const asciiRamp = " .:-=+*#%@"
+
+// sixelLevels is the number of gray levels in the sixel palette.
+const sixelLevels = 16
+
+// Resize returns a grayscale copy of m scaled to w by h pixels with
+// nearest-neighbour sampling.
+func Resize(m image.Image, w, h int) *image.Gray {
+	b := m.Bounds()
+	out := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + y*b.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + x*b.Dx()/w
+			out.SetGray(x, y, color.GrayModel.Convert(m.At(sx, sy)).(color.Gray))
+		}
+	}
+	return out
+}
+
+// WriteBlocks writes m as Unicode upper half blocks with 24-bit ANSI colors.
+// Each character cell shows two pixels, one above the other, so square
+// pixels stay roughly square in most terminal fonts.
+func WriteBlocks(w io.Writer, m *image.Gray) error {
+	bw := bufio.NewWriter(w)
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := m.GrayAt(x, y).Y
+			if y+1 < b.Max.Y {
+				bottom := m.GrayAt(x, y+1).Y
+				fmt.Fprintf(bw, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top, top, top, bottom, bottom, bottom)
+			} else {
+				fmt.Fprintf(bw, "\x1b[38;2;%d;%d;%dm\x1b[49m▀", top, top, top)
+			}
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
+
+// WriteASCII writes m as plain characters from a brightness ramp, for
+// terminals without color. Each character covers one pixel.
+func WriteASCII(w io.Writer, m *image.Gray) error {
+	bw := bufio.NewWriter(w)
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := int(m.GrayAt(x, y).Y)
+			bw.WriteByte(asciiRamp[g*(len(asciiRamp)-1)/0xff])
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// WriteSixel writes m as a DEC sixel image with a 16-level gray palette, for
+// terminals that support sixel graphics (e.g. xterm -ti vt340, mlterm).
+func WriteSixel(w io.Writer, m *image.Gray) error {
+	bw := bufio.NewWriter(w)
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	fmt.Fprintf(bw, "\x1bPq\"1;1;%d;%d", width, height)
+	for c := 0; c < sixelLevels; c++ {
+		pct := c * 100 / (sixelLevels - 1)
+		fmt.Fprintf(bw, "#%d;2;%d;%d;%d", c, pct, pct, pct)
+	}
+
+	level := func(x, y int) int {
+		return int(m.GrayAt(b.Min.X+x, b.Min.Y+y).Y) * (sixelLevels - 1) / 0xff
+	}
+	row := make([]byte, width)
+	for band := 0; band < height; band += 6 {
+		for c := 0; c < sixelLevels; c++ {
+			used := false
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if level(x, band+dy) == c {
+						bits |= 1 << uint(dy)
+					}
+				}
+				row[x] = 63 + bits
+				used = used || bits != 0
+			}
+			if !used {
+				continue
+			}
+			fmt.Fprintf(bw, "#%d", c)
+			writeSixelRun(bw, row)
+			bw.WriteByte('$')
+		}
+		bw.WriteByte('-')
+	}
+	bw.WriteString("\x1b\\\n")
+	return bw.Flush()
+}
+
+// writeSixelRun writes a row of sixel characters with run-length encoding.
+func writeSixelRun(w *bufio.Writer, row []byte) {
+	for x := 0; x < len(row); {
+		n := 1
+		for x+n < len(row) && row[x+n] == row[x] {
+			n++
+		}
+		if n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[x])
+		} else {
+			w.WriteString(strings.Repeat(string(row[x]), n))
+		}
+		x += n
+	}
+}
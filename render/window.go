@@ -0,0 +1,120 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// WindowPreset selects how a display window is computed from the data.
+type WindowPreset int
+
+// Window presets.
+const (
+	WindowFull      WindowPreset = iota // minimum to maximum
+	WindowAuto                          // 2nd to 98th percentile of the non-zero values
+	WindowSymmetric                     // symmetric about zero, for statistical maps
+)
+
+// Percentiles used by WindowAuto.
+const (
+	autoLow  = 2
+	autoHigh = 98
+)
+
+// ParseWindowPreset returns the preset with the name "full", "auto", or
+// "symmetric".
+func ParseWindowPreset(s string) (WindowPreset, error) {
+	switch s {
+	case "full":
+		return WindowFull, nil
+	case "auto":
+		return WindowAuto, nil
+	case "symmetric":
+		return WindowSymmetric, nil
+	}
+	return 0, fmt.Errorf("unknown window preset %q", s)
+}
+
+// String returns the name of the preset.
+func (p WindowPreset) String() string {
+	switch p {
+	case WindowFull:
+		return "full"
+	case WindowAuto:
+		return "auto"
+	case WindowSymmetric:
+		return "symmetric"
+	}
+	return fmt.Sprintf("WindowPreset(%d)", int(p))
+}
+
+// ComputeWindow returns the [low, high] window chosen by a preset for the
+// given values. Non-finite values are ignored. WindowAuto ignores zeros,
+// which are usually background, and WindowSymmetric spans
+// [-max|v|, max|v|] so that zero maps to mid-gray.
+func ComputeWindow(values []float64, preset WindowPreset) [2]float64 {
+	finite := make([]float64, 0, len(values))
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if preset == WindowAuto && v == 0 {
+			continue
+		}
+		finite = append(finite, v)
+	}
+	if len(finite) == 0 {
+		return [2]float64{0, 0}
+	}
+
+	switch preset {
+	case WindowAuto:
+		sort.Float64s(finite)
+		at := func(p float64) float64 {
+			return finite[int(math.Round(p/100*float64(len(finite)-1)))]
+		}
+		lo, hi := at(autoLow), at(autoHigh)
+		if lo < hi {
+			return [2]float64{lo, hi}
+		}
+		return [2]float64{finite[0], finite[len(finite)-1]}
+	case WindowSymmetric:
+		m := 0.0
+		for _, v := range finite {
+			m = math.Max(m, math.Abs(v))
+		}
+		return [2]float64{-m, m}
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range finite {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	return [2]float64{lo, hi}
+}
+
+// ImageWindow returns the window chosen by a preset for volume t of an
+// image, or for all volumes if t is negative.
+func ImageWindow(img *nifti1.Image, t int, preset WindowPreset) ([2]float64, error) {
+	var values []float64
+	var err error
+	if t < 0 {
+		values, err = img.ScaledFloat64s()
+	} else {
+		values, err = volume(img, t)
+	}
+	if err != nil {
+		return [2]float64{}, err
+	}
+	return ComputeWindow(values, preset), nil
+}
+
+// SetWindow sets the window of the plane with a preset and returns it.
+func (p *Plane) SetWindow(preset WindowPreset) [2]float64 {
+	p.Window = ComputeWindow(p.Values, preset)
+	return p.Window
+}
@@ -0,0 +1,318 @@
+// report assembles the quality metrics, slice montages, and intensity
+// histogram of an image into a single self-contained HTML page, with the
+// figures embedded, for a fast first-pass review of a subject without a
+// Python stack.
+
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image/png"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/qc"
+	"github.com/kaczmarj/gonifti/render"
+)
+
+// Options configures New.
+type Options struct {
+	// Subject is the title of the report. If empty, it is the sub- and
+	// ses- entities of a BIDS file name, or the base name of the input.
+	Subject string
+	// Mask is the brain mask, estimated if nil.
+	Mask []bool
+	// T2 is passed to qc.Anat for anatomical images.
+	T2 bool
+	// SpikeZ and SliceZ are passed to qc.EPI for functional images.
+	SpikeZ, SliceZ float64
+	// Slices and Columns are the number of slices of each montage and of
+	// tiles across it. Scale enlarges the montages by an integer factor.
+	Slices, Columns, Scale int
+	// Bins is the number of bins of the histogram.
+	Bins int
+}
+
+// DefaultOptions are the options of the report command.
+var DefaultOptions = Options{SpikeZ: 3, SliceZ: 5, Slices: 12, Columns: 6, Scale: 2, Bins: 64}
+
+// Field is a line of the summary of an image.
+type Field struct {
+	Name, Value string
+}
+
+// Figure is an embedded image.
+type Figure struct {
+	Title, Caption string
+	PNG            []byte
+}
+
+// Src returns the image as a data URI for the src attribute of an img
+// element.
+func (f Figure) Src() template.URL {
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(f.PNG))
+}
+
+// Bar is a bin of a histogram, with its rectangle in a 100 by 100 box whose
+// origin is the top left corner, as in SVG.
+type Bar struct {
+	Lo, Hi     float64
+	Count      int
+	X, Y, W, H float64
+}
+
+// Histogram is the distribution of the values of an image.
+type Histogram struct {
+	Title, Caption string
+	Lo, Hi         float64
+	Total          int
+	Bars           []Bar
+}
+
+// Report is the content of a report, which templates render.
+type Report struct {
+	Subject string
+	Input   string
+	Kind    string // "anat" or "epi"
+	Created time.Time
+	Summary []Field
+	Metrics []qc.Metric
+	Figures []Figure
+	// Histogram is nil if the image has no finite nonzero values.
+	Histogram *Histogram
+}
+
+// New computes the report of an image read from the named input. 4D images
+// are reported as EPI series with qc.EPI and the montages of their temporal
+// mean and standard deviation; others as anatomical images with qc.Anat and
+// the montages of their first volume along each axis.
+func New(img *nifti1.Image, input string, opts Options) (*Report, error) {
+	if opts.Slices < 1 || opts.Columns < 1 || opts.Scale < 1 || opts.Bins < 1 {
+		return nil, fmt.Errorf("slices, columns, scale, and bins must be positive")
+	}
+	r := &Report{
+		Subject: opts.Subject,
+		Input:   input,
+		Created: time.Now().UTC(),
+		Summary: summary(img),
+	}
+	if r.Subject == "" {
+		r.Subject = subject(input)
+	}
+
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	nxyz := dims[0] * dims[1] * dims[2]
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	var shown []float64
+	if img.Nt > 1 {
+		r.Kind = "epi"
+		r.Metrics, err = qc.EPI(img, qc.EPIOptions{Mask: opts.Mask, SpikeZ: opts.SpikeZ, SliceZ: opts.SliceZ})
+		if err != nil {
+			return nil, err
+		}
+		mean, sd := qc.TemporalStats(values, nxyz)
+		for _, f := range []struct {
+			title, caption string
+			values         []float64
+		}{
+			{"Temporal mean", "Axial slices of the mean volume.", mean},
+			{"Temporal standard deviation", "Axial slices of the standard deviation of each voxel over time, where motion, ghosts, and vessels show up.", sd},
+		} {
+			fig, err := montage(f.values, dims, render.AxisK, opts)
+			if err != nil {
+				return nil, err
+			}
+			fig.Title, fig.Caption = f.title, f.caption
+			r.Figures = append(r.Figures, fig)
+		}
+		shown = mean
+	} else {
+		r.Kind = "anat"
+		shown = values[:nxyz]
+		r.Metrics, err = qc.Anat(shown, dims, qc.AnatOptions{Mask: opts.Mask, T2: opts.T2})
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range []struct {
+			title string
+			axis  render.Axis
+		}{
+			{"Axial", render.AxisK},
+			{"Coronal", render.AxisJ},
+			{"Sagittal", render.AxisI},
+		} {
+			fig, err := montage(shown, dims, a.axis, opts)
+			if err != nil {
+				return nil, err
+			}
+			fig.Title = a.title
+			fig.Caption = fmt.Sprintf("%s slices of the image.", a.title)
+			r.Figures = append(r.Figures, fig)
+		}
+	}
+	r.Histogram = histogram(shown, opts.Bins)
+	return r, nil
+}
+
+// Metric returns the metric with a name, or nil if there is none, so that
+// templates can show the metrics they choose:
+//
+//	{{with .Metric "snr"}}{{value .Value}}{{end}}
+func (r *Report) Metric(name string) *qc.Metric {
+	for i := range r.Metrics {
+		if r.Metrics[i].Name == name {
+			return &r.Metrics[i]
+		}
+	}
+	return nil
+}
+
+// Figure returns the figure with a title, or nil if there is none.
+func (r *Report) Figure(title string) *Figure {
+	for i := range r.Figures {
+		if r.Figures[i].Title == title {
+			return &r.Figures[i]
+		}
+	}
+	return nil
+}
+
+// subject returns the title of the report of a file.
+func subject(input string) string {
+	base := filepath.Base(input)
+	if entities, _, _, ok := bids.ParseFilename(base); ok && entities["sub"] != "" {
+		s := "sub-" + entities["sub"]
+		if entities["ses"] != "" {
+			s += " ses-" + entities["ses"]
+		}
+		return s
+	}
+	for _, ext := range []string{".gz", ".nii", ".hdr", ".img"} {
+		base = strings.TrimSuffix(base, ext)
+	}
+	return base
+}
+
+// summary returns the geometry and acquisition fields of an image.
+func summary(img *nifti1.Image) []Field {
+	dims := make([]string, 0, 4)
+	for i := 1; i <= img.NDim && i < len(img.Dim); i++ {
+		dims = append(dims, fmt.Sprint(img.Dim[i]))
+	}
+	fields := []Field{
+		{"Dimensions", strings.Join(dims, " × ")},
+		{"Voxel size", strings.TrimSpace(fmt.Sprintf("%.3g × %.3g × %.3g %s", img.Dx, img.Dy, img.Dz, unitSymbol(img.XYZUnits)))},
+		{"Datatype", nifti1.DataTypeString(img.DataType)},
+	}
+	orientation := img.QFormOrientation()
+	if img.SFormCode > 0 {
+		orientation = img.SFormOrientation()
+	}
+	fields = append(fields, Field{"Orientation", nifti1.OrientationLetters(orientation)})
+	if img.Nt > 1 {
+		fields = append(fields,
+			Field{"Volumes", fmt.Sprint(img.Nt)},
+			Field{"Repetition time", strings.TrimSpace(fmt.Sprintf("%.4g %s", img.Dt, unitSymbol(img.TimeUnits)))})
+	}
+	return fields
+}
+
+// unitSymbol returns the symbol of a NIFTI_UNITS code, or "" if it has
+// none.
+func unitSymbol(code int) string {
+	switch code {
+	case 1:
+		return "m"
+	case 2:
+		return "mm"
+	case 3:
+		return "µm"
+	case 8:
+		return "s"
+	case 16:
+		return "ms"
+	case 24:
+		return "µs"
+	}
+	return ""
+}
+
+// montage draws a montage of a volume with the automatic window.
+func montage(values []float64, dims [3]int, axis render.Axis, opts Options) (Figure, error) {
+	p, err := render.Montage(values, dims, axis, opts.Slices, opts.Columns)
+	if err != nil {
+		return Figure{}, err
+	}
+	p.Mapping = render.Gray8
+	p.SetWindow(render.WindowAuto)
+	var b bytes.Buffer
+	if err := png.Encode(&b, p.Upsample(opts.Scale)); err != nil {
+		return Figure{}, err
+	}
+	return Figure{PNG: b.Bytes()}, nil
+}
+
+// histogram returns the histogram of the finite nonzero values within the
+// automatic window, which leaves out the background and the extreme
+// values that would squeeze the tissue peaks into a few bins.
+func histogram(values []float64, bins int) *Histogram {
+	window := render.ComputeWindow(values, render.WindowAuto)
+	lo, hi := window[0], window[1]
+	if !(hi > lo) {
+		return nil
+	}
+	counts := make([]int, bins)
+	total := 0
+	for _, v := range values {
+		if v == 0 || math.IsNaN(v) || v < lo || v > hi {
+			continue
+		}
+		i := int((v - lo) / (hi - lo) * float64(bins))
+		if i == bins {
+			i--
+		}
+		counts[i]++
+		total++
+	}
+	peak := 0
+	for _, c := range counts {
+		if c > peak {
+			peak = c
+		}
+	}
+	if peak == 0 {
+		return nil
+	}
+	h := &Histogram{
+		Title:   "Intensity histogram",
+		Caption: fmt.Sprintf("Nonzero values between the 2nd and 98th percentiles, in %d bins.", bins),
+		Lo:      lo,
+		Hi:      hi,
+		Total:   total,
+		Bars:    make([]Bar, bins),
+	}
+	width := 100 / float64(bins)
+	for i, c := range counts {
+		height := 100 * float64(c) / float64(peak)
+		h.Bars[i] = Bar{
+			Lo:    lo + float64(i)*(hi-lo)/float64(bins),
+			Hi:    lo + float64(i+1)*(hi-lo)/float64(bins),
+			Count: c,
+			X:     float64(i) * width,
+			Y:     100 - height,
+			W:     width,
+			H:     height,
+		}
+	}
+	return h
+}
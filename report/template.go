@@ -0,0 +1,191 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// funcs are the functions available to report templates, in addition to
+// the methods of Report.
+var funcs = map[string]interface{}{
+	// value formats a metric value with 4 significant digits, counts in
+	// full, and NaN as "n/a".
+	"value": func(v float64) string {
+		if math.IsNaN(v) {
+			return "n/a"
+		}
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatFloat(v, 'f', 0, 64)
+		}
+		return strconv.FormatFloat(v, 'g', 4, 64)
+	},
+	// cell escapes the pipes of a Markdown table cell.
+	"cell": func(s string) string {
+		return strings.Replace(s, "|", `\|`, -1)
+	},
+}
+
+// Template renders reports. HTML templates are parsed with html/template,
+// which escapes the values they show, and others, such as Markdown, with
+// text/template.
+type Template struct {
+	exec func(w io.Writer, data interface{}) error
+}
+
+// ParseTemplate parses a report template. It is HTML unless markdown is
+// true. The template is executed with a *Report, and can use its fields,
+// its Metric and Figure methods to pick the metrics and figures it shows,
+// the value function, which formats a metric value, and the cell function,
+// which escapes text for a Markdown table.
+func ParseTemplate(name, text string, markdown bool) (*Template, error) {
+	if markdown {
+		t, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(funcs)).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return &Template{exec: t.Execute}, nil
+	}
+	t, err := template.New(name).Funcs(template.FuncMap(funcs)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{exec: t.Execute}, nil
+}
+
+// ReadTemplate parses the template in a file, as Markdown if its extension
+// is .md or .markdown and as HTML otherwise.
+func ReadTemplate(filename string) (*Template, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	t, err := ParseTemplate(filepath.Base(filename), string(b), ext == ".md" || ext == ".markdown")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return t, nil
+}
+
+// Built-in templates, to start custom ones from.
+var (
+	HTMLTemplate     = mustParse(DefaultHTML, false)
+	MarkdownTemplate = mustParse(DefaultMarkdown, true)
+)
+
+func mustParse(text string, markdown bool) *Template {
+	t, err := ParseTemplate("report", text, markdown)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Execute renders the report with a template.
+func (r *Report) Execute(w io.Writer, t *Template) error {
+	return t.exec(w, r)
+}
+
+// WriteHTML writes the report as a self-contained HTML page with the
+// built-in template.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return r.Execute(w, HTMLTemplate)
+}
+
+// DefaultHTML is the text of the built-in HTML template.
+const DefaultHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Subject}} · gonifti report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 70em; color: #222; }
+h1 { margin-bottom: 0; }
+.meta { color: #666; margin-top: 0.2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { text-align: left; padding: 0.25em 1em 0.25em 0; border-bottom: 1px solid #ddd; }
+td.value { text-align: right; font-variant-numeric: tabular-nums; }
+figure { margin: 1.5em 0; }
+figure img { image-rendering: pixelated; max-width: 100%; background: #000; }
+figcaption { color: #555; }
+svg rect { fill: #4a6fa5; }
+</style>
+</head>
+<body>
+<h1>{{.Subject}}</h1>
+<p class="meta">{{.Input}} · {{if eq .Kind "epi"}}functional{{else}}anatomical{{end}} · {{.Created.Format "2006-01-02 15:04 UTC"}}</p>
+
+<h2>Image</h2>
+<table>
+{{- range .Summary}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Quality metrics</h2>
+<table>
+<tr><th>Metric</th><th>Value</th><th>Description</th></tr>
+{{- range .Metrics}}
+<tr><td>{{.Name}}</td><td class="value">{{value .Value}}</td><td>{{.Description}}</td></tr>
+{{- end}}
+</table>
+
+{{range .Figures -}}
+<figure>
+<h2>{{.Title}}</h2>
+<img src="{{.Src}}" alt="{{.Title}}">
+<figcaption>{{.Caption}}</figcaption>
+</figure>
+{{end -}}
+
+{{with .Histogram -}}
+<figure>
+<h2>{{.Title}}</h2>
+<svg viewBox="0 0 100 100" preserveAspectRatio="none" width="640" height="200">
+{{- range .Bars}}
+<rect x="{{.X}}" y="{{.Y}}" width="{{.W}}" height="{{.H}}"><title>{{value .Lo}} to {{value .Hi}}: {{.Count}}</title></rect>
+{{- end}}
+</svg>
+<figcaption>{{.Caption}} {{value .Lo}} to {{value .Hi}}, {{.Total}} voxels.</figcaption>
+</figure>
+{{- end}}
+</body>
+</html>
+`
+
+// DefaultMarkdown is the text of the built-in Markdown template. Figures
+// are embedded as data URIs, which most Markdown viewers show.
+const DefaultMarkdown = `# {{.Subject}}
+
+{{.Input}} · {{if eq .Kind "epi"}}functional{{else}}anatomical{{end}} · {{.Created.Format "2006-01-02 15:04 UTC"}}
+
+## Image
+
+| Field | Value |
+| ----- | ----- |
+{{- range .Summary}}
+| {{.Name}} | {{.Value}} |
+{{- end}}
+
+## Quality metrics
+
+| Metric | Value | Description |
+| ------ | ----: | ----------- |
+{{- range .Metrics}}
+| {{.Name}} | {{value .Value}} | {{cell .Description}} |
+{{- end}}
+{{range .Figures}}
+## {{.Title}}
+
+![{{.Title}}]({{.Src}})
+
+{{.Caption}}
+{{end}}
+`
@@ -0,0 +1,144 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LabelsFromValues rounds the values of a label image to integer labels.
+func LabelsFromValues(values []float64) []int {
+	labels := make([]int, len(values))
+	for i, v := range values {
+		if !math.IsNaN(v) {
+			labels[i] = int(math.Round(v))
+		}
+	}
+	return labels
+}
+
+// checkRaters reports an error unless there is at least one label image and
+// all have the same number of voxels.
+func checkRaters(labels [][]int) error {
+	if len(labels) == 0 {
+		return errors.New("no label images given")
+	}
+	for r, l := range labels[1:] {
+		if len(l) != len(labels[0]) {
+			return fmt.Errorf("label image %d has %d voxels, label image 0 has %d", r+1, len(l), len(labels[0]))
+		}
+	}
+	return nil
+}
+
+// MajorityVote returns the label chosen by most label images at each voxel.
+// Ties go to the smallest label.
+func MajorityVote(labels [][]int) ([]int, error) {
+	return WeightedVote(labels, nil)
+}
+
+// WeightedVote returns the label with the largest total weight at each
+// voxel, where label image r votes with weights[r]. A nil weights gives
+// every label image a weight of 1. Ties go to the smallest label.
+func WeightedVote(labels [][]int, weights []float64) ([]int, error) {
+	if err := checkRaters(labels); err != nil {
+		return nil, err
+	}
+	if weights != nil && len(weights) != len(labels) {
+		return nil, fmt.Errorf("got %d weights for %d label images", len(weights), len(labels))
+	}
+
+	out := make([]int, len(labels[0]))
+	votes := make(map[int]float64)
+	for i := range out {
+		for k := range votes {
+			delete(votes, k)
+		}
+		for r := range labels {
+			w := 1.0
+			if weights != nil {
+				w = weights[r]
+			}
+			votes[labels[r][i]] += w
+		}
+		best, bestVotes := 0, math.Inf(-1)
+		for l, v := range votes {
+			if v > bestVotes || (v == bestVotes && l < best) {
+				best, bestVotes = l, v
+			}
+		}
+		out[i] = best
+	}
+	return out, nil
+}
+
+// StapleLite estimates the reliability of each label image and returns the
+// consensus of a vote weighted by it, in the spirit of STAPLE (Warfield et
+// al., 2004) but without per-label sensitivity and specificity. Starting
+// from the majority vote, each label image is weighted by its agreement with
+// the consensus over the voxels labelled by any image, and the vote is
+// repeated until the consensus stops changing or iterations is reached. The
+// final weights sum to 1.
+func StapleLite(labels [][]int, iterations int) (consensus []int, weights []float64, err error) {
+	if consensus, err = MajorityVote(labels); err != nil {
+		return nil, nil, err
+	}
+
+	// Only voxels with a non-zero label somewhere are informative.
+	var fg []int
+	for i := range consensus {
+		for r := range labels {
+			if labels[r][i] != 0 {
+				fg = append(fg, i)
+				break
+			}
+		}
+	}
+
+	weights = make([]float64, len(labels))
+	for r := range weights {
+		weights[r] = 1 / float64(len(labels))
+	}
+	for iter := 0; iter < iterations; iter++ {
+		sum := 0.0
+		for r := range labels {
+			agree := 0
+			for _, i := range fg {
+				if labels[r][i] == consensus[i] {
+					agree++
+				}
+			}
+			// Keep every label image in the vote.
+			weights[r] = (float64(agree) + 1) / (float64(len(fg)) + 1)
+			sum += weights[r]
+		}
+		for r := range weights {
+			weights[r] /= sum
+		}
+
+		next, err := WeightedVote(labels, weights)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed := 0
+		for i := range next {
+			if next[i] != consensus[i] {
+				changed++
+			}
+		}
+		consensus = next
+
+		log.WithFields(log.Fields{
+			"iteration": iter + 1,
+			"changed":   changed,
+			"weights":   weights,
+		}).Debug("Updated consensus")
+
+		if changed == 0 {
+			break
+		}
+	}
+	return consensus, weights, nil
+}
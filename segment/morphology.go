@@ -0,0 +1,174 @@
+package segment
+
+import (
+	"errors"
+)
+
+// neighbors6 are the offsets of the face-connected neighbours of a voxel.
+var neighbors6 = [6][3]int{
+	{-1, 0, 0}, {1, 0, 0},
+	{0, -1, 0}, {0, 1, 0},
+	{0, 0, -1}, {0, 0, 1},
+}
+
+// forNeighbors calls f with the index of each face-connected neighbour of
+// voxel i that lies inside a grid of the given dims.
+func forNeighbors(i int, dims [3]int, f func(j int)) {
+	x := i % dims[0]
+	y := (i / dims[0]) % dims[1]
+	z := i / (dims[0] * dims[1])
+	for _, o := range neighbors6 {
+		nx, ny, nz := x+o[0], y+o[1], z+o[2]
+		if nx < 0 || ny < 0 || nz < 0 || nx >= dims[0] || ny >= dims[1] || nz >= dims[2] {
+			continue
+		}
+		f(nx + dims[0]*(ny+dims[1]*nz))
+	}
+}
+
+// Components labels the face-connected components of a mask. Components are
+// numbered from 1 in order of their first voxel; background is 0. It returns
+// the labels and the size of each component (sizes[0] is unused).
+func Components(mask []bool, dims [3]int) (labels []int, sizes []int) {
+	labels = make([]int, len(mask))
+	sizes = []int{0}
+	var queue []int
+	for start, in := range mask {
+		if !in || labels[start] != 0 {
+			continue
+		}
+		label := len(sizes)
+		sizes = append(sizes, 0)
+		labels[start] = label
+		queue = append(queue[:0], start)
+		for len(queue) > 0 {
+			i := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			sizes[label]++
+			forNeighbors(i, dims, func(j int) {
+				if mask[j] && labels[j] == 0 {
+					labels[j] = label
+					queue = append(queue, j)
+				}
+			})
+		}
+	}
+	return labels, sizes
+}
+
+// LargestComponent returns the largest face-connected component of a mask.
+func LargestComponent(mask []bool, dims [3]int) []bool {
+	labels, sizes := Components(mask, dims)
+	best := 0
+	for l := 1; l < len(sizes); l++ {
+		if sizes[l] > sizes[best] {
+			best = l
+		}
+	}
+	out := make([]bool, len(mask))
+	if best == 0 {
+		return out
+	}
+	for i, l := range labels {
+		out[i] = l == best
+	}
+	return out
+}
+
+// FillHoles returns the mask with every background region that is not
+// connected to the border of the grid filled in.
+func FillHoles(mask []bool, dims [3]int) []bool {
+	outside := make([]bool, len(mask))
+	var queue []int
+	for i, in := range mask {
+		x := i % dims[0]
+		y := (i / dims[0]) % dims[1]
+		z := i / (dims[0] * dims[1])
+		border := x == 0 || y == 0 || z == 0 || x == dims[0]-1 || y == dims[1]-1 || z == dims[2]-1
+		if border && !in {
+			outside[i] = true
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		forNeighbors(i, dims, func(j int) {
+			if !mask[j] && !outside[j] {
+				outside[j] = true
+				queue = append(queue, j)
+			}
+		})
+	}
+
+	out := make([]bool, len(mask))
+	for i := range out {
+		out[i] = !outside[i]
+	}
+	return out
+}
+
+// Dilate grows a mask by the given number of voxels using the
+// face-connected neighbourhood.
+func Dilate(mask []bool, dims [3]int, iterations int) []bool {
+	out := append([]bool(nil), mask...)
+	for n := 0; n < iterations; n++ {
+		prev := append([]bool(nil), out...)
+		for i, in := range prev {
+			if in {
+				forNeighbors(i, dims, func(j int) { out[j] = true })
+			}
+		}
+	}
+	return out
+}
+
+// Erode shrinks a mask by the given number of voxels using the
+// face-connected neighbourhood. Voxels on the border of the grid are kept if
+// all of their in-grid neighbours are in the mask.
+func Erode(mask []bool, dims [3]int, iterations int) []bool {
+	out := append([]bool(nil), mask...)
+	for n := 0; n < iterations; n++ {
+		prev := append([]bool(nil), out...)
+		for i, in := range prev {
+			if !in {
+				continue
+			}
+			forNeighbors(i, dims, func(j int) {
+				if !prev[j] {
+					out[i] = false
+				}
+			})
+		}
+	}
+	return out
+}
+
+// QuickBetOptions configures QuickBet.
+type QuickBetOptions struct {
+	// Erode is the number of voxels the Otsu mask is eroded by before the
+	// largest component is selected, to break thin bridges to the skull and
+	// neck. The same amount is dilated back afterwards.
+	Erode int
+	// Dilate is an extra margin added to the final mask.
+	Dilate int
+}
+
+// QuickBet returns an approximate brain mask of a 3D volume: an Otsu
+// threshold, followed by erosion, selection of the largest connected
+// component, dilation, and hole filling. It is meant for QC and defacing
+// workflows, not as a substitute for a proper skull stripping tool.
+func QuickBet(values []float64, dims [3]int, opts QuickBetOptions) ([]bool, error) {
+	if len(values) != dims[0]*dims[1]*dims[2] {
+		return nil, errors.New("values do not match the grid dimensions")
+	}
+	mask, err := OtsuMask(values)
+	if err != nil {
+		return nil, err
+	}
+	mask = Erode(mask, dims, opts.Erode)
+	mask = LargestComponent(mask, dims)
+	mask = Dilate(mask, dims, opts.Erode+opts.Dilate)
+	mask = FillHoles(mask, dims)
+	return mask, nil
+}
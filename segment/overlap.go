@@ -0,0 +1,169 @@
+package segment
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// DistanceTransform returns the Euclidean distance from each voxel to the
+// nearest voxel in mask, with voxel spacing given per axis (e.g. in mm).
+// Voxels in the mask are at distance 0; if the mask is empty, all distances
+// are +Inf. It uses the separable algorithm of Felzenszwalb and
+// Huttenlocher (2012).
+func DistanceTransform(mask []bool, dims [3]int, spacing [3]float64) []float64 {
+	d := make([]float64, len(mask))
+	for i, in := range mask {
+		if !in {
+			d[i] = math.Inf(1)
+		}
+	}
+
+	strides := [3]int{1, dims[0], dims[0] * dims[1]}
+	for axis := 0; axis < 3; axis++ {
+		n := dims[axis]
+		f := make([]float64, n)
+		out := make([]float64, n)
+		for start := range d {
+			// Visit each line along axis once, from its first voxel.
+			if (start/strides[axis])%n != 0 {
+				continue
+			}
+			for q := 0; q < n; q++ {
+				f[q] = d[start+q*strides[axis]]
+			}
+			squaredDistance1D(f, out, spacing[axis])
+			for q := 0; q < n; q++ {
+				d[start+q*strides[axis]] = out[q]
+			}
+		}
+	}
+
+	for i := range d {
+		d[i] = math.Sqrt(d[i])
+	}
+	return d
+}
+
+// squaredDistance1D computes the lower envelope of the parabolas
+// (x - x_q)^2 + f[q] for points spaced h apart, writing it to out.
+func squaredDistance1D(f, out []float64, h float64) {
+	var v []int
+	var z []float64
+	for q := range f {
+		if math.IsInf(f[q], 1) {
+			continue
+		}
+		xq := float64(q) * h
+		for len(v) > 0 {
+			p := v[len(v)-1]
+			xp := float64(p) * h
+			s := ((f[q] + xq*xq) - (f[p] + xp*xp)) / (2 * (xq - xp))
+			if s > z[len(z)-1] {
+				v = append(v, q)
+				z = append(z, s)
+				break
+			}
+			v = v[:len(v)-1]
+			z = z[:len(z)-1]
+		}
+		if len(v) == 0 {
+			v = append(v, q)
+			z = append(z, math.Inf(-1))
+		}
+	}
+
+	if len(v) == 0 {
+		for q := range out {
+			out[q] = math.Inf(1)
+		}
+		return
+	}
+	k := 0
+	for q := range out {
+		x := float64(q) * h
+		for k+1 < len(v) && z[k+1] < x {
+			k++
+		}
+		dx := x - float64(v[k])*h
+		out[q] = dx*dx + f[v[k]]
+	}
+}
+
+// OverlapStats compares one label between two label images A and B.
+type OverlapStats struct {
+	Label        int
+	VoxelsA      int
+	VoxelsB      int
+	Intersection int
+	Dice         float64 // 2|A∩B| / (|A|+|B|)
+	Jaccard      float64 // |A∩B| / |A∪B|
+	// Symmetric Hausdorff distance and its 95th percentile, in the units of
+	// the spacing. Both are +Inf if the label is missing from one image.
+	Hausdorff   float64
+	Hausdorff95 float64
+}
+
+// Overlap compares every non-zero label found in either of two label images
+// on the same grid. The results are sorted by label.
+func Overlap(a, b []int, dims [3]int, spacing [3]float64) ([]OverlapStats, error) {
+	if len(a) != len(b) || len(a) != dims[0]*dims[1]*dims[2] {
+		return nil, errors.New("label images are not on the same grid")
+	}
+
+	seen := map[int]bool{}
+	for i := range a {
+		if a[i] != 0 {
+			seen[a[i]] = true
+		}
+		if b[i] != 0 {
+			seen[b[i]] = true
+		}
+	}
+	keys := make([]int, 0, len(seen))
+	for l := range seen {
+		keys = append(keys, l)
+	}
+	sort.Ints(keys)
+
+	stats := make([]OverlapStats, 0, len(keys))
+	ma := make([]bool, len(a))
+	mb := make([]bool, len(b))
+	for _, l := range keys {
+		s := OverlapStats{Label: l}
+		for i := range a {
+			ma[i], mb[i] = a[i] == l, b[i] == l
+			if ma[i] {
+				s.VoxelsA++
+			}
+			if mb[i] {
+				s.VoxelsB++
+			}
+			if ma[i] && mb[i] {
+				s.Intersection++
+			}
+		}
+		s.Dice = 2 * float64(s.Intersection) / float64(s.VoxelsA+s.VoxelsB)
+		s.Jaccard = float64(s.Intersection) / float64(s.VoxelsA+s.VoxelsB-s.Intersection)
+
+		s.Hausdorff, s.Hausdorff95 = math.Inf(1), math.Inf(1)
+		if s.VoxelsA > 0 && s.VoxelsB > 0 {
+			da := DistanceTransform(ma, dims, spacing)
+			db := DistanceTransform(mb, dims, spacing)
+			dists := make([]float64, 0, s.VoxelsA+s.VoxelsB)
+			for i := range ma {
+				if ma[i] {
+					dists = append(dists, db[i])
+				}
+				if mb[i] {
+					dists = append(dists, da[i])
+				}
+			}
+			sort.Float64s(dists)
+			s.Hausdorff = dists[len(dists)-1]
+			s.Hausdorff95 = dists[int(math.Ceil(0.95*float64(len(dists))))-1]
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
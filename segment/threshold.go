@@ -0,0 +1,166 @@
+// segment contains methods to build masks and label images from nifti1
+// images.
+
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// histogramBins is the number of bins used by the Otsu methods.
+const histogramBins = 256
+
+// finite returns the values that are not NaN or infinite.
+func finite(values []float64) []float64 {
+	out := make([]float64, 0, len(values))
+	for _, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Otsu returns the threshold that maximizes the between-class variance of
+// the two classes below and above it. Voxels strictly above the threshold
+// are foreground.
+func Otsu(values []float64) (float64, error) {
+	t, err := MultiOtsu(values, 2)
+	if err != nil {
+		return 0, err
+	}
+	return t[0], nil
+}
+
+// MultiOtsu returns the classes-1 thresholds that split the values into the
+// given number of classes with maximum between-class variance. The search is
+// exact over a 256-bin histogram.
+func MultiOtsu(values []float64, classes int) ([]float64, error) {
+	if classes < 2 || classes > histogramBins {
+		return nil, fmt.Errorf("number of classes must be in [2, %d], got %d", histogramBins, classes)
+	}
+	v := finite(values)
+	if len(v) == 0 {
+		return nil, errors.New("no finite values")
+	}
+
+	lo, hi := v[0], v[0]
+	for _, x := range v {
+		lo = math.Min(lo, x)
+		hi = math.Max(hi, x)
+	}
+	if lo == hi {
+		return nil, errors.New("all values are equal")
+	}
+	width := (hi - lo) / histogramBins
+
+	// Cumulative weight and first moment of the histogram.
+	var hist [histogramBins]float64
+	for _, x := range v {
+		b := int((x - lo) / width)
+		if b >= histogramBins {
+			b = histogramBins - 1
+		}
+		hist[b]++
+	}
+	var w, s [histogramBins + 1]float64
+	for b := 0; b < histogramBins; b++ {
+		center := lo + (float64(b)+0.5)*width
+		w[b+1] = w[b] + hist[b]
+		s[b+1] = s[b] + hist[b]*center
+	}
+	// score of the class made of bins [i, j); maximizing the sum of scores
+	// maximizes the between-class variance.
+	score := func(i, j int) float64 {
+		dw := w[j] - w[i]
+		if dw == 0 {
+			return 0
+		}
+		ds := s[j] - s[i]
+		return ds * ds / dw
+	}
+
+	// best[c][j] is the best score of c+1 classes over bins [0, j).
+	best := make([][]float64, classes)
+	from := make([][]int, classes)
+	for c := range best {
+		best[c] = make([]float64, histogramBins+1)
+		from[c] = make([]int, histogramBins+1)
+	}
+	for j := 1; j <= histogramBins; j++ {
+		best[0][j] = score(0, j)
+	}
+	for c := 1; c < classes; c++ {
+		for j := c + 1; j <= histogramBins; j++ {
+			best[c][j] = math.Inf(-1)
+			for i := c; i < j; i++ {
+				if sc := best[c-1][i] + score(i, j); sc > best[c][j] {
+					best[c][j], from[c][j] = sc, i
+				}
+			}
+		}
+	}
+
+	thresholds := make([]float64, classes-1)
+	j := histogramBins
+	for c := classes - 1; c > 0; c-- {
+		j = from[c][j]
+		thresholds[c-1] = lo + float64(j)*width
+	}
+	return thresholds, nil
+}
+
+// Percentile returns the p-th percentile (0 <= p <= 100) of the finite
+// values, interpolating linearly between order statistics.
+func Percentile(values []float64, p float64) (float64, error) {
+	if p < 0 || p > 100 {
+		return 0, fmt.Errorf("percentile must be in [0, 100], got %v", p)
+	}
+	v := finite(values)
+	if len(v) == 0 {
+		return 0, errors.New("no finite values")
+	}
+	sort.Float64s(v)
+	pos := p / 100 * float64(len(v)-1)
+	i := int(pos)
+	if i >= len(v)-1 {
+		return v[len(v)-1], nil
+	}
+	f := pos - float64(i)
+	return v[i]*(1-f) + v[i+1]*f, nil
+}
+
+// Mask returns the voxels strictly above threshold.
+func Mask(values []float64, threshold float64) []bool {
+	mask := make([]bool, len(values))
+	for i, v := range values {
+		mask[i] = v > threshold
+	}
+	return mask
+}
+
+// Classify assigns each value the number of thresholds it is strictly above,
+// so that values in the lowest class get 0. The thresholds must be sorted.
+// NaN values get 0.
+func Classify(values []float64, thresholds []float64) []int {
+	labels := make([]int, len(values))
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		labels[i] = sort.Search(len(thresholds), func(k int) bool { return thresholds[k] >= v })
+	}
+	return labels
+}
+
+// OtsuMask returns the foreground mask of the values using Otsu's threshold.
+func OtsuMask(values []float64) ([]bool, error) {
+	t, err := Otsu(values)
+	if err != nil {
+		return nil, err
+	}
+	return Mask(values, t), nil
+}
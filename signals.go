@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// signalHooks are run by handleSignals before it cleans up, last added
+// first.
+var signalHooks struct {
+	sync.Mutex
+	fns []func()
+}
+
+// atSignal adds fn to the functions run when a signal stops the command,
+// such as to stop the child processes of a long-running command.
+func atSignal(fn func()) {
+	signalHooks.Lock()
+	defer signalHooks.Unlock()
+	signalHooks.fns = append(signalHooks.fns, fn)
+}
+
+// handleSignals stops the command on SIGINT or SIGTERM: it runs the
+// functions of atSignal, removes the temporary files of writes in progress,
+// flushes the profile with stop, and exits with 128 plus the number of the
+// signal. Every output file is written through util.WriteFileAtomic or
+// util.WriteAtomic and renamed into place once complete, so files already
+// written are whole and no partial file is left. Outputs of several files,
+// such as a Zarr store or a pair of .hdr and .img files, may be left with
+// only some of them written.
+func handleSignals(command string, stop func() error) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		signal.Stop(c)
+		signalHooks.Lock()
+		for i := len(signalHooks.fns) - 1; i >= 0; i-- {
+			signalHooks.fns[i]()
+		}
+		signalHooks.Unlock()
+		for _, name := range util.RemoveTemps() {
+			log.WithFields(log.Fields{
+				"file": name,
+			}).Info("Removed partial output")
+		}
+		if err := stop(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Warn("Could not write the profile")
+		}
+		s, _ := sig.(syscall.Signal)
+		exitWithError(command, &signalErr{s})
+	}()
+}
@@ -0,0 +1,234 @@
+// similarity contains measures of the similarity between two volumes on the
+// same grid, for registration QC and model evaluation.
+
+package similarity
+
+import (
+	"errors"
+	"math"
+
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// DefaultBins is the number of histogram bins per image used for mutual
+// information.
+const DefaultBins = 64
+
+// ssimRadius is the half-width of the cubic SSIM window (7x7x7 voxels).
+const ssimRadius = 3
+
+// selected returns the indices of voxels in mask (or all voxels if mask is
+// nil) whose values are finite in both images.
+func selected(a, b []float64, mask []bool) ([]int, error) {
+	if len(a) != len(b) {
+		return nil, errors.New("volumes are not on the same grid")
+	}
+	if mask != nil && len(mask) != len(a) {
+		return nil, errors.New("mask is not on the grid of the volumes")
+	}
+	var idx []int
+	for i := range a {
+		if mask != nil && !mask[i] {
+			continue
+		}
+		if math.IsNaN(a[i]) || math.IsInf(a[i], 0) || math.IsNaN(b[i]) || math.IsInf(b[i], 0) {
+			continue
+		}
+		idx = append(idx, i)
+	}
+	if len(idx) < 2 {
+		return nil, errors.New("fewer than two voxels to compare")
+	}
+	return idx, nil
+}
+
+// Correlation returns the Pearson correlation of two volumes within a mask.
+// A nil mask selects all voxels.
+func Correlation(a, b []float64, mask []bool) (float64, error) {
+	idx, err := selected(a, b, mask)
+	if err != nil {
+		return 0, err
+	}
+	var sa, sb numeric.Accumulator
+	for _, i := range idx {
+		sa.Add(a[i])
+		sb.Add(b[i])
+	}
+	ma := sa.Sum() / float64(len(idx))
+	mb := sb.Sum() / float64(len(idx))
+
+	var sab, saa, sbb numeric.Accumulator
+	for _, i := range idx {
+		da, db := a[i]-ma, b[i]-mb
+		sab.AddProduct(da, db)
+		saa.AddProduct(da, da)
+		sbb.AddProduct(db, db)
+	}
+	if saa.Sum() == 0 || sbb.Sum() == 0 {
+		return 0, errors.New("a volume is constant within the mask")
+	}
+	return sab.Sum() / math.Sqrt(saa.Sum()*sbb.Sum()), nil
+}
+
+// NormalizedMutualInformation returns (H(A) + H(B)) / H(A, B) of two volumes
+// within a mask (Studholme et al., 1999), using a joint histogram with the
+// given number of bins per image. It ranges from 1 for independent images
+// to 2 for identical ones.
+func NormalizedMutualInformation(a, b []float64, mask []bool, bins int) (float64, error) {
+	idx, err := selected(a, b, mask)
+	if err != nil {
+		return 0, err
+	}
+	if bins < 2 {
+		return 0, errors.New("at least two bins are needed")
+	}
+
+	binner := func(v []float64) func(i int) int {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, i := range idx {
+			lo = math.Min(lo, v[i])
+			hi = math.Max(hi, v[i])
+		}
+		return func(i int) int {
+			if hi == lo {
+				return 0
+			}
+			k := int(float64(bins) * (v[i] - lo) / (hi - lo))
+			if k == bins {
+				k--
+			}
+			return k
+		}
+	}
+	ba, bb := binner(a), binner(b)
+
+	joint := make([]float64, bins*bins)
+	pa := make([]float64, bins)
+	pb := make([]float64, bins)
+	w := 1 / float64(len(idx))
+	for _, i := range idx {
+		x, y := ba(i), bb(i)
+		joint[x*bins+y] += w
+		pa[x] += w
+		pb[y] += w
+	}
+
+	entropy := func(p []float64) float64 {
+		h := 0.0
+		for _, v := range p {
+			if v > 0 {
+				h -= v * math.Log(v)
+			}
+		}
+		return h
+	}
+	hab := entropy(joint)
+	if hab == 0 {
+		// Both images are constant.
+		return 2, nil
+	}
+	return (entropy(pa) + entropy(pb)) / hab, nil
+}
+
+// SSIM returns the mean structural similarity index (Wang et al., 2004) of
+// two 3D volumes over the voxels in mask, computed with a uniform 7x7x7
+// window. The dynamic range is taken from the values of both volumes.
+func SSIM(a, b []float64, dims [3]int, mask []bool) (float64, error) {
+	idx, err := selected(a, b, mask)
+	if err != nil {
+		return 0, err
+	}
+	if len(a) != dims[0]*dims[1]*dims[2] {
+		return 0, errors.New("volumes do not match the dims")
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, i := range idx {
+		lo = math.Min(lo, math.Min(a[i], b[i]))
+		hi = math.Max(hi, math.Max(a[i], b[i]))
+	}
+	l := hi - lo
+	c1 := (0.01 * l) * (0.01 * l)
+	c2 := (0.03 * l) * (0.03 * l)
+
+	// Non-finite values would poison every window they fall in.
+	clean := func(v []float64) []float64 {
+		out := make([]float64, len(v))
+		for i, x := range v {
+			if !math.IsNaN(x) && !math.IsInf(x, 0) {
+				out[i] = x
+			}
+		}
+		return out
+	}
+	a, b = clean(a), clean(b)
+	aa := make([]float64, len(a))
+	bb := make([]float64, len(a))
+	ab := make([]float64, len(a))
+	for i := range a {
+		aa[i], bb[i], ab[i] = a[i]*a[i], b[i]*b[i], a[i]*b[i]
+	}
+	mua, mub := boxMean(a, dims), boxMean(b, dims)
+	maa, mbb, mab := boxMean(aa, dims), boxMean(bb, dims), boxMean(ab, dims)
+
+	var sum numeric.Accumulator
+	for _, i := range idx {
+		va := maa[i] - mua[i]*mua[i]
+		vb := mbb[i] - mub[i]*mub[i]
+		cov := mab[i] - mua[i]*mub[i]
+		num := (2*mua[i]*mub[i] + c1) * (2*cov + c2)
+		den := (mua[i]*mua[i] + mub[i]*mub[i] + c1) * (va + vb + c2)
+		if den == 0 {
+			sum.Add(1)
+			continue
+		}
+		sum.Add(num / den)
+	}
+	return sum.Sum() / float64(len(idx)), nil
+}
+
+// boxMean returns the mean of v over a cube of half-width ssimRadius around
+// each voxel, using only the voxels inside the grid.
+func boxMean(v []float64, dims [3]int) []float64 {
+	out := append([]float64(nil), v...)
+	count := make([]float64, len(v))
+	for i := range count {
+		count[i] = 1
+	}
+	strides := [3]int{1, dims[0], dims[0] * dims[1]}
+	for axis := 0; axis < 3; axis++ {
+		n := dims[axis]
+		line := make([]float64, n)
+		lineCount := make([]float64, n)
+		for start := range out {
+			if (start/strides[axis])%n != 0 {
+				continue
+			}
+			// Running sums along the line.
+			var s, c float64
+			for q := 0; q < n && q <= ssimRadius; q++ {
+				s += out[start+q*strides[axis]]
+				c += count[start+q*strides[axis]]
+			}
+			for q := 0; q < n; q++ {
+				line[q], lineCount[q] = s, c
+				if add := q + ssimRadius + 1; add < n {
+					s += out[start+add*strides[axis]]
+					c += count[start+add*strides[axis]]
+				}
+				if drop := q - ssimRadius; drop >= 0 {
+					s -= out[start+drop*strides[axis]]
+					c -= count[start+drop*strides[axis]]
+				}
+			}
+			for q := 0; q < n; q++ {
+				out[start+q*strides[axis]] = line[q]
+				count[start+q*strides[axis]] = lineCount[q]
+			}
+		}
+	}
+	for i := range out {
+		out[i] /= count[i]
+	}
+	return out
+}
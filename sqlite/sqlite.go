@@ -0,0 +1,287 @@
+// sqlite contains a writer for SQLite database files holding one table. It
+// writes the file format directly and does not need the SQLite library.
+// https://www.sqlite.org/fileformat2.html
+
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/util"
+)
+
+const (
+	pageSize = 4096
+
+	// maxLocal is the largest payload stored in a table leaf cell without
+	// overflow pages, U-35 in the file format documentation.
+	maxLocal = pageSize - 35
+
+	leafHeaderSize     = 8
+	interiorHeaderSize = 12
+
+	flagInteriorTable = 0x05
+	flagLeafTable     = 0x0d
+)
+
+// Column is a column of the table. Type is a SQLite type name such as
+// "INTEGER", "REAL", or "TEXT".
+type Column struct {
+	Name string
+	Type string
+}
+
+// page is a b-tree page under construction.
+type page struct {
+	number int
+	leaf   bool
+	cells  [][]byte
+	right  *page // rightmost child of an interior page
+	maxKey int64 // largest rowid under this page
+}
+
+// size returns the bytes used by the header, cell pointers, and cells.
+func (p *page) size() int {
+	n := leafHeaderSize
+	if !p.leaf {
+		n = interiorHeaderSize
+	}
+	for _, c := range p.cells {
+		n += 2 + len(c)
+	}
+	return n
+}
+
+// Write writes a database with a single table to w. Each row holds one value
+// per column, of type int64, int, float64, string, []byte, bool, or nil.
+// Rows get rowids starting at 1.
+func Write(w io.Writer, table string, columns []Column, rows [][]interface{}) error {
+	var pages []*page
+	newPage := func(leaf bool) *page {
+		p := &page{number: len(pages) + 2, leaf: leaf}
+		pages = append(pages, p)
+		return p
+	}
+
+	// Leaves, filled in rowid order.
+	level := []*page{newPage(true)}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return fmt.Errorf("row %d has %d values, expected %d", i+1, len(row), len(columns))
+		}
+		payload, err := record(row)
+		if err != nil {
+			return fmt.Errorf("row %d: %v", i+1, err)
+		}
+		if len(payload) > maxLocal {
+			return fmt.Errorf("row %d is %d bytes, larger than the supported %d", i+1, len(payload), maxLocal)
+		}
+		rowid := int64(i + 1)
+		cell := appendVarint(nil, uint64(len(payload)))
+		cell = appendVarint(cell, uint64(rowid))
+		cell = append(cell, payload...)
+
+		p := level[len(level)-1]
+		if p.size()+2+len(cell) > pageSize {
+			p = newPage(true)
+			level = append(level, p)
+		}
+		p.cells = append(p.cells, cell)
+		p.maxKey = rowid
+	}
+
+	// Interior levels, until a single root remains.
+	for len(level) > 1 {
+		var next []*page
+		p := newPage(false)
+		next = append(next, p)
+		for _, child := range level {
+			if p.right != nil {
+				cell := make([]byte, 4, 4+9)
+				binary.BigEndian.PutUint32(cell, uint32(p.right.number))
+				cell = appendVarint(cell, uint64(p.right.maxKey))
+				if p.size()+2+len(cell) > pageSize {
+					p = newPage(false)
+					next = append(next, p)
+				} else {
+					p.cells = append(p.cells, cell)
+				}
+			}
+			p.right = child
+			p.maxKey = child.maxKey
+		}
+		level = next
+	}
+	root := level[0]
+
+	var defs []string
+	for _, c := range columns {
+		defs = append(defs, fmt.Sprintf("%s %s", quoteIdent(c.Name), c.Type))
+	}
+	sql := fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdent(table), strings.Join(defs, ", "))
+	schema, err := record([]interface{}{"table", table, table, int64(root.number), sql})
+	if err != nil {
+		return err
+	}
+	schemaCell := appendVarint(nil, uint64(len(schema)))
+	schemaCell = appendVarint(schemaCell, 1)
+	schemaCell = append(schemaCell, schema...)
+	if 100+leafHeaderSize+2+len(schemaCell) > pageSize {
+		return fmt.Errorf("table definition is too long")
+	}
+
+	npages := len(pages) + 1
+	first := make([]byte, pageSize)
+	writeFileHeader(first, npages)
+	encodePage(first, 100, &page{leaf: true, cells: [][]byte{schemaCell}})
+	if _, err := w.Write(first); err != nil {
+		return err
+	}
+
+	buf := make([]byte, pageSize)
+	for _, p := range pages {
+		for i := range buf {
+			buf[i] = 0
+		}
+		encodePage(buf, 0, p)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFile writes a database with a single table to filename.
+func WriteFile(filename, table string, columns []Column, rows [][]interface{}) error {
+	var b bytes.Buffer
+	if err := Write(&b, table, columns, rows); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filename, b.Bytes(), 0644)
+}
+
+// writeFileHeader fills the 100-byte database header.
+func writeFileHeader(b []byte, npages int) {
+	copy(b, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(b[16:], pageSize)
+	b[18], b[19] = 1, 1 // legacy journal mode
+	b[20] = 0           // reserved bytes per page
+	b[21], b[22], b[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(b[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(b[28:], uint32(npages))
+	binary.BigEndian.PutUint32(b[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(b[44:], 4) // schema format
+	binary.BigEndian.PutUint32(b[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(b[92:], 1) // version-valid-for
+	binary.BigEndian.PutUint32(b[96:], 3040001)
+}
+
+// encodePage writes a b-tree page whose header starts at offset off; off is
+// 100 on the first page, after the database header.
+func encodePage(b []byte, off int, p *page) {
+	hsize := leafHeaderSize
+	b[off] = flagLeafTable
+	if !p.leaf {
+		hsize = interiorHeaderSize
+		b[off] = flagInteriorTable
+		binary.BigEndian.PutUint32(b[off+8:], uint32(p.right.number))
+	}
+	binary.BigEndian.PutUint16(b[off+3:], uint16(len(p.cells)))
+
+	end := len(b)
+	ptr := off + hsize
+	for _, c := range p.cells {
+		end -= len(c)
+		copy(b[end:], c)
+		binary.BigEndian.PutUint16(b[ptr:], uint16(end))
+		ptr += 2
+	}
+	binary.BigEndian.PutUint16(b[off+5:], uint16(end))
+}
+
+// record encodes values in the SQLite record format.
+func record(values []interface{}) ([]byte, error) {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = appendVarint(types, 0)
+		case bool:
+			if v {
+				types = appendVarint(types, 9)
+			} else {
+				types = appendVarint(types, 8)
+			}
+		case int:
+			types = appendVarint(types, 6)
+			body = appendUint64(body, uint64(v))
+		case int64:
+			types = appendVarint(types, 6)
+			body = appendUint64(body, uint64(v))
+		case float64:
+			if math.IsNaN(v) {
+				types = appendVarint(types, 0)
+				continue
+			}
+			types = appendVarint(types, 7)
+			body = appendUint64(body, math.Float64bits(v))
+		case string:
+			types = appendVarint(types, uint64(len(v))*2+13)
+			body = append(body, v...)
+		case []byte:
+			types = appendVarint(types, uint64(len(v))*2+12)
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", v)
+		}
+	}
+
+	// The header size counts its own varint.
+	n := len(types) + 1
+	for len(appendVarint(nil, uint64(n)))+len(types) != n {
+		n++
+	}
+	out := appendVarint(nil, uint64(n))
+	out = append(out, types...)
+	return append(out, body...), nil
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+// appendVarint appends v as a SQLite varint: big-endian groups of 7 bits
+// with the high bit set on all but the last byte, and a full 8 bits in the
+// ninth byte.
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var tmp [9]byte
+		tmp[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			tmp[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, tmp[:]...)
+	}
+	var tmp [8]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,76 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/segment"
+)
+
+// Cluster is a face-connected cluster of voxels above a threshold.
+type Cluster struct {
+	Label int     // label in the image returned by Clusters
+	Size  int     // voxels
+	Peak  int     // voxel index of the maximum
+	Max   float64 // maximum statistic
+	P     float64 // corrected cluster p-value
+}
+
+// ClusterP returns the family-wise corrected p-value of a cluster of k
+// voxels in a Z map thresholded at z > u, by Gaussian random field theory
+// (Friston et al., 1994). The search volume has n voxels and the field the
+// given smoothness, FWHM in voxels along each axis.
+func ClusterP(k int, u float64, n int, fwhm [3]float64) (float64, error) {
+	if u <= 1 {
+		return 0, fmt.Errorf("cluster threshold z = %g must be above 1", u)
+	}
+	if fwhm[0] <= 0 || fwhm[1] <= 0 || fwhm[2] <= 0 {
+		return 0, fmt.Errorf("invalid smoothness %v", fwhm)
+	}
+	const d = 3
+	resels := float64(n) / (fwhm[0] * fwhm[1] * fwhm[2])
+	// Expected number of clusters: the Euler characteristic.
+	em := resels * math.Pow(4*math.Ln2, d/2.0) * math.Pow(2*math.Pi, -(d+1)/2.0) * (u*u - 1) * math.Exp(-u*u/2)
+	// Expected number of voxels above u, and per cluster.
+	en := float64(n) * 0.5 * math.Erfc(u/math.Sqrt2)
+	size := en / em
+	beta := math.Pow(math.Gamma(d/2.0+1)/size, 2.0/d)
+	pk := math.Exp(-beta * math.Pow(float64(k), 2.0/d))
+	return 1 - math.Exp(-em*pk), nil
+}
+
+// Clusters finds the clusters of z > u within mask (all voxels if mask is
+// nil) on a grid of the given dims, sorted by decreasing size, with their
+// corrected p-values for a field of smoothness fwhm (in voxels). It also
+// returns the cluster label of every voxel, 0 outside clusters.
+func Clusters(z []float64, dims [3]int, mask []bool, u float64, fwhm [3]float64) ([]Cluster, []int, error) {
+	above := make([]bool, len(z))
+	n := 0
+	for i, v := range z {
+		if mask != nil && !mask[i] {
+			continue
+		}
+		n++
+		above[i] = v > u
+	}
+	labels, sizes := segment.Components(above, dims)
+	clusters := make([]Cluster, len(sizes)-1)
+	for l := range clusters {
+		clusters[l] = Cluster{Label: l + 1, Size: sizes[l+1], Max: math.Inf(-1)}
+	}
+	for i, l := range labels {
+		if l > 0 && z[i] > clusters[l-1].Max {
+			clusters[l-1].Max, clusters[l-1].Peak = z[i], i
+		}
+	}
+	for i := range clusters {
+		p, err := ClusterP(clusters[i].Size, u, n, fwhm)
+		if err != nil {
+			return nil, nil, err
+		}
+		clusters[i].P = p
+	}
+	sort.SliceStable(clusters, func(a, b int) bool { return clusters[a].Size > clusters[b].Size })
+	return clusters, labels, nil
+}
@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// FDRThreshold returns the largest p-value that is significant at false
+// discovery rate q by the Benjamini-Hochberg procedure, or 0 if none is.
+// NaN p-values are ignored.
+func FDRThreshold(p []float64, q float64) float64 {
+	sorted := finiteSorted(p)
+	m := float64(len(sorted))
+	for k := len(sorted) - 1; k >= 0; k-- {
+		if sorted[k] <= q*float64(k+1)/m {
+			return sorted[k]
+		}
+	}
+	return 0
+}
+
+// FDRAdjust returns the Benjamini-Hochberg adjusted p-values (q-values):
+// the smallest false discovery rate at which each p-value is significant.
+// NaN p-values stay NaN.
+func FDRAdjust(p []float64) []float64 {
+	idx := make([]int, 0, len(p))
+	for i, v := range p {
+		if !math.IsNaN(v) {
+			idx = append(idx, i)
+		}
+	}
+	sort.Slice(idx, func(a, b int) bool { return p[idx[a]] < p[idx[b]] })
+	q := make([]float64, len(p))
+	for i := range q {
+		q[i] = math.NaN()
+	}
+	m := float64(len(idx))
+	min := 1.0
+	for k := len(idx) - 1; k >= 0; k-- {
+		min = math.Min(min, p[idx[k]]*m/float64(k+1))
+		q[idx[k]] = min
+	}
+	return q
+}
+
+func finiteSorted(x []float64) []float64 {
+	s := make([]float64, 0, len(x))
+	for _, v := range x {
+		if !math.IsNaN(v) {
+			s = append(s, v)
+		}
+	}
+	sort.Float64s(s)
+	return s
+}
@@ -0,0 +1,242 @@
+// stats contains group-level inference on stacks of subject maps:
+// permutation tests with family-wise error correction by the maximum
+// statistic, false discovery rate and cluster-level correction, and
+// smoothness estimation.
+
+package stats
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/kaczmarj/gonifti/numeric"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// PermOptions configure the permutation tests.
+type PermOptions struct {
+	Permutations int   // random permutations or sign flips, besides the data
+	Seed         int64 // seed of the random permutations
+	Workers      int   // goroutines evaluating permutations
+	TwoSided     bool  // test |t| instead of t > 0
+}
+
+// DefaultPermOptions run 5000 one-sided permutations.
+var DefaultPermOptions = PermOptions{Permutations: 5000, Seed: 1, Workers: 1}
+
+// PermResult holds the maps of a permutation test, one value per voxel.
+type PermResult struct {
+	T         []float64 // t-statistics of the data
+	P         []float64 // uncorrected p-values
+	PFWE      []float64 // p-values corrected by the maximum statistic
+	MaxT      []float64 // maximum statistic of each permutation
+	Effective int       // number of permutations, including the data
+}
+
+// OneSample tests whether the mean of the maps (one row of voxels per
+// subject) is above 0, or different from 0 if TwoSided, by flipping the
+// signs of the subjects. The maps are assumed to be symmetric about 0
+// under the null hypothesis.
+func OneSample(data [][]float64, o PermOptions) (*PermResult, error) {
+	n, nv, err := checkData(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	sumSq := make([]float64, nv)
+	for v := range sumSq {
+		var a numeric.Accumulator
+		for _, row := range data {
+			a.AddProduct(row[v], row[v])
+		}
+		sumSq[v] = a.Sum()
+	}
+	rng := rand.New(rand.NewSource(o.Seed))
+	signs := make([][]float64, o.Permutations)
+	for p := range signs {
+		signs[p] = make([]float64, n)
+		for i := range signs[p] {
+			signs[p][i] = 1
+			if rng.Intn(2) == 0 {
+				signs[p][i] = -1
+			}
+		}
+	}
+
+	stat := func(s []float64, t []float64) {
+		sum := make([]float64, nv)
+		for i, row := range data {
+			if s == nil || s[i] > 0 {
+				for v, x := range row {
+					sum[v] += x
+				}
+			} else {
+				for v, x := range row {
+					sum[v] -= x
+				}
+			}
+		}
+		fn := float64(n)
+		for v := range t {
+			mean := sum[v] / fn
+			variance := (sumSq[v] - fn*mean*mean) / (fn - 1)
+			t[v] = tValue(mean, variance/fn)
+		}
+	}
+	return run(nv, signs, stat, o), nil
+}
+
+// TwoSample tests whether the mean of the maps of group a (the subjects
+// where inGroupA is true) is above that of the others, or different if
+// TwoSided, with a pooled-variance t-statistic, by permuting the group
+// labels.
+func TwoSample(data [][]float64, inGroupA []bool, o PermOptions) (*PermResult, error) {
+	n, nv, err := checkData(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	if len(inGroupA) != n {
+		return nil, fmt.Errorf("%d group labels for %d maps", len(inGroupA), n)
+	}
+	na := 0
+	for _, a := range inGroupA {
+		if a {
+			na++
+		}
+	}
+	if na == 0 || na == n {
+		return nil, fmt.Errorf("both groups need at least one map")
+	}
+	labels := make([]float64, n)
+	for i, a := range inGroupA {
+		if a {
+			labels[i] = 1
+		}
+	}
+	rng := rand.New(rand.NewSource(o.Seed))
+	perms := make([][]float64, o.Permutations)
+	for p := range perms {
+		perms[p] = append([]float64(nil), labels...)
+		rng.Shuffle(n, func(i, j int) { perms[p][i], perms[p][j] = perms[p][j], perms[p][i] })
+	}
+
+	stat := func(l []float64, t []float64) {
+		if l == nil {
+			l = labels
+		}
+		var sum, sumSq [2][]float64
+		for g := 0; g < 2; g++ {
+			sum[g], sumSq[g] = make([]float64, nv), make([]float64, nv)
+		}
+		for i, row := range data {
+			g := int(l[i])
+			for v, x := range row {
+				sum[g][v] += x
+				sumSq[g][v] += x * x
+			}
+		}
+		nb, nA := float64(n-na), float64(na)
+		for v := range t {
+			ma, mb := sum[1][v]/nA, sum[0][v]/nb
+			ss := sumSq[1][v] - nA*ma*ma + sumSq[0][v] - nb*mb*mb
+			pooled := ss / float64(n-2)
+			t[v] = tValue(ma-mb, pooled*(1/nA+1/nb))
+		}
+	}
+	return run(nv, perms, stat, o), nil
+}
+
+// checkData returns the number of maps and voxels, requiring at least min
+// maps of equal size.
+func checkData(data [][]float64, min int) (int, int, error) {
+	if len(data) < min {
+		return 0, 0, fmt.Errorf("at least %d maps are needed, got %d", min, len(data))
+	}
+	nv := len(data[0])
+	for i, row := range data {
+		if len(row) != nv {
+			return 0, 0, fmt.Errorf("map %d has %d voxels, map 0 has %d", i, len(row), nv)
+		}
+	}
+	return len(data), nv, nil
+}
+
+// tValue returns effect / sqrt(variance), 0 if the variance is 0.
+func tValue(effect, variance float64) float64 {
+	if variance <= 0 {
+		return 0
+	}
+	return effect / math.Sqrt(variance)
+}
+
+// run evaluates stat on the data (a nil permutation) and on every
+// permutation with o.Workers goroutines, and counts the permutations at
+// least as extreme as the data.
+func run(nv int, perms [][]float64, stat func(perm []float64, t []float64), o PermOptions) *PermResult {
+	res := &PermResult{
+		T:         make([]float64, nv),
+		P:         make([]float64, nv),
+		PFWE:      make([]float64, nv),
+		MaxT:      make([]float64, len(perms)),
+		Effective: len(perms) + 1,
+	}
+	stat(nil, res.T)
+	side := func(t float64) float64 {
+		if o.TwoSided {
+			return math.Abs(t)
+		}
+		return t
+	}
+	observed := make([]float64, nv)
+	for v, t := range res.T {
+		observed[v] = side(t)
+	}
+
+	workers := util.Workers(o.Workers)
+	counts := make([][]int, workers)
+	jobs := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		counts[w] = make([]int, nv)
+		wg.Add(1)
+		go func(count []int) {
+			defer wg.Done()
+			t := make([]float64, nv)
+			for p := range jobs {
+				stat(perms[p], t)
+				max := math.Inf(-1)
+				for v, x := range t {
+					x = side(x)
+					if x >= observed[v] {
+						count[v]++
+					}
+					if x > max {
+						max = x
+					}
+				}
+				res.MaxT[p] = max
+			}
+		}(counts[w])
+	}
+	for p := range perms {
+		jobs <- p
+	}
+	close(jobs)
+	wg.Wait()
+
+	sorted := append([]float64(nil), res.MaxT...)
+	sort.Float64s(sorted)
+	total := float64(res.Effective)
+	for v := range res.P {
+		c := 1 // the data
+		for _, count := range counts {
+			c += count[v]
+		}
+		res.P[v] = float64(c) / total
+		c = 1 + len(sorted) - sort.SearchFloat64s(sorted, observed[v])
+		res.PFWE[v] = float64(c) / total
+	}
+	return res
+}
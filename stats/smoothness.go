@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// Smoothness is the estimated smoothness of a Gaussian random field.
+type Smoothness struct {
+	FWHM   [3]float64 // full width at half maximum along each axis, in voxels
+	Voxels int        // voxels in the search volume
+	Resels float64    // resolution elements in the search volume
+	DLH    float64    // sqrt(det(Lambda)) / (4 ln 2)^(3/2), as reported by FSL
+}
+
+// EstimateSmoothness estimates the smoothness of the field from residuals
+// of a model, stored volume by volume on a grid of the given dims, within
+// mask (all voxels if nil). Each voxel's series is normalized to unit
+// variance, and the variance of its spatial derivatives, from differences
+// of neighbors both in the mask, gives FWHM = sqrt(4 ln 2 / var) (Kiebel
+// et al., 1999). No correction for the degrees of freedom is applied.
+func EstimateSmoothness(residuals []float64, dims [3]int, mask []bool) (Smoothness, error) {
+	var s Smoothness
+	nxyz := dims[0] * dims[1] * dims[2]
+	nt := len(residuals) / nxyz
+	if nt < 2 || nt*nxyz != len(residuals) {
+		return s, fmt.Errorf("smoothness requires at least 2 residual volumes")
+	}
+	// Voxels used, without those of constant series.
+	used := make([]bool, nxyz)
+	for i := range used {
+		used[i] = mask == nil || mask[i]
+	}
+	mask = used
+
+	// Normalize the series of each voxel.
+	norm := make([]float64, len(residuals))
+	for m := 0; m < nxyz; m++ {
+		if !mask[m] {
+			continue
+		}
+		var sum, sumSq numeric.Accumulator
+		for t := 0; t < nt; t++ {
+			x := residuals[t*nxyz+m]
+			sum.Add(x)
+			sumSq.AddProduct(x, x)
+		}
+		mean := sum.Sum() / float64(nt)
+		sd := math.Sqrt(math.Max(sumSq.Sum()-float64(nt)*mean*mean, 0) / float64(nt-1))
+		if sd == 0 {
+			mask[m] = false
+			continue
+		}
+		s.Voxels++
+		for t := 0; t < nt; t++ {
+			norm[t*nxyz+m] = (residuals[t*nxyz+m] - mean) / sd
+		}
+	}
+
+	stride := [3]int{1, dims[0], dims[0] * dims[1]}
+	var lambda [3]float64
+	for a := 0; a < 3; a++ {
+		var sum numeric.Accumulator
+		n := 0
+		for m := 0; m < nxyz; m++ {
+			coord := m / stride[a] % dims[a]
+			if !mask[m] || coord+1 >= dims[a] || !mask[m+stride[a]] {
+				continue
+			}
+			for t := 0; t < nt; t++ {
+				d := norm[t*nxyz+m+stride[a]] - norm[t*nxyz+m]
+				sum.AddProduct(d, d)
+			}
+			n += nt
+		}
+		if n == 0 || sum.Sum() == 0 {
+			return s, fmt.Errorf("cannot estimate the smoothness along axis %d", a)
+		}
+		lambda[a] = sum.Sum() / float64(n)
+		s.FWHM[a] = math.Sqrt(4 * math.Ln2 / lambda[a])
+	}
+	s.Resels = float64(s.Voxels) / (s.FWHM[0] * s.FWHM[1] * s.FWHM[2])
+	s.DLH = math.Sqrt(lambda[0]*lambda[1]*lambda[2]) / math.Pow(4*math.Ln2, 1.5)
+	return s, nil
+}
@@ -0,0 +1,163 @@
+// synth generates synthetic images from a template, for testing group
+// pipelines and the statistics of gonifti itself: subjects with noise,
+// lesions, and jittered head positions whose ground truth is known.
+
+package synth
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/register"
+)
+
+// CohortOptions configure the subjects of a cohort.
+type CohortOptions struct {
+	// Seed makes the cohort reproducible. Subject i uses Seed + i, so a
+	// subject does not change with the size of the cohort.
+	Seed int64
+	// Noise is the standard deviation of Gaussian noise, as a fraction of
+	// the mean of the template's nonzero voxels.
+	Noise float64
+	// Lesions is the number of lesions of each subject, placed in nonzero
+	// voxels of the template.
+	Lesions int
+	// LesionRadius is the range of lesion radii in mm.
+	LesionRadius [2]float64
+	// LesionIntensity multiplies the values inside the lesions.
+	LesionIntensity float64
+	// LesionShape, LesionIrregularity, and LesionProfile are the Shape,
+	// Irregularity, and Profile of every lesion.
+	LesionShape        string
+	LesionIrregularity float64
+	LesionProfile      string
+	// Translation is the largest shift of the head in mm along each axis,
+	// and Rotation the largest rotation in degrees about each axis.
+	Translation, Rotation float64
+}
+
+// DefaultCohortOptions add 5% noise, two spheres of 3 to 8 mm that
+// brighten the tissue by half, and jitter of up to 5 mm and 5 degrees.
+var DefaultCohortOptions = CohortOptions{
+	Seed:               1,
+	Noise:              0.05,
+	Lesions:            2,
+	LesionRadius:       [2]float64{3, 8},
+	LesionIntensity:    1.5,
+	LesionShape:        Sphere,
+	LesionIrregularity: 0.3,
+	LesionProfile:      Flat,
+	Translation:        5,
+	Rotation:           5,
+}
+
+// Subject is a synthetic subject and its ground truth.
+type Subject struct {
+	// Image has the template's grid, with the jittered affine.
+	Image *nifti1.Image
+	// Lesions is a DT_UINT8 map of the lesions on the same grid, labeled
+	// from 1 in the order of LesionList; later lesions overwrite earlier
+	// ones where they overlap.
+	Lesions *nifti1.Image
+	// Jitter holds the translations (mm) and rotations (rad) of the head,
+	// as in register.ParamsMatrix, and Matrix maps the template's world
+	// coordinates to the subject's.
+	Jitter [6]float64
+	Matrix [4][4]float64
+	// LesionList lists the lesions inserted.
+	LesionList []Lesion
+}
+
+// NewSubject returns subject i of a cohort generated from the first volume
+// of template. The voxel values stay on the template's grid and the head
+// moves in world space: the affine of the subject is the template's
+// premultiplied by the jitter, as when a head is positioned differently in
+// the scanner.
+func NewSubject(template *nifti1.Image, o CohortOptions, i int) (*Subject, error) {
+	if o.LesionRadius[0] <= 0 || o.LesionRadius[1] < o.LesionRadius[0] {
+		return nil, fmt.Errorf("invalid lesion radii %v", o.LesionRadius)
+	}
+	if o.Lesions > 255 {
+		return nil, fmt.Errorf("at most 255 lesions can be labeled, got %d", o.Lesions)
+	}
+	all, err := template.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	dims := [3]int{template.Nx, template.Ny, template.Nz}
+	nxyz := dims[0] * dims[1] * dims[2]
+	values := append([]float64(nil), all[:nxyz]...)
+
+	tissue := tissueVoxels(values)
+	if len(tissue) == 0 {
+		return nil, fmt.Errorf("template has no nonzero voxels")
+	}
+	mean := 0.0
+	for _, m := range tissue {
+		mean += values[m]
+	}
+	mean /= float64(len(tissue))
+
+	rng := rand.New(rand.NewSource(o.Seed + int64(i)))
+	s := &Subject{}
+	labels := make([]uint8, nxyz)
+	voxelSize := template.VoxelSizeMM()
+	for l := 0; l < o.Lesions; l++ {
+		lesion := Lesion{
+			Center:       voxelIndex(tissue[rng.Intn(len(tissue))], dims),
+			Radius:       o.LesionRadius[0] + rng.Float64()*(o.LesionRadius[1]-o.LesionRadius[0]),
+			Intensity:    o.LesionIntensity,
+			Shape:        o.LesionShape,
+			Irregularity: o.LesionIrregularity,
+			Seed:         rng.Int63(),
+			Profile:      o.LesionProfile,
+		}
+		if _, err := lesion.Insert(values, dims, voxelSize, labels, uint8(l+1)); err != nil {
+			return nil, err
+		}
+		s.LesionList = append(s.LesionList, lesion)
+	}
+	if o.Noise > 0 {
+		sd := o.Noise * mean
+		for m := range values {
+			values[m] += sd * rng.NormFloat64()
+		}
+	}
+
+	for a := 0; a < 3; a++ {
+		s.Jitter[a] = o.Translation * (2*rng.Float64() - 1)
+		s.Jitter[a+3] = o.Rotation * math.Pi / 180 * (2*rng.Float64() - 1)
+	}
+	affine := template.Affine()
+	center := linalg.ApplyAffine(affine, [3]float64{
+		float64(dims[0]-1) / 2, float64(dims[1]-1) / 2, float64(dims[2]-1) / 2,
+	})
+	s.Matrix = register.ParamsMatrix(s.Jitter[:], center)
+	affine = linalg.MulAffine(s.Matrix, affine)
+
+	xform := template.SFormCode
+	if xform == 0 {
+		xform = template.QFormCode
+	}
+	if xform == 0 {
+		xform = nifti1.XformScannerAnat
+	}
+	if s.Image, err = nifti1.NewImage(nifti1.DTFloat32, dims[:], affine, xform); err != nil {
+		return nil, err
+	}
+	if err := s.Image.SetFloat32Data(values); err != nil {
+		return nil, err
+	}
+	if s.Lesions, err = nifti1.NewImage(nifti1.DTUint8, dims[:], affine, xform); err != nil {
+		return nil, err
+	}
+	copy(s.Lesions.Data, labels)
+	// The affine is in the template's units.
+	if template.XYZUnits != 0 {
+		s.Image.XYZUnits, s.Lesions.XYZUnits = template.XYZUnits, template.XYZUnits
+	}
+	return s, nil
+}
@@ -0,0 +1,200 @@
+package synth
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Lesion shapes.
+const (
+	Sphere    = "sphere"
+	Irregular = "irregular" // a sphere with a randomly perturbed radius
+)
+
+// Intensity profiles of lesions, as a function of the distance from the
+// center relative to the edge.
+const (
+	// Flat applies Intensity throughout.
+	Flat = "flat"
+	// Gaussian applies Intensity at the center, fading toward no change at
+	// the edge.
+	Gaussian = "gaussian"
+	// Rim applies Intensity at the edge, fading toward no change at the
+	// center, like a ring-enhancing lesion.
+	Rim = "rim"
+)
+
+// Shapes and Profiles list the lesion shapes and intensity profiles.
+var (
+	Shapes   = []string{Sphere, Irregular}
+	Profiles = []string{Flat, Gaussian, Rim}
+)
+
+// irregularTerms is the number of random waves that perturb the radius of
+// an irregular lesion.
+const irregularTerms = 6
+
+// Lesion is a synthetic lesion.
+type Lesion struct {
+	// Center is the voxel index of the center.
+	Center [3]float64
+	// Radius is in mm.
+	Radius float64
+	// Intensity multiplies the values inside the lesion, as shaped by
+	// Profile.
+	Intensity float64
+	// Shape is Sphere, the default, or Irregular.
+	Shape string
+	// Irregularity is the largest change of the radius of an Irregular
+	// lesion, as a fraction of Radius, and Seed selects its shape.
+	Irregularity float64
+	Seed         int64
+	// Profile is Flat, the default, Gaussian, or Rim.
+	Profile string
+}
+
+// Insert applies the lesion to values, a volume of dims voxels of the given
+// sizes in mm stored with i fastest, and returns the number of voxels
+// inside it. If mask is not nil, the voxels inside the lesion are set to
+// label in it, giving the ground truth.
+func (l Lesion) Insert(values []float64, dims [3]int, voxelSize [3]float64, mask []uint8, label uint8) (int, error) {
+	radius, err := l.radiusFunc()
+	if err != nil {
+		return 0, err
+	}
+	factor, err := l.profileFunc()
+	if err != nil {
+		return 0, err
+	}
+
+	// Bound the lesion by its largest possible radius.
+	reach := l.Radius
+	if l.Shape == Irregular {
+		reach *= 1 + l.Irregularity
+	}
+	var lo, hi [3]int
+	for a := 0; a < 3; a++ {
+		r := reach / voxelSize[a]
+		lo[a] = int(math.Max(0, math.Floor(l.Center[a]-r)))
+		hi[a] = int(math.Min(float64(dims[a]-1), math.Ceil(l.Center[a]+r)))
+	}
+	n := 0
+	for k := lo[2]; k <= hi[2]; k++ {
+		for j := lo[1]; j <= hi[1]; j++ {
+			for i := lo[0]; i <= hi[0]; i++ {
+				var u [3]float64
+				d := 0.0
+				for a, x := range [3]int{i, j, k} {
+					u[a] = (float64(x) - l.Center[a]) * voxelSize[a]
+					d += u[a] * u[a]
+				}
+				d = math.Sqrt(d)
+				if d > 0 {
+					for a := range u {
+						u[a] /= d
+					}
+				}
+				rho := d / radius(u)
+				if rho > 1 {
+					continue
+				}
+				m := i + dims[0]*(j+dims[1]*k)
+				values[m] *= factor(rho)
+				if mask != nil {
+					mask[m] = label
+				}
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// radiusFunc returns the radius in mm of the lesion along a unit vector.
+func (l Lesion) radiusFunc() (func(u [3]float64) float64, error) {
+	if l.Radius <= 0 {
+		return nil, fmt.Errorf("invalid lesion radius %g", l.Radius)
+	}
+	switch l.Shape {
+	case Sphere, "":
+		return func([3]float64) float64 { return l.Radius }, nil
+	case Irregular:
+	default:
+		return nil, fmt.Errorf("unknown lesion shape %q", l.Shape)
+	}
+	if l.Irregularity < 0 || l.Irregularity >= 1 {
+		return nil, fmt.Errorf("lesion irregularity must be in [0, 1), got %g", l.Irregularity)
+	}
+
+	// Sum waves of 1 to 3 cycles over the sphere along random directions,
+	// scaled so that the radius stays within Irregularity of Radius.
+	rng := rand.New(rand.NewSource(l.Seed))
+	var dirs [irregularTerms][3]float64
+	var freq, phase, amp [irregularTerms]float64
+	total := 0.0
+	for t := range dirs {
+		z := 2*rng.Float64() - 1
+		theta := 2 * math.Pi * rng.Float64()
+		s := math.Sqrt(1 - z*z)
+		dirs[t] = [3]float64{s * math.Cos(theta), s * math.Sin(theta), z}
+		freq[t] = float64(1 + rng.Intn(3))
+		phase[t] = 2 * math.Pi * rng.Float64()
+		amp[t] = rng.Float64()
+		total += amp[t]
+	}
+	return func(u [3]float64) float64 {
+		w := 0.0
+		for t := range dirs {
+			dot := u[0]*dirs[t][0] + u[1]*dirs[t][1] + u[2]*dirs[t][2]
+			w += amp[t] * math.Sin(freq[t]*math.Pi*dot+phase[t])
+		}
+		return l.Radius * (1 + l.Irregularity*w/total)
+	}, nil
+}
+
+// profileFunc returns the factor applied at distance rho from the center,
+// relative to the edge.
+func (l Lesion) profileFunc() (func(rho float64) float64, error) {
+	c := l.Intensity - 1
+	switch l.Profile {
+	case Flat, "":
+		return func(float64) float64 { return l.Intensity }, nil
+	case Gaussian:
+		// The Gaussian is 1% of its peak at the edge.
+		return func(rho float64) float64 { return 1 + c*math.Exp(-math.Ln10*2*rho*rho) }, nil
+	case Rim:
+		return func(rho float64) float64 { return 1 + c*rho*rho }, nil
+	}
+	return nil, fmt.Errorf("unknown lesion profile %q", l.Profile)
+}
+
+// RandomCenters returns n lesion centers drawn from the nonzero voxels of
+// values, a volume of dims voxels.
+func RandomCenters(values []float64, dims [3]int, n int, rng *rand.Rand) ([][3]float64, error) {
+	tissue := tissueVoxels(values)
+	if len(tissue) == 0 {
+		return nil, fmt.Errorf("no nonzero voxels to place lesions in")
+	}
+	centers := make([][3]float64, n)
+	for c := range centers {
+		centers[c] = voxelIndex(tissue[rng.Intn(len(tissue))], dims)
+	}
+	return centers, nil
+}
+
+// tissueVoxels returns the indices of the nonzero voxels.
+func tissueVoxels(values []float64) []int {
+	var tissue []int
+	for m, v := range values {
+		if v != 0 && !math.IsNaN(v) {
+			tissue = append(tissue, m)
+		}
+	}
+	return tissue
+}
+
+// voxelIndex returns the (i, j, k) index of voxel m.
+func voxelIndex(m int, dims [3]int) [3]float64 {
+	return [3]float64{float64(m % dims[0]), float64(m / dims[0] % dims[1]), float64(m / (dims[0] * dims[1]))}
+}
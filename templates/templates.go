@@ -0,0 +1,262 @@
+// templates downloads standard-space templates, such as MNI152 from
+// TemplateFlow, into a local cache and opens them as images. Downloads are
+// verified against a SHA-256 checksum: either the one registered with the
+// asset or, for assets registered without one, the checksum pinned in the
+// cache when the asset was first downloaded.
+// https://www.templateflow.org
+
+package templates
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrChecksum is returned when a downloaded or cached asset does not match
+// its checksum.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// CacheDir is the directory assets are stored in. It defaults to gonifti
+// in the user cache directory; the command line sets it from the config.
+var CacheDir string
+
+// HTTPClient downloads assets.
+var HTTPClient = &http.Client{Timeout: 10 * time.Minute}
+
+// TemplateFlowURL is the base URL of the TemplateFlow archive, which
+// relative asset URLs are resolved against. It may be set to a mirror.
+var TemplateFlowURL = "https://templateflow.s3.amazonaws.com"
+
+// Asset is a file that can be downloaded into the cache.
+type Asset struct {
+	Name   string // unique name, also the file name in the cache
+	URL    string // absolute, or relative to TemplateFlowURL
+	SHA256 string // hex checksum; empty pins it on first download
+}
+
+// url returns the absolute URL of the asset.
+func (a Asset) url() string {
+	if strings.Contains(a.URL, "://") {
+		return a.URL
+	}
+	return strings.TrimSuffix(TemplateFlowURL, "/") + "/" + a.URL
+}
+
+var (
+	mu     sync.Mutex
+	assets = map[string]Asset{}
+)
+
+// Register adds an asset, replacing any asset with the same name.
+func Register(a Asset) {
+	mu.Lock()
+	defer mu.Unlock()
+	assets[a.Name] = a
+}
+
+// Lookup returns the asset with the given name.
+func Lookup(name string) (Asset, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	a, ok := assets[name]
+	return a, ok
+}
+
+// Assets returns the registered assets sorted by name.
+func Assets() []Asset {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]Asset, 0, len(assets))
+	for _, a := range assets {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// templateFlow registers a TemplateFlow file of the form
+// tpl-<template>/tpl-<template>_<suffix>.nii.gz.
+func templateFlow(template, suffix string) {
+	name := fmt.Sprintf("tpl-%s_%s.nii.gz", template, suffix)
+	Register(Asset{Name: name, URL: fmt.Sprintf("tpl-%s/%s", template, name)})
+}
+
+func init() {
+	if dir, err := os.UserCacheDir(); err == nil {
+		CacheDir = filepath.Join(dir, "gonifti")
+	}
+	for _, res := range []string{"res-01", "res-02"} {
+		templateFlow("MNI152NLin2009cAsym", res+"_T1w")
+		templateFlow("MNI152NLin2009cAsym", res+"_desc-brain_mask")
+		templateFlow("MNI152NLin6Asym", res+"_T1w")
+	}
+}
+
+// assetDir returns the directory of cached assets.
+func assetDir() (string, error) {
+	if CacheDir == "" {
+		return "", fmt.Errorf("no cache directory")
+	}
+	return filepath.Join(CacheDir, "templates"), nil
+}
+
+// Path returns the path of an asset in the cache, downloading it first if
+// it is missing. The cached file is verified on every call, so corruption
+// is found before the file is used.
+func Path(name string) (string, error) {
+	a, ok := Lookup(name)
+	if !ok {
+		return "", fmt.Errorf("unknown template asset %q", name)
+	}
+	dir, err := assetDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, a.Name)
+	want := a.SHA256
+	if want == "" {
+		if b, err := ioutil.ReadFile(path + ".sha256"); err == nil {
+			want = strings.TrimSpace(string(b))
+		}
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		got, err := fileSHA256(path)
+		if err != nil {
+			return "", err
+		}
+		if want != "" && !strings.EqualFold(got, want) {
+			return "", fmt.Errorf("%s: %w: got %s, expected %s (delete the file to download it again)", path, ErrChecksum, got, want)
+		}
+		return path, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	got, err := download(a.url(), path)
+	if err != nil {
+		return "", err
+	}
+	if want != "" && !strings.EqualFold(got, want) {
+		os.Remove(path)
+		return "", fmt.Errorf("%s: %w: got %s, expected %s", a.url(), ErrChecksum, got, want)
+	}
+	if want == "" {
+		// Pin the checksum, so later changes to the file are detected.
+		if err := util.WriteFileAtomic(path+".sha256", []byte(got+"\n"), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"asset":  a.Name,
+		"path":   path,
+		"sha256": got,
+	}).Info("Downloaded template")
+
+	return path, nil
+}
+
+// download writes the content at url to path through a temporary file and
+// returns its checksum. Failed requests are retried and interrupted
+// downloads resumed as util.DefaultRetry says.
+func download(url, path string) (string, error) {
+	resp, err := util.DefaultRetry.Open(context.Background(), HTTPClient, func() (*http.Request, error) {
+		return http.NewRequest("GET", url, nil)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer util.TrackTemp(f.Name())()
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		f.Close()
+		return "", fmt.Errorf("GET %s: %v", url, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Cached reports whether an asset is in the cache, without verifying it.
+func Cached(name string) bool {
+	a, ok := Lookup(name)
+	dir, err := assetDir()
+	if !ok || err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, a.Name))
+	return err == nil
+}
+
+// Open returns an asset as an image, downloading it if needed.
+func Open(name string, opts ...nifti1.ReadOption) (*nifti1.Image, error) {
+	path, err := Path(name)
+	if err != nil {
+		return nil, err
+	}
+	return nifti1.ReadFile(path, opts...)
+}
+
+// MNI152 returns the MNI152NLin2009cAsym T1-weighted template at a
+// resolution of 1 or 2 mm.
+func MNI152(res int) (*nifti1.Image, error) {
+	if res != 1 && res != 2 {
+		return nil, fmt.Errorf("%w MNI152 resolution %d mm (1 or 2)", nifti1.ErrUnsupported, res)
+	}
+	return Open(fmt.Sprintf("tpl-MNI152NLin2009cAsym_res-%02d_T1w.nii.gz", res))
+}
+
+// MNI152Mask returns the brain mask of MNI152 at a resolution of 1 or 2 mm.
+func MNI152Mask(res int) (*nifti1.Image, error) {
+	if res != 1 && res != 2 {
+		return nil, fmt.Errorf("%w MNI152 resolution %d mm (1 or 2)", nifti1.ErrUnsupported, res)
+	}
+	return Open(fmt.Sprintf("tpl-MNI152NLin2009cAsym_res-%02d_desc-brain_mask.nii.gz", res))
+}
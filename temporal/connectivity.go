@@ -0,0 +1,208 @@
+package temporal
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Labels returns the integer labels of the voxels of an atlas, with 0 for
+// background.
+func Labels(atlas *nifti1.Image) ([]int, error) {
+	values, err := atlas.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]int, atlas.Nx*atlas.Ny*atlas.Nz)
+	for i := range labels {
+		labels[i] = int(math.Round(values[i]))
+	}
+	return labels, nil
+}
+
+// ROISeries returns the sorted nonzero labels and the mean time series of
+// the voxels of each.
+func ROISeries(img *nifti1.Image, labels []int) ([]int, [][]float64, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	nt := img.NVox / nxyz
+	if len(labels) != nxyz {
+		return nil, nil, fmt.Errorf("labels: %w", nifti1.ErrGridMismatch)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids, index, count := roiIndex(labels)
+	series := make([][]float64, len(ids))
+	for i := range ids {
+		series[i] = make([]float64, nt)
+	}
+
+	for m, l := range labels {
+		if l == 0 {
+			continue
+		}
+		s := series[index[l]]
+		for t := range s {
+			s[t] += values[t*nxyz+m]
+		}
+	}
+	for i, l := range ids {
+		for t := range series[i] {
+			series[i][t] /= float64(count[l])
+		}
+	}
+	return ids, series, nil
+}
+
+// StreamROISeries is like ROISeries, but reads the volumes one at a time
+// from r, so that the image need not be held in memory.
+func StreamROISeries(r *nifti1.VolumeReader, labels []int) ([]int, [][]float64, error) {
+	img := r.Image
+	if len(labels) != img.Nx*img.Ny*img.Nz {
+		return nil, nil, fmt.Errorf("labels: %w", nifti1.ErrGridMismatch)
+	}
+	ids, index, count := roiIndex(labels)
+	series := make([][]float64, len(ids))
+	for i := range ids {
+		series[i] = make([]float64, 0, r.Len())
+	}
+	sums := make([]float64, len(ids))
+	for {
+		values, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range sums {
+			sums[i] = 0
+		}
+		for m, l := range labels {
+			if l != 0 {
+				sums[index[l]] += values[m]
+			}
+		}
+		for i, l := range ids {
+			series[i] = append(series[i], sums[i]/float64(count[l]))
+		}
+	}
+	return ids, series, nil
+}
+
+// roiIndex returns the sorted nonzero labels, the position of each in that
+// order, and their voxel counts.
+func roiIndex(labels []int) ([]int, map[int]int, map[int]int) {
+	count := map[int]int{}
+	for _, l := range labels {
+		if l != 0 {
+			count[l]++
+		}
+	}
+	ids := make([]int, 0, len(count))
+	for l := range count {
+		ids = append(ids, l)
+	}
+	sort.Ints(ids)
+	index := map[int]int{}
+	for i, l := range ids {
+		index[l] = i
+	}
+	return ids, index, count
+}
+
+// standardize returns x minus its mean, divided by its norm, so that the
+// correlation of two series is their dot product. A constant series is all
+// zeros.
+func standardize(x []float64) []float64 {
+	mean := meanOf(x)
+	z := make([]float64, len(x))
+	norm := 0.0
+	for i, v := range x {
+		z[i] = v - mean
+		norm += z[i] * z[i]
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range z {
+			z[i] /= norm
+		}
+	}
+	return z
+}
+
+func dot(a, b []float64) float64 {
+	s := 0.0
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+// Correlation returns the matrix of Pearson correlations between series.
+// Constant series have a correlation of 0 with everything.
+func Correlation(series [][]float64) [][]float64 {
+	z := make([][]float64, len(series))
+	for i, s := range series {
+		z[i] = standardize(s)
+	}
+	r := make([][]float64, len(series))
+	for i := range r {
+		r[i] = make([]float64, len(series))
+		for j := 0; j <= i; j++ {
+			r[i][j] = dot(z[i], z[j])
+			r[j][i] = r[i][j]
+		}
+	}
+	return r
+}
+
+// FisherZ returns the Fisher z-transform of a correlation, atanh(r), with r
+// clamped to keep the result finite.
+func FisherZ(r float64) float64 {
+	const limit = 1 - 1e-7
+	return math.Atanh(math.Max(-limit, math.Min(limit, r)))
+}
+
+// SeedMaps returns the correlation of each seed time series with the
+// series of every voxel in mask (all voxels if mask is nil), one map per
+// seed; voxels outside the mask are 0.
+func SeedMaps(img *nifti1.Image, seeds [][]float64, mask []bool) ([][]float64, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	nt := img.NVox / nxyz
+	if mask != nil && len(mask) != nxyz {
+		return nil, fmt.Errorf("mask: %w", nifti1.ErrGridMismatch)
+	}
+	zs := make([][]float64, len(seeds))
+	maps := make([][]float64, len(seeds))
+	for i, s := range seeds {
+		if len(s) != nt {
+			return nil, fmt.Errorf("seed %d has %d time points, image has %d", i, len(s), nt)
+		}
+		zs[i] = standardize(s)
+		maps[i] = make([]float64, nxyz)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	series := make([]float64, nt)
+	for m := 0; m < nxyz; m++ {
+		if mask != nil && !mask[m] {
+			continue
+		}
+		for t := range series {
+			series[t] = values[t*nxyz+m]
+		}
+		z := standardize(series)
+		for i := range zs {
+			maps[i][m] = dot(zs[i], z)
+		}
+	}
+	return maps, nil
+}
@@ -0,0 +1,187 @@
+package temporal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Event is a row of a BIDS events.tsv file.
+type Event struct {
+	Onset     float64 // seconds from the first volume
+	Duration  float64 // seconds
+	TrialType string  // empty if the file has no trial_type column
+}
+
+// ReadEvents reads the onset, duration, and trial_type columns of a BIDS
+// events file. Events with a missing onset are skipped.
+func ReadEvents(name string) ([]Event, error) {
+	t, err := bids.ReadTSV(name)
+	if err != nil {
+		return nil, err
+	}
+	onsets, err := t.Float("onset")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	durations, err := t.Float("duration")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	types := t.Column("trial_type")
+	var events []Event
+	for i, row := range t.Rows {
+		if math.IsNaN(onsets[i]) {
+			continue
+		}
+		e := Event{Onset: onsets[i], Duration: durations[i]}
+		if math.IsNaN(e.Duration) {
+			e.Duration = 0
+		}
+		if types >= 0 && row[types] != bids.NA {
+			e.TrialType = row[types]
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// TrialTypes returns the distinct trial types of events, sorted.
+func TrialTypes(events []Event) []string {
+	seen := map[string]bool{}
+	var types []string
+	for _, e := range events {
+		if !seen[e.TrialType] {
+			seen[e.TrialType] = true
+			types = append(types, e.TrialType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Onsets returns the onsets of the events of a trial type.
+func Onsets(events []Event, trialType string) []float64 {
+	var onsets []float64
+	for _, e := range events {
+		if e.TrialType == trialType {
+			onsets = append(onsets, e.Onset)
+		}
+	}
+	return onsets
+}
+
+// PeriOptions configure PeriEvent.
+type PeriOptions struct {
+	Pre, Post float64 // window before and after each onset in seconds
+	Baseline  bool    // subtract the mean of the samples before the onset
+	Percent   bool    // express values as percent change from that mean
+}
+
+// Offsets returns the times of the samples of the window relative to the
+// onsets: multiples of tr from -Pre to Post.
+func (o PeriOptions) Offsets(tr float64) []float64 {
+	var times []float64
+	for k := -int(math.Floor(o.Pre/tr + 1e-9)); float64(k)*tr <= o.Post+1e-9; k++ {
+		times = append(times, float64(k)*tr)
+	}
+	return times
+}
+
+// PeriResult is the average time course around the onsets of events.
+type PeriResult struct {
+	Times []float64 // seconds from the onset
+	Mean  []float64
+	SEM   []float64 // standard error of the mean, NaN for one event
+	N     int       // number of events whose window fits in the series
+}
+
+// PeriEvent averages the series, sampled every tr seconds, in the window
+// around each onset. Samples between volumes are interpolated linearly.
+// Events whose window extends beyond the series are skipped.
+func PeriEvent(series []float64, tr float64, onsets []float64, o PeriOptions) PeriResult {
+	times := o.Offsets(tr)
+	res := PeriResult{Times: times, Mean: make([]float64, len(times)), SEM: make([]float64, len(times))}
+	sumSq := make([]float64, len(times))
+	epoch := make([]float64, len(times))
+	last := float64(len(series) - 1)
+	for _, onset := range onsets {
+		first, end := (onset+times[0])/tr, (onset+times[len(times)-1])/tr
+		if len(series) < 2 || first < 0 || end > last {
+			continue
+		}
+		base, nb := 0.0, 0
+		for i, dt := range times {
+			p := (onset + dt) / tr
+			t := int(p)
+			if t >= len(series)-1 {
+				t = len(series) - 2
+			}
+			f := p - float64(t)
+			epoch[i] = series[t]*(1-f) + series[t+1]*f
+			if dt < 0 {
+				base += epoch[i]
+				nb++
+			}
+		}
+		if (o.Baseline || o.Percent) && nb > 0 {
+			base /= float64(nb)
+			for i := range epoch {
+				epoch[i] -= base
+				if o.Percent {
+					if base != 0 {
+						epoch[i] *= 100 / base
+					} else {
+						epoch[i] = 0
+					}
+				}
+			}
+		}
+		for i, x := range epoch {
+			res.Mean[i] += x
+			sumSq[i] += x * x
+		}
+		res.N++
+	}
+	for i := range res.Mean {
+		if res.N == 0 {
+			res.Mean[i], res.SEM[i] = math.NaN(), math.NaN()
+			continue
+		}
+		n := float64(res.N)
+		res.Mean[i] /= n
+		res.SEM[i] = math.NaN()
+		if res.N > 1 {
+			variance := math.Max(sumSq[i]/n-res.Mean[i]*res.Mean[i], 0) * n / (n - 1)
+			res.SEM[i] = math.Sqrt(variance / n)
+		}
+	}
+	return res
+}
+
+// PeriEventImage returns the peri-event average of every voxel, stored as
+// one volume per sample of the window, with workers goroutines, and the
+// number of events averaged.
+func PeriEventImage(img *nifti1.Image, tr float64, onsets []float64, o PeriOptions, workers int) ([]float64, int, error) {
+	values, nxyz, err := seriesValues(img, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	nt := len(values) / nxyz
+	// The events that fit depend only on the number of volumes.
+	n := PeriEvent(make([]float64, nt), tr, onsets, o).N
+	if n == 0 {
+		return nil, 0, fmt.Errorf("no event window fits in the %d volumes", nt)
+	}
+	out := make([]float64, len(o.Offsets(tr))*nxyz)
+	eachSeries(values, nxyz, nil, workers, func(m int, series []float64) {
+		res := PeriEvent(series, tr, onsets, o)
+		for k, x := range res.Mean {
+			out[k*nxyz+m] = x
+		}
+	})
+	return out, n, nil
+}
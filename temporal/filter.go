@@ -0,0 +1,148 @@
+// temporal contains filters of voxel time series: zero-phase Butterworth
+// high-pass, low-pass, and band-pass filters with cutoffs in Hz.
+
+package temporal
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// section is a first- or second-order filter section in transposed direct
+// form II: y = b0 x + z1, z1 = b1 x - a1 y + z2, z2 = b2 x - a2 y.
+type section struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+}
+
+// gain returns the gain of the section at 0 Hz.
+func (s section) gain() float64 {
+	return (s.b0 + s.b1 + s.b2) / (1 + s.a1 + s.a2)
+}
+
+// Filter is a Butterworth filter, applied forwards and backwards so that it
+// has no phase shift. The magnitude response is that of the filter squared.
+type Filter struct {
+	sections []section
+}
+
+// Butterworth returns a filter of the given order for samples every tr
+// seconds. Frequencies below highpass and above lowpass (in Hz) are
+// removed; either may be 0 to leave that side unfiltered, and together
+// they give a band-pass filter.
+func Butterworth(order int, tr, highpass, lowpass float64) (*Filter, error) {
+	if order < 1 {
+		return nil, fmt.Errorf("invalid filter order %d", order)
+	}
+	if tr <= 0 {
+		return nil, fmt.Errorf("invalid repetition time %g s", tr)
+	}
+	nyquist := 0.5 / tr
+	if highpass < 0 || highpass >= nyquist || lowpass < 0 || lowpass >= nyquist {
+		return nil, fmt.Errorf("cutoffs must be in [0, %g) Hz, the Nyquist frequency at TR %g s", nyquist, tr)
+	}
+	if highpass == 0 && lowpass == 0 {
+		return nil, fmt.Errorf("no cutoff given")
+	}
+	if lowpass > 0 && highpass >= lowpass {
+		return nil, fmt.Errorf("high-pass cutoff %g Hz is not below the low-pass cutoff %g Hz", highpass, lowpass)
+	}
+	f := &Filter{}
+	if highpass > 0 {
+		f.sections = append(f.sections, design(order, math.Tan(math.Pi*highpass*tr), true)...)
+	}
+	if lowpass > 0 {
+		f.sections = append(f.sections, design(order, math.Tan(math.Pi*lowpass*tr), false)...)
+	}
+	return f, nil
+}
+
+// design returns the sections of a Butterworth filter with the prewarped
+// cutoff k, by the bilinear transform of the analog prototype.
+func design(order int, k float64, highpass bool) []section {
+	var sections []section
+	for i := 0; i < order/2; i++ {
+		q := 1 / (2 * math.Sin(float64(2*i+1)*math.Pi/float64(2*order)))
+		norm := 1 / (1 + k/q + k*k)
+		s := section{a1: 2 * (k*k - 1) * norm, a2: (1 - k/q + k*k) * norm}
+		if highpass {
+			s.b0, s.b1, s.b2 = norm, -2*norm, norm
+		} else {
+			s.b0 = k * k * norm
+			s.b1, s.b2 = 2*s.b0, s.b0
+		}
+		sections = append(sections, s)
+	}
+	if order%2 == 1 {
+		norm := 1 / (1 + k)
+		s := section{a1: (k - 1) * norm}
+		if highpass {
+			s.b0, s.b1 = norm, -norm
+		} else {
+			s.b0, s.b1 = k*norm, k*norm
+		}
+		sections = append(sections, s)
+	}
+	return sections
+}
+
+// pass filters x in place in one direction, starting each section in its
+// steady state for the first sample.
+func (f *Filter) pass(x []float64) {
+	for _, s := range f.sections {
+		x0 := x[0]
+		y0 := s.gain() * x0
+		z1, z2 := y0-s.b0*x0, s.b2*x0-s.a2*y0
+		for i, u := range x {
+			y := s.b0*u + z1
+			z1 = s.b1*u - s.a1*y + z2
+			z2 = s.b2*u - s.a2*y
+			x[i] = y
+		}
+	}
+}
+
+// Apply filters a time series in place. The series is extended at both
+// ends by odd reflection to reduce edge effects.
+func (f *Filter) Apply(x []float64) {
+	n := len(x)
+	if n < 2 {
+		return
+	}
+	pad := 3 * (2*len(f.sections) + 1)
+	if pad > n-1 {
+		pad = n - 1
+	}
+	ext := make([]float64, n+2*pad)
+	for i := 0; i < pad; i++ {
+		ext[pad-1-i] = 2*x[0] - x[i+1]
+		ext[pad+n+i] = 2*x[n-1] - x[n-2-i]
+	}
+	copy(ext[pad:], x)
+
+	f.pass(ext)
+	for i, j := 0, len(ext)-1; i < j; i, j = i+1, j-1 {
+		ext[i], ext[j] = ext[j], ext[i]
+	}
+	f.pass(ext)
+	for i := range x {
+		x[i] = ext[len(ext)-1-pad-i]
+	}
+}
+
+// FilterImage filters the time series of every voxel in mask (all voxels
+// if mask is nil) with workers goroutines, and returns the result as
+// DT_FLOAT32. Voxels outside the mask are unchanged. If keepMean is set,
+// each filtered series is shifted to the mean of the original, which a
+// high-pass filter removes.
+func FilterImage(img *nifti1.Image, f *Filter, mask []bool, keepMean bool, workers int) (*nifti1.Image, error) {
+	return mapSeries(img, mask, workers, func(series []float64) {
+		mean := meanOf(series)
+		f.Apply(series)
+		if keepMean {
+			shift(series, mean-meanOf(series))
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package temporal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// detrend removes the least-squares line from x.
+func detrend(x []float64) {
+	n := float64(len(x))
+	mt, mx := (n-1)/2, meanOf(x)
+	num, den := 0.0, 0.0
+	for t, v := range x {
+		num += (float64(t) - mt) * (v - mx)
+		den += (float64(t) - mt) * (float64(t) - mt)
+	}
+	slope := 0.0
+	if den > 0 {
+		slope = num / den
+	}
+	for t := range x {
+		x[t] -= mx + slope*(float64(t)-mt)
+	}
+}
+
+// ALFF returns the amplitude of low-frequency fluctuations of every voxel
+// in mask (all voxels if mask is nil): the sum of the spectral amplitudes
+// between low and high Hz of the linearly detrended series, zero padded to
+// a power of 2. fALFF is ALFF divided by the sum of the amplitudes at all
+// frequencies above 0. Voxels outside the mask are 0.
+func ALFF(img *nifti1.Image, tr, low, high float64, mask []bool, workers int) (alff, falff []float64, err error) {
+	if tr <= 0 {
+		return nil, nil, fmt.Errorf("invalid repetition time %g s", tr)
+	}
+	if low < 0 || high <= low || high > 0.5/tr {
+		return nil, nil, fmt.Errorf("invalid band %g-%g Hz at TR %g s (Nyquist %g Hz)", low, high, tr, 0.5/tr)
+	}
+	values, nxyz, err := seriesValues(img, mask)
+	if err != nil {
+		return nil, nil, err
+	}
+	nt := len(values) / nxyz
+	n := nextPow2(nt)
+	df := 1 / (float64(n) * tr)
+
+	alff = make([]float64, nxyz)
+	falff = make([]float64, nxyz)
+	eachSeries(values, nxyz, mask, workers, func(m int, series []float64) {
+		detrend(series)
+		x := make([]complex128, n)
+		for t, v := range series {
+			x[t] = complex(v, 0)
+		}
+		fft(x)
+		band, total := 0.0, 0.0
+		for k := 1; k <= n/2; k++ {
+			a := 2 * math.Hypot(real(x[k]), imag(x[k])) / float64(nt)
+			total += a
+			if f := float64(k) * df; f >= low && f <= high {
+				band += a
+			}
+		}
+		alff[m] = band
+		if total > 0 {
+			falff[m] = band / total
+		}
+	})
+	return alff, falff, nil
+}
+
+// Neighborhoods of ReHo: faces, faces and edges, or the full cube.
+var neighborhoods = map[int]int{7: 1, 19: 2, 27: 3}
+
+// ReHo returns the regional homogeneity of every voxel in mask (all voxels
+// if mask is nil): Kendall's coefficient of concordance W between the time
+// series of the voxel and its neighbors in the mask, with 7, 19, or 27
+// voxels in the neighborhood. Ties get their average rank. Voxels outside
+// the mask are 0.
+func ReHo(img *nifti1.Image, neighbors int, mask []bool, workers int) ([]float64, error) {
+	level, ok := neighborhoods[neighbors]
+	if !ok {
+		return nil, fmt.Errorf("invalid neighborhood of %d voxels (7, 19, or 27)", neighbors)
+	}
+	values, nxyz, err := seriesValues(img, mask)
+	if err != nil {
+		return nil, err
+	}
+	nt := len(values) / nxyz
+	if nt < 2 {
+		return nil, fmt.Errorf("ReHo requires at least 2 volumes")
+	}
+
+	// Ranks of the series of every voxel in the mask.
+	ranks := make([][]float32, nxyz)
+	eachSeries(values, nxyz, mask, workers, func(m int, series []float64) {
+		ranks[m] = rank(series)
+	})
+
+	// Offsets within the neighborhood: level 1 has only faces, 2 adds
+	// edges, and 3 adds corners.
+	var offsets [][3]int
+	for dz := -1; dz <= 1; dz++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if abs(dx)+abs(dy)+abs(dz) <= level {
+					offsets = append(offsets, [3]int{dx, dy, dz})
+				}
+			}
+		}
+	}
+
+	nx, ny, nz := img.Nx, img.Ny, img.Nz
+	out := make([]float64, nxyz)
+	forVoxels(nxyz, mask, workers, func() func(int) {
+		sums := make([]float64, nt)
+		return func(m int) {
+			i, j, k := m%nx, m/nx%ny, m/(nx*ny)
+			for t := range sums {
+				sums[t] = 0
+			}
+			count := 0
+			for _, o := range offsets {
+				x, y, z := i+o[0], j+o[1], k+o[2]
+				if x < 0 || y < 0 || z < 0 || x >= nx || y >= ny || z >= nz {
+					continue
+				}
+				r := ranks[(z*ny+y)*nx+x]
+				if r == nil {
+					continue
+				}
+				for t, v := range r {
+					sums[t] += float64(v)
+				}
+				count++
+			}
+			out[m] = kendallW(sums, count, nt)
+		}
+	})
+	return out, nil
+}
+
+// kendallW returns Kendall's W of k series of n ranks, given the sum of
+// the ranks at each time point.
+func kendallW(sums []float64, k, n int) float64 {
+	if k < 2 {
+		return 0
+	}
+	mean := float64(k) * float64(n+1) / 2
+	s := 0.0
+	for _, r := range sums {
+		s += (r - mean) * (r - mean)
+	}
+	kf, nf := float64(k), float64(n)
+	return 12 * s / (kf * kf * (nf*nf*nf - nf))
+}
+
+// rank returns the ranks of x, from 1, with ties getting their average.
+func rank(x []float64) []float32 {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return x[idx[a]] < x[idx[b]] })
+	r := make([]float32, len(x))
+	for i := 0; i < len(idx); {
+		j := i
+		for j+1 < len(idx) && x[idx[j+1]] == x[idx[i]] {
+			j++
+		}
+		avg := float32(i+j)/2 + 1
+		for ; i <= j; i++ {
+			r[idx[i]] = avg
+		}
+	}
+	return r
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
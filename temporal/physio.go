@@ -0,0 +1,385 @@
+package temporal
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/bids"
+)
+
+// Physio holds cardiac and respiratory traces recorded during a scan.
+type Physio struct {
+	// Freq is the sampling frequency in Hz.
+	Freq float64
+	// Start is the time in seconds of the first sample relative to the
+	// start of the first volume; it is negative if the recording started
+	// earlier.
+	Start float64
+	// Cardiac and Respiratory are the traces, either of which may be nil.
+	Cardiac, Respiratory []float64
+}
+
+// ReadBIDSPhysio reads a BIDS physiological recording, a headerless
+// *_physio.tsv.gz file whose sidecar (.json) gives its SamplingFrequency,
+// StartTime, and Columns, of which "cardiac" and "respiratory" are used.
+func ReadBIDSPhysio(name string) (*Physio, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".tsv")
+	b, err := ioutil.ReadFile(base + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var sidecar struct {
+		SamplingFrequency float64
+		StartTime         float64
+		Columns           []string
+	}
+	if err := json.Unmarshal(b, &sidecar); err != nil {
+		return nil, fmt.Errorf("%s.json: %v", base, err)
+	}
+	if sidecar.SamplingFrequency <= 0 {
+		return nil, fmt.Errorf("%s.json: no SamplingFrequency", base)
+	}
+	columns, err := readColumns(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) != len(sidecar.Columns) {
+		return nil, fmt.Errorf("%s: %d columns, sidecar lists %d", name, len(columns), len(sidecar.Columns))
+	}
+	p := &Physio{Freq: sidecar.SamplingFrequency, Start: sidecar.StartTime}
+	for j, c := range sidecar.Columns {
+		switch c {
+		case "cardiac":
+			p.Cardiac = columns[j]
+		case "respiratory":
+			p.Respiratory = columns[j]
+		}
+	}
+	if p.Cardiac == nil && p.Respiratory == nil {
+		return nil, fmt.Errorf("%s: no cardiac or respiratory column", name)
+	}
+	return p, nil
+}
+
+// ReadTrace reads a trace of one number per line, such as the .1D files of
+// AFNI, from a text file that may be gzipped.
+func ReadTrace(name string) ([]float64, error) {
+	columns, err := readColumns(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) != 1 {
+		return nil, fmt.Errorf("%s: %d columns, expected 1", name, len(columns))
+	}
+	return columns[0], nil
+}
+
+// readColumns reads the columns of a table of whitespace-separated
+// numbers without a header, gzipped if the name ends in .gz.
+func readColumns(name string) ([][]float64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		z, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		defer z.Close()
+		r = z
+	}
+
+	var columns [][]float64
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if columns == nil {
+			columns = make([][]float64, len(fields))
+		}
+		if len(fields) != len(columns) {
+			return nil, fmt.Errorf("%s: line %d has %d values, expected %d", name, line, len(fields), len(columns))
+		}
+		for j, f := range fields {
+			x := math.NaN()
+			if f != bids.NA {
+				if x, err = strconv.ParseFloat(f, 64); err != nil {
+					return nil, fmt.Errorf("%s: line %d: invalid number %q", name, line, f)
+				}
+			}
+			columns[j] = append(columns[j], x)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if columns == nil {
+		return nil, fmt.Errorf("%s: no samples", name)
+	}
+	return columns, nil
+}
+
+// cardiacPhase returns the phase of the cardiac cycle at each sample,
+// rising linearly from 0 to 2 pi between consecutive R peaks. Peaks are
+// local maxima above the mean of the trace plus half its standard
+// deviation, at least 0.3 s (200 beats per minute) apart. Before the first
+// and after the last peak, the nearest interval is extended.
+func cardiacPhase(trace []float64, freq float64) ([]float64, error) {
+	mean := meanOf(trace)
+	sd := math.Sqrt(variance(trace, mean))
+	level := mean + sd/2
+	gap := int(0.3 * freq)
+
+	var peaks []int
+	for i := 1; i < len(trace)-1; i++ {
+		x := trace[i]
+		if x < level || x < trace[i-1] || x <= trace[i+1] {
+			continue
+		}
+		if n := len(peaks); n > 0 && i-peaks[n-1] < gap {
+			if x > trace[peaks[n-1]] {
+				peaks[n-1] = i
+			}
+			continue
+		}
+		peaks = append(peaks, i)
+	}
+	if len(peaks) < 2 {
+		return nil, fmt.Errorf("found %d cardiac peaks, need at least 2", len(peaks))
+	}
+
+	phase := make([]float64, len(trace))
+	k := 0
+	for i := range phase {
+		for k < len(peaks)-2 && i >= peaks[k+1] {
+			k++
+		}
+		a, b := peaks[k], peaks[k+1]
+		p := 2 * math.Pi * float64(i-a) / float64(b-a)
+		phase[i] = math.Mod(p, 2*math.Pi)
+		if phase[i] < 0 {
+			phase[i] += 2 * math.Pi
+		}
+	}
+	return phase, nil
+}
+
+// respiratoryPhase returns the phase of the respiratory cycle at each
+// sample by histogram equalization of the amplitude (Glover et al., 2000):
+// from 0 to pi during inspiration and from 0 to -pi during expiration,
+// with the direction taken from the slope of the trace smoothed over 1 s.
+func respiratoryPhase(trace []float64, freq float64) ([]float64, error) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, x := range trace {
+		lo, hi = math.Min(lo, x), math.Max(hi, x)
+	}
+	if !(hi > lo) {
+		return nil, fmt.Errorf("respiratory trace is constant")
+	}
+
+	const bins = 100
+	bin := func(x float64) int {
+		b := int((x - lo) / (hi - lo) * bins)
+		if b >= bins {
+			b = bins - 1
+		}
+		return b
+	}
+	var cum [bins]float64
+	for _, x := range trace {
+		cum[bin(x)]++
+	}
+	for b := 1; b < bins; b++ {
+		cum[b] += cum[b-1]
+	}
+
+	half := int(freq / 2)
+	if half < 1 {
+		half = 1
+	}
+	phase := make([]float64, len(trace))
+	for i, x := range trace {
+		a, b := i-half, i+half
+		if a < 0 {
+			a = 0
+		}
+		if b > len(trace)-1 {
+			b = len(trace) - 1
+		}
+		p := math.Pi * cum[bin(x)] / cum[bins-1]
+		if trace[b] < trace[a] {
+			p = -p
+		}
+		phase[i] = p
+	}
+	return phase, nil
+}
+
+// variance returns the mean squared deviation of x from mean.
+func variance(x []float64, mean float64) float64 {
+	ss := 0.0
+	for _, v := range x {
+		ss += (v - mean) * (v - mean)
+	}
+	return ss / float64(len(x))
+}
+
+// RETROICOR returns the physiological regressors of a scan of nt volumes
+// every tr seconds for each slice, sampled at the acquisition times of the
+// slices (Glover et al., 2000): the cosine and sine of 1 to order times
+// the cardiac and respiratory phases, named cardiac_cos1, cardiac_sin1,
+// ..., respiratory_cos1, and so on. Volumes acquired outside the recording
+// take the phase of its nearest sample.
+func RETROICOR(p *Physio, tr float64, nt int, sliceTimes []float64, order int) ([]Confounds, error) {
+	if order < 1 {
+		return nil, fmt.Errorf("invalid order %d", order)
+	}
+	if p.Freq <= 0 {
+		return nil, fmt.Errorf("invalid sampling frequency %g Hz", p.Freq)
+	}
+	type trace struct {
+		name  string
+		phase []float64
+	}
+	var traces []trace
+	if p.Cardiac != nil {
+		phase, err := cardiacPhase(p.Cardiac, p.Freq)
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, trace{"cardiac", phase})
+	}
+	if p.Respiratory != nil {
+		phase, err := respiratoryPhase(p.Respiratory, p.Freq)
+		if err != nil {
+			return nil, err
+		}
+		traces = append(traces, trace{"respiratory", phase})
+	}
+	if len(traces) == 0 {
+		return nil, fmt.Errorf("no cardiac or respiratory trace")
+	}
+
+	outside := 0
+	slices := make([]Confounds, len(sliceTimes))
+	for s, st := range sliceTimes {
+		c := &slices[s]
+		for _, x := range traces {
+			for m := 1; m <= order; m++ {
+				c.Names = append(c.Names, fmt.Sprintf("%s_cos%d", x.name, m), fmt.Sprintf("%s_sin%d", x.name, m))
+				c.Values = append(c.Values, make([]float64, nt), make([]float64, nt))
+			}
+		}
+		for t := 0; t < nt; t++ {
+			i := int(math.Round((float64(t)*tr + st - p.Start) * p.Freq))
+			j := 0
+			for _, x := range traces {
+				k := i
+				if k < 0 {
+					k, outside = 0, outside+1
+				} else if k >= len(x.phase) {
+					k, outside = len(x.phase)-1, outside+1
+				}
+				for m := 1; m <= order; m++ {
+					c.Values[j][t] = math.Cos(float64(m) * x.phase[k])
+					c.Values[j+1][t] = math.Sin(float64(m) * x.phase[k])
+					j += 2
+				}
+			}
+		}
+	}
+	if outside > len(sliceTimes)*nt*len(traces)/2 {
+		return nil, fmt.Errorf("most of the scan is outside the physiological recording")
+	}
+	return slices, nil
+}
+
+// sliceColumn matches the names of slice-specific confound columns.
+var sliceColumn = regexp.MustCompile(`^(.+)_slice(\d+)$`)
+
+// WriteSliceConfounds writes slice-specific confounds as one table, with a
+// column <name>_slice<s> for each confound of each slice s.
+func WriteSliceConfounds(w io.Writer, slices []Confounds) error {
+	var b strings.Builder
+	var columns [][]float64
+	for s, c := range slices {
+		for j, name := range c.Names {
+			if len(columns) > 0 {
+				b.WriteString("\t")
+			}
+			fmt.Fprintf(&b, "%s_slice%03d", name, s)
+			columns = append(columns, c.Values[j])
+		}
+	}
+	b.WriteString("\n")
+	if len(columns) > 0 {
+		for t := range columns[0] {
+			for j, c := range columns {
+				if j > 0 {
+					b.WriteString("\t")
+				}
+				b.WriteString(strconv.FormatFloat(c[t], 'g', 8, 64))
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ReadSliceConfounds reads a table written by WriteSliceConfounds and
+// returns the confounds of each slice, from slice 0 to the highest slice
+// of its columns. Other columns are ignored.
+func ReadSliceConfounds(name string) ([]Confounds, error) {
+	c, err := ReadConfounds(name, []string{"*_slice[0-9]*"})
+	if err != nil {
+		return nil, err
+	}
+	bySlice := map[int]*Confounds{}
+	ns := 0
+	for j, col := range c.Names {
+		match := sliceColumn.FindStringSubmatch(col)
+		if match == nil {
+			continue
+		}
+		s, err := strconv.Atoi(match[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: column %q: invalid slice", name, col)
+		}
+		if bySlice[s] == nil {
+			bySlice[s] = &Confounds{}
+		}
+		bySlice[s].Names = append(bySlice[s].Names, match[1])
+		bySlice[s].Values = append(bySlice[s].Values, c.Values[j])
+		if s+1 > ns {
+			ns = s + 1
+		}
+	}
+	var missing []int
+	slices := make([]Confounds, ns)
+	for s := range slices {
+		if bySlice[s] == nil {
+			missing = append(missing, s)
+			continue
+		}
+		slices[s] = *bySlice[s]
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s: no confounds for slices %v", name, missing)
+	}
+	return slices, nil
+}
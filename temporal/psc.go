@@ -0,0 +1,54 @@
+package temporal
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// PercentChange returns img in percent signal change relative to baseline,
+// one value per voxel, or to the temporal mean of each voxel if baseline is
+// nil: 100 (x - b) / b. If change is set, the values of img are already
+// changes from the baseline, such as the betas of a GLM, and become
+// 100 x / b. Voxels outside mask (if not nil) and voxels with a baseline of
+// 0 are 0. The values of img and baseline are scaled by their scl_slope and
+// scl_inter, and the result is DT_FLOAT32 without scaling.
+func PercentChange(img *nifti1.Image, baseline []float64, mask []bool, change bool) (*nifti1.Image, error) {
+	values, nxyz, err := seriesValues(img, mask)
+	if err != nil {
+		return nil, err
+	}
+	nt := len(values) / nxyz
+	if baseline == nil {
+		baseline = make([]float64, nxyz)
+		for t := 0; t < nt; t++ {
+			for m, x := range values[t*nxyz : (t+1)*nxyz] {
+				baseline[m] += x / float64(nt)
+			}
+		}
+	} else if len(baseline) != nxyz {
+		return nil, fmt.Errorf("baseline: %w", nifti1.ErrGridMismatch)
+	}
+
+	for m, b := range baseline {
+		valid := b != 0 && !math.IsNaN(b) && !math.IsInf(b, 0) && (mask == nil || mask[m])
+		for t := 0; t < nt; t++ {
+			i := t*nxyz + m
+			switch {
+			case !valid:
+				values[i] = 0
+			case change:
+				values[i] = 100 * values[i] / b
+			default:
+				values[i] = 100 * (values[i] - b) / b
+			}
+		}
+	}
+
+	out := *img
+	if err := out.SetFloat32Data(values); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
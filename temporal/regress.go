@@ -0,0 +1,202 @@
+package temporal
+
+import (
+	"fmt"
+	"math"
+	"path"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Confounds are nuisance regressors, such as motion parameters or CompCor
+// components, with one value per volume.
+type Confounds struct {
+	Names  []string
+	Values [][]float64 // Values[j] is the series of regressor j
+}
+
+// ReadConfounds reads regressors from a TSV file with a header row. Columns
+// are selected by name or by pattern (e.g. "a_comp_cor_0*"), all columns
+// if none are given. Missing ("n/a") values, such as the first row of
+// derivatives, are replaced by the mean of the column.
+func ReadConfounds(name string, columns []string) (Confounds, error) {
+	var c Confounds
+	t, err := bids.ReadTSV(name)
+	if err != nil {
+		return c, err
+	}
+	for _, col := range t.Columns {
+		selected := len(columns) == 0
+		for _, pattern := range columns {
+			if ok, _ := path.Match(pattern, col); ok {
+				selected = true
+			}
+		}
+		if !selected {
+			continue
+		}
+		values, err := t.Float(col)
+		if err != nil {
+			return c, fmt.Errorf("%s: %v", name, err)
+		}
+		sum, n := 0.0, 0
+		for _, x := range values {
+			if !math.IsNaN(x) {
+				sum, n = sum+x, n+1
+			}
+		}
+		for i, x := range values {
+			if math.IsNaN(x) {
+				values[i] = sum / float64(n)
+			}
+		}
+		c.Names = append(c.Names, col)
+		c.Values = append(c.Values, values)
+	}
+	for _, pattern := range columns {
+		found := false
+		for _, col := range c.Names {
+			if ok, _ := path.Match(pattern, col); ok {
+				found = true
+			}
+		}
+		if !found {
+			return c, fmt.Errorf("%s: no column matches %q", name, pattern)
+		}
+	}
+	if len(c.Names) == 0 {
+		return c, fmt.Errorf("%s: no confound columns", name)
+	}
+	return c, nil
+}
+
+// Regress removes the confounds from the time series of every voxel in
+// mask (all voxels if mask is nil) by least squares, with an intercept,
+// and returns the residuals as DT_FLOAT32. Voxels outside the mask are
+// unchanged. If keepMean is set, the mean of each series is kept.
+func Regress(img *nifti1.Image, c Confounds, mask []bool, keepMean bool, workers int) (*nifti1.Image, error) {
+	return RegressSlices(img, c, nil, 0, mask, keepMean, workers)
+}
+
+// RegressSlices is like Regress, with slice-specific confounds in addition
+// to c: the voxels of slice s along dimension sliceDim (1, 2, or 3) are
+// also cleaned of the confounds slices[s], such as physiological
+// regressors sampled at the acquisition time of each slice.
+func RegressSlices(img *nifti1.Image, c Confounds, slices []Confounds, sliceDim int, mask []bool, keepMean bool, workers int) (*nifti1.Image, error) {
+	nt := img.NVox / (img.Nx * img.Ny * img.Nz)
+	var stride, ns int
+	if len(slices) > 0 {
+		if sliceDim < 1 || sliceDim > 3 {
+			return nil, fmt.Errorf("invalid slice dimension %d", sliceDim)
+		}
+		stride, ns = 1, img.Dim[sliceDim]
+		for d := 1; d < sliceDim; d++ {
+			stride *= img.Dim[d]
+		}
+		if len(slices) != ns {
+			return nil, fmt.Errorf("got confounds for %d slices, image has %d", len(slices), ns)
+		}
+	}
+
+	// One design per slice, or a single one.
+	designs := []*regressor{nil}
+	if ns > 0 {
+		designs = make([]*regressor, ns)
+	}
+	for s := range designs {
+		all := c
+		if ns > 0 {
+			all = Confounds{
+				Names:  append(append([]string(nil), c.Names...), slices[s].Names...),
+				Values: append(append([][]float64(nil), c.Values...), slices[s].Values...),
+			}
+		}
+		r, err := newRegressor(all, nt)
+		if err != nil {
+			if ns > 0 {
+				return nil, fmt.Errorf("slice %d: %w", s, err)
+			}
+			return nil, err
+		}
+		designs[s] = r
+	}
+
+	values, nxyz, err := seriesValues(img, mask)
+	if err != nil {
+		return nil, err
+	}
+	eachSeries(values, nxyz, mask, workers, func(m int, series []float64) {
+		r := designs[0]
+		if ns > 0 {
+			r = designs[m/stride%ns]
+		}
+		r.clean(series, keepMean)
+		for t, x := range series {
+			values[t*nxyz+m] = x
+		}
+	})
+	out := *img
+	if err := out.SetFloat32Data(values); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// regressor is the least-squares fit of series to confounds.
+type regressor struct {
+	x  *linalg.Dense
+	qr *linalg.QR
+}
+
+// newRegressor returns the regressor of nt volumes on the confounds and an
+// intercept.
+func newRegressor(c Confounds, nt int) (*regressor, error) {
+	k := len(c.Values)
+	if nt <= k+1 {
+		return nil, fmt.Errorf("%d volumes are too few for %d confounds", nt, k)
+	}
+
+	// Demeaned confounds and the intercept, so that the coefficient of the
+	// intercept is the mean of the series.
+	x := linalg.NewDense(nt, k+1)
+	for j, values := range c.Values {
+		if len(values) != nt {
+			return nil, fmt.Errorf("confound %q has %d rows, image has %d volumes", c.Names[j], len(values), nt)
+		}
+		mean := meanOf(values)
+		for t, v := range values {
+			x.Set(t, j, v-mean)
+		}
+	}
+	for t := 0; t < nt; t++ {
+		x.Set(t, k, 1)
+	}
+	qr, err := linalg.NewQR(x)
+	if err != nil {
+		return nil, err
+	}
+	if !qr.FullRank() {
+		return nil, fmt.Errorf("confounds: %w (constant or collinear columns)", linalg.ErrSingular)
+	}
+	return &regressor{x, qr}, nil
+}
+
+// clean replaces series with its residuals, keeping the mean if keepMean
+// is set. Series that cannot be fitted are unchanged.
+func (r *regressor) clean(series []float64, keepMean bool) {
+	beta, err := r.qr.Solve(series)
+	if err != nil {
+		return
+	}
+	k := len(beta) - 1
+	if keepMean {
+		beta[k] = 0
+	}
+	for t := range series {
+		for j, b := range beta {
+			series[t] -= b * r.x.At(t, j)
+		}
+	}
+}
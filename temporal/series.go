@@ -0,0 +1,100 @@
+package temporal
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// forVoxels calls a function for every voxel index in mask (all nxyz
+// voxels if mask is nil) with workers goroutines. Each goroutine calls
+// newWorker once for its function, which may hold buffers of its own.
+func forVoxels(nxyz int, mask []bool, workers int, newWorker func() func(m int)) {
+	workers = util.Workers(workers)
+	jobs := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn := newWorker()
+			for m := range jobs {
+				fn(m)
+			}
+		}()
+	}
+	for m := 0; m < nxyz; m++ {
+		if mask == nil || mask[m] {
+			jobs <- m
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// eachSeries calls fn with the index and time series of every voxel in
+// mask (all voxels if mask is nil) with workers goroutines. The values are
+// stored volume by volume, nxyz voxels each. Each goroutine reuses one
+// series buffer, which fn may modify.
+func eachSeries(values []float64, nxyz int, mask []bool, workers int, fn func(m int, series []float64)) {
+	nt := len(values) / nxyz
+	forVoxels(nxyz, mask, workers, func() func(int) {
+		series := make([]float64, nt)
+		return func(m int) {
+			for t := range series {
+				series[t] = values[t*nxyz+m]
+			}
+			fn(m, series)
+		}
+	})
+}
+
+// seriesValues returns the scaled values of an image and its number of
+// voxels per volume, checking that mask, if any, is on its grid.
+func seriesValues(img *nifti1.Image, mask []bool) ([]float64, int, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	if mask != nil && len(mask) != nxyz {
+		return nil, 0, fmt.Errorf("mask: %w", nifti1.ErrGridMismatch)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, 0, err
+	}
+	return values, nxyz, nil
+}
+
+// mapSeries calls fn on the time series of every voxel in mask (all voxels
+// if mask is nil) with workers goroutines, and returns the modified series
+// as a DT_FLOAT32 image. Voxels outside the mask are unchanged.
+func mapSeries(img *nifti1.Image, mask []bool, workers int, fn func(series []float64)) (*nifti1.Image, error) {
+	values, nxyz, err := seriesValues(img, mask)
+	if err != nil {
+		return nil, err
+	}
+	eachSeries(values, nxyz, mask, workers, func(m int, series []float64) {
+		fn(series)
+		for t, x := range series {
+			values[t*nxyz+m] = x
+		}
+	})
+
+	out := *img
+	if err := out.SetFloat32Data(values); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func meanOf(x []float64) float64 {
+	return numeric.Mean(x)
+}
+
+// shift adds d to every element of x.
+func shift(x []float64, d float64) {
+	for i := range x {
+		x[i] += d
+	}
+}
@@ -0,0 +1,176 @@
+package temporal
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// SliceMetrics holds the mean and variance of every slice of every volume
+// of a 4D image, with their z-scores across volumes, to find the slices
+// that dropped out or spiked: artifacts of single slices that volume-level
+// measures average away, as happen with interleaved acquisitions.
+type SliceMetrics struct {
+	Axis    int // the slice axis, 0 for x, 1 for y, or 2 for z
+	Slices  int
+	Volumes int
+	// Mean, Var, and their z-scores are indexed by t*Slices + k. Slices
+	// without voxels in the mask have NaN values.
+	Mean, Var   []float64
+	MeanZ, VarZ []float64
+	// Outliers flags the slices whose |MeanZ| or |VarZ| exceeds the
+	// threshold.
+	Outliers []bool
+}
+
+// SliceSignal computes the slice metrics of img within mask (all voxels if
+// mask is nil), with slices along the slice dimension of dim_info, or z if
+// it is not set. The z-scores of a slice are robust: they are centered on
+// its median across volumes and scaled by its median absolute deviation,
+// so that the artifacts being sought do not hide themselves by inflating
+// the spread. Slices with |z| above threshold are flagged.
+func SliceSignal(img *nifti1.Image, mask []bool, threshold float64) (*SliceMetrics, error) {
+	values, nxyz, err := seriesValues(img, mask)
+	if err != nil {
+		return nil, err
+	}
+	nt := len(values) / nxyz
+	if nt < 3 {
+		return nil, fmt.Errorf("slice metrics require at least 3 volumes, got %d", nt)
+	}
+	axis := 2
+	if img.SliceDim >= 1 && img.SliceDim <= 3 {
+		axis = img.SliceDim - 1
+	}
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+	ns := dims[axis]
+
+	m := &SliceMetrics{
+		Axis:     axis,
+		Slices:   ns,
+		Volumes:  nt,
+		Mean:     make([]float64, nt*ns),
+		Var:      make([]float64, nt*ns),
+		Outliers: make([]bool, nt*ns),
+	}
+	sums := make([]numeric.Accumulator, ns)
+	squares := make([]numeric.Accumulator, ns)
+	counts := make([]int, ns)
+	for t := 0; t < nt; t++ {
+		for k := range sums {
+			sums[k], squares[k], counts[k] = numeric.Accumulator{}, numeric.Accumulator{}, 0
+		}
+		vol := values[t*nxyz : (t+1)*nxyz]
+		for v, x := range vol {
+			if mask != nil && !mask[v] {
+				continue
+			}
+			p := [3]int{v % img.Nx, v / img.Nx % img.Ny, v / (img.Nx * img.Ny)}
+			k := p[axis]
+			sums[k].Add(x)
+			squares[k].AddProduct(x, x)
+			counts[k]++
+		}
+		for k := 0; k < ns; k++ {
+			i := t*ns + k
+			if counts[k] == 0 {
+				m.Mean[i], m.Var[i] = math.NaN(), math.NaN()
+				continue
+			}
+			n := float64(counts[k])
+			mean := sums[k].Sum() / n
+			m.Mean[i] = mean
+			m.Var[i] = math.Max(0, squares[k].Sum()/n-mean*mean)
+		}
+	}
+
+	m.MeanZ = robustZ(m.Mean, nt, ns)
+	m.VarZ = robustZ(m.Var, nt, ns)
+	for i := range m.Outliers {
+		m.Outliers[i] = math.Abs(m.MeanZ[i]) > threshold || math.Abs(m.VarZ[i]) > threshold
+	}
+	return m, nil
+}
+
+// robustZ returns the robust z-scores of the values of each slice k across
+// volumes, x[t*ns + k]: (x - median) / (1.4826 MAD). A slice whose MAD is
+// zero gets z 0 where it equals the median and ±Inf elsewhere.
+func robustZ(x []float64, nt, ns int) []float64 {
+	z := make([]float64, len(x))
+	series := make([]float64, nt)
+	for k := 0; k < ns; k++ {
+		for t := 0; t < nt; t++ {
+			series[t] = x[t*ns+k]
+		}
+		med := median(series)
+		dev := make([]float64, nt)
+		for t, v := range series {
+			dev[t] = math.Abs(v - med)
+		}
+		scale := 1.4826 * median(dev)
+		for t, v := range series {
+			switch {
+			case math.IsNaN(v) || math.IsNaN(med):
+				z[t*ns+k] = math.NaN()
+			case v == med:
+				z[t*ns+k] = 0
+			default:
+				z[t*ns+k] = (v - med) / scale
+			}
+		}
+	}
+	return z
+}
+
+// median returns the median of the values of x that are not NaN, or NaN if
+// there are none.
+func median(x []float64) float64 {
+	s := make([]float64, 0, len(x))
+	for _, v := range x {
+		if !math.IsNaN(v) {
+			s = append(s, v)
+		}
+	}
+	if len(s) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// WriteTSV writes the metrics as a table with one row per slice of each
+// volume: volume, slice, mean, variance, mean_z, variance_z, and outlier
+// (0 or 1).
+func (m *SliceMetrics) WriteTSV(w io.Writer) error {
+	format := func(x float64) string {
+		if math.IsNaN(x) {
+			return bids.NA
+		}
+		return strconv.FormatFloat(x, 'g', 8, 64)
+	}
+	var b strings.Builder
+	b.WriteString("volume\tslice\tmean\tvariance\tmean_z\tvariance_z\toutlier\n")
+	for t := 0; t < m.Volumes; t++ {
+		for k := 0; k < m.Slices; k++ {
+			i := t*m.Slices + k
+			outlier := "0"
+			if m.Outliers[i] {
+				outlier = "1"
+			}
+			fmt.Fprintf(&b, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", t, k,
+				format(m.Mean[i]), format(m.Var[i]), format(m.MeanZ[i]), format(m.VarZ[i]), outlier)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
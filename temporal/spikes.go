@@ -0,0 +1,203 @@
+package temporal
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/bids"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/numeric"
+)
+
+// Spikes holds volume-level quality measures of a 4D image and the volumes
+// flagged as outliers.
+type Spikes struct {
+	// Global is the mean signal of each volume, and GlobalZ its z-score
+	// across volumes.
+	Global, GlobalZ []float64
+	// DVARS is the root mean square over voxels of the change in signal
+	// from the previous volume, and DVARSZ its z-score. Both are NaN for
+	// the first volume.
+	DVARS, DVARSZ []float64
+	// Outliers flags the volumes whose |GlobalZ| or DVARSZ exceeds its
+	// threshold.
+	Outliers []bool
+}
+
+// DetectSpikes computes the global signal and DVARS of img within mask
+// (all voxels if mask is nil) and flags the volumes with |global z| above
+// globalZ or DVARS z above dvarsZ, as ART does. A threshold of 0 disables
+// that test. A volume with a DVARS spike is flagged along with the volume
+// before it, since the change could come from either.
+func DetectSpikes(img *nifti1.Image, mask []bool, globalZ, dvarsZ float64) (*Spikes, error) {
+	values, nxyz, err := seriesValues(img, mask)
+	if err != nil {
+		return nil, err
+	}
+	nt := len(values) / nxyz
+	if nt < 3 {
+		return nil, fmt.Errorf("spike detection requires at least 3 volumes, got %d", nt)
+	}
+	n := 0
+	for m := 0; m < nxyz; m++ {
+		if mask == nil || mask[m] {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("mask is empty")
+	}
+
+	s := &Spikes{
+		Global:   make([]float64, nt),
+		DVARS:    make([]float64, nt),
+		Outliers: make([]bool, nt),
+	}
+	s.DVARS[0] = math.NaN()
+	for t := 0; t < nt; t++ {
+		vol := values[t*nxyz : (t+1)*nxyz]
+		var sum, sq numeric.Accumulator
+		for m, x := range vol {
+			if mask != nil && !mask[m] {
+				continue
+			}
+			sum.Add(x)
+			if t > 0 {
+				d := x - values[(t-1)*nxyz+m]
+				sq.AddProduct(d, d)
+			}
+		}
+		s.Global[t] = sum.Sum() / float64(n)
+		if t > 0 {
+			s.DVARS[t] = math.Sqrt(sq.Sum() / float64(n))
+		}
+	}
+	s.GlobalZ = zscore(s.Global)
+	s.DVARSZ = append([]float64{math.NaN()}, zscore(s.DVARS[1:])...)
+
+	for t := 0; t < nt; t++ {
+		if globalZ > 0 && math.Abs(s.GlobalZ[t]) > globalZ {
+			s.Outliers[t] = true
+		}
+		if dvarsZ > 0 && t > 0 && s.DVARSZ[t] > dvarsZ {
+			s.Outliers[t-1], s.Outliers[t] = true, true
+		}
+	}
+	return s, nil
+}
+
+// zscore returns the z-scores of x, all 0 if x is constant.
+func zscore(x []float64) []float64 {
+	mean := meanOf(x)
+	var ss numeric.Accumulator
+	for _, v := range x {
+		ss.AddProduct(v-mean, v-mean)
+	}
+	sd := math.Sqrt(ss.Sum() / float64(len(x)-1))
+	z := make([]float64, len(x))
+	if sd == 0 || math.IsNaN(sd) {
+		return z
+	}
+	for i, v := range x {
+		z[i] = (v - mean) / sd
+	}
+	return z
+}
+
+// WriteTSV writes the measures as a BIDS table with one row per volume:
+// global_signal, global_signal_z, dvars, dvars_z, and outlier (0 or 1),
+// followed by a spike regressor per outlier volume, outlier00, outlier01,
+// and so on, which is 1 at that volume and 0 elsewhere, like the
+// regression outliers of ART.
+func (s *Spikes) WriteTSV(w io.Writer) error {
+	var flagged []int
+	for t, o := range s.Outliers {
+		if o {
+			flagged = append(flagged, t)
+		}
+	}
+	format := func(x float64) string {
+		if math.IsNaN(x) {
+			return bids.NA
+		}
+		return strconv.FormatFloat(x, 'g', 8, 64)
+	}
+
+	var b strings.Builder
+	b.WriteString("global_signal\tglobal_signal_z\tdvars\tdvars_z\toutlier")
+	for i := range flagged {
+		fmt.Fprintf(&b, "\toutlier%02d", i)
+	}
+	b.WriteString("\n")
+	for t := range s.Global {
+		b.WriteString(strings.Join([]string{
+			format(s.Global[t]), format(s.GlobalZ[t]), format(s.DVARS[t]), format(s.DVARSZ[t]),
+		}, "\t"))
+		if s.Outliers[t] {
+			b.WriteString("\t1")
+		} else {
+			b.WriteString("\t0")
+		}
+		for _, f := range flagged {
+			if f == t {
+				b.WriteString("\t1")
+			} else {
+				b.WriteString("\t0")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Despike returns img with the volumes flagged in outliers replaced, voxel
+// by voxel, by linear interpolation between the nearest unflagged volumes
+// before and after, or by the nearest one at the ends of the series.
+// Voxels outside mask (if not nil) are unchanged. The result is DT_FLOAT32.
+func Despike(img *nifti1.Image, outliers []bool, mask []bool, workers int) (*nifti1.Image, error) {
+	nt := img.NVox / (img.Nx * img.Ny * img.Nz)
+	if len(outliers) != nt {
+		return nil, fmt.Errorf("got %d outlier flags for %d volumes", len(outliers), nt)
+	}
+	// The nearest unflagged volumes before and after each volume, or -1.
+	prev, next := make([]int, nt), make([]int, nt)
+	last := -1
+	for t := 0; t < nt; t++ {
+		prev[t] = last
+		if !outliers[t] {
+			last = t
+		}
+	}
+	last = -1
+	for t := nt - 1; t >= 0; t-- {
+		next[t] = last
+		if !outliers[t] {
+			last = t
+		}
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("every volume is an outlier")
+	}
+
+	return mapSeries(img, mask, workers, func(series []float64) {
+		for t, o := range outliers {
+			if !o {
+				continue
+			}
+			a, b := prev[t], next[t]
+			switch {
+			case a < 0:
+				series[t] = series[b]
+			case b < 0:
+				series[t] = series[a]
+			default:
+				f := float64(t-a) / float64(b-a)
+				series[t] = (1-f)*series[a] + f*series[b]
+			}
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package tiff
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// IsTIFF reports whether name has a TIFF extension.
+func IsTIFF(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+// ReadStack reads a multi-page TIFF file, or a directory of TIFF files in
+// name order, as a volume with one slice per page. Pages are flipped so that
+// the top row has the highest j, which shows them upright in NIfTI viewers.
+// spacing is the voxel size (x, y, z) in mm; zeros are taken from the TIFF
+// resolution and an ImageJ "spacing=" description if present, else 1.
+func ReadStack(name string, spacing [3]float64) (*nifti1.Image, error) {
+	return ReadStackFS(util.Local, name, spacing)
+}
+
+// ReadStackFS is ReadStack for a file or directory of fsys.
+func ReadStackFS(fsys fs.FS, name string, spacing [3]float64) (*nifti1.Image, error) {
+	names := []string{name}
+	if fi, err := fs.Stat(fsys, name); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		names = names[:0]
+		for _, e := range entries {
+			if !e.IsDir() && IsTIFF(e.Name()) {
+				names = append(names, util.Join(fsys, name, e.Name()))
+			}
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("%s: no TIFF files", name)
+		}
+	}
+
+	var pages []Page
+	for _, n := range names {
+		b, err := fs.ReadFile(fsys, n)
+		if err != nil {
+			return nil, err
+		}
+		p, err := Decode(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n, err)
+		}
+		for _, page := range p {
+			if len(pages) > 0 {
+				first := pages[0]
+				if page.Width != first.Width || page.Height != first.Height || page.DataType != first.DataType {
+					return nil, fmt.Errorf("%s: page is %dx%d of datatype %d, expected %dx%d of datatype %d",
+						n, page.Width, page.Height, page.DataType, first.Width, first.Height, first.DataType)
+				}
+			}
+			pages = append(pages, page)
+		}
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%s: no pages", name)
+	}
+
+	first := pages[0]
+	for i := 0; i < 2; i++ {
+		if spacing[i] <= 0 {
+			spacing[i] = first.PixelSize[i]
+		}
+	}
+	if spacing[2] <= 0 {
+		spacing[2] = imageJSpacing(first.Description)
+	}
+	for i := range spacing {
+		if spacing[i] <= 0 {
+			spacing[i] = 1
+		}
+	}
+
+	var affine [4][4]float64
+	for i := 0; i < 3; i++ {
+		affine[i][i] = spacing[i]
+	}
+	affine[3][3] = 1
+	img, err := nifti1.NewImage(first.DataType, []int{first.Width, first.Height, len(pages)}, affine, nifti1.XformScannerAnat)
+	if err != nil {
+		return nil, err
+	}
+	row := first.Width * img.NByPer
+	slice := row * first.Height
+	for k, p := range pages {
+		for r := 0; r < first.Height; r++ {
+			j := first.Height - 1 - r
+			copy(img.Data[k*slice+j*row:], p.Data[r*row:(r+1)*row])
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"files":   len(names),
+		"pages":   len(pages),
+		"size":    fmt.Sprintf("%dx%d", first.Width, first.Height),
+		"spacing": spacing,
+	}).Debug("Read TIFF stack")
+
+	return img, nil
+}
+
+// imageJSpacing returns the slice spacing of an ImageJ description, or 0.
+func imageJSpacing(description string) float64 {
+	if !strings.HasPrefix(description, "ImageJ=") {
+		return 0
+	}
+	for _, line := range strings.Split(description, "\n") {
+		if strings.HasPrefix(line, "spacing=") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, "spacing="), 64)
+			if err == nil && v > 0 {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+// WriteStack writes one volume of img as a 16-bit multi-page TIFF file with
+// one page per slice, the inverse of ReadStack. Values that are not all
+// integers in [0, 65535] are rescaled linearly from their range to
+// [0, 65535]. The voxel size is stored as the resolution and as an ImageJ
+// description, which Fiji reads.
+func WriteStack(name string, img *nifti1.Image, volume int) error {
+	nxyz := img.Nx * img.Ny * img.Nz
+	if nt := img.NVox / nxyz; volume < 0 || volume >= nt {
+		return fmt.Errorf("volume %d is out of range [0, %d)", volume, nt)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return err
+	}
+	values = values[volume*nxyz : (volume+1)*nxyz]
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	integral := true
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+		if v != math.Trunc(v) {
+			integral = false
+		}
+	}
+	convert := func(v float64) uint16 { return uint16(v) }
+	if !integral || lo < 0 || hi > math.MaxUint16 {
+		scale := 0.0
+		if hi > lo {
+			scale = math.MaxUint16 / (hi - lo)
+		}
+		convert = func(v float64) uint16 {
+			if math.IsNaN(v) {
+				return 0
+			}
+			return uint16(math.Round((v - lo) * scale))
+		}
+		log.WithFields(log.Fields{
+			"min": lo,
+			"max": hi,
+		}).Warn("Rescaling values to [0, 65535]")
+	}
+
+	pages := make([][]uint16, img.Nz)
+	for k := range pages {
+		page := make([]uint16, img.Nx*img.Ny)
+		for r := 0; r < img.Ny; r++ {
+			j := img.Ny - 1 - r
+			src := values[k*img.Nx*img.Ny+j*img.Nx:]
+			for i := 0; i < img.Nx; i++ {
+				page[r*img.Nx+i] = convert(src[i])
+			}
+		}
+		pages[k] = page
+	}
+
+	size := img.VoxelSizeMM()
+	description := fmt.Sprintf("ImageJ=1.11a\nimages=%d\nslices=%d\nunit=mm\nspacing=%g\n", img.Nz, img.Nz, size[2])
+	return util.WriteAtomic(name, func(w io.Writer) error {
+		return Encode(w, img.Nx, img.Ny, pages, [2]float64{size[0], size[1]}, description)
+	})
+}
@@ -0,0 +1,448 @@
+// tiff contains a reader and writer of grayscale TIFF files, single or
+// multi-page, used to move image stacks between NIfTI and microscopy tools.
+// Only classic (not Big) TIFF with one sample per pixel in strips is read,
+// uncompressed, PackBits, or Deflate.
+// https://www.itu.int/itudoc/itu-t/com16/tiff-fx/docs/tiff6.pdf
+
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// Tags.
+const (
+	tagImageWidth       = 256
+	tagImageLength      = 257
+	tagBitsPerSample    = 258
+	tagCompression      = 259
+	tagPhotometric      = 262
+	tagImageDescription = 270
+	tagStripOffsets     = 273
+	tagSamplesPerPixel  = 277
+	tagRowsPerStrip     = 278
+	tagStripByteCounts  = 279
+	tagXResolution      = 282
+	tagYResolution      = 283
+	tagResolutionUnit   = 296
+	tagPredictor        = 317
+	tagTileWidth        = 322
+	tagSampleFormat     = 339
+)
+
+// Field types.
+const (
+	typeByte     = 1
+	typeASCII    = 2
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+)
+
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// Compression schemes.
+const (
+	compressionNone     = 1
+	compressionDeflate  = 8
+	compressionPackBits = 32773
+	compressionDeflate2 = 32946 // Adobe's old code for Deflate
+)
+
+// Sample formats.
+const (
+	formatUint  = 1
+	formatInt   = 2
+	formatFloat = 3
+)
+
+// Page is one page of a TIFF file.
+type Page struct {
+	Width, Height int
+	DataType      int        // a NIfTI datatype code
+	Data          []byte     // little-endian samples in row-major order
+	PixelSize     [2]float64 // width and height of a pixel in mm, or 0 if unknown
+	Description   string
+}
+
+// pageType returns the NIfTI datatype of a sample format and bit depth.
+func pageType(format, bits int) (int, bool) {
+	switch {
+	case format == formatUint && bits == 8:
+		return nifti1.DTUint8, true
+	case format == formatInt && bits == 8:
+		return nifti1.DTInt8, true
+	case format == formatUint && bits == 16:
+		return nifti1.DTUint16, true
+	case format == formatInt && bits == 16:
+		return nifti1.DTInt16, true
+	case format == formatUint && bits == 32:
+		return nifti1.DTUint32, true
+	case format == formatInt && bits == 32:
+		return nifti1.DTInt32, true
+	case format == formatFloat && bits == 32:
+		return nifti1.DTFloat32, true
+	case format == formatFloat && bits == 64:
+		return nifti1.DTFloat64, true
+	}
+	return 0, false
+}
+
+// field is a decoded IFD entry.
+type field struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+// Decode reads all pages of a TIFF file.
+func Decode(b []byte) ([]Page, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%w TIFF header", nifti1.ErrTruncated)
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+	switch order.Uint16(b[2:]) {
+	case 42:
+	case 43:
+		return nil, fmt.Errorf("%w BigTIFF", nifti1.ErrUnsupported)
+	default:
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+
+	var pages []Page
+	seen := map[uint32]bool{}
+	for off := order.Uint32(b[4:]); off != 0; {
+		if seen[off] {
+			return nil, fmt.Errorf("TIFF IFD loop at offset %d", off)
+		}
+		seen[off] = true
+		if int64(off)+2 > int64(len(b)) {
+			return nil, fmt.Errorf("%w TIFF IFD at offset %d", nifti1.ErrTruncated, off)
+		}
+		n := int(order.Uint16(b[off:]))
+		end := int64(off) + 2 + 12*int64(n) + 4
+		if end > int64(len(b)) {
+			return nil, fmt.Errorf("%w TIFF IFD at offset %d", nifti1.ErrTruncated, off)
+		}
+		fields := map[uint16]field{}
+		for i := 0; i < n; i++ {
+			e := b[int(off)+2+12*i:]
+			tag, typ, count := order.Uint16(e), order.Uint16(e[2:]), int(order.Uint32(e[4:]))
+			size := typeSizes[typ] * count
+			value := e[8:12]
+			if size > 4 {
+				p := int64(order.Uint32(e[8:]))
+				if p+int64(size) > int64(len(b)) {
+					return nil, fmt.Errorf("%w TIFF tag %d", nifti1.ErrTruncated, tag)
+				}
+				value = b[p : p+int64(size)]
+			}
+			fields[tag] = field{typ, count, value[:size]}
+		}
+		page, err := decodePage(b, fields, order)
+		if err != nil {
+			return nil, fmt.Errorf("TIFF page %d: %w", len(pages), err)
+		}
+		pages = append(pages, page)
+		off = order.Uint32(b[end-4:])
+	}
+	return pages, nil
+}
+
+// ints returns the integer values of a field, or def if it is missing.
+func ints(fields map[uint16]field, tag uint16, order binary.ByteOrder, def ...int) []int {
+	f, ok := fields[tag]
+	if !ok {
+		return def
+	}
+	v := make([]int, f.count)
+	for i := range v {
+		switch f.typ {
+		case typeByte:
+			v[i] = int(f.value[i])
+		case typeShort:
+			v[i] = int(order.Uint16(f.value[2*i:]))
+		case typeLong:
+			v[i] = int(order.Uint32(f.value[4*i:]))
+		}
+	}
+	return v
+}
+
+func decodePage(b []byte, fields map[uint16]field, order binary.ByteOrder) (Page, error) {
+	one := func(tag uint16, def int) int {
+		if v := ints(fields, tag, order, def); len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+	var p Page
+	p.Width, p.Height = one(tagImageWidth, 0), one(tagImageLength, 0)
+	if p.Width <= 0 || p.Height <= 0 {
+		return p, fmt.Errorf("invalid size %dx%d", p.Width, p.Height)
+	}
+	if one(tagSamplesPerPixel, 1) != 1 {
+		return p, fmt.Errorf("%w TIFF with more than one sample per pixel", nifti1.ErrUnsupported)
+	}
+	if _, ok := fields[tagTileWidth]; ok {
+		return p, fmt.Errorf("%w tiled TIFF", nifti1.ErrUnsupported)
+	}
+	if one(tagPredictor, 1) != 1 {
+		return p, fmt.Errorf("%w TIFF predictor %d", nifti1.ErrUnsupported, one(tagPredictor, 1))
+	}
+	bits, format := one(tagBitsPerSample, 1), one(tagSampleFormat, formatUint)
+	dt, ok := pageType(format, bits)
+	if !ok {
+		return p, fmt.Errorf("%w TIFF sample format %d with %d bits", nifti1.ErrUnsupported, format, bits)
+	}
+	p.DataType = dt
+	if f, ok := fields[tagImageDescription]; ok && f.typ == typeASCII {
+		p.Description = string(bytes.TrimRight(f.value, "\x00"))
+	}
+
+	var perMM float64
+	switch one(tagResolutionUnit, 1) { // the default of inches is usually meaningless
+	case 2:
+		perMM = 25.4
+	case 3:
+		perMM = 10
+	}
+	for i, tag := range []uint16{tagXResolution, tagYResolution} {
+		if f, ok := fields[tag]; ok && f.typ == typeRational && perMM > 0 {
+			num, den := order.Uint32(f.value), order.Uint32(f.value[4:])
+			if num > 0 && den > 0 {
+				p.PixelSize[i] = perMM * float64(den) / float64(num)
+			}
+		}
+	}
+
+	size := bits / 8
+	rowsPerStrip := one(tagRowsPerStrip, p.Height)
+	if rowsPerStrip <= 0 || rowsPerStrip > p.Height {
+		rowsPerStrip = p.Height
+	}
+	offsets, counts := ints(fields, tagStripOffsets, order), ints(fields, tagStripByteCounts, order)
+	nstrips := (p.Height + rowsPerStrip - 1) / rowsPerStrip
+	if len(offsets) != nstrips || len(counts) != nstrips {
+		return p, fmt.Errorf("expected %d strips, got %d offsets and %d byte counts", nstrips, len(offsets), len(counts))
+	}
+
+	compression := one(tagCompression, compressionNone)
+	p.Data = make([]byte, 0, p.Width*p.Height*size)
+	for s := 0; s < nstrips; s++ {
+		if offsets[s]+counts[s] > len(b) {
+			return p, fmt.Errorf("%w TIFF strip %d", nifti1.ErrTruncated, s)
+		}
+		strip := b[offsets[s] : offsets[s]+counts[s]]
+		rows := rowsPerStrip
+		if rem := p.Height - s*rowsPerStrip; rem < rows {
+			rows = rem
+		}
+		want := rows * p.Width * size
+		var err error
+		switch compression {
+		case compressionNone:
+		case compressionPackBits:
+			strip, err = unpackBits(strip, want)
+		case compressionDeflate, compressionDeflate2:
+			var r io.ReadCloser
+			if r, err = zlib.NewReader(bytes.NewReader(strip)); err == nil {
+				strip, err = ioutil.ReadAll(r)
+				r.Close()
+			}
+		default:
+			return p, fmt.Errorf("%w TIFF compression %d", nifti1.ErrUnsupported, compression)
+		}
+		if err != nil {
+			return p, fmt.Errorf("strip %d: %v", s, err)
+		}
+		if len(strip) < want {
+			return p, fmt.Errorf("%w TIFF strip %d", nifti1.ErrTruncated, s)
+		}
+		p.Data = append(p.Data, strip[:want]...)
+	}
+	if order == binary.BigEndian && size > 1 {
+		for i := 0; i < len(p.Data); i += size {
+			for j, k := i, i+size-1; j < k; j, k = j+1, k-1 {
+				p.Data[j], p.Data[k] = p.Data[k], p.Data[j]
+			}
+		}
+	}
+	return p, nil
+}
+
+// unpackBits decodes PackBits data of n bytes.
+func unpackBits(b []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(b) && len(out) < n; {
+		c := int(int8(b[i]))
+		i++
+		switch {
+		case c >= 0:
+			if i+c+1 > len(b) {
+				return nil, fmt.Errorf("PackBits literal run is truncated")
+			}
+			out = append(out, b[i:i+c+1]...)
+			i += c + 1
+		case c != -128:
+			if i >= len(b) {
+				return nil, fmt.Errorf("PackBits repeat run is truncated")
+			}
+			for j := 0; j < 1-c; j++ {
+				out = append(out, b[i])
+			}
+			i++
+		}
+	}
+	return out, nil
+}
+
+// Encode writes 16-bit grayscale pages of the same size as an uncompressed
+// little-endian TIFF file, one page per slice. pixelSize is the width and
+// height of a pixel in mm, stored as the resolution; 0 leaves it out. The
+// description is stored in the first page.
+func Encode(w io.Writer, width, height int, pages [][]uint16, pixelSize [2]float64, description string) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid size %dx%d", width, height)
+	}
+	n := width * height
+	for i, p := range pages {
+		if len(p) != n {
+			return fmt.Errorf("page %d has %d pixels, expected %d", i, len(p), n)
+		}
+	}
+	if int64(len(pages))*int64(2*n+512)+int64(len(description)) > math.MaxUint32 {
+		return fmt.Errorf("%w TIFF larger than 4 GB", nifti1.ErrUnsupported)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	put16(&buf, 42)
+	put32(&buf, 8)
+	for i, p := range pages {
+		var tags []entry
+		tags = append(tags,
+			entry{tagImageWidth, typeLong, 1, uint32(width), nil},
+			entry{tagImageLength, typeLong, 1, uint32(height), nil},
+			entry{tagBitsPerSample, typeShort, 1, 16, nil},
+			entry{tagCompression, typeShort, 1, compressionNone, nil},
+			entry{tagPhotometric, typeShort, 1, 1, nil}, // BlackIsZero
+		)
+		if i == 0 && description != "" {
+			tags = append(tags, entry{tagImageDescription, typeASCII, len(description) + 1, 0, append([]byte(description), 0)})
+		}
+		tags = append(tags,
+			entry{tagStripOffsets, typeLong, 1, 0, nil}, // set below
+			entry{tagSamplesPerPixel, typeShort, 1, 1, nil},
+			entry{tagRowsPerStrip, typeLong, 1, uint32(height), nil},
+			entry{tagStripByteCounts, typeLong, 1, uint32(2 * n), nil},
+		)
+		if pixelSize[0] > 0 && pixelSize[1] > 0 {
+			// Pixels per cm.
+			tags = append(tags,
+				entry{tagXResolution, typeRational, 1, 0, rational(10 / pixelSize[0])},
+				entry{tagYResolution, typeRational, 1, 0, rational(10 / pixelSize[1])},
+				entry{tagResolutionUnit, typeShort, 1, 3, nil},
+			)
+		}
+		tags = append(tags, entry{tagSampleFormat, typeShort, 1, formatUint, nil})
+
+		// Layout: IFD, out-of-line values, pixels.
+		ifdSize := 2 + 12*len(tags) + 4
+		extra := buf.Len() + ifdSize
+		for j := range tags {
+			if len(tags[j].data) > 4 {
+				tags[j].value = uint32(extra)
+				extra += len(tags[j].data) + len(tags[j].data)%2
+			}
+		}
+		pixels := extra
+		for j := range tags {
+			if tags[j].tag == tagStripOffsets {
+				tags[j].value = uint32(pixels)
+			}
+		}
+		next := 0
+		if i < len(pages)-1 {
+			next = pixels + 2*n
+		}
+
+		put16(&buf, uint16(len(tags)))
+		for _, t := range tags {
+			put16(&buf, t.tag)
+			put16(&buf, t.typ)
+			put32(&buf, uint32(t.count))
+			switch {
+			case len(t.data) > 4:
+				put32(&buf, t.value)
+			case t.data != nil:
+				var v [4]byte
+				copy(v[:], t.data)
+				buf.Write(v[:])
+			case t.typ == typeShort:
+				put16(&buf, uint16(t.value))
+				put16(&buf, 0)
+			default:
+				put32(&buf, t.value)
+			}
+		}
+		put32(&buf, uint32(next))
+		for _, t := range tags {
+			if len(t.data) > 4 {
+				buf.Write(t.data)
+				if len(t.data)%2 == 1 {
+					buf.WriteByte(0)
+				}
+			}
+		}
+		for _, v := range p {
+			put16(&buf, v)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// entry is an IFD entry to write. Values of up to four bytes are in value,
+// unless data is set.
+type entry struct {
+	tag, typ uint16
+	count    int
+	value    uint32
+	data     []byte
+}
+
+// rational returns v as an unsigned rational.
+func rational(v float64) []byte {
+	const den = 1000
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, uint32(math.Round(v*den)))
+	binary.LittleEndian.PutUint32(b[4:], den)
+	return b
+}
+
+func put16(buf *bytes.Buffer, v uint16) {
+	buf.WriteByte(byte(v))
+	buf.WriteByte(byte(v >> 8))
+}
+
+func put32(buf *bytes.Buffer, v uint32) {
+	put16(buf, uint16(v))
+	put16(buf, uint16(v>>16))
+}
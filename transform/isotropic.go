@@ -0,0 +1,93 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// fwhmToSigma converts the full width at half maximum of a Gaussian to its
+// standard deviation.
+var fwhmToSigma = 1 / (2 * math.Sqrt(2*math.Ln2))
+
+// ResampleIsotropic returns img resampled to cubic voxels of size mm that
+// span the same field of view. Along axes where the voxels grow, the
+// volumes are first smoothed by a Gaussian whose FWHM widens the input
+// voxels to the output size, so that the result does not alias. Nearest
+// neighbour interpolation, for labels, is not smoothed. Output voxels at
+// the edges may reach half an input voxel past the grid, so the Zero
+// boundary is taken as Clamp.
+func ResampleIsotropic(img *nifti1.Image, size float64, o interp.Options) (*nifti1.Image, error) {
+	if !(size > 0) {
+		return nil, fmt.Errorf("invalid voxel size %g mm", size)
+	}
+	voxel := img.VoxelSizeMM()
+	dims := [3]int{img.Nx, img.Ny, img.Nz}
+
+	// scale is the output voxel size in input voxels.
+	var scale, sigma [3]float64
+	var outDims [3]int
+	smooth := false
+	for a := 0; a < 3; a++ {
+		if !(voxel[a] > 0) {
+			return nil, fmt.Errorf("invalid voxel size %v mm", voxel)
+		}
+		scale[a] = size / voxel[a]
+		outDims[a] = int(math.Max(1, math.Round(float64(dims[a])/scale[a])))
+		if scale[a] > 1 && o.Method != interp.Nearest {
+			sigma[a] = math.Sqrt(scale[a]*scale[a]-1) * fwhmToSigma
+			smooth = true
+		}
+	}
+
+	src := img
+	if smooth {
+		all, err := img.ScaledFloat64s()
+		if err != nil {
+			return nil, err
+		}
+		nxyz := dims[0] * dims[1] * dims[2]
+		values := make([]float64, 0, len(all))
+		for t := 0; t < len(all)/nxyz; t++ {
+			values = append(values, interp.GaussianSmooth(all[t*nxyz:(t+1)*nxyz], dims, sigma)...)
+		}
+		s := *img
+		if err := s.SetFloat32Data(values); err != nil {
+			return nil, err
+		}
+		src = &s
+	}
+
+	// Output voxel i covers the input from i*scale to (i+1)*scale voxels,
+	// less the half voxel between centers and edges.
+	in := img.Affine()
+	var affine [4][4]float64
+	affine[3][3] = 1
+	for r := 0; r < 3; r++ {
+		affine[r][3] = in[r][3]
+		for c := 0; c < 3; c++ {
+			affine[r][c] = in[r][c] * scale[c]
+			affine[r][3] += in[r][c] * (scale[c]/2 - 0.5)
+		}
+	}
+	xform := img.SFormCode
+	if xform <= 0 {
+		xform = img.QFormCode
+	}
+	if xform <= 0 {
+		xform = nifti1.XformScannerAnat
+	}
+	ref := *img
+	if err := ref.SetDims(outDims[:]...); err != nil {
+		return nil, err
+	}
+	if err := ref.SetAffine(affine, xform); err != nil {
+		return nil, err
+	}
+	if o.Boundary == interp.Zero {
+		o.Boundary = interp.Clamp
+	}
+	return New().Resample(src, &ref, o)
+}
@@ -0,0 +1,166 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/linalg"
+)
+
+// FromLandmarks returns the least-squares matrix mapping reference points
+// to their corresponding moving points, the direction Resample needs, and
+// the root mean square distance in mm between the mapped reference points
+// and the moving points. dof is 6 (rigid), 7 (rigid with isotropic
+// scaling), or 12 (affine). Rigid fits, by the method of Umeyama, need 3
+// points not on a line; affine fits need 4 points not on a plane.
+func FromLandmarks(reference, moving [][3]float64, dof int) (Affine, float64, error) {
+	var a Affine
+	if len(reference) != len(moving) {
+		return a, 0, fmt.Errorf("got %d reference and %d moving landmarks", len(reference), len(moving))
+	}
+	var err error
+	switch dof {
+	case 6, 7:
+		a, err = umeyama(reference, moving, dof == 7)
+	case 12:
+		a, err = affineFit(reference, moving)
+	default:
+		return a, 0, fmt.Errorf("landmark fits have 6, 7, or 12 degrees of freedom, not %d", dof)
+	}
+	if err != nil {
+		return a, 0, err
+	}
+	sum := 0.0
+	for i, p := range reference {
+		q, _ := a.Apply(p)
+		for n := range q {
+			sum += (q[n] - moving[i][n]) * (q[n] - moving[i][n])
+		}
+	}
+	return a, math.Sqrt(sum / float64(len(reference))), nil
+}
+
+// umeyama fits a rotation, translation, and optionally an isotropic scale.
+func umeyama(x, y [][3]float64, scale bool) (Affine, error) {
+	var a Affine
+	if len(x) < 3 {
+		return a, fmt.Errorf("a rigid fit needs at least 3 landmarks, got %d", len(x))
+	}
+	n := float64(len(x))
+	var mx, my [3]float64
+	for i := range x {
+		for k := 0; k < 3; k++ {
+			mx[k] += x[i][k] / n
+			my[k] += y[i][k] / n
+		}
+	}
+	// The covariance of the centered points and the variance of x.
+	var cov [3][3]float64
+	vx := 0.0
+	for i := range x {
+		for r := 0; r < 3; r++ {
+			vx += (x[i][r] - mx[r]) * (x[i][r] - mx[r]) / n
+			for c := 0; c < 3; c++ {
+				cov[r][c] += (y[i][r] - my[r]) * (x[i][c] - mx[c]) / n
+			}
+		}
+	}
+	u, d, v := linalg.SVD3(cov)
+	if d[1] <= 1e-9*d[0] {
+		return a, errors.New("landmarks are on a line: the rotation is not determined")
+	}
+	// Reflections are replaced by the nearest rotation.
+	sign := [3]float64{1, 1, 1}
+	if det3(u)*det3(v) < 0 {
+		sign[2] = -1
+	}
+	c := 1.0
+	if scale {
+		c = (d[0]*sign[0] + d[1]*sign[1] + d[2]*sign[2]) / vx
+	}
+	for r := 0; r < 3; r++ {
+		for k := 0; k < 3; k++ {
+			for j := 0; j < 3; j++ {
+				a[r][k] += c * u[r][j] * sign[j] * v[k][j]
+			}
+		}
+	}
+	for r := 0; r < 3; r++ {
+		a[r][3] = my[r]
+		for k := 0; k < 3; k++ {
+			a[r][3] -= a[r][k] * mx[k]
+		}
+	}
+	a[3][3] = 1
+	return a, nil
+}
+
+// affineFit fits a general affine matrix, row by row.
+func affineFit(x, y [][3]float64) (Affine, error) {
+	var a Affine
+	if len(x) < 4 {
+		return a, fmt.Errorf("an affine fit needs at least 4 landmarks, got %d", len(x))
+	}
+	design := linalg.NewDense(len(x), 4)
+	for i, p := range x {
+		copy(design.Row(i), []float64{p[0], p[1], p[2], 1})
+	}
+	qr, err := linalg.NewQR(design)
+	if err != nil {
+		return a, err
+	}
+	if !qr.FullRank() {
+		return a, fmt.Errorf("landmarks: %w (points are on a plane)", linalg.ErrSingular)
+	}
+	b := make([]float64, len(y))
+	for r := 0; r < 3; r++ {
+		for i, q := range y {
+			b[i] = q[r]
+		}
+		row, err := qr.Solve(b)
+		if err != nil {
+			return a, err
+		}
+		copy(a[r][:], row)
+	}
+	a[3][3] = 1
+	return a, nil
+}
+
+// ReadLandmarks reads points from a text file, one "x y z" per line in
+// world coordinates (mm), separated by spaces, tabs, or commas. Blank lines
+// and lines starting with '#' are skipped.
+func ReadLandmarks(name string) ([][3]float64, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var points [][3]float64
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected x y z, got %d values", name, line, len(fields))
+		}
+		var p [3]float64
+		for n, f := range fields {
+			if p[n], err = strconv.ParseFloat(f, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid number %q", name, line, f)
+			}
+		}
+		points = append(points, p)
+	}
+	return points, s.Err()
+}
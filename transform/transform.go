@@ -0,0 +1,354 @@
+// transform contains spatial transforms between world coordinates (mm):
+// chains of steps, such as affine matrices, that can be composed, inverted,
+// applied to points and images, and saved as JSON or, for single affines,
+// as a plain 4x4 matrix.
+//
+// A transform maps points of a reference space to a moving space, the
+// direction needed to resample a moving image onto a reference grid.
+
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kaczmarj/gonifti/interp"
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+)
+
+// Step is one step of a transform.
+type Step interface {
+	// Kind names the step in JSON, e.g. "affine".
+	Kind() string
+	// Apply maps a point. It reports false if the point is outside the
+	// domain of the step.
+	Apply(p [3]float64) ([3]float64, bool)
+	// Inverse returns the step mapping the other way.
+	Inverse() (Step, error)
+}
+
+// Affine is a 4x4 affine matrix step.
+type Affine [4][4]float64
+
+// Identity is the identity matrix.
+var Identity = Affine{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+
+// Kind returns "affine".
+func (a Affine) Kind() string { return "affine" }
+
+// Apply maps p by the matrix.
+func (a Affine) Apply(p [3]float64) ([3]float64, bool) {
+	return linalg.ApplyAffine(a, p), true
+}
+
+// Inverse returns the inverse matrix.
+func (a Affine) Inverse() (Step, error) {
+	inv, err := linalg.InvertAffine(a)
+	for i := range inv {
+		for j := range inv[i] {
+			inv[i][j] += 0 // no negative zeros
+		}
+	}
+	return Affine(inv), err
+}
+
+// decoders decode the JSON of each kind of step.
+var (
+	decodersMu sync.Mutex
+	decoders   = map[string]func(json.RawMessage) (Step, error){
+		"affine": func(b json.RawMessage) (Step, error) {
+			var a Affine
+			err := json.Unmarshal(b, &a)
+			return a, err
+		},
+	}
+)
+
+// RegisterKind adds a kind of step that can be read from JSON.
+func RegisterKind(kind string, decode func(json.RawMessage) (Step, error)) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[kind] = decode
+}
+
+// Transform is a chain of steps, applied in order.
+type Transform struct {
+	Steps []Step
+}
+
+// New returns a transform of the steps.
+func New(steps ...Step) *Transform {
+	return &Transform{Steps: steps}
+}
+
+// Then returns the transform that applies t and then u.
+func (t *Transform) Then(u *Transform) *Transform {
+	steps := make([]Step, 0, len(t.Steps)+len(u.Steps))
+	steps = append(steps, t.Steps...)
+	return &Transform{Steps: append(steps, u.Steps...)}
+}
+
+// Apply maps a point through every step. It reports false if the point
+// leaves the domain of a step.
+func (t *Transform) Apply(p [3]float64) ([3]float64, bool) {
+	for _, s := range t.Steps {
+		var ok bool
+		if p, ok = s.Apply(p); !ok {
+			return p, false
+		}
+	}
+	return p, true
+}
+
+// Inverse returns the transform mapping the other way: the inverse steps in
+// reverse order.
+func (t *Transform) Inverse() (*Transform, error) {
+	steps := make([]Step, len(t.Steps))
+	for i, s := range t.Steps {
+		inv, err := s.Inverse()
+		if err != nil {
+			return nil, fmt.Errorf("step %d (%s): %w", i, s.Kind(), err)
+		}
+		steps[len(steps)-1-i] = inv
+	}
+	return &Transform{Steps: steps}, nil
+}
+
+// Simplify returns the transform with consecutive affine steps multiplied
+// into one.
+func (t *Transform) Simplify() *Transform {
+	var steps []Step
+	for _, s := range t.Steps {
+		a, ok := s.(Affine)
+		if n := len(steps); ok && n > 0 {
+			if prev, ok := steps[n-1].(Affine); ok {
+				steps[n-1] = Affine(linalg.MulAffine(a, prev))
+				continue
+			}
+		}
+		steps = append(steps, s)
+	}
+	return &Transform{Steps: steps}
+}
+
+// Matrix returns the transform as one matrix, if all its steps are affine.
+func (t *Transform) Matrix() ([4][4]float64, bool) {
+	s := t.Simplify()
+	switch len(s.Steps) {
+	case 0:
+		return Identity, true
+	case 1:
+		a, ok := s.Steps[0].(Affine)
+		return a, ok
+	}
+	return [4][4]float64{}, false
+}
+
+// jsonStep is a step in JSON.
+type jsonStep struct {
+	Kind   string          `json:"kind"`
+	Params json.RawMessage `json:"params"`
+}
+
+// MarshalJSON encodes the transform as {"steps": [{"kind", "params"}]}.
+func (t *Transform) MarshalJSON() ([]byte, error) {
+	var v struct {
+		Steps []jsonStep `json:"steps"`
+	}
+	v.Steps = []jsonStep{}
+	for _, s := range t.Steps {
+		b, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		v.Steps = append(v.Steps, jsonStep{s.Kind(), b})
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a transform written by MarshalJSON.
+func (t *Transform) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Steps []jsonStep `json:"steps"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	t.Steps = nil
+	for i, js := range v.Steps {
+		decodersMu.Lock()
+		decode, ok := decoders[js.Kind]
+		decodersMu.Unlock()
+		if !ok {
+			return fmt.Errorf("step %d: %w step kind %q", i, nifti1.ErrUnsupported, js.Kind)
+		}
+		s, err := decode(js.Params)
+		if err != nil {
+			return fmt.Errorf("step %d (%s): %v", i, js.Kind, err)
+		}
+		t.Steps = append(t.Steps, s)
+	}
+	return nil
+}
+
+// ReadFile reads a transform from JSON (.json), a warp field (.nii or
+// .nii.gz, see ReadWarp), or a text file of a 4x4 matrix, one row per line.
+func ReadFile(name string) (*Transform, error) {
+	if IsWarp(name) {
+		w, err := ReadWarp(name, "")
+		if err != nil {
+			return nil, err
+		}
+		return New(w), nil
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		var t Transform
+		if err := json.Unmarshal(b, &t); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return &t, nil
+	}
+	a, err := parseMatrix(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return New(a), nil
+}
+
+// parseMatrix parses a 4x4 matrix of whitespace-separated numbers. Blank
+// lines and lines starting with '#' are skipped.
+func parseMatrix(b []byte) (Affine, error) {
+	var a Affine
+	var rows [][]float64
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var row []float64
+		for _, f := range strings.Fields(line) {
+			x, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return a, fmt.Errorf("invalid number %q", f)
+			}
+			row = append(row, x)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 4 {
+		return a, fmt.Errorf("matrix has %d rows, expected 4", len(rows))
+	}
+	for i, row := range rows {
+		if len(row) != 4 {
+			return a, fmt.Errorf("matrix row %d has %d values, expected 4", i+1, len(row))
+		}
+		copy(a[i][:], row)
+	}
+	return a, nil
+}
+
+// WriteFile writes the transform as JSON (.json) or, for a transform that
+// is a single matrix, as text.
+func (t *Transform) WriteFile(name string) error {
+	var b []byte
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		var err error
+		if b, err = json.MarshalIndent(t, "", "  "); err != nil {
+			return err
+		}
+		b = append(b, '\n')
+	} else {
+		m, ok := t.Matrix()
+		if !ok {
+			return fmt.Errorf("%s: %w: only affine transforms can be written as a matrix; use .json", name, nifti1.ErrUnsupported)
+		}
+		b = []byte(FormatMatrix(m))
+	}
+	return util.WriteFileAtomic(name, b, 0644)
+}
+
+// FormatMatrix formats a matrix as four lines of text.
+func FormatMatrix(m [4][4]float64) string {
+	var b strings.Builder
+	for _, row := range m {
+		fmt.Fprintf(&b, "%.8f %.8f %.8f %.8f\n", row[0], row[1], row[2], row[3])
+	}
+	return b.String()
+}
+
+// Resample returns moving resampled onto the grid of reference: each
+// reference voxel takes the value of moving at the transformed position,
+// interpolated as o selects, or 0 outside moving with the interp.Zero
+// boundary. All volumes of moving are resampled. The result is float32 with
+// the geometry of reference.
+func (t *Transform) Resample(moving, reference *nifti1.Image, o interp.Options) (*nifti1.Image, error) {
+	inv, err := linalg.InvertAffine(moving.Affine())
+	if err != nil {
+		return nil, fmt.Errorf("moving affine: %w", err)
+	}
+	dims := [3]int{reference.Nx, reference.Ny, reference.Nz}
+	affine := reference.Affine()
+
+	// Voxel positions in moving, shared by all volumes.
+	n := dims[0] * dims[1] * dims[2]
+	pos := make([][3]float64, n)
+	inside := make([]bool, n)
+	for k, m := 0, 0; k < dims[2]; k++ {
+		for j := 0; j < dims[1]; j++ {
+			for i := 0; i < dims[0]; i, m = i+1, m+1 {
+				w := linalg.ApplyAffine(affine, [3]float64{float64(i), float64(j), float64(k)})
+				if w, inside[m] = t.Apply(w); inside[m] {
+					pos[m] = linalg.ApplyAffine(inv, w)
+				}
+			}
+		}
+	}
+
+	all, err := moving.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	nxyz := moving.Nx * moving.Ny * moving.Nz
+	nt := moving.NVox / nxyz
+	values := make([]float64, 0, n*nt)
+	for v := 0; v < nt; v++ {
+		vol, err := interp.New(all[v*nxyz:(v+1)*nxyz], [3]int{moving.Nx, moving.Ny, moving.Nz}, o)
+		if err != nil {
+			return nil, err
+		}
+		for m := 0; m < n; m++ {
+			x := 0.0
+			if inside[m] {
+				x, _ = vol.Sample(pos[m])
+			}
+			values = append(values, x)
+		}
+	}
+
+	out := *reference
+	d := []int{dims[0], dims[1], dims[2]}
+	if nt > 1 {
+		d = append(d, nt)
+	}
+	if err := out.SetDims(d...); err != nil {
+		return nil, err
+	}
+	if err := out.SetFloat32Data(values); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,255 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/linalg"
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/register"
+)
+
+// Conventions of the displacement vectors of warp fields.
+const (
+	// WarpRAS displacements are in world (RAS) mm.
+	WarpRAS = "ras"
+	// WarpITK displacements are in LPS mm, as written by ANTs and ITK
+	// (5D, one component per dim[5]).
+	WarpITK = "itk"
+	// WarpFSL displacements are relative, in FSL's scaled voxel mm, as
+	// written by fnirt --fout or convertwarp --relout (4D, one component
+	// per volume).
+	WarpFSL = "fsl"
+)
+
+// intentDispVect is NIFTI_INTENT_DISPVECT, the intent of ITK fields.
+const intentDispVect = 1006
+
+// Warp is a dense displacement field step: a point p maps to p + d(p),
+// where d is interpolated linearly from the field and 0 outside it.
+type Warp struct {
+	File       string `json:"file,omitempty"`
+	Convention string `json:"convention"`
+	Inverted   bool   `json:"inverse,omitempty"`
+
+	inv  [4][4]float64 // world to voxels of the field
+	disp [3]register.Volume
+}
+
+func init() {
+	RegisterKind("warp", func(b json.RawMessage) (Step, error) {
+		var w Warp
+		if err := json.Unmarshal(b, &w); err != nil {
+			return nil, err
+		}
+		if w.File == "" {
+			return nil, fmt.Errorf("warp has no file")
+		}
+		r, err := ReadWarp(w.File, w.Convention)
+		if err != nil {
+			return nil, err
+		}
+		r.Inverted = w.Inverted
+		return r, nil
+	})
+}
+
+// IsWarp reports whether name has the extension of a NIfTI-1 file, which
+// ReadFile reads as a warp field.
+func IsWarp(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".nii") || strings.HasSuffix(lower, ".nii.gz")
+}
+
+// ReadWarp reads a warp field. An empty convention is guessed from the
+// layout of the file: 5D fields are WarpITK and 4D fields are WarpFSL.
+func ReadWarp(name, convention string) (*Warp, error) {
+	img, err := nifti1.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWarp(img, convention)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if w.File, err = filepath.Abs(name); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWarp returns the warp of a displacement field image with three
+// components, either as dim[5] (5D) or as volumes (4D).
+func NewWarp(img *nifti1.Image, convention string) (*Warp, error) {
+	nxyz := img.Nx * img.Ny * img.Nz
+	is5D := img.Nt <= 1 && img.Nu == 3
+	if img.NVox != 3*nxyz || (!is5D && img.Nt != 3) {
+		return nil, fmt.Errorf("%w: warp field needs 3 components, got dims %v", nifti1.ErrInvalidHeader, img.Dim[1:img.NDim+1])
+	}
+	if convention == "" {
+		convention = WarpFSL
+		if is5D || img.IntentCode == intentDispVect {
+			convention = WarpITK
+		}
+	}
+
+	affine := img.Affine()
+	inv, err := linalg.InvertAffine(affine)
+	if err != nil {
+		return nil, fmt.Errorf("warp affine: %w", err)
+	}
+	values, err := img.ScaledFloat64s()
+	if err != nil {
+		return nil, err
+	}
+	w := &Warp{Convention: convention, inv: inv}
+	var comp [3][]float64
+	for c := range comp {
+		comp[c] = values[c*nxyz : (c+1)*nxyz]
+	}
+
+	// Convert the displacements to RAS mm.
+	switch convention {
+	case WarpRAS:
+	case WarpITK:
+		for m := 0; m < nxyz; m++ {
+			comp[0][m], comp[1][m] = -comp[0][m], -comp[1][m]
+		}
+	case WarpFSL:
+		// FSL's axes are the voxel axes scaled by pixdim, with x flipped
+		// if the affine has a positive determinant. Their directions in
+		// world space are the normalized columns of the affine.
+		var dir [3][3]float64
+		for j := 0; j < 3; j++ {
+			norm := math.Sqrt(affine[0][j]*affine[0][j] + affine[1][j]*affine[1][j] + affine[2][j]*affine[2][j])
+			for i := 0; i < 3; i++ {
+				dir[i][j] = affine[i][j] / norm
+			}
+		}
+		linear := [3][3]float64{
+			{affine[0][0], affine[0][1], affine[0][2]},
+			{affine[1][0], affine[1][1], affine[1][2]},
+			{affine[2][0], affine[2][1], affine[2][2]},
+		}
+		if det3(linear) > 0 {
+			for i := 0; i < 3; i++ {
+				dir[i][0] = -dir[i][0]
+			}
+		}
+		for m := 0; m < nxyz; m++ {
+			d := [3]float64{comp[0][m], comp[1][m], comp[2][m]}
+			for i := 0; i < 3; i++ {
+				comp[i][m] = dir[i][0]*d[0] + dir[i][1]*d[1] + dir[i][2]*d[2]
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w warp convention %q", nifti1.ErrUnsupported, convention)
+	}
+
+	for c := range w.disp {
+		w.disp[c] = register.Volume{Values: comp[c], Dims: [3]int{img.Nx, img.Ny, img.Nz}, Affine: affine}
+	}
+	return w, nil
+}
+
+// Kind returns "warp".
+func (w *Warp) Kind() string { return "warp" }
+
+// displacement returns the displacement at p, 0 outside the field.
+func (w *Warp) displacement(p [3]float64) [3]float64 {
+	var d [3]float64
+	v := linalg.ApplyAffine(w.inv, p)
+	for c := range d {
+		d[c], _ = w.disp[c].Sample(v)
+	}
+	return d
+}
+
+// Apply maps p by the field, or by its inverse if the warp is inverted.
+func (w *Warp) Apply(p [3]float64) ([3]float64, bool) {
+	if !w.Inverted {
+		d := w.displacement(p)
+		return [3]float64{p[0] + d[0], p[1] + d[1], p[2] + d[2]}, true
+	}
+
+	// Solve q + d(q) = p by fixed-point iteration.
+	q := p
+	for n := 0; n < 50; n++ {
+		d := w.displacement(q)
+		next := [3]float64{p[0] - d[0], p[1] - d[1], p[2] - d[2]}
+		delta := math.Abs(next[0]-q[0]) + math.Abs(next[1]-q[1]) + math.Abs(next[2]-q[2])
+		q = next
+		if delta < 1e-4 {
+			return q, true
+		}
+	}
+	return q, false
+}
+
+// Inverse returns the warp mapping the other way, solved per point.
+func (w *Warp) Inverse() (Step, error) {
+	inv := *w
+	inv.Inverted = !w.Inverted
+	return &inv, nil
+}
+
+// Jacobian returns the determinant of the Jacobian of the mapping
+// p -> p + d(p) at each voxel of the field, the local volume change: above
+// 1 where the field expands, below 1 where it contracts. Derivatives are
+// taken in world mm, so pixdims and oblique grids are accounted for, with
+// central differences inside the grid and one-sided differences at its
+// boundaries. The inversion of the warp is ignored.
+func (w *Warp) Jacobian() []float64 {
+	dims := w.disp[0].Dims
+	nx, nxy := dims[0], dims[0]*dims[1]
+	stride := [3]int{1, nx, nxy}
+
+	// diff returns the derivative of component c along voxel axis a at
+	// voxel m, whose coordinate on that axis is x.
+	diff := func(c, a, m, x int) float64 {
+		v := w.disp[c].Values
+		switch {
+		case dims[a] < 2:
+			return 0
+		case x == 0:
+			return v[m+stride[a]] - v[m]
+		case x == dims[a]-1:
+			return v[m] - v[m-stride[a]]
+		}
+		return (v[m+stride[a]] - v[m-stride[a]]) / 2
+	}
+
+	out := make([]float64, nxy*dims[2])
+	for k, m := 0, 0; k < dims[2]; k++ {
+		for j := 0; j < dims[1]; j++ {
+			for i := 0; i < dims[0]; i, m = i+1, m+1 {
+				pos := [3]int{i, j, k}
+				// J = I + (dd/dv)(dv/dx), where dv/dx is the inverse
+				// of the linear part of the affine.
+				var jac [3][3]float64
+				for c := 0; c < 3; c++ {
+					var dv [3]float64
+					for a := 0; a < 3; a++ {
+						dv[a] = diff(c, a, m, pos[a])
+					}
+					for r := 0; r < 3; r++ {
+						jac[c][r] = dv[0]*w.inv[0][r] + dv[1]*w.inv[1][r] + dv[2]*w.inv[2][r]
+					}
+					jac[c][c]++
+				}
+				out[m] = det3(jac)
+			}
+		}
+	}
+	return out
+}
+
+// det3 returns the determinant of a 3x3 matrix.
+func det3(a [3][3]float64) float64 {
+	return a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+}
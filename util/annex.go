@@ -0,0 +1,101 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrAnnexContentMissing is returned when a file is a git-annex placeholder
+// whose content has not been retrieved, e.g. in a DataLad dataset that was
+// cloned without `datalad get`.
+var ErrAnnexContentMissing = errors.New("annexed content not present")
+
+// AnnexGetHook, if set, is called to retrieve the content of a placeholder
+// before ErrAnnexContentMissing is returned. It receives the path as given
+// to ResolveAnnex.
+var AnnexGetHook func(path string) error
+
+// ReadHook, if set, is called with the name of every file that is read
+// through ResolveAnnex or OpenRemote, such as to record the inputs of a
+// command. It may be called from several goroutines at once.
+var ReadHook func(name string)
+
+// noteRead calls ReadHook, if set.
+func noteRead(name string) {
+	if ReadHook != nil {
+		ReadHook(name)
+	}
+}
+
+// annexObjects marks the targets of locked annexed files and the content of
+// unlocked pointer files.
+const annexObjects = "/annex/objects/"
+
+// maxPointerSize bounds the size of an unlocked pointer file, which holds
+// only a key.
+const maxPointerSize = 1024
+
+// ResolveAnnex returns the path to read for filename. Locked annexed files
+// are symlinks into .git/annex/objects and resolve to the object; unlocked
+// files are replaced by their content when it is present, so a remaining
+// pointer file means the content is missing. Other files are returned
+// unchanged.
+func ResolveAnnex(filename string) (string, error) {
+	noteRead(filename)
+	resolved, present, annexed := inspectAnnex(filename)
+	if !annexed || present {
+		return resolved, nil
+	}
+	if AnnexGetHook != nil {
+		log.WithFields(log.Fields{
+			"file": filename,
+		}).Info("Retrieving annexed content")
+		if err := AnnexGetHook(filename); err != nil {
+			return "", fmt.Errorf("%s: retrieving annexed content: %v", filename, err)
+		}
+		if resolved, present, _ = inspectAnnex(filename); present {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w, run `datalad get %s` (or `git annex get`)", filename, ErrAnnexContentMissing, filename)
+}
+
+// inspectAnnex reports whether filename is annexed and, if so, whether its
+// content is present and where.
+func inspectAnnex(filename string) (resolved string, present, annexed bool) {
+	fi, err := os.Lstat(filename)
+	if err != nil {
+		// Let the caller report the error.
+		return filename, false, false
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filename)
+		if err != nil || !strings.Contains(filepath.ToSlash(target), annexObjects) {
+			return filename, false, false
+		}
+		resolved, err = filepath.EvalSymlinks(filename)
+		if err != nil {
+			return filename, false, true
+		}
+		log.WithFields(log.Fields{
+			"file":   filename,
+			"object": resolved,
+		}).Debug("Resolved annexed file")
+		return resolved, true, true
+	}
+
+	if fi.Mode().IsRegular() && fi.Size() < maxPointerSize {
+		b, err := ioutil.ReadFile(filename)
+		if err == nil && strings.HasPrefix(string(b), annexObjects) {
+			return filename, false, true
+		}
+	}
+	return filename, false, false
+}
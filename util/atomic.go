@@ -0,0 +1,180 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SyncWrites makes WriteFileAtomic, and the writers built on it, flush each
+// file and then its directory to stable storage before returning, so that a
+// written file survives a crash of the machine, not only of the process.
+var SyncWrites bool
+
+// DryRun makes WriteFileAtomic, WriteAtomic, and WriteAtomicName print the
+// file each would create or overwrite, with its size, instead of writing
+// it. It is set by the -dry-run flag of commands.
+var DryRun bool
+
+// PrintDryRun prints what writing size bytes to filename would do: whether
+// it creates or overwrites the file, and the sizes of the new content and
+// of the file it replaces. A negative size is not known.
+func PrintDryRun(filename string, size int64) {
+	switch info, err := os.Stat(filename); {
+	case err != nil && size < 0:
+		fmt.Printf("would create %s\n", filename)
+	case err != nil:
+		fmt.Printf("would create %s: %d bytes\n", filename, size)
+	case size < 0:
+		fmt.Printf("would overwrite %s, now %d bytes\n", filename, info.Size())
+	default:
+		fmt.Printf("would overwrite %s: %d bytes, now %d\n", filename, size, info.Size())
+	}
+}
+
+// countWriter counts the bytes written to it and discards them.
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// WriteFileAtomic writes b to filename through a temporary file in the same
+// directory, which is renamed over filename once it is complete, so that an
+// interrupted write, such as of a preempted job, leaves either the old file
+// or the new one and never a partial one. A file that is overwritten keeps
+// its permissions; new files get perm.
+func WriteFileAtomic(filename string, b []byte, perm os.FileMode) error {
+	if DryRun {
+		PrintDryRun(filename, int64(len(b)))
+		return nil
+	}
+	f, err := createTemp(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		abortTemp(f)
+		return err
+	}
+	return commitTemp(f, filename, perm)
+}
+
+// WriteAtomic is like WriteFileAtomic for outputs encoded to a stream:
+// write is called with the temporary file, and the file replaces filename
+// only if write returns nil.
+func WriteAtomic(filename string, write func(w io.Writer) error) error {
+	if DryRun {
+		var w countWriter
+		if err := write(&w); err != nil {
+			return err
+		}
+		PrintDryRun(filename, w.n)
+		return nil
+	}
+	f, err := createTemp(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		abortTemp(f)
+		return err
+	}
+	return commitTemp(f, filename, 0644)
+}
+
+// WriteAtomicName is like WriteAtomic for writers that take a file name,
+// such as external programs: write is called with the name of the empty
+// temporary file, which it must overwrite in place rather than replace.
+// With DryRun, write is not called.
+func WriteAtomicName(filename string, write func(name string) error) error {
+	if DryRun {
+		PrintDryRun(filename, -1)
+		return nil
+	}
+	f, err := createTemp(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f.Name()); err != nil {
+		abortTemp(f)
+		return err
+	}
+	return commitTemp(f, filename, 0644)
+}
+
+// createTemp creates the temporary file of a write to filename, registered
+// with TrackTemp. Its name starts with a dot, so that directory walks skip
+// it if it is left behind by a killed process.
+func createTemp(filename string) (*os.File, error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".tmp*")
+	if err == nil {
+		TrackTemp(f.Name())
+	}
+	return f, err
+}
+
+// abortTemp closes and removes a temporary file after a failed write.
+func abortTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+	untrackTemp(f.Name())
+}
+
+// commitTemp renames the complete temporary file f to filename, syncing it
+// first with SyncWrites.
+func commitTemp(f *os.File, filename string, perm os.FileMode) error {
+	if fi, err := os.Stat(filename); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if err := f.Chmod(perm); err != nil {
+		abortTemp(f)
+		return err
+	}
+	if SyncWrites {
+		if err := f.Sync(); err != nil {
+			abortTemp(f)
+			return err
+		}
+	}
+	defer untrackTemp(f.Name())
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if SyncWrites {
+		syncDir(filepath.Dir(filename))
+	}
+	return nil
+}
+
+// syncDir flushes a directory, so that a rename in it is durable. Not every
+// platform can sync directories, so failures are only logged.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err == nil {
+		err = d.Sync()
+		d.Close()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"dir":   dir,
+			"error": err,
+		}).Debug("Could not sync directory")
+	}
+}
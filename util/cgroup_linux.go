@@ -0,0 +1,118 @@
+package util
+
+import (
+	"io/ioutil"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the control group file systems are mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cpuQuota reads the CPU quota of the process from its control group, the
+// lowest of the group and its parents: cpu.max with cgroup v2, or
+// cpu.cfs_quota_us and cpu.cfs_period_us with v1.
+func cpuQuota() (int, bool) {
+	b, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+	quota := math.Inf(1)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		// Lines are hierarchy-ID:controllers:path, with no controllers
+		// for the unified hierarchy of v2.
+		f := strings.SplitN(line, ":", 3)
+		if len(f) != 3 {
+			continue
+		}
+		switch {
+		case f[1] == "":
+			eachGroup(cgroupRoot, f[2], func(dir string) {
+				if q, ok := readCPUMax(dir); ok && q < quota {
+					quota = q
+				}
+			})
+		case hasController(f[1], "cpu"):
+			eachGroup(path.Join(cgroupRoot, f[1]), f[2], func(dir string) {
+				if q, ok := readCFSQuota(dir); ok && q < quota {
+					quota = q
+				}
+			})
+		}
+	}
+	if math.IsInf(quota, 1) {
+		return 0, false
+	}
+	n := int(math.Ceil(quota))
+	if n < 1 {
+		n = 1
+	}
+	return n, true
+}
+
+// hasController reports whether the comma-separated controllers of a v1
+// hierarchy include name.
+func hasController(controllers, name string) bool {
+	for _, c := range strings.Split(controllers, ",") {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// eachGroup calls fn with the directory of group under the hierarchy
+// mounted at mount and of each of its parents. In a container, whose
+// cgroup namespace makes its own group the root, the path is "/" and only
+// mount itself is visited.
+func eachGroup(mount, group string, fn func(dir string)) {
+	for g := path.Clean("/" + group); ; g = path.Dir(g) {
+		fn(path.Join(mount, g))
+		if g == "/" {
+			return
+		}
+	}
+}
+
+// readCPUMax reads the quota in CPUs from the cpu.max file of a v2 group,
+// "max 100000" if it has none or "150000 100000" for 1.5 CPUs.
+func readCPUMax(dir string) (float64, bool) {
+	b, err := ioutil.ReadFile(path.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0, false
+	}
+	f := strings.Fields(string(b))
+	if len(f) != 2 || f[0] == "max" {
+		return 0, false
+	}
+	return quotaCPUs(f[0], f[1])
+}
+
+// readCFSQuota reads the quota in CPUs of a v1 group, whose quota is -1 if
+// it has none.
+func readCFSQuota(dir string) (float64, bool) {
+	q, err := ioutil.ReadFile(path.Join(dir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	p, err := ioutil.ReadFile(path.Join(dir, "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return quotaCPUs(strings.TrimSpace(string(q)), strings.TrimSpace(string(p)))
+}
+
+// quotaCPUs divides a quota by its period, both in microseconds.
+func quotaCPUs(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
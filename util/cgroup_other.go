@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+func cpuQuota() (int, bool) {
+	return 0, false
+}
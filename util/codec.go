@@ -0,0 +1,240 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Codec is a compression format, recognized by the suffix of file names
+// when writing and by the leading bytes of the content when reading.
+type Codec struct {
+	Name   string // e.g. "gzip"
+	Suffix string // e.g. ".gz"
+	Magic  []byte // the first bytes of every compressed stream
+	// NewReader returns a reader of the content decompressed from r.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	// NewWriter returns a writer that compresses to w at level, where -1
+	// is the default of the codec and 1 to 9 range from fastest to
+	// smallest. Closing it flushes the compressed stream but does not
+	// close w.
+	NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// codecs are the registered codecs, gzip first.
+var codecs = struct {
+	sync.RWMutex
+	list []Codec
+}{list: []Codec{{
+	Name:   "gzip",
+	Suffix: ".gz",
+	Magic:  []byte{0x1f, 0x8b},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		g, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return g, nil
+	},
+	NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	},
+}}}
+
+// RegisterCodec adds a codec, or replaces the codec of the same name, so
+// that ReadBytes, WriteBytes, and the readers and writers of images handle
+// files with its suffix and magic bytes.
+func RegisterCodec(c Codec) error {
+	if c.Name == "" || c.Suffix == "" || len(c.Magic) == 0 || c.NewReader == nil || c.NewWriter == nil {
+		return fmt.Errorf("codec %q needs a name, a suffix, magic bytes, a reader, and a writer", c.Name)
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	for i, d := range codecs.list {
+		if d.Name == c.Name {
+			codecs.list[i] = c
+			return nil
+		}
+	}
+	codecs.list = append(codecs.list, c)
+	return nil
+}
+
+// Codecs returns the registered codecs.
+func Codecs() []Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return append([]Codec(nil), codecs.list...)
+}
+
+// CodecFromName returns the codec whose suffix ends filename.
+func CodecFromName(filename string) (Codec, bool) {
+	for _, c := range Codecs() {
+		if strings.HasSuffix(filename, c.Suffix) {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// SplitCodecSuffix removes the suffix of a codec from filename and returns
+// it, or "" if filename has none.
+func SplitCodecSuffix(filename string) (base, suffix string) {
+	if c, ok := CodecFromName(filename); ok {
+		return strings.TrimSuffix(filename, c.Suffix), c.Suffix
+	}
+	return filename, ""
+}
+
+// DetectCodec returns the codec whose magic bytes start b.
+func DetectCodec(b []byte) (Codec, bool) {
+	for _, c := range Codecs() {
+		if bytes.HasPrefix(b, c.Magic) {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// MagicLen is the length of the longest magic of the registered codecs,
+// which is enough of a stream for DetectCodec.
+func MagicLen() int {
+	n := 0
+	for _, c := range Codecs() {
+		if len(c.Magic) > n {
+			n = len(c.Magic)
+		}
+	}
+	return n
+}
+
+// Compress compresses content at level.
+func (c Codec) Compress(content []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses content.
+func (c Codec) Decompress(content []byte) ([]byte, error) {
+	r, err := c.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// ExternalCodec returns a codec that runs external programs, which read
+// from stdin and write to stdout: decompress, such as
+// []string{"zstd", "-d", "-c"}, and compress, such as
+// []string{"zstd", "-c"}, to which "-<level>" is appended for levels 1 to
+// 9. The programs must be on the PATH when files are read or written.
+func ExternalCodec(name, suffix string, magic []byte, decompress, compress []string) Codec {
+	return Codec{
+		Name:   name,
+		Suffix: suffix,
+		Magic:  magic,
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			cmd := exec.Command(decompress[0], decompress[1:]...)
+			cmd.Stdin = r
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			out, err := cmd.StdoutPipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := cmd.Start(); err != nil {
+				return nil, err
+			}
+			return &externalReader{out, cmd, &stderr}, nil
+		},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			args := append([]string(nil), compress[1:]...)
+			if level >= 1 && level <= 9 {
+				args = append(args, fmt.Sprintf("-%d", level))
+			}
+			cmd := exec.Command(compress[0], args...)
+			cmd.Stdout = w
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			in, err := cmd.StdinPipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := cmd.Start(); err != nil {
+				return nil, err
+			}
+			return &externalWriter{in, cmd, &stderr}, nil
+		},
+	}
+}
+
+// externalReader reads the output of an external decompressor.
+type externalReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+// Read returns the error of the program, with its message, once its
+// output ends.
+func (r *externalReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF && r.cmd != nil {
+		cmd := r.cmd
+		r.cmd = nil
+		if werr := cmd.Wait(); werr != nil {
+			return n, externalError(cmd, werr, r.stderr)
+		}
+	}
+	return n, err
+}
+
+func (r *externalReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.cmd != nil {
+		r.cmd.Wait()
+	}
+	return err
+}
+
+// externalWriter writes to the input of an external compressor.
+type externalWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (w *externalWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	if err := w.cmd.Wait(); err != nil {
+		return externalError(w.cmd, err, w.stderr)
+	}
+	return nil
+}
+
+// externalError adds the name and message of a program to its error.
+func externalError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s: %v: %s", cmd.Path, err, msg)
+	}
+	return fmt.Errorf("%s: %v", cmd.Path, err)
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// gzipMember compresses b as one gzip member. With bgzf, the member carries
+// the BC extra field of BGZF, which records the size of the block.
+func gzipMember(t *testing.T, b []byte, bgzf bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	g := gzip.NewWriter(&buf)
+	if bgzf {
+		g.Header.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+	}
+	if _, err := g.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	member := buf.Bytes()
+	if bgzf {
+		// BSIZE follows the 10-byte header, XLEN, SI1, SI2, and SLEN.
+		binary.LittleEndian.PutUint16(member[16:], uint16(len(member)-1))
+	}
+	return member
+}
+
+// multiMember returns data split into members of at most size bytes, as
+// "cat a.gz b.gz" and BGZF write them, with the empty member that ends BGZF
+// files, along with the data.
+func multiMember(t *testing.T, size int, bgzf bool) (compressed, data []byte) {
+	t.Helper()
+	data = make([]byte, 3*size+17)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	for i := 0; i < len(data); i += size {
+		end := i + size
+		if end > len(data) {
+			end = len(data)
+		}
+		compressed = append(compressed, gzipMember(t, data[i:end], bgzf)...)
+	}
+	if bgzf {
+		compressed = append(compressed, gzipMember(t, nil, true)...)
+	}
+	return compressed, data
+}
+
+func TestDecompressBytesMultiMember(t *testing.T) {
+	for _, bgzf := range []bool{false, true} {
+		compressed, data := multiMember(t, 1000, bgzf)
+		got, err := DecompressBytes(compressed)
+		if err != nil {
+			t.Fatalf("bgzf=%v: %v", bgzf, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("bgzf=%v: got %d bytes, want %d", bgzf, len(got), len(data))
+		}
+	}
+}
+
+func TestInflateToDiskMultiMember(t *testing.T) {
+	dir := t.TempDir()
+	for _, bgzf := range []bool{false, true} {
+		compressed, data := multiMember(t, 1000, bgzf)
+		name := filepath.Join(dir, "in.nii.gz")
+		if err := ioutil.WriteFile(name, compressed, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := InflateToDisk(name, dir)
+		if err != nil {
+			t.Fatalf("bgzf=%v: %v", bgzf, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("bgzf=%v: got %d bytes, want %d", bgzf, len(got), len(data))
+		}
+	}
+}
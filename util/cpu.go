@@ -0,0 +1,81 @@
+package util
+
+import (
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxWorkers is the limit set by SetMaxWorkers, or 0 for AvailableCPUs.
+var maxWorkers int32
+
+// CPUQuota returns the number of CPUs the control group of the process may
+// use, rounded up, such as 2 for docker run --cpus 1.5 or a Kubernetes CPU
+// limit of 1500m, or false if it has no quota. Quotas are only known on
+// Linux.
+func CPUQuota() (int, bool) {
+	return cpuQuota()
+}
+
+// AvailableCPUs returns GOMAXPROCS, or the CPU quota of the process if it
+// is lower. runtime.NumCPU counts the CPUs of the machine, not those a
+// container may use, so that starting that many goroutines in a container
+// with a quota oversubscribes it, and throttling makes them all wait.
+func AvailableCPUs() int {
+	n := runtime.GOMAXPROCS(0)
+	if q, ok := CPUQuota(); ok && q < n {
+		n = q
+	}
+	return n
+}
+
+// SetMaxWorkers limits the goroutines of every parallel task, whatever
+// number of workers it is given, to n. An n below 1 restores the default,
+// AvailableCPUs.
+func SetMaxWorkers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&maxWorkers, int32(n))
+}
+
+// MaxWorkers returns the limit set by SetMaxWorkers, or AvailableCPUs.
+func MaxWorkers() int {
+	if n := atomic.LoadInt32(&maxWorkers); n > 0 {
+		return int(n)
+	}
+	return AvailableCPUs()
+}
+
+// Workers returns the number of goroutines a parallel task asking for n
+// workers starts: n, at least 1 and at most MaxWorkers.
+func Workers(n int) int {
+	if limit := MaxWorkers(); n > limit {
+		n = limit
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// FitProcsToQuota lowers GOMAXPROCS to the CPU quota of the process, unless
+// the GOMAXPROCS environment variable sets it, so that the garbage
+// collector and the scheduler do not run more threads than the container
+// may use.
+func FitProcsToQuota() {
+	if os.Getenv("GOMAXPROCS") != "" {
+		return
+	}
+	q, ok := CPUQuota()
+	if !ok || q >= runtime.GOMAXPROCS(0) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"quota":      q,
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+	}).Debug("Lowering GOMAXPROCS to the CPU quota")
+	runtime.GOMAXPROCS(q)
+}
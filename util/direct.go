@@ -0,0 +1,130 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// directBlock is the alignment of the buffers, offsets, and lengths of
+// direct I/O, which covers the logical block size of common devices.
+const directBlock = 4096
+
+// directChunk is the size of the writes of WriteDirect.
+const directChunk = 16 << 20
+
+// ReadDirect returns the contents of a local file, read around the page
+// cache where the platform supports it (see CanDirect), so that reading a
+// large image once does not evict the pages of other jobs. It falls back to
+// an ordinary read if the file system does not support direct I/O, such as
+// tmpfs. Unlike ReadBytes, it does not inflate gzipped files.
+func ReadDirect(filename string) ([]byte, error) {
+	f, err := openDirect(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return directFallback(filename, err, func() ([]byte, error) {
+			return ioutil.ReadFile(filename)
+		})
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := int(fi.Size())
+	b := alignedBuffer(roundUp(size))
+	// The read stops at the end of the file, within the last block.
+	if n, err := f.ReadAt(b, 0); n < size {
+		return directFallback(filename, err, func() ([]byte, error) {
+			return ioutil.ReadFile(filename)
+		})
+	}
+	return b[:size], nil
+}
+
+// WriteDirect writes b to a local file around the page cache where the
+// platform supports it, after reserving its blocks with fallocate, so that
+// writing a large image neither fragments it nor fills the page cache. It
+// falls back to an ordinary write if the file system does not support
+// direct I/O. It does not compress. Like WriteFileAtomic, it writes through a
+// temporary file that is renamed to filename once complete.
+func WriteDirect(filename string, b []byte) error {
+	tmp, err := createTemp(filename)
+	if err != nil {
+		return err
+	}
+	f, err := openDirect(tmp.Name(), os.O_WRONLY, 0)
+	if err == nil {
+		err = writeDirect(f, b)
+	}
+	if err != nil {
+		_, err = directFallback(filename, err, func() ([]byte, error) {
+			if err := tmp.Truncate(0); err != nil {
+				return nil, err
+			}
+			_, err := tmp.WriteAt(b, 0)
+			return nil, err
+		})
+		if err != nil {
+			abortTemp(tmp)
+			return err
+		}
+	}
+	return commitTemp(tmp, filename, 0644)
+}
+
+// writeDirect writes b to f, opened for direct I/O, and closes it.
+func writeDirect(f *os.File, b []byte) error {
+	defer f.Close()
+	if err := preallocate(f, int64(len(b))); err != nil {
+		log.WithFields(log.Fields{
+			"file":  f.Name(),
+			"error": err,
+		}).Debug("Could not preallocate")
+	}
+	buf := alignedBuffer(directChunk)
+	for off := 0; off < len(b); off += directChunk {
+		n := copy(buf, b[off:])
+		// The last block is padded with zeros, which are then truncated.
+		padded := roundUp(n)
+		for i := n; i < padded; i++ {
+			buf[i] = 0
+		}
+		if _, err := f.WriteAt(buf[:padded], int64(off)); err != nil {
+			return err
+		}
+	}
+	if err := f.Truncate(int64(len(b))); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// directFallback logs why direct I/O was not used and runs the ordinary
+// operation instead, unless the error is not one of the file system.
+func directFallback(filename string, err error, op func() ([]byte, error)) ([]byte, error) {
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"file":  filename,
+		"error": err,
+	}).Debug("Direct I/O is not available; using the page cache")
+	return op()
+}
+
+// alignedBuffer returns n bytes whose address is a multiple of directBlock.
+func alignedBuffer(n int) []byte {
+	b := make([]byte, n+directBlock)
+	off := 0
+	if r := int(uintptr(unsafe.Pointer(&b[0])) % directBlock); r != 0 {
+		off = directBlock - r
+	}
+	return b[off : off+n : off+n]
+}
+
+// roundUp rounds n up to a multiple of directBlock.
+func roundUp(n int) int {
+	return (n + directBlock - 1) / directBlock * directBlock
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// CanDirect reports whether ReadDirect and WriteDirect bypass the page
+// cache on this platform, rather than read and write as usual.
+const CanDirect = true
+
+// openDirect opens a file with O_DIRECT.
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag|syscall.O_DIRECT, perm)
+}
+
+// preallocate reserves the blocks of the first size bytes of f.
+func preallocate(f *os.File, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	return syscall.Fallocate(int(f.Fd()), 0, 0, size)
+}
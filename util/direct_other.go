@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+// CanDirect reports whether ReadDirect and WriteDirect bypass the page
+// cache on this platform, rather than read and write as usual.
+const CanDirect = false
+
+// openDirect fails, so that ReadDirect and WriteDirect fall back to
+// ordinary reads and writes.
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return nil, errors.New("direct I/O is not supported on this platform")
+}
+
+// preallocate does nothing on this platform.
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Local is the file system of the operating system as an fs.FS, for
+// loaders that read through fs.FS. Unlike os.DirFS, it opens names as
+// os.Open does, relative to the working directory or absolute, so that the
+// loaders read the same names from disk as before.
+var Local fs.FS = localFS{}
+
+type localFS struct{}
+
+func (localFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (localFS) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (localFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// Join joins the elements of a name in fsys: with the separator of the
+// operating system for Local, and with slashes, as fs.FS names are, for
+// other file systems.
+func Join(fsys fs.FS, elem ...string) string {
+	if _, ok := fsys.(localFS); ok {
+		return filepath.Join(elem...)
+	}
+	return path.Join(elem...)
+}
+
+// Dir returns the directory of a name in fsys, like Join.
+func Dir(fsys fs.FS, name string) string {
+	if _, ok := fsys.(localFS); ok {
+		return filepath.Dir(name)
+	}
+	return path.Dir(name)
+}
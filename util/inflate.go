@@ -0,0 +1,87 @@
+package util
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// InflatedSize estimates the size of a gzip file once inflated from the
+// ISIZE field that ends it. ISIZE holds the size modulo 4 GiB of the last
+// member only, so the estimate is never less than the compressed size.
+func InflatedSize(filename string) (int64, error) {
+	filename, err := ResolveAnnex(filename)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() < 4 {
+		return fi.Size(), nil
+	}
+	var isize [4]byte
+	if _, err := f.ReadAt(isize[:], fi.Size()-4); err != nil {
+		return 0, err
+	}
+	n := int64(binary.LittleEndian.Uint32(isize[:]))
+	if n < fi.Size() {
+		n = fi.Size()
+	}
+	return n, nil
+}
+
+// InflateToDisk inflates a gzip file into a temporary file in dir (the
+// default directory for temporary files if empty) and returns its content
+// mapped into memory, so that the inflated bytes live in the page cache
+// rather than on the heap. The mapping is private: writes to the bytes are
+// allowed and never reach the file. The temporary file is removed once
+// mapped, and the mapping lasts for the life of the process. Platforms
+// without memory mapping read the temporary file into memory instead.
+func InflateToDisk(filename, dir string) ([]byte, error) {
+	filename, err := ResolveAnnex(filename)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	g, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	defer g.Close()
+
+	tmp, err := ioutil.TempFile(dir, "gonifti-*.nii")
+	if err != nil {
+		return nil, err
+	}
+	untrack := TrackTemp(tmp.Name())
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		untrack()
+	}()
+	n, err := io.Copy(tmp, g)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"file":     filename,
+		"tempfile": tmp.Name(),
+		"bytes":    n,
+	}).Debug("Inflated to disk")
+	return mapFile(tmp, n)
+}
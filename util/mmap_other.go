@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package util
+
+import "os"
+
+// CanMap reports whether files are mapped into memory on this platform,
+// rather than read.
+const CanMap = false
+
+// mapFile reads the first size bytes of f, which cannot be mapped on this
+// platform.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// CanMap reports whether files are mapped into memory on this platform,
+// rather than read.
+const CanMap = true
+
+// mapFile maps the first size bytes of f into memory, copy-on-write.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+}
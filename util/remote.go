@@ -0,0 +1,85 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Opener opens a remote file for reading.
+type Opener func(ctx context.Context, url string) (io.ReadCloser, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = map[string]Opener{"http": openHTTP, "https": openHTTP}
+)
+
+// RegisterScheme makes files with URLs of a scheme, such as "s3", readable
+// with ReadBytes and OpenRemote. http and https are built in, so that
+// signed URLs to object storage can be read without credentials.
+func RegisterScheme(scheme string, open Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	openers[scheme] = open
+}
+
+// Schemes returns the registered URL schemes in lexical order.
+func Schemes() []string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+	schemes := make([]string, 0, len(openers))
+	for s := range openers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// opener returns the opener of the scheme of name, if name is a URL of a
+// registered scheme.
+func opener(name string) (Opener, bool) {
+	i := strings.Index(name, "://")
+	if i <= 0 {
+		return nil, false
+	}
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+	open, ok := openers[strings.ToLower(name[:i])]
+	return open, ok
+}
+
+// IsRemote reports whether name is a URL of a registered scheme rather
+// than a local path.
+func IsRemote(name string) bool {
+	_, ok := opener(name)
+	return ok
+}
+
+// OpenRemote opens a URL of a registered scheme.
+func OpenRemote(ctx context.Context, name string) (io.ReadCloser, error) {
+	open, ok := opener(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a URL of a known scheme", name)
+	}
+	noteRead(name)
+	return open(ctx, name)
+}
+
+// openHTTP opens an HTTP URL, retrying as DefaultRetry says.
+func openHTTP(ctx context.Context, url string) (io.ReadCloser, error) {
+	resp, err := DefaultRetry.Open(ctx, http.DefaultClient, func() (*http.Request, error) {
+		return http.NewRequest("GET", url, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", redact(resp.Request.URL), resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Retry is how requests to remote storage are retried when the network or
+// the server fails: up to Retries more times, waiting Wait before the first
+// retry and twice as long before each next one, up to MaxWait.
+type Retry struct {
+	Retries int
+	Wait    time.Duration
+	MaxWait time.Duration
+}
+
+// DefaultRetry is used by the remote backends unless they are given their
+// own. The command line sets Retries from the config.
+var DefaultRetry = Retry{Retries: 4, Wait: time.Second, MaxWait: 30 * time.Second}
+
+// retryable reports whether a response status is worth retrying: rate
+// limits and server errors.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+}
+
+// wait returns the wait before retry n (from 0), or the server's
+// Retry-After if it gave one in seconds.
+func (r Retry) wait(n int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	d := r.Wait
+	for i := 0; i < n && (r.MaxWait <= 0 || d < r.MaxWait); i++ {
+		d *= 2
+	}
+	if r.MaxWait > 0 && d > r.MaxWait {
+		d = r.MaxWait
+	}
+	return d
+}
+
+// Do sends the request made by newRequest with client, retrying network
+// errors and responses with status 408, 429, or 5xx. Other responses are
+// returned as they are, for the caller to check. Waiting stops when ctx is
+// done. newRequest is called for every attempt, so that bodies are fresh.
+func (r Retry) Do(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for n := 0; ; n++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err == nil && !retryable(resp.StatusCode) {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if n >= r.Retries {
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+
+		d := r.wait(n, resp)
+		fields := log.Fields{"url": redact(req.URL), "attempt": n + 1, "wait": d}
+		if err != nil {
+			fields["error"] = err
+		} else {
+			fields["status"] = resp.Status
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		log.WithFields(fields).Warn("Request failed; retrying")
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Open is like Do, but for a GET whose successful response is read to the
+// end: if the connection breaks while the body is read, the rest is
+// requested with a Range header from where it broke, and reading goes on.
+// The caller checks the status and closes the body.
+func (r Retry) Open(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	resp, err := r.Do(ctx, client, newRequest)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	resp.Body = &resumingBody{
+		ReadCloser: resp.Body,
+		ctx:        ctx,
+		retry:      r,
+		client:     client,
+		newRequest: newRequest,
+		validator:  firstNonEmpty(resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")),
+	}
+	return resp, nil
+}
+
+// redact returns a URL without its password and query, which may hold a
+// signature.
+func redact(u *url.URL) string {
+	r := *u
+	r.RawQuery = ""
+	return r.Redacted()
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// resumingBody is a response body that requests the rest of the content
+// when a read fails.
+type resumingBody struct {
+	io.ReadCloser
+	ctx        context.Context
+	retry      Retry
+	client     *http.Client
+	newRequest func() (*http.Request, error)
+	validator  string // ETag or Last-Modified, to check that the content is unchanged
+	offset     int64
+	resumes    int
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.offset += int64(n)
+	if err == nil || err == io.EOF || b.ctx.Err() != nil || b.resumes >= b.retry.Retries {
+		return n, err
+	}
+	if rerr := b.resume(err); rerr != nil {
+		return n, rerr
+	}
+	return n, nil
+}
+
+// resume replaces the body with a response for the content from offset.
+func (b *resumingBody) resume(cause error) error {
+	b.resumes++
+	log.WithFields(log.Fields{
+		"offset":  b.offset,
+		"attempt": b.resumes,
+		"error":   cause,
+	}).Warn("Download interrupted; resuming")
+	b.ReadCloser.Close()
+
+	resp, err := b.retry.Do(b.ctx, b.client, func() (*http.Request, error) {
+		req, err := b.newRequest()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+		if b.validator != "" {
+			// The server sends everything again if the content changed.
+			req.Header.Set("If-Range", b.validator)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		b.ReadCloser = resp.Body
+		return nil
+	case http.StatusOK:
+		resp.Body.Close()
+		return errors.New("server cannot resume the download, or the content changed")
+	}
+	resp.Body.Close()
+	return fmt.Errorf("resuming download: %s", resp.Status)
+}
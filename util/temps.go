@@ -0,0 +1,45 @@
+package util
+
+import (
+	"os"
+	"sync"
+)
+
+// temps are the temporary files and directories being written, which
+// RemoveTemps removes when the process is interrupted and its deferred
+// removals do not run.
+var temps = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// TrackTemp registers a temporary file or directory for RemoveTemps. The
+// returned function unregisters it, once it has been removed or renamed.
+func TrackTemp(name string) (untrack func()) {
+	temps.Lock()
+	temps.names[name] = true
+	temps.Unlock()
+	return func() { untrackTemp(name) }
+}
+
+// untrackTemp unregisters a temporary file or directory.
+func untrackTemp(name string) {
+	temps.Lock()
+	delete(temps.names, name)
+	temps.Unlock()
+}
+
+// RemoveTemps removes the registered temporary files and directories and
+// returns their names.
+func RemoveTemps() []string {
+	temps.Lock()
+	defer temps.Unlock()
+	var removed []string
+	for name := range temps.names {
+		if os.RemoveAll(name) == nil {
+			removed = append(removed, name)
+		}
+		delete(temps.names, name)
+	}
+	return removed
+}
@@ -1,58 +1,83 @@
 package util
 
 import (
-	"bytes"
 	"compress/gzip"
+	"context"
 	"io/ioutil"
-	"net/http"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // ReadBytes returns the contents of a file as an array of bytes. It accepts
-// files compressed with gzip and uncompressed files.
+// uncompressed files and files compressed with a registered codec, such as
+// gzip. git-annex placeholders are resolved with ResolveAnnex, and URLs of
+// registered schemes are read with OpenRemote.
 func ReadBytes(filename string) ([]byte, error) {
+	if IsRemote(filename) {
+		r, err := OpenRemote(context.Background(), filename)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return DecompressBytes(content)
+	}
+	filename, err := ResolveAnnex(filename)
+	if err != nil {
+		return nil, err
+	}
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// This function usees at most 512 bytes.
-	mime := http.DetectContentType(content[:512])
-
-	log.WithFields(log.Fields{
-		"mimeType": mime,
-	}).Debug("Found mime type")
+	return DecompressBytes(content)
+}
 
-	// TODO(kaczmarj): Decompression seems to be the bottleneck for large files.
-	// Inflate if file is gzipped.
-	if mime == "application/x-gzip" {
-		log.WithFields(log.Fields{
-			"decompression": "gzip",
-		}).Debug("Decompressing ...")
-		// Overwrite array of compressed bytes with array of inflated bytes.
-		content, err = inflateGzip(content)
-		if err != nil {
-			log.Fatal(err)
-		}
+// DecompressBytes decompresses content if it starts with the magic bytes of
+// a registered codec, such as gzip, and returns it unchanged otherwise.
+func DecompressBytes(content []byte) ([]byte, error) {
+	c, ok := DetectCodec(content)
+	if !ok {
+		return content, nil
 	}
+	// TODO(kaczmarj): Decompression seems to be the bottleneck for large files.
+	log.WithFields(log.Fields{
+		"decompression": c.Name,
+	}).Debug("Decompressing ...")
+	return c.Decompress(content)
+}
 
-	return content, nil
+// WriteBytes writes an array of bytes to a file. The bytes are compressed
+// with the registered codec of the suffix of filename, such as gzip for
+// ".gz". The file is replaced atomically, as by WriteFileAtomic.
+func WriteBytes(filename string, b []byte) error {
+	return WriteBytesLevel(filename, b, gzip.DefaultCompression)
 }
 
-// inflateGzip inflates a gzip compressed array of bytes.
-func inflateGzip(b []byte) ([]byte, error) {
-	br := bytes.NewReader(b)
-	g, err := gzip.NewReader(br)
+// WriteBytesLevel is like WriteBytes but compresses with the given level,
+// from gzip.HuffmanOnly to gzip.BestCompression for gzip.
+func WriteBytesLevel(filename string, b []byte, level int) error {
+	b, err := CompressBytes(filename, b, level)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer g.Close()
+	return WriteFileAtomic(filename, b, 0644)
+}
 
-	p, err := ioutil.ReadAll(g)
-	if err != nil {
-		return nil, err
+// CompressBytes compresses content at level with the registered codec of the
+// suffix of filename, and returns it unchanged if there is none.
+func CompressBytes(filename string, content []byte, level int) ([]byte, error) {
+	c, ok := CodecFromName(filename)
+	if !ok {
+		return content, nil
 	}
-
-	return p, nil
+	log.WithFields(log.Fields{
+		"compression": c.Name,
+		"level":       level,
+	}).Debug("Compressing ...")
+	return c.Compress(content, level)
 }
@@ -0,0 +1,228 @@
+// xnat contains a client for the XNAT REST API to list sessions and scans
+// and to read NIfTI resources without downloading them to disk first.
+// https://wiki.xnat.org/display/XAPI/XNAT+REST+API+Directory
+
+package xnat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client talks to one XNAT server. Create it with New and call Login before
+// other requests; without Login, every request is sent with basic auth.
+type Client struct {
+	BaseURL  string // e.g. "https://central.xnat.org"
+	User     string
+	Password string
+	HTTP     *http.Client
+	// Retry is how failed requests are retried, and interrupted downloads
+	// resumed.
+	Retry util.Retry
+
+	session string // JSESSIONID
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL, user, password string) *Client {
+	return &Client{
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		User:     user,
+		Password: password,
+		HTTP:     http.DefaultClient,
+		Retry:    util.DefaultRetry,
+	}
+}
+
+// Session is an imaging session, called an experiment by XNAT.
+type Session struct {
+	ID      string `json:"ID"`
+	Label   string `json:"label"`
+	Project string `json:"project"`
+	Subject string `json:"subject_label"`
+	Date    string `json:"date"`
+}
+
+// Scan is a scan of a session.
+type Scan struct {
+	ID          string `json:"ID"`
+	Type        string `json:"type"`
+	Description string `json:"series_description"`
+	Quality     string `json:"quality"`
+}
+
+// File is a file of a scan resource.
+type File struct {
+	Name   string `json:"Name"`
+	URI    string `json:"URI"`
+	Size   string `json:"Size"`
+	Format string `json:"file_format"`
+}
+
+// resultSet is the JSON envelope of XNAT listings.
+type resultSet struct {
+	ResultSet struct {
+		Result json.RawMessage `json:"Result"`
+	} `json:"ResultSet"`
+}
+
+// Login creates a server session so that later requests do not resend the
+// password.
+func (c *Client) Login(ctx context.Context) error {
+	resp, err := c.Retry.Do(ctx, c.HTTP, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", c.BaseURL+"/data/JSESSION", nil)
+		if err == nil {
+			req.SetBasicAuth(c.User, c.Password)
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	c.session = strings.TrimSpace(string(b))
+
+	log.WithFields(log.Fields{
+		"server": c.BaseURL,
+		"user":   c.User,
+	}).Debug("Logged in to XNAT")
+
+	return nil
+}
+
+// Logout ends the server session.
+func (c *Client) Logout(ctx context.Context) error {
+	if c.session == "" {
+		return nil
+	}
+	resp, err := c.do(ctx, "DELETE", "/data/JSESSION")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	c.session = ""
+	return nil
+}
+
+// do sends an authenticated request for path, which is relative to the
+// server root, retrying failures. The body of a GET resumes where it broke
+// if the connection drops. The caller closes the body.
+func (c *Client) do(ctx context.Context, method, path string) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(method, c.BaseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if c.session != "" {
+			req.AddCookie(&http.Cookie{Name: "JSESSIONID", Value: c.session})
+		} else if c.User != "" {
+			req.SetBasicAuth(c.User, c.Password)
+		}
+		return req, nil
+	}
+	send := c.Retry.Do
+	if method == "GET" {
+		send = c.Retry.Open
+	}
+	resp, err := send(ctx, c.HTTP, newRequest)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
+
+// list fetches a JSON listing and decodes its results into v.
+func (c *Client) list(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.do(ctx, "GET", path+"?format=json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var rs resultSet
+	if err := json.NewDecoder(resp.Body).Decode(&rs); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return json.Unmarshal(rs.ResultSet.Result, v)
+}
+
+// Sessions lists the sessions of a project.
+func (c *Client) Sessions(ctx context.Context, project string) ([]Session, error) {
+	var s []Session
+	err := c.list(ctx, "/data/projects/"+url.PathEscape(project)+"/experiments", &s)
+	return s, err
+}
+
+// Scans lists the scans of a session, given its ID.
+func (c *Client) Scans(ctx context.Context, session string) ([]Scan, error) {
+	var s []Scan
+	err := c.list(ctx, "/data/experiments/"+url.PathEscape(session)+"/scans", &s)
+	return s, err
+}
+
+// Files lists the files of a scan resource, usually "NIFTI".
+func (c *Client) Files(ctx context.Context, session, scan, resource string) ([]File, error) {
+	var f []File
+	err := c.list(ctx, fmt.Sprintf("/data/experiments/%s/scans/%s/resources/%s/files",
+		url.PathEscape(session), url.PathEscape(scan), url.PathEscape(resource)), &f)
+	return f, err
+}
+
+// Fetch opens the content of a file. The caller closes it.
+func (c *Client) Fetch(ctx context.Context, f File) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, "GET", f.URI)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"file":   f.Name,
+		"length": resp.ContentLength,
+	}).Debug("Fetching XNAT file")
+	return resp.Body, nil
+}
+
+// Open streams a NIfTI file into the reader.
+func (c *Client) Open(ctx context.Context, f File, opts ...nifti1.ReadOption) (*nifti1.Image, error) {
+	r, err := c.Fetch(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	img, err := nifti1.Read(r, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name, err)
+	}
+	return img, nil
+}
+
+// IsNifti reports whether a file name has a NIfTI single-file extension.
+func IsNifti(name string) bool {
+	return strings.HasSuffix(name, ".nii") || strings.HasSuffix(name, ".nii.gz")
+}
@@ -0,0 +1,612 @@
+package zarr
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+	"github.com/kaczmarj/gonifti/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// arrayPath is the path of the array in the group.
+const arrayPath = "0"
+
+// Options configure WriteImage.
+type Options struct {
+	Version int    // 2 or 3
+	Chunks  []int  // chunk shape of (z, y, x); each volume is its own chunk along t
+	Codec   string // CodecNone, CodecGzip, CodecZlib, or CodecBlosc
+	Level   int    // compression level of gzip and zlib; -1 for the default
+}
+
+// DefaultChunk is the default chunk length along each spatial axis.
+const DefaultChunk = 64
+
+// axis is an OME-Zarr axis.
+type axis struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// transform is an OME-Zarr coordinate transformation.
+type transform struct {
+	Type        string    `json:"type"`
+	Scale       []float64 `json:"scale,omitempty"`
+	Translation []float64 `json:"translation,omitempty"`
+}
+
+type multiscale struct {
+	Version  string `json:"version,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Axes     []axis `json:"axes"`
+	Datasets []struct {
+		Path                      string      `json:"path"`
+		CoordinateTransformations []transform `json:"coordinateTransformations"`
+	} `json:"datasets"`
+}
+
+// niftiAttrs keep what OME-Zarr cannot express, so that images round-trip.
+type niftiAttrs struct {
+	Affine    [4][4]float64 `json:"affine"`
+	QFormCode int           `json:"qform_code"`
+	SFormCode int           `json:"sform_code"`
+	Descrip   string        `json:"descrip,omitempty"`
+}
+
+// shapeOf returns the Zarr shape of an image: (z, y, x) or (t, z, y, x),
+// where t covers all dims past the third.
+func shapeOf(img *nifti1.Image) []int {
+	shape := []int{img.Nz, img.Ny, img.Nx}
+	if nt := img.NVox / (img.Nx * img.Ny * img.Nz); nt > 1 {
+		shape = append([]int{nt}, shape...)
+	}
+	return shape
+}
+
+// WriteImage writes an image to a Zarr store at dir. Scaled images are
+// written as float32.
+func WriteImage(dir string, img *nifti1.Image, o Options) error {
+	if o.Version != 2 && o.Version != 3 {
+		return fmt.Errorf("%w Zarr version %d", nifti1.ErrUnsupported, o.Version)
+	}
+	if o.Codec == "" {
+		o.Codec = CodecGzip
+	}
+	if o.Level < 0 {
+		o.Level = 6
+	}
+	if o.Version == 3 && o.Codec == CodecZlib {
+		return fmt.Errorf("%w codec %q in Zarr v3", nifti1.ErrUnsupported, o.Codec)
+	}
+
+	if _, _, ok := img.Scaling(); ok {
+		values, err := img.ScaledFloat64s()
+		if err != nil {
+			return err
+		}
+		scaled := *img
+		if err := scaled.SetFloat32Data(values); err != nil {
+			return err
+		}
+		img = &scaled
+	}
+	dt, ok := dtypeOf(img.DataType)
+	if !ok {
+		return fmt.Errorf("%w datatype %d in Zarr", nifti1.ErrUnsupported, img.DataType)
+	}
+	data := img.Data
+	if img.ByteOrder == binary.BigEndian && dt.size > 1 {
+		data = append([]byte(nil), data...)
+		swapBytes(data, dt.size)
+	}
+
+	shape := shapeOf(img)
+	spatial := o.Chunks
+	if spatial == nil {
+		spatial = []int{DefaultChunk, DefaultChunk, DefaultChunk}
+	}
+	if len(spatial) != 3 {
+		return fmt.Errorf("chunk shape must have 3 values (z, y, x), got %d", len(spatial))
+	}
+	chunks := make([]int, len(shape))
+	off := len(shape) - 3
+	for i := 0; i < off; i++ {
+		chunks[i] = 1
+	}
+	for i, c := range spatial {
+		if c < 1 {
+			return fmt.Errorf("invalid chunk shape %v", spatial)
+		}
+		if c > shape[off+i] {
+			c = shape[off+i]
+		}
+		chunks[off+i] = c
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, arrayPath), 0755); err != nil {
+		return err
+	}
+	if err := writeMetadata(dir, img, shape, chunks, dt, o); err != nil {
+		return err
+	}
+
+	grid := make([]int, len(shape))
+	nchunks := 1
+	for i := range shape {
+		grid[i] = (shape[i] + chunks[i] - 1) / chunks[i]
+		nchunks *= grid[i]
+	}
+	chunkLen := dt.size
+	for _, c := range chunks {
+		chunkLen *= c
+	}
+	idx := make([]int, len(shape))
+	origin := make([]int, len(shape))
+	for n := 0; n < nchunks; n++ {
+		raw := make([]byte, chunkLen)
+		keys := make([]string, len(idx))
+		for i := range idx {
+			origin[i] = idx[i] * chunks[i]
+			keys[i] = strconv.Itoa(idx[i])
+		}
+		copyChunk(data, raw, shape, chunks, origin, dt.size, true)
+		enc, err := encodeChunk(raw, o.Codec, o.Level, dt.size)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(append([]string{dir, arrayPath}, keys...)...)
+		if o.Version == 3 {
+			name = filepath.Join(append([]string{dir, arrayPath, "c"}, keys...)...)
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := util.WriteFileAtomic(name, enc, 0644); err != nil {
+			return err
+		}
+		gridIndex(idx, grid)
+	}
+
+	log.WithFields(log.Fields{
+		"store":   dir,
+		"version": o.Version,
+		"shape":   shape,
+		"chunks":  chunks,
+		"codec":   o.Codec,
+	}).Debug("Wrote Zarr store")
+
+	return nil
+}
+
+// writeMetadata writes the group and array metadata.
+func writeMetadata(dir string, img *nifti1.Image, shape, chunks []int, dt dtype, o Options) error {
+	affine := img.Affine()
+	ms := multiscale{Name: strings.TrimSuffix(filepath.Base(dir), filepath.Ext(dir))}
+	scale := []float64{img.Dz, img.Dy, img.Dx}
+	translation := []float64{affine[2][3], affine[1][3], affine[0][3]}
+	ms.Axes = []axis{{"z", "space", "millimeter"}, {"y", "space", "millimeter"}, {"x", "space", "millimeter"}}
+	if len(shape) == 4 {
+		dt := img.Dt
+		if dt <= 0 {
+			dt = 1
+		}
+		scale = append([]float64{dt}, scale...)
+		translation = append([]float64{img.TOffset}, translation...)
+		ms.Axes = append([]axis{{"t", "time", "second"}}, ms.Axes...)
+	}
+	ms.Datasets = make([]struct {
+		Path                      string      `json:"path"`
+		CoordinateTransformations []transform `json:"coordinateTransformations"`
+	}, 1)
+	ms.Datasets[0].Path = arrayPath
+	ms.Datasets[0].CoordinateTransformations = []transform{
+		{Type: "scale", Scale: scale},
+		{Type: "translation", Translation: translation},
+	}
+	nifti := niftiAttrs{Affine: affine, QFormCode: img.QFormCode, SFormCode: img.SFormCode, Descrip: img.Descrip}
+
+	names := make([]string, len(ms.Axes))
+	for i, a := range ms.Axes {
+		names[i] = a.Name
+	}
+
+	if o.Version == 2 {
+		ms.Version = "0.4"
+		var compressor interface{}
+		switch o.Codec {
+		case CodecGzip, CodecZlib:
+			compressor = map[string]interface{}{"id": o.Codec, "level": o.Level}
+		case CodecBlosc:
+			compressor = map[string]interface{}{"id": "blosc", "cname": "lz4", "clevel": 0, "shuffle": 0, "blocksize": 0}
+		}
+		files := map[string]interface{}{
+			".zgroup": map[string]interface{}{"zarr_format": 2},
+			".zattrs": map[string]interface{}{"multiscales": []multiscale{ms}, "nifti": nifti},
+			filepath.Join(arrayPath, ".zarray"): map[string]interface{}{
+				"zarr_format":         2,
+				"shape":               shape,
+				"chunks":              chunks,
+				"dtype":               dt.v2,
+				"compressor":          compressor,
+				"fill_value":          0,
+				"order":               "C",
+				"filters":             nil,
+				"dimension_separator": "/",
+			},
+			filepath.Join(arrayPath, ".zattrs"): map[string]interface{}{"_ARRAY_DIMENSIONS": names},
+		}
+		return writeJSONFiles(dir, files)
+	}
+
+	codecs := []interface{}{
+		map[string]interface{}{"name": "bytes", "configuration": map[string]interface{}{"endian": "little"}},
+	}
+	switch o.Codec {
+	case CodecGzip:
+		codecs = append(codecs, map[string]interface{}{"name": "gzip", "configuration": map[string]interface{}{"level": o.Level}})
+	case CodecBlosc:
+		codecs = append(codecs, map[string]interface{}{"name": "blosc", "configuration": map[string]interface{}{
+			"cname": "lz4", "clevel": 0, "shuffle": "noshuffle", "typesize": dt.size, "blocksize": 0,
+		}})
+	}
+	files := map[string]interface{}{
+		"zarr.json": map[string]interface{}{
+			"zarr_format": 3,
+			"node_type":   "group",
+			"attributes": map[string]interface{}{
+				"ome":   map[string]interface{}{"version": "0.5", "multiscales": []multiscale{ms}},
+				"nifti": nifti,
+			},
+		},
+		filepath.Join(arrayPath, "zarr.json"): map[string]interface{}{
+			"zarr_format": 3,
+			"node_type":   "array",
+			"shape":       shape,
+			"data_type":   dt.v3,
+			"chunk_grid": map[string]interface{}{
+				"name": "regular", "configuration": map[string]interface{}{"chunk_shape": chunks},
+			},
+			"chunk_key_encoding": map[string]interface{}{
+				"name": "default", "configuration": map[string]interface{}{"separator": "/"},
+			},
+			"fill_value":      0,
+			"codecs":          codecs,
+			"dimension_names": names,
+		},
+	}
+	return writeJSONFiles(dir, files)
+}
+
+func writeJSONFiles(dir string, files map[string]interface{}) error {
+	for name, v := range files {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := util.WriteFileAtomic(filepath.Join(dir, name), append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arrayMeta is the array metadata of either version.
+type arrayMeta struct {
+	// v2
+	ZarrFormat int             `json:"zarr_format"`
+	Shape      []int           `json:"shape"`
+	Chunks     []int           `json:"chunks"`
+	DType      string          `json:"dtype"`
+	Compressor json.RawMessage `json:"compressor"`
+	FillValue  interface{}     `json:"fill_value"`
+	Order      string          `json:"order"`
+	Filters    json.RawMessage `json:"filters"`
+	Separator  string          `json:"dimension_separator"`
+
+	// v3
+	NodeType  string `json:"node_type"`
+	DataType  string `json:"data_type"`
+	ChunkGrid struct {
+		Configuration struct {
+			ChunkShape []int `json:"chunk_shape"`
+		} `json:"configuration"`
+	} `json:"chunk_grid"`
+	ChunkKeyEncoding struct {
+		Name          string `json:"name"`
+		Configuration struct {
+			Separator string `json:"separator"`
+		} `json:"configuration"`
+	} `json:"chunk_key_encoding"`
+	Codecs []struct {
+		Name          string                 `json:"name"`
+		Configuration map[string]interface{} `json:"configuration"`
+	} `json:"codecs"`
+}
+
+func readJSON(fsys fs.FS, name string, v interface{}) error {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// ReadImage reads a Zarr v2 or v3 store written by WriteImage or another
+// OME-Zarr writer. dir may be the group or the array. The last three axes
+// are z, y, and x; the axes before them are combined into t. The geometry is
+// taken from the "nifti" attributes if present and from the OME-Zarr scale
+// and translation otherwise.
+func ReadImage(dir string) (*nifti1.Image, error) {
+	return ReadImageFS(util.Local, dir)
+}
+
+// ReadImageFS is ReadImage for a store in fsys.
+func ReadImageFS(fsys fs.FS, dir string) (*nifti1.Image, error) {
+	join := func(elem ...string) string { return util.Join(fsys, elem...) }
+	group, array := dir, join(dir, arrayPath)
+	if exists(fsys, join(dir, ".zarray")) || isV3Array(fsys, dir) {
+		group, array = util.Dir(fsys, dir), dir
+	}
+
+	var meta arrayMeta
+	version := 2
+	if exists(fsys, join(array, "zarr.json")) {
+		version = 3
+		if err := readJSON(fsys, join(array, "zarr.json"), &meta); err != nil {
+			return nil, err
+		}
+	} else if err := readJSON(fsys, join(array, ".zarray"), &meta); err != nil {
+		return nil, err
+	}
+
+	var dt dtype
+	var bigEndian bool
+	var err error
+	codec := CodecNone
+	sep := "."
+	prefix := array
+	chunks := meta.Chunks
+	if version == 2 {
+		if meta.Order != "" && meta.Order != "C" {
+			return nil, fmt.Errorf("%w Fortran order", nifti1.ErrUnsupported)
+		}
+		if len(meta.Filters) > 0 && string(meta.Filters) != "null" {
+			return nil, fmt.Errorf("%w Zarr filters", nifti1.ErrUnsupported)
+		}
+		if dt, bigEndian, err = dtypeByName(meta.DType); err != nil {
+			return nil, err
+		}
+		if len(meta.Compressor) > 0 && string(meta.Compressor) != "null" {
+			var c struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(meta.Compressor, &c); err != nil {
+				return nil, err
+			}
+			codec = c.ID
+		}
+		if meta.Separator != "" {
+			sep = meta.Separator
+		}
+	} else {
+		if dt, _, err = dtypeByName(meta.DataType); err != nil {
+			return nil, err
+		}
+		chunks = meta.ChunkGrid.Configuration.ChunkShape
+		sep = "/"
+		if meta.ChunkKeyEncoding.Configuration.Separator != "" {
+			sep = meta.ChunkKeyEncoding.Configuration.Separator
+		}
+		if meta.ChunkKeyEncoding.Name != "v2" {
+			prefix = join(array, "c")
+		}
+		for _, c := range meta.Codecs {
+			switch c.Name {
+			case "bytes":
+				bigEndian = c.Configuration["endian"] == "big"
+			case "gzip", "blosc":
+				codec = c.Name
+			default:
+				return nil, fmt.Errorf("%w Zarr codec %q", nifti1.ErrUnsupported, c.Name)
+			}
+		}
+	}
+
+	shape := meta.Shape
+	if len(shape) < 2 || len(chunks) != len(shape) {
+		return nil, fmt.Errorf("%s: unsupported shape %v with chunks %v", array, shape, chunks)
+	}
+
+	n := dt.size
+	for _, s := range shape {
+		n *= s
+	}
+	data := make([]byte, n)
+	if f, ok := meta.FillValue.(float64); ok && f != 0 {
+		fillArray(data, dt, f)
+	}
+
+	grid := make([]int, len(shape))
+	nchunks := 1
+	for i := range shape {
+		grid[i] = (shape[i] + chunks[i] - 1) / chunks[i]
+		nchunks *= grid[i]
+	}
+	chunkLen := dt.size
+	for _, c := range chunks {
+		chunkLen *= c
+	}
+	idx := make([]int, len(shape))
+	origin := make([]int, len(shape))
+	for c := 0; c < nchunks; c++ {
+		keys := make([]string, len(idx))
+		for i := range idx {
+			origin[i] = idx[i] * chunks[i]
+			keys[i] = strconv.Itoa(idx[i])
+		}
+		gridIndex(idx, grid)
+
+		name := join(prefix, strings.Join(keys, sep))
+		b, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // fill value
+		} else if err != nil {
+			return nil, err
+		}
+		raw, err := decodeChunk(b, codec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(raw) != chunkLen {
+			return nil, fmt.Errorf("%s: chunk has %d bytes, expected %d", name, len(raw), chunkLen)
+		}
+		copyChunk(data, raw, shape, chunks, origin, dt.size, false)
+	}
+	if bigEndian {
+		swapBytes(data, dt.size)
+	}
+
+	// Image dims (x, y, z, t).
+	rank := len(shape)
+	nx, ny := shape[rank-1], shape[rank-2]
+	nz, nt := 1, 1
+	if rank >= 3 {
+		nz = shape[rank-3]
+	}
+	for _, s := range shape[:rank-3+boolInt(rank < 3)] {
+		nt *= s
+	}
+	dims := []int{nx, ny, nz}
+	if nt > 1 {
+		dims = append(dims, nt)
+	}
+
+	affine, xform, dt4 := readGeometry(fsys, group, version, rank)
+	img, err := nifti1.NewImage(dt.code, dims, affine, xform)
+	if err != nil {
+		return nil, err
+	}
+	img.Data = data
+	if nt > 1 {
+		img.Dt, img.PixDim[4] = dt4, dt4
+	}
+	return img, nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// readGeometry returns the affine, the xform code, and the time step from
+// the group attributes. Without attributes the affine is the identity.
+func readGeometry(fsys fs.FS, group string, version, rank int) ([4][4]float64, int, float64) {
+	affine := [4][4]float64{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+	var attrs struct {
+		Multiscales []multiscale `json:"multiscales"`
+		Nifti       *niftiAttrs  `json:"nifti"`
+		Attributes  struct {
+			Ome struct {
+				Multiscales []multiscale `json:"multiscales"`
+			} `json:"ome"`
+			Nifti *niftiAttrs `json:"nifti"`
+		} `json:"attributes"`
+	}
+	name := util.Join(fsys, group, ".zattrs")
+	if version == 3 {
+		name = util.Join(fsys, group, "zarr.json")
+	}
+	if err := readJSON(fsys, name, &attrs); err != nil {
+		return affine, nifti1.XformUnknown, 1
+	}
+	ms, nifti := attrs.Multiscales, attrs.Nifti
+	if version == 3 {
+		ms, nifti = attrs.Attributes.Ome.Multiscales, attrs.Attributes.Nifti
+	}
+
+	dt := 1.0
+	var scale, translation []float64
+	if len(ms) > 0 && len(ms[0].Datasets) > 0 {
+		for _, t := range ms[0].Datasets[0].CoordinateTransformations {
+			switch t.Type {
+			case "scale":
+				scale = t.Scale
+			case "translation":
+				translation = t.Translation
+			}
+		}
+	}
+	if len(scale) == rank && rank > 3 {
+		dt = scale[0]
+	}
+
+	if nifti != nil {
+		xform := nifti.SFormCode
+		if xform <= 0 {
+			xform = nifti.QFormCode
+		}
+		return nifti.Affine, xform, dt
+	}
+	if len(scale) == rank && rank >= 3 {
+		for i := 0; i < 3; i++ {
+			affine[i][i] = scale[rank-1-i]
+		}
+		if len(translation) == rank {
+			for i := 0; i < 3; i++ {
+				affine[i][3] = translation[rank-1-i]
+			}
+		}
+		return affine, nifti1.XformScannerAnat, dt
+	}
+	return affine, nifti1.XformUnknown, dt
+}
+
+// fillArray sets every element to a fill value.
+func fillArray(b []byte, dt dtype, f float64) {
+	elem := make([]byte, dt.size)
+	switch dt.v3 {
+	case "float32":
+		putLE(elem, uint64(math.Float32bits(float32(f))))
+	case "float64":
+		putLE(elem, math.Float64bits(f))
+	default:
+		putLE(elem, uint64(int64(f)))
+	}
+	for i := 0; i < len(b); i += dt.size {
+		copy(b[i:], elem)
+	}
+}
+
+func putLE(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v >> (8 * uint(i)))
+	}
+}
+
+func exists(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
+
+// isV3Array reports whether dir holds v3 array metadata.
+func isV3Array(fsys fs.FS, dir string) bool {
+	var m struct {
+		NodeType string `json:"node_type"`
+	}
+	return readJSON(fsys, util.Join(fsys, dir, "zarr.json"), &m) == nil && m.NodeType == "array"
+}
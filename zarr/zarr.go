@@ -0,0 +1,223 @@
+// zarr contains conversion between images and Zarr v2 and v3 stores on disk,
+// laid out like OME-Zarr: a group with multiscales metadata holding one
+// array "0" with axes (t,) z, y, x.
+// https://zarr-specs.readthedocs.io
+// https://ngff.openmicroscopy.org
+
+package zarr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/kaczmarj/gonifti/nifti1"
+)
+
+// dtype maps a NIfTI datatype to its Zarr v2 and v3 names.
+type dtype struct {
+	code int
+	v2   string
+	v3   string
+	size int
+}
+
+var dtypes = []dtype{
+	{nifti1.DTUint8, "|u1", "uint8", 1},
+	{nifti1.DTInt8, "|i1", "int8", 1},
+	{nifti1.DTInt16, "<i2", "int16", 2},
+	{nifti1.DTUint16, "<u2", "uint16", 2},
+	{nifti1.DTInt32, "<i4", "int32", 4},
+	{nifti1.DTUint32, "<u4", "uint32", 4},
+	{nifti1.DTInt64, "<i8", "int64", 8},
+	{nifti1.DTUint64, "<u8", "uint64", 8},
+	{nifti1.DTFloat32, "<f4", "float32", 4},
+	{nifti1.DTFloat64, "<f8", "float64", 8},
+}
+
+func dtypeOf(code int) (dtype, bool) {
+	for _, d := range dtypes {
+		if d.code == code {
+			return d, true
+		}
+	}
+	return dtype{}, false
+}
+
+// dtypeByName looks up a v2 ("<f4", ">i2") or v3 ("float32") name. It
+// reports whether the data are big endian.
+func dtypeByName(name string) (dtype, bool, error) {
+	for _, d := range dtypes {
+		if name == d.v3 || name == d.v2 {
+			return d, false, nil
+		}
+		if d.size > 1 && name == ">"+d.v2[1:] {
+			return d, true, nil
+		}
+		if d.size == 1 && (name == "<"+d.v2[1:] || name == ">"+d.v2[1:]) {
+			return d, false, nil
+		}
+	}
+	return dtype{}, false, fmt.Errorf("%w Zarr data type %q", nifti1.ErrUnsupported, name)
+}
+
+// Codecs.
+const (
+	CodecNone  = "none"
+	CodecGzip  = "gzip"
+	CodecZlib  = "zlib" // Zarr v2 only
+	CodecBlosc = "blosc"
+)
+
+// bloscHeaderSize is the size of a Blosc 1 frame header.
+const bloscHeaderSize = 16
+
+// Blosc 1 frame flags.
+const (
+	bloscShuffle    = 0x01
+	bloscMemcpyed   = 0x02
+	bloscBitShuffle = 0x04
+)
+
+// encodeChunk compresses a chunk. Blosc frames are written without
+// compression (memcpyed), which every Blosc decoder accepts, since the Blosc
+// compressors are not in the standard library.
+func encodeChunk(b []byte, codec string, level, typesize int) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return b, nil
+	case CodecGzip, CodecZlib:
+		var buf bytes.Buffer
+		var err error
+		if codec == CodecGzip {
+			var w *gzip.Writer
+			if w, err = gzip.NewWriterLevel(&buf, level); err == nil {
+				w.Write(b)
+				err = w.Close()
+			}
+		} else {
+			var w *zlib.Writer
+			if w, err = zlib.NewWriterLevel(&buf, level); err == nil {
+				w.Write(b)
+				err = w.Close()
+			}
+		}
+		return buf.Bytes(), err
+	case CodecBlosc:
+		h := make([]byte, bloscHeaderSize, bloscHeaderSize+len(b))
+		h[0], h[1], h[2], h[3] = 2, 1, bloscMemcpyed, byte(typesize)
+		binary.LittleEndian.PutUint32(h[4:], uint32(len(b)))
+		binary.LittleEndian.PutUint32(h[8:], uint32(len(b)))
+		binary.LittleEndian.PutUint32(h[12:], uint32(len(b)+bloscHeaderSize))
+		return append(h, b...), nil
+	}
+	return nil, fmt.Errorf("%w codec %q", nifti1.ErrUnsupported, codec)
+}
+
+// decodeChunk decompresses a chunk. Only uncompressed Blosc frames can be
+// read.
+func decodeChunk(b []byte, codec string) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return b, nil
+	case CodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case CodecZlib:
+		r, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case CodecBlosc:
+		if len(b) < bloscHeaderSize {
+			return nil, fmt.Errorf("blosc frame is too short")
+		}
+		flags := b[2]
+		if flags&bloscMemcpyed == 0 {
+			return nil, fmt.Errorf("%w compressed blosc frames (only uncompressed frames can be read)", nifti1.ErrUnsupported)
+		}
+		if flags&(bloscShuffle|bloscBitShuffle) != 0 {
+			return nil, fmt.Errorf("%w shuffled blosc frames", nifti1.ErrUnsupported)
+		}
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		if len(b) < bloscHeaderSize+n {
+			return nil, fmt.Errorf("blosc frame is truncated")
+		}
+		return b[bloscHeaderSize : bloscHeaderSize+n], nil
+	}
+	return nil, fmt.Errorf("%w codec %q", nifti1.ErrUnsupported, codec)
+}
+
+// swapBytes reverses the bytes of each element in place.
+func swapBytes(b []byte, size int) {
+	for i := 0; i+size <= len(b); i += size {
+		for j, k := i, i+size-1; j < k; j, k = j+1, k-1 {
+			b[j], b[k] = b[k], b[j]
+		}
+	}
+}
+
+// gridIndex advances idx to the next chunk of the grid in C order.
+func gridIndex(idx, grid []int) {
+	for i := len(idx) - 1; i >= 0; i-- {
+		if idx[i]++; idx[i] < grid[i] {
+			return
+		}
+		idx[i] = 0
+	}
+}
+
+// copyChunk copies between a C-order array of the given shape and a chunk
+// whose first element is at origin. With toChunk, the array is copied into
+// the chunk; otherwise the chunk is copied into the array. Parts of edge
+// chunks outside the array are skipped.
+func copyChunk(array, chunk []byte, shape, chunks, origin []int, size int, toChunk bool) {
+	rank := len(shape)
+	strides := make([]int, rank)
+	strides[rank-1] = 1
+	for i := rank - 2; i >= 0; i-- {
+		strides[i] = strides[i+1] * shape[i+1]
+	}
+	// Copy rows along the last axis.
+	row := chunks[rank-1]
+	if rem := shape[rank-1] - origin[rank-1]; rem < row {
+		row = rem
+	}
+	nrows := len(chunk) / size / chunks[rank-1]
+	el := make([]int, rank)
+	for r := 0; r < nrows; r++ {
+		src, inside := 0, true
+		for i := 0; i < rank; i++ {
+			x := origin[i] + el[i]
+			if x >= shape[i] {
+				inside = false
+				break
+			}
+			src += x * strides[i]
+		}
+		if inside {
+			a := array[src*size : (src+row)*size]
+			c := chunk[r*chunks[rank-1]*size : (r*chunks[rank-1]+row)*size]
+			if toChunk {
+				copy(c, a)
+			} else {
+				copy(a, c)
+			}
+		}
+		for i := rank - 2; i >= 0; i-- {
+			if el[i]++; el[i] < chunks[i] {
+				break
+			}
+			el[i] = 0
+		}
+	}
+}